@@ -0,0 +1,157 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deciding which datasets to keep on fast storage, cache or archive needed the audit log of the
+// namenode, which tells the opens but not how much was read. With -heatmapFile, the mount
+// accounts the reads of every HDFS directory since it was mounted: the number of times its files
+// were opened and read, the bytes read, the number of distinct files read and the time of the
+// last read, and writes them every -heatmapInterval, and when unmounting, to that file, as CSV
+// if its name ends with .csv and as JSON otherwise, the most read directories first. The file is
+// replaced atomically, so that it can be collected at any time, e.g., by a cron job of the data
+// platform team. Only the files read through this mount are accounted, and the names of the
+// distinct files are kept in memory until the mount is unmounted, up to MaxFiles names, after
+// which the files of a directory only grow by the files already known. With -heatmapInterval 0
+// the file is only written when unmounting
+var heatmapFile string
+var heatmapInterval time.Duration
+
+// Default of AccessHeatmap.MaxFiles
+const defaultHeatmapMaxFiles = 1000000
+
+// Reads of the files of a directory, as written to -heatmapFile
+type DirAccess struct {
+	Dir        string    `json:"dir"`
+	Reads      int64     `json:"reads"` // file handles which read
+	Bytes      int64     `json:"bytes"`
+	Files      int       `json:"files"` // distinct files read
+	LastAccess time.Time `json:"last_access"`
+}
+
+// Accounts the reads of each directory and writes them to -heatmapFile, see above
+// Concurrency: thread safe
+type AccessHeatmap struct {
+	Path     string
+	Interval time.Duration
+	Clock    Clock
+	MaxFiles int // names of distinct files kept for all the directories, unbounded if 0
+	done     chan struct{}
+
+	mutex sync.Mutex
+	dirs  map[string]*dirAccess
+	files int // names kept in the files of the dirs
+}
+
+type dirAccess struct {
+	DirAccess
+	files map[string]bool
+}
+
+// Creates the heatmap written to the file
+func NewAccessHeatmap(file string, interval time.Duration, clock Clock) *AccessHeatmap {
+	return &AccessHeatmap{Path: file, Interval: interval, Clock: clock, MaxFiles: defaultHeatmapMaxFiles,
+		done: make(chan struct{}), dirs: map[string]*dirAccess{}}
+}
+
+// Accounts a file handle which read the bytes of the file
+func (heatmap *AccessHeatmap) Record(file string, bytes int64) {
+	dir := path.Dir(file)
+	heatmap.mutex.Lock()
+	defer heatmap.mutex.Unlock()
+	access := heatmap.dirs[dir]
+	if access == nil {
+		access = &dirAccess{DirAccess: DirAccess{Dir: dir}, files: map[string]bool{}}
+		heatmap.dirs[dir] = access
+	}
+	access.Reads++
+	access.Bytes += bytes
+	if name := path.Base(file); !access.files[name] && (heatmap.MaxFiles <= 0 || heatmap.files < heatmap.MaxFiles) {
+		access.files[name] = true
+		access.Files = len(access.files)
+		heatmap.files++
+	}
+	access.LastAccess = heatmap.Clock.Now()
+}
+
+// Returns the reads of the directories, the most bytes read first
+func (heatmap *AccessHeatmap) Report() []DirAccess {
+	heatmap.mutex.Lock()
+	report := make([]DirAccess, 0, len(heatmap.dirs))
+	for _, access := range heatmap.dirs {
+		report = append(report, access.DirAccess)
+	}
+	heatmap.mutex.Unlock()
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Bytes != report[j].Bytes {
+			return report[i].Bytes > report[j].Bytes
+		}
+		return report[i].Dir < report[j].Dir
+	})
+	return report
+}
+
+// Formats the report as CSV, with a header line
+func heatmapCSV(report []DirAccess) []byte {
+	var buffer bytes.Buffer
+	w := csv.NewWriter(&buffer)
+	w.Write([]string{"dir", "reads", "bytes", "files", "last_access"})
+	for _, access := range report {
+		w.Write([]string{access.Dir, strconv.FormatInt(access.Reads, 10), strconv.FormatInt(access.Bytes, 10),
+			strconv.Itoa(access.Files), access.LastAccess.UTC().Format(time.RFC3339)})
+	}
+	w.Flush()
+	return buffer.Bytes()
+}
+
+// Replaces -heatmapFile with the current report
+func (heatmap *AccessHeatmap) Write() error {
+	report := heatmap.Report()
+	var data []byte
+	if strings.HasSuffix(heatmap.Path, ".csv") {
+		data = heatmapCSV(report)
+	} else {
+		data, _ = json.MarshalIndent(report, "", "  ")
+	}
+	tmp := heatmap.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, heatmap.Path)
+}
+
+// Writes the report every interval until closed. Returns at once if the interval is 0
+func (heatmap *AccessHeatmap) Run() {
+	if heatmap.Interval <= 0 {
+		return
+	}
+	for {
+		select {
+		case <-heatmap.done:
+			return
+		case <-heatmap.Clock.After(heatmap.Interval):
+		}
+		if err := heatmap.Write(); err != nil {
+			logwarn("Failed to write the access heatmap", Fields{Operation: HeatmapOp, Path: heatmap.Path, Error: err})
+		}
+	}
+}
+
+// Stops writing the report, and writes the final one
+func (heatmap *AccessHeatmap) Close() error {
+	close(heatmap.done)
+	return heatmap.Write()
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the reads are accounted per directory and written as CSV or JSON, the most read first
+func TestAccessHeatmap(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hopsfs-heatmap")
+	defer os.RemoveAll(dir)
+	clock := &MockClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	heatmap := NewAccessHeatmap(filepath.Join(dir, "heatmap.csv"), time.Minute, clock)
+	heatmap.Record("/data/cold/a", 10)
+	heatmap.Record("/data/hot/a", 100)
+	clock.NotifyTimeElapsed(time.Minute)
+	heatmap.Record("/data/hot/b", 100)
+	heatmap.Record("/data/hot/a", 100)
+
+	report := heatmap.Report()
+	assert.Equal(t, 2, len(report))
+	assert.Equal(t, DirAccess{Dir: "/data/hot", Reads: 3, Bytes: 300, Files: 2, LastAccess: clock.Now()}, report[0])
+	assert.Equal(t, "/data/cold", report[1].Dir)
+
+	assert.Nil(t, heatmap.Write())
+	data, _ := ioutil.ReadFile(heatmap.Path)
+	assert.Equal(t, "dir,reads,bytes,files,last_access\n"+
+		"/data/hot,3,300,2,2020-01-01T12:01:00Z\n"+
+		"/data/cold,1,10,1,2020-01-01T12:00:00Z\n", string(data))
+
+	heatmap.Path = filepath.Join(dir, "heatmap.json")
+	assert.Nil(t, heatmap.Close())
+	data, _ = ioutil.ReadFile(heatmap.Path)
+	var written []DirAccess
+	assert.Nil(t, json.Unmarshal(data, &written))
+	assert.Equal(t, 2, len(written))
+	assert.Equal(t, int64(300), written[0].Bytes)
+}
+
+// Testing that the names of the files kept are bounded by MaxFiles, and that an interval of 0 disables the writes
+func TestAccessHeatmapBounds(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hopsfs-heatmap")
+	defer os.RemoveAll(dir)
+	clock := &MockClock{}
+	heatmap := NewAccessHeatmap(filepath.Join(dir, "heatmap.csv"), 0, clock)
+	heatmap.MaxFiles = 2
+	heatmap.Record("/data/a", 1)
+	heatmap.Record("/data/b", 1)
+	heatmap.Record("/data/c", 1)
+	heatmap.Record("/other/a", 1)
+	heatmap.Record("/data/a", 1)
+	report := heatmap.Report()
+	assert.Equal(t, DirAccess{Dir: "/data", Reads: 4, Bytes: 4, Files: 2, LastAccess: clock.Now()}, report[0])
+	assert.Equal(t, DirAccess{Dir: "/other", Reads: 1, Bytes: 1, Files: 0, LastAccess: clock.Now()}, report[1])
+
+	done := make(chan struct{})
+	go func() {
+		heatmap.Run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return with an interval of 0")
+	}
+	_, err := os.Stat(heatmap.Path)
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, heatmap.Close())
+	_, err = os.Stat(heatmap.Path)
+	assert.Nil(t, err)
+}
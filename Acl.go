@@ -0,0 +1,388 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// The HDFS ACLs of files and directories are exposed the way Linux file systems expose POSIX
+// ACLs, as the system.posix_acl_access and system.posix_acl_default extended attributes, so that
+// getfacl and setfacl work on the mount. The ACLs are enforced by the namenode, not the kernel.
+// A file or directory created through the mount inherits the default ACL of its directory. The
+// HDFS client has no ACL RPCs, the ACLs are read and set over WebHDFS, see -webhdfsURL
+const (
+	aclAccessXAttr  = "system.posix_acl_access"
+	aclDefaultXAttr = "system.posix_acl_default"
+)
+
+// Tags of the entries of a POSIX ACL, as in the extended attributes, see linux/posix_acl_xattr.h
+const (
+	aclUserObj  uint16 = 0x01
+	aclUser     uint16 = 0x02
+	aclGroupObj uint16 = 0x04
+	aclGroup    uint16 = 0x08
+	aclMask     uint16 = 0x10
+	aclOther    uint16 = 0x20
+)
+
+const aclXAttrVersion = 2
+const aclUndefinedID = 0xffffffff
+
+// Entry of an HDFS ACL
+type AclEntry struct {
+	Tag     uint16 // one of the POSIX tags
+	Name    string // user or group of a named entry
+	Perm    uint16 // rwx bits
+	Default bool   // entry of the default ACL of a directory
+}
+
+// Names of the tags in the ACL specs of WebHDFS, e.g., "default:user:carla:rw-"
+var aclTagNames = map[uint16]string{aclUserObj: "user", aclUser: "user", aclGroupObj: "group", aclGroup: "group", aclMask: "mask", aclOther: "other"}
+
+// Formats the entries as the aclspec parameter of the WebHDFS SETACL operation
+func formatAclSpec(entries []AclEntry) string {
+	specs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		perm := []byte("---")
+		for i, c := range "rwx" {
+			if e.Perm&(4>>uint(i)) != 0 {
+				perm[i] = byte(c)
+			}
+		}
+		spec := aclTagNames[e.Tag] + ":" + e.Name + ":" + string(perm)
+		if e.Default {
+			spec = "default:" + spec
+		}
+		specs = append(specs, spec)
+	}
+	return strings.Join(specs, ",")
+}
+
+// Parses an entry of the AclStatus returned by WebHDFS, e.g., "group::r-x"
+func parseAclSpec(spec string) (AclEntry, error) {
+	var e AclEntry
+	if strings.HasPrefix(spec, "default:") {
+		e.Default = true
+		spec = strings.TrimPrefix(spec, "default:")
+	}
+	fields := strings.Split(spec, ":")
+	if len(fields) != 3 || len(fields[2]) != 3 {
+		return e, syscall.EINVAL
+	}
+	e.Name = fields[1]
+	switch fields[0] {
+	case "user":
+		e.Tag = aclUserObj
+		if e.Name != "" {
+			e.Tag = aclUser
+		}
+	case "group":
+		e.Tag = aclGroupObj
+		if e.Name != "" {
+			e.Tag = aclGroup
+		}
+	case "mask":
+		e.Tag = aclMask
+	case "other":
+		e.Tag = aclOther
+	default:
+		return e, syscall.EINVAL
+	}
+	for i, c := range "rwx" {
+		switch fields[2][i] {
+		case byte(c):
+			e.Perm |= 4 >> uint(i)
+		case '-':
+		default:
+			return e, syscall.EINVAL
+		}
+	}
+	return e, nil
+}
+
+// Returns true if the name is one of the ACL attributes
+func isAclXAttr(name string) bool {
+	return name == aclAccessXAttr || name == aclDefaultXAttr
+}
+
+// Returns the entries of the access or of the default ACL
+func aclScope(entries []AclEntry, defaults bool) []AclEntry {
+	var scope []AclEntry
+	for _, e := range entries {
+		if e.Default == defaults {
+			scope = append(scope, e)
+		}
+	}
+	return scope
+}
+
+// Returns the complete access ACL of a file with the mode and the extended entries returned by
+// the namenode, which keeps the owner and other entries, and the mask if any, in the mode
+func accessAcl(mode os.FileMode, extended []AclEntry) []AclEntry {
+	perm := uint16(mode.Perm())
+	entries := []AclEntry{{Tag: aclUserObj, Perm: perm >> 6 & 7}, {Tag: aclOther, Perm: perm & 7}}
+	if len(extended) == 0 {
+		return append(entries, AclEntry{Tag: aclGroupObj, Perm: perm >> 3 & 7})
+	}
+	entries = append(entries, extended...)
+	return append(entries, AclEntry{Tag: aclMask, Perm: perm >> 3 & 7})
+}
+
+// Returns the ACL of a new file or directory with the mode in a directory with the default ACL
+func inheritedAcl(defaults []AclEntry, mode os.FileMode) []AclEntry {
+	perm := uint16(mode.Perm())
+	masked := aclGroupObj
+	for _, e := range defaults {
+		if e.Tag == aclMask {
+			masked = aclMask
+		}
+	}
+	var entries []AclEntry
+	for _, e := range defaults {
+		access := e
+		access.Default = false
+		switch e.Tag {
+		case aclUserObj:
+			access.Perm &= perm >> 6 & 7
+		case masked:
+			access.Perm &= perm >> 3 & 7
+		case aclOther:
+			access.Perm &= perm & 7
+		}
+		entries = append(entries, access)
+	}
+	if mode.IsDir() {
+		entries = append(entries, defaults...)
+	}
+	return entries
+}
+
+// Encodes the entries in the format of the POSIX ACL attributes, sorted as the kernel does
+func encodeAcl(entries []AclEntry) []byte {
+	type xattrEntry struct {
+		tag, perm uint16
+		id        uint32
+	}
+	encoded := make([]xattrEntry, 0, len(entries))
+	for _, e := range entries {
+		id := uint32(aclUndefinedID)
+		switch e.Tag {
+		case aclUser:
+			id = localUid(e.Name)
+		case aclGroup:
+			id = localGid(e.Name)
+		}
+		encoded = append(encoded, xattrEntry{tag: e.Tag, perm: e.Perm, id: id})
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		if encoded[i].tag != encoded[j].tag {
+			return encoded[i].tag < encoded[j].tag
+		}
+		return encoded[i].id < encoded[j].id
+	})
+	b := make([]byte, 4+8*len(encoded))
+	binary.LittleEndian.PutUint32(b, aclXAttrVersion)
+	for i, e := range encoded {
+		binary.LittleEndian.PutUint16(b[4+8*i:], e.tag)
+		binary.LittleEndian.PutUint16(b[6+8*i:], e.perm)
+		binary.LittleEndian.PutUint32(b[8+8*i:], e.id)
+	}
+	return b
+}
+
+// Decodes a POSIX ACL attribute, failing with EINVAL if it is malformed or names unknown users or groups
+func decodeAcl(b []byte, defaults bool) ([]AclEntry, error) {
+	if len(b) < 4 || (len(b)-4)%8 != 0 || binary.LittleEndian.Uint32(b) != aclXAttrVersion {
+		return nil, syscall.EINVAL
+	}
+	var entries []AclEntry
+	seen := make(map[uint16]bool)
+	for i := 4; i < len(b); i += 8 {
+		e := AclEntry{Tag: binary.LittleEndian.Uint16(b[i:]), Perm: binary.LittleEndian.Uint16(b[i+2:]), Default: defaults}
+		id := binary.LittleEndian.Uint32(b[i+4:])
+		if e.Perm&^7 != 0 {
+			return nil, syscall.EINVAL
+		}
+		switch e.Tag {
+		case aclUser:
+			e.Name = idMapper.UserName(id)
+		case aclGroup:
+			e.Name = idMapper.GroupName(id)
+		case aclUserObj, aclGroupObj, aclMask, aclOther:
+			if seen[e.Tag] {
+				return nil, syscall.EINVAL
+			}
+		default:
+			return nil, syscall.EINVAL
+		}
+		if (e.Tag == aclUser || e.Tag == aclGroup) && e.Name == "" {
+			return nil, syscall.EINVAL
+		}
+		seen[e.Tag] = true
+		entries = append(entries, e)
+	}
+	if !seen[aclUserObj] || !seen[aclGroupObj] || !seen[aclOther] || ((seen[aclUser] || seen[aclGroup]) && !seen[aclMask]) {
+		return nil, syscall.EINVAL
+	}
+	return entries, nil
+}
+
+// Returns the value of the ACL attribute of the file or directory with the attributes
+func (filesystem *FileSystem) getAclXAttr(path string, attrs Attrs, name string) ([]byte, error) {
+	if !filesystem.Capabilities.ACLs {
+		return nil, syscall.ENOTSUP
+	}
+	entries, err := filesystem.getDFSConnector().GetAcl(path)
+	if err != nil {
+		return nil, err
+	}
+	if name == aclDefaultXAttr {
+		entries = aclScope(entries, true)
+	} else if entries = aclScope(entries, false); len(entries) > 0 {
+		entries = accessAcl(attrs.Mode, entries)
+	}
+	if len(entries) == 0 {
+		// getfacl shows the ACL of the mode bits
+		return nil, fuse.ErrNoXattr
+	}
+	return encodeAcl(entries), nil
+}
+
+// Sets the ACL attribute of the file or directory with the attributes, an empty value removes it
+func (filesystem *FileSystem) setAclXAttr(path string, attrs Attrs, name string, value []byte) error {
+	if !filesystem.Capabilities.ACLs {
+		return syscall.ENOTSUP
+	}
+	if name == aclDefaultXAttr && !attrs.Mode.IsDir() {
+		return syscall.EACCES
+	}
+	var entries []AclEntry
+	if len(value) > 0 {
+		var err error
+		if entries, err = decodeAcl(value, name == aclDefaultXAttr); err != nil {
+			return err
+		}
+	}
+	current, err := filesystem.getDFSConnector().GetAcl(path)
+	if err != nil {
+		return err
+	}
+	// the namenode replaces both the access and the default ACL
+	if name == aclDefaultXAttr {
+		entries = append(accessAcl(attrs.Mode, aclScope(current, false)), entries...)
+	} else {
+		if len(entries) == 0 {
+			entries = accessAcl(attrs.Mode, nil)
+		}
+		entries = append(entries, aclScope(current, true)...)
+	}
+	if err := filesystem.getDFSConnector().SetAcl(path, entries); err != nil {
+		logwarn("Failed to set ACL", Fields{Operation: SetAclOp, Path: path, Error: err})
+		return err
+	}
+	loginfo("Set ACL", Fields{Operation: SetAclOp, Path: path, Message: name})
+	return nil
+}
+
+// Applies the default ACL of the directory, if any, to a file or directory created in it. Called with the directory locked
+func (dir *DirINode) inheritAcl(path string, mode os.FileMode) error {
+	if !dir.FileSystem.Capabilities.ACLs {
+		return nil
+	}
+	entries, err := dir.FileSystem.getDFSConnector().GetAcl(dir.AbsolutePath())
+	if err != nil {
+		if isUnsupportedError(err) {
+			return nil
+		}
+		return err
+	}
+	defaults := aclScope(entries, true)
+	if len(defaults) == 0 {
+		return nil
+	}
+	return dir.FileSystem.getDFSConnector().SetAcl(path, inheritedAcl(defaults, mode))
+}
+
+// Responds on FUSE Setxattr request, for the ACL attributes and the policies of the directory
+func (dir *DirINode) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	if req.Name == storagePolicyXAttr {
+		return dir.FileSystem.setStoragePolicyXAttr(dir.AbsolutePath(), req.Xattr)
+	}
+	if req.Name == erasureCodingXAttr {
+		return dir.FileSystem.setErasureCodingXAttr(dir.AbsolutePath(), req.Xattr, false)
+	}
+	if !isAclXAttr(req.Name) {
+		return syscall.ENOTSUP
+	}
+	return dir.setAcl(req.Name, req.Xattr)
+}
+
+// Responds on FUSE Removexattr request, for the ACL attributes and the policies of the directory
+func (dir *DirINode) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	if req.Name == storagePolicyXAttr {
+		return dir.FileSystem.setStoragePolicy(dir.AbsolutePath(), "")
+	}
+	if req.Name == erasureCodingXAttr {
+		return dir.FileSystem.setErasureCodingXAttr(dir.AbsolutePath(), nil, true)
+	}
+	if !isAclXAttr(req.Name) {
+		return syscall.ENOTSUP
+	}
+	return dir.setAcl(req.Name, nil)
+}
+
+// Sets the ACL attribute of the directory and expires its attributes, since the mode changes with the ACL
+func (dir *DirINode) setAcl(name string, value []byte) error {
+	if err := dir.FileSystem.checkWritableAt(dir.AbsolutePath()); err != nil {
+		return err
+	}
+	dir.FileSystem.Mutations.Enter()
+	defer dir.FileSystem.Mutations.Exit()
+	dir.lockMutex()
+	defer dir.unlockMutex()
+	if err := dir.FileSystem.setAclXAttr(dir.AbsolutePath(), dir.Attrs, name, value); err != nil {
+		return err
+	}
+	dir.Attrs.Expires = dir.FileSystem.Clock.Monotonic() - time.Second
+	go dir.FileSystem.invalidateNodeAttr(dir)
+	return nil
+}
+
+// Sets the ACL attribute of the file and expires its attributes
+func (file *FileINode) setAcl(name string, value []byte) error {
+	if err := file.FileSystem.checkWritableAt(file.AbsolutePath()); err != nil {
+		return err
+	}
+	file.FileSystem.Mutations.Enter()
+	defer file.FileSystem.Mutations.Exit()
+	file.lockFile()
+	defer file.unlockFile()
+	if err := file.FileSystem.setAclXAttr(file.AbsolutePath(), file.Attrs, name, value); err != nil {
+		return err
+	}
+	file.InvalidateMetadataCache()
+	go file.FileSystem.invalidateNodeAttr(file)
+	return nil
+}
+
+// Returns the ACL attribute of the file
+func (file *FileINode) getAcl(name string, resp *fuse.GetxattrResponse) error {
+	file.lockFile()
+	attrs := file.Attrs
+	file.unlockFile()
+	value, err := file.FileSystem.getAclXAttr(file.AbsolutePath(), attrs, name)
+	if err != nil {
+		return err
+	}
+	resp.Xattr = value
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that HDFS ACLs are served and set as the POSIX ACL attributes, and that new files inherit the default ACL
+func TestAcl(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	dir := root.(*DirINode).NodeFromAttrs(Attrs{Name: "shared", Mode: os.ModeDir | 0770}).(*DirINode)
+
+	// without ACL support getfacl falls back to the mode
+	resp := &fuse.GetxattrResponse{}
+	assert.Equal(t, syscall.ENOTSUP, dir.Getxattr(nil, &fuse.GetxattrRequest{Name: aclAccessXAttr}, resp))
+	fs.Capabilities.ACLs = true
+
+	daemon := AclEntry{Tag: aclUser, Name: "daemon", Perm: 5}
+	defaults := []AclEntry{
+		{Tag: aclUserObj, Perm: 7, Default: true},
+		{Tag: aclUser, Name: "daemon", Perm: 7, Default: true},
+		{Tag: aclGroupObj, Perm: 5, Default: true},
+		{Tag: aclMask, Perm: 7, Default: true},
+		{Tag: aclOther, Perm: 0, Default: true}}
+	hdfsAccessor.EXPECT().GetAcl("/shared").Return(append([]AclEntry{daemon, {Tag: aclGroupObj, Perm: 7}}, defaults...), nil).AnyTimes()
+	assert.Nil(t, dir.Getxattr(nil, &fuse.GetxattrRequest{Name: aclAccessXAttr}, resp))
+	access, err := decodeAcl(resp.Xattr, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []AclEntry{{Tag: aclUserObj, Perm: 7}, {Tag: aclUser, Name: "daemon", Perm: 5}, {Tag: aclGroupObj, Perm: 7},
+		{Tag: aclMask, Perm: 7}, {Tag: aclOther, Perm: 0}}, access)
+	assert.Nil(t, dir.Getxattr(nil, &fuse.GetxattrRequest{Name: aclDefaultXAttr}, resp))
+	decoded, err := decodeAcl(resp.Xattr, true)
+	assert.Nil(t, err)
+	assert.Equal(t, defaults, decoded)
+
+	// setting the access ACL keeps the default ACL
+	access[1].Perm = 7
+	hdfsAccessor.EXPECT().SetAcl("/shared", append(append([]AclEntry(nil), access...), defaults...)).Return(nil)
+	assert.Nil(t, dir.Setxattr(nil, &fuse.SetxattrRequest{Name: aclAccessXAttr, Xattr: encodeAcl(access)}))
+	assert.Equal(t, syscall.EINVAL, dir.Setxattr(nil, &fuse.SetxattrRequest{Name: aclAccessXAttr, Xattr: []byte{2, 0, 0, 0, 1}}))
+
+	// a new file gets the default entries masked by its mode, a new directory also the default ACL
+	hdfsAccessor.EXPECT().Chown(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().Mkdir("/shared/sub", os.ModeDir|0750).Return(nil)
+	inherited := []AclEntry{{Tag: aclUserObj, Perm: 7}, {Tag: aclUser, Name: "daemon", Perm: 7}, {Tag: aclGroupObj, Perm: 5},
+		{Tag: aclMask, Perm: 5}, {Tag: aclOther, Perm: 0}}
+	hdfsAccessor.EXPECT().SetAcl("/shared/sub", append(append([]AclEntry(nil), inherited...), defaults...)).Return(nil)
+	_, err = dir.Mkdir(nil, &fuse.MkdirRequest{Name: "sub", Mode: os.ModeDir | 0750})
+	assert.Nil(t, err)
+	assert.Equal(t, []AclEntry{{Tag: aclUserObj, Perm: 6}, {Tag: aclUser, Name: "daemon", Perm: 7}, {Tag: aclGroupObj, Perm: 5},
+		{Tag: aclMask, Perm: 4}, {Tag: aclOther, Perm: 0}}, inheritedAcl(defaults, 0640))
+
+	file := dir.NodeFromAttrs(Attrs{Name: "notes.txt", Mode: 0640}).(*FileINode)
+	assert.Equal(t, syscall.EACCES, file.Setxattr(nil, &fuse.SetxattrRequest{Name: aclDefaultXAttr, Xattr: encodeAcl(defaults)}))
+}
+
+// Testing that the ACLs are read and replaced over WebHDFS, in the aclspec format
+func TestWebHdfsAcl(t *testing.T) {
+	var aclspec string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "alice", r.URL.Query().Get("user.name"))
+		switch r.URL.Query().Get("op") {
+		case "GETACLSTATUS":
+			assert.Equal(t, http.MethodGet, r.Method)
+			w.Write([]byte(`{"AclStatus":{"entries":["user:carla:rw-","group::r-x","default:user::rwx","default:mask::r--"],"group":"data","owner":"alice","stickyBit":false}}`))
+		case "SETACL":
+			assert.Equal(t, http.MethodPut, r.Method)
+			aclspec = r.URL.Query().Get("aclspec")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	webhdfs, err := NewWebHdfs(server.URL, WallClock{}, TLSConfig{})
+	assert.Nil(t, err)
+	dfs := &hdfsAccessorImpl{User: "alice", WebHdfs: webhdfs}
+	entries, err := dfs.GetAcl("/data")
+	assert.Nil(t, err)
+	assert.Equal(t, []AclEntry{{Tag: aclUser, Name: "carla", Perm: 6}, {Tag: aclGroupObj, Perm: 5},
+		{Tag: aclUserObj, Perm: 7, Default: true}, {Tag: aclMask, Perm: 4, Default: true}}, entries)
+
+	assert.Nil(t, dfs.SetAcl("/data", append(accessAcl(0750, entries[:2]), entries[2:]...)))
+	assert.Equal(t, "user::rwx,other::---,user:carla:rw-,group::r-x,mask::r-x,default:user::rwx,default:mask::r--", aclspec)
+
+	for _, spec := range []string{"user:carla", "owner::rwx", "user::rwz", "user::rw"} {
+		_, err := parseAclSpec(spec)
+		assert.Equal(t, syscall.EINVAL, err, spec)
+	}
+	_, err = (&hdfsAccessorImpl{}).GetAcl("/data")
+	assert.Equal(t, syscall.ENOTSUP, err)
+	assert.Equal(t, syscall.ENOTSUP, (&hdfsAccessorImpl{}).SetAcl("/data", entries))
+}
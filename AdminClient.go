@@ -0,0 +1,132 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Entry point of the "admin" sub command. Sends a command to a running mount
+// and prints the reply. Returns the process exit code
+func runAdminClient(args []string) int {
+	flags := flag.NewFlagSet("admin", flag.ExitOnError)
+	socketPath := flags.String("socket", "", "Admin socket of the mount. By default it is derived from the mount point of the first path argument")
+	mountPoint := flags.String("mountPoint", "", "Mount point of the file system to send the command to")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s admin:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s admin [Options] Command [Args]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  \nCommands:\n")
+		for _, name := range adminCommandNames() {
+			cmd := adminCommands[name]
+			fmt.Fprintf(os.Stderr, "  %s %s\n    \t%s\n", name, cmd.Usage, cmd.Help)
+		}
+		fmt.Fprintf(os.Stderr, "  \nOptions:\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	req := AdminRequest{Command: flags.Arg(0), Args: flags.Args()[1:]}
+	cmd, ok := adminCommands[req.Command]
+	if !ok || len(req.Args) < cmd.PathArgs {
+		flags.Usage()
+		return 2
+	}
+
+	// the mount process has a different working directory
+	for i := 0; i < cmd.PathArgs; i++ {
+		abs, err := filepath.Abs(req.Args[i])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid path %s. Error: %v\n", req.Args[i], err)
+			return 2
+		}
+		req.Args[i] = abs
+	}
+
+	if *socketPath == "" {
+		mnt := *mountPoint
+		if mnt == "" && cmd.PathArgs > 0 {
+			var err error
+			if mnt, err = findMountPoint(req.Args[0]); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to find the mount point of %s. Error: %v\n", req.Args[0], err)
+				return 2
+			}
+		}
+		if mnt == "" {
+			fmt.Fprintf(os.Stderr, "Either -socket or -mountPoint must be specified\n")
+			return 2
+		}
+		*socketPath = defaultAdminSocketPath(mnt)
+	}
+
+	if err := sendAdminRequest(*socketPath, req, func(msg string) { fmt.Println(msg) }); err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed. Error: %v\n", req.Command, err)
+		return 1
+	}
+	return 0
+}
+
+// Sends the request to the admin socket. Progress messages are passed to onMessage
+func sendAdminRequest(socketPath string, req AdminRequest, onMessage func(string)) error {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var reply AdminReply
+		if err := decoder.Decode(&reply); err != nil {
+			return fmt.Errorf("connection to the mount was lost: %v", err)
+		}
+		if reply.Message != "" {
+			onMessage(reply.Message)
+		}
+		if reply.Error != "" {
+			return errors.New(reply.Error)
+		}
+		if reply.Done {
+			return nil
+		}
+	}
+}
+
+// Returns the mount point containing the path, i.e, the top most
+// directory which is on the same device as the path
+func findMountPoint(p string) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(p, &st); err != nil {
+		return "", err
+	}
+	dev := st.Dev
+	for {
+		parent := filepath.Dir(p)
+		if parent == p {
+			return p, nil
+		}
+		if err := syscall.Stat(parent, &st); err != nil {
+			return "", err
+		}
+		if st.Dev != dev {
+			return p, nil
+		}
+		p = parent
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Request sent by the admin client over the admin socket (one JSON document per connection)
+type AdminRequest struct {
+	Command string   `json:"cmd"`
+	Args    []string `json:"args"`
+}
+
+// One line of the reply streamed back to the admin client. The last line has Done set
+type AdminReply struct {
+	Message string `json:"msg,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Done    bool   `json:"done,omitempty"`
+}
+
+// Implements an admin command. Arguments that are paths are already translated to HDFS paths
+type AdminHandler func(filesystem *FileSystem, args []string, out *AdminOutput) error
+
+// Describes an admin command
+type AdminCommand struct {
+	Usage    string       // arguments of the command, printed by the admin client
+	Help     string       // one line description of the command
+	PathArgs int          // number of leading arguments which are paths inside the mount point
+	Handler  AdminHandler // server side implementation of the command
+}
+
+var adminCommands = make(map[string]AdminCommand)
+
+// Registers a command served by the admin socket
+func registerAdminCommand(name string, cmd AdminCommand) {
+	adminCommands[name] = cmd
+}
+
+// Streams progress messages back to the admin client
+// Concurrency: thread safe, commands may report progress from multiple goroutines
+type AdminOutput struct {
+	encoder *json.Encoder
+	mutex   sync.Mutex
+}
+
+// Sends a progress message to the admin client
+func (out *AdminOutput) Printf(format string, args ...interface{}) {
+	out.send(AdminReply{Message: fmt.Sprintf(format, args...)})
+}
+
+func (out *AdminOutput) send(reply AdminReply) {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+	// errors are ignored, the client may have gone away. The command still runs to completion
+	out.encoder.Encode(reply)
+}
+
+// Serves administrative commands for a mounted file system over a unix domain socket
+type AdminServer struct {
+	FileSystem *FileSystem
+	SocketPath string
+	listener   net.Listener
+}
+
+// Returns the default location of the admin socket for a mount point.
+// The admin client uses the same function to find the socket of a mount
+func defaultAdminSocketPath(mountPoint string) string {
+	if abs, err := filepath.Abs(mountPoint); err == nil {
+		mountPoint = abs
+	}
+	h := fnv.New32a()
+	h.Write([]byte(mountPoint))
+	return filepath.Join(adminSocketDir(), fmt.Sprintf("hopsfs-mount-%08x.sock", h.Sum32()))
+}
+
+// Returns the directory of the default admin sockets of the user, private to the user
+func adminSocketDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("hopsfs-mount-%d", os.Getuid()))
+}
+
+// Creates the directory of the default admin sockets, and checks that it is a directory of the
+// user no one else can enter, e.g., not one created by another user in the temporary directory
+func createAdminSocketDir(dir string) error {
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || stat.Uid != uint32(os.Getuid()) || info.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s is not a directory of the user with mode 0700", dir)
+	}
+	return nil
+}
+
+// Returns true if the user may manage the mount: the user running it and root
+func adminAllowed(uid uint32) bool {
+	return uid == 0 || uid == uint32(os.Getuid())
+}
+
+// Returns the uid of the process at the other end of the connection
+func peerUid(conn net.Conn) (uint32, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, fmt.Errorf("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, credErr
+	}
+	return cred.Uid, nil
+}
+
+// Returns the admin socket given by -adminSocket, or the default one of the mount point
+func adminSocketFor(socketPath string, mountPoint string) string {
+	if socketPath != "" {
+		return socketPath
+	}
+	return defaultAdminSocketPath(mountPoint)
+}
+
+// Creates the admin socket. Only the user running the mount can connect to it, and root
+func NewAdminServer(filesystem *FileSystem, socketPath string) (*AdminServer, error) {
+	if filepath.Dir(socketPath) == adminSocketDir() {
+		if err := createAdminSocketDir(filepath.Dir(socketPath)); err != nil {
+			return nil, err
+		}
+	}
+	// remove the socket left behind by a previous instance
+	os.Remove(socketPath)
+	// the socket is created with mode 0600, rather than changed to it once others could connect
+	umask := syscall.Umask(0177)
+	listener, err := net.Listen("unix", socketPath)
+	syscall.Umask(umask)
+	if err != nil {
+		return nil, err
+	}
+	return &AdminServer{FileSystem: filesystem, SocketPath: socketPath, listener: listener}, nil
+}
+
+// Accepts connections until the server is closed
+func (server *AdminServer) Serve() {
+	loginfo(fmt.Sprintf("Admin socket is listening on %s", server.SocketPath), nil)
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return
+			}
+			logwarn("Admin socket accept failed", Fields{Error: err})
+			continue
+		}
+		// the mode of the socket may have been changed, or it may be a custom -adminSocket
+		// in a directory others can enter
+		if uid, err := peerUid(conn); err != nil || !adminAllowed(uid) {
+			logwarn("Admin socket refused a connection of another user", Fields{UID: uid, Error: err})
+			conn.Close()
+			continue
+		}
+		go server.handle(conn)
+	}
+}
+
+// Closes the admin socket
+func (server *AdminServer) Close() error {
+	err := server.listener.Close()
+	os.Remove(server.SocketPath)
+	return err
+}
+
+func (server *AdminServer) handle(conn net.Conn) {
+	defer conn.Close()
+	out := &AdminOutput{encoder: json.NewEncoder(conn)}
+
+	var req AdminRequest
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		out.send(AdminReply{Error: fmt.Sprintf("malformed request: %v", err), Done: true})
+		return
+	}
+
+	loginfo("Admin command", Fields{Operation: AdminCmd, Command: req.Command, Args: req.Args})
+	err := server.dispatch(req, out)
+	if err != nil {
+		logwarn("Admin command failed", Fields{Operation: AdminCmd, Command: req.Command, Args: req.Args, Error: err})
+		out.send(AdminReply{Error: err.Error(), Done: true})
+		return
+	}
+	out.send(AdminReply{Done: true})
+}
+
+func (server *AdminServer) dispatch(req AdminRequest, out *AdminOutput) error {
+	cmd, ok := adminCommands[req.Command]
+	if !ok {
+		return fmt.Errorf("unknown command %q", req.Command)
+	}
+	if len(req.Args) < cmd.PathArgs {
+		return fmt.Errorf("usage: %s %s", req.Command, cmd.Usage)
+	}
+	args := make([]string, len(req.Args))
+	copy(args, req.Args)
+	for i := 0; i < cmd.PathArgs; i++ {
+		hdfsPath, err := server.FileSystem.HdfsPathFromMountPath(args[i])
+		if err != nil {
+			return err
+		}
+		args[i] = hdfsPath
+	}
+	return cmd.Handler(server.FileSystem, args, out)
+}
+
+// Returns names of all registered admin commands in alphabetical order
+func adminCommandNames() []string {
+	names := make([]string, 0, len(adminCommands))
+	for name := range adminCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Translates an absolute local path under the mount point to the HDFS path
+func (filesystem *FileSystem) HdfsPathFromMountPath(localPath string) (string, error) {
+	mountPoint := filepath.Clean(filesystem.MountPoint)
+	localPath = filepath.Clean(localPath)
+	if mountPoint == "" || !filepath.IsAbs(localPath) {
+		return "", fmt.Errorf("path %s is not an absolute path", localPath)
+	}
+	rel, err := filepath.Rel(mountPoint, localPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("path %s is not under the mount point %s", localPath, mountPoint)
+	}
+	if rel == "." {
+		return filesystem.SrcDir, nil
+	}
+	return path.Join(filesystem.SrcDir, filepath.ToSlash(rel)), nil
+}
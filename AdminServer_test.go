@@ -0,0 +1,53 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the admin socket is created with mode 0600, that the directory of the default
+// sockets must be private to the user, and that the credentials of the connections are read
+func TestAdminSocketPermissions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{NewMockHdfsAccessor(mockCtrl)}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	dir, err := ioutil.TempDir("", "hopsfs-mount-admin")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "private")
+	assert.Nil(t, createAdminSocketDir(private))
+	assert.Nil(t, createAdminSocketDir(private))
+	os.Chmod(private, 0755)
+	assert.NotNil(t, createAdminSocketDir(private))
+
+	socketPath := filepath.Join(dir, "admin.sock")
+	server, err := NewAdminServer(fs, socketPath)
+	assert.Nil(t, err)
+	defer server.Close()
+	info, err := os.Stat(socketPath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	go func() {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			defer conn.Close()
+		}
+	}()
+	conn, err := server.listener.Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+	uid, err := peerUid(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(os.Getuid()), uid)
+	assert.True(t, adminAllowed(uid))
+}
@@ -0,0 +1,85 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"sync"
+)
+
+// close blocks until the whole staging file is uploaded, with the retries of -retryMaxAttempts
+// on a failing cluster, which stalls editors and build tools closing many files. With
+// -asyncUploads, the upload of a flush is handed to the background, and close returns right
+// away. At most -asyncUploads uploads run at once, the others wait for their turn. As with
+// -flushCoalesceWindow, the staging file is kept until it is uploaded, a flush of the file
+// meanwhile replaces the pending upload, fsync, a rename and the unmount upload right away and
+// return its error, and a failed background upload is logged, parked with -failedUploadsDir and
+// returned by the next flush or fsync of the file. With both, the upload starts in the background once the window passed
+var asyncUploads int
+
+// Runs the deferred flushes in the background, on at most -asyncUploads goroutines draining a
+// queue of the files to upload. A file is queued once however often it is flushed meanwhile
+// Concurrency: thread safe
+type AsyncUploader struct {
+	Uploads int
+	mutex   sync.Mutex
+	queue   []*FileINode
+	queued  map[*FileINode]bool
+	running int
+	pending sync.WaitGroup
+}
+
+// Creates an uploader running at most the given number of uploads at once
+func NewAsyncUploader(uploads int) *AsyncUploader {
+	return &AsyncUploader{Uploads: uploads, queued: make(map[*FileINode]bool)}
+}
+
+// Uploads the deferred flush of the file in the background
+func (uploader *AsyncUploader) Upload(file *FileINode) {
+	uploader.mutex.Lock()
+	defer uploader.mutex.Unlock()
+	if uploader.queued[file] {
+		return
+	}
+	uploader.queued[file] = true
+	uploader.queue = append(uploader.queue, file)
+	if uploader.running < uploader.Uploads {
+		uploader.running++
+		uploader.pending.Add(1)
+		go uploader.run()
+	}
+}
+
+// Uploads the queued files until the queue is empty
+func (uploader *AsyncUploader) run() {
+	defer uploader.pending.Done()
+	for {
+		uploader.mutex.Lock()
+		if len(uploader.queue) == 0 {
+			uploader.running--
+			uploader.mutex.Unlock()
+			return
+		}
+		file := uploader.queue[0]
+		uploader.queue[0] = nil
+		uploader.queue = uploader.queue[1:]
+		// a flush from now on queues the file again
+		delete(uploader.queued, file)
+		uploader.mutex.Unlock()
+		file.flushDeferred(false)
+	}
+}
+
+// Waits for the uploads in progress and queued, at unmount
+func (uploader *AsyncUploader) Close() error {
+	uploader.pending.Wait()
+	return nil
+}
+
+// Uploads the deferred flush of the file once it is due, in the background with -asyncUploads
+func (file *FileINode) dispatchDeferredFlush() {
+	if uploader := file.FileSystem.Uploader; uploader != nil {
+		uploader.Upload(file)
+	} else {
+		file.flushDeferred(false)
+	}
+}
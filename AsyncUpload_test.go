@@ -0,0 +1,52 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the close of a written file is uploaded in the background, and the staging file closed after
+func TestAsyncUpload(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	var uploaded []byte
+	fs := renameBarrierFs(mockCtrl, hdfsAccessor, &uploaded)
+	fs.Uploader = NewAsyncUploader(2)
+	root, _ := fs.Root()
+	node, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "model.bin",
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	file := node.(*FileINode)
+	writeAndClose(t, h.(*FileHandle), "weights")
+
+	assert.Nil(t, fs.Uploader.Close())
+	assert.Equal(t, "weights", string(uploaded))
+	file.lockFile()
+	assert.Nil(t, file.fileProxy)
+	file.unlockFile()
+	assert.Equal(t, int64(0), fs.Dirty.Dirty())
+	assert.Nil(t, (&DeferredFlushes{FileSystem: fs}).Close())
+	assert.Equal(t, "weights", string(uploaded), "uploaded once")
+}
+
+// Testing that the flushes queue the files once, and run on at most the given number of goroutines
+func TestAsyncUploaderQueue(t *testing.T) {
+	uploader := NewAsyncUploader(1)
+	uploader.mutex.Lock()
+	uploader.running = 1 // the worker is busy
+	uploader.mutex.Unlock()
+	files := []*FileINode{{}, {}}
+	uploader.Upload(files[0])
+	uploader.Upload(files[1])
+	uploader.Upload(files[0])
+	uploader.mutex.Lock()
+	assert.Equal(t, files, uploader.queue)
+	assert.Equal(t, 1, uploader.running)
+	uploader.mutex.Unlock()
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"context"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+)
+
+// A tool replaces a file atomically, or not at all, by writing the new content to a temporary
+// file in the same directory and, while it is open, setting the user.hopsfs.replace extended
+// attribute of the temporary file to the name of the file to replace, e.g.,
+// setfattr -n user.hopsfs.replace -v model.bin .model.bin.tmp. When the last handle of the
+// temporary file is closed and its content is in HDFS, it is renamed over the target with one
+// rename RPC, so that readers see either the old or the new content. If the upload or the
+// rename fails, the temporary file is removed, the target keeps its content and close fails.
+// Removing the attribute cancels the replace
+const replaceTargetXAttr = "user.hopsfs.replace"
+
+// Responds on FUSE Setxattr request
+func (file *FileINode) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	if isAclXAttr(req.Name) {
+		return file.setAcl(req.Name, req.Xattr)
+	}
+	if req.Name == storagePolicyXAttr {
+		return file.FileSystem.setStoragePolicyXAttr(file.AbsolutePath(), req.Xattr)
+	}
+	if req.Name != replaceTargetXAttr {
+		return syscall.ENOTSUP
+	}
+	if err := file.FileSystem.checkWritableAt(file.AbsolutePath()); err != nil {
+		return err
+	}
+	target := string(req.Xattr)
+	if target == "" || target == "." || target == ".." || strings.Contains(target, "/") {
+		return syscall.EINVAL
+	}
+	target, err := file.Parent.hdfsChildName(target)
+	if err != nil {
+		return err
+	}
+	if target == file.Attrs.Name {
+		return syscall.EINVAL
+	}
+	if err := checkProtected(Rename, file.Parent.AbsolutePathForChild(target), protectPath); err != nil {
+		return err
+	}
+	file.lockFileHandles()
+	defer file.unlockFileHandles()
+	if len(file.activeHandles) == 0 {
+		// the replace happens on close, so the file must be open
+		return syscall.EINVAL
+	}
+	file.replaceTarget = target
+	loginfo("Replace requested", file.logInfo(Fields{Operation: Replace, Message: target}))
+	return nil
+}
+
+// Responds on FUSE Removexattr request, cancelling the replace
+func (file *FileINode) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	if isAclXAttr(req.Name) {
+		return file.setAcl(req.Name, nil)
+	}
+	if req.Name == storagePolicyXAttr {
+		return file.FileSystem.setStoragePolicy(file.AbsolutePath(), "")
+	}
+	if req.Name != replaceTargetXAttr {
+		return syscall.ENOTSUP
+	}
+	file.lockFileHandles()
+	defer file.unlockFileHandles()
+	if file.replaceTarget == "" {
+		return fuse.ErrNoXattr
+	}
+	file.replaceTarget = ""
+	return nil
+}
+
+// Returns the target of the replace, if the handle is the last one of a file to replace
+func (fh *FileHandle) pendingReplace() string {
+	fh.File.lockFileHandles()
+	defer fh.File.unlockFileHandles()
+	if len(fh.File.activeHandles) != 1 {
+		return ""
+	}
+	return fh.File.replaceTarget
+}
+
+// Renames the file over the target once its content is uploaded, or removes it if the upload failed
+func (fh *FileHandle) replace(target string, uploadErr error) error {
+	file := fh.File
+	file.lockFileHandles()
+	file.replaceTarget = ""
+	file.unlockFileHandles()
+
+	parent := file.Parent
+	name := file.Attrs.Name
+	tempPath := file.AbsolutePath()
+	targetPath := parent.AbsolutePathForChild(target)
+	hdfsAccessor := file.FileSystem.getDFSConnector()
+	err := uploadErr
+	if err == nil {
+		err = checkProtected(Rename, targetPath, protectPath)
+	}
+	var preserved *PreservedMetadata
+	if err == nil {
+		preserved = capturePreservedMetadata(hdfsAccessor, targetPath)
+		err = hdfsAccessor.Rename(tempPath, targetPath)
+	}
+	if err != nil {
+		logerror("Replace failed, removing the new content", fh.logInfo(Fields{Operation: Replace, Message: targetPath, Error: err}))
+		if removeErr := hdfsAccessor.Remove(tempPath); removeErr != nil && removeErr != syscall.ENOENT {
+			logwarn("Failed to remove the file of a failed replace", fh.logInfo(Fields{Operation: Replace, Error: removeErr}))
+		}
+		parent.lockMutex()
+		parent.EntriesRemove(name)
+		parent.unlockMutex()
+		go file.FileSystem.invalidateEntry(parent, name)
+		return err
+	}
+
+	preserved.restore(hdfsAccessor, targetPath)
+
+	// the node of the temporary file is the target now
+	parent.lockMutex()
+	node := parent.EntriesGet(name)
+	parent.EntriesRemove(name)
+	file.Attrs.Name = target
+	if node != nil {
+		parent.EntriesSet(target, node)
+	} else {
+		parent.EntriesRemove(target)
+	}
+	parent.unlockMutex()
+	file.retagStaging()
+	// notifying the kernel while serving the flush could block it
+	go func() {
+		file.FileSystem.invalidateEntry(parent, name)
+		file.FileSystem.invalidateEntry(parent, target)
+	}()
+	loginfo("Replaced "+targetPath, fh.logInfo(Fields{Operation: Replace}))
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Creates /.model.tmp to replace /model with
+func createReplacement(t *testing.T, mockCtrl *gomock.Controller, hdfsAccessor *MockHdfsAccessor) (*DirINode, *FileHandle) {
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	empty := NewMockHdfsWriter(mockCtrl)
+	empty.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/.model.tmp", os.FileMode(0644), false).Return(empty, nil)
+	hdfsAccessor.EXPECT().Stat("/.model.tmp").Return(Attrs{Name: ".model.tmp", Mode: 0644}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().Chown("/.model.tmp", gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+
+	root, _ := fs.Root()
+	node, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: ".model.tmp",
+		Flags: fuse.OpenWriteOnly | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, node.(*FileINode).Setxattr(nil, &fuse.SetxattrRequest{Name: replaceTargetXAttr, Xattr: []byte("model")}))
+	fileHandle := h.(*FileHandle)
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("weights"), Offset: 0}, &fuse.WriteResponse{}))
+	return root.(*DirINode), fileHandle
+}
+
+// Testing that the file is renamed over the target once it is uploaded
+func TestAtomicReplace(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	root, fileHandle := createReplacement(t, mockCtrl, hdfsAccessor)
+
+	writer := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/.model.tmp").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/.model.tmp", os.FileMode(0644), true).Return(writer, nil)
+	writer.EXPECT().Write([]byte("weights")).Return(7, nil)
+	writer.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Rename("/.model.tmp", "/model").Return(nil)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Equal(t, "model", fileHandle.File.Attrs.Name)
+	assert.NotNil(t, root.EntriesGet("model"))
+	assert.Nil(t, root.EntriesGet(".model.tmp"))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
+
+// Testing that a failed upload removes the new content and leaves the target alone
+func TestAtomicReplaceRollback(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	root, fileHandle := createReplacement(t, mockCtrl, hdfsAccessor)
+
+	hdfsAccessor.EXPECT().Remove("/.model.tmp").Return(nil).Times(2)
+	hdfsAccessor.EXPECT().CreateFile("/.model.tmp", os.FileMode(0644), true).Return(nil, syscall.EDQUOT)
+	assert.Equal(t, syscall.EDQUOT, fileHandle.Flush(nil, nil))
+	assert.Nil(t, root.EntriesGet(".model.tmp"))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// The attributes of files and directories are kept for -attrCacheTTL after they are looked up,
+// by the mount and by the kernel, so that repeated stats do not each cost a getFileInfo RPC.
+// Changes made by other clients show once the cached attributes expire. With -attrCacheTTL=0
+// nothing is cached and every stat goes to the namenode, for users who need to see the
+// changes of other clients right away. The kernel still keeps the names it looked up for a
+// minute, but a stat of a name removed by another client fails right away
+var attrCacheTTL = 5 * time.Second
+
+// One -attrCacheTTL for all directories either stats published datasets which never change over
+// and over, or shows the changes of the directories written by other clients late. With
+// -attrCacheMaxTTL, the TTL adapts to how often the entries of each directory change: each time
+// the attributes of an entry are looked up again and did not change, the attributes of the
+// entries of the directory are kept twice as long, up to -attrCacheMaxTTL, and once an entry
+// changed, or an entry is created, renamed or removed through the mount, -attrCacheTTL again.
+// The kernel keeps the attributes for -attrCacheTTL, and then asks the mount, which answers from
+// its cache without an RPC
+var attrCacheMaxTTL time.Duration
+
+// Returns the Clock.Monotonic() until which attributes looked up now are kept
+func (filesystem *FileSystem) attrsExpiry() time.Duration {
+	return filesystem.Clock.Monotonic() + attrCacheTTL
+}
+
+// Returns true if the cached attributes have to be looked up again
+func (filesystem *FileSystem) attrsExpired(attrs *Attrs) bool {
+	return attrCacheTTL <= 0 || filesystem.Clock.Monotonic() > attrs.Expires
+}
+
+// Returns the Clock.Monotonic() until which the attributes of an entry of the directory looked up
+// now are kept, given the attributes it had before, a zero Attrs if it was not looked up yet
+func (dir *DirINode) entryAttrsExpiry(previous *Attrs, current *Attrs) time.Duration {
+	if attrCacheTTL <= 0 || attrCacheMaxTTL <= attrCacheTTL {
+		return dir.FileSystem.attrsExpiry()
+	}
+	ttl := time.Duration(atomic.LoadInt64(&dir.entryTTL))
+	if ttl < attrCacheTTL {
+		ttl = attrCacheTTL
+	}
+	if previous.Name != "" {
+		if attrsChanged(previous, current) {
+			ttl = attrCacheTTL
+		} else if ttl *= 2; ttl > attrCacheMaxTTL {
+			ttl = attrCacheMaxTTL
+		}
+		atomic.StoreInt64(&dir.entryTTL, int64(ttl))
+	}
+	return dir.FileSystem.Clock.Monotonic() + ttl
+}
+
+// Returns true if the entry was modified between the two lookups of its attributes
+func attrsChanged(previous *Attrs, current *Attrs) bool {
+	return previous.Inode != current.Inode || previous.Size != current.Size || !previous.Mtime.Equal(current.Mtime) ||
+		previous.Mode != current.Mode || previous.Uid != current.Uid || previous.Gid != current.Gid
+}
+
+// Keeps the attributes of the entries of the directory for -attrCacheTTL again, called when an
+// entry changed through the mount
+func (dir *DirINode) resetEntryAttrsTTL() {
+	atomic.StoreInt64(&dir.entryTTL, 0)
+}
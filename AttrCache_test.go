@@ -0,0 +1,89 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a file whose attributes are not cached yet
+func attrCacheTestFile(t *testing.T, hdfsAccessor *MockHdfsAccessor) *FileINode {
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	root, _ := fs.Root()
+	return root.(*DirINode).NodeFromAttrs(Attrs{Name: "file", Mode: 0644, Expires: -time.Second}).(*FileINode)
+}
+
+// Testing that the attributes are looked up once within -attrCacheTTL and given to the kernel for as long
+func TestAttrCacheTTL(t *testing.T) {
+	saveFlags(t, &attrCacheTTL)
+	attrCacheTTL = time.Minute
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	file := attrCacheTestFile(t, hdfsAccessor)
+	hdfsAccessor.EXPECT().Stat("/file").Return(Attrs{Name: "file", Mode: 0644, Size: 3}, nil).Times(1)
+
+	var attr fuse.Attr
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, file.Attr(nil, &attr))
+	}
+	assert.Equal(t, uint64(3), attr.Size)
+	assert.Equal(t, time.Minute, attr.Valid)
+}
+
+// Testing that nothing is cached with -attrCacheTTL=0
+func TestAttrCacheDisabled(t *testing.T) {
+	saveFlags(t, &attrCacheTTL)
+	attrCacheTTL = 0
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	file := attrCacheTestFile(t, hdfsAccessor)
+	hdfsAccessor.EXPECT().Stat("/file").Return(Attrs{Name: "file", Mode: 0644, Size: 3}, nil).Times(3)
+
+	var attr fuse.Attr
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, file.Attr(nil, &attr))
+	}
+	assert.Equal(t, time.Duration(0), attr.Valid)
+}
+
+// Testing that the attributes of the entries which do not change are kept longer, up to -attrCacheMaxTTL,
+// and for -attrCacheTTL again once an entry changed
+func TestAttrCacheAdaptiveTTL(t *testing.T) {
+	saveFlags(t, &attrCacheTTL, &attrCacheMaxTTL)
+	attrCacheTTL, attrCacheMaxTTL = time.Second, 4*time.Second
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	file := attrCacheTestFile(t, hdfsAccessor)
+	clock := file.FileSystem.Clock.(*MockClock)
+	stats := 0
+	size := uint64(3)
+	hdfsAccessor.EXPECT().Stat("/file").DoAndReturn(func(path string) (Attrs, error) {
+		stats++
+		return Attrs{Name: "file", Mode: 0644, Size: size}, nil
+	}).AnyTimes()
+
+	var attr fuse.Attr
+	stat := func(elapsed time.Duration) int {
+		clock.NotifyTimeElapsed(elapsed)
+		assert.Nil(t, file.Attr(nil, &attr))
+		return stats
+	}
+	assert.Equal(t, 1, stat(0))                     // changed, kept for 1s
+	assert.Equal(t, 2, stat(1500*time.Millisecond)) // unchanged, kept for 2s
+	assert.Equal(t, 2, stat(1500*time.Millisecond)) // cached
+	assert.Equal(t, 3, stat(time.Second))           // unchanged, kept for 4s
+	assert.Equal(t, 3, stat(3*time.Second))         // cached
+	assert.Equal(t, 4, stat(1500*time.Millisecond)) // unchanged, kept for 4s at most
+	assert.Equal(t, 5, stat(4500*time.Millisecond)) // expired after 4s
+	size = 5
+	assert.Equal(t, 6, stat(4500*time.Millisecond)) // changed, kept for 1s
+	assert.Equal(t, 7, stat(1500*time.Millisecond))
+	assert.Equal(t, time.Second, attr.Valid)
+}
@@ -18,10 +18,14 @@ type Attrs struct {
 	Size    uint64
 	Uid     uint32
 	Gid     uint32
+	Group   string // HDFS group of the entry, empty if not known
 	Mtime   time.Time
 	Ctime   time.Time
 	Crtime  time.Time
-	Expires time.Time // indicates when cached attribute information expires
+	Target  string        // target of a symlink, empty until read for emulated links
+	Policy  uint8         // id of the HDFS storage policy, 0 if unspecified or not known
+	EC      string        // name of the erasure coding policy, empty if replicated
+	Expires time.Duration // Clock.Monotonic() after which cached attribute information expires
 }
 
 // FsInfo provides information about HDFS
@@ -31,9 +35,32 @@ type FsInfo struct {
 	remaining uint64
 }
 
+// HDFS keeps the sticky bit in the permission as in POSIX, whereas os.FileMode
+// uses a separate high bit for it
+const hdfsStickyBit = 01000
+
+// Converts the HDFS permission into os.FileMode
+func fileModeFromHdfsPerm(perm uint32) os.FileMode {
+	mode := os.FileMode(perm) & os.ModePerm
+	if perm&hdfsStickyBit != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// Converts os.FileMode into the HDFS permission. The file type bits are dropped
+func hdfsPermFromFileMode(mode os.FileMode) os.FileMode {
+	perm := mode & os.ModePerm
+	if mode&os.ModeSticky != 0 {
+		perm |= hdfsStickyBit
+	}
+	return perm
+}
+
 // Converts Attrs datastructure into FUSE represnetation
 func (attrs *Attrs) ConvertAttrToFuse(a *fuse.Attr) error {
 	a.Inode = attrs.Inode
+	a.Valid = attrCacheTTL
 	a.Mode = attrs.Mode
 	if (a.Mode & os.ModeDir) == 0 {
 		a.Size = attrs.Size
@@ -42,14 +69,16 @@ func (attrs *Attrs) ConvertAttrToFuse(a *fuse.Attr) error {
 	a.Gid = attrs.Gid
 	a.Mtime = attrs.Mtime
 	a.Ctime = attrs.Ctime
-	a.Crtime = attrs.Crtime
+	a.Crtime = birthTime(attrs)
 	return nil
 }
 
-// returns fuse.DirentType for this attributes (DT_Dir or DT_File)
+// returns fuse.DirentType for this attributes (DT_Dir, DT_Link or DT_File)
 func (attrs *Attrs) FuseNodeType() fuse.DirentType {
 	if (attrs.Mode & os.ModeDir) == os.ModeDir {
 		return fuse.DT_Dir
+	} else if (attrs.Mode & os.ModeSymlink) == os.ModeSymlink {
+		return fuse.DT_Link
 	} else {
 		return fuse.DT_File
 	}
@@ -0,0 +1,270 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"sync/atomic"
+	"syscall"
+)
+
+// Without -blockCacheDir, a read of a file waits for HDFS, and the kernel only asks for the next
+// -maxReadahead bytes once the previous read returned, so a sequential reader was bound by the
+// latency of the datanode rather than its throughput. With -readaheadBytes, once two reads of an
+// open file are contiguous, the next -readaheadBytes bytes are read in the background through a
+// second HDFS reader of the file, and the following chunk as soon as the reads enter the
+// previous one, so that the datanode streams while the application consumes. A read which is
+// not served by the chunks read ahead drops them and is read from HDFS. The chunks are only read
+// ahead for files opened read-only, and are dropped if the file changed in HDFS meanwhile. With
+// -blockCacheDir or -readCacheMB, -readaheadBlocks applies instead. The chunks are read as the
+// user who opened the file, with -impersonate
+var readaheadBytes int64
+
+// Every open file reads up to -readaheadStreams + 1 chunks ahead, so many files read at once
+// could hold more memory than the machine has. -readaheadMaxBytes caps the bytes read ahead by
+// all the files of the mount: a chunk which does not fit is not read ahead, and the reads are
+// served from HDFS until the chunks of other files were read or dropped
+var readaheadMaxBytes int64
+
+// Bytes of the chunks read ahead by all the files, released once a chunk was both read and dropped
+var readaheadUsed int64
+
+// A single HDFS stream reads one datanode block after the other, far below the bandwidth of the
+// network of the client. With -readaheadStreams, the chunks are read ahead by that many
+// readers of the file concurrently, each reading every -readaheadStreams-th chunk, and served in
+// order, so that as many datanodes stream at once. Up to -readaheadStreams chunks are read ahead
+// of the one being read. Reads of chunks of a multiple of the HDFS block size, e.g., 128 MiB,
+// spread over the replicas of several blocks
+var readaheadStreams int
+
+// Chunks read ahead of the reads of a file: the one being read and the next -readaheadStreams
+type BackgroundReadahead struct {
+	chunks  []*readaheadChunk
+	next    int64 // offset following the last read
+	reads   int64
+	started int               // chunks started, a chunk is read by the stream started % streams
+	tails   []*readaheadChunk // last chunk started on each stream, also if dropped, the next one is read after it
+	readers []ReadSeekCloser  // readers of the streams, opened by their first chunk
+}
+
+// A chunk of -readaheadBytes bytes read in the background. Data and err are set once done is closed
+type readaheadChunk struct {
+	off     int64
+	data    []byte
+	err     error
+	size    int64 // bytes reserved from -readaheadMaxBytes
+	version FileVersion
+	done    chan struct{}
+}
+
+// Serves the read from the chunks read ahead, returns false if the read has to be read from HDFS.
+// Called with the file handles locked
+func (p *RemoteROFileProxy) readAhead(b []byte, off int64) (int, error, bool) {
+	ahead := &p.ahead
+	sequential := ahead.reads > 0 && off == ahead.next
+	ahead.reads++
+	ahead.next = off + int64(len(b))
+	if readaheadBytes <= 0 {
+		return 0, nil, false
+	}
+	if !sequential {
+		p.dropReadahead()
+		return 0, nil, false
+	}
+	if len(ahead.chunks) == 0 {
+		p.fillReadahead(off + int64(len(b)))
+		return 0, nil, false
+	}
+
+	n := 0
+	var err error
+	for _, chunk := range ahead.chunks {
+		pos := off + int64(n)
+		if pos < chunk.off || pos >= chunk.off+readaheadBytes {
+			continue
+		}
+		<-chunk.done
+		if (chunk.err != nil && chunk.err != io.EOF) || !p.sameVersion(chunk.version) {
+			p.dropReadahead()
+			return 0, nil, false
+		}
+		if start := pos - chunk.off; start < int64(len(chunk.data)) {
+			n += copy(b[n:], chunk.data[start:])
+		}
+		if n == len(b) {
+			break
+		}
+		if chunk.err == io.EOF {
+			err = io.EOF
+			break
+		}
+	}
+	if n < len(b) && err == nil {
+		// the read goes past the chunks read ahead
+		p.dropReadahead()
+		return 0, nil, false
+	}
+
+	// the chunks which were read entirely are dropped, and the next one is read ahead
+	for len(ahead.chunks) > 0 && ahead.chunks[0].off+readaheadBytes <= off+int64(n) {
+		releaseReadahead(ahead.chunks[0])
+		ahead.chunks = ahead.chunks[1:]
+	}
+	if err == nil {
+		p.fillReadahead(off + int64(n))
+	}
+	metrics.Record(ReadaheadOp, 0, int64(n), 0, true, nil)
+	logdebug("RemoteFileProxy ReadAt", p.file.logInfo(Fields{Operation: Read, Bytes: n, Error: err, Offset: off}))
+	return n, err, true
+}
+
+// Returns the number of streams reading ahead
+func readaheadStreamCount() int {
+	if readaheadStreams < 1 {
+		return 1
+	}
+	return readaheadStreams
+}
+
+// Reads the chunks following the offset ahead. Called with the file handles locked
+func (p *RemoteROFileProxy) fillReadahead(off int64) {
+	ahead := &p.ahead
+	streams := readaheadStreamCount()
+	if ahead.readers == nil {
+		ahead.readers, ahead.tails = make([]ReadSeekCloser, streams), make([]*readaheadChunk, streams)
+	}
+	for len(ahead.chunks) < 1+streams {
+		if len(ahead.chunks) > 0 {
+			off = ahead.chunks[len(ahead.chunks)-1].off + readaheadBytes
+		}
+		if !reserveReadahead(readaheadBytes) {
+			logtrace("Not reading ahead, -readaheadMaxBytes is used by other files", p.file.logInfo(Fields{Operation: Read, Offset: off}))
+			break
+		}
+		chunk := &readaheadChunk{off: off, size: readaheadBytes, done: make(chan struct{})}
+		ahead.chunks = append(ahead.chunks, chunk)
+		metrics.Record(ReadaheadFetched, 0, readaheadBytes, 0, false, nil)
+		stream := ahead.started % len(ahead.readers)
+		var connector HdfsAccessor
+		if ahead.tails[stream] == nil {
+			connector = p.dfsConnector()
+		}
+		go p.readChunk(chunk, ahead.tails[stream], stream, connector)
+		ahead.tails[stream] = chunk
+		ahead.started++
+	}
+}
+
+// Reads the chunk with the reader of the stream, once the previous chunk of the stream was read.
+// The first chunk of the stream opens its reader with the connector
+func (p *RemoteROFileProxy) readChunk(chunk *readaheadChunk, previous *readaheadChunk, stream int, connector HdfsAccessor) {
+	defer close(chunk.done)
+	if previous != nil {
+		<-previous.done
+	}
+	reader := p.ahead.readers[stream]
+	if reader == nil {
+		if connector == nil {
+			chunk.err = syscall.EIO // the reader of the stream failed to open
+			return
+		}
+		if reader, chunk.err = connector.OpenRead(p.path); chunk.err != nil {
+			logwarn("Failed to open the file to read ahead", p.file.logInfo(Fields{Operation: Read, Error: chunk.err}))
+			return
+		}
+		p.ahead.readers[stream] = reader
+	}
+	if v, ok := reader.(VersionedReader); ok {
+		if chunk.version, chunk.err = v.Version(); chunk.err != nil {
+			return
+		}
+	}
+	if chunk.err = reader.Seek(chunk.off); chunk.err != nil {
+		return
+	}
+	chunk.data = make([]byte, readaheadBytes)
+	n := 0
+	for n < len(chunk.data) {
+		m, err := reader.Read(chunk.data[n:])
+		n += m
+		if err != nil {
+			chunk.err = err
+			break
+		}
+	}
+	chunk.data = chunk.data[:n]
+	logtrace("Read ahead", p.file.logInfo(Fields{Operation: Read, Bytes: n, Offset: chunk.off, Error: chunk.err}))
+}
+
+// Returns true if the chunk read ahead is of the version read by the reader of the file
+func (p *RemoteROFileProxy) sameVersion(version FileVersion) bool {
+	v, ok := p.hdfsReader.(VersionedReader)
+	if !ok || version == (FileVersion{}) {
+		return true
+	}
+	current, err := v.Version()
+	return err == nil && current == version
+}
+
+// Returns the connection the reader of the file was opened with, the readers of the streams are
+// opened with the same one
+func (p *RemoteROFileProxy) dfsConnector() HdfsAccessor {
+	if p.connector != nil {
+		return p.connector
+	}
+	return p.file.FileSystem.getDFSConnector()
+}
+
+// Reserves the bytes of a chunk from -readaheadMaxBytes, returns false if they do not fit
+func reserveReadahead(size int64) bool {
+	if readaheadMaxBytes <= 0 {
+		atomic.AddInt64(&readaheadUsed, size)
+		return true
+	}
+	for {
+		used := atomic.LoadInt64(&readaheadUsed)
+		if used+size > readaheadMaxBytes {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&readaheadUsed, used, used+size) {
+			return true
+		}
+	}
+}
+
+// Releases the bytes of a dropped chunk once it was read
+func releaseReadahead(chunk *readaheadChunk) {
+	select {
+	case <-chunk.done:
+		atomic.AddInt64(&readaheadUsed, -chunk.size)
+	default:
+		go func() {
+			<-chunk.done
+			atomic.AddInt64(&readaheadUsed, -chunk.size)
+		}()
+	}
+}
+
+// Drops the chunks read ahead, those being read complete in the background
+func (p *RemoteROFileProxy) dropReadahead() {
+	for _, chunk := range p.ahead.chunks {
+		releaseReadahead(chunk)
+	}
+	p.ahead.chunks = nil
+}
+
+// Drops the chunks read ahead and closes the readers of the streams once their last chunk was read
+func (p *RemoteROFileProxy) closeReadahead() {
+	for stream, tail := range p.ahead.tails {
+		if tail != nil {
+			<-tail.done
+		}
+		if reader := p.ahead.readers[stream]; reader != nil {
+			if err := reader.Close(); err != nil {
+				logwarn("Failed to close the reader of the readahead", p.file.logInfo(Fields{Operation: Close, Error: err}))
+			}
+		}
+	}
+	p.dropReadahead()
+	p.ahead.tails, p.ahead.readers = nil, nil
+}
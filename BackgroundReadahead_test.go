@@ -0,0 +1,158 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that sequential reads are served by the chunks read in the background, until the end of the file
+func TestBackgroundReadahead(t *testing.T) {
+	saveFlags(t, &readaheadBytes)
+	readaheadBytes = 16384
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	aheadStats := &ReaderStats{}
+	hdfsAccessor.EXPECT().OpenRead("/f").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 40000, ReaderStats: aheadStats}, nil)
+	stats := &ReaderStats{}
+	reader := &MockReadSeekCloserWithPseudoRandomContent{FileSize: 40000, ReaderStats: stats}
+	file := &FileINode{FileSystem: fs, Attrs: Attrs{Name: "f"}, Parent: &DirINode{FileSystem: fs}}
+	proxy := &RemoteROFileProxy{hdfsReader: reader, file: file, path: "/f"}
+
+	buf := make([]byte, 4096)
+	for off := int64(0); off < 8192; off += 4096 {
+		_, err := proxy.ReadAt(buf, off)
+		assert.Nil(t, err)
+	}
+	reads := stats.ReadCount
+	off := int64(8192)
+	for ; ; off += 4096 {
+		n, err := proxy.ReadAt(buf, off)
+		assert.Equal(t, generateByteAtOffset(off), buf[0])
+		if err == io.EOF {
+			assert.Equal(t, 40000-int(off), n)
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, 4096, n)
+	}
+	assert.Equal(t, int64(36864), off)
+	assert.Equal(t, reads, stats.ReadCount, "read from the chunks read ahead")
+
+	// a jump is read from HDFS
+	n, err := proxy.ReadAt(buf, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, 4096, n)
+	assert.Equal(t, generateByteAtOffset(100), buf[0])
+	assert.True(t, stats.ReadCount > reads)
+	assert.Nil(t, proxy.Close())
+	assert.True(t, aheadStats.ReadCount > 0)
+}
+
+// Testing that the chunks read ahead by several streams are served in order
+func TestBackgroundReadaheadStreams(t *testing.T) {
+	saveFlags(t, &readaheadBytes, &readaheadStreams)
+	readaheadBytes, readaheadStreams = 4096, 3
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	var streams []*ReaderStats
+	var mutex sync.Mutex
+	hdfsAccessor.EXPECT().OpenRead("/f").DoAndReturn(func(path string) (ReadSeekCloser, error) {
+		stats := &ReaderStats{}
+		mutex.Lock()
+		defer mutex.Unlock()
+		streams = append(streams, stats)
+		return &MockReadSeekCloserWithPseudoRandomContent{FileSize: 100000, ReaderStats: stats}, nil
+	}).Times(3)
+	stats := &ReaderStats{}
+	reader := &MockReadSeekCloserWithPseudoRandomContent{FileSize: 100000, ReaderStats: stats}
+	file := &FileINode{FileSystem: fs, Attrs: Attrs{Name: "f"}, Parent: &DirINode{FileSystem: fs}}
+	proxy := &RemoteROFileProxy{hdfsReader: reader, file: file, path: "/f"}
+
+	buf := make([]byte, 1024)
+	proxy.ReadAt(buf, 0)
+	proxy.ReadAt(buf, 1024)
+	reads := stats.ReadCount
+	for off := int64(2048); off < 100000; off += 1024 {
+		n, err := proxy.ReadAt(buf, off)
+		assert.True(t, err == nil || err == io.EOF)
+		assert.True(t, n > 0)
+		for i := 0; i < n; i += 100 {
+			assert.Equal(t, generateByteAtOffset(off+int64(i)), buf[i])
+		}
+	}
+	assert.Equal(t, reads, stats.ReadCount)
+	assert.Nil(t, proxy.Close())
+	for _, stream := range streams {
+		assert.True(t, stream.ReadCount > 0)
+	}
+}
+
+// Testing that the readers of the streams are opened as the user who opened the file
+func TestBackgroundReadaheadImpersonate(t *testing.T) {
+	saveFlags(t, &readaheadBytes)
+	readaheadBytes = 4096
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	userAccessor := NewMockHdfsAccessor(mockCtrl)
+	userAccessor.EXPECT().OpenRead("/f").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 40000, ReaderStats: &ReaderStats{}}, nil)
+	reader := &MockReadSeekCloserWithPseudoRandomContent{FileSize: 40000, ReaderStats: &ReaderStats{}}
+	file := &FileINode{FileSystem: fs, Attrs: Attrs{Name: "f"}, Parent: &DirINode{FileSystem: fs}}
+	proxy := &RemoteROFileProxy{hdfsReader: reader, file: file, path: "/f", connector: userAccessor}
+
+	buf := make([]byte, 1024)
+	for off := int64(0); off < 8192; off += 1024 {
+		_, err := proxy.ReadAt(buf, off)
+		assert.Nil(t, err)
+		assert.Equal(t, generateByteAtOffset(off), buf[0])
+	}
+	assert.Nil(t, proxy.Close())
+}
+
+// Testing that the chunks read ahead by all the files fit -readaheadMaxBytes
+func TestBackgroundReadaheadMaxBytes(t *testing.T) {
+	saveFlags(t, &readaheadBytes, &readaheadStreams, &readaheadMaxBytes)
+	readaheadBytes, readaheadStreams, readaheadMaxBytes = 4096, 3, 8192
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	hdfsAccessor.EXPECT().OpenRead("/f").DoAndReturn(func(path string) (ReadSeekCloser, error) {
+		return &MockReadSeekCloserWithPseudoRandomContent{FileSize: 100000, ReaderStats: &ReaderStats{}}, nil
+	}).AnyTimes()
+	file := &FileINode{FileSystem: fs, Attrs: Attrs{Name: "f"}, Parent: &DirINode{FileSystem: fs}}
+	first := &RemoteROFileProxy{hdfsReader: &MockReadSeekCloserWithPseudoRandomContent{FileSize: 100000, ReaderStats: &ReaderStats{}}, file: file, path: "/f"}
+	second := &RemoteROFileProxy{hdfsReader: &MockReadSeekCloserWithPseudoRandomContent{FileSize: 100000, ReaderStats: &ReaderStats{}}, file: file, path: "/f"}
+
+	buf := make([]byte, 1024)
+	first.ReadAt(buf, 0)
+	first.ReadAt(buf, 1024)
+	assert.Equal(t, 2, len(first.ahead.chunks), "the other chunks do not fit")
+	assert.Equal(t, int64(8192), atomic.LoadInt64(&readaheadUsed))
+	second.ReadAt(buf, 0)
+	second.ReadAt(buf, 1024)
+	assert.Equal(t, 0, len(second.ahead.chunks))
+	for off := int64(2048); off < 100000; off += 1024 {
+		n, err := second.ReadAt(buf, off)
+		assert.True(t, err == nil || err == io.EOF)
+		assert.Equal(t, generateByteAtOffset(off), buf[0])
+		assert.True(t, n > 0)
+	}
+
+	assert.Nil(t, first.Close())
+	assert.Nil(t, second.Close())
+	assert.Equal(t, int64(0), atomic.LoadInt64(&readaheadUsed))
+}
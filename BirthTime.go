@@ -0,0 +1,139 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// HDFS keeps the modification and access times of a file, not its creation time, so the birth
+// time of a file was its modification time, changing with every write, and backup tools
+// comparing birth times took every modified file for a new one. With -birthTimesFile, the birth
+// time of a file is the modification time the mount first saw it with, exact for the files
+// created through the mount, and kept across remounts in that file, as the HDFS inode ids are
+// not reused. The birth time is the user.hopsfs.btime extended attribute of files and
+// directories, in RFC 3339, and the crtime of stat on macOS; the Linux FUSE protocol of the
+// mount has no birth time, so statx reports none there. The birth times recorded are appended
+// to the file within birthTimesFlushDelay, and the lines torn by a crash are skipped
+const birthTimeXAttr = "user.hopsfs.btime"
+
+// Time the birth times recorded are buffered before being written to -birthTimesFile
+const birthTimesFlushDelay = time.Second
+
+var birthTimesFile string
+
+// The birth times of -birthTimesFile, nil if not set
+var birthTimes *BirthTimes
+
+// Birth times of the inodes seen by the mount, appended to a file as "<inode> <unix seconds>" lines
+// Concurrency: thread safe
+type BirthTimes struct {
+	mutex          sync.Mutex
+	times          map[uint64]time.Time
+	file           *os.File
+	writer         *bufio.Writer
+	flushScheduled bool // the buffered lines are written after birthTimesFlushDelay
+	closed         bool
+}
+
+// Loads the birth times of the file, which is created if it does not exist
+func OpenBirthTimes(path string) (*BirthTimes, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	times := map[uint64]time.Time{}
+	reader := bufio.NewReader(file)
+	torn := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			file.Close()
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		if err == io.EOF {
+			// the last line, torn by a crash
+			logwarn(fmt.Sprintf("Skipping the unterminated last line of %s", path), Fields{Message: line})
+			torn = true
+			break
+		}
+		var inode uint64
+		var seconds int64
+		if _, err := fmt.Sscanf(line, "%d %d\n", &inode, &seconds); err != nil {
+			logwarn(fmt.Sprintf("Skipping a malformed line of %s", path), Fields{Error: err, Message: line})
+			continue
+		}
+		times[inode] = time.Unix(seconds, 0)
+	}
+	b := &BirthTimes{times: times, file: file, writer: bufio.NewWriter(file)}
+	if torn {
+		// the torn line stays malformed, and is skipped
+		b.writer.WriteString("\n")
+	}
+	return b, nil
+}
+
+// Returns the birth time of the inode, recording the birth time of the attributes if the inode was not seen yet
+func (b *BirthTimes) BirthTime(attrs *Attrs) time.Time {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if t, ok := b.times[attrs.Inode]; ok {
+		return t
+	}
+	t := attrs.Crtime
+	b.times[attrs.Inode] = t
+	if _, err := fmt.Fprintf(b.writer, "%d %d\n", attrs.Inode, t.Unix()); err != nil {
+		logwarn(fmt.Sprintf("Failed to record the birth time of inode %d", attrs.Inode), Fields{Error: err})
+	}
+	if !b.flushScheduled && !b.closed {
+		b.flushScheduled = true
+		time.AfterFunc(birthTimesFlushDelay, b.flush)
+	}
+	return t
+}
+
+// Writes the birth times recorded since the last flush to the file
+func (b *BirthTimes) flush() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.flushScheduled = false
+	if b.closed {
+		return
+	}
+	if err := b.writer.Flush(); err != nil {
+		logwarn(fmt.Sprintf("Failed to write the birth times to %s", b.file.Name()), Fields{Error: err})
+	}
+}
+
+// Writes the birth times recorded since the last flush and closes the file, at unmount
+func (b *BirthTimes) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	if err := b.writer.Flush(); err != nil {
+		b.file.Close()
+		return err
+	}
+	return b.file.Close()
+}
+
+// Returns the birth time of the attributes, their creation time from HDFS without -birthTimesFile
+func birthTime(attrs *Attrs) time.Time {
+	if birthTimes == nil || attrs.Inode == 0 {
+		return attrs.Crtime
+	}
+	return birthTimes.BirthTime(attrs)
+}
+
+// Returns the birth time of the attributes as the value of user.hopsfs.btime
+func birthTimeXAttrValue(attrs *Attrs) []byte {
+	return []byte(birthTime(attrs).UTC().Format(time.RFC3339))
+}
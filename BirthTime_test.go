@@ -0,0 +1,60 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the birth time of an inode is the time it was first seen with, also after a remount
+func TestBirthTimes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btimes")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "btimes")
+	created := time.Unix(1600000000, 0)
+	modified := created.Add(time.Hour)
+
+	times, err := OpenBirthTimes(path)
+	assert.Nil(t, err)
+	assert.Equal(t, created, times.BirthTime(&Attrs{Inode: 7, Crtime: created}))
+	assert.Equal(t, created, times.BirthTime(&Attrs{Inode: 7, Crtime: modified}))
+	assert.Nil(t, times.Close())
+
+	saveFlags(t, &birthTimes)
+	birthTimes, err = OpenBirthTimes(path)
+	assert.Nil(t, err)
+	defer birthTimes.Close()
+	assert.Equal(t, "2020-09-13T12:26:40Z", string(birthTimeXAttrValue(&Attrs{Inode: 7, Crtime: modified})))
+	assert.Equal(t, modified, birthTime(&Attrs{Inode: 8, Crtime: modified}))
+}
+
+// Testing that malformed lines are skipped, and that the birth times are written without a close
+func TestBirthTimesMalformedLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btimes")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "btimes")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("7 1600000000\nx 1\n8 1600000001\n9 16"), 0600))
+
+	times, err := OpenBirthTimes(path)
+	assert.Nil(t, err)
+	defer times.Close()
+	modified := time.Unix(1700000000, 0)
+	assert.Equal(t, time.Unix(1600000000, 0), times.BirthTime(&Attrs{Inode: 7, Crtime: modified}))
+	assert.Equal(t, time.Unix(1600000001, 0), times.BirthTime(&Attrs{Inode: 8, Crtime: modified}))
+	assert.Equal(t, modified, times.BirthTime(&Attrs{Inode: 9, Crtime: modified}))
+	times.flush()
+
+	reopened, err := OpenBirthTimes(path)
+	assert.Nil(t, err)
+	defer reopened.Close()
+	assert.Equal(t, modified, reopened.BirthTime(&Attrs{Inode: 9, Crtime: time.Unix(1800000000, 0)}))
+	assert.Equal(t, time.Unix(1600000001, 0), reopened.BirthTime(&Attrs{Inode: 8, Crtime: modified}))
+}
@@ -0,0 +1,346 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Identifies the content of an HDFS file. Overwriting a file in HDFS creates a new file id,
+// appending or truncating changes the length and the modification time
+type FileVersion struct {
+	FileId uint64
+	Mtime  int64 // modification time in nanoseconds
+	Size   int64
+}
+
+// Implemented by readers which know the version of the file they read
+type VersionedReader interface {
+	Version() (FileVersion, error)
+}
+
+// A block of a version of a file
+type blockKey struct {
+	FileVersion
+	Index int64
+}
+
+type blockEntry struct {
+	key  blockKey
+	size int64
+}
+
+type memoryBlock struct {
+	key  blockKey
+	data []byte
+}
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Caches blocks of -blockCacheBlockSize bytes of the files read through the mount on local
+// disk, in -blockCacheDir, up to -blockCacheSize bytes. Blocks are keyed by the file id, the
+// modification time and the length of the file, so a file which changed in HDFS never hits
+// blocks of its previous content, and those are removed as soon as the new version is seen.
+// Every block is stored with a CRC32C, a block which does not match is removed and read again
+// from HDFS. The blocks survive restarts. The least recently used blocks are evicted first, the
+// modification time of a block file is its last use, so the order survives restarts too.
+// The hottest blocks, e.g., parquet footers and index files, are also kept in memory, up to
+// -blockCacheMemory bytes, and served without any disk I/O. Blocks read from disk are promoted
+// to memory, the least recently used blocks in memory are demoted, i.e., only kept on disk
+// Concurrency: thread safe
+type BlockCache struct {
+	Dir         string // empty if the blocks are only kept in memory, see NewMemoryBlockCache
+	MaxBytes    int64
+	BlockSize   int64
+	MemoryBytes int64
+
+	mutex      sync.Mutex
+	entries    map[blockKey]*list.Element
+	lru        *list.List // of *blockEntry, most recently used first
+	size       int64
+	memEntries map[blockKey]*list.Element
+	memLRU     *list.List // of *memoryBlock, most recently used first
+	memSize    int64
+	versions   map[uint64]FileVersion // latest version of every cached file
+}
+
+// Creates the cache, loading the blocks cached by previous runs. Blocks of different block
+// sizes are kept in different directories
+func NewBlockCache(dir string, maxBytes int64, blockSize int64, memoryBytes int64) (*BlockCache, error) {
+	cache := &BlockCache{
+		Dir:         filepath.Join(dir, strconv.FormatInt(blockSize, 10)),
+		MaxBytes:    maxBytes,
+		BlockSize:   blockSize,
+		MemoryBytes: memoryBytes,
+		entries:     make(map[blockKey]*list.Element),
+		lru:         list.New(),
+		memEntries:  make(map[blockKey]*list.Element),
+		memLRU:      list.New(),
+		versions:    make(map[uint64]FileVersion),
+	}
+	if err := os.MkdirAll(cache.Dir, 0700); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(cache.Dir)
+	if err != nil {
+		return nil, err
+	}
+	// the most recently used blocks are added last, i.e., at the front of the LRU list
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+	for _, info := range infos {
+		key, ok := parseBlockFileName(info.Name())
+		if !ok || info.Size() < 4 {
+			os.Remove(filepath.Join(cache.Dir, info.Name()))
+			continue
+		}
+		cache.mutex.Lock()
+		cache.checkVersion(key.FileVersion)
+		cache.add(key, info.Size()-4)
+		cache.mutex.Unlock()
+	}
+	cache.mutex.Lock()
+	cache.evict()
+	cache.mutex.Unlock()
+	return cache, nil
+}
+
+// Without -blockCacheDir, the blocks read through the mount are cached in memory only, up to
+// -readCacheMB, shared by all the open files, so that small repeated reads, e.g., of index
+// files or of the parquet footers of files larger than -footerCacheSize, are not read from HDFS
+// again. Blocks are dropped when the file changes in HDFS as with -blockCacheDir
+var readCacheMB int64
+
+// Creates a cache keeping the blocks in memory only
+func NewMemoryBlockCache(blockSize int64, memoryBytes int64) *BlockCache {
+	return &BlockCache{
+		BlockSize:   blockSize,
+		MemoryBytes: memoryBytes,
+		entries:     make(map[blockKey]*list.Element),
+		lru:         list.New(),
+		memEntries:  make(map[blockKey]*list.Element),
+		memLRU:      list.New(),
+		versions:    make(map[uint64]FileVersion),
+	}
+}
+
+func (cache *BlockCache) fileName(key blockKey) string {
+	return filepath.Join(cache.Dir, fmt.Sprintf("%d-%d-%d-%d", key.FileId, key.Mtime, key.Size, key.Index))
+}
+
+func parseBlockFileName(name string) (blockKey, bool) {
+	parts := strings.Split(name, "-")
+	if len(parts) != 4 {
+		return blockKey{}, false
+	}
+	var values [4]int64
+	for i, part := range parts {
+		v, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return blockKey{}, false
+		}
+		values[i] = v
+	}
+	return blockKey{FileVersion{FileId: uint64(values[0]), Mtime: values[1], Size: values[2]}, values[3]}, true
+}
+
+// Returns the block, false if it is not cached or the cached copy is corrupt.
+// The returned block must not be modified
+func (cache *BlockCache) Get(version FileVersion, index int64) ([]byte, bool) {
+	key := blockKey{version, index}
+	cache.mutex.Lock()
+	cache.checkVersion(version)
+	if element, ok := cache.memEntries[key]; ok {
+		cache.memLRU.MoveToFront(element)
+		if diskElement, ok := cache.entries[key]; ok {
+			cache.lru.MoveToFront(diskElement)
+		}
+		cache.mutex.Unlock()
+		return element.Value.(*memoryBlock).data, true
+	}
+	element, ok := cache.entries[key]
+	if ok {
+		cache.lru.MoveToFront(element)
+	}
+	cache.mutex.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(cache.fileName(key))
+	if err == nil && len(data) >= 4 {
+		block := data[:len(data)-4]
+		if crc32.Checksum(block, castagnoliTable) == binary.BigEndian.Uint32(data[len(data)-4:]) {
+			cache.touch(key)
+			cache.mutex.Lock()
+			cache.promote(key, block)
+			cache.mutex.Unlock()
+			return block, true
+		}
+		logwarn("Removing corrupt block from the block cache", Fields{Path: cache.fileName(key)})
+	}
+	cache.mutex.Lock()
+	cache.remove(key)
+	cache.mutex.Unlock()
+	return nil, false
+}
+
+// Caches a block
+func (cache *BlockCache) Put(version FileVersion, index int64, block []byte) {
+	key := blockKey{version, index}
+	cache.mutex.Lock()
+	cache.checkVersion(version)
+	_, ok := cache.entries[key]
+	if cache.Dir == "" {
+		cache.promote(key, append([]byte(nil), block...))
+		cache.mutex.Unlock()
+		return
+	}
+	cache.mutex.Unlock()
+	if ok || int64(len(block)) > cache.MaxBytes {
+		return
+	}
+
+	data := make([]byte, len(block)+4)
+	copy(data, block)
+	binary.BigEndian.PutUint32(data[len(block):], crc32.Checksum(block, castagnoliTable))
+	tmp := cache.fileName(key) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		logwarn("Failed to write to the block cache", Fields{Path: tmp, Error: err})
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, cache.fileName(key)); err != nil {
+		os.Remove(tmp)
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if _, ok := cache.entries[key]; !ok {
+		cache.add(key, int64(len(block)))
+		cache.evict()
+	}
+	cache.promote(key, append([]byte(nil), block...))
+}
+
+// Returns the number of cached blocks and their size
+func (cache *BlockCache) Usage() (int, int64) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return len(cache.entries), cache.size
+}
+
+// Returns the number of blocks cached in memory and their size
+func (cache *BlockCache) MemoryUsage() (int, int64) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return len(cache.memEntries), cache.memSize
+}
+
+// Keeps the block in memory, demoting the least recently used blocks. Called with the mutex held
+func (cache *BlockCache) promote(key blockKey, block []byte) {
+	if _, ok := cache.memEntries[key]; ok || int64(len(block)) > cache.MemoryBytes {
+		return
+	}
+	cache.memEntries[key] = cache.memLRU.PushFront(&memoryBlock{key: key, data: block})
+	cache.memSize += int64(len(block))
+	for cache.memSize > cache.MemoryBytes {
+		cache.demote(cache.memLRU.Back().Value.(*memoryBlock).key)
+	}
+}
+
+// Drops the block from memory, it stays on disk until evicted from there
+func (cache *BlockCache) demote(key blockKey) {
+	element, ok := cache.memEntries[key]
+	if !ok {
+		return
+	}
+	block := cache.memLRU.Remove(element).(*memoryBlock)
+	delete(cache.memEntries, key)
+	cache.memSize -= int64(len(block.data))
+	if _, ok := cache.entries[key]; ok {
+		cache.touch(key) // its hits in memory did not touch it on disk
+	}
+}
+
+// Records the use of the block on disk
+func (cache *BlockCache) touch(key blockKey) {
+	now := time.Now()
+	os.Chtimes(cache.fileName(key), now, now)
+}
+
+// Records the use of the blocks kept in memory on disk, least recently used first, at unmount
+func (cache *BlockCache) Close() error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	for element := cache.memLRU.Back(); element != nil; element = element.Prev() {
+		if key := element.Value.(*memoryBlock).key; cache.entries[key] != nil {
+			cache.touch(key)
+		}
+	}
+	return nil
+}
+
+// Removes the blocks of other versions of the file. Called with the mutex held
+func (cache *BlockCache) checkVersion(version FileVersion) {
+	latest, ok := cache.versions[version.FileId]
+	if ok && latest == version {
+		return
+	}
+	if ok && latest.Mtime > version.Mtime {
+		return // an old reader, the blocks of the newer version stay
+	}
+	cache.versions[version.FileId] = version
+	if !ok {
+		return
+	}
+	for element := cache.lru.Front(); element != nil; {
+		next := element.Next()
+		entry := element.Value.(*blockEntry)
+		if entry.key.FileId == version.FileId && entry.key.FileVersion != version {
+			cache.remove(entry.key)
+		}
+		element = next
+	}
+	for element := cache.memLRU.Front(); element != nil; {
+		next := element.Next()
+		block := element.Value.(*memoryBlock)
+		if block.key.FileId == version.FileId && block.key.FileVersion != version {
+			cache.demote(block.key)
+		}
+		element = next
+	}
+}
+
+func (cache *BlockCache) add(key blockKey, size int64) {
+	cache.entries[key] = cache.lru.PushFront(&blockEntry{key: key, size: size})
+	cache.size += size
+}
+
+func (cache *BlockCache) remove(key blockKey) {
+	element, ok := cache.entries[key]
+	if !ok {
+		return
+	}
+	entry := cache.lru.Remove(element).(*blockEntry)
+	delete(cache.entries, key)
+	cache.size -= entry.size
+	os.Remove(cache.fileName(key))
+}
+
+func (cache *BlockCache) evict() {
+	for cache.size > cache.MaxBytes && cache.lru.Len() > 0 {
+		cache.remove(cache.lru.Back().Value.(*blockEntry).key)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that blocks are verified, invalidated when the file changes, evicted and reloaded after a restart
+func TestBlockCache(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "blockcache")
+	defer os.RemoveAll(dir)
+	cache, err := NewBlockCache(dir, 10, 4, 0)
+	assert.Nil(t, err)
+
+	v1 := FileVersion{FileId: 1, Mtime: 100, Size: 6}
+	cache.Put(v1, 0, []byte("abcd"))
+	cache.Put(v1, 1, []byte("ef"))
+	block, ok := cache.Get(v1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, "abcd", string(block))
+	_, ok = cache.Get(v1, 2)
+	assert.False(t, ok)
+
+	// corrupt blocks are not served
+	ioutil.WriteFile(cache.fileName(blockKey{v1, 1}), []byte("eX\x00\x00\x00\x00"), 0600)
+	_, ok = cache.Get(v1, 1)
+	assert.False(t, ok)
+	count, size := cache.Usage()
+	assert.Equal(t, 1, count)
+	assert.Equal(t, int64(4), size)
+
+	// a new version of the file removes the blocks of the old one
+	v2 := FileVersion{FileId: 1, Mtime: 200, Size: 8}
+	_, ok = cache.Get(v2, 0)
+	assert.False(t, ok)
+	count, _ = cache.Usage()
+	assert.Equal(t, 0, count)
+
+	// least recently used blocks are evicted
+	cache.Put(v2, 0, []byte("1234"))
+	cache.Put(v2, 1, []byte("5678"))
+	cache.Get(v2, 0)
+	cache.Put(FileVersion{FileId: 2, Mtime: 1, Size: 4}, 0, []byte("wxyz"))
+	_, ok = cache.Get(v2, 1)
+	assert.False(t, ok)
+	_, ok = cache.Get(v2, 0)
+	assert.True(t, ok)
+
+	reloaded, err := NewBlockCache(dir, 10, 4, 0)
+	assert.Nil(t, err)
+	count, size = reloaded.Usage()
+	assert.Equal(t, 2, count)
+	assert.Equal(t, int64(8), size)
+	block, ok = reloaded.Get(v2, 0)
+	assert.True(t, ok)
+	assert.Equal(t, "1234", string(block))
+}
+
+// Testing that blocks are promoted to memory, demoted when memory is full and served without disk I/O
+func TestBlockCacheMemoryTier(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "blockcache")
+	defer os.RemoveAll(dir)
+	cache, err := NewBlockCache(dir, 100, 4, 8)
+	assert.Nil(t, err)
+
+	v := FileVersion{FileId: 1, Mtime: 100, Size: 12}
+	cache.Put(v, 0, []byte("abcd"))
+	cache.Put(v, 1, []byte("efgh"))
+	cache.Put(v, 2, []byte("ijkl"))
+	count, size := cache.MemoryUsage()
+	assert.Equal(t, 2, count)
+	assert.Equal(t, int64(8), size)
+
+	// served from memory even though the file on disk is gone
+	os.Remove(cache.fileName(blockKey{v, 2}))
+	block, ok := cache.Get(v, 2)
+	assert.True(t, ok)
+	assert.Equal(t, "ijkl", string(block))
+
+	// block 0 was demoted, reading it from disk promotes it and demotes block 1
+	block, ok = cache.Get(v, 0)
+	assert.True(t, ok)
+	assert.Equal(t, "abcd", string(block))
+	_, inMemory := cache.memEntries[blockKey{v, 0}]
+	assert.True(t, inMemory)
+	_, inMemory = cache.memEntries[blockKey{v, 1}]
+	assert.False(t, inMemory)
+	count, _ = cache.Usage()
+	assert.Equal(t, 3, count)
+
+	// a new version of the file removes the blocks from memory too
+	cache.Get(FileVersion{FileId: 1, Mtime: 200, Size: 12}, 0)
+	count, size = cache.MemoryUsage()
+	assert.Equal(t, 0, count)
+	assert.Equal(t, int64(0), size)
+}
+
+// Testing that a restarted cache evicts the least recently used blocks of the previous run first
+func TestBlockCacheRecencyAfterRestart(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "blockcache")
+	defer os.RemoveAll(dir)
+	cache, err := NewBlockCache(dir, 100, 4, 4)
+	assert.Nil(t, err)
+
+	v := FileVersion{FileId: 1, Mtime: 100, Size: 12}
+	cache.Put(v, 0, []byte("abcd"))
+	cache.Put(v, 1, []byte("efgh"))
+	cache.Put(v, 2, []byte("ijkl"))
+	for i, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour} {
+		past := time.Now().Add(-age)
+		os.Chtimes(cache.fileName(blockKey{v, int64(i)}), past, past)
+	}
+	// block 0 is read from disk, block 2 is read from memory until the unmount
+	cache.Get(v, 0)
+	cache.Get(v, 2)
+	cache.Get(v, 2)
+	assert.Nil(t, cache.Close())
+
+	reloaded, err := NewBlockCache(dir, 8, 4, 0)
+	assert.Nil(t, err)
+	_, ok := reloaded.Get(v, 1)
+	assert.False(t, ok)
+	_, ok = reloaded.Get(v, 0)
+	assert.True(t, ok)
+	_, ok = reloaded.Get(v, 2)
+	assert.True(t, ok)
+}
+
+type versionedPseudoRandomReader struct {
+	*MockReadSeekCloserWithPseudoRandomContent
+}
+
+func (r versionedPseudoRandomReader) Version() (FileVersion, error) {
+	return FileVersion{FileId: 7, Mtime: 1, Size: r.FileSize}, nil
+}
+
+// Testing that reads through the proxy are served from the block cache
+func TestCachedRemoteRead(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "blockcache")
+	defer os.RemoveAll(dir)
+	cache, _ := NewBlockCache(dir, 1024*1024, 4096, 0)
+	stats := &ReaderStats{}
+	reader := versionedPseudoRandomReader{&MockReadSeekCloserWithPseudoRandomContent{FileSize: 10000, ReaderStats: stats}}
+	fs := &FileSystem{BlockCache: cache, Clock: &MockClock{}}
+	proxy := &RemoteROFileProxy{hdfsReader: reader, file: &FileINode{FileSystem: fs, Attrs: Attrs{Name: "f"}, Parent: &DirINode{FileSystem: fs}}}
+
+	expected := make([]byte, 6000)
+	for i := range expected {
+		expected[i] = generateByteAtOffset(int64(3000 + i))
+	}
+	buf := make([]byte, 6000)
+	n, err := proxy.ReadAt(buf, 3000)
+	assert.Nil(t, err)
+	assert.Equal(t, 6000, n)
+	assert.True(t, bytes.Equal(expected, buf))
+	reads := stats.ReadCount
+
+	n, err = proxy.ReadAt(buf, 3000)
+	assert.Nil(t, err)
+	assert.Equal(t, 6000, n)
+	assert.True(t, bytes.Equal(expected, buf))
+	assert.Equal(t, reads, stats.ReadCount)
+
+	// the last block is shorter
+	n, _ = proxy.ReadAt(buf, 9000)
+	assert.Equal(t, 1000, n)
+}
+
+// Testing that without a directory the blocks are shared by the handles of the mount in memory, up to its size
+func TestMemoryBlockCache(t *testing.T) {
+	cache := NewMemoryBlockCache(4096, 8192)
+	stats := &ReaderStats{}
+	reader := versionedPseudoRandomReader{&MockReadSeekCloserWithPseudoRandomContent{FileSize: 10000, ReaderStats: stats}}
+	fs := &FileSystem{BlockCache: cache, Clock: &MockClock{}}
+	file := &FileINode{FileSystem: fs, Attrs: Attrs{Name: "f"}, Parent: &DirINode{FileSystem: fs}}
+
+	buf := make([]byte, 100)
+	_, err := (&RemoteROFileProxy{hdfsReader: reader, file: file}).ReadAt(buf, 5000)
+	assert.Nil(t, err)
+	reads := stats.ReadCount
+	n, err := (&RemoteROFileProxy{hdfsReader: reader, file: file}).ReadAt(buf, 5000)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, generateByteAtOffset(5000), buf[0])
+	assert.Equal(t, reads, stats.ReadCount)
+
+	cache.Put(FileVersion{FileId: 8, Mtime: 1, Size: 8192}, 0, make([]byte, 4096))
+	cache.Put(FileVersion{FileId: 8, Mtime: 1, Size: 8192}, 1, make([]byte, 4096))
+	count, size := cache.MemoryUsage()
+	assert.Equal(t, 2, count)
+	assert.Equal(t, int64(8192), size)
+	_, ok := cache.Get(FileVersion{FileId: 7, Mtime: 1, Size: 10000}, 1)
+	assert.False(t, ok, "evicted")
+	count, _ = cache.Usage()
+	assert.Equal(t, 0, count)
+}
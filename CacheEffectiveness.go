@@ -0,0 +1,108 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The hit ratios of the caches of the mount are printed by the stats command and logged with
+// every -metricsLogInterval summary, together with tuning hints for the caches which do not
+// pay off for the workload:
+//
+//	attr: attributes of files and directories served without a stat RPC
+//	lookup: names found among the entries of the last listing or lookup of their directory,
+//	        or known not to exist with -negativeLookupTTL
+//	listing: directory listings served from memory, with -listingCacheTTL
+//	block: reads served by -blockCacheDir
+//	readahead: blocks read ahead which were read before they were evicted
+//	footer: reads of the end of columnar files served from memory
+//	reader: read-only opens served by the reader of a closed handle, with -openCoalesceWindow
+//
+// Ratios and hints are only given once a cache saw cacheHintMinSamples operations
+const cacheHintMinSamples = 1000
+
+// Caches, with the operation recording their hits, in the order they are printed
+var cacheRatioOps = []struct {
+	name string
+	op   string
+}{
+	{"attr", AttrCacheOp},
+	{"lookup", Lookup},
+	{"listing", ListingCacheOp},
+	{"block", BlockCacheOp},
+	{"readahead", ReadaheadOp},
+	{"footer", FooterCacheOp},
+	{"reader", ReaderReuseOp},
+}
+
+// Returns the hit ratio of each cache with enough samples, in percent
+func cacheHitRatios(snapshot map[string]OpStats) map[string]float64 {
+	ratios := make(map[string]float64)
+	for _, cache := range cacheRatioOps {
+		hits, total := snapshot[cache.op].CacheHits, snapshot[cache.op].Count
+		if cache.op == ReadaheadOp {
+			// only the hits are recorded as readahead, the blocks read ahead as readahead_fetched
+			hits, total = snapshot[ReadaheadOp].Bytes, snapshot[ReadaheadFetched].Bytes
+			if snapshot[ReadaheadFetched].Count < cacheHintMinSamples {
+				continue
+			}
+		} else if total < cacheHintMinSamples {
+			continue
+		}
+		if total > 0 {
+			ratios[cache.name] = 100 * float64(hits) / float64(total)
+		}
+	}
+	return ratios
+}
+
+// Formats the hit ratios as name=ratio% pairs, "none" if no cache has enough samples
+func formatCacheHitRatios(ratios map[string]float64) string {
+	var pairs []string
+	for _, cache := range cacheRatioOps {
+		if ratio, ok := ratios[cache.name]; ok {
+			pairs = append(pairs, fmt.Sprintf("%s=%.1f%%", cache.name, ratio))
+		}
+	}
+	if len(pairs) == 0 {
+		return "none"
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Returns advice on the configuration of the caches given the metrics of the workload
+func tuningHints(snapshot map[string]OpStats, classes map[string]map[string]uint64) []string {
+	ratios := cacheHitRatios(snapshot)
+	var hints []string
+	if lookups := snapshot[Lookup].Count; lookups >= cacheHintMinSamples {
+		if missing := 100 * float64(classes[Lookup]["ENOENT"]) / float64(lookups); missing >= 30 {
+			cached := "these are not cached and each costs a stat RPC, -negativeLookupTTL caches them"
+			if negativeLookupTTL > 0 {
+				cached = "each costs a stat RPC once -negativeLookupTTL expires"
+			}
+			hints = append(hints, fmt.Sprintf("%.0f%% of the lookups are for names which do not exist, %s. "+
+				"Check search paths pointing into the mount, e.g., PYTHONPATH or LD_LIBRARY_PATH", missing, cached))
+		}
+	}
+	if ratio, ok := ratios["attr"]; ok && ratio < 50 {
+		hints = append(hints, fmt.Sprintf("only %.0f%% of the attribute requests are served from the cache, the attributes are cached for 5s", ratio))
+	}
+	if ratio, ok := ratios["lookup"]; ok && ratio < 50 && snapshot[ReadDir].Count < snapshot[Lookup].Count/100 {
+		hints = append(hints, fmt.Sprintf("only %.0f%% of the lookups are served from the cache and directories are rarely listed, "+
+			"-prefetchPaths lists the directories of the workload into the cache after mounting", ratio))
+	}
+	if ratio, ok := ratios["block"]; ok && ratio < 20 {
+		hints = append(hints, fmt.Sprintf("only %.0f%% of the reads are served by -blockCacheDir, "+
+			"increase -blockCacheSize if files are read again, or disable the cache for a workload reading every file once", ratio))
+	}
+	if ratio, ok := ratios["readahead"]; ok && ratio < 50 && readaheadBlocks > 1 {
+		hints = append(hints, fmt.Sprintf("only %.0f%% of the blocks read ahead are read, lower -readaheadBlocks", ratio))
+	}
+	if ratio, ok := ratios["footer"]; ok && ratio < 50 {
+		hints = append(hints, fmt.Sprintf("only %.0f%% of the reads of file ends are served from memory, "+
+			"increase -footerCacheSize if the footers of the columnar files are larger", ratio))
+	}
+	return hints
+}
@@ -0,0 +1,35 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that hit ratios are only reported with enough samples, and that hints point at the ineffective caches
+func TestCacheHitRatiosAndHints(t *testing.T) {
+	snapshot := map[string]OpStats{
+		AttrCacheOp:      {Count: 2000, CacheHits: 1500},
+		Lookup:           {Count: 1000, CacheHits: 200, Errors: 500},
+		BlockCacheOp:     {Count: 10, CacheHits: 1},
+		ReadaheadOp:      {Count: 100, CacheHits: 100, Bytes: 100},
+		ReadaheadFetched: {Count: 1000, Bytes: 1000},
+		ReadDir:          {Count: 1},
+	}
+	classes := map[string]map[string]uint64{Lookup: {"ENOENT": 400}}
+	ratios := cacheHitRatios(snapshot)
+	assert.Equal(t, "attr=75.0% lookup=20.0% readahead=10.0%", formatCacheHitRatios(ratios))
+	assert.Equal(t, "none", formatCacheHitRatios(cacheHitRatios(map[string]OpStats{})))
+
+	saveFlags(t, &readaheadBlocks)
+	readaheadBlocks = 4
+	hints := strings.Join(tuningHints(snapshot, classes), "\n")
+	assert.Contains(t, hints, "40% of the lookups are for names which do not exist")
+	assert.Contains(t, hints, "-prefetchPaths")
+	assert.NotContains(t, hints, "attribute requests")
+	assert.NotContains(t, hints, "-blockCacheSize")
+	assert.Contains(t, hints, "lower -readaheadBlocks")
+}
@@ -0,0 +1,119 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// Periodically writes, reads back and deletes a small file in HDFS to check that the
+// mount works end to end. Failures, e.g., expired credentials or writes which silently
+// stopped working, are logged and recorded in the metrics as the "canary" operation
+type CanaryMonitor struct {
+	FileSystem *FileSystem
+	Dir        string        // HDFS directory where the canary file is written
+	Interval   time.Duration // time between probes
+	done       chan struct{}
+
+	mutex       sync.Mutex
+	lastSuccess time.Time
+	lastError   error
+}
+
+// Creates the monitor. The canary file name is unique to this host and process
+func NewCanaryMonitor(filesystem *FileSystem, dir string, interval time.Duration) *CanaryMonitor {
+	return &CanaryMonitor{FileSystem: filesystem, Dir: dir, Interval: interval, done: make(chan struct{})}
+}
+
+// Probes until closed
+func (monitor *CanaryMonitor) Run() {
+	for {
+		select {
+		case <-monitor.done:
+			return
+		case <-monitor.FileSystem.Clock.After(monitor.Interval):
+			monitor.Probe()
+		}
+	}
+}
+
+// Stops the monitor
+func (monitor *CanaryMonitor) Close() error {
+	close(monitor.done)
+	return nil
+}
+
+// Returns the time of the last successful probe and the error of the last probe
+func (monitor *CanaryMonitor) Status() (time.Time, error) {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	return monitor.lastSuccess, monitor.lastError
+}
+
+// Performs one probe
+func (monitor *CanaryMonitor) Probe() error {
+	start := monitor.FileSystem.Clock.Now()
+	err := monitor.probe()
+	duration := monitor.FileSystem.Clock.Now().Sub(start)
+	metrics.Record(Canary, duration, 0, 0, false, err)
+
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	monitor.lastError = err
+	if err != nil {
+		logerror("Canary probe failed", Fields{Operation: Canary, Path: monitor.Dir, Duration: duration, Error: err})
+	} else {
+		monitor.lastSuccess = start
+		logdebug("Canary probe succeeded", Fields{Operation: Canary, Path: monitor.Dir, Duration: duration})
+	}
+	return err
+}
+
+func (monitor *CanaryMonitor) probe() error {
+	hostname, _ := os.Hostname()
+	canaryPath := path.Join(monitor.Dir, fmt.Sprintf(".hopsfs-mount-canary-%s-%d", hostname, os.Getpid()))
+	content := []byte(fmt.Sprintf("hopsfs-mount canary %s\n", monitor.FileSystem.Clock.Now().Format(time.RFC3339Nano)))
+	hdfsAccessor := monitor.FileSystem.getDFSConnector()
+
+	w, err := hdfsAccessor.CreateFile(canaryPath, 0600, true)
+	if err != nil {
+		return fmt.Errorf("create %s: %v", canaryPath, err)
+	}
+	if _, err := w.Write(content); err != nil {
+		w.Close()
+		hdfsAccessor.Remove(canaryPath)
+		return fmt.Errorf("write %s: %v", canaryPath, err)
+	}
+	if err := w.Close(); err != nil {
+		hdfsAccessor.Remove(canaryPath)
+		return fmt.Errorf("close %s: %v", canaryPath, err)
+	}
+
+	r, err := hdfsAccessor.OpenRead(canaryPath)
+	if err != nil {
+		hdfsAccessor.Remove(canaryPath)
+		return fmt.Errorf("open %s: %v", canaryPath, err)
+	}
+	buf := make([]byte, len(content)+1)
+	n, err := io.ReadFull(r, buf)
+	r.Close()
+	if err != nil && err != io.ErrUnexpectedEOF {
+		hdfsAccessor.Remove(canaryPath)
+		return fmt.Errorf("read %s: %v", canaryPath, err)
+	}
+	if !bytes.Equal(buf[:n], content) {
+		hdfsAccessor.Remove(canaryPath)
+		return fmt.Errorf("read back %d bytes from %s which do not match the %d bytes written", n, canaryPath, len(content))
+	}
+
+	if err := hdfsAccessor.Remove(canaryPath); err != nil {
+		return fmt.Errorf("remove %s: %v", canaryPath, err)
+	}
+	return nil
+}
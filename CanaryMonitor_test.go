@@ -0,0 +1,51 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the canary probe writes, reads back and deletes the canary file
+func TestCanaryProbe(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	monitor := NewCanaryMonitor(fs, "/canary", 0)
+
+	var written []byte
+	hdfsAccessor.EXPECT().CreateFile(gomock.Any(), gomock.Any(), true).Return(hdfsWriter, nil).Times(2)
+	hdfsWriter.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		written = append([]byte{}, b...)
+		return len(b), nil
+	}).Times(2)
+	hdfsWriter.EXPECT().Close().Return(nil).Times(2)
+	reader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead(gomock.Any()).Return(reader, nil).Times(2)
+	reader.EXPECT().Read(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		return copy(b, written), io.EOF
+	})
+	reader.EXPECT().Close().Return(nil).Times(2)
+	hdfsAccessor.EXPECT().Remove(gomock.Any()).Return(nil).Times(2)
+	assert.Nil(t, monitor.Probe())
+	_, lastErr := monitor.Status()
+	assert.Nil(t, lastErr)
+
+	// the content read back differs from the content written
+	reader.EXPECT().Read(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		return copy(b, "garbage"), io.EOF
+	})
+	assert.NotNil(t, monitor.Probe())
+
+	hdfsAccessor.EXPECT().CreateFile(gomock.Any(), gomock.Any(), true).Return(nil, errors.New("expired"))
+	assert.NotNil(t, monitor.Probe())
+	_, lastErr = monitor.Status()
+	assert.NotNil(t, lastErr)
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Defaults of the file system configured on the namenode
+type ServerDefaults struct {
+	BlockSize           int64
+	Replication         int
+	EncryptDataTransfer bool
+	TrashInterval       time.Duration // fs.trash.interval, 0 if the trash is disabled
+}
+
+// Features of the backend, probed at mount time so that mount features the backend does not
+// support are disabled with a warning instead of failing at first use, e.g., log streaming on
+// a backend without append. The capabilities are logged and printed by the stats command
+type Capabilities struct {
+	Defaults       ServerDefaults
+	XAttrs         bool // extended attributes, used for the I/O class of directories
+	ContentSummary bool // directory totals, used by du, count and the user.hopsfs.* attributes
+	Append         bool // used by log streaming and resumable uploads
+	AppendProbed   bool // false if no directory to probe append in was given, Append is then assumed
+	ErasureCoding  bool // the HDFS client has no erasure coding RPCs, always false, the policies are set over WebHDFS
+	ACLs           bool // served as the POSIX ACL attributes, over WebHDFS since the HDFS client has no ACL RPCs
+}
+
+// Capabilities assumed when the backend is not probed, e.g., with -lazy. ACLs are only assumed
+// with -webhdfsURL, they would otherwise fail with every create
+func assumedCapabilities() *Capabilities {
+	return &Capabilities{XAttrs: true, ContentSummary: true, Append: true, ACLs: webhdfsURL != ""}
+}
+
+// Returns true if the error says that the backend does not implement the RPC
+func isUnsupportedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == syscall.ENOTSUP || err == syscall.ENOSYS {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "UnsupportedOperationException") || strings.Contains(msg, "RpcNoSuchMethodException") ||
+		strings.Contains(msg, "Unknown method")
+}
+
+// Probes the backend. srcDir is the root of the mount, and probeDir, if set, a directory where
+// a file is created, appended to and removed to probe append
+func probeCapabilities(hdfsAccessor HdfsAccessor, srcDir string, probeDir string) *Capabilities {
+	capabilities := &Capabilities{}
+	defaults, err := hdfsAccessor.ServerDefaults()
+	if err != nil {
+		logwarn("Unable to fetch the server defaults", Fields{Operation: CapabilitiesOp, Error: err})
+	}
+	capabilities.Defaults = defaults
+
+	_, err = hdfsAccessor.GetXAttrs(srcDir)
+	capabilities.XAttrs = !isUnsupportedError(err)
+	_, err = hdfsAccessor.GetContentSummary(srcDir)
+	capabilities.ContentSummary = !isUnsupportedError(err)
+	_, err = hdfsAccessor.GetAcl(srcDir)
+	capabilities.ACLs = !isUnsupportedError(err)
+
+	capabilities.Append = true
+	if probeDir != "" {
+		capabilities.AppendProbed = true
+		if err := probeAppend(hdfsAccessor, probeDir); err != nil {
+			logwarn("Append probe failed", Fields{Operation: CapabilitiesOp, Path: probeDir, Error: err})
+			capabilities.Append = !isUnsupportedError(err)
+		}
+	}
+	loginfo("Backend capabilities", Fields{Operation: CapabilitiesOp, Message: capabilities.String()})
+	return capabilities
+}
+
+func probeAppend(hdfsAccessor HdfsAccessor, probeDir string) error {
+	hostname, _ := os.Hostname()
+	probePath := path.Join(probeDir, fmt.Sprintf(".hopsfs-mount-probe-%s-%d", hostname, os.Getpid()))
+	w, err := hdfsAccessor.CreateFile(probePath, 0600, true)
+	if err != nil {
+		return err
+	}
+	defer hdfsAccessor.Remove(probePath)
+	if err := w.Close(); err != nil {
+		return err
+	}
+	w, err = hdfsAccessor.Append(probePath)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{'\n'}); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Disables the features configured for the mount which need capabilities the backend lacks
+func (capabilities *Capabilities) disableUnsupported() {
+	if !capabilities.Append {
+		if logStreamDirs != "" {
+			logwarn("The backend does not support append, -logStreamDirs is disabled", Fields{Operation: CapabilitiesOp})
+			logStreamDirs = ""
+		}
+		if resumableUploadThreshold > 0 {
+			logwarn("The backend does not support append, -resumableUploadThreshold is disabled", Fields{Operation: CapabilitiesOp})
+			resumableUploadThreshold = 0
+		}
+		if deltaUploads {
+			logwarn("The backend does not support append, -deltaUploads is disabled", Fields{Operation: CapabilitiesOp})
+			deltaUploads = false
+		}
+		if appendWrites {
+			logwarn("The backend does not support append, files opened with O_APPEND are rewritten on close", Fields{Operation: CapabilitiesOp})
+			appendWrites = false
+		}
+	}
+	if !capabilities.XAttrs && maxTransfers > 0 {
+		logwarn("The backend does not support extended attributes, the I/O class of directories is not read", Fields{Operation: CapabilitiesOp})
+	}
+}
+
+func (capabilities *Capabilities) String() string {
+	appendSupport := fmt.Sprint(capabilities.Append)
+	if !capabilities.AppendProbed {
+		appendSupport += "(not probed)"
+	}
+	return fmt.Sprintf("block_size=%d replication=%d encrypt_data_transfer=%v trash_interval=%v xattrs=%v content_summary=%v append=%s erasure_coding=%v acls=%v",
+		capabilities.Defaults.BlockSize, capabilities.Defaults.Replication, capabilities.Defaults.EncryptDataTransfer,
+		capabilities.Defaults.TrashInterval, capabilities.XAttrs, capabilities.ContentSummary, appendSupport, capabilities.ErasureCoding, capabilities.ACLs)
+}
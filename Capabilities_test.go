@@ -0,0 +1,59 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that features whose RPCs the backend does not implement are disabled
+func TestProbeCapabilities(t *testing.T) {
+	saveFlags(t, &logStreamDirs, &resumableUploadThreshold)
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	unsupported := errors.New("org.apache.hadoop.ipc.RpcNoSuchMethodException: Unknown method append called")
+	hdfsAccessor.EXPECT().ServerDefaults().Return(ServerDefaults{BlockSize: 128 << 20, Replication: 3, TrashInterval: time.Hour}, nil)
+	hdfsAccessor.EXPECT().GetXAttrs("/data").Return(map[string]string{}, nil)
+	hdfsAccessor.EXPECT().GetContentSummary("/data").Return(ContentSummary{}, errors.New("java.lang.UnsupportedOperationException"))
+	hdfsAccessor.EXPECT().GetAcl("/data").Return(nil, nil)
+	writer := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().CreateFile(gomock.Any(), gomock.Any(), true).Return(writer, nil)
+	writer.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Append(gomock.Any()).Return(nil, unsupported)
+	hdfsAccessor.EXPECT().Remove(gomock.Any()).Return(nil)
+
+	capabilities := probeCapabilities(hdfsAccessor, "/data", "/tmp")
+	assert.Equal(t, int64(128<<20), capabilities.Defaults.BlockSize)
+	assert.True(t, capabilities.XAttrs)
+	assert.False(t, capabilities.ContentSummary)
+	assert.True(t, capabilities.ACLs)
+	assert.False(t, capabilities.Append)
+	assert.True(t, capabilities.AppendProbed)
+	assert.Contains(t, capabilities.String(), "trash_interval=1h0m0s xattrs=true content_summary=false append=false")
+
+	saveFlags(t, &appendWrites)
+	logStreamDirs = "/logs"
+	resumableUploadThreshold = 1024
+	appendWrites = true
+	capabilities.disableUnsupported()
+	assert.Equal(t, "", logStreamDirs)
+	assert.Equal(t, int64(0), resumableUploadThreshold)
+	assert.False(t, appendWrites)
+
+	// without a probe directory append is assumed
+	hdfsAccessor.EXPECT().ServerDefaults().Return(ServerDefaults{}, nil)
+	hdfsAccessor.EXPECT().GetXAttrs("/").Return(nil, errors.New("java.lang.UnsupportedOperationException"))
+	hdfsAccessor.EXPECT().GetContentSummary("/").Return(ContentSummary{}, nil)
+	hdfsAccessor.EXPECT().GetAcl("/").Return(nil, syscall.ENOTSUP)
+	capabilities = probeCapabilities(hdfsAccessor, "/", "")
+	assert.False(t, capabilities.XAttrs)
+	assert.False(t, capabilities.ACLs)
+	assert.True(t, capabilities.Append)
+	assert.Contains(t, capabilities.String(), "append=true(not probed)")
+}
@@ -0,0 +1,185 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// df, and every tool calling statfs before a write, e.g., to check the free space, asked the
+// namenode for the usage of the cluster. With -capacityInterval, a monitor polls the usage of
+// the cluster, and the usage of the quotas applying to the mounted directory, at that interval,
+// and statfs is answered from the last poll. The usage is reported by the status command. Once
+// the cluster or a quota is -capacityWarningPercent used, a warning is logged, recorded in the
+// metrics as the "capacity_warning" operation and, with -capacityWebhook, posted as JSON to
+// that URL, e.g., a chat or alerting webhook. Another alert is only raised after the usage went
+// back below the threshold, which is also posted
+var capacityInterval time.Duration
+var capacityWarningPercent float64
+var capacityWebhook string
+
+// Alert posted to -capacityWebhook
+type CapacityAlert struct {
+	MountPoint string  `json:"mount_point"`
+	SrcDir     string  `json:"src_dir"`
+	Kind       string  `json:"kind"` // cluster, or the kind of the quota: space or names
+	Path       string  `json:"path,omitempty"`
+	Percent    float64 `json:"percent"`
+	Resolved   bool    `json:"resolved"` // the usage went back below the threshold
+}
+
+// Polls the usage of HDFS for statfs and raises the capacity alerts
+type CapacityMonitor struct {
+	FileSystem *FileSystem
+	Interval   time.Duration
+	client     *http.Client
+	done       chan struct{}
+
+	mutex   sync.Mutex
+	info    FsInfo
+	polled  bool
+	quota   QuotaUsage
+	summary *ContentSummary // of the closest quota of the source dir, for -statfsQuota
+	alerted map[string]bool // usages above the threshold at the last poll, cluster or quota
+}
+
+// Creates the monitor
+func NewCapacityMonitor(filesystem *FileSystem, interval time.Duration) *CapacityMonitor {
+	return &CapacityMonitor{FileSystem: filesystem, Interval: interval, done: make(chan struct{}),
+		client: &http.Client{Timeout: 10 * time.Second}, alerted: map[string]bool{}}
+}
+
+// Polls until closed
+func (monitor *CapacityMonitor) Run() {
+	for {
+		monitor.Poll()
+		select {
+		case <-monitor.done:
+			return
+		case <-monitor.FileSystem.Clock.After(monitor.Interval):
+		}
+	}
+}
+
+// Stops the monitor
+func (monitor *CapacityMonitor) Close() error {
+	close(monitor.done)
+	return nil
+}
+
+// Returns the usage of HDFS of the last poll, false before the first successful poll
+func (monitor *CapacityMonitor) FsInfo() (FsInfo, bool) {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	return monitor.info, monitor.polled
+}
+
+// Returns the content summary of the closest quota of the source dir of the last poll, false if it failed
+func (monitor *CapacityMonitor) Summary() (ContentSummary, bool) {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	if monitor.summary == nil {
+		return ContentSummary{}, false
+	}
+	return *monitor.summary, true
+}
+
+// Polls the usage of the cluster and of the quotas once
+func (monitor *CapacityMonitor) Poll() error {
+	info, err := monitor.FileSystem.getDFSConnector().StatFs()
+	if err != nil {
+		logwarn("Failed to poll the capacity of HDFS", Fields{Operation: StatFS, Error: err})
+		return err
+	}
+	quota := QuotaUsage{}
+	var summary *ContentSummary
+	if monitor.FileSystem.Capabilities.ContentSummary {
+		if cs, dir, err := closestQuotaSummary(monitor.FileSystem.getDFSConnector(), monitor.FileSystem.SrcDir); err != nil {
+			logdebug("Unable to check the quota usage", Fields{Operation: GetContentSummary, Path: monitor.FileSystem.SrcDir, Error: err})
+		} else {
+			if percent, kind := summaryQuotaUsage(cs); kind != "" {
+				quota = QuotaUsage{Percent: percent, Kind: kind, Dir: dir}
+			}
+			summary = &cs
+		}
+	}
+
+	monitor.mutex.Lock()
+	monitor.info, monitor.polled, monitor.quota, monitor.summary = info, true, quota, summary
+	monitor.mutex.Unlock()
+
+	monitor.check("cluster", CapacityAlert{Kind: "cluster", Percent: usedPercent(info)})
+	if quota.Kind != "" {
+		monitor.check("quota", CapacityAlert{Kind: quota.Kind, Path: quota.Dir, Percent: quota.Percent})
+	}
+	return nil
+}
+
+// Returns the percentage of the capacity of the cluster which is used
+func usedPercent(info FsInfo) float64 {
+	if info.capacity == 0 {
+		return 0
+	}
+	return 100 * float64(info.used) / float64(info.capacity)
+}
+
+// Raises the alert if the usage crossed the threshold since the last poll of the usage
+func (monitor *CapacityMonitor) check(usage string, alert CapacityAlert) {
+	if capacityWarningPercent <= 0 {
+		return
+	}
+	above := alert.Percent >= capacityWarningPercent
+	monitor.mutex.Lock()
+	changed := monitor.alerted[usage] != above
+	monitor.alerted[usage] = above
+	monitor.mutex.Unlock()
+	if !changed {
+		return
+	}
+	alert.Resolved = !above
+	if above {
+		logwarn(fmt.Sprintf("HDFS %s usage is %.1f%%", alert.Kind, alert.Percent), Fields{Operation: CapacityWarning, Path: alert.Path})
+		metrics.Record(CapacityWarning, 0, 0, 0, false, nil)
+	} else {
+		loginfo(fmt.Sprintf("HDFS %s usage is back to %.1f%%", alert.Kind, alert.Percent), Fields{Operation: CapacityWarning, Path: alert.Path})
+	}
+	if capacityWebhook != "" {
+		alert.MountPoint, alert.SrcDir = monitor.FileSystem.MountPoint, monitor.FileSystem.SrcDir
+		if err := monitor.post(alert); err != nil {
+			logwarn("Failed to post the capacity alert", Fields{Operation: CapacityWarning, Error: err})
+		}
+	}
+}
+
+// Posts the alert to -capacityWebhook
+func (monitor *CapacityMonitor) post(alert CapacityAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	resp, err := monitor.client.Post(capacityWebhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", capacityWebhook, resp.Status)
+	}
+	return nil
+}
+
+// Returns the usage of the last poll for the status command, nil before the first poll
+func (monitor *CapacityMonitor) status() *CapacityStatus {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	if !monitor.polled {
+		return nil
+	}
+	return &CapacityStatus{Capacity: monitor.info.capacity, Used: monitor.info.used, Remaining: monitor.info.remaining,
+		UsedPercent: usedPercent(monitor.info), Quota: monitor.quota.String()}
+}
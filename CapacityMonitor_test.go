@@ -0,0 +1,50 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that statfs is answered from the last poll and that alerts are posted once per crossing
+func TestCapacityMonitor(t *testing.T) {
+	saveFlags(t, &capacityWarningPercent, &capacityWebhook)
+	var alerts []CapacityAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert CapacityAlert
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&alert))
+		alerts = append(alerts, alert)
+	}))
+	defer server.Close()
+	capacityWarningPercent, capacityWebhook = 90, server.URL
+
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.Capabilities.ContentSummary = false
+	monitor := NewCapacityMonitor(fs, 0)
+	fs.Capacity = monitor
+
+	full := FsInfo{capacity: 100 * 1024, used: 95 * 1024, remaining: 5 * 1024}
+	hdfsAccessor.EXPECT().StatFs().Return(full, nil).Times(2)
+	assert.Nil(t, monitor.Poll())
+	assert.Nil(t, monitor.Poll())
+	resp := &fuse.StatfsResponse{}
+	assert.Nil(t, fs.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(5), resp.Bfree)
+	assert.Equal(t, []CapacityAlert{{SrcDir: "/", Kind: "cluster", Percent: 95}}, alerts)
+	assert.Equal(t, 95.0, fs.status().Capacity.UsedPercent)
+
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 100 * 1024, used: 50 * 1024, remaining: 50 * 1024}, nil)
+	assert.Nil(t, monitor.Poll())
+	assert.Equal(t, 2, len(alerts))
+	assert.True(t, alerts[1].Resolved)
+}
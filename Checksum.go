@@ -0,0 +1,103 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// HDFS checksum of a file together with the parameters needed to compute it for local content
+type FileChecksum struct {
+	Checksum         []byte // MD5 of the MD5s of the CRCs of the blocks, as "hdfs dfs -checksum"
+	Size             int64
+	BlockSize        int64
+	BytesPerChecksum int
+}
+
+// Returns true if the content read from r has the HDFS checksum of the remote file.
+// The remote file may have been written with CRC32 (e.g., by this client) or with
+// CRC32C (e.g., by the Java client), the local checksums are computed for both in one pass.
+// Files with variable sized blocks, e.g., appended to, never match.
+func (remote FileChecksum) Matches(r io.Reader) (bool, error) {
+	if remote.BlockSize <= 0 || remote.BytesPerChecksum <= 0 {
+		return false, nil
+	}
+	tables := []*crc32.Table{crc32.IEEETable, crc32.MakeTable(crc32.Castagnoli)}
+	fileMD5 := []hash.Hash{md5.New(), md5.New()}
+	chunk := make([]byte, remote.BytesPerChecksum)
+	crc := make([]byte, 4)
+	// Hadoop pads the block MD5s with zeros to the next power of 2, with a minimum of 32 bytes
+	paddedLength, totalLength := 32, 0
+	eof := false
+	for !eof {
+		blockMD5 := []hash.Hash{md5.New(), md5.New()}
+		var inBlock int64
+		for inBlock < remote.BlockSize {
+			size := int64(len(chunk))
+			if remote.BlockSize-inBlock < size {
+				size = remote.BlockSize - inBlock
+			}
+			n, err := io.ReadFull(r, chunk[:size])
+			if n > 0 {
+				for i, table := range tables {
+					binary.BigEndian.PutUint32(crc, crc32.Checksum(chunk[:n], table))
+					blockMD5[i].Write(crc)
+				}
+				inBlock += int64(n)
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+				break
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+		if inBlock == 0 {
+			break
+		}
+		for i := range fileMD5 {
+			fileMD5[i].Write(blockMD5[i].Sum(nil))
+		}
+		totalLength += md5.Size
+		if paddedLength < totalLength {
+			paddedLength *= 2
+		}
+	}
+	for _, h := range fileMD5 {
+		h.Write(make([]byte, paddedLength-totalLength))
+		if bytes.Equal(h.Sum(nil), remote.Checksum) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns true if both readers return the same bytes
+func sameContent(a io.Reader, b io.Reader) (bool, error) {
+	bufA := make([]byte, 65536)
+	bufB := make([]byte, 65536)
+	for {
+		na, errA := io.ReadFull(a, bufA)
+		nb, errB := io.ReadFull(b, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if endA || endB {
+			return endA && endB, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
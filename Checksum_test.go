@@ -0,0 +1,44 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing the local checksum against the checksum reported by HDFS for "bar\n"
+func TestChecksumMatches(t *testing.T) {
+	sum, _ := hex.DecodeString("27c076e4987344253650d3335a5d08ce")
+	remote := FileChecksum{Checksum: sum, Size: 4, BlockSize: 64 * 1024 * 1024, BytesPerChecksum: 512}
+
+	matches, err := remote.Matches(strings.NewReader("bar\n"))
+	assert.Nil(t, err)
+	assert.True(t, matches)
+
+	matches, err = remote.Matches(strings.NewReader("baz\n"))
+	assert.Nil(t, err)
+	assert.False(t, matches)
+
+	matches, _ = FileChecksum{Checksum: sum}.Matches(strings.NewReader("bar\n"))
+	assert.False(t, matches)
+}
+
+func TestSameContent(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 20000)
+	equal, err := sameContent(bytes.NewReader(data), bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.True(t, equal)
+
+	equal, _ = sameContent(bytes.NewReader(data), bytes.NewReader(data[:len(data)-1]))
+	assert.False(t, equal)
+
+	changed := append([]byte{}, data...)
+	changed[150000] = 'x'
+	equal, _ = sameContent(bytes.NewReader(data), bytes.NewReader(changed))
+	assert.False(t, equal)
+}
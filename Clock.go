@@ -8,10 +8,17 @@ import (
 )
 
 // Interface to get wall clock time
-// (taking an indirection makes unit testing easier)
+// (taking an indirection makes unit testing easier).
+// Wall clock time is for timestamps, and monotonic time for expiry of cached entries, so that
+// NTP setting the clock back or forward neither keeps nor drops all of them at once. Times set
+// by other hosts, e.g., modification times by the namenode, are compared with local times
+// allowing for -clockSkewTolerance
 type Clock interface {
 	Now() time.Time
 	After(d time.Duration) <-chan time.Time
+	// Time elapsed since an arbitrary point, which does not jump when the wall clock is set,
+	// e.g., by NTP. Expiry of cached entries is based on it
+	Monotonic() time.Duration
 }
 
 type WallClock struct{}
@@ -28,3 +35,11 @@ func (WallClock) Now() time.Time {
 func (WallClock) After(d time.Duration) <-chan time.Time {
 	return time.After(d)
 }
+
+// Start of the monotonic clock, time.Since uses the monotonic reading of it
+var monotonicOrigin = time.Now()
+
+// Returns the monotonic time since the process started
+func (WallClock) Monotonic() time.Duration {
+	return time.Since(monotonicOrigin)
+}
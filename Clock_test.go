@@ -9,6 +9,7 @@ import (
 
 type MockClock struct {
 	now               time.Time
+	monotonic         time.Duration
 	LastSleepDuration time.Duration
 }
 
@@ -28,7 +29,18 @@ func (mc *MockClock) After(d time.Duration) <-chan time.Time {
 	return c
 }
 
+// Returns the time elapsed by NotifyTimeElapsed
+func (mc *MockClock) Monotonic() time.Duration {
+	return mc.monotonic
+}
+
 // Tells mock clock about time progression
 func (mc *MockClock) NotifyTimeElapsed(d time.Duration) {
 	mc.now = mc.Now().Add(d)
+	mc.monotonic += d
+}
+
+// Sets the wall clock forward or back, as NTP would, without time progression
+func (mc *MockClock) JumpWallClock(d time.Duration) {
+	mc.now = mc.Now().Add(d)
 }
@@ -0,0 +1,29 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+// Hadoop output committers, and Spark through them, write the output of a job into a
+// _temporary directory below the output directory and promote it with renames once the task
+// or job commits. Through the mount every promotion is a single rename RPC, the open files of
+// the renamed subtree are re-tagged so that their data is uploaded to the promoted path, and
+// the cached entry of a replaced target is dropped. With -hideTemporaryDirs the _temporary
+// directories are omitted from listings, so that readers listing the output directory, e.g., a
+// downstream job polling for new partitions, do not see uncommitted output. They can still be
+// looked up by name, so the job writing them is not affected
+const temporaryDirName = "_temporary"
+
+// Returns true if the entry is omitted from the listings of its directory
+func hiddenFromListing(name string) bool {
+	return (hideTemporaryDirs && name == temporaryDirName) || hiddenCrcSidecar(name)
+}
+
+// Re-tags the staging files of the files open in the cached subtree after it was renamed
+func (dir *DirINode) retagStagingBelow() {
+	for _, node := range dir.cachedEntries() {
+		if fnode, ok := node.(*FileINode); ok && fnode.countActiveHandles() > 0 {
+			fnode.retagStaging()
+		} else if dnode, ok := node.(*DirINode); ok {
+			dnode.retagStagingBelow()
+		}
+	}
+}
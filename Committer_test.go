@@ -0,0 +1,40 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that _temporary is omitted from listings but can be looked up, and that promotion fixes up the cache
+func TestTemporaryDirPromotion(t *testing.T) {
+	saveFlags(t, &hideTemporaryDirs)
+	hideTemporaryDirs = true
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	out := root.(*DirINode).NodeFromAttrs(Attrs{Name: "out", Mode: os.ModeDir | 0755}).(*DirINode)
+
+	hdfsAccessor.EXPECT().ReadDir("/out").Return([]Attrs{
+		{Name: "_temporary", Mode: os.ModeDir | 0755},
+		{Name: "part-0", Mode: 0644},
+	}, nil)
+	entries, err := out.ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "part-0", entries[0].Name)
+	temporary, err := out.Lookup(nil, "_temporary")
+	assert.Nil(t, err)
+
+	// promoting a task output which is not cached replaces the cached part-0
+	hdfsAccessor.EXPECT().Rename("/out/_temporary/part-0", "/out/part-0").Return(nil)
+	assert.Nil(t, temporary.(*DirINode).Rename(nil, &fuse.RenameRequest{OldName: "part-0", NewName: "part-0"}, out))
+	assert.Nil(t, out.cachedEntry("part-0"))
+}
@@ -0,0 +1,124 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Every option can also be set in a config file given by -config or $HOPSFS_MOUNT_CONFIG,
+// and in an environment variable named after the option, e.g., $HOPSFS_MOUNT_BLOCK_CACHE_DIR
+// for -blockCacheDir. Options on the command line take precedence over the environment,
+// which takes precedence over the config file. The file is TOML, or YAML if its name ends
+// with .yaml or .yml, with one key per option, e.g., blockCacheDir = "/data/cache".
+// Lists are joined with commas for the options taking comma separated lists
+const configEnvPrefix = "HOPSFS_MOUNT_"
+
+var configFile string
+
+// Returns the environment variable of an option, e.g., HOPSFS_MOUNT_ROOT_CA_BUNDLE for rootCABundle
+func configEnvVar(option string) string {
+	runes := []rune(option)
+	var name strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) &&
+			(!unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			name.WriteByte('_')
+		}
+		name.WriteRune(unicode.ToUpper(r))
+	}
+	return configEnvPrefix + name.String()
+}
+
+// Sets the options which are not given on the command line from the environment and from
+// the config file. Returns an error naming the offending option for unknown options and
+// invalid values
+func applyConfig(flags *flag.FlagSet) error {
+	onCommandLine := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	path := configFile
+	if !onCommandLine["config"] {
+		path = os.Getenv(configEnvVar("config"))
+	}
+	settings := make(map[string]string)
+	if path != "" {
+		var err error
+		if settings, err = readConfigFile(path); err != nil {
+			return err
+		}
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" || flags.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown option %q, the keys are the names of the options without the leading -", path, name)
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || onCommandLine[f.Name] || f.Name == "config" {
+			return
+		}
+		if value, ok := os.LookupEnv(configEnvVar(f.Name)); ok {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q of $%s for -%s: %v", value, configEnvVar(f.Name), f.Name, setErr)
+			}
+		} else if value, ok := settings[f.Name]; ok {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("%s: invalid value %q for %s: %v", path, value, f.Name, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// Returns the options set in a TOML or YAML config file as the strings given on the command line
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	default:
+		err = toml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	settings := make(map[string]string, len(values))
+	for name, value := range values {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			return nil, fmt.Errorf("%s: %s must be a value, not a table", path, name)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			settings[name] = strings.Join(items, ",")
+		default:
+			settings[name] = fmt.Sprint(v)
+		}
+	}
+	return settings, nil
+}
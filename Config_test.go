@@ -0,0 +1,69 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigEnvVar(t *testing.T) {
+	assert.Equal(t, "HOPSFS_MOUNT_BLOCK_CACHE_DIR", configEnvVar("blockCacheDir"))
+	assert.Equal(t, "HOPSFS_MOUNT_ROOT_CA_BUNDLE", configEnvVar("rootCABundle"))
+	assert.Equal(t, "HOPSFS_MOUNT_GROUP_CACHE_TTL", configEnvVar("groupCacheTTL"))
+	assert.Equal(t, "HOPSFS_MOUNT_TLS", configEnvVar("tls"))
+}
+
+// Testing that the command line takes precedence over the environment, and the environment
+// over the config file
+func TestApplyConfig(t *testing.T) {
+	saveFlags(t, &configFile)
+	dir, _ := ioutil.TempDir("", "config")
+	defer os.RemoveAll(dir)
+	toml := filepath.Join(dir, "mount.toml")
+	ioutil.WriteFile(toml, []byte("stageDir = [\"/a\", \"/b\"]\nlogLevel = \"info\"\ntls = true\nretryTimeLimit = \"1m\"\n"), 0600)
+	yaml := filepath.Join(dir, "mount.yaml")
+	ioutil.WriteFile(yaml, []byte("logLevel: debug\nnumConnections: 4\n"), 0600)
+
+	newFlags := func() (*flag.FlagSet, *string, *string, *bool, *int, *time.Duration) {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags.StringVar(&configFile, "config", "", "")
+		return flags, flags.String("stageDir", "/tmp", ""), flags.String("logLevel", "error", ""),
+			flags.Bool("tls", false, ""), flags.Int("numConnections", 1, ""), flags.Duration("retryTimeLimit", 0, "")
+	}
+
+	flags, stageDir, logLevel, tls, _, timeLimit := newFlags()
+	flags.Parse([]string{"-config", toml, "-logLevel", "warn"})
+	os.Setenv("HOPSFS_MOUNT_TLS", "false")
+	defer os.Unsetenv("HOPSFS_MOUNT_TLS")
+	assert.Nil(t, applyConfig(flags))
+	assert.Equal(t, "/a,/b", *stageDir)
+	assert.Equal(t, "warn", *logLevel)
+	assert.False(t, *tls)
+	assert.Equal(t, time.Minute, *timeLimit)
+
+	// the config file given by the environment
+	flags, _, logLevel, _, connections, _ := newFlags()
+	flags.Parse(nil)
+	os.Setenv("HOPSFS_MOUNT_CONFIG", yaml)
+	defer os.Unsetenv("HOPSFS_MOUNT_CONFIG")
+	assert.Nil(t, applyConfig(flags))
+	assert.Equal(t, "debug", *logLevel)
+	assert.Equal(t, 4, *connections)
+
+	ioutil.WriteFile(yaml, []byte("logLevl: debug\n"), 0600)
+	flags, _, _, _, _, _ = newFlags()
+	flags.Parse(nil)
+	assert.EqualError(t, applyConfig(flags), yaml+`: unknown option "logLevl", the keys are the names of the options without the leading -`)
+
+	ioutil.WriteFile(yaml, []byte("numConnections: many\n"), 0600)
+	flags, _, _, _, _, _ = newFlags()
+	flags.Parse(nil)
+	assert.Contains(t, applyConfig(flags).Error(), `invalid value "many" for numConnections`)
+}
@@ -0,0 +1,311 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// The -numConnections connections with the namenode are pooled: each operation checks out an
+// idle connection for its duration, the healthy ones first, and waits for one to be released if
+// they are all busy. A connection whose operation fails with an error of the connection, rather
+// than of the operation, e.g., ENOENT, is closed and only used again after
+// -connectionRecheckInterval or if no other connection is healthy, and reconnects on its next
+// operation, while the retry goes to another connection. Readers and writers keep the connection
+// they were opened with until they are closed
+var connectionRecheckInterval time.Duration
+
+// Hands out the connections with the namenode, see above
+// Concurrency: thread safe
+type ConnectionPool struct {
+	Connections []HdfsAccessor
+	Clock       Clock
+
+	mutex    sync.Mutex
+	released *sync.Cond  // signalled when a connection is released
+	busy     []bool      // checked out by an operation
+	failedAt []time.Time // last failure of each connection, zero once it succeeded again
+	next     int         // first connection tried by the next checkout, so that the load is spread
+}
+
+var _ HdfsAccessor = (*ConnectionPool)(nil) // ensure ConnectionPool implements HdfsAccessor
+
+// Creates the pool of the connections
+func NewConnectionPool(connections []HdfsAccessor, clock Clock) *ConnectionPool {
+	pool := &ConnectionPool{Connections: connections, Clock: clock,
+		busy: make([]bool, len(connections)), failedAt: make([]time.Time, len(connections))}
+	pool.released = sync.NewCond(&pool.mutex)
+	return pool
+}
+
+// Returns true if the connection did not fail within -connectionRecheckInterval. Called with the mutex held
+func (pool *ConnectionPool) healthy(i int) bool {
+	return pool.failedAt[i].IsZero() || pool.Clock.Now().Sub(pool.failedAt[i]) >= connectionRecheckInterval
+}
+
+// Checks out an idle connection: a healthy one, else an unhealthy one if no healthy connection
+// is busy, else waits for a connection to be released
+func (pool *ConnectionPool) checkout() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	for {
+		unhealthy, healthyBusy := -1, false
+		for n := range pool.Connections {
+			i := (pool.next + n) % len(pool.Connections)
+			if pool.healthy(i) {
+				if !pool.busy[i] {
+					pool.busy[i], pool.next = true, i+1
+					return i
+				}
+				healthyBusy = true
+			} else if !pool.busy[i] && unhealthy < 0 {
+				unhealthy = i
+			}
+		}
+		if unhealthy >= 0 && !healthyBusy {
+			pool.busy[unhealthy], pool.next = true, unhealthy+1
+			return unhealthy
+		}
+		pool.released.Wait()
+	}
+}
+
+// Releases the connection after its operation. A connection whose operation failed is closed,
+// it reconnects on its next operation
+func (pool *ConnectionPool) release(i int, err error) error {
+	pool.mutex.Lock()
+	failed := !IsSuccessOrNonRetriableError(err)
+	wasHealthy := pool.failedAt[i].IsZero()
+	if failed {
+		pool.failedAt[i] = pool.Clock.Now()
+	} else {
+		pool.failedAt[i] = time.Time{}
+	}
+	pool.mutex.Unlock()
+
+	if failed {
+		if wasHealthy {
+			logwarn(fmt.Sprintf("Connection %d failed, the next operations use the other connections", i), Fields{Operation: Connect, Error: err})
+		}
+		pool.Connections[i].Close()
+	} else if !wasHealthy {
+		loginfo(fmt.Sprintf("Connection %d recovered", i), Fields{Operation: Connect})
+	}
+
+	pool.mutex.Lock()
+	pool.busy[i] = false
+	pool.released.Signal()
+	pool.mutex.Unlock()
+	return err
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (pool *ConnectionPool) EnsureConnected() error {
+	i := pool.checkout()
+	return pool.release(i, pool.Connections[i].EnsureConnected())
+}
+
+// Opens HDFS file for reading
+func (pool *ConnectionPool) OpenRead(path string) (ReadSeekCloser, error) {
+	i := pool.checkout()
+	reader, err := pool.Connections[i].OpenRead(path)
+	return reader, pool.release(i, err)
+}
+
+// Opens HDFS file for writing
+func (pool *ConnectionPool) CreateFile(path string, mode os.FileMode, overwrite bool) (HdfsWriter, error) {
+	i := pool.checkout()
+	writer, err := pool.Connections[i].CreateFile(path, mode, overwrite)
+	return writer, pool.release(i, err)
+}
+
+// Opens HDFS file for appending
+func (pool *ConnectionPool) Append(path string) (HdfsWriter, error) {
+	i := pool.checkout()
+	writer, err := pool.Connections[i].Append(path)
+	return writer, pool.release(i, err)
+}
+
+// Enumerates HDFS directory
+func (pool *ConnectionPool) ReadDir(path string) ([]Attrs, error) {
+	i := pool.checkout()
+	entries, err := pool.Connections[i].ReadDir(path)
+	return entries, pool.release(i, err)
+}
+
+// Retrieves file/directory attributes
+func (pool *ConnectionPool) Stat(path string) (Attrs, error) {
+	i := pool.checkout()
+	attrs, err := pool.Connections[i].Stat(path)
+	return attrs, pool.release(i, err)
+}
+
+// Retrieves HDFS usage
+func (pool *ConnectionPool) StatFs() (FsInfo, error) {
+	i := pool.checkout()
+	info, err := pool.Connections[i].StatFs()
+	return info, pool.release(i, err)
+}
+
+// Creates a directory
+func (pool *ConnectionPool) Mkdir(path string, mode os.FileMode) error {
+	i := pool.checkout()
+	return pool.release(i, pool.Connections[i].Mkdir(path, mode))
+}
+
+// Removes a file or directory
+func (pool *ConnectionPool) Remove(path string) error {
+	i := pool.checkout()
+	return pool.release(i, pool.Connections[i].Remove(path))
+}
+
+// Removes a file or directory recursively
+func (pool *ConnectionPool) RemoveAll(path string) error {
+	i := pool.checkout()
+	return pool.release(i, pool.Connections[i].RemoveAll(path))
+}
+
+// Renames a file or directory
+func (pool *ConnectionPool) Rename(oldPath string, newPath string) error {
+	i := pool.checkout()
+	return pool.release(i, pool.Connections[i].Rename(oldPath, newPath))
+}
+
+// Changes the owner and group of the file
+func (pool *ConnectionPool) Chown(path string, owner, group string) error {
+	i := pool.checkout()
+	return pool.release(i, pool.Connections[i].Chown(path, owner, group))
+}
+
+// Changes the mode of the file
+func (pool *ConnectionPool) Chmod(path string, mode os.FileMode) error {
+	i := pool.checkout()
+	return pool.release(i, pool.Connections[i].Chmod(path, mode))
+}
+
+// Changes the modification time of the file
+func (pool *ConnectionPool) Chtimes(path string, mtime time.Time) error {
+	i := pool.checkout()
+	return pool.release(i, pool.Connections[i].Chtimes(path, mtime))
+}
+
+// Retrieves the HDFS checksum of the file
+func (pool *ConnectionPool) Checksum(path string) (FileChecksum, error) {
+	i := pool.checkout()
+	checksum, err := pool.Connections[i].Checksum(path)
+	return checksum, pool.release(i, err)
+}
+
+// Retrieves the extended attributes of the file
+func (pool *ConnectionPool) GetXAttrs(path string) (map[string]string, error) {
+	i := pool.checkout()
+	xattrs, err := pool.Connections[i].GetXAttrs(path)
+	return xattrs, pool.release(i, err)
+}
+
+// Creates or replaces an extended attribute of the file
+func (pool *ConnectionPool) SetXAttr(path, name, value string) error {
+	i := pool.checkout()
+	return pool.release(i, pool.Connections[i].SetXAttr(path, name, value))
+}
+
+// Retrieves the ACL entries of the file which are not in its mode
+func (pool *ConnectionPool) GetAcl(path string) ([]AclEntry, error) {
+	i := pool.checkout()
+	entries, err := pool.Connections[i].GetAcl(path)
+	return entries, pool.release(i, err)
+}
+
+// Replaces the access and default ACL of the file
+func (pool *ConnectionPool) SetAcl(path string, entries []AclEntry) error {
+	i := pool.checkout()
+	return pool.release(i, pool.Connections[i].SetAcl(path, entries))
+}
+
+// Sets the storage policy of the file, or unsets it if empty
+func (pool *ConnectionPool) SetStoragePolicy(path, policy string) error {
+	i := pool.checkout()
+	return pool.release(i, pool.Connections[i].SetStoragePolicy(path, policy))
+}
+
+// Sets the erasure coding policy of the directory, or unsets it if empty
+func (pool *ConnectionPool) SetErasureCodingPolicy(path, policy string) error {
+	i := pool.checkout()
+	return pool.release(i, pool.Connections[i].SetErasureCodingPolicy(path, policy))
+}
+
+// Retrieves the totals of a directory tree
+func (pool *ConnectionPool) GetContentSummary(path string) (ContentSummary, error) {
+	i := pool.checkout()
+	summary, err := pool.Connections[i].GetContentSummary(path)
+	return summary, pool.release(i, err)
+}
+
+// Retrieves the configuration of the namenode
+func (pool *ConnectionPool) ServerDefaults() (ServerDefaults, error) {
+	i := pool.checkout()
+	defaults, err := pool.Connections[i].ServerDefaults()
+	return defaults, pool.release(i, err)
+}
+
+// Creates a snapshot of a snapshottable directory
+func (pool *ConnectionPool) CreateSnapshot(path, name string) (string, error) {
+	i := pool.checkout()
+	snapshot, err := pool.Connections[i].CreateSnapshot(path, name)
+	return snapshot, pool.release(i, err)
+}
+
+// Truncates the file
+func (pool *ConnectionPool) Truncate(path string, size int64) (bool, error) {
+	i := pool.checkout()
+	done, err := pool.Connections[i].Truncate(path, size)
+	return done, pool.release(i, err)
+}
+
+// Does nothing: the connection of a failed operation is closed when it is released, and the
+// others are kept until PoolShutdown closes them at unmount
+func (pool *ConnectionPool) Close() error {
+	return nil
+}
+
+// Returns the number of connections which failed within -connectionRecheckInterval
+func (pool *ConnectionPool) Unhealthy() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	unhealthy := 0
+	for i := range pool.Connections {
+		if !pool.healthy(i) {
+			unhealthy++
+		}
+	}
+	return unhealthy
+}
+
+// Closes the idle connections of the pool at unmount, after the uploads
+type PoolShutdown struct {
+	Pool *ConnectionPool
+}
+
+func (shutdown *PoolShutdown) Close() error {
+	pool := shutdown.Pool
+	pool.mutex.Lock()
+	idle := []int{}
+	for i := range pool.Connections {
+		if !pool.busy[i] {
+			pool.busy[i] = true
+			idle = append(idle, i)
+		}
+	}
+	pool.mutex.Unlock()
+
+	var firstErr error
+	for _, i := range idle {
+		if err := pool.Connections[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,92 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that a connection which failed is closed and set aside while the other one is healthy,
+// and that an error of the operation does not set the connection aside
+func TestConnectionPool(t *testing.T) {
+	saveFlags(t, &connectionRecheckInterval)
+	connectionRecheckInterval = 10 * time.Second
+	mockCtrl := gomock.NewController(t)
+	bad, good := NewMockHdfsAccessor(mockCtrl), NewMockHdfsAccessor(mockCtrl)
+	clock := &MockClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	pool := NewConnectionPool([]HdfsAccessor{bad, good}, clock)
+
+	bad.EXPECT().Stat("/a").Return(Attrs{}, errors.New("connection reset by peer"))
+	bad.EXPECT().Close().Return(nil)
+	_, err := pool.Stat("/a")
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, pool.Unhealthy())
+
+	// the retry and the next operations go to the healthy connection
+	good.EXPECT().Stat("/a").Return(Attrs{Name: "a"}, nil)
+	good.EXPECT().Stat("/b").Return(Attrs{}, syscall.ENOENT).Times(2)
+	attrs, err := pool.Stat("/a")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", attrs.Name)
+	for i := 0; i < 2; i++ {
+		_, err = pool.Stat("/b")
+		assert.Equal(t, syscall.ENOENT, err)
+	}
+	assert.Equal(t, 1, pool.Unhealthy())
+
+	// the failed connection is used again after -connectionRecheckInterval, and reconnects
+	clock.NotifyTimeElapsed(connectionRecheckInterval)
+	assert.Equal(t, 0, pool.Unhealthy())
+	bad.EXPECT().Stat("/c").Return(Attrs{Name: "c"}, nil)
+	good.EXPECT().Stat("/c").Return(Attrs{Name: "c"}, nil)
+	for i := 0; i < 2; i++ {
+		_, err = pool.Stat("/c")
+		assert.Nil(t, err)
+	}
+}
+
+// Testing that a connection which failed is used when no other connection is healthy, and that
+// an operation waits for a busy connection rather than using one which failed
+func TestConnectionPoolCheckout(t *testing.T) {
+	saveFlags(t, &connectionRecheckInterval)
+	connectionRecheckInterval = 10 * time.Second
+	clock := &MockClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	pool := NewConnectionPool([]HdfsAccessor{nil, nil}, clock)
+	pool.failedAt[0] = clock.Now().Add(time.Second)
+	assert.Equal(t, 1, pool.checkout())
+
+	checkedOut := make(chan int)
+	go func() { checkedOut <- pool.checkout() }()
+	select {
+	case <-checkedOut:
+		t.Fatal("the failed connection was checked out while the healthy one is busy")
+	case <-time.After(50 * time.Millisecond):
+	}
+	pool.mutex.Lock()
+	pool.busy[1] = false
+	pool.released.Signal()
+	pool.mutex.Unlock()
+	assert.Equal(t, 1, <-checkedOut)
+
+	pool.failedAt[1] = clock.Now().Add(time.Second)
+	pool.busy[1] = false
+	assert.Equal(t, 0, pool.checkout())
+}
+
+// Testing that the pool only closes its connections at unmount, and not a busy one
+func TestPoolShutdown(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	idle, busy := NewMockHdfsAccessor(mockCtrl), NewMockHdfsAccessor(mockCtrl)
+	pool := NewConnectionPool([]HdfsAccessor{idle, busy}, &MockClock{})
+	assert.Nil(t, pool.Close())
+	pool.busy[1] = true
+
+	idle.EXPECT().Close().Return(nil)
+	assert.Nil(t, (&PoolShutdown{Pool: pool}).Close())
+}
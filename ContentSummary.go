@@ -0,0 +1,164 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// du and find walk the whole tree through the mount, one lookup per entry. The namenode keeps
+// the totals of every directory and returns them with a single content summary RPC instead.
+// The FUSE library has no ioctl support, so the summary is served by the "du" and "count" admin
+// commands and by the user.hopsfs.* extended attributes of directories, e.g.,
+// getfattr -n user.hopsfs.size <dir>
+func init() {
+	registerAdminCommand("du", AdminCommand{
+		Usage:    "<path>",
+		Help:     "Prints the total size of a directory tree, like du -s",
+		PathArgs: 1,
+		Handler:  contentSummaryCmd(false),
+	})
+	registerAdminCommand("count", AdminCommand{
+		Usage:    "<path>",
+		Help:     "Prints the number of directories, files and bytes of a directory tree and its quotas",
+		PathArgs: 1,
+		Handler:  contentSummaryCmd(true),
+	})
+}
+
+// Totals of a directory tree, as kept by the namenode
+type ContentSummary struct {
+	Length         int64 // bytes in the files
+	SpaceConsumed  int64 // bytes including replication
+	FileCount      int64
+	DirectoryCount int64
+	NameQuota      int64 // -1 if not set
+	SpaceQuota     int64 // -1 if not set
+}
+
+// Virtual extended attributes of directories and how they are derived from the summary
+var contentSummaryXAttrs = map[string]func(ContentSummary) int64{
+	"user.hopsfs.size":            func(cs ContentSummary) int64 { return cs.Length },
+	"user.hopsfs.space_consumed":  func(cs ContentSummary) int64 { return cs.SpaceConsumed },
+	"user.hopsfs.file_count":      func(cs ContentSummary) int64 { return cs.FileCount },
+	"user.hopsfs.directory_count": func(cs ContentSummary) int64 { return cs.DirectoryCount },
+	"user.hopsfs.name_quota":      func(cs ContentSummary) int64 { return cs.NameQuota },
+	"user.hopsfs.space_quota":     func(cs ContentSummary) int64 { return cs.SpaceQuota },
+}
+
+// The summary of a directory is reused for this long, getfattr queries the size of the value first
+const contentSummaryTTL = 5 * time.Second
+
+func contentSummaryCmd(count bool) AdminHandler {
+	return func(filesystem *FileSystem, args []string, out *AdminOutput) error {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s <path>", map[bool]string{false: "du", true: "count"}[count])
+		}
+		if !filesystem.Capabilities.ContentSummary {
+			return fmt.Errorf("the backend does not support content summaries")
+		}
+		cs, err := filesystem.getDFSConnector().GetContentSummary(args[0])
+		if err != nil {
+			return err
+		}
+		if count {
+			// the columns of hdfs dfs -count -q
+			out.Printf("%s %s %d %d %d %s", quotaString(cs.NameQuota), quotaString(cs.SpaceQuota),
+				cs.DirectoryCount, cs.FileCount, cs.Length, args[0])
+		} else {
+			out.Printf("%d %d %s", cs.Length, cs.SpaceConsumed, args[0])
+		}
+		return nil
+	}
+}
+
+func quotaString(quota int64) string {
+	if quota < 0 {
+		return "none"
+	}
+	return strconv.FormatInt(quota, 10)
+}
+
+// Responds on FUSE Getxattr request with the values derived from the content summary, the
+// birth time, the storage and erasure coding policies, and the build information on the root
+func (dir *DirINode) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if isAclXAttr(req.Name) {
+		dir.lockMutex()
+		attrs := dir.Attrs
+		dir.unlockMutex()
+		value, err := dir.FileSystem.getAclXAttr(dir.AbsolutePath(), attrs, req.Name)
+		if err != nil {
+			return err
+		}
+		resp.Xattr = value
+		return nil
+	}
+	if req.Name == birthTimeXAttr {
+		dir.lockMutex()
+		defer dir.unlockMutex()
+		resp.Xattr = birthTimeXAttrValue(&dir.Attrs)
+		return nil
+	}
+	if req.Name == storagePolicyXAttr {
+		value, err := dir.FileSystem.getStoragePolicyXAttr(dir.AbsolutePath())
+		if err != nil {
+			return err
+		}
+		resp.Xattr = value
+		return nil
+	}
+	if req.Name == erasureCodingXAttr {
+		value, err := dir.FileSystem.getErasureCodingXAttr(dir.AbsolutePath())
+		if err != nil {
+			return err
+		}
+		resp.Xattr = value
+		return nil
+	}
+	if req.Name == versionXAttr && dir.Parent == nil {
+		resp.Xattr = []byte(buildInfo().String())
+		return nil
+	}
+	if !dir.FileSystem.Capabilities.ContentSummary {
+		return fuse.ErrNoXattr
+	}
+	if req.Name == quotaUsageXAttr {
+		usage, err := dir.quotaUsage()
+		if err != nil {
+			return err
+		}
+		resp.Xattr = []byte(usage.String())
+		return nil
+	}
+	value, ok := contentSummaryXAttrs[req.Name]
+	if !ok {
+		return fuse.ErrNoXattr
+	}
+	cs, err := dir.contentSummary()
+	if err != nil {
+		return err
+	}
+	resp.Xattr = []byte(strconv.FormatInt(value(cs), 10))
+	return nil
+}
+
+// Returns the content summary of the directory, cached for contentSummaryTTL
+func (dir *DirINode) contentSummary() (ContentSummary, error) {
+	dir.lockMutex()
+	defer dir.unlockMutex()
+	now := dir.FileSystem.Clock.Monotonic()
+	if dir.summary == nil || now > dir.summaryExpires {
+		cs, err := dir.FileSystem.getDFSConnector().GetContentSummary(dir.AbsolutePath())
+		if err != nil {
+			return ContentSummary{}, err
+		}
+		dir.summary = &cs
+		dir.summaryExpires = now + contentSummaryTTL
+	}
+	return *dir.summary, nil
+}
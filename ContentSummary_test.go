@@ -0,0 +1,56 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the content summary is served as extended attributes and by the count admin command
+func TestContentSummary(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	dir := root.(*DirINode).NodeFromAttrs(Attrs{Name: "data", Mode: os.ModeDir | 0755}).(*DirINode)
+	summary := ContentSummary{Length: 300, SpaceConsumed: 900, FileCount: 3, DirectoryCount: 2, NameQuota: -1, SpaceQuota: 1000}
+	hdfsAccessor.EXPECT().GetContentSummary("/data").Return(summary, nil).Times(2)
+
+	resp := &fuse.GetxattrResponse{}
+	assert.Nil(t, dir.Getxattr(nil, &fuse.GetxattrRequest{Name: "user.hopsfs.size"}, resp))
+	assert.Equal(t, "300", string(resp.Xattr))
+	// served from the cached summary
+	assert.Nil(t, dir.Getxattr(nil, &fuse.GetxattrRequest{Name: "user.hopsfs.file_count"}, resp))
+	assert.Equal(t, "3", string(resp.Xattr))
+	assert.Equal(t, fuse.ErrNoXattr, dir.Getxattr(nil, &fuse.GetxattrRequest{Name: "user.other"}, resp))
+
+	var buf bytes.Buffer
+	out := &AdminOutput{encoder: json.NewEncoder(&buf)}
+	assert.Nil(t, contentSummaryCmd(true)(fs, []string{"/data"}, out))
+	assert.True(t, strings.Contains(buf.String(), "none 1000 2 3 300 /data"), buf.String())
+}
+
+// Testing that the root of the mount, and only the root, has the build information
+func TestVersionXAttr(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	resp := &fuse.GetxattrResponse{}
+	assert.Nil(t, root.(*DirINode).Getxattr(nil, &fuse.GetxattrRequest{Name: versionXAttr}, resp))
+	assert.Contains(t, string(resp.Xattr), "version="+VERSION+" git_commit="+GITCOMMIT)
+	assert.Contains(t, string(resp.Xattr), "go_version=go")
+
+	dir := root.(*DirINode).NodeFromAttrs(Attrs{Name: "data", Mode: os.ModeDir | 0755}).(*DirINode)
+	assert.Equal(t, fuse.ErrNoXattr, dir.Getxattr(nil, &fuse.GetxattrRequest{Name: versionXAttr}, resp))
+}
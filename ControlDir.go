@@ -0,0 +1,207 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bazil.org/fuse/fuseutil"
+)
+
+// The admin socket is only accessible on the host of the mount, to the user running it, and
+// needs the hopsfs-mount binary. With -controlDir, the mount root has a virtual .hopsfs
+// directory, hidden from the listing of the root, whose files manage the running mount:
+// reading stats and status returns the output of the stats and status admin commands, and
+// writing paths to invalidate, one per line, relative to the mount point or absolute local
+// paths under it, drops the cached attributes, entries and listings of those paths so that
+// their next access fetches them from HDFS. Like the admin socket, the files are only readable
+// and writable by the user running the mount and by root, as the status tells the staging
+// usage of each user and the credentials of the mount. An HDFS entry named .hopsfs at the root of -srcDir is hidden by the
+// control directory
+var controlDir bool
+
+// Name of the control directory at the mount root
+const controlDirName = ".hopsfs"
+
+// Contents of the control files which are read
+var controlFiles = map[string]func(filesystem *FileSystem) []byte{
+	"stats": func(filesystem *FileSystem) []byte {
+		return []byte(strings.Join(filesystem.statsLines(), "\n") + "\n")
+	},
+	"status": func(filesystem *FileSystem) []byte {
+		b, _ := json.Marshal(filesystem.status())
+		return append(b, '\n')
+	},
+}
+
+// Name of the control file invalidating the caches of the paths written to it
+const controlInvalidate = "invalidate"
+
+// The .hopsfs directory
+type ControlDir struct {
+	FileSystem *FileSystem
+}
+
+var _ fs.Node = (*ControlDir)(nil)
+var _ fs.NodeStringLookuper = (*ControlDir)(nil)
+var _ fs.HandleReadDirAller = (*ControlDir)(nil)
+
+// A file of the .hopsfs directory
+type ControlFile struct {
+	FileSystem *FileSystem
+	Name       string
+}
+
+var _ fs.Node = (*ControlFile)(nil)
+var _ fs.NodeOpener = (*ControlFile)(nil)
+var _ fs.NodeSetattrer = (*ControlFile)(nil)
+
+// Handle of an opened control file, with the content as of the open
+type ControlFileHandle struct {
+	File *ControlFile
+	data []byte
+}
+
+var _ fs.HandleReader = (*ControlFileHandle)(nil)
+var _ fs.HandleWriter = (*ControlFileHandle)(nil)
+
+// Returns the control directory if name is its name in the mount root, nil otherwise
+func (dir *DirINode) controlDirNode(name string) fs.Node {
+	if !controlDir || dir.Parent != nil || name != controlDirName {
+		return nil
+	}
+	return &ControlDir{FileSystem: dir.FileSystem}
+}
+
+func (dir *ControlDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	a.Uid, a.Gid = uint32(os.Getuid()), uint32(os.Getgid())
+	return nil
+}
+
+func (dir *ControlDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	if _, ok := controlFiles[name]; !ok && name != controlInvalidate {
+		return nil, syscall.ENOENT
+	}
+	return &ControlFile{FileSystem: dir.FileSystem, Name: name}, nil
+}
+
+func (dir *ControlDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	entries := []fuse.Dirent{{Name: controlInvalidate, Type: fuse.DT_File}}
+	for name := range controlFiles {
+		entries = append(entries, fuse.Dirent{Name: name, Type: fuse.DT_File})
+	}
+	return entries, nil
+}
+
+func (file *ControlFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0400
+	if file.Name == controlInvalidate {
+		a.Mode = 0200
+	}
+	a.Uid, a.Gid = uint32(os.Getuid()), uint32(os.Getgid())
+	return nil
+}
+
+// Snapshots the content of the file, read with direct I/O as the size changes with each open
+func (file *ControlFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if file.Name == controlInvalidate {
+		if !req.Flags.IsWriteOnly() {
+			return nil, syscall.EACCES
+		}
+		if !adminAllowed(req.Uid) {
+			return nil, syscall.EACCES
+		}
+		return &ControlFileHandle{File: file}, nil
+	}
+	if !req.Flags.IsReadOnly() || !adminAllowed(req.Uid) {
+		return nil, syscall.EACCES
+	}
+	resp.Flags |= fuse.OpenDirectIO
+	return &ControlFileHandle{File: file, data: controlFiles[file.Name](file.FileSystem)}, nil
+}
+
+// Accepts the truncation of invalidate by shell redirections
+func (file *ControlFile) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if file.Name != controlInvalidate || req.Valid.Mode() || req.Valid.Uid() || req.Valid.Gid() {
+		return syscall.EPERM
+	}
+	return file.Attr(ctx, &resp.Attr)
+}
+
+func (handle *ControlFileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	fuseutil.HandleRead(req, resp, handle.data)
+	return nil
+}
+
+// Invalidates the paths of the lines written
+func (handle *ControlFileHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	filesystem := handle.File.FileSystem
+	for _, line := range strings.Split(string(req.Data), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		hdfsPath := path.Join(filesystem.SrcDir, filepath.ToSlash(line))
+		if filepath.IsAbs(line) {
+			var err error
+			if hdfsPath, err = filesystem.HdfsPathFromMountPath(line); err != nil {
+				logwarn("Invalid path written to the control directory", Fields{Operation: ControlOp, Path: line, Error: err})
+				return syscall.EINVAL
+			}
+		}
+		loginfo("Invalidating the cache of the path", Fields{Operation: ControlOp, Path: hdfsPath, UID: req.Uid})
+		filesystem.invalidatePath(hdfsPath)
+	}
+	resp.Size = len(req.Data)
+	return nil
+}
+
+// Drops the cached entry of the path and the listing of its directory, or the listing of the
+// root for the root
+func (filesystem *FileSystem) invalidatePath(hdfsPath string) {
+	if path.Clean(hdfsPath) == path.Clean(filesystem.SrcDir) {
+		if root, ok := filesystem.watchedNode(hdfsPath).(*DirINode); ok {
+			root.lockMutex()
+			root.forgetListing("")
+			root.unlockMutex()
+			filesystem.invalidateNodeData(root)
+		}
+		return
+	}
+	filesystem.forgetChangedEntry(hdfsPath)
+}
+
+// Drops the cached entry of a path which was created, removed or renamed, and the listing of its
+// directory. The entries of open files are kept, only their listing is dropped
+func (filesystem *FileSystem) forgetChangedEntry(hdfsPath string) {
+	parent, ok := filesystem.watchedNode(path.Dir(hdfsPath)).(*DirINode)
+	if !ok {
+		return
+	}
+	if file, ok := filesystem.watchedNode(hdfsPath).(*FileINode); ok && file.countActiveHandles() > 0 {
+		parent.lockMutex()
+		parent.forgetListing(file.Attrs.Name)
+		parent.unlockMutex()
+		return
+	}
+	filesystem.forgetCachedNode(hdfsPath)
+	filesystem.invalidateNodeAttr(parent)
+}
+
+// Returns the cached node of an HDFS path, nil if it is not cached or not in the mount
+func (filesystem *FileSystem) watchedNode(hdfsPath string) fs.Node {
+	srcDir := path.Clean(filesystem.SrcDir)
+	hdfsPath = path.Clean(hdfsPath)
+	if srcDir != "/" && hdfsPath != srcDir && !strings.HasPrefix(hdfsPath, srcDir+"/") {
+		return nil
+	}
+	return filesystem.cachedNode(hdfsPath)
+}
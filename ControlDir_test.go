@@ -0,0 +1,105 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the control directory is hidden from the root listing, that stats is read and
+// that the paths written to invalidate are dropped from the cache, only by the mount's user
+func TestControlDir(t *testing.T) {
+	saveFlags(t, &controlDir)
+	controlDir = true
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: ".hopsfs", Mode: os.ModeDir | 0755}, {Name: "data", Mode: os.ModeDir | 0755}}, nil)
+	hdfsAccessor.EXPECT().ReadDir("/data").Return([]Attrs{{Name: "kept", Mode: 0644}, {Name: "changed", Mode: 0644}}, nil)
+	root, _ := fs.Root()
+	entries, err := root.(*DirINode).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "data", entries[0].Name)
+	data := root.(*DirINode).cachedEntry("data").(*DirINode)
+	_, err = data.ReadDirAll(nil)
+	assert.Nil(t, err)
+
+	node, err := root.(*DirINode).Lookup(nil, controlDirName)
+	assert.Nil(t, err)
+	control := node.(*ControlDir)
+	stats, err := control.Lookup(nil, "stats")
+	assert.Nil(t, err)
+	handle, err := stats.(*ControlFile).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	resp := &fuse.ReadResponse{Data: make([]byte, 4096)}
+	assert.Nil(t, handle.(*ControlFileHandle).Read(nil, &fuse.ReadRequest{Size: 4096}, resp))
+	assert.True(t, strings.HasPrefix(string(resp.Data), BuildInfoOp))
+	_, err = control.Lookup(nil, "other")
+	assert.Equal(t, syscall.ENOENT, err)
+
+	invalidate, _ := control.Lookup(nil, controlInvalidate)
+	_, err = invalidate.(*ControlFile).Open(nil, &fuse.OpenRequest{Header: fuse.Header{Uid: uint32(os.Getuid()) + 1}, Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, syscall.EACCES, err)
+	handle, err = invalidate.(*ControlFile).Open(nil, &fuse.OpenRequest{Header: fuse.Header{Uid: uint32(os.Getuid())}, Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, handle.(*ControlFileHandle).Write(nil, &fuse.WriteRequest{Data: []byte("data/changed\n")}, &fuse.WriteResponse{}))
+	assert.Nil(t, data.cachedEntry("changed"))
+	assert.NotNil(t, data.cachedEntry("kept"))
+}
+
+// Testing that invalidating paths drops the cached entries, but not those of open files
+func TestInvalidatePathKeepsOpenFiles(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().ReadDir("/data").Return([]Attrs{
+		{Name: "removed", Mode: 0644},
+		{Name: "open", Mode: 0644},
+		{Name: "kept", Mode: 0644},
+	}, nil)
+	root, _ := fs.Root()
+	data := root.(*DirINode).NodeFromAttrs(Attrs{Name: "data", Mode: os.ModeDir | 0755}).(*DirINode)
+	_, err := data.ReadDirAll(nil)
+	assert.Nil(t, err)
+
+	hdfsAccessor.EXPECT().OpenRead("/data/open").Return(NewMockReadSeekCloser(mockCtrl), nil)
+	open := data.cachedEntry("open").(*FileINode)
+	_, err = open.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+
+	fs.invalidatePath("/data/removed")
+	fs.invalidatePath("/data/open")
+	fs.invalidatePath("/elsewhere/file")
+	assert.Nil(t, data.cachedEntry("removed"))
+	assert.Equal(t, open, data.cachedEntry("open"))
+	assert.NotNil(t, data.cachedEntry("kept"))
+}
+
+// Testing that the stats and status files are only readable by the user running the mount and by root
+func TestControlDirPermissions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	for name := range controlFiles {
+		file := &ControlFile{FileSystem: fs, Name: name}
+		var attr fuse.Attr
+		assert.Nil(t, file.Attr(nil, &attr))
+		assert.Equal(t, os.FileMode(0400), attr.Mode)
+		_, err := file.Open(nil, &fuse.OpenRequest{Header: fuse.Header{Uid: uint32(os.Getuid()) + 1}, Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		assert.Equal(t, syscall.EACCES, err, name)
+		_, err = file.Open(nil, &fuse.OpenRequest{Header: fuse.Header{Uid: uint32(os.Getuid())}, Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		assert.Nil(t, err, name)
+	}
+}
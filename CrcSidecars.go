@@ -0,0 +1,159 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"strings"
+	"syscall"
+)
+
+const (
+	CrcFilesKeep       = "keep"       // sidecars are ordinary files
+	CrcFilesHide       = "hide"       // sidecars are omitted from listings
+	CrcFilesSynthesize = "synthesize" // sidecars are hidden and generated for the files which have none or a stale one
+)
+
+// Hadoop's LocalFileSystem, used by tools run against the mount with file:// paths, e.g.,
+// "hadoop fs -put" to a local path or Spark in local mode, writes a .<name>.crc sidecar next to
+// each file it writes, with the CRC32 of every chunk of the file, and verifies the reads of the
+// file against the sidecar if there is one. With -crcFiles=hide the sidecars are omitted from
+// listings. They are still stored in HDFS and can be looked up by name, so the tools keep
+// verifying. With -crcFiles=synthesize they are also hidden, and the sidecar of a file which has
+// none or one older than the file, e.g., as the file was rewritten by a client not knowing about
+// sidecars, is generated from the content of the file in the background when it is looked up.
+// The tools then verify against the content in HDFS instead of failing the read with a
+// ChecksumException, once the sidecar is generated: a lookup racing with the generation still
+// finds the missing or older sidecar
+var crcFiles = CrcFilesKeep
+
+// Header of the sidecars and the chunk size of LocalFileSystem, used for sidecars which have none
+var crcSidecarMagic = []byte{'c', 'r', 'c', 0}
+
+const crcBytesPerChecksum = 512
+
+// Returns the name of the file of the sidecar name, empty if the name is not that of a sidecar
+func crcSidecarOf(name string) string {
+	if strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".crc") && len(name) > len("..crc") {
+		return name[1 : len(name)-len(".crc")]
+	}
+	return ""
+}
+
+// Returns true if the entry is a sidecar omitted from listings with -crcFiles
+func hiddenCrcSidecar(name string) bool {
+	return crcFiles != CrcFilesKeep && crcSidecarOf(name) != ""
+}
+
+// Returns the content of the sidecar of the content read from r, with a CRC32 per bytesPerChecksum
+func crcSidecarContent(r io.Reader, bytesPerChecksum int) ([]byte, error) {
+	var sidecar bytes.Buffer
+	sidecar.Write(crcSidecarMagic)
+	binary.Write(&sidecar, binary.BigEndian, int32(bytesPerChecksum))
+	chunk := make([]byte, bytesPerChecksum)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			binary.Write(&sidecar, binary.BigEndian, crc32.ChecksumIEEE(chunk[:n]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sidecar.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Returns the chunk size recorded in the header of the sidecar, crcBytesPerChecksum if it has none
+func (dir *DirINode) crcBytesPerChecksum(sidecarPath string) int {
+	reader, err := dir.FileSystem.getDFSConnector().OpenRead(sidecarPath)
+	if err != nil {
+		return crcBytesPerChecksum
+	}
+	defer reader.Close()
+	header := make([]byte, len(crcSidecarMagic)+4)
+	if _, err := io.ReadFull(reader, header); err != nil || !bytes.Equal(header[:len(crcSidecarMagic)], crcSidecarMagic) {
+		return crcBytesPerChecksum
+	}
+	if n := int(int32(binary.BigEndian.Uint32(header[len(crcSidecarMagic):]))); n > 0 {
+		return n
+	}
+	return crcBytesPerChecksum
+}
+
+// Generates the sidecar of the name in the background with -crcFiles=synthesize, unless it is
+// being generated already. Called by Lookup with the directory locked
+func (dir *DirINode) syncCrcSidecarInBackground(name string) {
+	sidecarPath := dir.AbsolutePathForChild(name)
+	if crcFiles != CrcFilesSynthesize || crcSidecarOf(name) == "" || dir.FileSystem.checkWritableAt(sidecarPath) != nil {
+		return
+	}
+	if _, running := dir.FileSystem.crcSidecarSyncs.LoadOrStore(sidecarPath, true); running {
+		return
+	}
+	go func() {
+		defer dir.FileSystem.crcSidecarSyncs.Delete(sidecarPath)
+		dir.syncCrcSidecar(name)
+	}()
+}
+
+// Generates the sidecar of the name if the file has none or one older than the file. Files open
+// through the mount are left alone as they are still being written. Called without the
+// directory locked, reading the file takes a while
+func (dir *DirINode) syncCrcSidecar(name string) {
+	dataName := crcSidecarOf(name)
+	if fnode, ok := dir.cachedEntry(dataName).(*FileINode); ok && fnode.countActiveHandles() > 0 {
+		return
+	}
+	hdfsAccessor := dir.FileSystem.getDFSConnector()
+	data, err := hdfsAccessor.Stat(dir.AbsolutePathForChild(dataName))
+	if err != nil || !data.Mode.IsRegular() {
+		return
+	}
+	bytesPerChecksum := crcBytesPerChecksum
+	sidecarPath := dir.AbsolutePathForChild(name)
+	if sidecar, err := hdfsAccessor.Stat(sidecarPath); err == nil {
+		if !sidecar.Mtime.Before(data.Mtime) {
+			return
+		}
+		bytesPerChecksum = dir.crcBytesPerChecksum(sidecarPath)
+	} else if err != syscall.ENOENT {
+		return
+	}
+
+	dir.FileSystem.Mutations.Enter()
+	defer dir.FileSystem.Mutations.Exit()
+	reader, err := hdfsAccessor.OpenRead(dir.AbsolutePathForChild(dataName))
+	if err != nil {
+		logwarn("Unable to read the file to generate its CRC sidecar", Fields{Operation: Create, Path: sidecarPath, Error: err})
+		return
+	}
+	content, err := crcSidecarContent(reader, bytesPerChecksum)
+	reader.Close()
+	if err != nil {
+		logwarn("Unable to read the file to generate its CRC sidecar", Fields{Operation: Create, Path: sidecarPath, Error: err})
+		return
+	}
+	writer, err := hdfsAccessor.CreateFile(sidecarPath, data.Mode&0666, true)
+	if err == nil {
+		if _, err = writer.Write(content); err != nil {
+			writer.Close()
+		} else {
+			err = writer.Close()
+		}
+	}
+	if err != nil {
+		logwarn("Unable to write the CRC sidecar of the file", Fields{Operation: Create, Path: sidecarPath, Error: err})
+		return
+	}
+	loginfo("Generated the CRC sidecar of the file", Fields{Operation: Create, Path: sidecarPath, FileSize: data.Size})
+	// the sidecar is looked up again, as a new node so that the kernel does not serve the old content
+	dir.lockMutex()
+	dir.EntriesRemove(name)
+	dir.unlockMutex()
+	dir.FileSystem.invalidateEntry(dir, name)
+}
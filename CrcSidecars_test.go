@@ -0,0 +1,131 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing the recognition of the sidecar names
+func TestCrcSidecarNames(t *testing.T) {
+	saveFlags(t, &crcFiles)
+	assert.Equal(t, "part-0000", crcSidecarOf(".part-0000.crc"))
+	assert.Equal(t, "", crcSidecarOf("part-0000.crc"))
+	assert.Equal(t, "", crcSidecarOf("..crc"))
+	assert.Equal(t, "", crcSidecarOf(".bashrc"))
+
+	crcFiles = CrcFilesKeep
+	assert.False(t, hiddenFromListing(".part-0000.crc"))
+	crcFiles = CrcFilesHide
+	assert.True(t, hiddenFromListing(".part-0000.crc"))
+	assert.False(t, hiddenFromListing("part-0000"))
+}
+
+// Testing that the sidecar content has the header and a CRC32 per chunk, the last one short
+func TestCrcSidecarContent(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 600)
+	content, err := crcSidecarContent(bytes.NewReader(data), 512)
+	assert.Nil(t, err)
+	assert.Equal(t, 8+2*4, len(content))
+	assert.Equal(t, crcSidecarMagic, content[:4])
+	assert.Equal(t, uint32(512), binary.BigEndian.Uint32(content[4:8]))
+	assert.Equal(t, crc32.ChecksumIEEE(data[:512]), binary.BigEndian.Uint32(content[8:12]))
+	assert.Equal(t, crc32.ChecksumIEEE(data[512:]), binary.BigEndian.Uint32(content[12:16]))
+}
+
+// Waits for the sidecars generated in the background
+func waitCrcSidecarSyncs(fs *FileSystem) {
+	for {
+		running := false
+		fs.crcSidecarSyncs.Range(func(key, value interface{}) bool {
+			running = true
+			return false
+		})
+		if !running {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Testing that a missing sidecar is generated from the file in the background when looked up
+func TestCrcSidecarSynthesized(t *testing.T) {
+	saveFlags(t, &crcFiles)
+	crcFiles = CrcFilesSynthesize
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().Stat("/data").Return(Attrs{Name: "data", Mode: 0644, Size: 5, Mtime: time.Unix(100, 0)}, nil).Times(2)
+
+	var written []byte
+	var writtenMutex sync.Mutex
+	hdfsAccessor.EXPECT().Stat("/.data.crc").DoAndReturn(func(path string) (Attrs, error) {
+		writtenMutex.Lock()
+		defer writtenMutex.Unlock()
+		if written == nil {
+			return Attrs{}, syscall.ENOENT
+		}
+		return Attrs{Name: ".data.crc", Mode: 0644, Size: uint64(len(written)), Mtime: time.Unix(101, 0)}, nil
+	}).AnyTimes()
+	reader := NewMockReadSeekCloser(mockCtrl)
+	remote := bytes.NewBufferString("hello")
+	hdfsAccessor.EXPECT().OpenRead("/data").Return(reader, nil)
+	reader.EXPECT().Read(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		if remote.Len() == 0 {
+			return 0, io.EOF
+		}
+		return remote.Read(b)
+	}).AnyTimes()
+	reader.EXPECT().Close().Return(nil)
+	writer := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().CreateFile("/.data.crc", os.FileMode(0644), true).Return(writer, nil)
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		writtenMutex.Lock()
+		defer writtenMutex.Unlock()
+		written = append(written, b...)
+		return len(b), nil
+	})
+	writer.EXPECT().Close().Return(nil)
+
+	root, _ := fs.Root()
+	// the first lookup may or may not find the sidecar generated meanwhile
+	root.(*DirINode).Lookup(nil, ".data.crc")
+	waitCrcSidecarSyncs(fs)
+	node, err := root.(*DirINode).Lookup(nil, ".data.crc")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(12), node.(*FileINode).Attrs.Size)
+	waitCrcSidecarSyncs(fs)
+	expected, _ := crcSidecarContent(bytes.NewBufferString("hello"), crcBytesPerChecksum)
+	assert.Equal(t, expected, written)
+}
+
+// Testing that a sidecar newer than its file is left alone
+func TestCrcSidecarUpToDate(t *testing.T) {
+	saveFlags(t, &crcFiles)
+	crcFiles = CrcFilesSynthesize
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().Stat("/data").Return(Attrs{Name: "data", Mode: 0644, Size: 5, Mtime: time.Unix(100, 0)}, nil)
+	hdfsAccessor.EXPECT().Stat("/.data.crc").Return(Attrs{Name: ".data.crc", Mode: 0644, Size: 12, Mtime: time.Unix(100, 0)}, nil).Times(2)
+
+	root, _ := fs.Root()
+	_, err := root.(*DirINode).Lookup(nil, ".data.crc")
+	assert.Nil(t, err)
+	waitCrcSidecarSyncs(fs)
+}
@@ -0,0 +1,131 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// Implemented by HdfsAccessors which can swap their connection to the name node
+type Reconnecter interface {
+	Reconnect() error
+}
+
+// Watches the TLS client certificate and reconnects all the clients as soon as a renewed
+// certificate is found on disk, instead of waiting for operations to fail once the
+// certificate in use expires. Open readers and writers are drained (see Reconnect)
+type CredentialRefresher struct {
+	CertificateFile string        // client certificate, renewed in place by the certificate manager
+	Margin          time.Duration // warn when the certificate in use expires within this time
+	Interval        time.Duration // how often the certificate file is checked
+	Clock           Clock         // interface to get wall clock time
+	reconnecters    []Reconnecter
+	notAfter        time.Time  // expiry of the certificate the clients are connected with
+	mutex           sync.Mutex // guards notAfter, which the status command reads
+	done            chan struct{}
+}
+
+// Creates the refresher. The clients are assumed to be connected with the current certificate
+func NewCredentialRefresher(certificateFile string, reconnecters []Reconnecter, margin time.Duration, clock Clock) (*CredentialRefresher, error) {
+	refresher := &CredentialRefresher{
+		CertificateFile: certificateFile,
+		Margin:          margin,
+		Interval:        time.Minute,
+		Clock:           clock,
+		reconnecters:    reconnecters,
+		done:            make(chan struct{}),
+	}
+	notAfter, err := certificateExpiry(certificateFile)
+	if err != nil {
+		return nil, err
+	}
+	refresher.notAfter = notAfter
+	loginfo(fmt.Sprintf("Client certificate expires at %v", notAfter), nil)
+	return refresher, nil
+}
+
+// Checks the certificate periodically until closed
+func (refresher *CredentialRefresher) Run() {
+	for {
+		select {
+		case <-refresher.done:
+			return
+		case <-refresher.Clock.After(refresher.Interval):
+			refresher.check()
+		}
+	}
+}
+
+// Stops the refresher
+func (refresher *CredentialRefresher) Close() error {
+	close(refresher.done)
+	return nil
+}
+
+// Returns the expiry of the certificate the clients are connected with
+func (refresher *CredentialRefresher) Expiry() time.Time {
+	refresher.mutex.Lock()
+	defer refresher.mutex.Unlock()
+	return refresher.notAfter
+}
+
+func (refresher *CredentialRefresher) check() {
+	notAfter, err := certificateExpiry(refresher.CertificateFile)
+	if err != nil {
+		// the file may be in the middle of being replaced
+		logwarn(fmt.Sprintf("Unable to read client certificate %s", refresher.CertificateFile), Fields{Error: err})
+	} else if notAfter.After(refresher.notAfter) {
+		loginfo(fmt.Sprintf("Found renewed client certificate expiring at %v, reconnecting", notAfter), nil)
+		failed := 0
+		for _, r := range refresher.reconnecters {
+			if err := r.Reconnect(); err != nil {
+				logerror("Failed to reconnect with the renewed certificate", Fields{Error: err})
+				failed++
+			}
+		}
+		// the failed ones are retried on the next check
+		if failed == 0 {
+			refresher.mutex.Lock()
+			refresher.notAfter = notAfter
+			refresher.mutex.Unlock()
+		}
+		return
+	}
+
+	// the expiry time is checked by the servers with their clocks, which may be ahead of ours
+	if remaining := refresher.notAfter.Sub(refresher.Clock.Now()) - clockSkewTolerance; remaining < refresher.Margin {
+		logwarn(fmt.Sprintf("Client certificate expires in %v and no renewed certificate was found", remaining), nil)
+	}
+}
+
+// Returns the expiry time of the first certificate in the PEM file
+func certificateExpiry(certificateFile string) (time.Time, error) {
+	cert, err := readCertificate(certificateFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+// Returns the first certificate in the PEM file
+func readCertificate(certificateFile string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(certificateFile)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate found in %s", certificateFile)
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingReconnecter struct {
+	reconnects int
+}
+
+func (r *countingReconnecter) Reconnect() error {
+	r.reconnects++
+	return nil
+}
+
+func writeTestCertificate(t *testing.T, path string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "hdfs"},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+}
+
+// Testing that the clients are reconnected once a renewed certificate is found
+func TestCredentialRefresher(t *testing.T) {
+	f, _ := ioutil.TempFile("", "cert")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	writeTestCertificate(t, f.Name(), expiry)
+	r := &countingReconnecter{}
+	refresher, err := NewCredentialRefresher(f.Name(), []Reconnecter{r}, 30*time.Minute, WallClock{})
+	assert.Nil(t, err)
+	assert.True(t, expiry.Equal(refresher.notAfter))
+
+	refresher.check()
+	assert.Equal(t, 0, r.reconnects)
+
+	writeTestCertificate(t, f.Name(), expiry.Add(24*time.Hour))
+	refresher.check()
+	assert.Equal(t, 1, r.reconnects)
+	refresher.check()
+	assert.Equal(t, 1, r.reconnects)
+}
@@ -0,0 +1,59 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"path"
+	"strings"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// -debugPaths is a comma separated list of globs of HDFS paths, in the syntax of -protectedPaths,
+// e.g., /Projects/x/**. The messages about the matching paths are logged down to the trace level
+// whatever the -logLevel, so that the diagnostics of one workload can be captured on a busy
+// shared mount without the messages of all the others. The other messages are logged at -logLevel
+var debugPaths string
+
+// Components of the patterns of -debugPaths containing a /, and the patterns matched against names
+var debugPathPatterns [][]string
+var debugNamePatterns []string
+
+// Level of -logLevel. With -debugPaths the logger logs everything, and the messages which are not
+// about the debugged paths are filtered against this level
+var logThreshold = logger.FatalLevel
+
+// Parses -debugPaths, called before initLogger
+func setDebugPaths(patterns string) {
+	debugPathPatterns, debugNamePatterns = nil, nil
+	for _, pattern := range strings.Split(patterns, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if strings.Contains(pattern, "/") {
+			debugPathPatterns = append(debugPathPatterns, pathComponents(pattern))
+		} else {
+			debugNamePatterns = append(debugNamePatterns, pattern)
+		}
+	}
+}
+
+// Returns true if the message is about a path matched by -debugPaths
+func debuggedPath(f Fields) bool {
+	p, ok := f[Path].(string)
+	if !ok || (debugPathPatterns == nil && debugNamePatterns == nil) {
+		return false
+	}
+	for _, pattern := range debugNamePatterns {
+		if matched, _ := path.Match(pattern, path.Base(p)); matched {
+			return true
+		}
+	}
+	components := pathComponents(p)
+	for _, pattern := range debugPathPatterns {
+		if matchComponents(pattern, components) {
+			return true
+		}
+	}
+	return false
+}
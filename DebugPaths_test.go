@@ -0,0 +1,33 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the messages of the debugged paths are logged below -logLevel, and only those
+func TestDebugPaths(t *testing.T) {
+	defer initLogger("fatal", false, "")
+	defer setDebugPaths("")
+	setDebugPaths("/Projects/x/**, *.ckpt")
+	initLogger("error", false, "")
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+
+	logtrace("Read", Fields{Operation: Read, Path: "/Projects/x/data/part-0"})
+	assert.Contains(t, buf.String(), "part-0")
+	buf.Reset()
+	logdebug("Flush", Fields{Operation: Flush, Path: "/Projects/y/model.ckpt"})
+	assert.Contains(t, buf.String(), "model.ckpt")
+	buf.Reset()
+	logtrace("Read", Fields{Operation: Read, Path: "/Projects/y/data"})
+	loginfo("Mounted", nil)
+	assert.Equal(t, "", buf.String())
+	logerror("Failed", Fields{Operation: Read, Path: "/Projects/y/data"})
+	assert.Contains(t, buf.String(), "Failed")
+}
@@ -0,0 +1,113 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"path"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+)
+
+// -protectedPaths is a comma separated list of globs of HDFS paths which cannot be removed
+// or renamed through the mount, as a last line of defense against a mistyped rm -rf. A pattern
+// containing a / is matched against the whole path, where * matches within a path component
+// and ** matches any number of components, e.g., /warehouse/** protects /warehouse and all of
+// its subtree. A pattern without a / is matched against the name, e.g., *.model. Removing,
+// renaming or replacing a protected path fails with EPERM, as do renaming a directory above a
+// protected path and deleting it with the rmr admin command
+var protectedPaths string
+
+// How much of a tree an operation affects
+type protectScope int
+
+const (
+	protectPath        protectScope = iota // the path itself, e.g., unlink and rmdir
+	protectMovedTree                       // the path and the paths below it, which move with a rename
+	protectDeletedTree                     // the path and everything below it, including names matching a pattern without a /
+)
+
+// Returns the patterns of -protectedPaths
+func protectedPatterns() []string {
+	var patterns []string
+	for _, pattern := range strings.Split(protectedPaths, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func pathComponents(p string) []string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// Returns true if the components of a path match the components of a pattern
+func matchComponents(pattern, components []string) bool {
+	if len(pattern) == 0 {
+		return len(components) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(components); i++ {
+			if matchComponents(pattern[1:], components[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(components) == 0 {
+		return false
+	}
+	matched, _ := path.Match(pattern[0], components[0])
+	return matched && matchComponents(pattern[1:], components[1:])
+}
+
+// Returns true if the pattern matches a path below the one given by its components
+func matchesBelow(pattern, components []string) bool {
+	if len(components) == 0 {
+		return len(pattern) > 0
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		return true
+	}
+	matched, _ := path.Match(pattern[0], components[0])
+	return matched && matchesBelow(pattern[1:], components[1:])
+}
+
+// Returns the pattern of -protectedPaths protecting the HDFS path from an operation of the scope, "" if none
+func protectingPattern(p string, scope protectScope) string {
+	components := pathComponents(p)
+	for _, pattern := range protectedPatterns() {
+		if !strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(pattern, path.Base(p)); matched || scope == protectDeletedTree {
+				return pattern
+			}
+			continue
+		}
+		patternComponents := pathComponents(pattern)
+		if matchComponents(patternComponents, components) || (scope != protectPath && matchesBelow(patternComponents, components)) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// Returns EPERM if -protectedPaths protects the HDFS path from the operation
+func checkProtected(operation string, p string, scope protectScope) error {
+	if protectedPaths == "" {
+		return nil
+	}
+	if pattern := protectingPattern(p, scope); pattern != "" {
+		logwarn("Refused by -protectedPaths", Fields{Operation: operation, Path: p, Message: pattern})
+		return fuse.Errno(syscall.EPERM)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing the matching of -protectedPaths patterns for the scopes of the operations
+func TestProtectingPattern(t *testing.T) {
+	saveFlags(t, &protectedPaths)
+	protectedPaths = "/warehouse/**, *.model, /Projects/*/Models"
+
+	assert.Equal(t, "/warehouse/**", protectingPattern("/warehouse", protectPath))
+	assert.Equal(t, "/warehouse/**", protectingPattern("/warehouse/db/t1/part-0", protectPath))
+	assert.Equal(t, "", protectingPattern("/warehouse2", protectPath))
+	assert.Equal(t, "*.model", protectingPattern("/tmp/a.model", protectPath))
+	assert.Equal(t, "/Projects/*/Models", protectingPattern("/Projects/p1/Models", protectPath))
+	assert.Equal(t, "", protectingPattern("/Projects/p1/Models/m1", protectPath))
+	assert.Equal(t, "", protectingPattern("/Projects/p1", protectPath))
+
+	// renaming or deleting a directory above a protected path
+	assert.Equal(t, "/Projects/*/Models", protectingPattern("/Projects/p1", protectMovedTree))
+	assert.Equal(t, "/warehouse/**", protectingPattern("/", protectMovedTree))
+	assert.Equal(t, "", protectingPattern("/tmp", protectMovedTree))
+	assert.Equal(t, "*.model", protectingPattern("/tmp", protectDeletedTree))
+}
+
+// Testing that protected paths cannot be removed or replaced through the mount
+func TestDeletionProtection(t *testing.T) {
+	saveFlags(t, &protectedPaths)
+	protectedPaths = "/warehouse/**"
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	warehouse := root.(*DirINode).NodeFromAttrs(Attrs{Name: "warehouse", Mode: os.ModeDir | 0777}).(*DirINode)
+
+	assert.Equal(t, fuse.Errno(syscall.EPERM), warehouse.Remove(nil, &fuse.RemoveRequest{Name: "t1"}))
+	assert.Equal(t, fuse.Errno(syscall.EPERM), root.(*DirINode).Remove(nil, &fuse.RemoveRequest{Name: "warehouse", Dir: true}))
+	assert.Equal(t, fuse.Errno(syscall.EPERM), warehouse.Rename(nil, &fuse.RenameRequest{OldName: "t1", NewName: "t2"}, root))
+
+	// publishing a new file into the protected tree is allowed, replacing one is not
+	hdfsAccessor.EXPECT().Stat("/warehouse/new").Return(Attrs{}, syscall.ENOENT)
+	hdfsAccessor.EXPECT().Rename("/tmp", "/warehouse/new").Return(nil)
+	assert.Nil(t, root.(*DirINode).Rename(nil, &fuse.RenameRequest{OldName: "tmp", NewName: "new"}, warehouse))
+	hdfsAccessor.EXPECT().Stat("/warehouse/t1").Return(Attrs{Name: "t1"}, nil)
+	assert.Equal(t, fuse.Errno(syscall.EPERM), root.(*DirINode).Rename(nil, &fuse.RenameRequest{OldName: "tmp", NewName: "t1"}, warehouse))
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"math"
+)
+
+// With -deltaUploads, the regions of a staging file changed since it was downloaded or last
+// uploaded are tracked, so that a flush only ships the changes HDFS can apply in place: data
+// written past the end of the file in HDFS is appended, and a file cut shorter is truncated
+// with the truncate RPC. The whole file is uploaded as without the option if data below the
+// end of the file in HDFS was overwritten, if the file was changed in HDFS meanwhile, or if
+// applying the changes fails
+var deltaUploads bool
+
+// Changes of a staging file since the last upload
+type stagingChanges struct {
+	uploadedSize int64 // size of the file in DFS, whose content is that of the staging file up to it. -1 if not known
+	writtenFrom  int64 // lowest offset written
+	truncatedTo  int64 // smallest size the file was truncated to
+}
+
+// Records that the staging file and the file in DFS are the same
+func (c *stagingChanges) reset(size int64) {
+	c.uploadedSize = size
+	c.writtenFrom = math.MaxInt64
+	c.truncatedTo = math.MaxInt64
+}
+
+func (c *stagingChanges) written(off int64) {
+	if off < c.writtenFrom {
+		c.writtenFrom = off
+	}
+}
+
+func (c *stagingChanges) truncated(size int64) {
+	if size < c.truncatedTo {
+		c.truncatedTo = size
+	}
+}
+
+// Returns the size of the unchanged prefix of the file in DFS, and false if data in it was overwritten
+func (c *stagingChanges) unchangedPrefix() (int64, bool) {
+	if c.uploadedSize < 0 {
+		return 0, false
+	}
+	prefix := c.uploadedSize
+	if c.truncatedTo < prefix {
+		prefix = c.truncatedTo
+	}
+	return prefix, c.writtenFrom >= prefix
+}
+
+// Records that the staging file was uploaded
+func (p *LocalRWFileProxy) uploaded() {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	size := int64(-1)
+	if info, err := p.localFile.Stat(); err == nil {
+		size = info.Size()
+	}
+	p.changes.reset(size)
+	p.journal(false)
+}
+
+// Applies the changes of the staging file to the file in DFS by truncating and appending it.
+// Returns false if the whole file has to be uploaded instead
+func (p *LocalRWFileProxy) uploadChanges(hdfsAccessor HdfsAccessor, hdfsPath string) (int64, bool) {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+
+	prefix, ok := p.changes.unchangedPrefix()
+	if !ok {
+		return 0, false
+	}
+	info, err := p.localFile.Stat()
+	if err != nil {
+		return 0, false
+	}
+	attrs, err := hdfsAccessor.Stat(hdfsPath)
+	if err != nil || int64(attrs.Size) != p.changes.uploadedSize {
+		return 0, false
+	}
+	size := info.Size()
+	if prefix < p.changes.uploadedSize {
+		done, err := hdfsAccessor.Truncate(hdfsPath, prefix)
+		if err != nil {
+			logwarn("Failed to truncate the file in DFS, uploading it", p.file.logInfo(Fields{Operation: Truncate, Error: err}))
+			return 0, false
+		}
+		p.changes.uploadedSize = prefix
+		if !done && size > prefix {
+			// the last block is being recovered, the file cannot be appended to until it is done
+			return 0, false
+		}
+	}
+	if size > prefix {
+		w, err := hdfsAccessor.Append(hdfsPath)
+		if err != nil {
+			logwarn("Failed to append to the file in DFS, uploading it", p.file.logInfo(Fields{Operation: Append, Error: err}))
+			return 0, false
+		}
+		_, err = io.Copy(w, io.NewSectionReader(p.localFile, prefix, size-prefix))
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			// some of the data may have been appended
+			p.changes.uploadedSize = -1
+			logwarn("Failed to append to the file in DFS, uploading it", p.file.logInfo(Fields{Operation: Append, Error: err}))
+			return 0, false
+		}
+	}
+	p.changes.reset(size)
+	return size - prefix, true
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Opens an existing file with the content for writing, through a staging file
+func openForDelta(t *testing.T, mockCtrl *gomock.Controller, hdfsAccessor *MockHdfsAccessor, content string) *FileHandle {
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	reader := NewMockReadSeekCloser(mockCtrl)
+	remote := bytes.NewBufferString(content)
+	hdfsAccessor.EXPECT().OpenRead("/records").Return(reader, nil).Times(2)
+	reader.EXPECT().Read(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		if remote.Len() == 0 {
+			return 0, io.EOF
+		}
+		return remote.Read(b)
+	}).AnyTimes()
+	reader.EXPECT().Close().Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().Stat("/records").Return(Attrs{Name: "records", Mode: 0644, Size: uint64(len(content))}, nil).AnyTimes()
+
+	root, _ := fs.Root()
+	file := root.(*DirINode).NodeFromAttrs(Attrs{Name: "records", Mode: 0644, Size: uint64(len(content))}).(*FileINode)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	return h.(*FileHandle)
+}
+
+// Testing that data written past the end of the file in HDFS is appended on flush
+func TestDeltaUploadAppends(t *testing.T) {
+	saveFlags(t, &deltaUploads)
+	deltaUploads = true
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileHandle := openForDelta(t, mockCtrl, hdfsAccessor, "hello")
+
+	writer := NewMockHdfsWriter(mockCtrl)
+	var appended []byte
+	hdfsAccessor.EXPECT().Append("/records").Return(writer, nil)
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		appended = append(appended, b...)
+		return len(b), nil
+	})
+	writer.EXPECT().Close().Return(nil)
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte(" world"), Offset: 5}, &fuse.WriteResponse{}))
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Equal(t, " world", string(appended))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
+
+// Testing that a file cut shorter is truncated in HDFS, and that overwritten data uploads the whole file
+func TestDeltaUploadTruncatesAndRewrites(t *testing.T) {
+	saveFlags(t, &deltaUploads)
+	deltaUploads = true
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileHandle := openForDelta(t, mockCtrl, hdfsAccessor, "hello")
+
+	hdfsAccessor.EXPECT().Truncate("/records", int64(2)).Return(true, nil)
+	assert.Nil(t, fileHandle.Truncate(2))
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+
+	// overwriting data which is in HDFS uploads the whole file
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("y"), Offset: 0}, &fuse.WriteResponse{}))
+	writer := NewMockHdfsWriter(mockCtrl)
+	var uploaded []byte
+	hdfsAccessor.EXPECT().Remove("/records").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/records", os.FileMode(0644), true).Return(writer, nil)
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		uploaded = append(uploaded, b...)
+		return len(b), nil
+	})
+	writer.EXPECT().Close().Return(nil)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Equal(t, "ye", string(uploaded))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
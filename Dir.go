@@ -9,6 +9,7 @@ import (
 	"path"
 	"path/filepath"
 	"sync"
+	"syscall"
 	"time"
 
 	"bazil.org/fuse"
@@ -19,10 +20,25 @@ import (
 // Encapsulates state and operations for directory node on the HDFS file system
 type DirINode struct {
 	FileSystem *FileSystem         // Pointer to the owning filesystem
-	Attrs      Attrs               // Cached attributes of the directory, TODO: add TTL
+	Attrs      Attrs               // Cache of directory attributes, kept for -attrCacheTTL
 	Parent     *DirINode           // Pointer to the parent directory (allows computing fully-qualified paths on demand)
 	Entries    map[string]*fs.Node // Cahed directory entries
 	mutex      sync.Mutex          // One read or write operation on a directory at a time
+
+	ioClass     IOClass // class of the files opened under the directory, see IOClass()
+	ioClassOnce sync.Once
+
+	summary        *ContentSummary // served as extended attributes, see Getxattr()
+	summaryExpires time.Duration
+	quotaChecked   bool // whether the quota usage was checked after a write, see checkQuota()
+	quotaCheckedAt time.Duration
+
+	listing  *cachedListing           // with -listingCacheTTL
+	entryTTL int64                    // TTL of the attributes of the entries with -attrCacheMaxTTL, accessed atomically
+	missing  map[string]time.Duration // names not found, with -negativeLookupTTL
+
+	snapshottable        int32 // 1 if the directory has a .snapshot directory, -1 if not, 0 if unknown, accessed atomically
+	snapshottableExpires int64 // monotonic time the snapshottable flag is checked again, accessed atomically
 }
 
 // Verify that *Dir implements necesary FUSE interfaces
@@ -32,6 +48,8 @@ var _ fs.NodeStringLookuper = (*DirINode)(nil)
 var _ fs.NodeMkdirer = (*DirINode)(nil)
 var _ fs.NodeRemover = (*DirINode)(nil)
 var _ fs.NodeRenamer = (*DirINode)(nil)
+var _ fs.NodeAccesser = (*DirINode)(nil)
+var _ fs.NodeGetxattrer = (*DirINode)(nil)
 
 // Returns absolute path of the dir in HDFS namespace
 func (dir *DirINode) AbsolutePath() string {
@@ -55,12 +73,15 @@ func (dir *DirINode) AbsolutePathForChild(name string) string {
 func (dir *DirINode) Attr(ctx context.Context, a *fuse.Attr) error {
 	dir.lockMutex()
 	defer dir.unlockMutex()
-	if dir.Parent != nil && dir.FileSystem.Clock.Now().After(dir.Attrs.Expires) {
-		err := dir.Parent.LookupAttrs(dir.Attrs.Name, &dir.Attrs)
-		if err != nil {
-			return err
+	if dir.Parent != nil {
+		expired := dir.FileSystem.attrsExpired(&dir.Attrs)
+		metrics.Record(AttrCacheOp, 0, 0, 0, !expired, nil)
+		if expired {
+			err := dir.Parent.LookupAttrs(dir.Attrs.Name, &dir.Attrs)
+			if err != nil {
+				return err
+			}
 		}
-
 	}
 	return dir.Attrs.ConvertAttrToFuse(a)
 }
@@ -79,6 +100,7 @@ func (dir *DirINode) EntriesSet(name string, node *fs.Node) {
 	}
 
 	dir.Entries[name] = node
+	dir.forgetListing(name)
 }
 
 func (dir *DirINode) EntriesUpdate(name string, attr Attrs) {
@@ -91,6 +113,14 @@ func (dir *DirINode) EntriesUpdate(name string, attr Attrs) {
 			fnode.Attrs = attr
 		} else if dnode, ok := (*node).(*DirINode); ok {
 			dnode.Attrs = attr
+		} else if lnode, ok := (*node).(*SymlinkINode); ok {
+			lnode.mutex.Lock()
+			if attr.Target == "" && attr.Size == lnode.Attrs.Size {
+				// the target of an emulated link is only known once read
+				attr.Target = lnode.Attrs.Target
+			}
+			lnode.Attrs = attr
+			lnode.mutex.Unlock()
 		}
 	}
 }
@@ -99,6 +129,63 @@ func (dir *DirINode) EntriesRemove(name string) {
 	if dir.Entries != nil {
 		delete(dir.Entries, name)
 	}
+	dir.forgetListing(name)
+}
+
+// Returns the cached entry or nil. Used outside of FUSE requests, hence the locking
+func (dir *DirINode) cachedEntry(name string) fs.Node {
+	dir.lockMutex()
+	defer dir.unlockMutex()
+	if node, ok := dir.Entries[name]; ok {
+		return *node
+	}
+	return nil
+}
+
+// Returns all cached entries. Used outside of FUSE requests, hence the locking
+func (dir *DirINode) cachedEntries() []fs.Node {
+	dir.lockMutex()
+	defer dir.unlockMutex()
+	nodes := make([]fs.Node, 0, len(dir.Entries))
+	for _, node := range dir.Entries {
+		nodes = append(nodes, *node)
+	}
+	return nodes
+}
+
+// Expires cached attributes of the directory and its cached subtree
+func (dir *DirINode) expireCachedAttrs() {
+	dir.lockMutex()
+	dir.Attrs.Expires = dir.FileSystem.Clock.Monotonic() - time.Second
+	dir.listing = nil
+	dir.missing = nil
+	dir.unlockMutex()
+	dir.FileSystem.invalidateNodeAttr(dir)
+
+	for _, node := range dir.cachedEntries() {
+		if fnode, ok := node.(*FileINode); ok {
+			fnode.lockFile()
+			fnode.InvalidateMetadataCache()
+			fnode.unlockFile()
+			dir.FileSystem.invalidateNodeAttr(fnode)
+		} else if dnode, ok := node.(*DirINode); ok {
+			dnode.expireCachedAttrs()
+		}
+	}
+}
+
+// Returns the path of a file with active handles in the cached subtree, or "" if there are none
+func (dir *DirINode) findOpenFile() string {
+	for _, node := range dir.cachedEntries() {
+		if fnode, ok := node.(*FileINode); ok && fnode.countActiveHandles() > 0 {
+			return fnode.AbsolutePath()
+		} else if dnode, ok := node.(*DirINode); ok {
+			if openFile := dnode.findOpenFile(); openFile != "" {
+				return openFile
+			}
+		}
+	}
+	return ""
 }
 
 // Responds on FUSE request to lookup the directory
@@ -106,17 +193,49 @@ func (dir *DirINode) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	dir.lockMutex()
 	defer dir.unlockMutex()
 
+	if node := dir.controlDirNode(name); node != nil {
+		return node, nil
+	}
+
+	name, err := dir.hdfsChildName(name)
+	if err != nil {
+		return nil, err
+	}
+
 	if !dir.FileSystem.IsPathAllowed(dir.AbsolutePathForChild(name)) {
 		return nil, fuse.ENOENT
 	}
 
-	if node := dir.EntriesGet(name); node != nil {
+	dir.syncCrcSidecarInBackground(name)
+	start := dir.FileSystem.Clock.Now()
+	if node := dir.EntriesGet(dir.storedName(name)); node != nil {
+		metrics.Record(Lookup, dir.FileSystem.Clock.Now().Sub(start), 0, 0, true, nil)
 		return *node, nil
 	}
+	if dir.knownMissing(name) {
+		metrics.Record(Lookup, dir.FileSystem.Clock.Now().Sub(start), 0, 0, true, nil)
+		return nil, syscall.ENOENT
+	}
 
 	var attrs Attrs
-	err := dir.LookupAttrs(name, &attrs)
+	err = dir.LookupAttrs(name, &attrs)
+	if err == syscall.ENOENT {
+		err = dir.lookupVariant(name, &attrs)
+	}
+	if err == syscall.ENOENT && symlinkSuffix != "" {
+		var marker Attrs
+		if dir.LookupAttrs(name+symlinkSuffix, &marker) == nil {
+			if link, ok := emulatedSymlinkAttrs(marker); ok {
+				metrics.Record(Lookup, dir.FileSystem.Clock.Now().Sub(start), 0, 0, false, nil)
+				return dir.emulatedSymlinkNode(link), nil
+			}
+		}
+	}
+	metrics.Record(Lookup, dir.FileSystem.Clock.Now().Sub(start), 0, 0, false, err)
 	if err != nil {
+		if err == syscall.ENOENT {
+			dir.cacheMissing(name)
+		}
 		return nil, err
 	}
 	return dir.NodeFromAttrs(attrs), nil
@@ -127,6 +246,9 @@ func (dir *DirINode) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	dir.lockMutex()
 	defer dir.unlockMutex()
 
+	if entries := dir.cachedListing(); entries != nil {
+		return entries, nil
+	}
 	absolutePath := dir.AbsolutePath()
 	loginfo("Read directory", Fields{Operation: ReadDir, Path: absolutePath})
 
@@ -139,24 +261,35 @@ func (dir *DirINode) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	entries := make([]fuse.Dirent, 0, len(allAttrs))
 	for _, a := range allAttrs {
 		if dir.FileSystem.IsPathAllowed(dir.AbsolutePathForChild(a.Name)) {
+			a.Inode = snapshotInode(dir.AbsolutePathForChild(a.Name), a.Inode)
+			if link, ok := emulatedSymlinkAttrs(a); ok {
+				entries = append(entries, fuse.Dirent{Inode: link.Inode, Name: link.Name, Type: fuse.DT_Link})
+				dir.emulatedSymlinkNode(link)
+				continue
+			}
 			// Creating Dirent structure as required by FUSE
-			entries = append(entries, fuse.Dirent{
-				Inode: a.Inode,
-				Name:  a.Name,
-				Type:  a.FuseNodeType()})
+			if !hiddenFromListing(a.Name) && dir.controlDirNode(a.Name) == nil {
+				entries = append(entries, fuse.Dirent{
+					Inode: a.Inode,
+					Name:  a.Name,
+					Type:  a.FuseNodeType()})
+			}
 			// Speculatively pre-creating child Dir or File node with cached attributes,
 			// since it's highly likely that we will have Lookup() call for this name
 			// This is the key trick which dramatically speeds up 'ls'
 			dir.NodeFromAttrs(a)
 		}
 	}
+	dir.cacheListing(entries)
 	return entries, nil
 }
 
-// Creates typed node (Dir or File) from the attributes
+// Creates typed node (Dir, Symlink or File) from the attributes
 func (dir *DirINode) NodeFromAttrs(attrs Attrs) fs.Node {
 	var node fs.Node
-	if (attrs.Mode & os.ModeDir) == 0 {
+	if (attrs.Mode & os.ModeSymlink) != 0 {
+		node = &SymlinkINode{FileSystem: dir.FileSystem, Parent: dir, Attrs: attrs}
+	} else if (attrs.Mode & os.ModeDir) == 0 {
 		node = &FileINode{FileSystem: dir.FileSystem, Parent: dir, Attrs: attrs}
 	} else {
 		node = &DirINode{FileSystem: dir.FileSystem, Parent: dir, Attrs: attrs}
@@ -174,7 +307,11 @@ func (dir *DirINode) NodeFromAttrs(attrs Attrs) fs.Node {
 // Performs Stat() query on the backend
 func (dir *DirINode) LookupAttrs(name string, attrs *Attrs) error {
 
+	if snapshotDirs && name == snapshotDirName {
+		return dir.snapshotDirAttrs(attrs)
+	}
 	var err error
+	previous := *attrs
 	*attrs, err = dir.FileSystem.getDFSConnector().Stat(path.Join(dir.AbsolutePath(), name))
 	if err != nil {
 		// It is a warning as each time new file write tries to stat if the file exists
@@ -183,78 +320,161 @@ func (dir *DirINode) LookupAttrs(name string, attrs *Attrs) error {
 	}
 
 	logdebug("Stat successful ", Fields{Operation: Stat, Path: path.Join(dir.AbsolutePath(), name)})
-	// expiration time := now + 5 secs // TODO: make configurable
-	attrs.Expires = dir.FileSystem.Clock.Now().Add(5 * time.Second)
+	attrs.Inode = snapshotInode(path.Join(dir.AbsolutePath(), name), attrs.Inode)
+	attrs.Expires = dir.entryAttrsExpiry(&previous, attrs)
 	return nil
 }
 
 // Responds on FUSE Mkdir request
 func (dir *DirINode) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	if err := dir.FileSystem.checkWritableAt(dir.AbsolutePathForChild(req.Name)); err != nil {
+		return nil, err
+	}
+	dir.FileSystem.Mutations.Enter()
+	defer dir.FileSystem.Mutations.Exit()
 	dir.lockMutex()
 	defer dir.unlockMutex()
 
-	err := dir.FileSystem.getDFSConnector().Mkdir(dir.AbsolutePathForChild(req.Name), req.Mode)
+	name, err := dir.hdfsChildName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	req.Name = name
+
+	if err := dir.FileSystem.checkAccess(&dir.Attrs, req.Header, accessWrite|accessExec, dir.AbsolutePath()); err != nil {
+		return nil, err
+	}
+	hdfsAccessor, err := dir.FileSystem.connectorFor(req.Header)
+	if err != nil {
+		return nil, err
+	}
+	err = hdfsAccessor.Mkdir(dir.AbsolutePathForChild(req.Name), req.Mode)
 	if err != nil {
 		loginfo("mkdir failed", Fields{Operation: Mkdir, Path: path.Join(dir.AbsolutePath(), req.Name), Error: err})
 		return nil, err
 	}
 	logdebug("mkdir successful", Fields{Operation: Mkdir, Path: path.Join(dir.AbsolutePath(), req.Name)})
 
-	err = ChownOp(&dir.Attrs, dir.FileSystem, dir.AbsolutePathForChild(req.Name), req.Uid, req.Gid)
+	// a directory created as the caller is already owned by it
+	if !dir.FileSystem.impersonated(hdfsAccessor) {
+		err = ChownOp(&dir.Attrs, hdfsAccessor, dir.AbsolutePathForChild(req.Name), req.Uid, req.Gid)
+	}
 	if err != nil {
 		logwarn("Unable to change ownership of new dir", Fields{Operation: Create, Path: dir.AbsolutePathForChild(req.Name),
 			UID: req.Uid, GID: req.Gid, Error: err})
 		//unable to change the ownership of the directory. so delete it as the operation as a whole failed
-		dir.FileSystem.getDFSConnector().Remove(dir.AbsolutePathForChild(req.Name))
+		hdfsAccessor.Remove(dir.AbsolutePathForChild(req.Name))
 		return nil, err
 	}
+	if err := dir.inheritAcl(dir.AbsolutePathForChild(req.Name), req.Mode|os.ModeDir); err != nil {
+		logwarn("Unable to apply the default ACL of the directory to the new dir", Fields{Operation: Mkdir, Path: dir.AbsolutePathForChild(req.Name), Error: err})
+	}
 
 	return dir.NodeFromAttrs(Attrs{Name: req.Name, Mode: req.Mode | os.ModeDir, Uid: req.Uid, Gid: req.Gid}), nil
 }
 
 // Responds on FUSE Create request
 func (dir *DirINode) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	if err := dir.FileSystem.checkWritableAt(dir.AbsolutePathForChild(req.Name)); err != nil {
+		return nil, nil, err
+	}
+	if err := dir.FileSystem.Opens.Check(); err != nil {
+		return nil, nil, err
+	}
+	dir.FileSystem.Mutations.Enter()
+	defer dir.FileSystem.Mutations.Exit()
 	dir.lockMutex()
 	defer dir.unlockMutex()
 
+	name, err := dir.hdfsChildName(req.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Name = name
+
+	if err := dir.FileSystem.checkAccess(&dir.Attrs, req.Header, accessWrite|accessExec, dir.AbsolutePath()); err != nil {
+		return nil, nil, err
+	}
+	hdfsAccessor, err := dir.FileSystem.connectorFor(req.Header)
+	if err != nil {
+		return nil, nil, err
+	}
 	loginfo("Creating a new file", Fields{Operation: Create, Path: dir.AbsolutePathForChild(req.Name), Mode: req.Mode, Flags: req.Flags})
 	file := dir.NodeFromAttrs(Attrs{Name: req.Name, Mode: req.Mode}).(*FileINode)
-	handle, err := file.NewFileHandle(false, req.Flags)
+	handle, err := file.NewFileHandleAs(hdfsAccessor, req.Uid, false, req.Flags)
 	if err != nil {
 		logerror("File creation failed", Fields{Operation: Create, Path: dir.AbsolutePathForChild(req.Name), Mode: req.Mode, Flags: req.Flags, Error: err})
 		//TODO remove the entry from the cache
 		return nil, nil, err
 	}
+	handle.ioClass = dir.FileSystem.ioClassOf(dir, req.Uid)
 
 	file.AddHandle(handle)
-	err = ChownOp(&dir.Attrs, dir.FileSystem, dir.AbsolutePathForChild(req.Name), req.Uid, req.Gid)
+	if !dir.FileSystem.impersonated(hdfsAccessor) {
+		err = ChownOp(&dir.Attrs, hdfsAccessor, dir.AbsolutePathForChild(req.Name), req.Uid, req.Gid)
+	}
 	if err != nil {
 		logwarn("Unable to change ownership of new file", Fields{Operation: Create, Path: dir.AbsolutePathForChild(req.Name),
 			UID: req.Uid, GID: req.Gid, Error: err})
 		//unable to change the ownership of the file. so delete it as the operation as a whole failed
-		dir.FileSystem.getDFSConnector().Remove(dir.AbsolutePathForChild(req.Name))
+		hdfsAccessor.Remove(dir.AbsolutePathForChild(req.Name))
 		return nil, nil, err
 	}
+	if err := dir.inheritAcl(dir.AbsolutePathForChild(req.Name), req.Mode); err != nil {
+		logwarn("Unable to apply the default ACL of the directory to the new file", Fields{Operation: Create, Path: dir.AbsolutePathForChild(req.Name), Error: err})
+	}
 
 	//update the attributes of the file now
 	err = dir.LookupAttrs(file.Attrs.Name, &file.Attrs)
 	if err != nil {
 		return nil, nil, err
 	}
+	dir.FileSystem.Hooks.Fire(HookCreate, dir.AbsolutePathForChild(req.Name), 0, req.Mode, req.Uid)
 
 	return file, handle, nil
 }
 
 // Responds on FUSE Remove request
 func (dir *DirINode) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	if err := dir.FileSystem.checkWritableAt(dir.AbsolutePathForChild(req.Name)); err != nil {
+		return err
+	}
+	dir.FileSystem.Mutations.Enter()
+	defer dir.FileSystem.Mutations.Exit()
 	dir.lockMutex()
 	defer dir.unlockMutex()
 
-	path := dir.AbsolutePathForChild(req.Name)
+	name, err := dir.hdfsChildName(req.Name)
+	if err != nil {
+		return err
+	}
+	req.Name = dir.storedName(name)
+
+	path := dir.AbsolutePathForChild(dir.hdfsEntryName(req.Name))
+	if err := dir.FileSystem.checkAccess(&dir.Attrs, req.Header, accessWrite|accessExec, dir.AbsolutePath()); err != nil {
+		return err
+	}
+	if err := checkProtected(Remove, path, protectPath); err != nil {
+		return err
+	}
+	if err := dir.checkStickyBit(dir.hdfsEntryName(req.Name), req.Header.Uid); err != nil {
+		logwarn("Remove denied by the sticky bit", Fields{Operation: Remove, Path: path, UID: req.Header.Uid})
+		return err
+	}
+	hdfsAccessor, err := dir.FileSystem.connectorFor(req.Header)
+	if err != nil {
+		return err
+	}
 	loginfo("Removing path", Fields{Operation: Remove, Path: path})
-	err := dir.FileSystem.getDFSConnector().Remove(path)
+	err = dir.FileSystem.removeOrTrash(hdfsAccessor, path, req.Dir, req.Header.Uid)
 	if err == nil {
+		if node := dir.EntriesGet(req.Name); node != nil {
+			if fnode, ok := (*node).(*FileINode); ok {
+				fnode.discardDeferredFlush()
+			}
+		}
 		dir.EntriesRemove(req.Name)
+		dir.FileSystem.Hooks.Fire(HookDelete, path, 0, 0, req.Header.Uid)
 	} else {
 		logwarn("Failed to remove path", Fields{Operation: Remove, Path: path, Error: err})
 	}
@@ -263,25 +483,106 @@ func (dir *DirINode) Remove(ctx context.Context, req *fuse.RemoveRequest) error
 
 // Responds on FUSE Rename request
 func (dir *DirINode) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	if err := dir.FileSystem.checkWritableAt(dir.AbsolutePathForChild(req.OldName)); err != nil {
+		return err
+	}
+	if target, ok := newDir.(*DirINode); ok {
+		if err := dir.FileSystem.checkWritableAt(target.AbsolutePathForChild(req.NewName)); err != nil {
+			return err
+		}
+	}
+	dir.FileSystem.Mutations.Enter()
+	defer dir.FileSystem.Mutations.Exit()
 	dir.lockMutex()
 	defer dir.unlockMutex()
 
-	oldPath := dir.AbsolutePathForChild(req.OldName)
-	newPath := newDir.(*DirINode).AbsolutePathForChild(req.NewName)
+	oldName, err := dir.hdfsChildName(req.OldName)
+	if err != nil {
+		return err
+	}
+	newName, err := newDir.(*DirINode).hdfsChildName(req.NewName)
+	if err != nil {
+		return err
+	}
+	// an entry stored in another form of the name keeps it, so does a replaced target
+	req.OldName, req.NewName = dir.storedName(oldName), newDir.(*DirINode).storedName(newName)
+
+	// an emulated link is renamed with its marker file
+	oldEntry, newEntry := dir.hdfsEntryName(req.OldName), req.NewName
+	if oldEntry != req.OldName {
+		newEntry += symlinkSuffix
+	}
+	oldPath := dir.AbsolutePathForChild(oldEntry)
+	newPath := newDir.(*DirINode).AbsolutePathForChild(newEntry)
+	if err := dir.FileSystem.checkAccess(&dir.Attrs, req.Header, accessWrite|accessExec, dir.AbsolutePath()); err != nil {
+		return err
+	}
+	if err := dir.FileSystem.checkAccess(&newDir.(*DirINode).Attrs, req.Header, accessWrite|accessExec, newDir.(*DirINode).AbsolutePath()); err != nil {
+		return err
+	}
+	if err := checkProtected(Rename, oldPath, protectMovedTree); err != nil {
+		return err
+	}
+	// replacing a protected target removes it, moving a file to a protected path which does not exist yet is fine
+	if protectedPaths != "" && protectingPattern(newPath, protectPath) != "" {
+		if _, err := dir.FileSystem.getDFSConnector().Stat(newPath); err != syscall.ENOENT {
+			return checkProtected(Rename, newPath, protectPath)
+		}
+	}
+	if err := dir.checkStickyBit(oldEntry, req.Header.Uid); err != nil {
+		logwarn("Rename denied by the sticky bit", Fields{Operation: Rename, Path: oldPath, UID: req.Header.Uid})
+		return err
+	}
+	// an existing target is replaced, which is a removal in the target directory
+	if err := newDir.(*DirINode).checkStickyBit(newEntry, req.Header.Uid); err != nil && err != syscall.ENOENT {
+		logwarn("Rename denied by the sticky bit of the target directory", Fields{Operation: Rename, Path: newPath, UID: req.Header.Uid})
+		return err
+	}
+	hdfsAccessor, err := dir.FileSystem.connectorFor(req.Header)
+	if err != nil {
+		return err
+	}
+	if node := dir.EntriesGet(req.OldName); node != nil {
+		if fnode, ok := (*node).(*FileINode); ok {
+			err = fnode.flushBeforeRename()
+		} else if dnode, ok := (*node).(*DirINode); ok {
+			err = dnode.flushBelowBeforeRename()
+		}
+		if err != nil {
+			return err
+		}
+	}
 	loginfo("Renaming to "+newPath, Fields{Operation: Rename, Path: oldPath})
-	err := dir.FileSystem.getDFSConnector().Rename(oldPath, newPath)
+	shadowed := newDir.(*DirINode).shadowedEntry(req.NewName, newEntry)
+	err = hdfsAccessor.Rename(oldPath, newPath)
+	if err == nil && shadowed != "" {
+		if err := hdfsAccessor.Remove(shadowed); err != nil {
+			logwarn("Failed to remove the replaced entry", Fields{Operation: Rename, Path: shadowed, Error: err})
+		}
+	}
 	if err == nil {
 		// Upon successful rename, updating in-memory representation of the file entry
 		if node := dir.EntriesGet(req.OldName); node != nil {
 			if fnode, ok := (*node).(*FileINode); ok {
 				fnode.Attrs.Name = req.NewName
 				fnode.Parent = newDir.(*DirINode)
+				// an open file keeps its staging file, the data is uploaded to the new path on close
+				fnode.retagStaging()
 			} else if dnode, ok := (*node).(*DirINode); ok {
 				dnode.Attrs.Name = req.NewName
 				dnode.Parent = newDir.(*DirINode)
+				dnode.retagStagingBelow()
+			} else if lnode, ok := (*node).(*SymlinkINode); ok {
+				lnode.mutex.Lock()
+				lnode.Attrs.Name = req.NewName
+				lnode.Parent = newDir.(*DirINode)
+				lnode.mutex.Unlock()
 			}
 			dir.EntriesRemove(req.OldName)
 			newDir.(*DirINode).EntriesSet(req.NewName, node)
+		} else {
+			// the cached entry of a replaced target is stale
+			newDir.(*DirINode).EntriesRemove(req.NewName)
 		}
 	}
 	return err
@@ -289,6 +590,11 @@ func (dir *DirINode) Rename(ctx context.Context, req *fuse.RenameRequest, newDir
 
 // Responds on FUSE Chmod request
 func (dir *DirINode) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if err := dir.FileSystem.checkWritableAt(dir.AbsolutePath()); err != nil {
+		return err
+	}
+	dir.FileSystem.Mutations.Enter()
+	defer dir.FileSystem.Mutations.Exit()
 	dir.lockMutex()
 	defer dir.unlockMutex()
 
@@ -297,16 +603,23 @@ func (dir *DirINode) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp
 	}
 
 	path := dir.AbsolutePath()
+	if err := checkSetattr(dir.FileSystem, &dir.Attrs, req, path); err != nil {
+		return err
+	}
+	hdfsAccessor, err := dir.FileSystem.connectorFor(req.Header)
+	if err != nil {
+		return err
+	}
 
 	if req.Valid.Mode() {
-		if err := ChmodOp(&dir.Attrs, dir.FileSystem, path, req, resp); err != nil {
+		if err := ChmodOp(&dir.Attrs, hdfsAccessor, path, req, resp); err != nil {
 			logwarn("Setattr (chmod) failed. ", Fields{Operation: Chmod, Path: path, Mode: req.Mode})
 			return err
 		}
 	}
 
 	if req.Valid.Uid() || req.Valid.Gid() {
-		if err := SetAttrChownOp(&dir.Attrs, dir.FileSystem, path, req, resp); err != nil {
+		if err := SetAttrChownOp(&dir.Attrs, hdfsAccessor, path, req, resp); err != nil {
 			logwarn("Setattr (chown/chgrp )failed", Fields{Operation: Chmod, Path: path, UID: req.Uid, GID: req.Gid})
 			return err
 		}
@@ -319,6 +632,38 @@ func (dir *DirINode) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp
 	return nil
 }
 
+// Responds on FUSE Access request. Only sent by the kernel if default_permissions is not used
+func (dir *DirINode) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	dir.lockMutex()
+	defer dir.unlockMutex()
+	return dir.FileSystem.checkAccess(&dir.Attrs, req.Header, req.Mask, dir.AbsolutePath())
+}
+
+// In a directory with the sticky bit set only root, the owner of the directory and the
+// owner of an entry can remove or rename the entry. The check is done here as the FUSE
+// library does not pass the sticky bit to the kernel, so default_permissions can not enforce it
+func (dir *DirINode) checkStickyBit(name string, uid uint32) error {
+	if uid == 0 {
+		return nil
+	}
+	if dir.Parent != nil && dir.FileSystem.attrsExpired(&dir.Attrs) {
+		if err := dir.Parent.LookupAttrs(dir.Attrs.Name, &dir.Attrs); err != nil {
+			return err
+		}
+	}
+	if dir.Attrs.Mode&os.ModeSticky == 0 || dir.Attrs.Uid == uid {
+		return nil
+	}
+	var attrs Attrs
+	if err := dir.LookupAttrs(name, &attrs); err != nil {
+		return err
+	}
+	if attrs.Uid != uid {
+		return fuse.Errno(syscall.EPERM)
+	}
+	return nil
+}
+
 func (dir *DirINode) lockMutex() {
 	dir.mutex.Lock()
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"os"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -143,3 +144,33 @@ func TestSetattr(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, uint32(0), node.(*DirINode).Attrs.Uid)
 }
+
+// Testing that entries of a sticky directory can be removed only by their owner, the owner of the directory or root
+func TestStickyBit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/tmp").Return(Attrs{Name: "tmp", Mode: os.ModeDir | os.ModeSticky | 0777, Uid: 0}, nil).AnyTimes()
+	tmp, err := root.(*DirINode).Lookup(nil, "tmp")
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().Stat("/tmp/file").Return(Attrs{Name: "file", Mode: 0644, Uid: 1000}, nil).AnyTimes()
+
+	err = tmp.(*DirINode).Remove(nil, &fuse.RemoveRequest{Header: fuse.Header{Uid: 1001}, Name: "file"})
+	assert.Equal(t, fuse.Errno(syscall.EPERM), err)
+	err = tmp.(*DirINode).Rename(nil, &fuse.RenameRequest{Header: fuse.Header{Uid: 1001}, OldName: "file", NewName: "file2"}, tmp)
+	assert.Equal(t, fuse.Errno(syscall.EPERM), err)
+
+	hdfsAccessor.EXPECT().Remove("/tmp/file").Return(nil).Times(2)
+	assert.Nil(t, tmp.(*DirINode).Remove(nil, &fuse.RemoveRequest{Header: fuse.Header{Uid: 1000}, Name: "file"}))
+	assert.Nil(t, tmp.(*DirINode).Remove(nil, &fuse.RemoveRequest{Header: fuse.Header{Uid: 0}, Name: "file"}))
+}
+
+// Testing the conversion of the sticky bit between HDFS and os.FileMode
+func TestStickyBitConversion(t *testing.T) {
+	assert.Equal(t, os.ModeSticky|0777, fileModeFromHdfsPerm(01777))
+	assert.Equal(t, os.FileMode(0755), fileModeFromHdfsPerm(0755))
+	assert.Equal(t, os.FileMode(01777), hdfsPermFromFileMode(os.ModeDir|os.ModeSticky|0777))
+	assert.Equal(t, os.FileMode(0755), hdfsPermFromFileMode(os.ModeDir|0755))
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Longest delay of a single write() while the dirty data is between half of -maxDirtyBytes and the limit
+const maxDirtyThrottleDelay = 100 * time.Millisecond
+
+// Tracks the data written to staging files which is not uploaded to HDFS yet and slows
+// down writers when it grows over -maxDirtyBytes, similar to the dirty page throttling
+// of the kernel. Writes are delayed progressively once half of the limit is reached,
+// and block once the limit is reached until enough data is uploaded. A blocked writer uploads
+// the data of its own file first, a single writer over the limit would wait for itself
+// Concurrency: thread safe
+type DirtyTracker struct {
+	Clock Clock
+	dirty int64
+	mutex sync.Mutex
+	cond  *sync.Cond
+}
+
+// Creates a tracker with no dirty data
+func NewDirtyTracker(clock Clock) *DirtyTracker {
+	tracker := &DirtyTracker{Clock: clock}
+	tracker.cond = sync.NewCond(&tracker.mutex)
+	return tracker
+}
+
+// Returns the amount of dirty data
+func (tracker *DirtyTracker) Dirty() int64 {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.dirty
+}
+
+// Accounts data written to a staging file
+func (tracker *DirtyTracker) Add(n int64) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.dirty += n
+}
+
+// Accounts data which was uploaded or discarded, waking up blocked writers
+func (tracker *DirtyTracker) Release(n int64) {
+	if n == 0 {
+		return
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.dirty -= n
+	if tracker.dirty < 0 {
+		tracker.dirty = 0
+	}
+	tracker.cond.Broadcast()
+}
+
+// Called before a write, without holding what the uploads need. Delays or blocks the caller
+// depending on the amount of dirty data, calling writeback, if not nil, to upload the data of
+// the caller before blocking. Fails with ENOSPC if the data is not below the limit within
+// -dirtyWaitTimeout, i.e., the write fails early instead of the staging directory growing
+// until the upload fails at close
+func (tracker *DirtyTracker) Throttle(limit int64, timeout time.Duration, writeback func() error) error {
+	if limit <= 0 {
+		return nil
+	}
+	tracker.mutex.Lock()
+	dirty := tracker.dirty
+	if dirty < limit/2 {
+		tracker.mutex.Unlock()
+		return nil
+	}
+	if dirty < limit {
+		tracker.mutex.Unlock()
+		delay := time.Duration(int64(maxDirtyThrottleDelay) * (dirty - limit/2) / (limit - limit/2))
+		<-tracker.Clock.After(delay)
+		return nil
+	}
+	tracker.mutex.Unlock()
+	if writeback != nil {
+		if err := writeback(); err != nil {
+			logwarn("Failed to upload the data of the blocked writer", Fields{Operation: Write, Error: err})
+		}
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.dirty < limit {
+		return nil
+	}
+
+	logwarn("Too much data is waiting to be uploaded, blocking writes", Fields{Operation: Write, Bytes: tracker.dirty})
+	timedOut := false
+	timer := time.AfterFunc(timeout, func() {
+		tracker.mutex.Lock()
+		defer tracker.mutex.Unlock()
+		timedOut = true
+		tracker.cond.Broadcast()
+	})
+	defer timer.Stop()
+	for tracker.dirty >= limit && !timedOut {
+		tracker.cond.Wait()
+	}
+	if tracker.dirty >= limit {
+		logerror("Data waiting to be uploaded did not go below -maxDirtyBytes in time, failing the write", Fields{Operation: Write, Bytes: tracker.dirty})
+		return syscall.ENOSPC
+	}
+	return nil
+}
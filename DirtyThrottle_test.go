@@ -0,0 +1,83 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that writes are delayed above half of the limit and blocked at the limit
+func TestDirtyThrottle(t *testing.T) {
+	mockClock := &MockClock{}
+	tracker := NewDirtyTracker(mockClock)
+
+	tracker.Add(400)
+	assert.Nil(t, tracker.Throttle(1000, time.Second, nil))
+	assert.Equal(t, time.Duration(0), mockClock.LastSleepDuration)
+	assert.Nil(t, tracker.Throttle(0, time.Second, nil))
+
+	tracker.Add(350)
+	assert.Nil(t, tracker.Throttle(1000, time.Second, nil))
+	assert.Equal(t, maxDirtyThrottleDelay/2, mockClock.LastSleepDuration)
+
+	tracker.Add(250)
+	assert.Equal(t, syscall.ENOSPC, tracker.Throttle(1000, 10*time.Millisecond, nil))
+
+	// uploads unblock the writer
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		tracker.Release(500)
+	}()
+	assert.Nil(t, tracker.Throttle(1000, time.Minute, nil))
+	assert.Equal(t, int64(500), tracker.Dirty())
+}
+
+// Testing that a writer at the limit uploads its own data before blocking
+func TestDirtyThrottleWriteback(t *testing.T) {
+	tracker := NewDirtyTracker(&MockClock{})
+	tracker.Add(1000)
+	assert.Nil(t, tracker.Throttle(1000, time.Minute, func() error {
+		tracker.Release(1000)
+		return nil
+	}))
+	assert.Equal(t, int64(0), tracker.Dirty())
+}
+
+// Testing that a single writer over the limit uploads its own data instead of waiting for it
+func TestDirtyThrottleSingleWriter(t *testing.T) {
+	saveFlags(t, &maxDirtyBytes, &dirtyWaitTimeout)
+	maxDirtyBytes = 10
+	dirtyWaitTimeout = time.Minute
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	var uploaded []byte
+	fs := renameBarrierFs(mockCtrl, hdfsAccessor, &uploaded)
+	root, _ := fs.Root()
+	_, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "dump",
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("0123456789"), Offset: 0}, &fuse.WriteResponse{}))
+	assert.Equal(t, int64(10), fs.Dirty.Dirty())
+
+	done := make(chan error)
+	go func() {
+		done <- fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("abc"), Offset: 10}, &fuse.WriteResponse{})
+	}()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the writer is blocked by its own data")
+	}
+	assert.Equal(t, "0123456789", string(uploaded))
+	assert.Equal(t, int64(3), fs.Dirty.Dirty())
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
@@ -0,0 +1,221 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// A busy mount point left the choice between umount failing with EBUSY and a lazy unmount,
+// which detaches the mount while files are still open and loses what they write afterwards.
+// hopsfs-mount umount -drain makes the mount refuse new opens and creates with EBUSY, waits for
+// the open files to be closed, printing how many are left, uploads the data which is not in
+// HDFS yet, including the deferred flushes of -flushCoalesceWindow, and then unmounts. If files
+// are still open after -drainTimeout, the mount accepts opens again and umount fails
+func init() {
+	registerAdminCommand("drain", AdminCommand{
+		Usage:   "[timeout]",
+		Help:    "Refuses new opens, waits for the open files to be closed and uploads the dirty data",
+		Handler: drainCmd,
+	})
+	registerAdminCommand("undrain", AdminCommand{
+		Help:    "Accepts the opens refused by drain again",
+		Handler: undrainCmd,
+	})
+}
+
+// Default of -drainTimeout
+const defaultDrainTimeout = 5 * time.Minute
+
+// Counts the open file handles and refuses new ones while the mount is drained
+// Concurrency: thread safe
+type OpenGate struct {
+	mutex    sync.Mutex
+	changed  *sync.Cond // signalled when a handle is closed or the drain times out
+	draining bool
+	open     int
+}
+
+// Creates an open gate
+func NewOpenGate() *OpenGate {
+	gate := &OpenGate{}
+	gate.changed = sync.NewCond(&gate.mutex)
+	return gate
+}
+
+// Called before a file is opened or created, fails while the mount is drained
+func (gate *OpenGate) Check() error {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	if gate.draining {
+		return syscall.EBUSY
+	}
+	return nil
+}
+
+// Accounts a handle which was opened
+func (gate *OpenGate) Opened() {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	gate.open++
+}
+
+// Accounts a handle which was released
+func (gate *OpenGate) Closed() {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	gate.open--
+	gate.changed.Broadcast()
+}
+
+// Returns the number of open handles
+func (gate *OpenGate) Open() int {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	return gate.open
+}
+
+// Refuses new opens and waits for the open handles to be released, reporting every change of
+// their number. Accepts opens again and returns an error if handles are still open after the timeout
+func (gate *OpenGate) drain(timeout time.Duration, clock Clock, progress func(open int)) error {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	if gate.draining {
+		return fmt.Errorf("the mount is drained already")
+	}
+	gate.draining = true
+	timedOut := false
+	go func() {
+		<-clock.After(timeout)
+		gate.mutex.Lock()
+		defer gate.mutex.Unlock()
+		timedOut = true
+		gate.changed.Broadcast()
+	}()
+	reported := -1
+	for gate.open > 0 && !timedOut {
+		if gate.open != reported {
+			reported = gate.open
+			gate.mutex.Unlock()
+			progress(reported)
+			gate.mutex.Lock()
+			continue
+		}
+		gate.changed.Wait()
+	}
+	if gate.open > 0 {
+		gate.draining = false
+		return fmt.Errorf("%d files are still open after %v, the mount accepts opens again", gate.open, timeout)
+	}
+	return nil
+}
+
+// Accepts opens again, returns false if the mount was not drained
+func (gate *OpenGate) undrain() bool {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	if !gate.draining {
+		return false
+	}
+	gate.draining = false
+	return true
+}
+
+// Drains the mount and uploads the data of the staging files and the deferred flushes
+func (filesystem *FileSystem) drain(timeout time.Duration, out *AdminOutput) error {
+	loginfo("Draining the mount", Fields{Operation: DrainOp, Duration: timeout})
+	err := filesystem.Opens.drain(timeout, filesystem.Clock, func(open int) {
+		out.Printf("waiting for %d open files to be closed", open)
+	})
+	if err != nil {
+		logwarn("Failed to drain the mount", Fields{Operation: DrainOp, Error: err})
+		return err
+	}
+	if err := (&DeferredFlushes{FileSystem: filesystem}).Close(); err != nil {
+		filesystem.Opens.undrain()
+		return fmt.Errorf("failed to upload a deferred flush, the mount accepts opens again: %v", err)
+	}
+	if filesystem.Uploader != nil {
+		filesystem.Uploader.Close()
+	}
+	for _, file := range filesystem.StagedFiles() {
+		if dirty := file.Dirty(); dirty > 0 {
+			if err := file.syncHandles(nil, &fuse.FsyncRequest{}); err != nil {
+				filesystem.Opens.undrain()
+				return fmt.Errorf("failed to upload %s, the mount accepts opens again: %v", file.AbsolutePath(), err)
+			}
+			out.Printf("uploaded %s (%d bytes)", file.AbsolutePath(), dirty)
+		}
+	}
+	loginfo("Mount drained", Fields{Operation: DrainOp})
+	out.Printf("drained")
+	return nil
+}
+
+func drainCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	timeout := defaultDrainTimeout
+	if len(args) > 0 {
+		var err error
+		if timeout, err = time.ParseDuration(args[0]); err != nil {
+			return fmt.Errorf("invalid timeout %q: %v", args[0], err)
+		}
+	}
+	return filesystem.drain(timeout, out)
+}
+
+func undrainCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	if !filesystem.Opens.undrain() {
+		return fmt.Errorf("the mount is not drained")
+	}
+	loginfo("Mount accepts opens again", Fields{Operation: DrainOp})
+	out.Printf("undrained")
+	return nil
+}
+
+// Entry point of the "umount" sub command
+func runUnmount(args []string) int {
+	flags := flag.NewFlagSet("umount", flag.ExitOnError)
+	drain := flags.Bool("drain", false, "Waits for the open files to be closed and uploads their data before unmounting, refusing new opens meanwhile")
+	drainTimeout := flags.Duration("drainTimeout", defaultDrainTimeout, "How long -drain waits for the open files to be closed")
+	socket := flags.String("adminSocket", "", "Admin socket of the mount, same as its -adminSocket. By default it is derived from the mount point")
+	flags.Usage = func() {
+		subcommandUsage("umount")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	mountPoint := flags.Arg(0)
+	socketPath := ""
+	if *drain {
+		abs, err := filepath.Abs(mountPoint)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid path %s. Error: %v\n", mountPoint, err)
+			return 2
+		}
+		socketPath = adminSocketFor(*socket, abs)
+		req := AdminRequest{Command: "drain", Args: []string{drainTimeout.String()}}
+		if err := sendAdminRequest(socketPath, req, func(msg string) { fmt.Println(msg) }); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to drain %s. Error: %v\n", mountPoint, err)
+			return 1
+		}
+	}
+	if err := fuse.Unmount(mountPoint); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to unmount %s. Error: %v\n", mountPoint, err)
+		if *drain {
+			sendAdminRequest(socketPath, AdminRequest{Command: "undrain"}, func(string) {})
+		}
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that a drained mount refuses new opens and waits for the open files to be closed
+func TestDrain(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(WallClock{}), WallClock{})
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().OpenRead("/data").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 5, ReaderStats: &ReaderStats{}}, nil)
+	root, _ := fs.Root()
+	file := root.(*DirINode).NodeFromAttrs(Attrs{Name: "data", Mode: 0644, Size: 5}).(*FileINode)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, fs.Opens.Open())
+
+	out := &AdminOutput{encoder: json.NewEncoder(ioutil.Discard)}
+	drained := make(chan error)
+	go func() { drained <- fs.drain(time.Minute, out) }()
+	assert.Eventually(t, func() bool { return fs.Opens.Check() != nil }, time.Second, time.Millisecond)
+	_, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, syscall.EBUSY, err)
+	_, _, err = root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "new", Flags: fuse.OpenWriteOnly | fuse.OpenCreate, Mode: 0644}, &fuse.CreateResponse{})
+	assert.Equal(t, syscall.EBUSY, err)
+
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+	assert.Nil(t, <-drained)
+	assert.Nil(t, undrainCmd(fs, nil, out))
+	assert.Nil(t, fs.Opens.Check())
+}
+
+// Testing that a mount whose files stay open accepts opens again after the timeout
+func TestDrainTimeout(t *testing.T) {
+	gate := NewOpenGate()
+	gate.Opened()
+	var reported []int
+	err := gate.drain(10*time.Millisecond, WallClock{}, func(open int) { reported = append(reported, open) })
+	assert.EqualError(t, err, "1 files are still open after 10ms, the mount accepts opens again")
+	assert.Equal(t, []int{1}, reported)
+	assert.Nil(t, gate.Check())
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// Values of -durability, i.e., when data written to the staging files is uploaded to HDFS
+const (
+	DurabilityNone     = "none"     // on close only, fsync returns right away
+	DurabilityInterval = "interval" // on close and every -durabilityInterval in the background
+	DurabilityAlways   = "always"   // on close and on every fsync, which returns once the data is in HDFS
+)
+
+// Returns true if fsync uploads the data before returning
+func syncOnFsync() bool {
+	return durability == DurabilityAlways
+}
+
+// Uploads the data written to the open files every interval, for -durability=interval
+type DurabilityFlusher struct {
+	FileSystem *FileSystem
+	Interval   time.Duration
+	done       chan struct{}
+}
+
+// Creates the flusher
+func NewDurabilityFlusher(filesystem *FileSystem, interval time.Duration) *DurabilityFlusher {
+	return &DurabilityFlusher{FileSystem: filesystem, Interval: interval, done: make(chan struct{})}
+}
+
+// Flushes until closed
+func (flusher *DurabilityFlusher) Run() {
+	for {
+		select {
+		case <-flusher.done:
+			return
+		case <-flusher.FileSystem.Clock.After(flusher.Interval):
+			flusher.Flush()
+		}
+	}
+}
+
+// Uploads the files with data which is not in HDFS yet
+func (flusher *DurabilityFlusher) Flush() {
+	for _, file := range flusher.FileSystem.StagedFiles() {
+		if file.Dirty() == 0 {
+			continue
+		}
+		if err := file.syncHandles(nil, &fuse.FsyncRequest{}); err != nil {
+			logwarn("Background flush failed", file.logInfo(Fields{Operation: Fsync, Error: err}))
+		}
+	}
+}
+
+// Stops the flusher
+func (flusher *DurabilityFlusher) Close() error {
+	close(flusher.done)
+	return nil
+}
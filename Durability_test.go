@@ -0,0 +1,52 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that fsync does not upload with -durability=none and that the interval flusher uploads dirty files
+func TestDurability(t *testing.T) {
+	saveFlags(t, &durability)
+	durability = DurabilityNone
+
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	appends := 0
+	files := mockRemoteFiles(mockCtrl, hdfsAccessor, &appends)
+	hdfsAccessor.EXPECT().Chown(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+
+	root, _ := fs.Root()
+	n, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "data",
+		Flags: fuse.OpenWriteOnly | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	file := n.(*FileINode)
+	fileHandle := h.(*FileHandle)
+	assert.Equal(t, []*FileINode{file}, fs.StagedFiles())
+
+	fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("hello"), Offset: 0}, &fuse.WriteResponse{})
+	assert.Nil(t, file.Fsync(nil, &fuse.FsyncRequest{}))
+	assert.Equal(t, "", files["/data"].String())
+	assert.Equal(t, int64(5), file.Dirty())
+
+	NewDurabilityFlusher(fs, 0).Flush()
+	assert.Equal(t, "hello", files["/data"].String())
+	assert.Equal(t, int64(0), file.Dirty())
+
+	durability = DurabilityAlways
+	fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte(" world"), Offset: 5}, &fuse.WriteResponse{})
+	assert.Nil(t, file.Fsync(nil, &fuse.FsyncRequest{}))
+	assert.Equal(t, "hello world", files["/data"].String())
+
+	assert.Nil(t, fileHandle.Release(nil, nil))
+	assert.Equal(t, 0, len(fs.StagedFiles()))
+}
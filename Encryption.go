@@ -0,0 +1,99 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"context"
+	cryptotls "crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+// With -tls the RPCs with the namenodes go over TLS, authenticated with the client certificate.
+// The HDFS client connects to the datanodes without TLS, the block data is protected by the
+// SASL handshake of the data transfer protocol instead: -dataTransferProtection privacy
+// encrypts it, integrity signs it. If the namenode has dfs.encrypt.data.transfer enabled, the
+// data is encrypted whatever -dataTransferProtection says
+var dataTransferProtection string
+
+// Connects the HDFS client to the datanodes, net.Dialer if nil. The tests look at the bytes
+// exchanged with the datanodes through it
+var datanodeDialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+const (
+	DataTransferAuthentication = "authentication"
+	DataTransferIntegrity      = "integrity"
+	DataTransferPrivacy        = "privacy"
+)
+
+func validDataTransferProtection(protection string) bool {
+	switch protection {
+	case "", DataTransferAuthentication, DataTransferIntegrity, DataTransferPrivacy:
+		return true
+	}
+	return false
+}
+
+// Returns the credentials of the connections with the namenode of the command line
+func tlsConfigFromFlags() TLSConfig {
+	return TLSConfig{
+		TLS:                    *tls,
+		RootCABundle:           rootCABundle,
+		ClientCertificate:      clientCertificate,
+		ClientKey:              clientKey,
+		DataTransferProtection: dataTransferProtection,
+	}
+}
+
+// Checks that the credentials of a TLS config can be loaded, so that a mistyped path fails the
+// mount at once rather than every connection attempt with a handshake error
+func checkTLSConfig(config TLSConfig) error {
+	if !config.TLS {
+		return nil
+	}
+	if _, err := cryptotls.LoadX509KeyPair(config.ClientCertificate, config.ClientKey); err != nil {
+		return fmt.Errorf("unable to load client certificate %s with key %s: %v", config.ClientCertificate, config.ClientKey, err)
+	}
+	b, err := ioutil.ReadFile(config.RootCABundle)
+	if err != nil {
+		return fmt.Errorf("unable to read root CA bundle: %v", err)
+	}
+	// the HDFS client panics on a certificate of the bundle which does not parse
+	found := false
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return fmt.Errorf("invalid certificate in root CA bundle %s: %v", config.RootCABundle, err)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("no certificate found in root CA bundle %s", config.RootCABundle)
+	}
+	return nil
+}
+
+// Returns how the block data exchanged with the datanodes is protected
+func describeDataTransfer(config TLSConfig, defaults ServerDefaults) string {
+	switch {
+	case defaults.EncryptDataTransfer:
+		return "encrypted (dfs.encrypt.data.transfer)"
+	case config.DataTransferProtection == DataTransferPrivacy:
+		return "encrypted (-dataTransferProtection privacy)"
+	case config.DataTransferProtection != "":
+		return fmt.Sprintf("not encrypted (-dataTransferProtection %s)", config.DataTransferProtection)
+	}
+	return "not encrypted"
+}
+
+// Warns if the RPCs are encrypted but the block data is not, which -tls alone does not tell
+func warnUnencryptedDataTransfer(config TLSConfig, defaults ServerDefaults) {
+	if config.TLS && !defaults.EncryptDataTransfer && config.DataTransferProtection != DataTransferPrivacy {
+		logwarn("The RPCs with the namenode use TLS, the data read from and written to the datanodes is not encrypted. Use -dataTransferProtection privacy to encrypt it", Fields{Operation: CapabilitiesOp})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that TLS credentials which the HDFS client could not load are refused when mounting
+func TestCheckTLSConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "hdfs"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	config := TLSConfig{
+		TLS:               true,
+		RootCABundle:      filepath.Join(dir, "ca.pem"),
+		ClientCertificate: filepath.Join(dir, "cert.pem"),
+		ClientKey:         filepath.Join(dir, "key.pem"),
+	}
+	assert.Nil(t, ioutil.WriteFile(config.ClientCertificate, certificate, 0600))
+	assert.Nil(t, ioutil.WriteFile(config.ClientKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	assert.Nil(t, ioutil.WriteFile(config.RootCABundle, certificate, 0600))
+	assert.Nil(t, checkTLSConfig(config))
+	assert.Nil(t, checkTLSConfig(TLSConfig{ClientKey: "/missing"}))
+
+	missing := config
+	missing.ClientKey = filepath.Join(dir, "missing.pem")
+	assert.NotNil(t, checkTLSConfig(missing))
+
+	assert.Nil(t, ioutil.WriteFile(config.RootCABundle, append(certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")})...), 0600))
+	assert.Contains(t, checkTLSConfig(config).Error(), "invalid certificate in root CA bundle")
+	assert.Nil(t, ioutil.WriteFile(config.RootCABundle, []byte("not a bundle"), 0600))
+	assert.Contains(t, checkTLSConfig(config).Error(), "no certificate found")
+}
+
+func TestDataTransferProtection(t *testing.T) {
+	assert.True(t, validDataTransferProtection(""))
+	assert.True(t, validDataTransferProtection(DataTransferPrivacy))
+	assert.False(t, validDataTransferProtection("encrypted"))
+	assert.Equal(t, "encrypted (dfs.encrypt.data.transfer)", describeDataTransfer(TLSConfig{TLS: true}, ServerDefaults{EncryptDataTransfer: true}))
+	assert.Equal(t, "encrypted (-dataTransferProtection privacy)", describeDataTransfer(TLSConfig{DataTransferProtection: DataTransferPrivacy}, ServerDefaults{}))
+	assert.Equal(t, "not encrypted (-dataTransferProtection integrity)", describeDataTransfer(TLSConfig{DataTransferProtection: DataTransferIntegrity}, ServerDefaults{}))
+	assert.Equal(t, "not encrypted", describeDataTransfer(TLSConfig{TLS: true}, ServerDefaults{}))
+
+	dir, _ := ioutil.TempDir("", "routes")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "routes")
+	ioutil.WriteFile(file, []byte("/archive archive-nn:8020 dataTransferProtection=privacy\n"), 0600)
+	routes, err := parseRoutingTable(file, TLSConfig{DataTransferProtection: DataTransferIntegrity})
+	assert.Nil(t, err)
+	assert.Equal(t, DataTransferPrivacy, routes[0].TLS.DataTransferProtection)
+	ioutil.WriteFile(file, []byte("/archive archive-nn:8020 dataTransferProtection=yes\n"), 0600)
+	_, err = parseRoutingTable(file, TLSConfig{})
+	assert.EqualError(t, err, file+`:1: invalid dataTransferProtection "yes"`)
+}
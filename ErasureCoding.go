@@ -0,0 +1,57 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+// Whether a file is replicated or erasure coded, e.g., with RS-6-3-1024k, changes how it is
+// written and read, and was only visible with hdfs ec -getPolicy. The erasure coding policy of
+// a file or directory is its user.hdfs.erasureCodingPolicy extended attribute, the policy it
+// inherits from the closest directory above with one, or "replication" for replicated files.
+// Setting the attribute of a directory, e.g., one created through the mount, sets the policy
+// of the files created in it afterwards, the "replication" policy makes them replicated under
+// an erasure coded parent, and removing it unsets the policy. The policy of a file is the one
+// it was created with, setting it fails with ENOTSUP. The HDFS client has no erasure coding
+// RPCs, so setting the policy needs -webhdfsURL, and fails with ENOTSUP without it
+const erasureCodingXAttr = "user.hdfs.erasureCodingPolicy"
+
+// Name of the policy of the replicated files, as in HDFS
+const replicationPolicyName = "replication"
+
+// Returns the name of the erasure coding policy of the HDFS path
+func (filesystem *FileSystem) getErasureCodingXAttr(hdfsPath string) ([]byte, error) {
+	// not the cached attributes, the policy of a directory changes with the one of its parents
+	attrs, err := filesystem.getDFSConnector().Stat(hdfsPath)
+	if err != nil {
+		return nil, err
+	}
+	if attrs.EC == "" {
+		return []byte(replicationPolicyName), nil
+	}
+	return []byte(attrs.EC), nil
+}
+
+// Sets the erasure coding policy of the HDFS directory to the value of the attribute, unsets it if
+// unset is true
+func (filesystem *FileSystem) setErasureCodingXAttr(hdfsPath string, value []byte, unset bool) error {
+	policy := strings.TrimSpace(string(value))
+	if unset {
+		policy = ""
+	} else if policy == "" || strings.Trim(policy, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+		return syscall.EINVAL
+	}
+	if err := filesystem.checkWritableAt(hdfsPath); err != nil {
+		return err
+	}
+	filesystem.Mutations.Enter()
+	defer filesystem.Mutations.Exit()
+	if err := filesystem.getDFSConnector().SetErasureCodingPolicy(hdfsPath, policy); err != nil {
+		logwarn("Failed to set the erasure coding policy", Fields{Operation: ErasureCodingOp, Path: hdfsPath, Message: policy, Error: err})
+		return err
+	}
+	loginfo("Erasure coding policy set", Fields{Operation: ErasureCodingOp, Path: hdfsPath, Message: policy})
+	return nil
+}
@@ -0,0 +1,38 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the erasure coding policy of files and directories is read, and set on directories only
+func TestErasureCodingXAttr(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(WallClock{}), WallClock{})
+	root, _ := fs.Root()
+	dir := root.(*DirINode).NodeFromAttrs(Attrs{Name: "cold", Mode: os.ModeDir | 0755}).(*DirINode)
+	file := dir.NodeFromAttrs(Attrs{Name: "a", Mode: 0644}).(*FileINode)
+
+	hdfsAccessor.EXPECT().Stat("/cold").Return(Attrs{Name: "cold", Mode: os.ModeDir | 0755, EC: "RS-6-3-1024k"}, nil)
+	resp := &fuse.GetxattrResponse{}
+	assert.Nil(t, dir.Getxattr(nil, &fuse.GetxattrRequest{Name: erasureCodingXAttr}, resp))
+	assert.Equal(t, "RS-6-3-1024k", string(resp.Xattr))
+	hdfsAccessor.EXPECT().Stat("/cold/a").Return(Attrs{Name: "a", Mode: 0644}, nil)
+	assert.Nil(t, file.Getxattr(nil, &fuse.GetxattrRequest{Name: erasureCodingXAttr}, resp))
+	assert.Equal(t, "replication", string(resp.Xattr))
+
+	hdfsAccessor.EXPECT().SetErasureCodingPolicy("/cold", "XOR-2-1-1024k").Return(nil)
+	assert.Nil(t, dir.Setxattr(nil, &fuse.SetxattrRequest{Name: erasureCodingXAttr, Xattr: []byte("XOR-2-1-1024k")}))
+	hdfsAccessor.EXPECT().SetErasureCodingPolicy("/cold", "").Return(nil)
+	assert.Nil(t, dir.Removexattr(nil, &fuse.RemovexattrRequest{Name: erasureCodingXAttr}))
+	assert.Equal(t, syscall.EINVAL, dir.Setxattr(nil, &fuse.SetxattrRequest{Name: erasureCodingXAttr, Xattr: []byte("RS 6 3")}))
+	assert.Equal(t, syscall.ENOTSUP, file.Setxattr(nil, &fuse.SetxattrRequest{Name: erasureCodingXAttr, Xattr: []byte("RS-6-3-1024k")}))
+}
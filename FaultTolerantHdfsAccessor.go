@@ -4,12 +4,17 @@ package main
 
 import (
 	"os"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
 )
 
 // Adds automatic retry capability to HdfsAccessor with respect to RetryPolicy
 type FaultTolerantHdfsAccessor struct {
 	Impl        HdfsAccessor
 	RetryPolicy *RetryPolicy
+	User        string // HDFS user the operations of Impl run as, the mount's user if empty
 }
 
 var _ HdfsAccessor = (*FaultTolerantHdfsAccessor)(nil) // ensure FaultTolerantHdfsAccessor implements HdfsAccessor
@@ -39,10 +44,21 @@ func (fta *FaultTolerantHdfsAccessor) OpenRead(path string) (ReadSeekCloser, err
 		result, err := fta.Impl.OpenRead(path)
 		if err == nil {
 			// wrapping returned HdfsReader with FaultTolerantHdfsReader
-			return NewFaultTolerantHdfsReader(path, result, fta.Impl, fta.RetryPolicy), nil
+			op.Done(Open, nil)
+			reader := NewFaultTolerantHdfsReader(path, result, fta.Impl, fta.RetryPolicy)
+			if readHedger != nil {
+				return NewHedgedReader(path, reader, func() (ReadSeekCloser, error) {
+					hedge, err := fta.Impl.OpenRead(path)
+					if err != nil {
+						return nil, err
+					}
+					return NewFaultTolerantHdfsReader(path, hedge, fta.Impl, fta.RetryPolicy), nil
+				}, readHedger), nil
+			}
+			return reader, nil
 		}
 		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] OpenRead: %s", path, err) {
-			return nil, err
+			return nil, op.Done(Open, err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			fta.Impl.Close()
@@ -50,10 +66,38 @@ func (fta *FaultTolerantHdfsAccessor) OpenRead(path string) (ReadSeekCloser, err
 	}
 }
 
-// Opens HDFS file for writing
+// Opens HDFS file for writing. The retry of a creation whose reply was lost fails with EEXIST
+// without overwrite: the file is then created again with overwrite if it is empty and owned by
+// the user, as the earlier attempt left it, the writer of that attempt being lost
 func (fta *FaultTolerantHdfsAccessor) CreateFile(path string, mode os.FileMode, overwrite bool) (HdfsWriter, error) {
-	// TODO: implement fault-tolerance. For now re-try-loop is implemented inside FileHandleWriter
-	return fta.Impl.CreateFile(path, mode, overwrite)
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		result, err := fta.Impl.CreateFile(path, mode, overwrite)
+		if op.Attempt > 1 && !overwrite && isExistError(err) && fta.appliedByEarlierAttempt(Create, path, fta.isEmptyFileOfUser(path)) {
+			overwrite = true
+			result, err = fta.Impl.CreateFile(path, mode, overwrite)
+		}
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] CreateFile: %s", path, err) {
+			return result, op.Done(Create, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Opens HDFS file for appending. Retried, e.g., while the lease of a writer which died is recovered
+func (fta *FaultTolerantHdfsAccessor) Append(path string) (HdfsWriter, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		result, err := fta.Impl.Append(path)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] Append: %s", path, err) {
+			return result, op.Done(Append, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
 }
 
 // Enumerates HDFS directory
@@ -62,7 +106,7 @@ func (fta *FaultTolerantHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
 	for {
 		result, err := fta.Impl.ReadDir(path)
 		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] ReadDir: %s", path, err) {
-			return result, err
+			return result, op.Done(ReadDir, err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			fta.Impl.Close()
@@ -76,7 +120,7 @@ func (fta *FaultTolerantHdfsAccessor) Stat(path string) (Attrs, error) {
 	for {
 		result, err := fta.Impl.Stat(path)
 		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] Stat: %s", path, err) {
-			return result, err
+			return result, op.Done(Stat, err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			fta.Impl.Close()
@@ -90,7 +134,7 @@ func (fta *FaultTolerantHdfsAccessor) StatFs() (FsInfo, error) {
 	for {
 		result, err := fta.Impl.StatFs()
 		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("StatFs: %s", err) {
-			return result, err
+			return result, op.Done(StatFS, err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			fta.Impl.Close()
@@ -103,8 +147,11 @@ func (fta *FaultTolerantHdfsAccessor) Mkdir(path string, mode os.FileMode) error
 	op := fta.RetryPolicy.StartOperation()
 	for {
 		err := fta.Impl.Mkdir(path, mode)
+		if op.Attempt > 1 && isExistError(err) && fta.appliedByEarlierAttempt(Mkdir, path, isDirectoryAt(fta.Impl, path)) {
+			err = nil
+		}
 		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] Mkdir %s: %s", path, mode, err) {
-			return err
+			return op.Done(Mkdir, err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			fta.Impl.Close()
@@ -117,8 +164,28 @@ func (fta *FaultTolerantHdfsAccessor) Remove(path string) error {
 	op := fta.RetryPolicy.StartOperation()
 	for {
 		err := fta.Impl.Remove(path)
+		if op.Attempt > 1 && unwrapAndTranslateError(err) == syscall.ENOENT && fta.appliedByEarlierAttempt(Remove, path, true) {
+			err = nil
+		}
 		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] Remove: %s", path, err) {
-			return err
+			return op.Done(Remove, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Removes a file or directory recursively
+func (fta *FaultTolerantHdfsAccessor) RemoveAll(path string) error {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		err := fta.Impl.RemoveAll(path)
+		if op.Attempt > 1 && unwrapAndTranslateError(err) == syscall.ENOENT && fta.appliedByEarlierAttempt(RemoveAll, path, true) {
+			err = nil
+		}
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] RemoveAll: %s", path, err) {
+			return op.Done(RemoveAll, err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			fta.Impl.Close()
@@ -131,8 +198,12 @@ func (fta *FaultTolerantHdfsAccessor) Rename(oldPath string, newPath string) err
 	op := fta.RetryPolicy.StartOperation()
 	for {
 		err := fta.Impl.Rename(oldPath, newPath)
+		if op.Attempt > 1 && unwrapAndTranslateError(err) == syscall.ENOENT &&
+			fta.appliedByEarlierAttempt(Rename, oldPath, !existsAt(fta.Impl, oldPath) && existsAt(fta.Impl, newPath)) {
+			err = nil
+		}
 		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] Rename to %s: %s", oldPath, newPath, err) {
-			return err
+			return op.Done(Rename, err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			fta.Impl.Close()
@@ -146,7 +217,7 @@ func (fta *FaultTolerantHdfsAccessor) Chmod(path string, mode os.FileMode) error
 	for {
 		err := fta.Impl.Chmod(path, mode)
 		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("Chmod [%s] to [%d]: %s", path, mode, err) {
-			return err
+			return op.Done(Chmod, err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			fta.Impl.Close()
@@ -160,7 +231,105 @@ func (fta *FaultTolerantHdfsAccessor) Chown(path string, user, group string) err
 	for {
 		err := fta.Impl.Chown(path, user, group)
 		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("Chown [%s] to [%s:%s]: %s", path, user, group, err) {
-			return err
+			return op.Done(Chown, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Changes the modification time of the file
+func (fta *FaultTolerantHdfsAccessor) Chtimes(path string, mtime time.Time) error {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		err := fta.Impl.Chtimes(path, mtime)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("Chtimes [%s] to [%s]: %s", path, mtime, err) {
+			return op.Done(Chtimes, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Retrieves the HDFS checksum of the file
+func (fta *FaultTolerantHdfsAccessor) Checksum(path string) (FileChecksum, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		result, err := fta.Impl.Checksum(path)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] Checksum: %s", path, err) {
+			return result, op.Done(Checksum, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Retrieves the extended attributes of the file or directory
+func (fta *FaultTolerantHdfsAccessor) GetXAttrs(path string) (map[string]string, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		result, err := fta.Impl.GetXAttrs(path)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] GetXAttrs: %s", path, err) {
+			return result, op.Done(GetXAttrs, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Creates or replaces an extended attribute of the file or directory
+func (fta *FaultTolerantHdfsAccessor) SetXAttr(path, name, value string) error {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		err := fta.Impl.SetXAttr(path, name, value)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] SetXAttr: %s", path, err) {
+			return op.Done(SetXAttrOp, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Retrieves the ACL entries of the file or directory
+func (fta *FaultTolerantHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		result, err := fta.Impl.GetAcl(path)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] GetAcl: %s", path, err) {
+			return result, op.Done(GetAclOp, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Replaces the ACL of the file or directory
+func (fta *FaultTolerantHdfsAccessor) SetAcl(path string, entries []AclEntry) error {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		err := fta.Impl.SetAcl(path, entries)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] SetAcl: %s", path, err) {
+			return op.Done(SetAclOp, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Sets the storage policy of the file or directory, or unsets it if empty
+func (fta *FaultTolerantHdfsAccessor) SetStoragePolicy(path, policy string) error {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		err := fta.Impl.SetStoragePolicy(path, policy)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] SetStoragePolicy: %s", path, err) {
+			return op.Done(StoragePolicyOp, err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			fta.Impl.Close()
@@ -168,6 +337,106 @@ func (fta *FaultTolerantHdfsAccessor) Chown(path string, user, group string) err
 	}
 }
 
+// Sets the erasure coding policy of the directory, or unsets it if empty
+func (fta *FaultTolerantHdfsAccessor) SetErasureCodingPolicy(path, policy string) error {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		err := fta.Impl.SetErasureCodingPolicy(path, policy)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] SetErasureCodingPolicy: %s", path, err) {
+			return op.Done(ErasureCodingOp, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Retrieves the totals of a directory tree
+func (fta *FaultTolerantHdfsAccessor) GetContentSummary(path string) (ContentSummary, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		result, err := fta.Impl.GetContentSummary(path)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] GetContentSummary: %s", path, err) {
+			return result, op.Done(GetContentSummary, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Truncates the file
+func (fta *FaultTolerantHdfsAccessor) Truncate(path string, size int64) (bool, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		done, err := fta.Impl.Truncate(path, size)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("Truncate [%s] to [%d]: %s", path, size, err) {
+			return done, op.Done(Truncate, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Creates a snapshot of a snapshottable directory
+func (fta *FaultTolerantHdfsAccessor) CreateSnapshot(path, name string) (string, error) {
+	// not retried, a retry after the snapshot was created would fail as it exists already
+	return fta.Impl.CreateSnapshot(path, name)
+}
+
+// Retrieves the configuration of the namenode
+func (fta *FaultTolerantHdfsAccessor) ServerDefaults() (ServerDefaults, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		result, err := fta.Impl.ServerDefaults()
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("ServerDefaults: %s", err) {
+			return result, op.Done(ServerDefaultsOp, err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// A retried mutation may fail because an earlier attempt was applied by the namenode but its
+// reply was lost, e.g., a retried rename fails with ENOENT as the source is already gone. Such
+// failures are reported as success if the post-condition of the operation holds
+func (fta *FaultTolerantHdfsAccessor) appliedByEarlierAttempt(operation string, path string, postCondition bool) bool {
+	if postCondition {
+		loginfo("Operation was applied by an earlier attempt", Fields{Operation: operation, Path: path})
+	}
+	return postCondition
+}
+
+func existsAt(hdfsAccessor HdfsAccessor, path string) bool {
+	_, err := hdfsAccessor.Stat(path)
+	return err == nil
+}
+
+func isDirectoryAt(hdfsAccessor HdfsAccessor, path string) bool {
+	attrs, err := hdfsAccessor.Stat(path)
+	return err == nil && attrs.Mode&os.ModeDir != 0
+}
+
+// Returns true if the path is an empty file owned by the user of the accessor
+func (fta *FaultTolerantHdfsAccessor) isEmptyFileOfUser(path string) bool {
+	attrs, err := fta.Impl.Stat(path)
+	if err != nil || attrs.Mode.IsDir() || attrs.Size != 0 {
+		return false
+	}
+	user := fta.User
+	if user == "" {
+		user = hadoopUserName
+	}
+	return attrs.Uid == localUid(user)
+}
+
+func isExistError(err error) bool {
+	err = unwrapAndTranslateError(err)
+	return err == syscall.EEXIST || err == fuse.EEXIST || err == os.ErrExist
+}
+
 // Close underline connection if needed
 func (fta *FaultTolerantHdfsAccessor) Close() error {
 	return fta.Impl.Close()
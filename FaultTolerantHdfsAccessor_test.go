@@ -6,6 +6,7 @@ package main
 import (
 	"errors"
 	"os"
+	"syscall"
 	"testing"
 	"time"
 
@@ -88,3 +89,69 @@ func atMost2Attempts() *RetryPolicy {
 	rp.TimeLimit = time.Hour
 	return rp
 }
+
+// Testing that a retried rename whose earlier attempt was applied succeeds
+func TestRenameAppliedByEarlierAttempt(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	hdfsAccessor.EXPECT().Rename("/a", "/b").Return(errors.New("Injected timeout"))
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Rename("/a", "/b").Return(&os.PathError{Op: "rename", Path: "/a", Err: os.ErrNotExist})
+	hdfsAccessor.EXPECT().Stat("/a").Return(Attrs{}, syscall.ENOENT)
+	hdfsAccessor.EXPECT().Stat("/b").Return(Attrs{Name: "b"}, nil)
+	assert.Nil(t, ftHdfsAccessor.Rename("/a", "/b"))
+
+	// without a retry ENOENT is a failure
+	hdfsAccessor.EXPECT().Rename("/a", "/b").Return(&os.PathError{Op: "rename", Path: "/a", Err: os.ErrNotExist})
+	assert.NotNil(t, ftHdfsAccessor.Rename("/a", "/b"))
+}
+
+// Testing that a retried mkdir whose earlier attempt was applied succeeds, unless the path is a file
+func TestMkdirAppliedByEarlierAttempt(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	hdfsAccessor.EXPECT().Close().Return(nil).Times(2)
+	for _, mode := range []os.FileMode{os.ModeDir | 0755, 0644} {
+		gomock.InOrder(
+			hdfsAccessor.EXPECT().Mkdir("/d", os.FileMode(0755)).Return(errors.New("Injected timeout")),
+			hdfsAccessor.EXPECT().Mkdir("/d", os.FileMode(0755)).Return(syscall.EEXIST),
+			hdfsAccessor.EXPECT().Stat("/d").Return(Attrs{Name: "d", Mode: mode}, nil))
+		err := ftHdfsAccessor.Mkdir("/d", os.FileMode(0755))
+		if mode&os.ModeDir != 0 {
+			assert.Nil(t, err)
+		} else {
+			assert.Equal(t, syscall.EEXIST, err)
+		}
+	}
+}
+
+// Testing that a retried creation whose earlier attempt was applied creates the empty file of the
+// user again, and fails on a file which was not created by the earlier attempt
+func TestCreateFileAppliedByEarlierAttempt(t *testing.T) {
+	saveFlags(t, &hadoopUserName, &idMapper)
+	hadoopUserName, idMapper = "5001", &numericIdMapper{}
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	writer := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Close().Return(nil).Times(3)
+	for _, attrs := range []Attrs{{Name: "f", Mode: 0644, Uid: 5001}, {Name: "f", Mode: 0644, Uid: 5001, Size: 1}, {Name: "f", Mode: 0644, Uid: 6001}} {
+		calls := []*gomock.Call{
+			hdfsAccessor.EXPECT().CreateFile("/f", os.FileMode(0644), false).Return(nil, errors.New("Injected timeout")),
+			hdfsAccessor.EXPECT().CreateFile("/f", os.FileMode(0644), false).Return(nil, &os.PathError{Op: "create", Path: "/f", Err: os.ErrExist}),
+			hdfsAccessor.EXPECT().Stat("/f").Return(attrs, nil)}
+		if attrs.Size == 0 && attrs.Uid == 5001 {
+			calls = append(calls, hdfsAccessor.EXPECT().CreateFile("/f", os.FileMode(0644), true).Return(writer, nil))
+		}
+		gomock.InOrder(calls...)
+		w, err := ftHdfsAccessor.CreateFile("/f", os.FileMode(0644), false)
+		if attrs.Size == 0 && attrs.Uid == 5001 {
+			assert.Nil(t, err)
+			assert.Equal(t, writer, w)
+		} else {
+			assert.True(t, isExistError(err))
+		}
+	}
+}
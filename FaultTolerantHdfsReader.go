@@ -3,6 +3,11 @@
 // Licensed under the MIT license. See LICENSE file in the project root for details.
 package main
 
+import (
+	"errors"
+	"syscall"
+)
+
 // Implements ReadSeekCloser interface with automatic retries (acts as a proxy to HdfsReader)
 type FaultTolerantHdfsReader struct {
 	Path         string
@@ -10,12 +15,17 @@ type FaultTolerantHdfsReader struct {
 	HdfsAccessor HdfsAccessor
 	RetryPolicy  *RetryPolicy
 	Offset       int64
+	version      *FileVersion // version of the file when it was first opened, if known
 }
 
 var _ ReadSeekCloser = (*FaultTolerantHdfsReader)(nil) // ensure FaultTolerantHdfsReaderImpl implements ReadSeekCloser
+var _ VersionedReader = (*FaultTolerantHdfsReader)(nil)
+
 // Creates new instance of FaultTolerantHdfsReader
 func NewFaultTolerantHdfsReader(path string, impl ReadSeekCloser, hdfsAccessor HdfsAccessor, retryPolicy *RetryPolicy) *FaultTolerantHdfsReader {
-	return &FaultTolerantHdfsReader{Path: path, Impl: impl, HdfsAccessor: hdfsAccessor, RetryPolicy: retryPolicy}
+	ftr := &FaultTolerantHdfsReader{Path: path, Impl: impl, HdfsAccessor: hdfsAccessor, RetryPolicy: retryPolicy}
+	ftr.Version() // pinning the version of the file
+	return ftr
 }
 
 // Read a chunk of data
@@ -33,6 +43,16 @@ func (ftr *FaultTolerantHdfsReader) Read(buffer []byte) (int, error) {
 					return 0, err
 				}
 			}
+			// the file must not have been replaced in between, the reader would mix the content of two files
+			if ftr.version != nil {
+				if v, ok := ftr.Impl.(VersionedReader); ok {
+					if version, err := v.Version(); err == nil && version != *ftr.version {
+						logwarn("File changed while it was read", Fields{Operation: Read, Path: ftr.Path})
+						ftr.Close()
+						return 0, syscall.ESTALE
+					}
+				}
+			}
 			// Seeking to the right offset
 			if err = ftr.Impl.Seek(ftr.Offset); err != nil {
 				// Those errors are non-recoverable propagating right away
@@ -68,6 +88,24 @@ func (ftr *FaultTolerantHdfsReader) Seek(pos int64) error {
 	return err
 }
 
+// Returns the version of the file the reader was opened for. The version is pinned, a reader
+// reopened after a failure fails with ESTALE if the file was replaced or modified meanwhile
+func (ftr *FaultTolerantHdfsReader) Version() (FileVersion, error) {
+	if ftr.version != nil {
+		return *ftr.version, nil
+	}
+	v, ok := ftr.Impl.(VersionedReader)
+	if !ok {
+		return FileVersion{}, errors.New("Version is not known")
+	}
+	version, err := v.Version()
+	if err != nil {
+		return FileVersion{}, err
+	}
+	ftr.version = &version
+	return version, nil
+}
+
 // Returns current position
 func (ftr *FaultTolerantHdfsReader) Position() (int64, error) {
 	// This fault-tolerant wrapper keeps track the position on its own, no need
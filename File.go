@@ -7,11 +7,11 @@ package main
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 	"math/rand"
 	"os"
 	"path"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -23,13 +23,25 @@ import (
 
 type FileINode struct {
 	FileSystem *FileSystem // pointer to the FieSystem which owns this file
-	Attrs      Attrs       // Cache of file attributes // TODO: implement TTL
+	Attrs      Attrs       // Cache of file attributes, kept for -attrCacheTTL
 	Parent     *DirINode   // Pointer to the parent directory (allows computing fully-qualified paths on demand)
 
-	activeHandles   []*FileHandle // list of opened file handles
-	fileMutex       sync.Mutex    // mutex for file operation such as open, delete
-	fileProxy       FileProxy     // file proxy. Could be LocalRWFileProxy or RemoteFileProxy
-	fileHandleMutex sync.Mutex    // mutex for file handle
+	activeHandles    []*FileHandle      // list of opened file handles
+	fileMutex        sync.Mutex         // mutex for file operation such as open, delete
+	fileProxy        FileProxy          // file proxy. Could be LocalRWFileProxy or RemoteFileProxy
+	fileHandleMutex  sync.Mutex         // mutex for file handle
+	dirtyBytes       int64              // data written since the last upload, accounted in FileSystem.Dirty. Accessed atomically
+	logStream        *LogStream         // set while the staging file is open if the file is under -logStreamDirs
+	footer           *FileFooter        // cached tail of the file, accessed with fileHandleMutex held
+	mimeType         *fileMimeType      // sniffed type of the content, see Getxattr()
+	replaceTarget    string             // name of the file replaced by this one on close, see Setxattr(). Accessed with fileHandleMutex held
+	lingering        *RemoteROFileProxy // proxy of the last closed read-only handle, see linger(). Accessed with fileHandleMutex held
+	lingerExpires    time.Duration      // Clock.Monotonic() after which the lingering proxy is not reused
+	deferredFlush    *FileHandle        // handle whose flush is uploaded later with -flushCoalesceWindow, nil if none
+	deferredFlushDue time.Duration      // Clock.Monotonic() at which the deferred flush is uploaded
+	deferredFlushGen uint64             // incremented by every deferred flush, an upload only clears the flush it uploaded
+	deferredFlushErr error              // error of the last background upload of a deferred flush, see takeDeferredFlushErr()
+	pageCache        *pageCacheVersion  // content cached by the kernel with -keepPageCache, accessed with fileMutex held
 }
 
 // Verify that *File implements necesary FUSE interfaces
@@ -37,6 +49,10 @@ var _ fs.Node = (*FileINode)(nil)
 var _ fs.NodeOpener = (*FileINode)(nil)
 var _ fs.NodeFsyncer = (*FileINode)(nil)
 var _ fs.NodeSetattrer = (*FileINode)(nil)
+var _ fs.NodeAccesser = (*FileINode)(nil)
+var _ fs.NodeGetxattrer = (*FileINode)(nil)
+var _ fs.NodeSetxattrer = (*FileINode)(nil)
+var _ fs.NodeRemovexattrer = (*FileINode)(nil)
 
 // File is also a factory for ReadSeekCloser objects
 var _ ReadSeekCloserFactory = (*FileINode)(nil)
@@ -64,12 +80,19 @@ func (file *FileINode) Attr(ctx context.Context, a *fuse.Attr) error {
 		// update the local cache
 		file.Attrs.Size = uint64(fileInfo.Size())
 		file.Attrs.Mtime = fileInfo.ModTime()
+	} else if proxy, ok := file.fileProxy.(*StreamingFileProxy); ok {
+		size, mtime := proxy.stat()
+		file.Attrs.Size = uint64(size)
+		file.Attrs.Mtime = mtime
 	} else {
-		if file.FileSystem.Clock.Now().After(file.Attrs.Expires) {
+		expired := file.FileSystem.attrsExpired(&file.Attrs)
+		metrics.Record(AttrCacheOp, 0, 0, 0, !expired, nil)
+		if expired {
 			err := file.Parent.LookupAttrs(file.Attrs.Name, &file.Attrs)
 			if err != nil {
 				return err
 			}
+			file.checkPageCache()
 		}
 	}
 	return file.Attrs.ConvertAttrToFuse(a)
@@ -82,15 +105,41 @@ func (file *FileINode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fu
 	defer file.unlockFile()
 
 	logdebug("Opening file", Fields{Operation: Open, Path: file.AbsolutePath(), Flags: req.Flags})
-	handle, err := file.NewFileHandle(true, req.Flags)
+	if err := file.FileSystem.Opens.Check(); err != nil {
+		return nil, err
+	}
+	if openAccessMask(req.Flags)&accessWrite != 0 {
+		if err := file.FileSystem.checkWritableAt(file.AbsolutePath()); err != nil {
+			return nil, err
+		}
+	}
+	if err := file.FileSystem.checkAccess(&file.Attrs, req.Header, openAccessMask(req.Flags), file.AbsolutePath()); err != nil {
+		return nil, err
+	}
+	if resp != nil {
+		resp.Flags |= file.pageCacheFlags()
+	}
+	connector, err := file.FileSystem.connectorFor(req.Header)
 	if err != nil {
 		return nil, err
 	}
+	handle, err := file.NewFileHandleAs(connector, req.Uid, true, req.Flags)
+	if err != nil {
+		return nil, err
+	}
+	handle.ioClass = file.FileSystem.ioClassOf(file.Parent, req.Uid)
 
 	file.AddHandle(handle)
 	return handle, nil
 }
 
+// Responds on FUSE Access request. Only sent by the kernel if default_permissions is not used
+func (file *FileINode) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	file.lockFile()
+	defer file.unlockFile()
+	return file.FileSystem.checkAccess(&file.Attrs, req.Header, req.Mask, file.AbsolutePath())
+}
+
 // Opens file for reading
 func (file *FileINode) OpenRead() (ReadSeekCloser, error) {
 	file.lockFile()
@@ -108,6 +157,10 @@ func (file *FileINode) AddHandle(handle *FileHandle) {
 	file.lockFileHandles()
 	defer file.unlockFileHandles()
 	file.activeHandles = append(file.activeHandles, handle)
+	file.FileSystem.Opens.Opened()
+	if file.FileSystem.Handles != nil {
+		file.FileSystem.Handles.Add(handle)
+	}
 }
 
 // Unregisters an opened file handle
@@ -121,12 +174,16 @@ func (file *FileINode) RemoveHandle(handle *FileHandle) {
 	for i, h := range file.activeHandles {
 		if h == handle {
 			file.activeHandles = append(file.activeHandles[:i], file.activeHandles[i+1:]...)
+			file.FileSystem.Opens.Closed()
+			if file.FileSystem.Handles != nil {
+				file.FileSystem.Handles.Remove(handle)
+			}
 			break
 		}
 	}
 
-	//close the staging file if it is the last handle
-	if len(file.activeHandles) == 0 {
+	//close the staging file if it is the last handle, unless kept for a deferred flush
+	if len(file.activeHandles) == 0 && file.deferredFlush == nil {
 		file.closeStaging()
 	} else {
 		logtrace("Staging file is not closed.", file.logInfo(Fields{Operation: Close}))
@@ -136,17 +193,55 @@ func (file *FileINode) RemoveHandle(handle *FileHandle) {
 // close staging file
 func (file *FileINode) closeStaging() {
 	if file.fileProxy != nil { // if not already closed
-		err := file.fileProxy.Close()
+		if file.logStream != nil {
+			if err := file.logStream.Close(); err != nil {
+				logerror("Failed to stream file on close", file.logInfo(Fields{Operation: Close, Error: err}))
+			}
+			file.FileSystem.LogStreams.Remove(file.logStream)
+			file.logStream = nil
+		}
+		var err error
+		if proxy, ok := file.fileProxy.(*RemoteROFileProxy); !ok || !file.linger(proxy) {
+			err = file.fileProxy.Close()
+		}
 		if err != nil {
 			logerror("Failed to close staging file", file.logInfo(Fields{Operation: Close, Error: err}))
 		}
 		file.fileProxy = nil
+		file.FileSystem.removeStaged(file)
+		// data which was not uploaded is gone with the staging file
+		file.FileSystem.Dirty.Release(atomic.SwapInt64(&file.dirtyBytes, 0))
 		loginfo("Staging file is closed", file.logInfo(Fields{Operation: Close}))
 	}
 }
 
+// Updates the HDFS path recorded in the sidecar of the staging file after a rename
+func (file *FileINode) retagStaging() {
+	file.lockFileHandles()
+	defer file.unlockFileHandles()
+	if proxy, ok := file.fileProxy.(*LocalRWFileProxy); ok {
+		if err := tagStagingFile(proxy.localFile, proxy.owner()); err != nil {
+			logwarn("Failed to update staging file owner", file.logInfo(Fields{Operation: Rename, TmpFile: proxy.localFile.Name(), Error: err}))
+		}
+	}
+}
+
 // Responds to the FUSE Fsync request
 func (file *FileINode) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	if !syncOnFsync() {
+		logdebug("Fsync is a no-op with -durability "+durability, file.logInfo(Fields{Operation: Fsync}))
+		return nil
+	}
+	return file.syncHandles(ctx, req)
+}
+
+// Returns the amount of data written to the staging file which is not in HDFS yet
+func (file *FileINode) Dirty() int64 {
+	return atomic.LoadInt64(&file.dirtyBytes)
+}
+
+// Uploads the data written through any of the open handles
+func (file *FileINode) syncHandles(ctx context.Context, req *fuse.FsyncRequest) error {
 	loginfo(fmt.Sprintf("Dispatching fsync request to all open handles: %d", len(file.activeHandles)), Fields{Operation: Fsync})
 	file.lockFile()
 	defer file.unlockFile()
@@ -158,20 +253,37 @@ func (file *FileINode) Fsync(ctx context.Context, req *fuse.FsyncRequest) error
 			retErr = err
 		}
 	}
+	if handle := file.closedDeferredFlush(); handle != nil {
+		if err := handle.Fsync(ctx, req); err != nil {
+			retErr = err
+		}
+	}
 	return retErr
 }
 
 // Invalidates metadata cache, so next ls or stat gives up-to-date file attributes
 func (file *FileINode) InvalidateMetadataCache() {
-	file.Attrs.Expires = file.FileSystem.Clock.Now().Add(-1 * time.Second)
+	file.Attrs.Expires = file.FileSystem.Clock.Monotonic() - time.Second
 }
 
 // Responds on FUSE Chmod request
 func (file *FileINode) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if err := file.FileSystem.checkWritableAt(file.AbsolutePath()); err != nil {
+		return err
+	}
+	file.FileSystem.Mutations.Enter()
+	defer file.FileSystem.Mutations.Exit()
 	file.lockFile()
 	defer file.unlockFile()
 
+	if err := checkSetattr(file.FileSystem, &file.Attrs, req, file.AbsolutePath()); err != nil {
+		return err
+	}
+
 	if req.Valid.Size() {
+		if err := file.checkFileSize(Truncate, int64(req.Size)); err != nil {
+			return err
+		}
 		var err error = nil
 		for _, handle := range file.activeHandles {
 			err := handle.Truncate(int64(req.Size))
@@ -185,15 +297,19 @@ func (file *FileINode) Setattr(ctx context.Context, req *fuse.SetattrRequest, re
 	}
 
 	path := file.AbsolutePath()
+	hdfsAccessor, err := file.FileSystem.connectorFor(req.Header)
+	if err != nil {
+		return err
+	}
 
 	if req.Valid.Mode() {
-		if err := ChmodOp(&file.Attrs, file.FileSystem, path, req, resp); err != nil {
+		if err := ChmodOp(&file.Attrs, hdfsAccessor, path, req, resp); err != nil {
 			return err
 		}
 	}
 
 	if req.Valid.Uid() || req.Valid.Gid() {
-		if err := SetAttrChownOp(&file.Attrs, file.FileSystem, path, req, resp); err != nil {
+		if err := SetAttrChownOp(&file.Attrs, hdfsAccessor, path, req, resp); err != nil {
 			return err
 		}
 	}
@@ -207,18 +323,17 @@ func (file *FileINode) Setattr(ctx context.Context, req *fuse.SetattrRequest, re
 
 func (file *FileINode) countActiveHandles() int {
 	file.lockFileHandles()
-	file.unlockFileHandles()
+	defer file.unlockFileHandles()
 	return len(file.activeHandles)
 }
 
-func (file *FileINode) createStagingFile(operation string, existsInDFS bool) (*os.File, error) {
+func (file *FileINode) createStagingFile(operation string, existsInDFS bool, hdfsAccessor HdfsAccessor, uid uint32) (*os.File, error) {
 	if file.fileProxy != nil {
 		return nil, nil // there is already an active handle.
 	}
 
 	//create staging file
 	absPath := file.AbsolutePath()
-	hdfsAccessor := file.FileSystem.getDFSConnector()
 	if !existsInDFS { // it  is a new file so create it in the DFS
 		w, err := hdfsAccessor.CreateFile(absPath, file.Attrs.Mode, false)
 		if err != nil {
@@ -236,24 +351,42 @@ func (file *FileINode) createStagingFile(operation string, existsInDFS bool) (*o
 		}
 	}
 
-	stagingFile, err := ioutil.TempFile(stagingDir, "stage")
+	dir, err := userStagingDir(stagingDirFor(absPath), uid)
+	if err != nil {
+		logerror("Failed to create the staging dir of the user", file.logInfo(Fields{Operation: operation, UID: uid, Error: err}))
+		return nil, err
+	}
+	stagingFile, err := newStagingFile(dir, file.FileSystem.MountPoint, absPath)
 	if err != nil {
 		logerror("Failed to create staging file", file.logInfo(Fields{Operation: operation, Error: err}))
 		return nil, err
 	}
-	os.Remove(stagingFile.Name())
+	if stagingPerUser {
+		chownStaging(stagingFile.Name(), uid)
+	}
 	loginfo("Created staging file", file.logInfo(Fields{Operation: operation, TmpFile: stagingFile.Name()}))
 
 	if existsInDFS {
-		if err := file.downloadToStaging(stagingFile, operation); err != nil {
+		if err := file.downloadToStaging(stagingFile, operation, hdfsAccessor); err != nil {
+			removeStagingFile(stagingFile)
 			return nil, err
 		}
 	}
+	var size int64
+	if info, err := stagingFile.Stat(); err == nil {
+		size = info.Size()
+	}
+	file.FileSystem.addStaged(file, uid, size)
+	if isLogStreamPath(absPath) {
+		if info, err := stagingFile.Stat(); err == nil {
+			file.logStream = newLogStream(file, stagingFile, info.Size())
+			file.FileSystem.LogStreams.Add(file.logStream)
+		}
+	}
 	return stagingFile, nil
 }
 
-func (file *FileINode) downloadToStaging(stagingFile *os.File, operation string) error {
-	hdfsAccessor := file.FileSystem.getDFSConnector()
+func (file *FileINode) downloadToStaging(stagingFile *os.File, operation string, hdfsAccessor HdfsAccessor) error {
 	absPath := file.AbsolutePath()
 
 	reader, err := hdfsAccessor.OpenRead(absPath)
@@ -275,10 +408,15 @@ func (file *FileINode) downloadToStaging(stagingFile *os.File, operation string)
 
 // Creates new file handle
 func (file *FileINode) NewFileHandle(existsInDFS bool, flags fuse.OpenFlags) (*FileHandle, error) {
+	return file.NewFileHandleAs(nil, uint32(os.Getuid()), existsInDFS, flags)
+}
+
+// Creates new file handle for the uid whose RPCs are issued on the connector, the mount's if nil
+func (file *FileINode) NewFileHandleAs(connector HdfsAccessor, uid uint32, existsInDFS bool, flags fuse.OpenFlags) (*FileHandle, error) {
 	file.lockFileHandles()
 	defer file.unlockFileHandles()
 
-	fh := &FileHandle{File: file, fileFlags: flags, fhID: int64(rand.Uint64())}
+	fh := &FileHandle{File: file, fileFlags: flags, fhID: int64(rand.Uint64()), connector: connector, uid: uid}
 	operation := Create
 	if existsInDFS {
 		operation = Open
@@ -289,14 +427,23 @@ func (file *FileINode) NewFileHandle(existsInDFS bool, flags fuse.OpenFlags) (*F
 		if file.fileProxy != nil {
 			logpanic("Unexpected file state during creation", file.logInfo(Fields{Flags: flags}))
 		}
+		if isStreamingPath(file.AbsolutePath()) {
+			proxy, err := file.newStreamingFileProxy(operation, fh.dfsConnector(), uid)
+			if err != nil {
+				return nil, err
+			}
+			fh.File.fileProxy = proxy
+			loginfo("Opened file, streaming handle", fh.logInfo(Fields{Operation: operation, Flags: fh.fileFlags}))
+			return fh, nil
+		}
 		if err := file.checkDiskSpace(); err != nil {
 			return nil, err
 		}
-		stagingFile, err := file.createStagingFile(operation, existsInDFS)
+		stagingFile, err := file.createStagingFile(operation, existsInDFS, fh.dfsConnector(), uid)
 		if err != nil {
 			return nil, err
 		}
-		fh.File.fileProxy = &LocalRWFileProxy{localFile: stagingFile, file: file}
+		fh.File.fileProxy = newLocalRWFileProxy(stagingFile, file)
 		loginfo("Opened file, RW handle", fh.logInfo(Fields{Operation: operation, Flags: fh.fileFlags}))
 	} else {
 		if file.fileProxy != nil {
@@ -307,8 +454,13 @@ func (file *FileINode) NewFileHandle(existsInDFS bool, flags fuse.OpenFlags) (*F
 			// then we upgrade the handle. However, if the file is already opened in
 			// in RW state then we use the existing RW handle
 			// if file.handle
-			reader, _ := file.FileSystem.getDFSConnector().OpenRead(file.AbsolutePath())
-			fh.File.fileProxy = &RemoteROFileProxy{hdfsReader: reader, file: file}
+			if proxy := file.reuseLingering(); proxy != nil {
+				fh.File.fileProxy = proxy
+				loginfo("Opened file, reusing the reader of a closed RO handle", fh.logInfo(Fields{Operation: operation, Flags: fh.fileFlags}))
+				return fh, nil
+			}
+			reader, _ := fh.dfsConnector().OpenRead(file.AbsolutePath())
+			fh.File.fileProxy = &RemoteROFileProxy{hdfsReader: reader, file: file, path: file.AbsolutePath(), connector: fh.connector}
 			loginfo("Opened file, RO handle", fh.logInfo(Fields{Operation: operation, Flags: fh.fileFlags}))
 		}
 	}
@@ -324,6 +476,8 @@ func (file *FileINode) upgradeHandleForWriting(me *FileHandle) error {
 	var upgrade = false
 	if _, ok := file.fileProxy.(*LocalRWFileProxy); ok {
 		upgrade = false
+	} else if _, ok := file.fileProxy.(*StreamingFileProxy); ok {
+		upgrade = false
 	} else if _, ok := file.fileProxy.(*RemoteROFileProxy); ok {
 		upgrade = true
 	} else {
@@ -343,19 +497,29 @@ func (file *FileINode) upgradeHandleForWriting(me *FileHandle) error {
 		}
 
 		remoteROFileProxy, _ := file.fileProxy.(*RemoteROFileProxy)
-		remoteROFileProxy.hdfsReader.Close() // close this read only handle
+		remoteROFileProxy.Close() // close this read only handle
 		file.fileProxy = nil
 
+		if appendWrites && me.fileFlags&fuse.OpenAppend != 0 && !isLogStreamPath(file.AbsolutePath()) {
+			proxy, err := file.newAppendingFileProxy(me.dfsConnector(), me.uid)
+			if err == nil {
+				file.fileProxy = proxy
+				loginfo("Open handle upgrade to append", file.logInfo(Fields{Operation: Append}))
+				return nil
+			}
+			logwarn("Failed to open file for append, using a staging file", file.logInfo(Fields{Operation: Append, Error: err}))
+		}
+
 		if err := file.checkDiskSpace(); err != nil {
 			return err
 		}
 
-		stagingFile, err := file.createStagingFile("Open", true)
+		stagingFile, err := file.createStagingFile("Open", true, me.dfsConnector(), me.uid)
 		if err != nil {
 			return err
 		}
 
-		file.fileProxy = &LocalRWFileProxy{localFile: stagingFile, file: file}
+		file.fileProxy = newLocalRWFileProxy(stagingFile, file)
 		loginfo("Open handle upgrade to support RW ", file.logInfo(Fields{Operation: "Open"}))
 		return nil
 	}
@@ -363,11 +527,9 @@ func (file *FileINode) upgradeHandleForWriting(me *FileHandle) error {
 
 func (file *FileINode) checkDiskSpace() error {
 	var stat unix.Statfs_t
-	wd, err := os.Getwd()
-	if err != nil {
+	if err := unix.Statfs(stagingDirFor(file.AbsolutePath()), &stat); err != nil {
 		return err
 	}
-	unix.Statfs(wd, &stat)
 	// Available blocks * size per block = available space in bytes
 	bytesAvailable := stat.Bavail * uint64(stat.Bsize)
 	if bytesAvailable < 64*1024*1024 {
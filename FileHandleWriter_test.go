@@ -4,6 +4,7 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"io"
 	"os"
@@ -163,3 +164,81 @@ func TestFlushFile(t *testing.T) {
 	err = fileHandle.Release(nil, nil)
 	assert.Nil(t, err)
 }
+
+// Testing that a file written as file.part, synced and renamed into place is not uploaded again on close
+func TestRenameIntoPlace(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+
+	hdfswriter := NewMockHdfsWriter(mockCtrl)
+	hdfswriter.EXPECT().Write(gomock.Any()).DoAndReturn(func(p []byte) (int, error) { return len(p), nil }).AnyTimes()
+	hdfswriter.EXPECT().Close().Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().Stat(gomock.Any()).DoAndReturn(func(path string) (Attrs, error) {
+		return Attrs{Name: path[1:], Mode: os.FileMode(0644)}, nil
+	}).AnyTimes()
+	hdfsAccessor.EXPECT().Chown(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().Remove(gomock.Any()).Return(nil).AnyTimes()
+	// created once at open and uploaded once by fsync
+	hdfsAccessor.EXPECT().CreateFile("/file.part", os.FileMode(0644), gomock.Any()).Return(hdfswriter, nil).Times(2)
+	hdfsAccessor.EXPECT().Rename("/file.part", "/file").Return(nil)
+
+	root, _ := fs.Root()
+	n, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "file.part",
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("hello world"), Offset: int64(0)}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, n.(*FileINode).Fsync(nil, &fuse.FsyncRequest{}))
+	assert.Equal(t, int64(0), fs.Dirty.Dirty())
+
+	err = root.(*DirINode).Rename(nil, &fuse.RenameRequest{OldName: "file.part", NewName: "file"}, root)
+	assert.Nil(t, err)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+
+	// new data is uploaded to the new path
+	hdfsAccessor.EXPECT().CreateFile("/file", os.FileMode(0644), gomock.Any()).Return(hdfswriter, nil).Times(1)
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("!"), Offset: int64(11)}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+	mockCtrl.Finish()
+}
+
+// Testing that a file rewritten with the content it has in HDFS is only touched with -skipUnchangedUploads
+func TestSkipUnchangedUpload(t *testing.T) {
+	saveFlags(t, &skipUnchangedUploads)
+	skipUnchangedUploads = true
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+
+	hdfswriter := NewMockHdfsWriter(mockCtrl)
+	hdfswriter.EXPECT().Close().Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().Stat(gomock.Any()).DoAndReturn(func(path string) (Attrs, error) {
+		return Attrs{Name: path[1:], Mode: os.FileMode(0644)}, nil
+	}).AnyTimes()
+	hdfsAccessor.EXPECT().Chown(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	// only the empty file created at open
+	hdfsAccessor.EXPECT().CreateFile("/app.conf", os.FileMode(0644), gomock.Any()).Return(hdfswriter, nil).Times(1)
+	sum, _ := hex.DecodeString("27c076e4987344253650d3335a5d08ce")
+	hdfsAccessor.EXPECT().Checksum("/app.conf").Return(FileChecksum{Checksum: sum, Size: 4, BlockSize: 64 * 1024 * 1024, BytesPerChecksum: 512}, nil)
+	hdfsAccessor.EXPECT().Chtimes("/app.conf", gomock.Any()).Return(nil)
+
+	root, _ := fs.Root()
+	_, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "app.conf",
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("bar\n"), Offset: int64(0)}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Equal(t, int64(0), fs.Dirty.Dirty())
+	assert.Nil(t, fileHandle.Release(nil, nil))
+	mockCtrl.Finish()
+}
@@ -0,0 +1,22 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"syscall"
+)
+
+// A runaway job writing to the mount fills the staging dir, shared by all users of the edge
+// node, before anything is uploaded. With -maxFileSize, writes and truncates growing a file past
+// the limit fail with EFBIG, like on a local file system with a file size limit, before any
+// data is staged. Files already larger can be read, and written within their size
+var maxFileSize int64
+
+// Fails with EFBIG if the file would grow past -maxFileSize
+func (file *FileINode) checkFileSize(operation string, size int64) error {
+	if maxFileSize > 0 && size > maxFileSize && size > int64(file.Attrs.Size) {
+		logwarn("File would exceed -maxFileSize", file.logInfo(Fields{Operation: operation, Bytes: size}))
+		return syscall.EFBIG
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that writes and truncates past -maxFileSize fail with EFBIG before anything is staged
+func TestMaxFileSize(t *testing.T) {
+	saveFlags(t, &maxFileSize)
+	maxFileSize = 10
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().OpenRead("/out.log").DoAndReturn(func(path string) (ReadSeekCloser, error) {
+		return &MockReadSeekCloserWithPseudoRandomContent{FileSize: 5}, nil
+	}).AnyTimes()
+	hdfsAccessor.EXPECT().Stat("/out.log").Return(Attrs{Name: "out.log", Mode: 0644, Size: 5}, nil).AnyTimes()
+	file := root.(*DirINode).NodeFromAttrs(Attrs{Name: "out.log", Mode: 0644, Size: 5}).(*FileINode)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fh := h.(*FileHandle)
+
+	assert.Nil(t, fh.Write(nil, &fuse.WriteRequest{Data: []byte("12345"), Offset: 5}, &fuse.WriteResponse{}))
+	assert.Equal(t, syscall.EFBIG, fh.Write(nil, &fuse.WriteRequest{Data: []byte("1"), Offset: 10}, &fuse.WriteResponse{}))
+	assert.Equal(t, int64(5), fh.totalBytesWritten)
+	assert.Equal(t, syscall.EFBIG, file.Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 11}, &fuse.SetattrResponse{}))
+
+	// files which are larger already are rewritten within their size
+	large := root.(*DirINode).NodeFromAttrs(Attrs{Name: "large", Mode: 0644, Size: 20}).(*FileINode)
+	assert.Nil(t, large.checkFileSize(Write, 20))
+	assert.Equal(t, syscall.EFBIG, large.checkFileSize(Write, 21))
+	maxFileSize = 0
+	assert.Nil(t, large.checkFileSize(Write, 1<<40))
+}
@@ -16,23 +16,58 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"path"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 type FileSystem struct {
-	HdfsAccessors      []HdfsAccessor // Interface to access HDFS
-	hdfsAccessorsIndex int
-	SrcDir             string       // Src directory that will mounted
-	AllowedPrefixes    []string     // List of allowed path prefixes (only those prefixes are exposed via mountpoint)
-	ReadOnly           bool         // Indicates whether mount filesystem with readonly
-	Mounted            bool         // True if filesystem is mounted
-	RetryPolicy        *RetryPolicy // Retry policy
-	Clock              Clock        // interface to get wall clock time
-	FsInfo             FsInfo       // Usage of HDFS, including capacity, remaining, used sizes.
+	HdfsAccessors       []HdfsAccessor // Interface to access HDFS
+	hdfsAccessorsIndex  int
+	SrcDir              string               // Src directory that will mounted
+	AllowedPrefixes     []string             // List of allowed path prefixes (only those prefixes are exposed via mountpoint)
+	ReadOnly            bool                 // Indicates whether mount filesystem with readonly
+	Mounted             bool                 // True if filesystem is mounted
+	RetryPolicy         *RetryPolicy         // Retry policy
+	Clock               Clock                // interface to get wall clock time
+	FsInfo              FsInfo               // Usage of HDFS, including capacity, remaining, used sizes.
+	MountPoint          string               // Local directory where the filesystem is mounted
+	GroupResolver       GroupResolver        // Resolves HDFS groups of callers for -permissionChecks=client. Primary gid only if nil
+	Dirty               *DirtyTracker        // Data written to staging files which is not uploaded yet
+	LogStreams          *LogStreamer         // Streams the files written under -logStreamDirs
+	BlockCache          *BlockCache          // Caches blocks of files read from HDFS, nil if -blockCacheDir is not set
+	IOScheduler         *IOScheduler         // Prioritizes HDFS data transfers, nil if -maxTransfers is not set
+	Capabilities        *Capabilities        // Features of the backend, probed at mount time
+	Mutations           *MutationGate        // Blocks the mutations while the mount is frozen
+	Opens               *OpenGate            // Counts the open handles, refuses new ones while the mount is drained
+	Handles             *HandleRegistry      // Open file handles, listed and force-closed by the admin commands, nil in tests
+	Uploader            *AsyncUploader       // Uploads the flushes in the background, nil if -asyncUploads is not set
+	Pool                *ConnectionPool      // Connections with the namenode of HdfsAccessors, nil in tests
+	Canary              *CanaryMonitor       // Probes the mount end to end, nil if -canaryDir is not set
+	Heatmap             *AccessHeatmap       // Accounts the reads of each directory, nil if -heatmapFile is not set
+	Hooks               *Hooks               // Runs -hookCommand and -hookURL, nil if neither is set
+	Capacity            *CapacityMonitor     // Polls the usage of HDFS, nil if -capacityInterval is not set
+	CredentialRefresher *CredentialRefresher // Watches the client certificate, nil without -tls
+	UserConnectors      *UserConnectors      // Connections of the callers' users, nil without -impersonate
 
-	closeOnUnmount     []io.Closer // list of opened files (zip archives) to be closed on unmount
-	closeOnUnmountLock sync.Mutex  // mutex to protet closeOnUnmount
+	root               *DirINode               // Root directory, created on the first Root() call
+	rootMutex          sync.Mutex              // mutex to protect root
+	fuseServer         *fs.Server              // FUSE server used to invalidate kernel caches, nil if not serving
+	closeOnUnmount     []io.Closer             // list of opened files (zip archives) to be closed on unmount
+	closeOnUnmountLock sync.Mutex              // mutex to protet closeOnUnmount
+	staged             map[*FileINode]struct{} // files with an open staging file
+	stagedMutex        sync.Mutex
+	crcSidecarSyncs    sync.Map // HDFS paths of the CRC sidecars being generated, see syncCrcSidecar()
+
+	stagingReserved  map[*FileINode]stagingReservation // sizes accounted to the staging files, see reserveStagingSize(). Accessed with stagedMutex held
+	stagingBytes     int64                             // total of stagingReserved
+	stagingUserBytes map[uint32]int64                  // total of stagingReserved of each uid
+
+	quotaSummary        *ContentSummary // of the closest quota of the source dir, see quotaStatfs()
+	quotaSummaryExpires time.Duration
+	quotaSummaryMutex   sync.Mutex
 }
 
 // Verify that *FileSystem implements necesary FUSE interfaces
@@ -48,6 +83,13 @@ func NewFileSystem(hdfsAccessors []HdfsAccessor, srcDir string, allowedPrefixes
 		ReadOnly:        readOnly,
 		RetryPolicy:     retryPolicy,
 		Clock:           clock,
+		Dirty:           NewDirtyTracker(clock),
+		LogStreams:      NewLogStreamer(logStreamInterval, clock),
+		Capabilities:    assumedCapabilities(),
+		Mutations:       NewMutationGate(),
+		Opens:           NewOpenGate(),
+		Handles:         NewHandleRegistry(),
+		staged:          make(map[*FileINode]struct{}),
 		SrcDir:          srcDir}, nil
 }
 
@@ -63,6 +105,7 @@ func (filesystem *FileSystem) Mount(mountPoint string, conf ...fuse.MountOption)
 		return nil, err
 	}
 	filesystem.Mounted = true
+	filesystem.MountPoint = mountPoint
 	return conn, nil
 }
 
@@ -88,8 +131,23 @@ func (filesystem *FileSystem) Unmount(mountPoint string) {
 	}
 }
 
+// Fails with EROFS on a read-only mount. The kernel refuses most writes to a read-only mount
+// already, this keeps the requests reaching the mount anyway from changing HDFS
+func (filesystem *FileSystem) checkWritable() error {
+	if filesystem.ReadOnly {
+		return syscall.EROFS
+	}
+	return nil
+}
+
 // Returns root directory of the filesystem
 func (filesystem *FileSystem) Root() (fs.Node, error) {
+	filesystem.rootMutex.Lock()
+	defer filesystem.rootMutex.Unlock()
+	if filesystem.root != nil {
+		return filesystem.root, nil
+	}
+
 	//get UID and GID for the current user
 	cu, err := user.Current()
 	if err != nil {
@@ -98,7 +156,7 @@ func (filesystem *FileSystem) Root() (fs.Node, error) {
 	uid64, _ := strconv.ParseUint(cu.Uid, 10, 32)
 	gid64, _ := strconv.ParseUint(cu.Gid, 10, 32)
 
-	return &DirINode{FileSystem: filesystem, Parent: nil, Attrs: Attrs{
+	filesystem.root = &DirINode{FileSystem: filesystem, Parent: nil, Attrs: Attrs{
 		Inode:  1,
 		Uid:    uint32(uid64),
 		Gid:    uint32(gid64),
@@ -106,7 +164,79 @@ func (filesystem *FileSystem) Root() (fs.Node, error) {
 		Mtime:  filesystem.Clock.Now(),
 		Ctime:  filesystem.Clock.Now(),
 		Crtime: filesystem.Clock.Now()},
-	}, nil
+	}
+	return filesystem.root, nil
+}
+
+// Serves FUSE requests on the connection until the file system is unmounted
+func (filesystem *FileSystem) Serve(conn *fuse.Conn) error {
+	filesystem.fuseServer = fs.New(conn, nil)
+	return filesystem.fuseServer.Serve(filesystem)
+}
+
+// Returns the cached node for an HDFS path, or nil if the node is not cached.
+// Must not be called while holding a directory lock
+func (filesystem *FileSystem) cachedNode(hdfsPath string) fs.Node {
+	root, _ := filesystem.Root()
+	rel := strings.TrimPrefix(path.Clean(hdfsPath), path.Clean(filesystem.SrcDir))
+	var node fs.Node = root
+	for _, name := range strings.Split(rel, "/") {
+		if name == "" {
+			continue
+		}
+		dir, ok := node.(*DirINode)
+		if !ok {
+			return nil
+		}
+		if node = dir.cachedEntry(name); node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// Drops the cached node of an HDFS path (and its subtree), so that next
+// access fetches it from the backend. Kernel caches are invalidated as well
+func (filesystem *FileSystem) forgetCachedNode(hdfsPath string) {
+	parent, ok := filesystem.cachedNode(path.Dir(hdfsPath)).(*DirINode)
+	if !ok {
+		return
+	}
+	name := path.Base(hdfsPath)
+	parent.lockMutex()
+	parent.EntriesRemove(name)
+	parent.unlockMutex()
+	filesystem.invalidateEntry(parent, name)
+}
+
+// Invalidates the kernel cache of the node attributes
+func (filesystem *FileSystem) invalidateNodeAttr(node fs.Node) {
+	if filesystem.fuseServer == nil {
+		return
+	}
+	if err := filesystem.fuseServer.InvalidateNodeAttr(node); err != nil && err != fuse.ErrNotCached {
+		logwarn("Failed to invalidate kernel attribute cache", Fields{Error: err})
+	}
+}
+
+// Invalidates the kernel cache of the node attributes and data
+func (filesystem *FileSystem) invalidateNodeData(node fs.Node) {
+	if filesystem.fuseServer == nil {
+		return
+	}
+	if err := filesystem.fuseServer.InvalidateNodeData(node); err != nil && err != fuse.ErrNotCached {
+		logwarn("Failed to invalidate kernel data cache", Fields{Error: err})
+	}
+}
+
+// Invalidates the kernel cache of a directory entry
+func (filesystem *FileSystem) invalidateEntry(parent *DirINode, name string) {
+	if filesystem.fuseServer == nil {
+		return
+	}
+	if err := filesystem.fuseServer.InvalidateEntry(parent, name); err != nil && err != fuse.ErrNotCached {
+		logwarn("Failed to invalidate kernel cache", Fields{Path: parent.AbsolutePathForChild(name), Error: err})
+	}
 }
 
 // Returns if given absoute path allowed by any of the prefixes
@@ -127,6 +257,31 @@ func (filesystem *FileSystem) IsPathAllowed(path string) bool {
 }
 
 // Register a file to be closed on Unmount()
+func (filesystem *FileSystem) addStaged(file *FileINode, uid uint32, size int64) {
+	filesystem.stagedMutex.Lock()
+	defer filesystem.stagedMutex.Unlock()
+	filesystem.staged[file] = struct{}{}
+	filesystem.accountStaging(file, uid, size)
+}
+
+func (filesystem *FileSystem) removeStaged(file *FileINode) {
+	filesystem.stagedMutex.Lock()
+	defer filesystem.stagedMutex.Unlock()
+	delete(filesystem.staged, file)
+	filesystem.releaseStaging(file)
+}
+
+// Returns the files which have an open staging file
+func (filesystem *FileSystem) StagedFiles() []*FileINode {
+	filesystem.stagedMutex.Lock()
+	defer filesystem.stagedMutex.Unlock()
+	files := make([]*FileINode, 0, len(filesystem.staged))
+	for file := range filesystem.staged {
+		files = append(files, file)
+	}
+	return files
+}
+
 func (filesystem *FileSystem) CloseOnUnmount(file io.Closer) {
 	filesystem.closeOnUnmountLock.Lock()
 	defer filesystem.closeOnUnmountLock.Unlock()
@@ -136,15 +291,22 @@ func (filesystem *FileSystem) CloseOnUnmount(file io.Closer) {
 // Statfs is called to obtain file system metadata.
 // It should write that data to resp.
 func (filesystem *FileSystem) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
-	fsInfo, err := filesystem.getDFSConnector().StatFs()
-	if err != nil {
-		logwarn("Stat DFS failed", Fields{Operation: StatFS, Error: err})
-		return err
+	fsInfo, polled := FsInfo{}, false
+	if filesystem.Capacity != nil {
+		fsInfo, polled = filesystem.Capacity.FsInfo()
+	}
+	if !polled {
+		var err error
+		if fsInfo, err = filesystem.getDFSConnector().StatFs(); err != nil {
+			logwarn("Stat DFS failed", Fields{Operation: StatFS, Error: err})
+			return err
+		}
 	}
 	resp.Bsize = 1024
 	resp.Bfree = fsInfo.remaining / uint64(resp.Bsize)
 	resp.Bavail = resp.Bfree
 	resp.Blocks = fsInfo.capacity / uint64(resp.Bsize)
+	filesystem.quotaStatfs(resp)
 	return nil
 }
 
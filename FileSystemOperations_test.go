@@ -3,18 +3,24 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io/fs"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"bazil.org/fuse/fs/fstestutil"
+	"golang.org/x/sys/unix"
 )
 
 func TestReadWriteEmptyFile(t *testing.T) {
@@ -259,9 +265,15 @@ func TestGitClone(t *testing.T) {
 }
 
 func withMount(t testing.TB, srcDir string, fn func(mntPath string, hdfsAccessor HdfsAccessor)) {
+	t.Helper()
+	withMountConfig(t, srcDir, TLSConfig{TLS: false}, fn)
+}
+
+// Mounts with the credentials and data transfer protection of the config
+func withMountConfig(t testing.TB, srcDir string, tlsConfig TLSConfig, fn func(mntPath string, hdfsAccessor HdfsAccessor)) {
 	t.Helper()
 	//initLogger("debug", false, "")
-	hdfsAccessor, _ := NewHdfsAccessor("localhost:8020", WallClock{}, TLSConfig{TLS: false})
+	hdfsAccessor, _ := NewHdfsAccessor("localhost:8020", WallClock{}, tlsConfig)
 	err := hdfsAccessor.EnsureConnected()
 	if err != nil {
 		t.Fatalf(fmt.Sprintf("Error/NewHdfsAccessor: %v ", err), nil)
@@ -308,6 +320,170 @@ func createFile(t testing.TB, filePath string, data string) {
 	out.Close()
 }
 
+// Testing that memory mapped files read the content of the file, and that shared writable mappings are uploaded
+func TestMmap(t *testing.T) {
+	withMount(t, "/", func(mountPoint string, hdfsAccessor HdfsAccessor) {
+		testFile := filepath.Join(mountPoint, "mmap_file")
+		os.Remove(testFile)
+		data := make([]byte, 3*1024*1024+17)
+		rand.New(rand.NewSource(1)).Read(data)
+		if err := ioutil.WriteFile(testFile, data, 0644); err != nil {
+			t.Fatalf("Unable to write %s. Error: %v", testFile, err)
+		}
+		if mapped := mmapFile(t, testFile); !bytes.Equal(data, mapped) {
+			t.Errorf("The mapped content of %s differs from the written content", testFile)
+		}
+
+		f, err := os.OpenFile(testFile, os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatalf("Unable to open %s. Error: %v", testFile, err)
+		}
+		mapped, err := syscall.Mmap(int(f.Fd()), 0, len(data), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+		if err != nil {
+			t.Fatalf("Unable to map %s for writing. Error: %v", testFile, err)
+		}
+		copy(mapped[1024*1024:], "written through a mapping")
+		copy(data[1024*1024:], "written through a mapping")
+		if err := unix.Msync(mapped, unix.MS_SYNC); err != nil {
+			t.Errorf("Msync failed. Error: %v", err)
+		}
+		syscall.Munmap(mapped)
+		if err := f.Close(); err != nil {
+			t.Errorf("Close failed. Error: %v", err)
+		}
+		if content, _ := ioutil.ReadFile(testFile); !bytes.Equal(data, content) {
+			t.Errorf("The writes through the mapping of %s are lost", testFile)
+		}
+		rmFile(t, testFile)
+	})
+}
+
+// Testing that with -keepPageCache a file changed in HopsFS is not read from stale cached pages
+func TestMmapKeepPageCache(t *testing.T) {
+	saveFlags(t, &keepPageCache)
+	keepPageCache = true
+	withMount(t, "/", func(mountPoint string, hdfsAccessor HdfsAccessor) {
+		testFile := filepath.Join(mountPoint, "mmap_model")
+		os.Remove(testFile)
+		createFile(t, testFile, "first version")
+		for i := 0; i < 2; i++ {
+			if mapped := mmapFile(t, testFile); string(mapped) != "first version" {
+				t.Errorf("Unexpected mapped content %q", mapped)
+			}
+		}
+
+		// rewritten behind the mount
+		hdfsAccessor.Remove("/mmap_model")
+		w, err := hdfsAccessor.CreateFile("/mmap_model", 0644, true)
+		if err != nil {
+			t.Fatalf("Unable to rewrite the file in HopsFS. Error: %v", err)
+		}
+		w.Write([]byte("second version!"))
+		w.Close()
+		time.Sleep(6 * time.Second) // the attributes expire
+		if mapped := mmapFile(t, testFile); string(mapped) != "second version!" {
+			t.Errorf("Unexpected mapped content %q after the file changed", mapped)
+		}
+		rmFile(t, testFile)
+	})
+}
+
+// Connection to a datanode which keeps a copy of the bytes sent and received
+type recordingConn struct {
+	net.Conn
+	mutex   *sync.Mutex
+	traffic *bytes.Buffer
+}
+
+func (conn *recordingConn) Read(b []byte) (int, error) {
+	n, err := conn.Conn.Read(b)
+	conn.mutex.Lock()
+	conn.traffic.Write(b[:n])
+	conn.mutex.Unlock()
+	return n, err
+}
+
+func (conn *recordingConn) Write(b []byte) (int, error) {
+	conn.mutex.Lock()
+	conn.traffic.Write(b)
+	conn.mutex.Unlock()
+	return conn.Conn.Write(b)
+}
+
+// Testing that with -dataTransferProtection privacy the data written through the mount goes
+// encrypted to the datanodes, and is read back intact through the mount and from HopsFS
+func TestDataTransferPrivacy(t *testing.T) {
+	var mutex sync.Mutex
+	var traffic bytes.Buffer
+	saveFlags(t, &datanodeDialFunc)
+	datanodeDialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &recordingConn{Conn: conn, mutex: &mutex, traffic: &traffic}, nil
+	}
+	withMountConfig(t, "/", TLSConfig{DataTransferProtection: DataTransferPrivacy}, func(mountPoint string, hdfsAccessor HdfsAccessor) {
+		testFile := filepath.Join(mountPoint, "encrypted_file")
+		os.Remove(testFile)
+		data := bytes.Repeat([]byte("plaintext of the encrypted file "), 32*1024)
+		if err := ioutil.WriteFile(testFile, data, 0644); err != nil {
+			t.Fatalf("Unable to write %s. Error: %v", testFile, err)
+		}
+
+		mutex.Lock()
+		sent := traffic.Len()
+		leaked := bytes.Contains(traffic.Bytes(), data[:64])
+		mutex.Unlock()
+		if sent < len(data) {
+			t.Fatalf("Only %d bytes of the %d written went to the datanodes", sent, len(data))
+		}
+		if leaked {
+			t.Errorf("The data written to the datanodes is not encrypted")
+		}
+
+		if content, err := ioutil.ReadFile(testFile); err != nil || !bytes.Equal(data, content) {
+			t.Errorf("The content read back through the mount differs from the written content. Error: %v", err)
+		}
+		reader, err := hdfsAccessor.OpenRead("/encrypted_file")
+		if err != nil {
+			t.Fatalf("Unable to open the file in HopsFS. Error: %v", err)
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil || !bytes.Equal(data, content) {
+			t.Errorf("The content read from HopsFS differs from the written content. Error: %v", err)
+		}
+		mutex.Lock()
+		leaked = bytes.Contains(traffic.Bytes(), data[:64])
+		mutex.Unlock()
+		if leaked {
+			t.Errorf("The data read from the datanodes is not encrypted")
+		}
+		rmFile(t, testFile)
+	})
+}
+
+// Returns a copy of the content of a file read through a read-only mapping
+func mmapFile(t testing.TB, filePath string) []byte {
+	t.Helper()
+	f, err := os.Open(filePath)
+	if err != nil {
+		t.Fatalf("Unable to open %s. Error: %v", filePath, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Unable to stat %s. Error: %v", filePath, err)
+	}
+	mapped, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		t.Fatalf("Unable to map %s. Error: %v", filePath, err)
+	}
+	defer syscall.Munmap(mapped)
+	return append([]byte(nil), mapped...)
+}
+
 func listDir(t testing.TB, dir string) []fs.FileInfo {
 	t.Helper()
 	content, err := ioutil.ReadDir(dir)
@@ -0,0 +1,19 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Restores the flags, or other package variables, a test changes once it ended, e.g.,
+// saveFlags(t, &readaheadBytes, &readaheadStreams) before setting them
+func saveFlags(t testing.TB, flags ...interface{}) {
+	for _, flag := range flags {
+		value := reflect.ValueOf(flag).Elem()
+		saved := reflect.New(value.Type()).Elem()
+		saved.Set(value)
+		t.Cleanup(func() { value.Set(saved) })
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"time"
+)
+
+// Every close of a written file uploads the whole staging file. Editors saving through a
+// temporary close and reopen the file, and checkpointing libraries rewrite it several times in
+// a row, so a close storm on one file uploaded it back to back. With -flushCoalesceWindow, the
+// upload of the flush of a file is deferred for the window, and a flush of the file within the
+// window defers it again, so that the flushes of a storm become one upload of the latest content
+// once the file was left alone for the window. The staging file is kept meanwhile, and is
+// reused by the next open without a download. fsync, a rename and the unmount upload right
+// away. Close returns before the data is in HDFS, so a successful close does not mean that the
+// data is durable, only fsync does. A failed upload is logged, parked with -failedUploadsDir,
+// and returned by the next flush or fsync of the file, e.g., by the close of its next handle
+var flushCoalesceWindow time.Duration
+
+// Defers the upload of the flush of the handle, returns false if it has to be uploaded now.
+// Called with the handle locked
+func (fh *FileHandle) deferFlush() bool {
+	if (flushCoalesceWindow <= 0 && fh.File.FileSystem.Uploader == nil) || fh.File.logStream != nil {
+		return false
+	}
+	file := fh.File
+	file.lockFileHandles()
+	defer file.unlockFileHandles()
+	if _, ok := file.fileProxy.(*LocalRWFileProxy); !ok {
+		return false
+	}
+	file.deferredFlush = fh
+	file.deferredFlushGen++
+	file.deferredFlushDue = file.FileSystem.Clock.Monotonic() + flushCoalesceWindow
+	if flushCoalesceWindow > 0 {
+		time.AfterFunc(flushCoalesceWindow, file.dispatchDeferredFlush)
+	} else {
+		file.dispatchDeferredFlush()
+	}
+	logdebug("Deferred the upload of the flush", fh.logInfo(Fields{Operation: Flush}))
+	return true
+}
+
+// Uploads the deferred flush of the file once its window passed, right away if forced, and
+// closes the staging file if the file is not open anymore. The deferred flush is kept during the
+// upload, so that an fsync meanwhile waits for it, and is only cleared if no flush was deferred
+// again since the upload read the data
+func (file *FileINode) flushDeferred(force bool) error {
+	file.lockFileHandles()
+	fh := file.deferredFlush
+	if fh == nil || (!force && file.FileSystem.Clock.Monotonic() < file.deferredFlushDue) {
+		file.unlockFileHandles()
+		return nil
+	}
+	file.unlockFileHandles()
+
+	fh.lockHandle()
+	// the flushes of the handle are deferred with the handle locked, the upload has their data
+	file.lockFileHandles()
+	gen := file.deferredFlushGen
+	file.unlockFileHandles()
+	var err error
+	uploaded := fh.dataChanged()
+	if uploaded {
+		loginfo("Uploading the deferred flush", fh.logInfo(Fields{Operation: Flush}))
+		if err = fh.copyToDFS(Flush); err != nil {
+			logerror("Failed to upload the deferred flush", fh.logInfo(Fields{Operation: Flush, Error: err}))
+		}
+	}
+	fh.unlockHandle()
+	file.lockFileHandles()
+	if file.deferredFlushGen == gen {
+		file.deferredFlush = nil
+	}
+	if uploaded && !force {
+		// nobody waits for the background upload, the next flush or fsync of the file reports it
+		file.deferredFlushErr = err
+	}
+	file.unlockFileHandles()
+	file.closeStagingIfUnused()
+	return err
+}
+
+// Returns the error of the last background upload of a deferred flush of the file, once
+func (file *FileINode) takeDeferredFlushErr() error {
+	file.lockFileHandles()
+	defer file.unlockFileHandles()
+	err := file.deferredFlushErr
+	file.deferredFlushErr = nil
+	return err
+}
+
+// Drops the deferred flush of a file which was removed
+func (file *FileINode) discardDeferredFlush() {
+	file.lockFileHandles()
+	discarded := file.deferredFlush != nil
+	file.deferredFlush = nil
+	file.unlockFileHandles()
+	if discarded {
+		file.closeStagingIfUnused()
+	}
+}
+
+// Closes the staging file kept for a deferred flush once the file has no handles and no deferred flush
+func (file *FileINode) closeStagingIfUnused() {
+	file.lockFile()
+	defer file.unlockFile()
+	file.lockFileHandles()
+	defer file.unlockFileHandles()
+	if len(file.activeHandles) == 0 && file.deferredFlush == nil {
+		file.closeStaging()
+	}
+}
+
+// Uploads the deferred flushes of all files, at unmount
+type DeferredFlushes struct {
+	FileSystem *FileSystem
+}
+
+func (flushes *DeferredFlushes) Close() error {
+	var firstErr error
+	for _, file := range flushes.FileSystem.StagedFiles() {
+		if err := file.flushDeferred(true); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Returns the handle of the deferred flush if it is not among the open handles, nil otherwise,
+// for fsync to upload its data as well. Called with the file locked
+func (file *FileINode) closedDeferredFlush() *FileHandle {
+	file.lockFileHandles()
+	defer file.unlockFileHandles()
+	for _, handle := range file.activeHandles {
+		if handle == file.deferredFlush {
+			return nil
+		}
+	}
+	return file.deferredFlush
+}
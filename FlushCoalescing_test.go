@@ -0,0 +1,152 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes the data to the file through the handle and closes it
+func writeAndClose(t *testing.T, fileHandle *FileHandle, data string) {
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte(data), Offset: 0}, &fuse.WriteResponse{}))
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
+
+// Testing that the closes of a file within the window are uploaded once, with the latest content
+func TestFlushCoalescing(t *testing.T) {
+	saveFlags(t, &flushCoalesceWindow)
+	flushCoalesceWindow = time.Hour
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	var uploaded []byte
+	fs := renameBarrierFs(mockCtrl, hdfsAccessor, &uploaded)
+	root, _ := fs.Root()
+	node, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "notes.txt",
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	file := node.(*FileINode)
+	writeAndClose(t, h.(*FileHandle), "draft")
+	// reopened without a download
+	h, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	writeAndClose(t, h.(*FileHandle), "final")
+	assert.Nil(t, uploaded)
+	assert.NotNil(t, file.fileProxy, "the staging file is kept for the deferred flush")
+
+	assert.Nil(t, (&DeferredFlushes{FileSystem: fs}).Close())
+	assert.Equal(t, "final", string(uploaded))
+	assert.Nil(t, file.fileProxy)
+	assert.Equal(t, int64(0), fs.Dirty.Dirty())
+}
+
+// Testing that the deferred flush of a removed file is dropped
+func TestFlushCoalescingRemovedFile(t *testing.T) {
+	saveFlags(t, &flushCoalesceWindow)
+	flushCoalesceWindow = time.Hour
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	var uploaded []byte
+	fs := renameBarrierFs(mockCtrl, hdfsAccessor, &uploaded)
+	root, _ := fs.Root()
+	node, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "scratch",
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	file := node.(*FileINode)
+	writeAndClose(t, h.(*FileHandle), "tmp")
+
+	assert.Nil(t, root.(*DirINode).Remove(nil, &fuse.RemoveRequest{Name: "scratch"}))
+	assert.Nil(t, file.fileProxy)
+	assert.Nil(t, (&DeferredFlushes{FileSystem: fs}).Close())
+	assert.Nil(t, uploaded)
+}
+
+// Testing that a flush of the handle deferred while its previous flush is uploaded is kept
+func TestFlushCoalescingFlushDuringUpload(t *testing.T) {
+	saveFlags(t, &flushCoalesceWindow)
+	flushCoalesceWindow = time.Hour
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	hdfsAccessor.EXPECT().Stat(gomock.Any()).Return(Attrs{Name: "log.txt", Mode: os.FileMode(0644)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().Chown(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().Remove(gomock.Any()).Return(nil).AnyTimes()
+	var uploads []string
+	var fileHandle *FileHandle
+	flushed := make(chan struct{})
+	hdfswriter := NewMockHdfsWriter(mockCtrl)
+	hdfswriter.EXPECT().Write(gomock.Any()).DoAndReturn(func(p []byte) (int, error) {
+		uploads = append(uploads, string(p))
+		if string(p) == "one" {
+			// written and flushed again once the upload releases the handle
+			go func() {
+				assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("two"), Offset: 3}, &fuse.WriteResponse{}))
+				assert.Nil(t, fileHandle.Flush(nil, nil))
+				close(flushed)
+			}()
+		}
+		return len(p), nil
+	}).AnyTimes()
+	hdfswriter.EXPECT().Close().Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().CreateFile(gomock.Any(), gomock.Any(), gomock.Any()).Return(hdfswriter, nil).AnyTimes()
+	root, _ := fs.Root()
+	_, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "log.txt",
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	fileHandle = h.(*FileHandle)
+	file := fileHandle.File
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("one"), Offset: 0}, &fuse.WriteResponse{}))
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+
+	assert.Nil(t, file.flushDeferred(true))
+	<-flushed
+
+	file.lockFileHandles()
+	assert.Equal(t, fileHandle, file.deferredFlush, "the second flush is still deferred")
+	file.unlockFileHandles()
+	assert.Nil(t, fileHandle.Release(nil, nil))
+	assert.Nil(t, (&DeferredFlushes{FileSystem: fs}).Close())
+	assert.Equal(t, []string{"one", "onetwo"}, uploads)
+}
+
+// Testing that a failed upload of a deferred flush is returned by the next flush of the file, once
+func TestFlushCoalescingUploadError(t *testing.T) {
+	saveFlags(t, &flushCoalesceWindow)
+	flushCoalesceWindow = time.Hour
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	hdfsAccessor.EXPECT().Stat(gomock.Any()).Return(Attrs{Name: "out.txt", Mode: os.FileMode(0644)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().Chown(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().Remove(gomock.Any()).Return(nil).AnyTimes()
+	hdfswriter := NewMockHdfsWriter(mockCtrl)
+	hdfswriter.EXPECT().Close().Return(nil).AnyTimes()
+	// the create succeeds and the deferred upload fails
+	hdfsAccessor.EXPECT().CreateFile(gomock.Any(), gomock.Any(), gomock.Any()).Return(hdfswriter, nil)
+	hdfsAccessor.EXPECT().CreateFile(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, syscall.EIO)
+	root, _ := fs.Root()
+	node, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "out.txt",
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	file := node.(*FileINode)
+	other, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	writeAndClose(t, h.(*FileHandle), "data")
+
+	mockClock.NotifyTimeElapsed(2 * flushCoalesceWindow)
+	assert.Equal(t, syscall.EIO, file.flushDeferred(false))
+	assert.Equal(t, syscall.EIO, other.(*FileHandle).Flush(nil, nil))
+	assert.Nil(t, other.(*FileHandle).Flush(nil, nil))
+	assert.Nil(t, other.(*FileHandle).Release(nil, nil))
+}
@@ -0,0 +1,144 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// A process which hung with a file open, e.g., on a dead NFS mount of its own, kept its staging
+// file and the lease of the HDFS file, and blocked drain, until the whole mount was unmounted.
+// The handles admin command lists the open file handles, and close-handle force-closes the
+// handles of the files under a path, or only the one of the given id: the handle's next
+// operations, including the close by the process, fail with EBADF, and it is released as if the
+// process closed it once its operation in progress, if any, returns. Data written through the
+// handle which was not flushed is not uploaded, unless another handle of the file flushes it.
+// The close by the process fails with EBADF from its flush, and its release, whose error the
+// kernel does not report, returns EBADF as well
+func init() {
+	registerAdminCommand("handles", AdminCommand{
+		Help:    "Lists the open file handles: id, path, uid of the process, flags and bytes read and written",
+		Handler: handlesCmd,
+	})
+	registerAdminCommand("close-handle", AdminCommand{
+		Usage:    "<path> [id]",
+		Help:     "Force-closes the open handles of the files under the path, or the one of the id, their next operations fail with EBADF",
+		PathArgs: 1,
+		Handler:  closeHandleCmd,
+	})
+}
+
+// The open file handles of the mount
+// Concurrency: thread safe
+type HandleRegistry struct {
+	mutex   sync.Mutex
+	handles map[*FileHandle]bool
+}
+
+// Creates an empty registry
+func NewHandleRegistry() *HandleRegistry {
+	return &HandleRegistry{handles: map[*FileHandle]bool{}}
+}
+
+// Registers an opened handle
+func (registry *HandleRegistry) Add(fh *FileHandle) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.handles[fh] = true
+}
+
+// Unregisters a released handle
+func (registry *HandleRegistry) Remove(fh *FileHandle) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	delete(registry.handles, fh)
+}
+
+// Returns the open handles, ordered by path
+func (registry *HandleRegistry) Handles() []*FileHandle {
+	registry.mutex.Lock()
+	handles := make([]*FileHandle, 0, len(registry.handles))
+	for fh := range registry.handles {
+		handles = append(handles, fh)
+	}
+	registry.mutex.Unlock()
+	paths := make(map[*FileHandle]string, len(handles))
+	for _, fh := range handles {
+		paths[fh] = fh.File.AbsolutePath()
+	}
+	sort.Slice(handles, func(i, j int) bool {
+		if paths[handles[i]] != paths[handles[j]] {
+			return paths[handles[i]] < paths[handles[j]]
+		}
+		return handles[i].fhID < handles[j].fhID
+	})
+	return handles
+}
+
+// Returns EBADF once the handle was force-closed
+func (fh *FileHandle) checkForceClosed() error {
+	if atomic.LoadInt32(&fh.forceClosed) != 0 {
+		return syscall.EBADF
+	}
+	return nil
+}
+
+// Fails the next operations of the handle and releases it once its operation in progress
+// returns. Returns false if it was force-closed already
+func (fh *FileHandle) forceClose() bool {
+	if !atomic.CompareAndSwapInt32(&fh.forceClosed, 0, 1) {
+		return false
+	}
+	logwarn("Force-closing file handle", fh.logInfo(Fields{Operation: Close, Flags: fh.fileFlags}))
+	go func() {
+		fh.lockHandle()
+		defer fh.unlockHandle()
+		if !fh.released {
+			fh.release()
+		}
+	}()
+	return true
+}
+
+func handlesCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	for _, fh := range filesystem.Handles.Handles() {
+		out.Printf("%d %s uid=%d flags=%v read=%d written=%d", fh.fhID, fh.File.AbsolutePath(), fh.uid, fh.fileFlags,
+			atomic.LoadInt64(&fh.tatalBytesRead), atomic.LoadInt64(&fh.totalBytesWritten))
+	}
+	return nil
+}
+
+func closeHandleCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	hdfsPath := args[0]
+	var id int64
+	if len(args) > 1 {
+		var err error
+		if id, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return fmt.Errorf("invalid handle id %q", args[1])
+		}
+	}
+	closed := 0
+	for _, fh := range filesystem.Handles.Handles() {
+		p := fh.File.AbsolutePath()
+		if p != hdfsPath && !strings.HasPrefix(p, strings.TrimSuffix(hdfsPath, "/")+"/") {
+			continue
+		}
+		if id != 0 && fh.fhID != id {
+			continue
+		}
+		if fh.forceClose() {
+			out.Printf("closed %d %s", fh.fhID, p)
+			closed++
+		}
+	}
+	if closed == 0 {
+		return fmt.Errorf("no open handle under %s", hdfsPath)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that close-handle force-closes the handles under the path, which then fail with EBADF,
+// and leaves the other handles open
+func TestCloseHandle(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(WallClock{}), WallClock{})
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().OpenRead("/dir/a").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 5, ReaderStats: &ReaderStats{}}, nil)
+	hdfsAccessor.EXPECT().OpenRead("/b").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 5, ReaderStats: &ReaderStats{}}, nil)
+	root, _ := fs.Root()
+	dir := root.(*DirINode).NodeFromAttrs(Attrs{Name: "dir", Mode: os.ModeDir | 0755}).(*DirINode)
+	a := dir.NodeFromAttrs(Attrs{Name: "a", Mode: 0644, Size: 5}).(*FileINode)
+	b := root.(*DirINode).NodeFromAttrs(Attrs{Name: "b", Mode: 0644, Size: 5}).(*FileINode)
+	ha, err := a.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	hb, err := b.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(fs.Handles.Handles()))
+
+	out := &AdminOutput{encoder: json.NewEncoder(ioutil.Discard)}
+	assert.NotNil(t, closeHandleCmd(fs, []string{"/dir", "12345"}, out))
+	assert.Nil(t, closeHandleCmd(fs, []string{"/dir"}, out))
+	assert.Eventually(t, func() bool { return fs.Opens.Open() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, []*FileHandle{hb.(*FileHandle)}, fs.Handles.Handles())
+	assert.Equal(t, 0, a.countActiveHandles())
+
+	resp := &fuse.ReadResponse{Data: make([]byte, 5)}
+	assert.Equal(t, syscall.EBADF, ha.(*FileHandle).Read(nil, &fuse.ReadRequest{Size: 5}, resp))
+	assert.Equal(t, syscall.EBADF, ha.(*FileHandle).Flush(nil, &fuse.FlushRequest{}))
+	assert.Equal(t, syscall.EBADF, ha.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+	assert.Nil(t, hb.(*FileHandle).Read(nil, &fuse.ReadRequest{Size: 5}, resp))
+	assert.Equal(t, 1, fs.Opens.Open())
+
+	// a handle is force-closed once
+	assert.NotNil(t, closeHandleCmd(fs, []string{"/dir"}, out))
+}
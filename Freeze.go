@@ -0,0 +1,152 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// The freeze admin command quiesces the mount for host level backups or disk snapshots, like
+// fsfreeze does for local file systems: new writes, creates, removes, renames and attribute
+// changes block, the mutations in progress complete, and the data of the staging files is
+// uploaded, so that HDFS and the staging dir agree. The command returns once the mount is
+// quiescent, and thaw lets the blocked operations through. Reads are not blocked. A mount
+// which is not thawed within -freezeTimeout thaws by itself, so that a backup tool which died
+// does not leave the applications blocked
+func init() {
+	registerAdminCommand("freeze", AdminCommand{
+		Help:    "Blocks new mutations and uploads the dirty data, returns once the mount is quiescent",
+		Handler: freezeCmd,
+	})
+	registerAdminCommand("thaw", AdminCommand{
+		Help:    "Lets the mutations blocked by freeze through",
+		Handler: thawCmd,
+	})
+}
+
+var freezeTimeout time.Duration
+
+// Blocks the mutations of the mount while it is frozen
+// Concurrency: thread safe
+type MutationGate struct {
+	mutex   sync.Mutex
+	changed *sync.Cond // signalled when the gate thaws or a mutation completes
+	frozen  bool
+	epoch   int // incremented by every freeze, so that the timeout of an older freeze does not thaw a newer one
+	active  int // mutations in progress
+}
+
+// Creates an open gate
+func NewMutationGate() *MutationGate {
+	gate := &MutationGate{}
+	gate.changed = sync.NewCond(&gate.mutex)
+	return gate
+}
+
+// Called before a mutation, blocks while the mount is frozen. The mutation must call Exit once done
+func (gate *MutationGate) Enter() {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	for gate.frozen {
+		gate.changed.Wait()
+	}
+	gate.active++
+}
+
+// Called once a mutation is done
+func (gate *MutationGate) Exit() {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	gate.active--
+	gate.changed.Broadcast()
+}
+
+// Closes the gate and waits for the mutations in progress. Returns the epoch of the freeze,
+// or false if the mount is frozen already
+func (gate *MutationGate) freeze() (int, bool) {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	if gate.frozen {
+		return 0, false
+	}
+	gate.frozen = true
+	gate.epoch++
+	for gate.active > 0 {
+		gate.changed.Wait()
+	}
+	return gate.epoch, true
+}
+
+// Opens the gate if it was closed by the freeze of the epoch, or by any freeze if epoch is 0.
+// Returns false if the mount was not frozen
+func (gate *MutationGate) thaw(epoch int) bool {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	if !gate.frozen || (epoch != 0 && epoch != gate.epoch) {
+		return false
+	}
+	gate.frozen = false
+	gate.changed.Broadcast()
+	return true
+}
+
+// Returns true if the mount is frozen
+func (gate *MutationGate) Frozen() bool {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	return gate.frozen
+}
+
+// Freezes the mount and uploads the data of the staging files. The mount is thawed again if an upload fails
+func (filesystem *FileSystem) freeze(out *AdminOutput) error {
+	start := filesystem.Clock.Now()
+	epoch, ok := filesystem.Mutations.freeze()
+	if !ok {
+		return fmt.Errorf("the mount is frozen already")
+	}
+	loginfo("Mount frozen, uploading the staging files", Fields{Operation: FreezeOp})
+	var uploaded, bytes int64
+	for _, file := range filesystem.StagedFiles() {
+		dirty := file.Dirty()
+		if dirty == 0 {
+			continue
+		}
+		if err := file.syncHandles(nil, &fuse.FsyncRequest{}); err != nil {
+			filesystem.Mutations.thaw(epoch)
+			metrics.Record(FreezeOp, filesystem.Clock.Now().Sub(start), bytes, 0, false, err)
+			return fmt.Errorf("failed to upload %s, the mount is thawed: %v", file.AbsolutePath(), err)
+		}
+		uploaded++
+		bytes += dirty
+		out.Printf("uploaded %s (%d bytes)", file.AbsolutePath(), dirty)
+	}
+	if timeout := freezeTimeout; timeout > 0 {
+		go func() {
+			<-filesystem.Clock.After(timeout)
+			if filesystem.Mutations.thaw(epoch) {
+				logwarn(fmt.Sprintf("Mount not thawed within -freezeTimeout %v, thawing", timeout), Fields{Operation: FreezeOp})
+			}
+		}()
+	}
+	metrics.Record(FreezeOp, filesystem.Clock.Now().Sub(start), bytes, 0, false, nil)
+	loginfo("Mount quiescent", Fields{Operation: FreezeOp, Bytes: bytes})
+	out.Printf("frozen, uploaded %d files (%d bytes)", uploaded, bytes)
+	return nil
+}
+
+func freezeCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	return filesystem.freeze(out)
+}
+
+func thawCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	if !filesystem.Mutations.thaw(0) {
+		return fmt.Errorf("the mount is not frozen")
+	}
+	loginfo("Mount thawed", Fields{Operation: FreezeOp})
+	out.Printf("thawed")
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that freeze uploads the dirty data and blocks writes until thaw
+func TestFreeze(t *testing.T) {
+	saveFlags(t, &quotaWarningPercent, &freezeTimeout)
+	quotaWarningPercent = 0 // no quota check in the background after the upload
+	freezeTimeout = 0
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	out := &AdminOutput{encoder: json.NewEncoder(ioutil.Discard)}
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().OpenRead("/db").DoAndReturn(func(path string) (ReadSeekCloser, error) {
+		return &MockReadSeekCloserWithPseudoRandomContent{FileSize: 5}, nil
+	}).AnyTimes()
+	hdfsAccessor.EXPECT().Stat("/db").Return(Attrs{Name: "db", Mode: 0644, Size: 5}, nil).AnyTimes()
+	file := root.(*DirINode).NodeFromAttrs(Attrs{Name: "db", Mode: 0644, Size: 5}).(*FileINode)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fh := h.(*FileHandle)
+	assert.Nil(t, fh.Write(nil, &fuse.WriteRequest{Data: []byte("wal"), Offset: 5}, &fuse.WriteResponse{}))
+
+	writer := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/db").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/db", os.FileMode(0644), true).Return(writer, nil)
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) { return len(b), nil }).AnyTimes()
+	writer.EXPECT().Close().Return(nil)
+	assert.Nil(t, fs.freeze(out))
+	assert.True(t, fs.Mutations.Frozen())
+	assert.Equal(t, int64(0), file.Dirty())
+	assert.EqualError(t, fs.freeze(out), "the mount is frozen already")
+
+	written := make(chan error)
+	go func() {
+		written <- fh.Write(nil, &fuse.WriteRequest{Data: []byte("more"), Offset: 8}, &fuse.WriteResponse{})
+	}()
+	select {
+	case <-written:
+		t.Fatal("write went through a frozen mount")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Nil(t, thawCmd(fs, nil, out))
+	assert.Nil(t, <-written)
+	assert.EqualError(t, thawCmd(fs, nil, out), "the mount is not frozen")
+
+	// a mount which is not thawed thaws by itself, an older freeze does not thaw a newer one
+	freezeTimeout = time.Minute
+	idle, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	assert.Nil(t, idle.freeze(out))
+	assert.Eventually(t, func() bool { return !idle.Mutations.Frozen() }, time.Second, time.Millisecond)
+	epoch, _ := idle.Mutations.freeze()
+	assert.False(t, idle.Mutations.thaw(epoch-1))
+	assert.True(t, idle.Mutations.thaw(epoch))
+}
@@ -0,0 +1,229 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+const (
+	GroupResolverNSS       = "nss"       // local groups of the calling process, mapped to names by NSS
+	GroupResolverFile      = "file"      // static user to HDFS groups mapping file
+	GroupResolverIdMapping = "idMapping" // groups of the user from -idMapping, e.g., LDAP or Hopsworks
+)
+
+// Resolves the HDFS groups of the process issuing a FUSE request.
+// Used by the client side permission checks (-permissionChecks=client)
+type GroupResolver interface {
+	Groups(caller fuse.Header) ([]string, error)
+}
+
+// Creates the group resolver selected by -groupResolver
+func NewGroupResolver(kind string) (GroupResolver, error) {
+	var resolver GroupResolver
+	switch kind {
+	case GroupResolverNSS:
+		resolver = &nssGroupResolver{}
+	case GroupResolverFile:
+		fileResolver, err := newFileGroupResolver(groupMappingFile)
+		if err != nil {
+			return nil, err
+		}
+		resolver = fileResolver
+	case GroupResolverIdMapping:
+		resolver = &idMappingGroupResolver{}
+	default:
+		return nil, fmt.Errorf("unknown group resolver %q", kind)
+	}
+	return newCachingGroupResolver(resolver, kind == GroupResolverNSS, groupCacheTTL, WallClock{}), nil
+}
+
+// Maps the primary and supplementary groups of the calling process to group names.
+// HDFS groups are expected to have the same names as the local groups
+type nssGroupResolver struct{}
+
+func (resolver *nssGroupResolver) Groups(caller fuse.Header) ([]string, error) {
+	gids, err := processGids(caller.Pid)
+	if err != nil {
+		// the process may have already exited, falling back to the groups of the user
+		logdebug("Unable to read the groups of the process", Fields{PID: caller.Pid, Error: err})
+		if gids, err = userGids(caller.Uid); err != nil {
+			return nil, err
+		}
+	}
+	gids = append(gids, caller.Gid)
+	groups := make([]string, 0, len(gids))
+	for _, gid := range gids {
+		if name := idMapper.GroupName(gid); name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
+
+// Returns the supplementary groups of a process
+func processGids(pid uint32) ([]uint32, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Groups:") {
+			continue
+		}
+		var gids []uint32
+		for _, field := range strings.Fields(strings.TrimPrefix(line, "Groups:")) {
+			gid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			gids = append(gids, uint32(gid))
+		}
+		return gids, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no groups found in /proc/%d/status", pid)
+}
+
+// Returns the groups the user is a member of according to NSS
+func userGids(uid uint32) ([]uint32, error) {
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return nil, err
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	gids := make([]uint32, 0, len(ids))
+	for _, id := range ids {
+		if gid, err := strconv.ParseUint(id, 10, 32); err == nil {
+			gids = append(gids, uint32(gid))
+		}
+	}
+	return gids, nil
+}
+
+// Maps local user names to HDFS groups using a static file. Each line of the
+// file has the format "user: group1, group2". Empty lines and lines starting with # are ignored
+type fileGroupResolver struct {
+	groups map[string][]string
+}
+
+func newFileGroupResolver(mappingFile string) (*fileGroupResolver, error) {
+	if mappingFile == "" {
+		return nil, fmt.Errorf("-groupMappingFile is required by the %s group resolver", GroupResolverFile)
+	}
+	f, err := os.Open(mappingFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	resolver := &fileGroupResolver{groups: make(map[string][]string)}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected \"user: group1, group2\"", mappingFile, lineNo)
+		}
+		userName := strings.TrimSpace(line[:i])
+		for _, group := range strings.Split(line[i+1:], ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				resolver.groups[userName] = append(resolver.groups[userName], group)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return resolver, nil
+}
+
+func (resolver *fileGroupResolver) Groups(caller fuse.Header) ([]string, error) {
+	userName := idMapper.UserName(caller.Uid)
+	if userName == "" {
+		return nil, fmt.Errorf("unable to find the user name of uid %d", caller.Uid)
+	}
+	return resolver.groups[userName], nil
+}
+
+// Resolves the HDFS groups of the user with the id mapping
+type idMappingGroupResolver struct{}
+
+func (resolver *idMappingGroupResolver) Groups(caller fuse.Header) ([]string, error) {
+	userName := idMapper.UserName(caller.Uid)
+	if userName == "" {
+		return nil, fmt.Errorf("unable to find the user name of uid %d", caller.Uid)
+	}
+	return idMapper.Groups(userName)
+}
+
+type resolvedGroups struct {
+	groups  []string
+	expires time.Duration
+}
+
+// Caches the groups returned by another resolver, as the resolvers are consulted on every
+// permission check. Entries are keyed by uid, and also by pid if the groups depend on the process
+type cachingGroupResolver struct {
+	resolver   GroupResolver
+	perProcess bool
+	ttl        time.Duration
+	clock      Clock
+	cache      map[[2]uint32]resolvedGroups
+	mutex      sync.Mutex
+}
+
+func newCachingGroupResolver(resolver GroupResolver, perProcess bool, ttl time.Duration, clock Clock) *cachingGroupResolver {
+	return &cachingGroupResolver{resolver: resolver, perProcess: perProcess, ttl: ttl, clock: clock,
+		cache: make(map[[2]uint32]resolvedGroups)}
+}
+
+func (resolver *cachingGroupResolver) Groups(caller fuse.Header) ([]string, error) {
+	key := [2]uint32{caller.Uid, 0}
+	if resolver.perProcess {
+		key[1] = caller.Pid
+	}
+	now := resolver.clock.Monotonic()
+	resolver.mutex.Lock()
+	entry, ok := resolver.cache[key]
+	resolver.mutex.Unlock()
+	if ok && now < entry.expires {
+		return entry.groups, nil
+	}
+
+	groups, err := resolver.resolver.Groups(caller)
+	if err != nil {
+		return nil, err
+	}
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	// dropping expired entries, otherwise the cache grows with every process
+	for k, v := range resolver.cache {
+		if now >= v.expires {
+			delete(resolver.cache, k)
+		}
+	}
+	resolver.cache[key] = resolvedGroups{groups: groups, expires: now + resolver.ttl}
+	return groups, nil
+}
@@ -26,16 +26,30 @@ type HdfsAccessor interface {
 	OpenRead(path string) (ReadSeekCloser, error) // Opens HDFS file for reading
 	CreateFile(path string,
 		mode os.FileMode, overwrite bool) (HdfsWriter, error) // Opens HDFS file for writing
-	ReadDir(path string) ([]Attrs, error)         // Enumerates HDFS directory
-	Stat(path string) (Attrs, error)              // Retrieves file/directory attributes
-	StatFs() (FsInfo, error)                      // Retrieves HDFS usage
-	Mkdir(path string, mode os.FileMode) error    // Creates a directory
-	Remove(path string) error                     // Removes a file or directory
-	Rename(oldPath string, newPath string) error  // Renames a file or directory
-	EnsureConnected() error                       // Ensures HDFS accessor is connected to the HDFS name node
-	Chown(path string, owner, group string) error // Changes the owner and group of the file
-	Chmod(path string, mode os.FileMode) error    // Changes the mode of the file
-	Close() error                                 // Close current meta connection if needed
+	Append(path string) (HdfsWriter, error)                // Opens HDFS file for appending
+	ReadDir(path string) ([]Attrs, error)                  // Enumerates HDFS directory
+	Stat(path string) (Attrs, error)                       // Retrieves file/directory attributes
+	StatFs() (FsInfo, error)                               // Retrieves HDFS usage
+	Mkdir(path string, mode os.FileMode) error             // Creates a directory
+	Remove(path string) error                              // Removes a file or directory
+	RemoveAll(path string) error                           // Removes a file or directory recursively
+	Rename(oldPath string, newPath string) error           // Renames a file or directory
+	EnsureConnected() error                                // Ensures HDFS accessor is connected to the HDFS name node
+	Chown(path string, owner, group string) error          // Changes the owner and group of the file
+	Chmod(path string, mode os.FileMode) error             // Changes the mode of the file
+	Chtimes(path string, mtime time.Time) error            // Changes the modification time of the file
+	Checksum(path string) (FileChecksum, error)            // Retrieves the HDFS checksum of the file
+	GetXAttrs(path string) (map[string]string, error)      // Retrieves the extended attributes of the file
+	SetXAttr(path, name, value string) error               // Creates or replaces an extended attribute of the file
+	GetAcl(path string) ([]AclEntry, error)                // Retrieves the ACL entries of the file which are not in its mode
+	SetAcl(path string, entries []AclEntry) error          // Replaces the access and default ACL of the file
+	SetStoragePolicy(path, policy string) error            // Sets the storage policy of the file, or unsets it if empty
+	SetErasureCodingPolicy(path, policy string) error      // Sets the erasure coding policy of the directory, or unsets it if empty
+	GetContentSummary(path string) (ContentSummary, error) // Retrieves the totals of a directory tree
+	ServerDefaults() (ServerDefaults, error)               // Retrieves the configuration of the namenode
+	CreateSnapshot(path, name string) (string, error)      // Creates a snapshot of a snapshottable directory, returns its path
+	Truncate(path string, size int64) (bool, error)        // Truncates the file, returns false if the namenode completes it asynchronously
+	Close() error                                          // Close current meta connection if needed
 }
 
 type TLSConfig struct {
@@ -44,26 +58,48 @@ type TLSConfig struct {
 	RootCABundle      string
 	ClientCertificate string
 	ClientKey         string
+	// authentication, integrity or privacy of the block data exchanged with the datanodes, empty for the namenode's default
+	DataTransferProtection string
 }
 
 type hdfsAccessorImpl struct {
-	Clock               Clock        // interface to get wall clock time
-	NameNodeAddresses   []string     // array of Address:port string for the name nodes
-	MetadataClient      *hdfs.Client // HDFS client used for metadata operations
-	MetadataClientMutex sync.Mutex   // Serializing all metadata operations for simplicity (for now), TODO: allow N concurrent operations
-	TLSConfig           TLSConfig    // enable/disable using tls
+	Clock               Clock             // interface to get wall clock time
+	NameNodeAddresses   []string          // array of Address:port string for the name nodes
+	MetadataClient      *hdfs.Client      // HDFS client used for metadata operations
+	MetadataClientMutex sync.Mutex        // Serializing all metadata operations for simplicity (for now), TODO: allow N concurrent operations
+	TLSConfig           TLSConfig         // enable/disable using tls
+	User                string            // HDFS user the RPCs are issued as, the user of the mount if empty
+	WebHdfs             *WebHdfs          // reads the blocks whose datanodes are unreachable, nil without -webhdfsURL
+	Failover            *NamenodeFailover // order in which the name nodes are connected to
+
+	clientRefs      map[*hdfs.Client]int  // number of open readers and writers of each client
+	retiredClients  map[*hdfs.Client]bool // replaced clients which are closed once their readers and writers are closed
+	clientRefsMutex sync.Mutex
 }
 
 var _ HdfsAccessor = (*hdfsAccessorImpl)(nil) // ensure hdfsAccessorImpl implements HdfsAccessor
 
 // Creates an instance of HdfsAccessor
 func NewHdfsAccessor(nameNodeAddresses string, clock Clock, tlsConfig TLSConfig) (HdfsAccessor, error) {
+	return NewHdfsAccessorAsUser(nameNodeAddresses, clock, tlsConfig, "")
+}
+
+// Creates an instance of HdfsAccessor issuing the RPCs as the HDFS user, see -impersonate
+func NewHdfsAccessorAsUser(nameNodeAddresses string, clock Clock, tlsConfig TLSConfig, user string) (HdfsAccessor, error) {
 	nns := strings.Split(nameNodeAddresses, ",")
 
 	this := &hdfsAccessorImpl{
 		NameNodeAddresses: nns,
 		Clock:             clock,
 		TLSConfig:         tlsConfig,
+		User:              user,
+		Failover:          NewNamenodeFailover(nns, clock),
+	}
+	if webhdfsURL != "" {
+		var err error
+		if this.WebHdfs, err = NewWebHdfs(webhdfsURL, clock, tlsConfig); err != nil {
+			return nil, fmt.Errorf("invalid -webhdfsURL: %v", err)
+		}
 	}
 	return this, nil
 }
@@ -99,6 +135,10 @@ func (dfs *hdfsAccessorImpl) ConnectToNameNode() (*hdfs.Client, error) {
 
 // Performs an attempt to connect to the HDFS name
 func (dfs *hdfsAccessorImpl) connectToNameNodeImpl() (*hdfs.Client, error) {
+	if kerberosLogin != nil {
+		// the namenode acts as the principal, whatever HADOOP_USER_NAME says
+		hadoopUserName = kerberosLogin.UserName()
+	}
 	if hadoopUserName == "" {
 		u, err := ugcache.CurrentUserName()
 		if err != nil {
@@ -106,19 +146,32 @@ func (dfs *hdfsAccessorImpl) connectToNameNodeImpl() (*hdfs.Client, error) {
 		}
 		hadoopUserName = u
 	}
-	hadoopUserID = ugcache.LookupUId(hadoopUserName)
-	if hadoopUserName != "root" && hadoopUserID == 0 {
+	var mapped bool
+	if hadoopUserID, mapped = idMapper.Uid(hadoopUserName); !mapped {
+		hadoopUserID = 0
 		logwarn(fmt.Sprintf("Unable to find user id for user: %s, returning uid: 0", hadoopUserName), nil)
 	}
 
-	loginfo(fmt.Sprintf("Connecting as user: %s, UID: %d", hadoopUserName, hadoopUserID), nil)
+	user := hadoopUserName
+	if dfs.User != "" {
+		// -impersonate, the mount itself still acts as its own user
+		user = dfs.User
+		loginfo(fmt.Sprintf("Connecting as user: %s on behalf of its processes", user), nil)
+	} else {
+		loginfo(fmt.Sprintf("Connecting as user: %s, UID: %d", hadoopUserName, hadoopUserID), nil)
+	}
 
 	// Performing an attempt to connect to the name node
-	// Colinmar's hdfs implementation has supported the multiple name node connection
 	hdfsOptions := hdfs.ClientOptions{
-		Addresses: dfs.NameNodeAddresses,
-		TLS:       dfs.TLSConfig.TLS,
-		User:      hadoopUserName,
+		TLS:                    dfs.TLSConfig.TLS,
+		User:                   user,
+		DataTransferProtection: dfs.TLSConfig.DataTransferProtection,
+		DatanodeDialFunc:       datanodeDialFunc,
+	}
+
+	if kerberosLogin != nil {
+		hdfsOptions.KerberosClient = kerberosLogin.Client()
+		hdfsOptions.KerberosServicePrincipleName = kerberosLogin.ServicePrincipal
 	}
 
 	if dfs.TLSConfig.TLS {
@@ -127,6 +180,22 @@ func (dfs *hdfsAccessorImpl) connectToNameNodeImpl() (*hdfs.Client, error) {
 		hdfsOptions.ClientCertificate = dfs.TLSConfig.ClientCertificate
 	}
 
+	// the name nodes are tried one after the other, see NamenodeFailover
+	var err error
+	for _, address := range dfs.Failover.Candidates() {
+		hdfsOptions.Addresses = []string{address}
+		var client *hdfs.Client
+		if client, err = connectToNameNodeAddress(hdfsOptions); err == nil {
+			dfs.Failover.Connected(address)
+			return client, nil
+		}
+		dfs.Failover.Failed(address, err)
+	}
+	return nil, err
+}
+
+// Connects to the name node of the options
+func connectToNameNodeAddress(hdfsOptions hdfs.ClientOptions) (*hdfs.Client, error) {
 	client, err := hdfs.NewClient(hdfsOptions)
 	if err != nil {
 		return nil, err
@@ -163,7 +232,13 @@ func (dfs *hdfsAccessorImpl) OpenRead(path string) (ReadSeekCloser, error) {
 	if err != nil {
 		return nil, unwrapAndTranslateError(err)
 	}
-	return NewHdfsReader(reader), nil
+	client := dfs.MetadataClient
+	dfs.acquireClient(client)
+	hdfsReader := &HdfsReader{BackendReader: reader, release: func() { dfs.releaseClient(client) }}
+	if dfs.WebHdfs != nil {
+		return newWebHdfsFallbackReader(hdfsReader, dfs.WebHdfs, dfs.webhdfsUser()), nil
+	}
+	return hdfsReader, nil
 }
 
 // Creates new HDFS file
@@ -176,12 +251,34 @@ func (dfs *hdfsAccessorImpl) CreateFile(path string, mode os.FileMode, overwrite
 			return nil, err
 		}
 	}
-	writer, err := dfs.MetadataClient.CreateFile(path, 3, 64*1024*1024, mode, overwrite)
+	writer, err := dfs.MetadataClient.CreateFile(path, 3, 64*1024*1024, hdfsPermFromFileMode(mode), overwrite)
+	if err != nil {
+		return nil, unwrapAndTranslateError(err)
+	}
+
+	client := dfs.MetadataClient
+	dfs.acquireClient(client)
+	return &hdfsWriterImpl{BackendWriter: writer, release: func() { dfs.releaseClient(client) }}, nil
+}
+
+// Opens an existing HDFS file for appending
+func (dfs *hdfsAccessorImpl) Append(path string) (HdfsWriter, error) {
+	dfs.lockHadoopClient()
+	defer dfs.unlockHadoopClient()
+
+	if dfs.MetadataClient == nil {
+		if err := dfs.ConnectMetadataClient(); err != nil {
+			return nil, err
+		}
+	}
+	writer, err := dfs.MetadataClient.Append(path)
 	if err != nil {
 		return nil, unwrapAndTranslateError(err)
 	}
 
-	return NewHdfsWriter(writer), nil
+	client := dfs.MetadataClient
+	dfs.acquireClient(client)
+	return &hdfsWriterImpl{BackendWriter: writer, release: func() { dfs.releaseClient(client) }}, nil
 }
 
 // Enumerates HDFS directory
@@ -263,32 +360,51 @@ func (dfs *hdfsAccessorImpl) StatFs() (FsInfo, error) {
 func (dfs *hdfsAccessorImpl) AttrsFromFileInfo(fileInfo os.FileInfo) Attrs {
 	// protoBufDatr := fileInfo.Sys().(*hadoop_hdfs.HdfsFileStatusProto)
 	fi := fileInfo.(*hdfs.FileInfo)
-	mode := os.FileMode(fi.Permission())
+	mode := fileModeFromHdfsPerm(fi.Permission())
 	if fileInfo.IsDir() {
 		mode |= os.ModeDir
 	}
+	size := fi.Length()
+	var target string
+	if status, ok := fileInfo.Sys().(*hdfs.FileStatus); ok && len(status.GetSymlink()) > 0 {
+		mode |= os.ModeSymlink
+		target = string(status.GetSymlink())
+		size = uint64(len(target))
+	}
+	var policy uint8
+	var ec string
+	if status, ok := fileInfo.Sys().(*hdfs.FileStatus); ok {
+		policy = uint8(status.GetStoragePolicy())
+		ec = status.GetEcPolicy().GetName()
+	}
 
 	modificationTime := time.Unix(int64(fi.ModificationTime())/1000, 0)
-	gid := ugcache.LookupGid(fi.OwnerGroup())
-	if fi.OwnerGroup() != "root" && gid == 0 {
-		logwarn(fmt.Sprintf("Unable to find group id for group: %s, returning gid: 0", fi.OwnerGroup()), nil)
+	gid, ok := idMapper.Gid(fi.OwnerGroup())
+	if !ok {
+		gid = uint32(unmappedId)
+		logwarn(fmt.Sprintf("Unable to find group id for group: %s, returning gid: %d", fi.OwnerGroup(), gid), nil)
 	}
 
-	uid := ugcache.LookupUId(fi.Owner())
-	if fi.Owner() != "root" && uid == 0 {
-		logwarn(fmt.Sprintf("Unable to find user id for user: %s, returning uid: 0", fi.Owner()), nil)
+	uid, ok := idMapper.Uid(fi.Owner())
+	if !ok {
+		uid = uint32(unmappedId)
+		logwarn(fmt.Sprintf("Unable to find user id for user: %s, returning uid: %d", fi.Owner(), uid), nil)
 	}
 
 	return Attrs{
 		Inode:  fi.FileId(),
 		Name:   fileInfo.Name(),
 		Mode:   mode,
-		Size:   fi.Length(),
+		Size:   size,
 		Uid:    uid,
 		Mtime:  modificationTime,
 		Ctime:  modificationTime,
 		Crtime: modificationTime,
-		Gid:    gid}
+		Gid:    gid,
+		Group:  fi.OwnerGroup(),
+		Policy: policy,
+		EC:     ec,
+		Target: target}
 }
 
 func (dfs *hdfsAccessorImpl) AttrsFromFsInfo(fsInfo hdfs.FsInfo) FsInfo {
@@ -343,6 +459,7 @@ func isNonRetriableError(err error) bool {
 		err == syscall.EROFS ||
 		err == syscall.EDQUOT ||
 		err == syscall.ENOLINK ||
+		err == syscall.ENOTSUP ||
 		err == os.ErrNotExist ||
 		err == os.ErrPermission ||
 		err == os.ErrExist ||
@@ -363,7 +480,7 @@ func (dfs *hdfsAccessorImpl) Mkdir(path string, mode os.FileMode) error {
 			return err
 		}
 	}
-	err := dfs.MetadataClient.Mkdir(path, mode)
+	err := dfs.MetadataClient.Mkdir(path, hdfsPermFromFileMode(mode))
 	if err != nil {
 		if strings.HasSuffix(err.Error(), "file already exists") {
 			err = fuse.EEXIST
@@ -385,6 +502,19 @@ func (dfs *hdfsAccessorImpl) Remove(path string) error {
 	return dfs.MetadataClient.Remove(path)
 }
 
+// Removes file or directory recursively
+func (dfs *hdfsAccessorImpl) RemoveAll(path string) error {
+	dfs.lockHadoopClient()
+	defer dfs.unlockHadoopClient()
+
+	if dfs.MetadataClient == nil {
+		if err := dfs.ConnectMetadataClient(); err != nil {
+			return err
+		}
+	}
+	return dfs.MetadataClient.RemoveAll(path)
+}
+
 // Renames file or directory
 func (dfs *hdfsAccessorImpl) Rename(oldPath string, newPath string) error {
 	dfs.lockHadoopClient()
@@ -408,7 +538,7 @@ func (dfs *hdfsAccessorImpl) Chmod(path string, mode os.FileMode) error {
 			return err
 		}
 	}
-	return dfs.MetadataClient.Chmod(path, mode)
+	return dfs.MetadataClient.Chmod(path, hdfsPermFromFileMode(mode))
 }
 
 // Changes the owner and group of the file
@@ -424,19 +554,235 @@ func (dfs *hdfsAccessorImpl) Chown(path string, user, group string) error {
 	return dfs.MetadataClient.Chown(path, user, group)
 }
 
+// Changes the modification time of the file, the access time is set to the same time
+func (dfs *hdfsAccessorImpl) Chtimes(path string, mtime time.Time) error {
+	dfs.lockHadoopClient()
+	defer dfs.unlockHadoopClient()
+
+	if dfs.MetadataClient == nil {
+		if err := dfs.ConnectMetadataClient(); err != nil {
+			return err
+		}
+	}
+	return unwrapAndTranslateError(dfs.MetadataClient.Chtimes(path, mtime, mtime))
+}
+
+// Retrieves the HDFS checksum of the file, computed by the datanodes from the block checksums
+func (dfs *hdfsAccessorImpl) Checksum(path string) (FileChecksum, error) {
+	dfs.lockHadoopClient()
+	defer dfs.unlockHadoopClient()
+
+	if dfs.MetadataClient == nil {
+		if err := dfs.ConnectMetadataClient(); err != nil {
+			return FileChecksum{}, err
+		}
+	}
+	defaults, err := dfs.MetadataClient.ServerDefaults()
+	if err != nil {
+		return FileChecksum{}, unwrapAndTranslateError(err)
+	}
+	reader, err := dfs.MetadataClient.Open(path)
+	if err != nil {
+		return FileChecksum{}, unwrapAndTranslateError(err)
+	}
+	defer reader.Close()
+	status, ok := reader.Stat().Sys().(*hdfs.FileStatus)
+	if !ok {
+		return FileChecksum{}, fmt.Errorf("no block size for %s", path)
+	}
+	checksum, err := reader.Checksum()
+	if err != nil {
+		if strings.Contains(err.Error(), "stored in DB") {
+			// small files stored in the metadata database have no block checksums
+			return FileChecksum{}, syscall.ENOTSUP
+		}
+		return FileChecksum{}, err
+	}
+	return FileChecksum{Checksum: checksum, Size: int64(status.GetLength()), BlockSize: int64(status.GetBlocksize()),
+		BytesPerChecksum: defaults.BytesPerChecksum}, nil
+}
+
+// Retrieves the extended attributes of the file or directory, keyed by namespace and name
+func (dfs *hdfsAccessorImpl) GetXAttrs(path string) (map[string]string, error) {
+	dfs.lockHadoopClient()
+	defer dfs.unlockHadoopClient()
+
+	if dfs.MetadataClient == nil {
+		if err := dfs.ConnectMetadataClient(); err != nil {
+			return nil, err
+		}
+	}
+	xattrs, err := dfs.MetadataClient.ListXAttrs(path)
+	return xattrs, unwrapAndTranslateError(err)
+}
+
+// Creates or replaces an extended attribute of the file, name is prefixed by its namespace, e.g., user.
+func (dfs *hdfsAccessorImpl) SetXAttr(path, name, value string) error {
+	dfs.lockHadoopClient()
+	defer dfs.unlockHadoopClient()
+
+	if dfs.MetadataClient == nil {
+		if err := dfs.ConnectMetadataClient(); err != nil {
+			return err
+		}
+	}
+	return unwrapAndTranslateError(dfs.MetadataClient.SetXAttr(path, name, value))
+}
+
+// Retrieves the ACL entries of the file. The HDFS client has no ACL RPCs, the ACLs are managed
+// over WebHDFS, not supported without -webhdfsURL
+func (dfs *hdfsAccessorImpl) GetAcl(path string) ([]AclEntry, error) {
+	if dfs.WebHdfs == nil {
+		return nil, syscall.ENOTSUP
+	}
+	return dfs.WebHdfs.getAcl(path, dfs.webhdfsUser())
+}
+
+// Replaces the ACL of the file, over WebHDFS
+func (dfs *hdfsAccessorImpl) SetAcl(path string, entries []AclEntry) error {
+	if dfs.WebHdfs == nil {
+		return syscall.ENOTSUP
+	}
+	return dfs.WebHdfs.setAcl(path, dfs.webhdfsUser(), entries)
+}
+
+// Sets the storage policy of the file or directory, or unsets it if empty. The HDFS client has
+// no storage policy RPCs, the policy is set over WebHDFS, not supported without -webhdfsURL
+func (dfs *hdfsAccessorImpl) SetStoragePolicy(path, policy string) error {
+	if dfs.WebHdfs == nil {
+		return syscall.ENOTSUP
+	}
+	return dfs.WebHdfs.setStoragePolicy(path, dfs.webhdfsUser(), policy)
+}
+
+// Sets the erasure coding policy of the directory, or unsets it if empty. The HDFS client has no
+// erasure coding RPCs, the policy is set over WebHDFS, not supported without -webhdfsURL
+func (dfs *hdfsAccessorImpl) SetErasureCodingPolicy(path, policy string) error {
+	if dfs.WebHdfs == nil {
+		return syscall.ENOTSUP
+	}
+	return dfs.WebHdfs.setErasureCodingPolicy(path, dfs.webhdfsUser(), policy)
+}
+
+// Returns the user the WebHDFS operations run as
+func (dfs *hdfsAccessorImpl) webhdfsUser() string {
+	if dfs.User == "" {
+		return hadoopUserName
+	}
+	return dfs.User
+}
+
+// Retrieves the totals of a directory tree, computed by the namenode
+func (dfs *hdfsAccessorImpl) GetContentSummary(path string) (ContentSummary, error) {
+	dfs.lockHadoopClient()
+	defer dfs.unlockHadoopClient()
+
+	if dfs.MetadataClient == nil {
+		if err := dfs.ConnectMetadataClient(); err != nil {
+			return ContentSummary{}, err
+		}
+	}
+	cs, err := dfs.MetadataClient.GetContentSummary(path)
+	if err != nil {
+		return ContentSummary{}, unwrapAndTranslateError(err)
+	}
+	return ContentSummary{
+		Length:         cs.Size(),
+		SpaceConsumed:  cs.SizeAfterReplication(),
+		FileCount:      int64(cs.FileCount()),
+		DirectoryCount: int64(cs.DirectoryCount()),
+		NameQuota:      int64(cs.NameQuota()),
+		SpaceQuota:     cs.SpaceQuota(),
+	}, nil
+}
+
 // Close current connection if needed
 func (dfs *hdfsAccessorImpl) Close() error {
 	dfs.lockHadoopClient()
 	defer dfs.unlockHadoopClient()
 
 	if dfs.MetadataClient != nil {
-		err := dfs.MetadataClient.Close()
+		err := dfs.retireClient(dfs.MetadataClient)
 		dfs.MetadataClient = nil
 		return err
 	}
 	return nil
 }
 
+// Connects a new client, e.g., with renewed credentials, and swaps it with the current one.
+// Metadata operations are serialized, so none is using the old client when it is swapped.
+// Readers and writers opened with the old client keep using it until they are closed,
+// then the old client is closed. It is closed anyway after -credentialDrainTimeout
+func (dfs *hdfsAccessorImpl) Reconnect() error {
+	// connecting without holding the lock, operations continue with the old client meanwhile
+	client, err := dfs.ConnectToNameNode()
+	if err != nil {
+		return err
+	}
+
+	dfs.lockHadoopClient()
+	defer dfs.unlockHadoopClient()
+	old := dfs.MetadataClient
+	dfs.MetadataClient = client
+	if old != nil {
+		dfs.retireClient(old)
+	}
+	return nil
+}
+
+// Registers a reader or writer opened with the client
+func (dfs *hdfsAccessorImpl) acquireClient(client *hdfs.Client) {
+	dfs.clientRefsMutex.Lock()
+	defer dfs.clientRefsMutex.Unlock()
+	if dfs.clientRefs == nil {
+		dfs.clientRefs = make(map[*hdfs.Client]int)
+	}
+	dfs.clientRefs[client]++
+}
+
+// Unregisters a closed reader or writer. The last one closes the client if it was retired
+func (dfs *hdfsAccessorImpl) releaseClient(client *hdfs.Client) {
+	dfs.clientRefsMutex.Lock()
+	defer dfs.clientRefsMutex.Unlock()
+	dfs.clientRefs[client]--
+	if dfs.clientRefs[client] > 0 {
+		return
+	}
+	delete(dfs.clientRefs, client)
+	if dfs.retiredClients[client] {
+		delete(dfs.retiredClients, client)
+		logdebug("Closing drained client", nil)
+		client.Close()
+	}
+}
+
+// Closes the client once it has no open readers and writers
+func (dfs *hdfsAccessorImpl) retireClient(client *hdfs.Client) error {
+	dfs.clientRefsMutex.Lock()
+	defer dfs.clientRefsMutex.Unlock()
+	refs := dfs.clientRefs[client]
+	if refs == 0 {
+		return client.Close()
+	}
+
+	loginfo(fmt.Sprintf("Draining %d open readers and writers before closing the client", refs), nil)
+	if dfs.retiredClients == nil {
+		dfs.retiredClients = make(map[*hdfs.Client]bool)
+	}
+	dfs.retiredClients[client] = true
+	time.AfterFunc(credentialDrainTimeout, func() {
+		dfs.clientRefsMutex.Lock()
+		defer dfs.clientRefsMutex.Unlock()
+		if dfs.retiredClients[client] {
+			logwarn(fmt.Sprintf("%d readers and writers are still open after %v, closing the client", dfs.clientRefs[client], credentialDrainTimeout), nil)
+			delete(dfs.retiredClients, client)
+			delete(dfs.clientRefs, client)
+			client.Close()
+		}
+	})
+	return nil
+}
+
 func (dfs *hdfsAccessorImpl) lockHadoopClient() {
 	dfs.MetadataClientMutex.Lock()
 }
@@ -444,3 +790,59 @@ func (dfs *hdfsAccessorImpl) lockHadoopClient() {
 func (dfs *hdfsAccessorImpl) unlockHadoopClient() {
 	dfs.MetadataClientMutex.Unlock()
 }
+
+// Retrieves the defaults of the file system configured on the namenode
+func (dfs *hdfsAccessorImpl) ServerDefaults() (ServerDefaults, error) {
+	dfs.lockHadoopClient()
+	defer dfs.unlockHadoopClient()
+
+	if dfs.MetadataClient == nil {
+		if err := dfs.ConnectMetadataClient(); err != nil {
+			return ServerDefaults{}, err
+		}
+	}
+	defaults, err := dfs.MetadataClient.ServerDefaults()
+	if err != nil {
+		return ServerDefaults{}, unwrapAndTranslateError(err)
+	}
+	return ServerDefaults{
+		BlockSize:           defaults.BlockSize,
+		Replication:         defaults.Replication,
+		EncryptDataTransfer: defaults.EncryptDataTransfer,
+		TrashInterval:       time.Duration(defaults.TrashInterval) * time.Minute,
+	}, nil
+}
+
+// Truncates the file, returns false if the last block is recovered asynchronously
+func (dfs *hdfsAccessorImpl) Truncate(path string, size int64) (bool, error) {
+	dfs.lockHadoopClient()
+	defer dfs.unlockHadoopClient()
+
+	if dfs.MetadataClient == nil {
+		if err := dfs.ConnectMetadataClient(); err != nil {
+			return false, err
+		}
+	}
+	done, err := dfs.MetadataClient.Truncate(path, size)
+	if err != nil {
+		return false, unwrapAndTranslateError(err)
+	}
+	return done, nil
+}
+
+// Creates a snapshot of a snapshottable directory
+func (dfs *hdfsAccessorImpl) CreateSnapshot(path, name string) (string, error) {
+	dfs.lockHadoopClient()
+	defer dfs.unlockHadoopClient()
+
+	if dfs.MetadataClient == nil {
+		if err := dfs.ConnectMetadataClient(); err != nil {
+			return "", err
+		}
+	}
+	snapshotPath, err := dfs.MetadataClient.CreateSnapshot(path, name)
+	if err != nil {
+		return "", unwrapAndTranslateError(err)
+	}
+	return snapshotPath, nil
+}
@@ -12,9 +12,11 @@ import (
 // Concurrency: not thread safe: at most on request at a time
 type HdfsReader struct {
 	BackendReader *hdfs.FileReader
+	release       func() // called once the reader is closed, may be nil
 }
 
 var _ ReadSeekCloser = (*HdfsReader)(nil) // ensure HdfsReader implements ReadSeekCloser
+var _ VersionedReader = (*HdfsReader)(nil)
 
 // Creates new instance of HdfsReader
 func NewHdfsReader(backendReader *hdfs.FileReader) ReadSeekCloser {
@@ -38,6 +40,16 @@ func (hr *HdfsReader) Seek(pos int64) error {
 	return nil
 }
 
+// Returns the version of the file at the time it was opened
+func (hr *HdfsReader) Version() (FileVersion, error) {
+	info := hr.BackendReader.Stat()
+	status, ok := info.Sys().(*hdfs.FileStatus)
+	if !ok {
+		return FileVersion{}, errors.New("No file status")
+	}
+	return FileVersion{FileId: status.GetFileId(), Mtime: info.ModTime().UnixNano(), Size: info.Size()}, nil
+}
+
 // Returns current position
 func (hr *HdfsReader) Position() (int64, error) {
 	actualPos, err := hr.BackendReader.Seek(0, 1)
@@ -49,5 +61,10 @@ func (hr *HdfsReader) Position() (int64, error) {
 
 // Closes the stream
 func (hr *HdfsReader) Close() error {
-	return hr.BackendReader.Close()
+	err := hr.BackendReader.Close()
+	if hr.release != nil {
+		hr.release()
+		hr.release = nil
+	}
+	return err
 }
@@ -20,6 +20,7 @@ type HdfsWriter interface {
 
 type hdfsWriterImpl struct {
 	BackendWriter *hdfs.FileWriter
+	release       func() // called once the writer is closed, may be nil
 }
 
 var _ HdfsWriter = (*hdfsWriterImpl)(nil) // ensure hdfsWriterImpl implements HdfsWriter
@@ -39,9 +40,9 @@ func (w *hdfsWriterImpl) Write(buffer []byte) (int, error) {
 	return w.BackendWriter.Write(buffer)
 }
 
-// Flushes all the data
+// Flushes all the data to the datanodes, making it visible to readers (hflush)
 func (w *hdfsWriterImpl) Flush() error {
-	return errors.New("Flush is not implemented")
+	return w.BackendWriter.Flush()
 }
 
 // Closes the stream
@@ -51,5 +52,10 @@ func (w *hdfsWriterImpl) Truncate() error {
 
 // Truncate the HDFS file at a given position
 func (w *hdfsWriterImpl) Close() error {
-	return w.BackendWriter.Close()
+	err := w.BackendWriter.Close()
+	if w.release != nil {
+		w.release()
+		w.release = nil
+	}
+	return err
 }
@@ -0,0 +1,238 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// With -hedgedReadPercentile, a read which has not returned after that percentile of the
+// latencies of recent reads is hedged: a second stream is opened at the same offset and
+// whichever read returns first is taken, the other stream is closed once its read completes.
+// The HDFS client picks the datanode of a stream itself, the second stream reads from the
+// replica the namenode lists first, which is a different replica when the namenode shuffles
+// replicas at the same distance, not necessarily. -hedgedReadBudget caps the hedged reads at
+// a percentage of all reads so that an overloaded cluster does not get twice the load.
+// Hedged reads are recorded as hedged_read, those which returned first as hedged_read_won
+const hedgedReadSamples = 256
+
+// Minimum number of latency samples before reads are hedged
+const hedgedReadMinSamples = 32
+
+// Tracks the latencies of reads to decide when to hedge, and the budget of hedged reads
+// Concurrency: thread safe, shared by all the readers of the mount
+type ReadHedger struct {
+	Percentile  float64       // percentile of the latencies after which a read is hedged
+	MinDeadline time.Duration // reads are never hedged sooner
+	Budget      float64       // percentage of the reads which may be hedged
+	Clock       Clock
+	samples     []time.Duration // ring buffer of recent latencies
+	next        int
+	tokens      float64 // hedged reads available, every read adds Budget/100
+	mutex       sync.Mutex
+}
+
+// Hedger of the reads of the mount, nil if hedging is disabled
+var readHedger *ReadHedger
+
+// Creates a hedger
+func NewReadHedger(percentile float64, minDeadline time.Duration, budget float64, clock Clock) *ReadHedger {
+	return &ReadHedger{Percentile: percentile, MinDeadline: minDeadline, Budget: budget, Clock: clock}
+}
+
+// Records the latency of a read which was not hedged
+func (hedger *ReadHedger) observe(latency time.Duration) {
+	hedger.mutex.Lock()
+	defer hedger.mutex.Unlock()
+	if len(hedger.samples) < hedgedReadSamples {
+		hedger.samples = append(hedger.samples, latency)
+	} else {
+		hedger.samples[hedger.next] = latency
+		hedger.next = (hedger.next + 1) % hedgedReadSamples
+	}
+}
+
+// Returns how long to wait for a read before hedging it, 0 if reads are not hedged yet
+func (hedger *ReadHedger) deadline() time.Duration {
+	hedger.mutex.Lock()
+	defer hedger.mutex.Unlock()
+	hedger.tokens += hedger.Budget / 100
+	if hedger.tokens > 1 {
+		hedger.tokens = 1
+	}
+	if len(hedger.samples) < hedgedReadMinSamples {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), hedger.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	deadline := sorted[int(hedger.Percentile/100*float64(len(sorted)-1))]
+	if deadline < hedger.MinDeadline {
+		deadline = hedger.MinDeadline
+	}
+	return deadline
+}
+
+// Takes a hedged read from the budget, returns false if it is used up
+func (hedger *ReadHedger) allow() bool {
+	hedger.mutex.Lock()
+	defer hedger.mutex.Unlock()
+	if hedger.tokens < 1 {
+		return false
+	}
+	hedger.tokens--
+	return true
+}
+
+// Hedges the reads of a stream with reads of a second stream
+// Concurrency: not thread safe: at most on request at a time
+type HedgedReader struct {
+	Path   string
+	Impl   ReadSeekCloser
+	Open   func() (ReadSeekCloser, error) // opens another stream of the file
+	Hedger *ReadHedger
+	offset int64
+}
+
+var _ ReadSeekCloser = (*HedgedReader)(nil) // ensure HedgedReader implements ReadSeekCloser
+var _ VersionedReader = (*HedgedReader)(nil)
+
+// Creates new instance of HedgedReader
+func NewHedgedReader(path string, impl ReadSeekCloser, open func() (ReadSeekCloser, error), hedger *ReadHedger) *HedgedReader {
+	return &HedgedReader{Path: path, Impl: impl, Open: open, Hedger: hedger}
+}
+
+type hedgedReadResult struct {
+	buffer []byte
+	n      int
+	err    error
+}
+
+// Reads into a buffer of its own, the read may complete after the caller moved on
+func readAsync(reader ReadSeekCloser, size int) chan hedgedReadResult {
+	result := make(chan hedgedReadResult, 1)
+	go func() {
+		buffer := make([]byte, size)
+		n, err := reader.Read(buffer)
+		result <- hedgedReadResult{buffer: buffer, n: n, err: err}
+	}()
+	return result
+}
+
+// Read a chunk of data
+func (hr *HedgedReader) Read(buffer []byte) (int, error) {
+	deadline := hr.Hedger.deadline()
+	if deadline == 0 {
+		start := hr.Hedger.Clock.Now()
+		n, err := hr.Impl.Read(buffer)
+		if err == nil {
+			hr.Hedger.observe(hr.Hedger.Clock.Now().Sub(start))
+		}
+		hr.offset += int64(n)
+		return n, err
+	}
+
+	start := hr.Hedger.Clock.Now()
+	primary := readAsync(hr.Impl, len(buffer))
+	select {
+	case result := <-primary:
+		if result.err == nil {
+			hr.Hedger.observe(hr.Hedger.Clock.Now().Sub(start))
+		}
+		return hr.complete(buffer, result)
+	case <-hr.Hedger.Clock.After(deadline):
+	}
+	hedge := hr.openHedge()
+	if hedge == nil {
+		return hr.complete(buffer, <-primary)
+	}
+	hedged := readAsync(hedge, len(buffer))
+	select {
+	case result := <-primary:
+		go closeAfter(hedge, hedged)
+		metrics.Record(HedgedRead, hr.Hedger.Clock.Now().Sub(start), 0, 0, false, nil)
+		return hr.complete(buffer, result)
+	case result := <-hedged:
+		if result.err != nil {
+			hedge.Close()
+			metrics.Record(HedgedRead, hr.Hedger.Clock.Now().Sub(start), 0, 0, false, result.err)
+			return hr.complete(buffer, <-primary)
+		}
+		// the hedged stream takes over, the primary one is closed once its read completes
+		go closeAfter(hr.Impl, primary)
+		hr.Impl = hedge
+		metrics.Record(HedgedRead, hr.Hedger.Clock.Now().Sub(start), int64(result.n), 0, false, nil)
+		metrics.Record(HedgedReadWon, hr.Hedger.Clock.Now().Sub(start), int64(result.n), 0, false, nil)
+		logdebug("Hedged read returned first", Fields{Operation: HedgedRead, Path: hr.Path, Offset: hr.offset, Bytes: result.n})
+		return hr.complete(buffer, result)
+	}
+}
+
+func (hr *HedgedReader) complete(buffer []byte, result hedgedReadResult) (int, error) {
+	n := copy(buffer, result.buffer[:result.n])
+	hr.offset += int64(n)
+	return n, result.err
+}
+
+// Opens the second stream at the offset of the read, nil if the budget is used up or it fails
+func (hr *HedgedReader) openHedge() ReadSeekCloser {
+	if !hr.Hedger.allow() {
+		return nil
+	}
+	hedge, err := hr.Open()
+	if err != nil {
+		logdebug("Unable to open a stream for a hedged read", Fields{Operation: HedgedRead, Path: hr.Path, Error: err})
+		return nil
+	}
+	if err := hedge.Seek(hr.offset); err != nil {
+		hedge.Close()
+		return nil
+	}
+	// the file must not have been replaced in between, the reader would mix the content of two files
+	if primary, ok := hr.Impl.(VersionedReader); ok {
+		if secondary, ok := hedge.(VersionedReader); ok {
+			v1, err1 := primary.Version()
+			v2, err2 := secondary.Version()
+			if err1 != nil || err2 != nil || v1 != v2 {
+				hedge.Close()
+				return nil
+			}
+		}
+	}
+	return hedge
+}
+
+// Closes the stream once its pending read completes
+func closeAfter(reader ReadSeekCloser, pending chan hedgedReadResult) {
+	<-pending
+	reader.Close()
+}
+
+// Seeks to a given position
+func (hr *HedgedReader) Seek(pos int64) error {
+	err := hr.Impl.Seek(pos)
+	if err == nil {
+		hr.offset = pos
+	}
+	return err
+}
+
+// Returns the version of the file the stream was opened for
+func (hr *HedgedReader) Version() (FileVersion, error) {
+	if v, ok := hr.Impl.(VersionedReader); ok {
+		return v.Version()
+	}
+	return FileVersion{}, errors.New("Version is not known")
+}
+
+// Returns current position
+func (hr *HedgedReader) Position() (int64, error) {
+	return hr.offset, nil
+}
+
+// Closes the stream
+func (hr *HedgedReader) Close() error {
+	return hr.Impl.Close()
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that a slow read is hedged within the budget, and that the faster stream takes over
+func TestHedgedReader(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hedger := NewReadHedger(95, time.Millisecond, 100, mockClock)
+	for i := 0; i < hedgedReadMinSamples; i++ {
+		hedger.observe(time.Millisecond)
+	}
+	primary := NewMockReadSeekCloser(mockCtrl)
+	secondary := NewMockReadSeekCloser(mockCtrl)
+	opened := 0
+	reader := NewHedgedReader("/a", primary, func() (ReadSeekCloser, error) {
+		opened++
+		return secondary, nil
+	}, hedger)
+
+	release := make(chan struct{})
+	closed := make(chan struct{})
+	primary.EXPECT().Read(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		<-release
+		return copy(b, "slow"), nil
+	})
+	primary.EXPECT().Close().DoAndReturn(func() error {
+		close(closed)
+		return nil
+	})
+	secondary.EXPECT().Seek(int64(0)).Return(nil)
+	secondary.EXPECT().Read(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		return copy(b, "fast"), nil
+	})
+	buffer := make([]byte, 4)
+	metrics.Snapshot(true)
+	n, err := reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "fast", string(buffer[:n]))
+	assert.Equal(t, 1, opened)
+	assert.Equal(t, uint64(1), metrics.Snapshot(true)[HedgedReadWon].Count)
+	close(release)
+	<-closed
+	position, _ := reader.Position()
+	assert.Equal(t, int64(4), position)
+
+	// the budget is used up, the read waits for the current stream
+	hedger.Budget = 0
+	secondary.EXPECT().Read(gomock.Any()).Return(2, nil)
+	n, err = reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 1, opened)
+}
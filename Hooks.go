@@ -0,0 +1,157 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Downstream systems, e.g., feature stores, indexers and antivirus scanners, polled HDFS to find
+// the data which landed through the mount. With -hookCommand, the command is run through sh -c
+// after each file created, uploaded by a flush and deleted through the mount, with the event as
+// JSON on its standard input and in the HOPSFS_EVENT and HOPSFS_PATH environment variables, and
+// with -hookURL the event is POSTed as JSON to the URL. -hookEvents restricts the events. Hooks
+// run in the background, one at a time in the order of the events, and at most -hookTimeout
+// each: they never delay or fail the operation, and a failed hook is logged and not retried.
+// Events beyond hookQueueSize waiting to be run are dropped and logged. The events waiting when
+// unmounting are run before the mount exits
+var hookCommand string
+var hookURL string
+var hookEvents string
+var hookTimeout time.Duration
+
+// Events which may wait for the hooks to run, further ones are dropped
+const hookQueueSize = 1024
+
+// Events passed to the hooks
+const (
+	HookCreate = "create" // a file was created, it is empty until its first flush
+	HookFlush  = "flush"  // the content of a file was uploaded
+	HookDelete = "delete" // a file or an empty directory was deleted
+)
+
+// Event passed to the hooks
+type HookEvent struct {
+	Event string      `json:"event"`
+	Path  string      `json:"path"`           // HDFS path
+	Size  int64       `json:"size,omitempty"` // bytes written by the handle, for flush
+	Mode  os.FileMode `json:"mode,omitempty"` // for create
+	Uid   uint32      `json:"uid"`            // uid of the process
+	Time  time.Time   `json:"time"`
+}
+
+// Runs -hookCommand and calls -hookURL for the events, see above
+// Concurrency: thread safe
+type Hooks struct {
+	Command string
+	URL     string
+	Timeout time.Duration
+	Clock   Clock
+	events  map[string]bool
+	client  *http.Client
+
+	mutex  sync.Mutex // protects closed against Fire() sending on the closed queue
+	closed bool
+	queue  chan HookEvent
+	done   chan struct{}
+}
+
+// Creates the hooks for the comma separated events, and starts running them
+func NewHooks(command, url, events string, timeout time.Duration, clock Clock) (*Hooks, error) {
+	hooks := &Hooks{Command: command, URL: url, Timeout: timeout, Clock: clock, events: map[string]bool{},
+		client: &http.Client{Timeout: timeout}, queue: make(chan HookEvent, hookQueueSize), done: make(chan struct{})}
+	for _, event := range strings.Split(events, ",") {
+		switch event = strings.TrimSpace(event); event {
+		case HookCreate, HookFlush, HookDelete:
+			hooks.events[event] = true
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown hook event %q, expected %s, %s or %s", event, HookCreate, HookFlush, HookDelete)
+		}
+	}
+	go hooks.run()
+	return hooks, nil
+}
+
+// Queues the event for the hooks, if it is one of -hookEvents. Does not wait for the hooks
+func (hooks *Hooks) Fire(event string, hdfsPath string, size int64, mode os.FileMode, uid uint32) {
+	if hooks == nil || !hooks.events[event] {
+		return
+	}
+	e := HookEvent{Event: event, Path: hdfsPath, Size: size, Mode: mode, Uid: uid, Time: hooks.Clock.Now()}
+	hooks.mutex.Lock()
+	defer hooks.mutex.Unlock()
+	if hooks.closed {
+		return
+	}
+	select {
+	case hooks.queue <- e:
+	default:
+		logwarn(fmt.Sprintf("Too many hook events waiting, dropping the %s event", event), Fields{Operation: HookOp, Path: hdfsPath})
+	}
+}
+
+// Runs the hooks of the queued events until closed
+func (hooks *Hooks) run() {
+	defer close(hooks.done)
+	for e := range hooks.queue {
+		data, _ := json.Marshal(e)
+		if hooks.Command != "" {
+			if err := hooks.runCommand(e, data); err != nil {
+				logwarn(fmt.Sprintf("Hook command failed for the %s event", e.Event), Fields{Operation: HookOp, Path: e.Path, Error: err})
+			}
+		}
+		if hooks.URL != "" {
+			if err := hooks.post(data); err != nil {
+				logwarn(fmt.Sprintf("Hook URL failed for the %s event", e.Event), Fields{Operation: HookOp, Path: e.Path, Error: err})
+			}
+		}
+	}
+}
+
+// Runs -hookCommand for the event
+func (hooks *Hooks) runCommand(e HookEvent, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hooks.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", hooks.Command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(), "HOPSFS_EVENT="+e.Event, "HOPSFS_PATH="+e.Path)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// POSTs the event to -hookURL
+func (hooks *Hooks) post(data []byte) error {
+	resp, err := hooks.client.Post(hooks.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", hooks.URL, resp.Status)
+	}
+	return nil
+}
+
+// Stops accepting events, and waits for the hooks of the queued ones to run
+func (hooks *Hooks) Close() error {
+	hooks.mutex.Lock()
+	if !hooks.closed {
+		hooks.closed = true
+		close(hooks.queue)
+	}
+	hooks.mutex.Unlock()
+	<-hooks.done
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the events of -hookEvents run the command and are POSTed to the URL, in order
+func TestHooks(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hopsfs-hooks")
+	defer os.RemoveAll(dir)
+	var posted []HookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e HookEvent
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&e))
+		posted = append(posted, e)
+	}))
+	defer server.Close()
+	log := filepath.Join(dir, "events")
+	clock := &MockClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	hooks, err := NewHooks(`echo "$HOPSFS_EVENT $HOPSFS_PATH" >> `+log, server.URL, "create, flush", time.Minute, clock)
+	assert.Nil(t, err)
+	hooks.Fire(HookCreate, "/data/a", 0, 0644, 1000)
+	hooks.Fire(HookDelete, "/data/b", 0, 0, 1000)
+	hooks.Fire(HookFlush, "/data/a", 5, 0, 1000)
+	assert.Nil(t, hooks.Close())
+	hooks.Fire(HookFlush, "/data/c", 5, 0, 1000)
+
+	data, _ := ioutil.ReadFile(log)
+	assert.Equal(t, "create /data/a\nflush /data/a\n", string(data))
+	assert.Equal(t, []HookEvent{
+		{Event: HookCreate, Path: "/data/a", Mode: 0644, Uid: 1000, Time: clock.Now()},
+		{Event: HookFlush, Path: "/data/a", Size: 5, Uid: 1000, Time: clock.Now()},
+	}, posted)
+
+	_, err = NewHooks("true", "", "create,rename", time.Minute, clock)
+	assert.True(t, strings.Contains(err.Error(), "rename"))
+}
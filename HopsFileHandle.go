@@ -5,6 +5,8 @@ package main
 import (
 	"io"
 	"sync"
+	"sync/atomic"
+	"syscall"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -18,7 +20,20 @@ type FileHandle struct {
 	fileFlags         fuse.OpenFlags // flags used to creat the file
 	tatalBytesRead    int64
 	totalBytesWritten int64
-	fhID              int64 // file handle id. for debugging only
+	fhID              int64        // file handle id. for debugging only
+	ioClass           IOClass      // priority of the reads of the handle
+	connector         HdfsAccessor // connection of the user who opened the handle, nil for the mount's
+	uid               uint32       // uid of the process which opened the handle
+	forceClosed       int32        // set atomically by the close-handle admin command, see ForceClose.go
+	released          bool         // released by the process or by the close-handle admin command
+}
+
+// Returns the connection the RPCs of the handle are issued on
+func (fh *FileHandle) dfsConnector() HdfsAccessor {
+	if fh.connector != nil {
+		return fh.connector
+	}
+	return fh.File.FileSystem.getDFSConnector()
 }
 
 // Verify that *FileHandle implements necesary FUSE interfaces
@@ -30,7 +45,12 @@ var _ fs.NodeFsyncer = (*FileHandle)(nil)
 var _ fs.HandleFlusher = (*FileHandle)(nil)
 
 func (fh *FileHandle) dataChanged() bool {
-	if fh.totalBytesWritten > 0 {
+	if proxy, ok := fh.File.fileProxy.(*StreamingFileProxy); ok {
+		return fh.totalBytesWritten > 0 && proxy.streaming()
+	}
+	// data which was already uploaded, e.g., by fsync or by the flush of another
+	// handle, is not uploaded again. A file renamed after fsync is not re-uploaded on close
+	if fh.totalBytesWritten > 0 && atomic.LoadInt64(&fh.File.dirtyBytes) > 0 {
 		return true
 	} else {
 		return false
@@ -50,7 +70,12 @@ func (fh *FileHandle) Truncate(size int64) error {
 		return err
 	}
 
-	fh.totalBytesWritten += sizeChanged
+	atomic.AddInt64(&fh.totalBytesWritten, sizeChanged)
+	atomic.AddInt64(&fh.File.dirtyBytes, sizeChanged)
+	fh.File.FileSystem.Dirty.Add(sizeChanged)
+	if fh.File.logStream != nil {
+		fh.File.logStream.Truncated(size)
+	}
 
 	loginfo("Truncated file", fh.logInfo(Fields{Operation: Truncate, Bytes: size}))
 	return nil
@@ -67,11 +92,28 @@ func (fh *FileHandle) Attr(ctx context.Context, a *fuse.Attr) error {
 func (fh *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
 	fh.lockHandle()
 	defer fh.unlockHandle()
+	if err := fh.checkForceClosed(); err != nil {
+		return err
+	}
 
+	start := fh.File.FileSystem.Clock.Now()
 	buf := resp.Data[0:req.Size]
+	// reads from the staging file are served locally
+	_, local := fh.File.fileProxy.(*LocalRWFileProxy)
+	if !local {
+		fh.File.FileSystem.IOScheduler.Acquire(fh.ioClass)
+	}
 	nr, err := fh.File.fileProxy.ReadAt(buf, req.Offset)
+	if !local {
+		fh.File.FileSystem.IOScheduler.Release()
+	}
 	resp.Data = buf[0:nr]
-	fh.tatalBytesRead += int64(nr)
+	atomic.AddInt64(&fh.tatalBytesRead, int64(nr))
+	readErr := err
+	if err == io.EOF {
+		readErr = nil
+	}
+	metrics.Record(Read, fh.File.FileSystem.Clock.Now().Sub(start), int64(nr), 0, local, readErr)
 
 	if err != nil {
 		if err == io.EOF {
@@ -88,22 +130,67 @@ func (fh *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fus
 
 // Responds to FUSE Write request
 func (fh *FileHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	if err := fh.checkForceClosed(); err != nil {
+		return err
+	}
+	if err := fh.File.FileSystem.checkWritable(); err != nil {
+		return err
+	}
+	// before entering the gate, a freeze waiting for the write would not upload the dirty data
+	if err := fh.File.FileSystem.Dirty.Throttle(maxDirtyBytes, dirtyWaitTimeout, fh.writeback); err != nil {
+		return err
+	}
+	fh.File.FileSystem.Mutations.Enter()
+	defer fh.File.FileSystem.Mutations.Exit()
+	if err := fh.File.checkFileSize(Write, req.Offset+int64(len(req.Data))); err != nil {
+		return err
+	}
+	if err := fh.File.FileSystem.reserveStaging(fh.File, fh.uid, req.Offset, int64(len(req.Data))); err != nil {
+		return err
+	}
 	fh.lockHandle()
 	defer fh.unlockHandle()
+	if err := fh.checkForceClosed(); err != nil {
+		return err
+	}
 
 	// as an optimization the file is initially opened in readonly mode
 	fh.File.upgradeHandleForWriting(fh)
 
+	start := fh.File.FileSystem.Clock.Now()
 	nw, err := fh.File.fileProxy.WriteAt(req.Data, req.Offset)
 	resp.Size = nw
-	fh.totalBytesWritten += int64(nw)
+	atomic.AddInt64(&fh.totalBytesWritten, int64(nw))
+	if _, streaming := fh.File.fileProxy.(*StreamingFileProxy); !streaming {
+		// streamed data is not in the staging dir
+		atomic.AddInt64(&fh.File.dirtyBytes, int64(nw))
+		fh.File.FileSystem.Dirty.Add(int64(nw))
+	}
+	metrics.Record(Write, fh.File.FileSystem.Clock.Now().Sub(start), int64(nw), 0, false, err)
 	if err != nil {
 		logerror("Failed to write to staging file", fh.logInfo(Fields{Operation: Write, Error: err}))
 		return err
-	} else {
-		logdebug("Write data to staging file", fh.logInfo(Fields{Operation: Write, Bytes: nw, ReqOffset: req.Offset}))
+	}
+	logdebug("Write data to staging file", fh.logInfo(Fields{Operation: Write, Bytes: nw, ReqOffset: req.Offset}))
+	if stream := fh.File.logStream; stream != nil {
+		stream.Written(req.Offset, nw)
+		if stream.Pending() >= logStreamBytes {
+			// failures are retried at the next interval and on flush
+			stream.Stream()
+		}
+	}
+	return nil
+}
+
+// Uploads the data written to the file so far, for a writer blocked by -maxDirtyBytes
+func (fh *FileHandle) writeback() error {
+	if fh.File.Dirty() == 0 {
 		return nil
 	}
+	fh.File.FileSystem.Mutations.Enter()
+	defer fh.File.FileSystem.Mutations.Exit()
+	loginfo("Uploading the data written so far, over -maxDirtyBytes", fh.logInfo(Fields{Operation: Write, Bytes: fh.File.Dirty()}))
+	return fh.Fsync(nil, &fuse.FsyncRequest{})
 }
 
 func (fh *FileHandle) copyToDFS(operation string) error {
@@ -111,23 +198,116 @@ func (fh *FileHandle) copyToDFS(operation string) error {
 		return nil
 	}
 	defer fh.File.InvalidateMetadataCache()
+	if proxy, ok := fh.File.fileProxy.(*StreamingFileProxy); ok {
+		return fh.flushStream(proxy, operation)
+	}
 
 	logdebug("Uploading to DFS", fh.logInfo(Fields{Operation: Write, Bytes: TotalBytesWritten}))
 
 	op := fh.File.FileSystem.RetryPolicy.StartOperation()
+	if skipUnchangedUploads && fh.contentUnchanged() {
+		// the file is touched as if it was rewritten, e.g., for make and for mtime based syncs
+		err := fh.dfsConnector().Chtimes(fh.File.AbsolutePath(), fh.File.FileSystem.Clock.Now())
+		if err == nil {
+			loginfo("Content unchanged, skipped upload to DFS", fh.logInfo(Fields{Operation: operation}))
+			fh.File.FileSystem.Dirty.Release(atomic.SwapInt64(&fh.File.dirtyBytes, 0))
+			metrics.Record(operation, fh.File.FileSystem.Clock.Now().Sub(op.Start), 0, 0, true, nil)
+			return nil
+		}
+		logwarn("Failed to update the modification time, uploading", fh.logInfo(Fields{Operation: operation, Error: err}))
+	}
+	// captured once, a retried attempt finds the file removed by the previous one
+	preserved := capturePreservedMetadata(fh.dfsConnector(), fh.File.AbsolutePath())
 	for {
 		err := fh.FlushAttempt(operation)
+		if err == nil {
+			preserved.restore(fh.dfsConnector(), fh.File.AbsolutePath())
+			fh.File.FileSystem.Hooks.Fire(HookFlush, fh.File.AbsolutePath(), fh.totalBytesWritten, 0, fh.uid)
+			fh.File.FileSystem.Dirty.Release(atomic.SwapInt64(&fh.File.dirtyBytes, 0))
+			if quotaWarningPercent > 0 && fh.File.Parent != nil {
+				go fh.File.Parent.checkQuota()
+			}
+			if proxy, ok := fh.File.fileProxy.(*LocalRWFileProxy); ok {
+				proxy.uploaded()
+				if fh.File.logStream != nil {
+					if info, err := proxy.localFile.Stat(); err == nil {
+						fh.File.logStream.Reset(info.Size())
+					}
+				}
+			}
+		}
 		if err != io.EOF || IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("Flush() %s", err) {
+			metrics.Record(operation, fh.File.FileSystem.Clock.Now().Sub(op.Start), fh.totalBytesWritten, op.Attempt-1, false, err)
+			if failedUploadsDir != "" && !IsSuccessOrNonRetriableError(err) {
+				fh.parkUpload(operation, err)
+			}
 			return err
 		}
 		// Reconnect and try again
-		fh.File.FileSystem.getDFSConnector().Close()
+		fh.dfsConnector().Close()
 		logwarn("Failed to copy file to DFS", fh.logInfo(Fields{Operation: operation}))
 	}
 }
 
+// Returns true if the staging file has the content of the file in DFS, e.g., a configuration
+// management tool rewrote the file with the same content. Any error means changed content
+func (fh *FileHandle) contentUnchanged() bool {
+	proxy, ok := fh.File.fileProxy.(*LocalRWFileProxy)
+	if !ok {
+		return false
+	}
+	info, err := proxy.localFile.Stat()
+	if err != nil {
+		return false
+	}
+	hdfsAccessor := fh.dfsConnector()
+	path := fh.File.AbsolutePath()
+	remote, err := hdfsAccessor.Checksum(path)
+	if err == syscall.ENOTSUP {
+		// no block checksums for small files stored in the metadata database, comparing the content
+		attrs, err := hdfsAccessor.Stat(path)
+		if err != nil || int64(attrs.Size) != info.Size() {
+			return false
+		}
+		reader, err := hdfsAccessor.OpenRead(path)
+		if err != nil {
+			return false
+		}
+		defer reader.Close()
+		equal, err := sameContent(io.NewSectionReader(proxy.localFile, 0, info.Size()), reader)
+		return err == nil && equal
+	}
+	if err != nil || remote.Size != info.Size() {
+		return false
+	}
+	matches, err := remote.Matches(io.NewSectionReader(proxy.localFile, 0, info.Size()))
+	if err != nil {
+		logwarn("Failed to compute the checksum of the staging file", fh.logInfo(Fields{Operation: Checksum, Error: err}))
+	}
+	return err == nil && matches
+}
+
 func (fh *FileHandle) FlushAttempt(operation string) error {
-	hdfsAccessor := fh.File.FileSystem.getDFSConnector()
+	fh.File.FileSystem.IOScheduler.Acquire(Batch)
+	defer fh.File.FileSystem.IOScheduler.Release()
+	hdfsAccessor := fh.dfsConnector()
+	if proxy, ok := fh.File.fileProxy.(*LocalRWFileProxy); ok && deltaUploads && fh.File.logStream == nil {
+		if uploaded, ok := proxy.uploadChanges(hdfsAccessor, fh.File.AbsolutePath()); ok {
+			loginfo("Uploaded the changes to DFS", fh.logInfo(Fields{Operation: operation, Bytes: uploaded}))
+			return nil
+		}
+	}
+	if proxy, ok := fh.File.fileProxy.(*LocalRWFileProxy); ok && resumableUploadThreshold > 0 {
+		if info, err := proxy.localFile.Stat(); err == nil && info.Size() >= resumableUploadThreshold {
+			uploaded, err := uploadStagingFile(hdfsAccessor, proxy.localFile, fh.File.AbsolutePath(), fh.File.Attrs.Mode, resumableUploadPartSize)
+			if err != nil {
+				logerror("Failed to upload to DFS", fh.logInfo(Fields{Operation: operation, Bytes: uploaded, Error: err}))
+				return err
+			}
+			loginfo("Uploaded to DFS", fh.logInfo(Fields{Operation: operation, Bytes: uploaded}))
+			return nil
+		}
+	}
 	//delete the file and then rewrite.
 	//note we can not rely on the overwrite functionality of CreateFile API.
 	//For example if the file has permission set to 444 then we can not overwrite it
@@ -186,37 +366,71 @@ func (fh *FileHandle) FlushAttempt(operation string) error {
 func (fh *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
 	fh.lockHandle()
 	defer fh.unlockHandle()
-	if fh.dataChanged() {
+	if err := fh.checkForceClosed(); err != nil {
+		return err
+	}
+	deferredErr := fh.File.takeDeferredFlushErr()
+	var err error
+	if stream := fh.File.logStream; stream != nil && stream.Active() {
+		err = stream.Stream()
+	} else if fh.dataChanged() {
+		if fh.pendingReplace() == "" && fh.deferFlush() {
+			return deferredErr
+		}
 		loginfo("Flush file", fh.logInfo(Fields{Operation: Flush}))
-		return fh.copyToDFS(Flush)
-	} else {
-		return nil
+		err = fh.copyToDFS(Flush)
+	}
+	if err == nil {
+		err = deferredErr
+	}
+	if target := fh.pendingReplace(); target != "" {
+		return fh.replace(target, err)
 	}
+	return err
 }
 
 // Responds to the FUSE Fsync request
 func (fh *FileHandle) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
 	fh.lockHandle()
 	defer fh.unlockHandle()
-	if fh.dataChanged() {
+	if err := fh.checkForceClosed(); err != nil {
+		return err
+	}
+	deferredErr := fh.File.takeDeferredFlushErr()
+	var err error
+	if stream := fh.File.logStream; stream != nil && stream.Active() {
+		err = stream.Stream()
+	} else if fh.dataChanged() {
 		loginfo("Fsync file", fh.logInfo(Fields{Operation: Fsync}))
-		return fh.copyToDFS(Fsync)
-	} else {
-		return nil
+		err = fh.copyToDFS(Fsync)
 	}
+	if err == nil {
+		err = deferredErr
+	}
+	return err
 }
 
-// Closes the handle
+// Closes the handle. Returns EBADF if it was force-closed, also when this releases it
 func (fh *FileHandle) Release(_ context.Context, _ *fuse.ReleaseRequest) error {
 	fh.lockHandle()
 	defer fh.unlockHandle()
+	if !fh.released {
+		fh.release()
+	}
+	return fh.checkForceClosed()
+}
 
+// Unregisters the handle, closing the staging file if it is the last one. Called with the handle lock held
+func (fh *FileHandle) release() {
+	fh.released = true
 	//close the file handle if it is the last handle
 	fh.File.InvalidateMetadataCache()
 	fh.File.RemoveHandle(fh)
+	if heatmap := fh.File.FileSystem.Heatmap; heatmap != nil && fh.tatalBytesRead > 0 {
+		heatmap.Record(fh.File.AbsolutePath(), fh.tatalBytesRead)
+	}
 
 	loginfo("Closed file handle ", fh.logInfo(Fields{Operation: Close, Flags: fh.fileFlags, TotalBytesRead: fh.tatalBytesRead, TotalBytesWritten: fh.totalBytesWritten}))
-	return nil
 }
 
 func (fh *FileHandle) logInfo(fields Fields) Fields {
@@ -0,0 +1,112 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Priority class of the HDFS data transfers of a file handle
+type IOClass int
+
+const (
+	Interactive IOClass = iota
+	Batch
+)
+
+// Extended attribute of a directory tagging the files opened under it as "batch" or "interactive"
+const ioClassXAttr = "user.hopsfs.io_class"
+
+func (class IOClass) String() string {
+	if class == Batch {
+		return "batch"
+	}
+	return "interactive"
+}
+
+// Limits the concurrent HDFS data transfers, reads of remote files and uploads, to
+// -maxTransfers. Once all slots are taken, waiting interactive transfers get the next free
+// slot before batch ones. Uploads are batch transfers, reads have the class of their handle
+// Concurrency: thread safe
+type IOScheduler struct {
+	Slots   int
+	inUse   int
+	waiting [2]int // per class
+	mutex   sync.Mutex
+	cond    *sync.Cond
+}
+
+// Creates a scheduler of the given number of concurrent transfers
+func NewIOScheduler(slots int) *IOScheduler {
+	scheduler := &IOScheduler{Slots: slots}
+	scheduler.cond = sync.NewCond(&scheduler.mutex)
+	return scheduler
+}
+
+// Waits for a free slot. A nil scheduler does not limit transfers
+func (scheduler *IOScheduler) Acquire(class IOClass) {
+	if scheduler == nil {
+		return
+	}
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.waiting[class]++
+	for scheduler.inUse >= scheduler.Slots || (class == Batch && scheduler.waiting[Interactive] > 0) {
+		scheduler.cond.Wait()
+	}
+	scheduler.waiting[class]--
+	scheduler.inUse++
+}
+
+// Frees the slot taken by Acquire
+func (scheduler *IOScheduler) Release() {
+	if scheduler == nil {
+		return
+	}
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.inUse--
+	scheduler.cond.Broadcast()
+}
+
+// Returns the class of a file opened by the uid under the directory. Handles of the
+// -batchUids users are batch, other handles have the class the directory or its closest
+// ancestor is tagged with, interactive by default
+func (filesystem *FileSystem) ioClassOf(dir *DirINode, uid uint32) IOClass {
+	if filesystem.IOScheduler == nil {
+		return Interactive
+	}
+	for _, batchUid := range strings.Split(batchUids, ",") {
+		if id, err := strconv.ParseUint(strings.TrimSpace(batchUid), 10, 32); err == nil && uint32(id) == uid {
+			return Batch
+		}
+	}
+	return dir.IOClass()
+}
+
+// Returns the class the directory or its closest ancestor is tagged with. The tag is read from
+// HDFS once while the directory is cached
+func (dir *DirINode) IOClass() IOClass {
+	dir.ioClassOnce.Do(func() {
+		if dir.Parent != nil {
+			dir.ioClass = dir.Parent.IOClass()
+		}
+		if !dir.FileSystem.Capabilities.XAttrs {
+			return
+		}
+		xattrs, err := dir.FileSystem.getDFSConnector().GetXAttrs(dir.AbsolutePath())
+		if err != nil {
+			logwarn("Unable to read the I/O class of the directory", Fields{Operation: GetXAttrs, Path: dir.AbsolutePath(), Error: err})
+			return
+		}
+		switch xattrs[ioClassXAttr] {
+		case "batch":
+			dir.ioClass = Batch
+		case "interactive":
+			dir.ioClass = Interactive
+		}
+	})
+	return dir.ioClass
+}
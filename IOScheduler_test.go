@@ -0,0 +1,75 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that waiting interactive transfers get a free slot before waiting batch ones
+func TestIOSchedulerPriority(t *testing.T) {
+	scheduler := NewIOScheduler(1)
+	scheduler.Acquire(Batch)
+
+	order := make(chan IOClass, 2)
+	go func() {
+		scheduler.Acquire(Batch)
+		order <- Batch
+		scheduler.Release()
+	}()
+	waitForWaiting(scheduler, Batch)
+	go func() {
+		scheduler.Acquire(Interactive)
+		order <- Interactive
+		scheduler.Release()
+	}()
+	waitForWaiting(scheduler, Interactive)
+
+	scheduler.Release()
+	assert.Equal(t, Interactive, <-order)
+	assert.Equal(t, Batch, <-order)
+
+	// a nil scheduler does not limit
+	var unlimited *IOScheduler
+	unlimited.Acquire(Batch)
+	unlimited.Release()
+}
+
+func waitForWaiting(scheduler *IOScheduler, class IOClass) {
+	for {
+		scheduler.mutex.Lock()
+		waiting := scheduler.waiting[class]
+		scheduler.mutex.Unlock()
+		if waiting > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Testing that handles are batch for -batchUids and under directories tagged as batch
+func TestIOClassOf(t *testing.T) {
+	saveFlags(t, &batchUids)
+	batchUids = "1001, 1002"
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.IOScheduler = NewIOScheduler(4)
+	root, _ := fs.Root()
+	rootDir := root.(*DirINode)
+	jobs := &DirINode{FileSystem: fs, Parent: rootDir, Attrs: Attrs{Name: "jobs"}}
+	output := &DirINode{FileSystem: fs, Parent: jobs, Attrs: Attrs{Name: "output"}}
+	hdfsAccessor.EXPECT().GetXAttrs("/").Return(map[string]string{}, nil).Times(1)
+	hdfsAccessor.EXPECT().GetXAttrs("/jobs").Return(map[string]string{ioClassXAttr: "batch"}, nil).Times(1)
+	hdfsAccessor.EXPECT().GetXAttrs("/jobs/output").Return(map[string]string{"user.other": "x"}, nil).Times(1)
+
+	assert.Equal(t, Batch, fs.ioClassOf(rootDir, 1002))
+	assert.Equal(t, Interactive, fs.ioClassOf(rootDir, 1000))
+	assert.Equal(t, Batch, fs.ioClassOf(output, 1000))
+	assert.Equal(t, Batch, fs.ioClassOf(output, 1000))
+}
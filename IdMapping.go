@@ -0,0 +1,406 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"logicalclocks.com/hopsfs-mount/ugcache"
+)
+
+const (
+	IdMappingNSS     = "nss"     // local accounts of the same name, from passwd and group, LDAP or SSSD through NSS
+	IdMappingFile    = "file"    // static mapping file, NSS for the names and ids it does not list
+	IdMappingNumeric = "numeric" // HDFS owners and groups which are numbers are the ids, e.g., written by NFS gateways
+	IdMappingURL     = "url"     // ids of the users and groups listed by an HTTP endpoint, NSS for the others
+	IdMappingLDAP    = "ldap"    // RFC 2307 users and groups of an LDAP directory, NSS for the others
+	// Groups of the users from the Hopsworks REST API. The users API of Hopsworks has no POSIX
+	// uids and gids, so the ids are those of NSS, e.g., from the directory Hopsworks uses
+	IdMappingHopsworks = "hopsworks"
+)
+
+// Maps the owners and groups of HDFS to local uids and gids and back, and resolves the HDFS
+// groups of the users. Used for the attributes shown by the mount, chown, the ownership of the
+// entries created through the mount, ACLs and -groupResolver=idMapping
+type IdMapper interface {
+	Uid(user string) (uint32, bool) // false if the HDFS user has no local uid
+	Gid(group string) (uint32, bool)
+	UserName(uid uint32) string // empty if the uid has no HDFS user
+	GroupName(gid uint32) string
+	Groups(user string) ([]string, error) // HDFS groups of the HDFS user
+}
+
+// Mapper selected by -idMapping
+var idMapper IdMapper = &nssIdMapper{}
+
+// Creates the mapper selected by -idMapping
+func NewIdMapper(kind string) (IdMapper, error) {
+	switch kind {
+	case IdMappingNSS:
+		return &nssIdMapper{}, nil
+	case IdMappingFile:
+		return newFileIdMapper(idMappingFile)
+	case IdMappingNumeric:
+		return &numericIdMapper{}, nil
+	case IdMappingURL:
+		if idMappingURL == "" {
+			return nil, fmt.Errorf("-idMappingURL is required by the %s id mapping", kind)
+		}
+		return newURLIdMapper(idMappingURL, idCacheTTL, WallClock{})
+	case IdMappingLDAP:
+		if ldapURL == "" || ldapBaseDN == "" {
+			return nil, fmt.Errorf("-ldapURL and -ldapBaseDN are required by the %s id mapping", kind)
+		}
+		password, err := ldapBindPassword()
+		if err != nil {
+			return nil, err
+		}
+		client := &ldapClient{URL: ldapURL, BindDN: ldapBindDN, Password: password, Timeout: 10 * time.Second}
+		return newLdapIdMapper(client, ldapBaseDN, idCacheTTL, WallClock{})
+	case IdMappingHopsworks:
+		if hopsworksGroupsURL == "" {
+			return nil, fmt.Errorf("-hopsworksGroupsURL is required by the %s id mapping", kind)
+		}
+		apiKey, err := hopsworksAPIKey()
+		if err != nil {
+			return nil, err
+		}
+		return &hopsworksIdMapper{
+			GroupsURL: hopsworksGroupsURL,
+			APIKey:    apiKey,
+			client:    &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unknown id mapping %q", kind)
+}
+
+// Returns the local uid of the HDFS user, -unmappedId if it has none
+func localUid(user string) uint32 {
+	if uid, ok := idMapper.Uid(user); ok {
+		return uid
+	}
+	return uint32(unmappedId)
+}
+
+// Returns the local gid of the HDFS group, -unmappedId if it has none
+func localGid(group string) uint32 {
+	if gid, ok := idMapper.Gid(group); ok {
+		return gid
+	}
+	return uint32(unmappedId)
+}
+
+// HDFS users and groups are the local accounts of the same name
+type nssIdMapper struct{}
+
+func (mapper *nssIdMapper) Uid(user string) (uint32, bool) {
+	uid := ugcache.LookupUId(user)
+	return uid, uid != 0 || user == "root"
+}
+
+func (mapper *nssIdMapper) Gid(group string) (uint32, bool) {
+	gid := ugcache.LookupGid(group)
+	return gid, gid != 0 || group == "root"
+}
+
+func (mapper *nssIdMapper) UserName(uid uint32) string {
+	return ugcache.LookupUserName(uid)
+}
+
+func (mapper *nssIdMapper) GroupName(gid uint32) string {
+	return ugcache.LookupGroupName(gid)
+}
+
+func (mapper *nssIdMapper) Groups(user string) ([]string, error) {
+	uid, ok := mapper.Uid(user)
+	if !ok {
+		return nil, fmt.Errorf("unable to find the uid of %s", user)
+	}
+	gids, err := userGids(uid)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(gids))
+	for _, gid := range gids {
+		if name := mapper.GroupName(gid); name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
+
+// Maps HDFS users and groups with a static file. Each line of the file has the format
+// "user <name> <uid>" or "group <name> <gid>". Empty lines and lines starting with # are
+// ignored. Names and ids which are not in the file are those of the local accounts
+type fileIdMapper struct {
+	nssIdMapper
+	uids       map[string]uint32
+	gids       map[string]uint32
+	userNames  map[uint32]string
+	groupNames map[uint32]string
+}
+
+func newFileIdMapper(mappingFile string) (*fileIdMapper, error) {
+	if mappingFile == "" {
+		return nil, fmt.Errorf("-idMappingFile is required by the %s id mapping", IdMappingFile)
+	}
+	f, err := os.Open(mappingFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mapper := newIdTable()
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || (fields[0] != "user" && fields[0] != "group") {
+			return nil, fmt.Errorf("%s:%d: expected \"user <name> <uid>\" or \"group <name> <gid>\"", mappingFile, lineNo)
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid id %q", mappingFile, lineNo, fields[2])
+		}
+		if fields[0] == "user" {
+			mapper.uids[fields[1]] = uint32(id)
+			mapper.userNames[uint32(id)] = fields[1]
+		} else {
+			mapper.gids[fields[1]] = uint32(id)
+			mapper.groupNames[uint32(id)] = fields[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mapper, nil
+}
+
+func newIdTable() *fileIdMapper {
+	return &fileIdMapper{uids: map[string]uint32{}, gids: map[string]uint32{},
+		userNames: map[uint32]string{}, groupNames: map[uint32]string{}}
+}
+
+func (mapper *fileIdMapper) Uid(user string) (uint32, bool) {
+	if uid, ok := mapper.uids[user]; ok {
+		return uid, true
+	}
+	return mapper.nssIdMapper.Uid(user)
+}
+
+func (mapper *fileIdMapper) Gid(group string) (uint32, bool) {
+	if gid, ok := mapper.gids[group]; ok {
+		return gid, true
+	}
+	return mapper.nssIdMapper.Gid(group)
+}
+
+func (mapper *fileIdMapper) UserName(uid uint32) string {
+	if name, ok := mapper.userNames[uid]; ok {
+		return name
+	}
+	return mapper.nssIdMapper.UserName(uid)
+}
+
+func (mapper *fileIdMapper) GroupName(gid uint32) string {
+	if name, ok := mapper.groupNames[gid]; ok {
+		return name
+	}
+	return mapper.nssIdMapper.GroupName(gid)
+}
+
+// HDFS users and groups named by a number are that uid and gid, the others are the local
+// accounts of the same name. Ids without a local account are passed to HDFS as numbers
+type numericIdMapper struct {
+	nssIdMapper
+}
+
+func (mapper *numericIdMapper) Uid(user string) (uint32, bool) {
+	if uid, err := strconv.ParseUint(user, 10, 32); err == nil {
+		return uint32(uid), true
+	}
+	return mapper.nssIdMapper.Uid(user)
+}
+
+func (mapper *numericIdMapper) Gid(group string) (uint32, bool) {
+	if gid, err := strconv.ParseUint(group, 10, 32); err == nil {
+		return uint32(gid), true
+	}
+	return mapper.nssIdMapper.Gid(group)
+}
+
+func (mapper *numericIdMapper) UserName(uid uint32) string {
+	if name := mapper.nssIdMapper.UserName(uid); name != "" {
+		return name
+	}
+	return strconv.FormatUint(uint64(uid), 10)
+}
+
+func (mapper *numericIdMapper) GroupName(gid uint32) string {
+	if name := mapper.nssIdMapper.GroupName(gid); name != "" {
+		return name
+	}
+	return strconv.FormatUint(uint64(gid), 10)
+}
+
+// Endpoint of the url id mapping and how long the ids it returned are used
+var idMappingURL string
+var idCacheTTL time.Duration
+
+// Ids returned by -idMappingURL
+type urlIds struct {
+	Users  map[string]uint32 `json:"users"`
+	Groups map[string]uint32 `json:"groups"`
+}
+
+// Maps the users and groups with the ids served by an HTTP endpoint, e.g., a file exported from
+// the user directory by a cron job and served by a web server, as a JSON object of the form
+// {"users": {"<name>": <uid>}, "groups": {"<name>": <gid>}}. All the ids are fetched at once, at mount time and again in the background once they are -idCacheTTL old, the previous
+// ids are used until the new ones are fetched. Names and ids which are not listed are those of
+// the local accounts
+type urlIdMapper struct {
+	URL    string
+	TTL    time.Duration
+	Clock  Clock
+	client *http.Client
+
+	mutex      sync.Mutex
+	ids        *fileIdMapper
+	fetchedAt  time.Duration
+	refreshing bool
+}
+
+func newURLIdMapper(url string, ttl time.Duration, clock Clock) (*urlIdMapper, error) {
+	mapper := &urlIdMapper{URL: url, TTL: ttl, Clock: clock, client: &http.Client{Timeout: 10 * time.Second}}
+	ids, err := mapper.fetch()
+	if err != nil {
+		return nil, err
+	}
+	mapper.ids, mapper.fetchedAt = ids, clock.Monotonic()
+	return mapper, nil
+}
+
+// Fetches the ids from the endpoint
+func (mapper *urlIdMapper) fetch() (*fileIdMapper, error) {
+	resp, err := mapper.client.Get(mapper.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching the ids from %s failed with status %s", mapper.URL, resp.Status)
+	}
+	var fetched urlIds
+	if err := json.NewDecoder(resp.Body).Decode(&fetched); err != nil {
+		return nil, err
+	}
+	ids := newIdTable()
+	for name, uid := range fetched.Users {
+		ids.uids[name], ids.userNames[uid] = uid, name
+	}
+	for name, gid := range fetched.Groups {
+		ids.gids[name], ids.groupNames[gid] = gid, name
+	}
+	return ids, nil
+}
+
+// Returns the current ids, refreshing them in the background if they are too old
+func (mapper *urlIdMapper) current() *fileIdMapper {
+	mapper.mutex.Lock()
+	defer mapper.mutex.Unlock()
+	if !mapper.refreshing && mapper.Clock.Monotonic() >= mapper.fetchedAt+mapper.TTL {
+		mapper.refreshing = true
+		go mapper.refresh()
+	}
+	return mapper.ids
+}
+
+func (mapper *urlIdMapper) refresh() {
+	ids, err := mapper.fetch()
+	if err != nil {
+		logwarn(fmt.Sprintf("Failed to refresh the ids of %s, using the previous ones", mapper.URL), Fields{Error: err})
+	}
+	mapper.mutex.Lock()
+	defer mapper.mutex.Unlock()
+	if err == nil {
+		mapper.ids = ids
+	}
+	mapper.fetchedAt, mapper.refreshing = mapper.Clock.Monotonic(), false
+}
+
+func (mapper *urlIdMapper) Uid(user string) (uint32, bool) {
+	return mapper.current().Uid(user)
+}
+
+func (mapper *urlIdMapper) Gid(group string) (uint32, bool) {
+	return mapper.current().Gid(group)
+}
+
+func (mapper *urlIdMapper) UserName(uid uint32) string {
+	return mapper.current().UserName(uid)
+}
+
+func (mapper *urlIdMapper) GroupName(gid uint32) string {
+	return mapper.current().GroupName(gid)
+}
+
+func (mapper *urlIdMapper) Groups(user string) ([]string, error) {
+	return mapper.current().Groups(user)
+}
+
+// Returns the API key in -hopsworksAPIKeyFile, empty if it is not set
+func hopsworksAPIKey() (string, error) {
+	if hopsworksAPIKeyFile == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(hopsworksAPIKeyFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Fetches the HDFS groups of the users from the Hopsworks REST API. The URL may contain the
+// {user} placeholder which is replaced with the user name. The response is expected to be a
+// JSON array of group names. The ids are those of the local accounts
+type hopsworksIdMapper struct {
+	nssIdMapper
+	GroupsURL string
+	APIKey    string
+	client    *http.Client
+}
+
+func (mapper *hopsworksIdMapper) Groups(user string) ([]string, error) {
+	req, err := http.NewRequest("GET", strings.Replace(mapper.GroupsURL, "{user}", url.PathEscape(user), -1), nil)
+	if err != nil {
+		return nil, err
+	}
+	if mapper.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+mapper.APIKey)
+	}
+	resp, err := mapper.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching groups of %s failed with status %s", user, resp.Status)
+	}
+	var groups []string
+	if err := json.NewDecoder(resp.Body).Decode(&groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing the static id mapping file, falling back to the local accounts
+func TestFileIdMapper(t *testing.T) {
+	f, _ := ioutil.TempFile("", "ids")
+	defer os.Remove(f.Name())
+	f.WriteString("# hdfs names\nuser alice 5001\n\ngroup analysts 6001\n")
+	f.Close()
+
+	mapper, err := newFileIdMapper(f.Name())
+	assert.Nil(t, err)
+	uid, ok := mapper.Uid("alice")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(5001), uid)
+	assert.Equal(t, "analysts", mapper.GroupName(6001))
+	uid, ok = mapper.Uid("root")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0), uid)
+	_, ok = mapper.Gid("no-such-group")
+	assert.False(t, ok)
+
+	ioutil.WriteFile(f.Name(), []byte("user alice\n"), 0600)
+	_, err = newFileIdMapper(f.Name())
+	assert.EqualError(t, err, f.Name()+`:1: expected "user <name> <uid>" or "group <name> <gid>"`)
+	ioutil.WriteFile(f.Name(), []byte("group analysts x\n"), 0600)
+	_, err = newFileIdMapper(f.Name())
+	assert.EqualError(t, err, f.Name()+`:1: invalid id "x"`)
+}
+
+func TestNumericIdMapper(t *testing.T) {
+	mapper := &numericIdMapper{}
+	gid, ok := mapper.Gid("4242")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(4242), gid)
+	assert.Equal(t, "4242424", mapper.UserName(4242424))
+	assert.Equal(t, "root", mapper.UserName(0))
+}
+
+// Testing that chown and the attributes go through the id mapping
+func TestIdMappingChown(t *testing.T) {
+	saveFlags(t, &idMapper)
+	f, _ := ioutil.TempFile("", "ids")
+	defer os.Remove(f.Name())
+	f.WriteString("user alice 5001\ngroup analysts 6001\n")
+	f.Close()
+	saveFlags(t, &idMapping, &idMappingFile)
+	idMapping, idMappingFile = IdMappingFile, f.Name()
+	var err error
+	idMapper, err = NewIdMapper(idMapping)
+	assert.Nil(t, err)
+
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	hdfsAccessor.EXPECT().Chown("/data", "alice", "analysts").Return(nil)
+	attrs := Attrs{Name: "data"}
+	assert.Nil(t, ChownOp(&attrs, hdfsAccessor, "/data", 5001, 6001))
+	assert.Equal(t, "analysts", attrs.Group)
+	assert.Equal(t, uint32(6001), localGid("analysts"))
+	assert.Equal(t, uint32(unmappedId), localUid("no-such-user"))
+}
+
+// Testing that the ids of the endpoint are fetched at once and refreshed in the background
+func TestURLIdMapper(t *testing.T) {
+	fetches := make(chan struct{}, 2)
+	ids := `{"users": {"alice": 5001}, "groups": {"analysts": 6001}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(ids))
+		fetches <- struct{}{}
+	}))
+	defer server.Close()
+	clock := &MockClock{}
+	mapper, err := newURLIdMapper(server.URL, time.Minute, clock)
+	assert.Nil(t, err)
+	<-fetches
+
+	uid, ok := mapper.Uid("alice")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(5001), uid)
+	assert.Equal(t, "analysts", mapper.GroupName(6001))
+	_, ok = mapper.Gid("no-such-group")
+	assert.False(t, ok)
+
+	ids = `{"users": {"bob": 5002}}`
+	clock.NotifyTimeElapsed(time.Minute)
+	assert.Equal(t, "alice", mapper.UserName(5001), "the previous ids until the refresh")
+	<-fetches
+	assert.Eventually(t, func() bool { return mapper.UserName(5002) == "bob" }, time.Second, time.Millisecond)
+	assert.Equal(t, "", mapper.UserName(5001))
+}
+
+// Testing that -groupResolver=idMapping resolves the groups of the caller with the Hopsworks REST
+// API of -idMapping=hopsworks, and that the ids of the hopsworks id mapping are those of NSS
+func TestHopsworksIdMapper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users/root/groups" || r.Header.Get("Authorization") != "ApiKey secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`["project1", "project1__meb10000"]`))
+	}))
+	defer server.Close()
+	saveFlags(t, &idMapper)
+	mapper := &hopsworksIdMapper{GroupsURL: server.URL + "/users/{user}/groups", APIKey: "secret", client: server.Client()}
+	idMapper = mapper
+	uid, ok := mapper.Uid("root")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0), uid)
+
+	groups, err := (&idMappingGroupResolver{}).Groups(fuse.Header{Uid: 0})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"project1", "project1__meb10000"}, groups)
+	mapper.APIKey = "wrong"
+	_, err = (&idMappingGroupResolver{}).Groups(fuse.Header{Uid: 0})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,140 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+)
+
+// The mount is shared by all local users (allow_other), and by default every RPC is issued as
+// the HDFS user of the mount, so HDFS sees a single user and owns everything created through
+// the mount by it, chown aside. With -impersonate the creates, removes, renames, attribute
+// changes, opens and uploads are issued as the HDFS user named like the local user of the
+// calling process, on a connection of that user opened on first use, so that HDFS checks the
+// permissions of, and records the ownership by, the real user. The HDFS client has no proxy
+// user support, so the namenode must take the user of the connection as given, i.e., use
+// simple authentication. Lookups, listings and the shared reader of a file opened by several
+// users go through the caches of the mount and are issued as the mount's user
+var impersonate bool
+
+// Default of UserConnectors.MaxConnectors
+const defaultMaxUserConnectors = 256
+
+// Connections of the users the mount acts on behalf of, with -impersonate. At most MaxConnectors
+// are kept, the least recently used one is closed past it, and the handles still holding it
+// reconnect on their next RPC
+// Concurrency: thread safe
+type UserConnectors struct {
+	NewConnector  func(user string) (HdfsAccessor, error) // opens the connection of a user
+	UserName      func(uid uint32) string                 // name of the local user of a uid, empty if unknown. idMapper's if nil
+	MaxConnectors int                                     // unbounded if 0
+	mutex         sync.Mutex
+	connectors    map[string]*list.Element // elements of lru
+	lru           *list.List               // *userConnector, the most recently used first
+}
+
+type userConnector struct {
+	user      string
+	connector HdfsAccessor
+}
+
+// Creates an instance of UserConnectors
+func NewUserConnectors(newConnector func(user string) (HdfsAccessor, error)) *UserConnectors {
+	return &UserConnectors{NewConnector: newConnector, MaxConnectors: defaultMaxUserConnectors,
+		connectors: map[string]*list.Element{}, lru: list.New()}
+}
+
+// Returns the name of the local user of the uid, empty if unknown
+func (uc *UserConnectors) userName(uid uint32) string {
+	if uc.UserName != nil {
+		return uc.UserName(uid)
+	}
+	return idMapper.UserName(uid)
+}
+
+// Returns the connection of the user, opening it on first use
+func (uc *UserConnectors) Get(user string) (HdfsAccessor, error) {
+	uc.mutex.Lock()
+	if e, ok := uc.connectors[user]; ok {
+		uc.lru.MoveToFront(e)
+		uc.mutex.Unlock()
+		return e.Value.(*userConnector).connector, nil
+	}
+	uc.mutex.Unlock()
+
+	// connecting without holding the lock, the RPCs of the other users go on meanwhile
+	connector, err := uc.NewConnector(user)
+	if err != nil {
+		return nil, err
+	}
+	var closed []HdfsAccessor
+	uc.mutex.Lock()
+	if e, ok := uc.connectors[user]; ok {
+		// connected by a concurrent call
+		uc.lru.MoveToFront(e)
+		closed = append(closed, connector)
+		connector = e.Value.(*userConnector).connector
+	} else {
+		uc.connectors[user] = uc.lru.PushFront(&userConnector{user: user, connector: connector})
+		for uc.MaxConnectors > 0 && uc.lru.Len() > uc.MaxConnectors {
+			oldest := uc.lru.Remove(uc.lru.Back()).(*userConnector)
+			delete(uc.connectors, oldest.user)
+			closed = append(closed, oldest.connector)
+		}
+	}
+	uc.mutex.Unlock()
+	for _, c := range closed {
+		c.Close()
+	}
+	return connector, nil
+}
+
+// Closes the connections of all users
+func (uc *UserConnectors) Close() error {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+	for e := uc.lru.Front(); e != nil; e = e.Next() {
+		e.Value.(*userConnector).connector.Close()
+	}
+	uc.connectors = map[string]*list.Element{}
+	uc.lru.Init()
+	return nil
+}
+
+// Returns the connection the RPCs of a caller are issued on: with -impersonate that of the
+// caller's user, otherwise, and for root and the user of the mount, the mount's own. Fails
+// with EACCES for local users without a name, who have no HDFS user to act as
+func (filesystem *FileSystem) connectorFor(caller fuse.Header) (HdfsAccessor, error) {
+	if filesystem.UserConnectors == nil || caller.Uid == 0 || caller.Uid == hadoopUserID {
+		return filesystem.getDFSConnector(), nil
+	}
+	user := filesystem.UserConnectors.userName(caller.Uid)
+	if user == "" {
+		logwarn("Unable to find the user of the caller to act as, denying", Fields{UID: caller.Uid, PID: caller.Pid})
+		return nil, syscall.EACCES
+	}
+	connector, err := filesystem.UserConnectors.Get(user)
+	if err != nil {
+		logerror(fmt.Sprintf("Unable to connect as %s", user), Fields{UID: caller.Uid, PID: caller.Pid, Error: err})
+		return nil, syscall.EIO
+	}
+	return connector, nil
+}
+
+// Returns true if the RPCs of the connection are issued as the caller, who then owns what it creates
+func (filesystem *FileSystem) impersonated(hdfsAccessor HdfsAccessor) bool {
+	if filesystem.UserConnectors == nil {
+		return false
+	}
+	for _, connector := range filesystem.HdfsAccessors {
+		if connector == hdfsAccessor {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Local users of the impersonation tests
+func testUserNames(uid uint32) string {
+	return map[uint32]string{1000: "alice", 1001: "bob"}[uid]
+}
+
+// Testing that the mutations of a user are issued on a connection of its own, opened once, and not chowned afterwards
+func TestImpersonatedMkdir(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mountAccessor := NewMockHdfsAccessor(mockCtrl)
+	userAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{mountAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	var users []string
+	fs.UserConnectors = NewUserConnectors(func(user string) (HdfsAccessor, error) {
+		users = append(users, user)
+		return userAccessor, nil
+	})
+	fs.UserConnectors.UserName = testUserNames
+	root, _ := fs.Root()
+	mountAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+
+	caller := fuse.Header{Uid: 1000, Gid: 1000}
+	userAccessor.EXPECT().Mkdir("/foo", os.FileMode(0755)|os.ModeDir).Return(nil)
+	_, err := root.(*DirINode).Mkdir(nil, &fuse.MkdirRequest{Header: caller, Name: "foo", Mode: os.FileMode(0755) | os.ModeDir})
+	assert.Nil(t, err)
+	userAccessor.EXPECT().Remove("/bar").Return(nil)
+	assert.Nil(t, root.(*DirINode).Remove(nil, &fuse.RemoveRequest{Header: caller, Name: "bar"}))
+	assert.Equal(t, []string{"alice"}, users)
+
+	// root acts as the mount, and chowns what it creates for the caller
+	gomock.InOrder(
+		mountAccessor.EXPECT().Mkdir("/baz", os.FileMode(0755)|os.ModeDir).Return(nil),
+		mountAccessor.EXPECT().Chown("/baz", "root", "root").Return(nil),
+	)
+	_, err = root.(*DirINode).Mkdir(nil, &fuse.MkdirRequest{Name: "baz", Mode: os.FileMode(0755) | os.ModeDir})
+	assert.Nil(t, err)
+}
+
+func TestConnectorFor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mountAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{mountAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+
+	connector, err := fs.connectorFor(fuse.Header{Uid: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, mountAccessor, connector)
+	assert.False(t, fs.impersonated(connector))
+
+	fs.UserConnectors = NewUserConnectors(func(user string) (HdfsAccessor, error) {
+		return nil, errors.New("connection refused")
+	})
+	fs.UserConnectors.UserName = testUserNames
+	_, err = fs.connectorFor(fuse.Header{Uid: 1000})
+	assert.Equal(t, syscall.EIO, err)
+	// nobody to act as
+	_, err = fs.connectorFor(fuse.Header{Uid: 4242424})
+	assert.Equal(t, syscall.EACCES, err)
+}
+
+// Testing that the least recently used connection is closed past MaxConnectors, and opened again when needed
+func TestUserConnectorsLRU(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	opened := map[string]int{}
+	accessors := map[string]*MockHdfsAccessor{}
+	uc := NewUserConnectors(func(user string) (HdfsAccessor, error) {
+		opened[user]++
+		accessors[user] = NewMockHdfsAccessor(mockCtrl)
+		return accessors[user], nil
+	})
+	uc.MaxConnectors = 2
+
+	alice, err := uc.Get("alice")
+	assert.Nil(t, err)
+	_, err = uc.Get("bob")
+	assert.Nil(t, err)
+	connector, err := uc.Get("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, alice, connector)
+	bob := accessors["bob"]
+	bob.EXPECT().Close().Return(nil)
+	_, err = uc.Get("carol")
+	assert.Nil(t, err)
+	_, err = uc.Get("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"alice": 1, "bob": 1, "carol": 1}, opened)
+
+	accessors["carol"].EXPECT().Close().Return(nil)
+	_, err = uc.Get("bob")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, opened["bob"])
+
+	accessors["alice"].EXPECT().Close().Return(nil)
+	accessors["bob"].EXPECT().Close().Return(nil)
+	assert.Nil(t, uc.Close())
+}
@@ -0,0 +1,328 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// Records every call to the backend as an "rpc." operation in the metrics, e.g., rpc.stat,
+// with its latency, the bytes read or written, and the class of the error.
+// It sits below FaultTolerantHdfsAccessor, so each attempt is recorded separately and the
+// retries are counted by the operation recorded by FaultTolerantHdfsAccessor, e.g., stat.
+// Comparing rpc.read with read tells whether slow reads are slow in the cluster or in the mount
+type InstrumentedHdfsAccessor struct {
+	Impl  HdfsAccessor
+	Clock Clock
+}
+
+var _ HdfsAccessor = (*InstrumentedHdfsAccessor)(nil) // ensure InstrumentedHdfsAccessor implements HdfsAccessor
+
+// Creates an instance of InstrumentedHdfsAccessor
+func NewInstrumentedHdfsAccessor(impl HdfsAccessor, clock Clock) *InstrumentedHdfsAccessor {
+	return &InstrumentedHdfsAccessor{Impl: impl, Clock: clock}
+}
+
+// Returns the name under which calls to the backend of an operation are recorded
+func rpcOp(operation string) string {
+	return "rpc." + operation
+}
+
+func (ia *InstrumentedHdfsAccessor) record(operation string, start time.Time, bytes int64, err error) {
+	metrics.Record(rpcOp(operation), ia.Clock.Now().Sub(start), bytes, 0, false, err)
+	backendHealth.observe(ia.Clock.Now(), err)
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (ia *InstrumentedHdfsAccessor) EnsureConnected() error {
+	start := ia.Clock.Now()
+	err := ia.Impl.EnsureConnected()
+	ia.record(Connect, start, 0, err)
+	return err
+}
+
+// Opens HDFS file for reading
+func (ia *InstrumentedHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	start := ia.Clock.Now()
+	reader, err := ia.Impl.OpenRead(path)
+	ia.record(Open, start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedReader{ReadSeekCloser: reader, clock: ia.Clock}, nil
+}
+
+// Opens HDFS file for writing
+func (ia *InstrumentedHdfsAccessor) CreateFile(path string, mode os.FileMode, overwrite bool) (HdfsWriter, error) {
+	start := ia.Clock.Now()
+	writer, err := ia.Impl.CreateFile(path, mode, overwrite)
+	ia.record(Create, start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedWriter{HdfsWriter: writer, clock: ia.Clock}, nil
+}
+
+// Opens HDFS file for appending
+func (ia *InstrumentedHdfsAccessor) Append(path string) (HdfsWriter, error) {
+	start := ia.Clock.Now()
+	writer, err := ia.Impl.Append(path)
+	ia.record(Append, start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedWriter{HdfsWriter: writer, clock: ia.Clock}, nil
+}
+
+// Enumerates HDFS directory
+func (ia *InstrumentedHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	start := ia.Clock.Now()
+	result, err := ia.Impl.ReadDir(path)
+	ia.record(ReadDir, start, 0, err)
+	return result, err
+}
+
+// Retrieves file/directory attributes
+func (ia *InstrumentedHdfsAccessor) Stat(path string) (Attrs, error) {
+	start := ia.Clock.Now()
+	result, err := ia.Impl.Stat(path)
+	ia.record(Stat, start, 0, err)
+	return result, err
+}
+
+// Retrieves HDFS usage
+func (ia *InstrumentedHdfsAccessor) StatFs() (FsInfo, error) {
+	start := ia.Clock.Now()
+	result, err := ia.Impl.StatFs()
+	ia.record(StatFS, start, 0, err)
+	return result, err
+}
+
+// Creates a directory
+func (ia *InstrumentedHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
+	start := ia.Clock.Now()
+	err := ia.Impl.Mkdir(path, mode)
+	ia.record(Mkdir, start, 0, err)
+	return err
+}
+
+// Removes a file or directory
+func (ia *InstrumentedHdfsAccessor) Remove(path string) error {
+	start := ia.Clock.Now()
+	err := ia.Impl.Remove(path)
+	ia.record(Remove, start, 0, err)
+	return err
+}
+
+// Removes a file or directory recursively
+func (ia *InstrumentedHdfsAccessor) RemoveAll(path string) error {
+	start := ia.Clock.Now()
+	err := ia.Impl.RemoveAll(path)
+	ia.record(RemoveAll, start, 0, err)
+	return err
+}
+
+// Renames a file or directory
+func (ia *InstrumentedHdfsAccessor) Rename(oldPath string, newPath string) error {
+	start := ia.Clock.Now()
+	err := ia.Impl.Rename(oldPath, newPath)
+	ia.record(Rename, start, 0, err)
+	return err
+}
+
+// Changes the owner and group of the file
+func (ia *InstrumentedHdfsAccessor) Chown(path string, owner, group string) error {
+	start := ia.Clock.Now()
+	err := ia.Impl.Chown(path, owner, group)
+	ia.record(Chown, start, 0, err)
+	return err
+}
+
+// Changes the mode of the file
+func (ia *InstrumentedHdfsAccessor) Chmod(path string, mode os.FileMode) error {
+	start := ia.Clock.Now()
+	err := ia.Impl.Chmod(path, mode)
+	ia.record(Chmod, start, 0, err)
+	return err
+}
+
+// Changes the modification time of the file
+func (ia *InstrumentedHdfsAccessor) Chtimes(path string, mtime time.Time) error {
+	start := ia.Clock.Now()
+	err := ia.Impl.Chtimes(path, mtime)
+	ia.record(Chtimes, start, 0, err)
+	return err
+}
+
+// Retrieves the HDFS checksum of the file
+func (ia *InstrumentedHdfsAccessor) Checksum(path string) (FileChecksum, error) {
+	start := ia.Clock.Now()
+	result, err := ia.Impl.Checksum(path)
+	ia.record(Checksum, start, 0, err)
+	return result, err
+}
+
+// Retrieves the extended attributes of the file
+func (ia *InstrumentedHdfsAccessor) GetXAttrs(path string) (map[string]string, error) {
+	start := ia.Clock.Now()
+	result, err := ia.Impl.GetXAttrs(path)
+	ia.record(GetXAttrs, start, 0, err)
+	return result, err
+}
+
+// Creates or replaces an extended attribute of the file
+func (ia *InstrumentedHdfsAccessor) SetXAttr(path, name, value string) error {
+	start := ia.Clock.Now()
+	err := ia.Impl.SetXAttr(path, name, value)
+	ia.record(SetXAttrOp, start, 0, err)
+	return err
+}
+
+// Retrieves the ACL entries of the file
+func (ia *InstrumentedHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	start := ia.Clock.Now()
+	result, err := ia.Impl.GetAcl(path)
+	ia.record(GetAclOp, start, 0, err)
+	return result, err
+}
+
+// Replaces the ACL of the file
+func (ia *InstrumentedHdfsAccessor) SetAcl(path string, entries []AclEntry) error {
+	start := ia.Clock.Now()
+	err := ia.Impl.SetAcl(path, entries)
+	ia.record(SetAclOp, start, 0, err)
+	return err
+}
+
+// Sets the storage policy of the file
+func (ia *InstrumentedHdfsAccessor) SetStoragePolicy(path, policy string) error {
+	start := ia.Clock.Now()
+	err := ia.Impl.SetStoragePolicy(path, policy)
+	ia.record(StoragePolicyOp, start, 0, err)
+	return err
+}
+
+// Sets the erasure coding policy of the directory
+func (ia *InstrumentedHdfsAccessor) SetErasureCodingPolicy(path, policy string) error {
+	start := ia.Clock.Now()
+	err := ia.Impl.SetErasureCodingPolicy(path, policy)
+	ia.record(ErasureCodingOp, start, 0, err)
+	return err
+}
+
+// Retrieves the totals of a directory tree
+func (ia *InstrumentedHdfsAccessor) GetContentSummary(path string) (ContentSummary, error) {
+	start := ia.Clock.Now()
+	result, err := ia.Impl.GetContentSummary(path)
+	ia.record(GetContentSummary, start, 0, err)
+	return result, err
+}
+
+// Retrieves the configuration of the namenode
+func (ia *InstrumentedHdfsAccessor) ServerDefaults() (ServerDefaults, error) {
+	start := ia.Clock.Now()
+	result, err := ia.Impl.ServerDefaults()
+	ia.record(ServerDefaultsOp, start, 0, err)
+	return result, err
+}
+
+// Truncates the file
+func (ia *InstrumentedHdfsAccessor) Truncate(path string, size int64) (bool, error) {
+	start := ia.Clock.Now()
+	done, err := ia.Impl.Truncate(path, size)
+	ia.record(Truncate, start, 0, err)
+	return done, err
+}
+
+// Creates a snapshot of a snapshottable directory
+func (ia *InstrumentedHdfsAccessor) CreateSnapshot(path, name string) (string, error) {
+	start := ia.Clock.Now()
+	result, err := ia.Impl.CreateSnapshot(path, name)
+	ia.record(CreateSnapshot, start, 0, err)
+	return result, err
+}
+
+// Close current meta connection if needed
+func (ia *InstrumentedHdfsAccessor) Close() error {
+	return ia.Impl.Close()
+}
+
+// Records every read from a datanode as rpc.read
+type instrumentedReader struct {
+	ReadSeekCloser
+	clock Clock
+}
+
+func (r *instrumentedReader) Read(buffer []byte) (int, error) {
+	start := r.clock.Now()
+	n, err := r.ReadSeekCloser.Read(buffer)
+	recordErr := err
+	if err == io.EOF {
+		// reaching the end of the file is not a failure of the backend
+		recordErr = nil
+	}
+	metrics.Record(rpcOp(Read), r.clock.Now().Sub(start), int64(n), 0, false, recordErr)
+	return n, err
+}
+
+// Returns the version of the file of the wrapped reader, for the caches keyed by version
+func (r *instrumentedReader) Version() (FileVersion, error) {
+	if v, ok := r.ReadSeekCloser.(VersionedReader); ok {
+		return v.Version()
+	}
+	return FileVersion{}, errors.New("Version is not known")
+}
+
+// Records every write to the datanodes as rpc.write, and flushes as rpc.flush
+type instrumentedWriter struct {
+	HdfsWriter
+	clock Clock
+}
+
+func (w *instrumentedWriter) Write(buffer []byte) (int, error) {
+	start := w.clock.Now()
+	n, err := w.HdfsWriter.Write(buffer)
+	metrics.Record(rpcOp(Write), w.clock.Now().Sub(start), int64(n), 0, false, err)
+	return n, err
+}
+
+func (w *instrumentedWriter) Flush() error {
+	start := w.clock.Now()
+	err := w.HdfsWriter.Flush()
+	metrics.Record(rpcOp(Flush), w.clock.Now().Sub(start), 0, 0, false, err)
+	return err
+}
+
+func (w *instrumentedWriter) Close() error {
+	start := w.clock.Now()
+	err := w.HdfsWriter.Close()
+	metrics.Record(rpcOp(Close), w.clock.Now().Sub(start), 0, 0, false, err)
+	return err
+}
+
+// Returns the class of an error for the metrics: the name of the errno, e.g., ENOENT,
+// "timeout" and "network" for connection problems, or "other"
+func errorClass(err error) string {
+	switch e := err.(type) {
+	case fuse.Errno:
+		return e.ErrnoName()
+	case syscall.Errno:
+		return fuse.Errno(e).ErrnoName()
+	case net.Error:
+		if e.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	if os.IsTimeout(err) {
+		return "timeout"
+	}
+	return "other"
+}
@@ -0,0 +1,31 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the readers of the accessor chain of a mount know the version of their file,
+// which the block, memory and footer caches, readahead and open coalescing depend on
+func TestAccessorChainReaderVersion(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	clock := WallClock{}
+	reader := versionedPseudoRandomReader{&MockReadSeekCloserWithPseudoRandomContent{FileSize: 10, ReaderStats: &ReaderStats{}}}
+	hdfsAccessor.EXPECT().OpenRead("/a").Return(reader, nil)
+
+	// as built by main
+	pool := NewConnectionPool([]HdfsAccessor{NewInstrumentedHdfsAccessor(hdfsAccessor, clock)}, clock)
+	accessor := NewFaultTolerantHdfsAccessor(pool, NewDefaultRetryPolicy(clock))
+	opened, err := accessor.OpenRead("/a")
+	assert.Nil(t, err)
+	v, ok := opened.(VersionedReader)
+	assert.True(t, ok)
+	version, err := v.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, FileVersion{FileId: 7, Mtime: 1, Size: 10}, version)
+}
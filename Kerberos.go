@@ -0,0 +1,268 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	krb "github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+)
+
+// With -kerberos the connections with the namenodes are authenticated with SASL/Kerberos, as
+// the principal of -kerberosKeytab, or with the TGT of the ticket cache which kinit, k5start
+// or sssd maintain. Keytab logins are renewed by logging in again every -kerberosRelogin. A
+// ticket cache is read again once it changes on disk and the connections are renewed, like
+// the client certificate with -tls
+var kerberos bool
+var kerberosPrincipal string
+var kerberosKeytab string
+var kerberosCCache string
+var krb5Conf string
+var kerberosServicePrincipal string
+var kerberosRelogin time.Duration
+
+// Kerberos credentials shared by all connections with the namenodes, nil without -kerberos
+var kerberosLogin *KerberosLogin
+
+// Logs in with a keytab or a ticket cache and keeps the login fresh
+type KerberosLogin struct {
+	Principal        string        // user@REALM, for keytab logins
+	Keytab           string        // keytab of the principal, empty for ticket cache logins
+	CCache           string        // ticket cache, for logins without keytab
+	ServicePrincipal string        // SPN of the namenodes, e.g., nn/_HOST
+	Margin           time.Duration // warn when the TGT of the ticket cache expires within this time
+	Interval         time.Duration // how often the login is checked
+	Relogin          time.Duration // how often a keytab login is renewed
+	Clock            Clock         // interface to get wall clock time
+	Reconnecters     []Reconnecter // reconnected when the ticket cache is renewed
+	config           *config.Config
+	mutex            sync.Mutex
+	client           *krb.Client
+	loggedIn         time.Time // when the keytab was logged in with, or mtime of the ticket cache read
+	tgtExpiry        time.Time // end time of the TGT of the ticket cache, zero for keytab logins
+	done             chan struct{}
+}
+
+// Returns the ticket cache of the user, as found by kinit
+func defaultKerberosCCache() string {
+	if name := os.Getenv("KRB5CCNAME"); name != "" {
+		return strings.TrimPrefix(name, "FILE:")
+	}
+	return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+}
+
+// Returns the krb5.conf of the host
+func defaultKrb5Conf() string {
+	if path := os.Getenv("KRB5_CONFIG"); path != "" {
+		return path
+	}
+	return "/etc/krb5.conf"
+}
+
+// Splits a user@REALM principal
+func splitPrincipal(principal string) (string, string, error) {
+	at := strings.LastIndex(principal, "@")
+	if at <= 0 || at == len(principal)-1 {
+		return "", "", fmt.Errorf("principal %q is not of the form user@REALM", principal)
+	}
+	return principal[:at], principal[at+1:], nil
+}
+
+// Returns the HDFS user of the principal name, its first component as with the default auth_to_local rule
+func principalShortName(name string) string {
+	if slash := strings.Index(name, "/"); slash >= 0 {
+		return name[:slash]
+	}
+	return name
+}
+
+// Logs in with the keytab, or with the ticket cache if keytab is empty
+func NewKerberosLogin(principal, keytabFile, ccache, krb5ConfFile, servicePrincipal string, margin time.Duration, clock Clock) (*KerberosLogin, error) {
+	cfg, err := config.Load(krb5ConfFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %v", krb5ConfFile, err)
+	}
+	login := &KerberosLogin{
+		Principal:        principal,
+		Keytab:           keytabFile,
+		CCache:           ccache,
+		ServicePrincipal: servicePrincipal,
+		Margin:           margin,
+		Interval:         time.Minute,
+		Relogin:          kerberosRelogin,
+		Clock:            clock,
+		config:           cfg,
+		done:             make(chan struct{}),
+	}
+	if err := login.login(); err != nil {
+		return nil, err
+	}
+	return login, nil
+}
+
+// Logs in again, keeps the current login on failure
+func (login *KerberosLogin) login() error {
+	var client *krb.Client
+	var loggedIn, tgtExpiry time.Time
+	if login.Keytab != "" {
+		user, realm, err := splitPrincipal(login.Principal)
+		if err != nil {
+			return err
+		}
+		// logging in again replaces the session of the client, together with its renewal
+		if client = login.Client(); client == nil {
+			kt, err := keytab.Load(login.Keytab)
+			if err != nil {
+				return fmt.Errorf("failed to load keytab %s: %v", login.Keytab, err)
+			}
+			client = krb.NewWithKeytab(user, realm, kt, login.config, krb.DisablePAFXFAST(true))
+		}
+		if err := client.Login(); err != nil {
+			return fmt.Errorf("failed to log in as %s: %v", login.Principal, err)
+		}
+		loggedIn = login.Clock.Now()
+	} else {
+		info, err := os.Stat(login.CCache)
+		if err != nil {
+			return fmt.Errorf("no ticket cache, run kinit. Error: %v", err)
+		}
+		ccache, err := credentials.LoadCCache(login.CCache)
+		if err != nil {
+			return fmt.Errorf("failed to load ticket cache %s: %v", login.CCache, err)
+		}
+		if client, err = krb.NewFromCCache(ccache, login.config, krb.DisablePAFXFAST(true)); err != nil {
+			return fmt.Errorf("failed to use ticket cache %s: %v", login.CCache, err)
+		}
+		loggedIn = info.ModTime()
+		for _, cred := range ccache.GetEntries() {
+			if len(cred.Server.PrincipalName.NameString) > 0 && cred.Server.PrincipalName.NameString[0] == "krbtgt" {
+				tgtExpiry = cred.EndTime
+			}
+		}
+	}
+
+	// the clients of open connections keep the previous login
+	login.mutex.Lock()
+	login.client, login.loggedIn, login.tgtExpiry = client, loggedIn, tgtExpiry
+	login.mutex.Unlock()
+	loginfo(fmt.Sprintf("Logged in to Kerberos as %s", client.Credentials.CName().PrincipalNameString()), nil)
+	return nil
+}
+
+// Returns the client used to authenticate new connections
+func (login *KerberosLogin) Client() *krb.Client {
+	login.mutex.Lock()
+	defer login.mutex.Unlock()
+	return login.client
+}
+
+// Returns the end time of the TGT of the ticket cache, zero for keytab logins which are renewed
+func (login *KerberosLogin) Expiry() time.Time {
+	login.mutex.Lock()
+	defer login.mutex.Unlock()
+	return login.tgtExpiry
+}
+
+// Returns the HDFS user of the login
+func (login *KerberosLogin) UserName() string {
+	return principalShortName(login.Client().Credentials.CName().PrincipalNameString())
+}
+
+// Checks the login periodically until closed
+func (login *KerberosLogin) Run() {
+	for {
+		select {
+		case <-login.done:
+			return
+		case <-login.Clock.After(login.Interval):
+			login.check()
+		}
+	}
+}
+
+// Stops the checks
+func (login *KerberosLogin) Close() error {
+	close(login.done)
+	return nil
+}
+
+func (login *KerberosLogin) check() {
+	login.mutex.Lock()
+	loggedIn, tgtExpiry := login.loggedIn, login.tgtExpiry
+	login.mutex.Unlock()
+
+	if login.Keytab != "" {
+		// failures are retried on the next check, the current TGT is used meanwhile
+		if login.Relogin > 0 && login.Clock.Now().Sub(loggedIn) >= login.Relogin {
+			if err := login.login(); err != nil {
+				logerror("Failed to renew the Kerberos login", Fields{Error: err})
+			}
+		}
+		return
+	}
+
+	if info, err := os.Stat(login.CCache); err != nil {
+		// kinit replaces the file
+		logwarn(fmt.Sprintf("Unable to read ticket cache %s", login.CCache), Fields{Error: err})
+	} else if info.ModTime().After(loggedIn) {
+		if err := login.login(); err != nil {
+			logwarn("Unable to read the renewed ticket cache", Fields{Error: err})
+			return
+		}
+		loginfo("Found renewed ticket cache, reconnecting", nil)
+		for _, r := range login.Reconnecters {
+			if err := r.Reconnect(); err != nil {
+				logerror("Failed to reconnect with the renewed ticket cache", Fields{Error: err})
+			}
+		}
+		return
+	}
+	if remaining := tgtExpiry.Sub(login.Clock.Now()) - clockSkewTolerance; !tgtExpiry.IsZero() && remaining < login.Margin {
+		logwarn(fmt.Sprintf("Kerberos ticket expires in %v and the ticket cache was not renewed, run kinit -R or k5start", remaining), nil)
+	}
+}
+
+// Returns true if the token file, e.g., of a YARN container, holds HDFS delegation tokens, which
+// only clusters with Kerberos authentication issue. The kind of the tokens is a string in both
+// the Writable and the protobuf formats of the file
+func hasHdfsDelegationTokens(tokenFile string) (bool, error) {
+	data, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Contains(data, []byte("HDFS_DELEGATION_TOKEN")), nil
+}
+
+// Logs in with -kerberos, exits on failure since no connection could be authenticated. The HDFS
+// delegation tokens of HADOOP_TOKEN_FILE_LOCATION are ignored with a warning: the HDFS client can
+// neither fetch, renew nor authenticate with them
+func initKerberos() {
+	if tokenFile := os.Getenv("HADOOP_TOKEN_FILE_LOCATION"); tokenFile != "" {
+		if tokens, err := hasHdfsDelegationTokens(tokenFile); err != nil {
+			logwarn(fmt.Sprintf("Unable to read HADOOP_TOKEN_FILE_LOCATION %s", tokenFile), Fields{Error: err})
+		} else if tokens {
+			logwarn(fmt.Sprintf("Ignoring the delegation tokens of HADOOP_TOKEN_FILE_LOCATION %s, use -kerberosKeytab for mounts outliving a ticket", tokenFile), nil)
+		}
+	}
+	if !kerberos {
+		return
+	}
+	ccache := kerberosCCache
+	if ccache == "" {
+		ccache = defaultKerberosCCache()
+	}
+	login, err := NewKerberosLogin(kerberosPrincipal, kerberosKeytab, ccache, krb5Conf, kerberosServicePrincipal, credentialRefreshMargin, WallClock{})
+	if err != nil {
+		logfatal(fmt.Sprintf("Kerberos login failed. Error: %v", err), nil)
+	}
+	kerberosLogin = login
+}
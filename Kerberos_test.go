@@ -0,0 +1,69 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that a ticket cache login acts as the principal of the cache and reconnects once the cache is renewed
+func TestKerberosLogin(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "krb")
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "krb5.conf")
+	assert.Nil(t, ioutil.WriteFile(conf, []byte("[libdefaults]\n default_realm = TEST.GOKRB5\n[realms]\n TEST.GOKRB5 = {\n  kdc = 127.0.0.1:88\n }\n"), 0600))
+	ccache := filepath.Join(dir, "krb5cc")
+	b, _ := hex.DecodeString(testdata.CCACHE_TEST)
+	assert.Nil(t, ioutil.WriteFile(ccache, b, 0600))
+
+	_, err := NewKerberosLogin("", "", filepath.Join(dir, "missing"), conf, "nn/_HOST", 30*time.Minute, WallClock{})
+	assert.NotNil(t, err)
+	login, err := NewKerberosLogin("", "", ccache, conf, "nn/_HOST", 30*time.Minute, WallClock{})
+	assert.Nil(t, err)
+	assert.Equal(t, "testuser1", login.UserName())
+	assert.False(t, login.tgtExpiry.IsZero())
+
+	r := &countingReconnecter{}
+	login.Reconnecters = []Reconnecter{r}
+	login.check()
+	assert.Equal(t, 0, r.reconnects)
+	renewed := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(ccache, renewed, renewed))
+	login.check()
+	assert.Equal(t, 1, r.reconnects)
+	login.check()
+	assert.Equal(t, 1, r.reconnects)
+
+	user, realm, err := splitPrincipal("hdfs/nn1.example.com@EXAMPLE.COM")
+	assert.Nil(t, err)
+	assert.Equal(t, "hdfs/nn1.example.com", user)
+	assert.Equal(t, "EXAMPLE.COM", realm)
+	assert.Equal(t, "hdfs", principalShortName(user))
+	_, _, err = splitPrincipal("hdfs")
+	assert.NotNil(t, err)
+}
+
+// Testing that the HDFS delegation tokens of a token file are found, and not those of other services
+func TestHasHdfsDelegationTokens(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hopsfs-tokens")
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "container_tokens")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("HDTS\x00\x01\x15HDFS_DELEGATION_TOKEN\x0f10.0.0.1:8020"), 0600))
+	tokens, err := hasHdfsDelegationTokens(tokenFile)
+	assert.Nil(t, err)
+	assert.True(t, tokens)
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("HDTS\x00\x01\x0fYARN_AM_RM_TOKEN"), 0600))
+	tokens, err = hasHdfsDelegationTokens(tokenFile)
+	assert.Nil(t, err)
+	assert.False(t, tokens)
+	_, err = hasHdfsDelegationTokens(filepath.Join(dir, "missing"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,461 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	cryptotls "crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directory of the ldap id mapping and the credentials of its simple bind, anonymous if not set
+var ldapURL string
+var ldapBaseDN string
+var ldapBindDN string
+var ldapBindPasswordFile string
+
+// Returns the password in -ldapBindPasswordFile, empty if it is not set
+func ldapBindPassword() (string, error) {
+	if ldapBindPasswordFile == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(ldapBindPasswordFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+type ldapEntry struct {
+	values  []string
+	expires time.Duration
+}
+
+// Maps the users and groups with an LDAP directory of the RFC 2307 schema: posixAccount entries
+// with the uid, uidNumber and gidNumber attributes, and posixGroup entries with cn, gidNumber and
+// the memberUid of their members. The results, including the names which are not found, are
+// cached for -idCacheTTL. Names and ids which are not in the directory are those of the local
+// accounts, as are all of them while the directory is unreachable
+type ldapIdMapper struct {
+	nssIdMapper
+	client *ldapClient
+	BaseDN string
+	TTL    time.Duration
+	Clock  Clock
+
+	mutex   sync.Mutex
+	entries map[string]ldapEntry
+}
+
+// Creates the mapper and binds, so that an unreachable directory or wrong credentials fail the mount
+func newLdapIdMapper(client *ldapClient, baseDN string, ttl time.Duration, clock Clock) (*ldapIdMapper, error) {
+	if err := client.Open(); err != nil {
+		return nil, err
+	}
+	return &ldapIdMapper{client: client, BaseDN: baseDN, TTL: ttl, Clock: clock, entries: map[string]ldapEntry{}}, nil
+}
+
+// Returns the values of the attribute of the entries of the object class with the attribute value
+func (mapper *ldapIdMapper) lookup(objectClass string, key string, value string, attribute string) ([]string, error) {
+	cacheKey := strings.Join([]string{objectClass, key, value, attribute}, "\x00")
+	now := mapper.Clock.Monotonic()
+	mapper.mutex.Lock()
+	entry, ok := mapper.entries[cacheKey]
+	mapper.mutex.Unlock()
+	if ok && now < entry.expires {
+		return entry.values, nil
+	}
+
+	values, err := mapper.client.search(mapper.BaseDN, [][2]string{{"objectClass", objectClass}, {key, value}}, attribute)
+	if err != nil {
+		return nil, err
+	}
+	mapper.mutex.Lock()
+	defer mapper.mutex.Unlock()
+	for k, v := range mapper.entries {
+		if now >= v.expires {
+			delete(mapper.entries, k)
+		}
+	}
+	mapper.entries[cacheKey] = ldapEntry{values: values, expires: now + mapper.TTL}
+	return values, nil
+}
+
+// Returns the id in the first value of the attribute, false if the directory has none
+func (mapper *ldapIdMapper) lookupId(objectClass string, key string, value string, attribute string) (uint32, bool) {
+	values, err := mapper.lookup(objectClass, key, value, attribute)
+	if err != nil {
+		logwarn(fmt.Sprintf("Failed to look %s up in %s, using NSS", value, mapper.client.URL), Fields{Error: err})
+		return 0, false
+	}
+	if len(values) == 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(values[0], 10, 32)
+	if err != nil {
+		logwarn(fmt.Sprintf("Invalid %s of %s in %s", attribute, value, mapper.client.URL), Fields{Error: err})
+		return 0, false
+	}
+	return uint32(id), true
+}
+
+// Returns the first value of the attribute, empty if the directory has none
+func (mapper *ldapIdMapper) lookupName(objectClass string, key string, id uint32, attribute string) string {
+	values, err := mapper.lookup(objectClass, key, strconv.FormatUint(uint64(id), 10), attribute)
+	if err != nil {
+		logwarn(fmt.Sprintf("Failed to look %s %d up in %s, using NSS", key, id, mapper.client.URL), Fields{Error: err})
+		return ""
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (mapper *ldapIdMapper) Uid(user string) (uint32, bool) {
+	if uid, ok := mapper.lookupId("posixAccount", "uid", user, "uidNumber"); ok {
+		return uid, true
+	}
+	return mapper.nssIdMapper.Uid(user)
+}
+
+func (mapper *ldapIdMapper) Gid(group string) (uint32, bool) {
+	if gid, ok := mapper.lookupId("posixGroup", "cn", group, "gidNumber"); ok {
+		return gid, true
+	}
+	return mapper.nssIdMapper.Gid(group)
+}
+
+func (mapper *ldapIdMapper) UserName(uid uint32) string {
+	if name := mapper.lookupName("posixAccount", "uidNumber", uid, "uid"); name != "" {
+		return name
+	}
+	return mapper.nssIdMapper.UserName(uid)
+}
+
+func (mapper *ldapIdMapper) GroupName(gid uint32) string {
+	if name := mapper.lookupName("posixGroup", "gidNumber", gid, "cn"); name != "" {
+		return name
+	}
+	return mapper.nssIdMapper.GroupName(gid)
+}
+
+// Returns the primary group of the account and the groups listing the user as a member. Users
+// which are not in the directory have the groups of their local account
+func (mapper *ldapIdMapper) Groups(user string) ([]string, error) {
+	primary, err := mapper.lookup("posixAccount", "uid", user, "gidNumber")
+	if err != nil {
+		return nil, err
+	}
+	if len(primary) == 0 {
+		return mapper.nssIdMapper.Groups(user)
+	}
+	members, err := mapper.lookup("posixGroup", "memberUid", user, "cn")
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(members)+1)
+	if gid, err := strconv.ParseUint(primary[0], 10, 32); err == nil {
+		if name := mapper.GroupName(uint32(gid)); name != "" {
+			groups = append(groups, name)
+		}
+	}
+	for _, group := range members {
+		if len(groups) == 0 || group != groups[0] {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// Minimal LDAP v3 client (RFC 4511) of the ldap id mapping: a simple bind and searches with an
+// equality filter, over one connection which is opened again once it failed
+type ldapClient struct {
+	URL      string // ldap://host:389 or ldaps://host:636
+	BindDN   string
+	Password string
+	Timeout  time.Duration
+
+	mutex     sync.Mutex
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageID int
+}
+
+// BER tags of the LDAP messages and filters used
+const (
+	berBoolean        = 0x01
+	berInteger        = 0x02
+	berOctetString    = 0x04
+	berEnumerated     = 0x0a
+	berSequence       = 0x30
+	ldapBindRequest   = 0x60
+	ldapBindResponse  = 0x61
+	ldapUnbindRequest = 0x42
+	ldapSearchRequest = 0x63
+	ldapSearchEntry   = 0x64
+	ldapSearchDone    = 0x65
+	ldapSearchRef     = 0x73
+	ldapFilterAnd     = 0xa0
+	ldapFilterEqual   = 0xa3
+	ldapSimpleAuth    = 0x80
+)
+
+// An element of a BER encoded message: its tag and its content, the children of constructed ones
+type berElement struct {
+	tag      byte
+	content  []byte
+	children []berElement
+}
+
+func berEncode(tag byte, content ...[]byte) []byte {
+	length := 0
+	for _, c := range content {
+		length += len(c)
+	}
+	out := []byte{tag}
+	if length < 0x80 {
+		out = append(out, byte(length))
+	} else {
+		var lengthBytes []byte
+		for l := length; l > 0; l >>= 8 {
+			lengthBytes = append([]byte{byte(l)}, lengthBytes...)
+		}
+		out = append(out, 0x80|byte(len(lengthBytes)))
+		out = append(out, lengthBytes...)
+	}
+	for _, c := range content {
+		out = append(out, c...)
+	}
+	return out
+}
+
+func berInt(tag byte, value int) []byte {
+	content := []byte{byte(value)}
+	for value >>= 8; value > 0; value >>= 8 {
+		content = append([]byte{byte(value)}, content...)
+	}
+	if content[0]&0x80 != 0 {
+		content = append([]byte{0}, content...)
+	}
+	return berEncode(tag, content)
+}
+
+func berString(tag byte, value string) []byte {
+	return berEncode(tag, []byte(value))
+}
+
+// Reads one element from the reader, and its children if it is constructed
+func berRead(reader io.Reader) (berElement, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return berElement{}, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		lengthBytes := make([]byte, length&0x7f)
+		if len(lengthBytes) == 0 || len(lengthBytes) > 4 {
+			return berElement{}, fmt.Errorf("unsupported BER length of %d bytes", len(lengthBytes))
+		}
+		if _, err := io.ReadFull(reader, lengthBytes); err != nil {
+			return berElement{}, err
+		}
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	element := berElement{tag: header[0], content: make([]byte, length)}
+	if _, err := io.ReadFull(reader, element.content); err != nil {
+		return berElement{}, err
+	}
+	if element.tag&0x20 != 0 {
+		rest := strings.NewReader(string(element.content))
+		for rest.Len() > 0 {
+			child, err := berRead(rest)
+			if err != nil {
+				return berElement{}, err
+			}
+			element.children = append(element.children, child)
+		}
+	}
+	return element, nil
+}
+
+func (element berElement) int() int {
+	value := 0
+	for _, b := range element.content {
+		value = value<<8 | int(b)
+	}
+	return value
+}
+
+// Returns the result code and the diagnostic message of a response
+func ldapResult(op berElement) (int, string) {
+	if len(op.children) < 3 {
+		return -1, "malformed LDAP result"
+	}
+	return op.children[0].int(), string(op.children[2].content)
+}
+
+// Connects to the server and binds
+func (client *ldapClient) Open() error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.closeConn()
+	return client.connect()
+}
+
+// Connects to the server and binds, called with the mutex held
+func (client *ldapClient) connect() error {
+	u, err := url.Parse(client.URL)
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{Timeout: client.Timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		conn, err = dialer.Dial("tcp", hostPort(u, "389"))
+	case "ldaps":
+		conn, err = cryptotls.DialWithDialer(dialer, "tcp", hostPort(u, "636"), &cryptotls.Config{ServerName: u.Hostname()})
+	default:
+		return fmt.Errorf("unsupported LDAP URL %s, expected ldap:// or ldaps://", client.URL)
+	}
+	if err != nil {
+		return err
+	}
+	client.conn, client.reader = conn, bufio.NewReader(conn)
+	response, err := client.roundTrip(berEncode(ldapBindRequest, berInt(berInteger, 3), berString(berOctetString, client.BindDN), berString(ldapSimpleAuth, client.Password)))
+	if err == nil && (len(response) != 1 || response[0].tag != ldapBindResponse) {
+		err = errors.New("unexpected reply to the LDAP bind")
+	}
+	if err == nil {
+		if code, message := ldapResult(response[0]); code != 0 {
+			err = fmt.Errorf("LDAP bind as %q failed with result code %d: %s", client.BindDN, code, message)
+		}
+	}
+	if err != nil {
+		client.closeConn()
+	}
+	return err
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+// Sends a request and returns the operations of its replies, up to the final one which is not a
+// search entry or reference. Called with the mutex held
+func (client *ldapClient) roundTrip(op []byte) ([]berElement, error) {
+	client.messageID++
+	id := client.messageID
+	client.conn.SetDeadline(time.Now().Add(client.Timeout))
+	if _, err := client.conn.Write(berEncode(berSequence, berInt(berInteger, id), op)); err != nil {
+		return nil, err
+	}
+	var ops []berElement
+	for {
+		message, err := berRead(client.reader)
+		if err != nil {
+			return nil, err
+		}
+		if message.tag != berSequence || len(message.children) < 2 {
+			return nil, errors.New("malformed LDAP message")
+		}
+		if message.children[0].int() != id {
+			continue
+		}
+		reply := message.children[1]
+		ops = append(ops, reply)
+		if reply.tag != ldapSearchEntry && reply.tag != ldapSearchRef {
+			return ops, nil
+		}
+	}
+}
+
+// Returns the values of the attribute of the entries under the base DN whose attributes have the
+// values of the filter, which is a list of attribute and value pairs
+func (client *ldapClient) search(baseDN string, filter [][2]string, attribute string) ([]string, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.conn == nil {
+		if err := client.connect(); err != nil {
+			return nil, err
+		}
+	}
+	var matches [][]byte
+	for _, f := range filter {
+		matches = append(matches, berEncode(ldapFilterEqual, berString(berOctetString, f[0]), berString(berOctetString, f[1])))
+	}
+	request := berEncode(ldapSearchRequest,
+		berString(berOctetString, baseDN),
+		berInt(berEnumerated, 2), // whole subtree
+		berInt(berEnumerated, 0), // never dereference aliases
+		berInt(berInteger, 0),
+		berInt(berInteger, int(client.Timeout/time.Second)),
+		berEncode(berBoolean, []byte{0}),
+		berEncode(ldapFilterAnd, matches...),
+		berEncode(berSequence, berString(berOctetString, attribute)))
+	ops, err := client.roundTrip(request)
+	if err != nil {
+		client.closeConn()
+		return nil, err
+	}
+	var values []string
+	for _, op := range ops {
+		switch op.tag {
+		case ldapSearchEntry:
+			if len(op.children) < 2 {
+				continue
+			}
+			for _, attr := range op.children[1].children {
+				if len(attr.children) == 2 && strings.EqualFold(string(attr.children[0].content), attribute) {
+					for _, value := range attr.children[1].children {
+						values = append(values, string(value.content))
+					}
+				}
+			}
+		case ldapSearchDone:
+			// noSuchObject, e.g., a mistyped -ldapBaseDN, is an error, no match is not
+			if code, message := ldapResult(op); code != 0 {
+				return nil, fmt.Errorf("LDAP search under %q failed with result code %d: %s", baseDN, code, message)
+			}
+		}
+	}
+	return values, nil
+}
+
+// Closes the connection which failed, the next search connects again. Called with the mutex held
+func (client *ldapClient) closeConn() {
+	if client.conn != nil {
+		client.conn.Close()
+		client.conn, client.reader = nil, nil
+	}
+}
+
+// Unbinds and closes the connection
+func (client *ldapClient) Close() error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.conn == nil {
+		return nil
+	}
+	client.messageID++
+	client.conn.Write(berEncode(berSequence, berInt(berInteger, client.messageID), []byte{ldapUnbindRequest, 0}))
+	err := client.conn.Close()
+	client.conn, client.reader = nil, nil
+	return err
+}
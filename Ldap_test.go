@@ -0,0 +1,167 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// LDAP server answering the binds as cn=admin and the equality searches of the ldap id mapping
+type fakeLdapServer struct {
+	listener net.Listener
+	entries  []map[string][]string
+	searches int32
+	mutex    sync.Mutex
+	conns    []net.Conn
+}
+
+func newFakeLdapServer(t *testing.T, entries ...map[string][]string) *fakeLdapServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := &fakeLdapServer{listener: listener, entries: entries}
+	t.Cleanup(func() {
+		listener.Close()
+		server.closeConns()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mutex.Lock()
+			server.conns = append(server.conns, conn)
+			server.mutex.Unlock()
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *fakeLdapServer) URL() string {
+	return "ldap://" + server.listener.Addr().String()
+}
+
+func (server *fakeLdapServer) closeConns() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+	server.conns = nil
+}
+
+func (server *fakeLdapServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reply := func(id int, op []byte) { conn.Write(berEncode(berSequence, berInt(berInteger, id), op)) }
+	result := func(tag byte, code int) []byte {
+		return berEncode(tag, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, ""))
+	}
+	for {
+		message, err := berRead(conn)
+		if err != nil {
+			return
+		}
+		id, op := message.children[0].int(), message.children[1]
+		switch op.tag {
+		case ldapBindRequest:
+			code := 0
+			if string(op.children[1].content) != "cn=admin" || string(op.children[2].content) != "secret" {
+				code = 49 // invalidCredentials
+			}
+			reply(id, result(ldapBindResponse, code))
+		case ldapSearchRequest:
+			atomic.AddInt32(&server.searches, 1)
+			attribute := string(op.children[7].children[0].content)
+			for _, entry := range server.entries {
+				matches := true
+				for _, filter := range op.children[6].children {
+					found := false
+					for _, value := range entry[string(filter.children[0].content)] {
+						found = found || value == string(filter.children[1].content)
+					}
+					matches = matches && found
+				}
+				if !matches {
+					continue
+				}
+				var values [][]byte
+				for _, value := range entry[attribute] {
+					values = append(values, berString(berOctetString, value))
+				}
+				reply(id, berEncode(ldapSearchEntry, berString(berOctetString, "cn=entry"),
+					berEncode(berSequence, berEncode(berSequence, berString(berOctetString, attribute), berEncode(0x31, values...)))))
+			}
+			reply(id, result(ldapSearchDone, 0))
+		case ldapUnbindRequest:
+			return
+		}
+	}
+}
+
+// Testing the encoding of a bind request against the bytes of RFC 4511, and the long form of lengths
+func TestBerEncoding(t *testing.T) {
+	bind := berEncode(berSequence, berInt(berInteger, 1),
+		berEncode(ldapBindRequest, berInt(berInteger, 3), berString(berOctetString, "cn=admin"), berString(ldapSimpleAuth, "secret")))
+	expected := append(append([]byte{0x30, 0x1a, 0x02, 0x01, 0x01, 0x60, 0x15, 0x02, 0x01, 0x03, 0x04, 0x08}, "cn=admin"...), 0x80, 0x06)
+	assert.Equal(t, append(expected, "secret"...), bind)
+
+	long := berString(berOctetString, string(make([]byte, 300)))
+	assert.Equal(t, []byte{0x04, 0x82, 0x01, 0x2c}, long[:4])
+	element, err := berRead(bytes.NewReader(berEncode(berSequence, long, berInt(berInteger, 128))))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(element.children))
+	assert.Equal(t, 300, len(element.children[0].content))
+	assert.Equal(t, 128, element.children[1].int())
+}
+
+// Testing the ids and groups of the directory, the cache of the answers, the names not in the
+// directory mapped by NSS, and that the mapping connects again once the connection failed
+func TestLdapIdMapper(t *testing.T) {
+	server := newFakeLdapServer(t,
+		map[string][]string{"objectClass": {"posixAccount"}, "uid": {"alice"}, "uidNumber": {"5001"}, "gidNumber": {"6001"}},
+		map[string][]string{"objectClass": {"posixGroup"}, "cn": {"analysts"}, "gidNumber": {"6001"}},
+		map[string][]string{"objectClass": {"posixGroup"}, "cn": {"project1"}, "gidNumber": {"6002"}, "memberUid": {"alice", "bob"}})
+	_, err := newLdapIdMapper(&ldapClient{URL: server.URL(), BindDN: "cn=admin", Password: "wrong", Timeout: time.Second}, "dc=example", time.Minute, &MockClock{})
+	assert.NotNil(t, err)
+
+	clock := &MockClock{}
+	client := &ldapClient{URL: server.URL(), BindDN: "cn=admin", Password: "secret", Timeout: time.Second}
+	mapper, err := newLdapIdMapper(client, "dc=example", time.Minute, clock)
+	assert.Nil(t, err)
+	defer client.Close()
+
+	uid, ok := mapper.Uid("alice")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(5001), uid)
+	assert.Equal(t, "alice", mapper.UserName(5001))
+	gid, ok := mapper.Gid("project1")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(6002), gid)
+	groups, err := mapper.Groups("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"analysts", "project1"}, groups)
+	uid, ok = mapper.Uid("root")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0), uid)
+
+	searches := atomic.LoadInt32(&server.searches)
+	mapper.Uid("alice")
+	mapper.Uid("root")
+	assert.Equal(t, searches, atomic.LoadInt32(&server.searches), "answered from the cache")
+
+	server.closeConns()
+	clock.NotifyTimeElapsed(time.Minute)
+	_, ok = mapper.Uid("alice")
+	assert.False(t, ok, "NSS while the directory is unreachable")
+	uid, ok = mapper.Uid("alice")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(5001), uid)
+}
@@ -0,0 +1,78 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// File browsers, e.g., the one of JupyterLab, list the open directory every few seconds and
+// look up names which do not exist, e.g., .ipynb_checkpoints or the .git of each directory.
+// With -listingCacheTTL, the listing of a directory is served from memory for that long, and
+// with -negativeLookupTTL, a name which was not found is reported missing for that long
+// without a stat RPC. Changes made through the mount update both caches right away, changes
+// made by other clients show once the cached listing or lookup expires
+var listingCacheTTL time.Duration
+var negativeLookupTTL time.Duration
+
+// Listing of a directory, kept with -listingCacheTTL
+type cachedListing struct {
+	entries []fuse.Dirent
+	expires time.Duration
+}
+
+// Returns the cached listing of the directory, nil if there is none. Called with the directory locked
+func (dir *DirINode) cachedListing() []fuse.Dirent {
+	if listingCacheTTL <= 0 {
+		return nil
+	}
+	hit := dir.listing != nil && dir.FileSystem.Clock.Monotonic() <= dir.listing.expires
+	metrics.Record(ListingCacheOp, 0, 0, 0, hit, nil)
+	if !hit {
+		dir.listing = nil
+		return nil
+	}
+	return append([]fuse.Dirent(nil), dir.listing.entries...)
+}
+
+// Keeps the listing of the directory. Called with the directory locked
+func (dir *DirINode) cacheListing(entries []fuse.Dirent) {
+	if listingCacheTTL > 0 {
+		dir.listing = &cachedListing{
+			entries: append([]fuse.Dirent(nil), entries...),
+			expires: dir.FileSystem.Clock.Monotonic() + listingCacheTTL}
+	}
+}
+
+// Returns true if the name was not found within -negativeLookupTTL. Called with the directory locked
+func (dir *DirINode) knownMissing(name string) bool {
+	expires, ok := dir.missing[name]
+	if !ok {
+		return false
+	}
+	if dir.FileSystem.Clock.Monotonic() > expires {
+		delete(dir.missing, name)
+		return false
+	}
+	return true
+}
+
+// Remembers that the name was not found. Called with the directory locked
+func (dir *DirINode) cacheMissing(name string) {
+	if negativeLookupTTL <= 0 {
+		return
+	}
+	if dir.missing == nil {
+		dir.missing = make(map[string]time.Duration)
+	}
+	dir.missing[name] = dir.FileSystem.Clock.Monotonic() + negativeLookupTTL
+}
+
+// Drops what the caches know of the name, called when an entry of the directory is created, renamed or removed
+func (dir *DirINode) forgetListing(name string) {
+	dir.listing = nil
+	dir.resetEntryAttrsTTL()
+	delete(dir.missing, name)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that listings and missing names are served from memory until they expire or the directory changes
+func TestListingCache(t *testing.T) {
+	saveFlags(t, &listingCacheTTL, &negativeLookupTTL)
+	listingCacheTTL = 10 * time.Second
+	negativeLookupTTL = 10 * time.Second
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	dir := root.(*DirINode)
+
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "a.ipynb", Mode: 0644}}, nil).Times(3)
+	for i := 0; i < 2; i++ {
+		entries, err := dir.ReadDirAll(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(entries))
+	}
+	hdfsAccessor.EXPECT().Stat("/.ipynb_checkpoints").Return(Attrs{}, syscall.ENOENT)
+	for i := 0; i < 2; i++ {
+		_, err := dir.Lookup(nil, ".ipynb_checkpoints")
+		assert.Equal(t, syscall.ENOENT, err)
+	}
+
+	// a directory created through the mount shows right away
+	hdfsAccessor.EXPECT().Mkdir("/.ipynb_checkpoints", os.FileMode(0755)|os.ModeDir).Return(nil)
+	hdfsAccessor.EXPECT().Chown("/.ipynb_checkpoints", gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	_, err := dir.Mkdir(nil, &fuse.MkdirRequest{Name: ".ipynb_checkpoints", Mode: os.FileMode(0755) | os.ModeDir})
+	assert.Nil(t, err)
+	node, err := dir.Lookup(nil, ".ipynb_checkpoints")
+	assert.Nil(t, err)
+	assert.NotNil(t, node)
+	_, err = dir.ReadDirAll(nil)
+	assert.Nil(t, err)
+
+	// changes of other clients show once the caches expire
+	hdfsAccessor.EXPECT().Stat("/model.pt").Return(Attrs{}, syscall.ENOENT)
+	hdfsAccessor.EXPECT().Stat("/model.pt").Return(Attrs{Name: "model.pt", Mode: 0644}, nil)
+	mockClock.NotifyTimeElapsed(11 * time.Second)
+	_, err = dir.ReadDirAll(nil)
+	assert.Nil(t, err)
+	_, err = dir.Lookup(nil, "model.pt")
+	assert.Equal(t, syscall.ENOENT, err)
+	_, err = dir.Lookup(nil, "model.pt")
+	assert.Equal(t, syscall.ENOENT, err)
+	mockClock.NotifyTimeElapsed(11 * time.Second)
+	_, err = dir.Lookup(nil, "model.pt")
+	assert.Nil(t, err)
+}
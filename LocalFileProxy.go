@@ -8,25 +8,44 @@ import (
 type LocalRWFileProxy struct {
 	localFile *os.File // handle to the temp file in staging dir
 	file      *FileINode
+	changes   stagingChanges // regions changed since the last upload, for -deltaUploads
+	dirty     bool           // the sidecar records data which is not in DFS
 }
 
 var _ FileProxy = (*LocalRWFileProxy)(nil)
 
+// Creates the proxy of a staging file with the content the file has in DFS
+func newLocalRWFileProxy(stagingFile *os.File, file *FileINode) *LocalRWFileProxy {
+	p := &LocalRWFileProxy{localFile: stagingFile, file: file}
+	size := int64(-1)
+	if info, err := stagingFile.Stat(); err == nil {
+		size = info.Size()
+	}
+	p.changes.reset(size)
+	return p
+}
+
 func (p *LocalRWFileProxy) Truncate(size int64) (int64, error) {
 	p.file.lockFileHandles()
 	defer p.file.unlockFileHandles()
+	p.changes.truncated(size)
+	p.journal(true)
+	return truncateStagingFile(p.localFile, size)
+}
 
-	statBefore, err := p.localFile.Stat()
+// Truncates the staging file and returns by how much its size changed
+func truncateStagingFile(localFile *os.File, size int64) (int64, error) {
+	statBefore, err := localFile.Stat()
 	if err != nil {
 		return 0, err
 	}
 
-	err = p.localFile.Truncate(size)
+	err = localFile.Truncate(size)
 	if err != nil {
 		return 0, err
 	}
 
-	statAfter, err := p.localFile.Stat()
+	statAfter, err := localFile.Stat()
 	if err != nil {
 		return 0, err
 	}
@@ -37,6 +56,8 @@ func (p *LocalRWFileProxy) Truncate(size int64) (int64, error) {
 func (p *LocalRWFileProxy) WriteAt(b []byte, off int64) (n int, err error) {
 	p.file.lockFileHandles()
 	defer p.file.unlockFileHandles()
+	p.changes.written(off)
+	p.journal(true)
 	return p.localFile.WriteAt(b, off)
 }
 
@@ -63,7 +84,7 @@ func (p *LocalRWFileProxy) Read(b []byte) (n int, err error) {
 
 func (p *LocalRWFileProxy) Close() error {
 	//NOTE: Locking is done in File.go
-	return p.localFile.Close()
+	return removeStagingFile(p.localFile)
 }
 
 func (p *LocalRWFileProxy) Sync() error {
@@ -8,9 +8,7 @@ import (
 	"os"
 	"runtime"
 
-	nested "github.com/antonfisher/nested-logrus-formatter"
 	logger "github.com/sirupsen/logrus"
-	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // bunch of constants for logging
@@ -64,6 +62,71 @@ const (
 	Line              = "line"
 	ReqOffset         = "req_offset"
 	FileHandleID      = "file_handle_id"
+	RemoveAll         = "remove_all"
+	AdminCmd          = "admin"
+	Command           = "cmd"
+	Args              = "args"
+	PID               = "pid"
+	Access            = "access"
+	Duration          = "duration"
+	AvgDuration       = "avg_duration"
+	MaxDuration       = "max_duration"
+	CacheHit          = "cache_hit"
+	Count             = "count"
+	Errors            = "errors"
+	Interval          = "interval"
+	Lookup            = "lookup"
+	Checksum          = "checksum"
+	Chtimes           = "chtimes"
+	GetXAttrs         = "getxattrs"
+	GetContentSummary = "content_summary"
+	Append            = "append"
+	Stream            = "stream"
+	BlockCacheOp      = "block_cache"
+	FooterCacheOp     = "footer_cache"
+	ReadaheadOp       = "readahead"
+	WebHdfsRead       = "webhdfs_read"
+	PackOp            = "pack"
+	HeatmapOp         = "heatmap"
+	HookOp            = "hook"
+	StatsDumpOp       = "stats_dump"
+	ControlOp         = "control"
+	StoragePolicyOp   = "storage_policy"
+	ErasureCodingOp   = "erasure_coding"
+	Canary            = "canary"
+	Connect           = "connect"
+	ErrorClasses      = "error_classes"
+	BuildInfoOp       = "build_info"
+	ServerDefaultsOp  = "server_defaults"
+	CapabilitiesOp    = "capabilities"
+	QuotaWarning      = "quota_warning"
+	CapacityWarning   = "capacity_warning"
+	ParkUpload        = "park_upload"
+	ReplayUpload      = "replay_upload"
+	FailedUploads     = "failed_uploads"
+	HedgedRead        = "hedged_read"
+	HedgedReadWon     = "hedged_read_won"
+	AttrCacheOp       = "attr_cache"
+	ReadaheadFetched  = "readahead_fetched"
+	CacheHitRatios    = "cache_hit_ratios"
+	TuningHint        = "tuning_hint"
+	CreateSnapshot    = "create_snapshot"
+	StreamFallback    = "stream_fallback"
+	OverlayOp         = "overlay"
+	Replace           = "replace"
+	RecoverStagingOp  = "recover_staging"
+	ReaderReuseOp     = "reader_reuse"
+	StagingEvictOp    = "staging_evict"
+	ListingCacheOp    = "listing_cache"
+	GetAclOp          = "getacl"
+	SetAclOp          = "setacl"
+	FreezeOp          = "freeze"
+	DrainOp           = "drain"
+	Symlink           = "symlink"
+	Readlink          = "readlink"
+	VerifyReadOp      = "verify_read"
+	SetXAttrOp        = "setxattr"
+	PreserveOp        = "preserve_metadata"
 )
 
 var ReportCaller = true
@@ -80,31 +143,19 @@ func initLogger(l string, reportCaller bool, lfile string) {
 		lvl = logger.ErrorLevel
 	}
 
-	// Output to stdout instead of the default stderr
-	// Can be any io.Writer, see below for File example
-	// TODO log to file and log cutting
-
-	//Json output
-	//logger.SetFormatter(&logger.JSONFormatter{})
-
-	//set custom formatter github.com/antonfisher/nested-logrus-formatter
-	logger.SetFormatter(&nested.Formatter{
-		HideKeys:       false,
-		NoFieldsColors: true,
-		FieldsOrder:    []string{Operation, Path, Bytes, TotalBytesRead, TotalBytesWritten},
-	})
+	logger.SetFormatter(logFormatter(logFormat))
+	redirectStdLog()
 
 	// Only log the warning severity or above.
 	logger.SetLevel(lvl)
+	logThreshold = lvl
+	if debugPathPatterns != nil || debugNamePatterns != nil {
+		logger.SetLevel(logger.TraceLevel)
+	}
 
 	// setup log cutting
 	if lfile != "" {
-		logger.SetOutput(&lumberjack.Logger{
-			Filename:   lfile,
-			MaxSize:    100, // megabytes
-			MaxBackups: 10,
-			MaxAge:     30, //days
-		})
+		logger.SetOutput(newRotatedLogFile(lfile, WallClock{}))
 	} else {
 		logger.SetOutput(os.Stdout)
 	}
@@ -141,6 +192,9 @@ func logpanic(msg string, f Fields) {
 }
 
 func logmessage(lvl logger.Level, msg string, f Fields) {
+	if lvl > logThreshold && !debuggedPath(f) {
+		return
+	}
 	if ReportCaller {
 		_, file, line, _ := runtime.Caller(2)
 		if f == nil {
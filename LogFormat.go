@@ -0,0 +1,74 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	nested "github.com/antonfisher/nested-logrus-formatter"
+	logger "github.com/sirupsen/logrus"
+)
+
+// -logFormat json writes every message as one JSON object per line, with the level, time,
+// message and fields, e.g., path, op, duration and error, as keys, so that the log can be
+// ingested by ELK or Loki without parsing the text format. Durations are in milliseconds. The
+// messages of the libraries logged with the log package, e.g., by the FUSE server or the HDFS
+// client, are logged through the same logger at the error level, in either format
+var logFormat string
+
+// Log formats of -logFormat
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Writer of the log package into the logger, replaced by each initLogger
+var stdLogWriter *io.PipeWriter
+
+// Returns the formatter of -logFormat, the text one if it is not json
+func logFormatter(format string) logger.Formatter {
+	if format == LogFormatJSON {
+		return &jsonFormatter{JSONFormatter: logger.JSONFormatter{TimestampFormat: time.RFC3339Nano}}
+	}
+	//set custom formatter github.com/antonfisher/nested-logrus-formatter
+	return &nested.Formatter{
+		HideKeys:       false,
+		NoFieldsColors: true,
+		FieldsOrder:    []string{Operation, Path, Bytes, TotalBytesRead, TotalBytesWritten},
+	}
+}
+
+// Checks the value of -logFormat
+func checkLogFormat(format string) error {
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("invalid -logFormat %s, expected %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+	return nil
+}
+
+// Formats the entries as JSON with the durations in milliseconds
+type jsonFormatter struct {
+	logger.JSONFormatter
+}
+
+func (formatter *jsonFormatter) Format(entry *logger.Entry) ([]byte, error) {
+	for k, v := range entry.Data {
+		if d, ok := v.(time.Duration); ok {
+			entry.Data[k] = float64(d) / float64(time.Millisecond)
+		}
+	}
+	return formatter.JSONFormatter.Format(entry)
+}
+
+// Routes the log package to the logger
+func redirectStdLog() {
+	if stdLogWriter != nil {
+		stdLogWriter.Close()
+	}
+	stdLogWriter = logger.StandardLogger().WriterLevel(logger.ErrorLevel)
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// Buffer written by the goroutine of the log package writer
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+// Testing that -logFormat json logs the fields as keys, durations in milliseconds, and the
+// messages of the log package through the logger
+func TestLogFormatJSON(t *testing.T) {
+	defer initLogger("fatal", false, "")
+	saveFlags(t, &logFormat)
+	logFormat = LogFormatJSON
+	initLogger("warn", false, "")
+	var buf lockedBuffer
+	logger.SetOutput(&buf)
+
+	logwarn("Slow read", Fields{Operation: Read, Path: "/data/a", Duration: 1500 * time.Microsecond, Error: errors.New("timeout")})
+	var entry map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(buf.String()), &entry))
+	assert.Equal(t, "Slow read", entry["msg"])
+	assert.Equal(t, "warning", entry["level"])
+	assert.Equal(t, Read, entry[Operation])
+	assert.Equal(t, "/data/a", entry[Path])
+	assert.Equal(t, 1.5, entry[Duration])
+	assert.Equal(t, "timeout", entry[Error])
+
+	log.Printf("mount helper failed: %v", errors.New("exit status 1"))
+	assert.Eventually(t, func() bool { return strings.Contains(buf.String(), `"msg":"mount helper failed: exit status 1"`) }, time.Second, time.Millisecond)
+
+	assert.NotNil(t, checkLogFormat("xml"))
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// The -logFile of a long running mount is rotated once it reaches -logMaxSize megabytes, and
+// every -logRotateInterval if set, e.g., 24h for a file per day whatever the traffic. The
+// rotated files are renamed with the time of the rotation, compressed with gzip with
+// -logCompress, and deleted once there are more than -logMaxBackups of them or they are older
+// than -logMaxAge days, 0 keeping them regardless of their number or age
+var logMaxSize int
+var logMaxBackups int
+var logMaxAge int
+var logCompress bool
+var logRotateInterval time.Duration
+
+// Stops the rotation of the previous log file, replaced by each initLogger
+var logRotationDone chan struct{}
+
+// Returns the writer of the log file, rotated as configured above
+func newRotatedLogFile(file string, clock Clock) *lumberjack.Logger {
+	if logRotationDone != nil {
+		close(logRotationDone)
+		logRotationDone = nil
+	}
+	writer := &lumberjack.Logger{
+		Filename:   file,
+		MaxSize:    logMaxSize, // megabytes
+		MaxBackups: logMaxBackups,
+		MaxAge:     logMaxAge, //days
+		Compress:   logCompress,
+	}
+	if logRotateInterval > 0 {
+		logRotationDone = make(chan struct{})
+		go rotateLogEvery(writer, logRotateInterval, clock, logRotationDone)
+	}
+	return writer
+}
+
+// Rotates the log file at the interval until done
+func rotateLogEvery(writer *lumberjack.Logger, interval time.Duration, clock Clock, done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-clock.After(interval):
+		}
+		if err := writer.Rotate(); err != nil {
+			logerror("Failed to rotate the log file", Fields{Path: writer.Filename, Error: err})
+		}
+	}
+}
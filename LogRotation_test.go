@@ -0,0 +1,36 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that -logRotateInterval rotates the log file, and -logCompress compresses the rotated files
+func TestLogRotateInterval(t *testing.T) {
+	saveFlags(t, &logRotateInterval, &logCompress)
+	logRotateInterval, logCompress = 20*time.Millisecond, true
+	dir, _ := ioutil.TempDir("", "hopsfs-log")
+	defer os.RemoveAll(dir)
+	writer := newRotatedLogFile(filepath.Join(dir, "hopsfs.log"), WallClock{})
+	defer func() {
+		close(logRotationDone)
+		logRotationDone = nil
+		writer.Close()
+	}()
+	_, err := writer.Write([]byte("mounted\n"))
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		rotated, _ := filepath.Glob(filepath.Join(dir, "hopsfs-*.log.gz"))
+		return len(rotated) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = os.Stat(filepath.Join(dir, "hopsfs.log"))
+	assert.Nil(t, err)
+}
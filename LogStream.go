@@ -0,0 +1,227 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Files under the -logStreamDirs directories are streamed to HDFS while they are written,
+// so that consumers, e.g., log aggregation, see the data before the file is closed. New data
+// is appended every -logStreamInterval, or as soon as -logStreamBytes are pending, and made
+// visible to readers with hflush. All the handles of a file share one HDFS writer, which is
+// closed with the last handle. A file which is written other than at its end, or truncated,
+// stops streaming and is uploaded as a whole by the next flush, then streams again
+// Concurrency: thread safe
+type LogStream struct {
+	file     *FileINode
+	staging  *os.File
+	writer   HdfsWriter
+	streamed int64 // bytes of the staging file which are in HDFS
+	end      int64 // end of the data written to the staging file
+	broken   bool
+	mutex    sync.Mutex
+}
+
+// Returns true if the HDFS path is in one of the -logStreamDirs directories
+func isLogStreamPath(hdfsPath string) bool {
+	for _, dir := range strings.Split(logStreamDirs, ",") {
+		dir = strings.TrimSpace(dir)
+		if dir != "" && strings.HasPrefix(hdfsPath, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Creates the stream of a staging file which has the content of the file in HDFS
+func newLogStream(file *FileINode, staging *os.File, size int64) *LogStream {
+	return &LogStream{file: file, staging: staging, streamed: size, end: size}
+}
+
+// Returns true if the file is streamed, false if it has to be uploaded as a whole
+func (s *LogStream) Active() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return !s.broken
+}
+
+// Returns the number of bytes waiting to be streamed
+func (s *LogStream) Pending() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.end - s.streamed
+}
+
+// Accounts the data written to the staging file
+func (s *LogStream) Written(offset int64, n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if offset < s.streamed {
+		s.breakStream("overwrites data which was already streamed")
+		return
+	}
+	if end := offset + int64(n); end > s.end {
+		s.end = end
+	}
+}
+
+// Accounts a truncation of the staging file
+func (s *LogStream) Truncated(size int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if size < s.end {
+		s.breakStream("truncated")
+		return
+	}
+	s.end = size
+}
+
+// Called once the staging file was uploaded as a whole, the file streams again from its end
+func (s *LogStream) Reset(size int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.broken = false
+	s.streamed = size
+	s.end = size
+}
+
+func (s *LogStream) breakStream(reason string) {
+	if s.broken {
+		return
+	}
+	logwarn("Stopped streaming, the file is uploaded on flush", s.file.logInfo(Fields{Operation: Stream, Message: reason}))
+	s.broken = true
+	s.closeWriter()
+}
+
+func (s *LogStream) closeWriter() error {
+	if s.writer == nil {
+		return nil
+	}
+	err := s.writer.Close()
+	s.writer = nil
+	return err
+}
+
+// Appends the pending data to the file in HDFS and makes it visible to readers
+func (s *LogStream) Stream() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.broken || s.end == s.streamed {
+		return nil
+	}
+	s.file.FileSystem.IOScheduler.Acquire(Batch)
+	defer s.file.FileSystem.IOScheduler.Release()
+	start := s.file.FileSystem.Clock.Now()
+	hdfsAccessor := s.file.FileSystem.getDFSConnector()
+	if s.writer == nil {
+		w, err := hdfsAccessor.Append(s.file.AbsolutePath())
+		if err != nil {
+			logerror("Failed to open file for appending", s.file.logInfo(Fields{Operation: Append, Error: err}))
+			return err
+		}
+		// the file in HDFS only has a prefix of the staging file, possibly more than streamed
+		// if a previous append failed in the middle
+		attrs, err := hdfsAccessor.Stat(s.file.AbsolutePath())
+		if err != nil {
+			w.Close()
+			return err
+		}
+		if int64(attrs.Size) < s.streamed || int64(attrs.Size) > s.end {
+			w.Close()
+			s.breakStream("modified in HDFS")
+			return nil
+		}
+		s.streamed = int64(attrs.Size)
+		s.writer = w
+	}
+	n, err := io.Copy(writerOnly{s.writer}, io.NewSectionReader(s.staging, s.streamed, s.end-s.streamed))
+	if err == nil {
+		err = s.writer.Flush()
+	}
+	if err != nil {
+		logerror("Failed to stream to DFS", s.file.logInfo(Fields{Operation: Stream, Bytes: n, Error: err}))
+		s.closeWriter()
+		return err
+	}
+	s.streamed += n
+	released := n
+	if dirty := atomic.LoadInt64(&s.file.dirtyBytes); released > dirty {
+		released = dirty
+	}
+	atomic.AddInt64(&s.file.dirtyBytes, -released)
+	s.file.FileSystem.Dirty.Release(released)
+	metrics.Record(Stream, s.file.FileSystem.Clock.Now().Sub(start), n, 0, false, nil)
+	logdebug("Streamed to DFS", s.file.logInfo(Fields{Operation: Stream, Bytes: n, FileSize: s.streamed}))
+	return nil
+}
+
+// Streams the pending data and closes the HDFS writer
+func (s *LogStream) Close() error {
+	err := s.Stream()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if closeErr := s.closeWriter(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Streams the pending data of the open log stream files every interval
+// Concurrency: thread safe
+type LogStreamer struct {
+	Interval time.Duration
+	Clock    Clock
+	streams  map[*LogStream]struct{}
+	mutex    sync.Mutex
+	done     chan struct{}
+}
+
+// Creates a streamer without streams
+func NewLogStreamer(interval time.Duration, clock Clock) *LogStreamer {
+	return &LogStreamer{Interval: interval, Clock: clock, streams: make(map[*LogStream]struct{}), done: make(chan struct{})}
+}
+
+func (streamer *LogStreamer) Add(s *LogStream) {
+	streamer.mutex.Lock()
+	defer streamer.mutex.Unlock()
+	streamer.streams[s] = struct{}{}
+}
+
+func (streamer *LogStreamer) Remove(s *LogStream) {
+	streamer.mutex.Lock()
+	defer streamer.mutex.Unlock()
+	delete(streamer.streams, s)
+}
+
+// Streams until closed
+func (streamer *LogStreamer) Run() {
+	for {
+		select {
+		case <-streamer.done:
+			return
+		case <-streamer.Clock.After(streamer.Interval):
+			streamer.mutex.Lock()
+			streams := make([]*LogStream, 0, len(streamer.streams))
+			for s := range streamer.streams {
+				streams = append(streams, s)
+			}
+			streamer.mutex.Unlock()
+			for _, s := range streams {
+				s.Stream()
+			}
+		}
+	}
+}
+
+// Stops the streamer
+func (streamer *LogStreamer) Close() error {
+	close(streamer.done)
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsLogStreamPath(t *testing.T) {
+	saveFlags(t, &logStreamDirs)
+
+	logStreamDirs = "/logs/, /apps/spark/events"
+	assert.True(t, isLogStreamPath("/logs/app.log"))
+	assert.True(t, isLogStreamPath("/apps/spark/events/app-1/events"))
+	assert.False(t, isLogStreamPath("/logs2/app.log"))
+	assert.False(t, isLogStreamPath("/apps/spark/eventsX"))
+	logStreamDirs = "/"
+	assert.True(t, isLogStreamPath("/a"))
+}
+
+// Testing that appended data is streamed on flush, and that a file written in the middle is uploaded as a whole
+func TestLogStream(t *testing.T) {
+	saveFlags(t, &logStreamDirs)
+	logStreamDirs = "/logs"
+
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	appends := 1
+	files := mockRemoteFiles(mockCtrl, hdfsAccessor, &appends)
+	hdfsAccessor.EXPECT().Chown(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/logs", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+
+	root, _ := fs.Root()
+	_, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "app.log",
+		Flags: fuse.OpenWriteOnly | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+	assert.NotNil(t, fileHandle.File.logStream)
+
+	fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("line 1\n"), Offset: 0}, &fuse.WriteResponse{})
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Equal(t, "line 1\n", files["/logs/app.log"].String())
+	assert.Equal(t, int64(0), fs.Dirty.Dirty())
+
+	// the writer stays open, no further append
+	fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("line 2\n"), Offset: 7}, &fuse.WriteResponse{})
+	assert.Nil(t, fileHandle.File.logStream.Stream())
+	assert.Equal(t, "line 1\nline 2\n", files["/logs/app.log"].String())
+
+	// rewriting streamed data is uploaded as a whole on flush, then streaming continues
+	fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("LINE"), Offset: 0}, &fuse.WriteResponse{})
+	assert.False(t, fileHandle.File.logStream.Active())
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Equal(t, "LINE 1\nline 2\n", files["/logs/app.log"].String())
+	assert.True(t, fileHandle.File.logStream.Active())
+
+	appends = 1
+	fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("line 3\n"), Offset: 14}, &fuse.WriteResponse{})
+	assert.Nil(t, fileHandle.Release(nil, nil))
+	assert.Equal(t, "LINE 1\nline 2\nline 3\n", files["/logs/app.log"].String())
+	assert.Nil(t, fileHandle.File.logStream)
+}
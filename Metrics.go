@@ -0,0 +1,206 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Aggregated statistics of one kind of operation
+type OpStats struct {
+	Count       uint64
+	Errors      uint64
+	Retries     uint64
+	CacheHits   uint64
+	Bytes       uint64
+	Duration    time.Duration // total duration of all the operations
+	MaxDuration time.Duration
+}
+
+// Collects per operation statistics. Every completed operation is logged at debug
+// level, and with -metricsLogInterval a summary of the last interval is logged at info level
+// Concurrency: thread safe
+type Metrics struct {
+	ops     map[string]*OpStats
+	classes map[string]map[string]uint64 // failures of each operation by errorClass
+	mutex   sync.Mutex
+}
+
+var metrics = NewMetrics()
+
+func init() {
+	registerAdminCommand("stats", AdminCommand{
+		Help:    "Prints the statistics of the operations and of the calls to the backend",
+		Handler: statsCmd,
+	})
+}
+
+// Creates an empty metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{ops: make(map[string]*OpStats), classes: make(map[string]map[string]uint64)}
+}
+
+// Records a completed operation
+func (m *Metrics) Record(operation string, duration time.Duration, bytes int64, retries int, cacheHit bool, err error) {
+	logdebug("Operation completed", Fields{Operation: operation, Duration: duration, Bytes: bytes, Retries: retries, CacheHit: cacheHit, Error: err})
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats, ok := m.ops[operation]
+	if !ok {
+		stats = &OpStats{}
+		m.ops[operation] = stats
+	}
+	stats.Count++
+	if err != nil {
+		stats.Errors++
+		classes, ok := m.classes[operation]
+		if !ok {
+			classes = make(map[string]uint64)
+			m.classes[operation] = classes
+		}
+		classes[errorClass(err)]++
+	}
+	stats.Retries += uint64(retries)
+	if cacheHit {
+		stats.CacheHits++
+	}
+	if bytes > 0 {
+		stats.Bytes += uint64(bytes)
+	}
+	stats.Duration += duration
+	if duration > stats.MaxDuration {
+		stats.MaxDuration = duration
+	}
+}
+
+// Returns the statistics collected since the last reset, optionally resetting them
+func (m *Metrics) Snapshot(reset bool) map[string]OpStats {
+	snapshot, _ := m.snapshot(reset)
+	return snapshot
+}
+
+// Returns the statistics and the failures by error class of each operation
+func (m *Metrics) snapshot(reset bool) (map[string]OpStats, map[string]map[string]uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	snapshot := make(map[string]OpStats, len(m.ops))
+	for op, stats := range m.ops {
+		snapshot[op] = *stats
+	}
+	classes := make(map[string]map[string]uint64, len(m.classes))
+	for op, counts := range m.classes {
+		classes[op] = make(map[string]uint64, len(counts))
+		for class, count := range counts {
+			classes[op][class] = count
+		}
+	}
+	if reset {
+		m.ops = make(map[string]*OpStats)
+		m.classes = make(map[string]map[string]uint64)
+	}
+	return snapshot, classes
+}
+
+// Logs a summary line per operation every interval, until done is closed
+func (m *Metrics) LogSummaries(interval time.Duration, clock Clock, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-clock.After(interval):
+			m.logSummary(interval)
+		}
+	}
+}
+
+func (m *Metrics) logSummary(interval time.Duration) {
+	snapshot, classes := m.snapshot(true)
+	loginfo("Metrics summary", Fields{Operation: BuildInfoOp, Message: buildInfo().String()})
+	loginfo("Metrics summary", Fields{Operation: CacheHitRatios, Message: formatCacheHitRatios(cacheHitRatios(snapshot))})
+	for _, hint := range tuningHints(snapshot, classes) {
+		logwarn("Tuning hint", Fields{Operation: TuningHint, Message: hint})
+	}
+	for _, op := range sortedOps(snapshot) {
+		fields := opSummaryFields(op, snapshot[op], classes[op])
+		fields[Interval] = interval
+		loginfo("Metrics summary", fields)
+	}
+}
+
+// Returns the fields logging the statistics of an operation
+func opSummaryFields(op string, stats OpStats, classes map[string]uint64) Fields {
+	fields := Fields{
+		Operation:   op,
+		Count:       stats.Count,
+		Errors:      stats.Errors,
+		Retries:     stats.Retries,
+		CacheHits:   stats.CacheHits,
+		Bytes:       stats.Bytes,
+		AvgDuration: stats.Duration / time.Duration(stats.Count),
+		MaxDuration: stats.MaxDuration}
+	if len(classes) > 0 {
+		fields[ErrorClasses] = classes
+	}
+	return fields
+}
+
+// Returns the operations of a snapshot in alphabetical order
+func sortedOps(snapshot map[string]OpStats) []string {
+	ops := make([]string, 0, len(snapshot))
+	for op := range snapshot {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// Prints the statistics collected since the last -metricsLogInterval summary, or since
+// mounting. Operations recorded by InstrumentedHdfsAccessor are the calls to the backend
+func statsCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	for _, line := range filesystem.statsLines() {
+		out.Printf("%s", line)
+	}
+	return nil
+}
+
+// Returns the lines printed by the stats command
+func (filesystem *FileSystem) statsLines() []string {
+	var lines []string
+	snapshot, classes := metrics.snapshot(false)
+	lines = append(lines, fmt.Sprintf("%s %s", BuildInfoOp, buildInfo()))
+	lines = append(lines, fmt.Sprintf("%s %s", CapabilitiesOp, filesystem.Capabilities))
+	lines = append(lines, fmt.Sprintf("%s %s", CacheHitRatios, formatCacheHitRatios(cacheHitRatios(snapshot))))
+	for _, hint := range tuningHints(snapshot, classes) {
+		lines = append(lines, fmt.Sprintf("%s %s", TuningHint, hint))
+	}
+	if failedUploadsDir != "" {
+		count, size := parkedUploadsBacklog()
+		lines = append(lines, fmt.Sprintf("%s count=%d bytes=%d", FailedUploads, count, size))
+	}
+	for _, op := range sortedOps(snapshot) {
+		stats := snapshot[op]
+		line := fmt.Sprintf("%s count=%d errors=%d retries=%d cache_hits=%d bytes=%d avg=%v max=%v", op,
+			stats.Count, stats.Errors, stats.Retries, stats.CacheHits, stats.Bytes,
+			stats.Duration/time.Duration(stats.Count), stats.MaxDuration)
+		names := make([]string, 0, len(classes[op]))
+		for class := range classes[op] {
+			names = append(names, class)
+		}
+		sort.Strings(names)
+		for _, class := range names {
+			line += fmt.Sprintf(" %s=%d", class, classes[op][class])
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Records the completion of the operation retried by op. Returns err
+func (op *Op) Done(operation string, err error) error {
+	metrics.Record(operation, op.RetryPolicy.Clock.Now().Sub(op.Start), 0, op.Attempt-1, false, err)
+	return err
+}
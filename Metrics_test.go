@@ -0,0 +1,71 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing aggregation of operation statistics
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	m.Record(Read, 10*time.Millisecond, 100, 0, true, nil)
+	m.Record(Read, 30*time.Millisecond, 50, 2, false, errors.New("failed"))
+	m.Record(Stat, time.Millisecond, 0, 0, false, nil)
+
+	snapshot := m.Snapshot(true)
+	assert.Equal(t, OpStats{Count: 2, Errors: 1, Retries: 2, CacheHits: 1, Bytes: 150,
+		Duration: 40 * time.Millisecond, MaxDuration: 30 * time.Millisecond}, snapshot[Read])
+	assert.Equal(t, uint64(1), snapshot[Stat].Count)
+	assert.Equal(t, 0, len(m.Snapshot(false)))
+}
+
+// Testing that retried operations record the number of retries
+func TestRetriedOperationMetrics(t *testing.T) {
+	mockClock := &MockClock{}
+	op := NewDefaultRetryPolicy(mockClock).StartOperation()
+	assert.True(t, op.ShouldRetry("test"))
+	metrics.Snapshot(true)
+	assert.Nil(t, op.Done(Mkdir, nil))
+	assert.Equal(t, uint64(1), metrics.Snapshot(true)[Mkdir].Retries)
+}
+
+// Testing that calls to the backend are recorded per attempt with their error class
+func TestInstrumentedHdfsAccessor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ia := NewInstrumentedHdfsAccessor(hdfsAccessor, mockClock)
+	hdfsAccessor.EXPECT().Stat("/a").Return(Attrs{}, syscall.ENOENT)
+	hdfsAccessor.EXPECT().Stat("/b").Return(Attrs{Name: "b"}, nil)
+	hdfsAccessor.EXPECT().Mkdir("/c", os.FileMode(0755)).Return(errors.New("i/o timeout"))
+
+	metrics.Snapshot(true)
+	ia.Stat("/a")
+	ia.Stat("/b")
+	ia.Mkdir("/c", 0755)
+	snapshot, classes := metrics.snapshot(true)
+	assert.Equal(t, uint64(2), snapshot[rpcOp(Stat)].Count)
+	assert.Equal(t, uint64(1), snapshot[rpcOp(Stat)].Errors)
+	assert.Equal(t, map[string]uint64{"ENOENT": 1}, classes[rpcOp(Stat)])
+	assert.Equal(t, map[string]uint64{"other": 1}, classes[rpcOp(Mkdir)])
+
+	reader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/b").Return(reader, nil)
+	reader.EXPECT().Read(gomock.Any()).Return(10, nil)
+	reader.EXPECT().Read(gomock.Any()).Return(0, io.EOF)
+	r, _ := ia.OpenRead("/b")
+	r.Read(make([]byte, 10))
+	r.Read(make([]byte, 10))
+	snapshot = metrics.Snapshot(true)
+	assert.Equal(t, OpStats{Count: 2, Bytes: 10}, snapshot[rpcOp(Read)])
+	assert.Equal(t, uint64(1), snapshot[rpcOp(Open)].Count)
+}
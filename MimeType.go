@@ -0,0 +1,127 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// With -mimeTypeXattr, files have a user.hopsfs.mime_type extended attribute with the type of
+// their content, sniffed from the first bytes of the file, so that data catalog crawlers can
+// classify files without reading them. The type is cached with the inode until the file changes
+const mimeTypeXAttr = "user.hopsfs.mime_type"
+
+// Bytes read to sniff the type, as many as http.DetectContentType considers
+const mimeSniffBytes = 512
+
+// Signatures of data formats http.DetectContentType does not know
+var mimeSignatures = []struct {
+	magic    []byte
+	mimeType string
+}{
+	{[]byte("PAR1"), "application/vnd.apache.parquet"},
+	{[]byte("ORC"), "application/vnd.apache.orc"},
+	{[]byte("Obj\x01"), "application/vnd.apache.avro"},
+	{[]byte("SEQ"), "application/x-hadoop-sequencefile"},
+	{[]byte("\x93NUMPY"), "application/x-npy"},
+	{[]byte("\x89HDF\r\n\x1a\n"), "application/x-hdf5"},
+	{[]byte("ARROW1"), "application/vnd.apache.arrow.file"},
+	{[]byte("\x28\xb5\x2f\xfd"), "application/zstd"},
+}
+
+// Returns the MIME type of a file from its first bytes, falling back to its extension for
+// content which has no signature, e.g., CSV
+func sniffMimeType(name string, head []byte) string {
+	for _, signature := range mimeSignatures {
+		if bytes.HasPrefix(head, signature.magic) {
+			return signature.mimeType
+		}
+	}
+	detected := http.DetectContentType(head)
+	if detected == "application/octet-stream" || strings.HasPrefix(detected, "text/plain") {
+		if byExtension := mime.TypeByExtension(path.Ext(name)); byExtension != "" {
+			return byExtension
+		}
+	}
+	if len(head) == 0 {
+		return "application/x-empty"
+	}
+	return detected
+}
+
+// Cached MIME type of a file, valid while the file has the same size and modification time
+type fileMimeType struct {
+	mimeType string
+	size     uint64
+	mtime    time.Time
+}
+
+// Responds on FUSE Getxattr request
+func (file *FileINode) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if isAclXAttr(req.Name) {
+		return file.getAcl(req.Name, resp)
+	}
+	if req.Name == replaceTargetXAttr {
+		file.lockFileHandles()
+		defer file.unlockFileHandles()
+		if file.replaceTarget == "" {
+			return fuse.ErrNoXattr
+		}
+		resp.Xattr = []byte(file.replaceTarget)
+		return nil
+	}
+	if req.Name == storagePolicyXAttr {
+		value, err := file.FileSystem.getStoragePolicyXAttr(file.AbsolutePath())
+		if err != nil {
+			return err
+		}
+		resp.Xattr = value
+		return nil
+	}
+	if req.Name == erasureCodingXAttr {
+		value, err := file.FileSystem.getErasureCodingXAttr(file.AbsolutePath())
+		if err != nil {
+			return err
+		}
+		resp.Xattr = value
+		return nil
+	}
+	if req.Name == birthTimeXAttr {
+		file.lockFile()
+		defer file.unlockFile()
+		resp.Xattr = birthTimeXAttrValue(&file.Attrs)
+		return nil
+	}
+	if req.Name != mimeTypeXAttr || !mimeTypeXattr {
+		return fuse.ErrNoXattr
+	}
+	file.lockFile()
+	defer file.unlockFile()
+	if cached := file.mimeType; cached != nil && cached.size == file.Attrs.Size && cached.mtime.Equal(file.Attrs.Mtime) {
+		resp.Xattr = []byte(cached.mimeType)
+		return nil
+	}
+	reader, err := file.FileSystem.getDFSConnector().OpenRead(file.AbsolutePath())
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	head := make([]byte, mimeSniffBytes)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		logwarn("Failed to read the file to sniff its type", file.logInfo(Fields{Operation: Read, Error: err}))
+		return err
+	}
+	file.mimeType = &fileMimeType{mimeType: sniffMimeType(file.Attrs.Name, head[:n]), size: file.Attrs.Size, mtime: file.Attrs.Mtime}
+	resp.Xattr = []byte(file.mimeType.mimeType)
+	return nil
+}
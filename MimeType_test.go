@@ -0,0 +1,51 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSniffMimeType(t *testing.T) {
+	assert.Equal(t, "application/vnd.apache.parquet", sniffMimeType("part-0.parquet", []byte("PAR1\x15\x04")))
+	assert.Equal(t, "application/vnd.apache.orc", sniffMimeType("data", []byte("ORC\x0a")))
+	assert.Equal(t, "application/x-gzip", sniffMimeType("data.gz", []byte("\x1f\x8b\x08\x00")))
+	assert.Equal(t, "application/json", sniffMimeType("rows.json", []byte("{\"id\": 1}\n")))
+	assert.Equal(t, "text/plain; charset=utf-8", sniffMimeType("notes", []byte("hello\n")))
+	assert.Equal(t, "application/x-empty", sniffMimeType("empty", nil))
+}
+
+// Testing that the type is sniffed once per version of the file and only with -mimeTypeXattr
+func TestMimeTypeXattr(t *testing.T) {
+	saveFlags(t, &mimeTypeXattr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	file := root.(*DirINode).NodeFromAttrs(Attrs{Name: "part-0", Mode: 0644, Size: 1000}).(*FileINode)
+
+	resp := &fuse.GetxattrResponse{}
+	assert.Equal(t, fuse.ErrNoXattr, file.Getxattr(nil, &fuse.GetxattrRequest{Name: mimeTypeXAttr}, resp))
+
+	mimeTypeXattr = true
+	reader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/part-0").Return(reader, nil).Times(2)
+	reader.EXPECT().Read(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		return copy(b, "PAR1"), io.EOF
+	}).Times(2)
+	reader.EXPECT().Close().Return(nil).Times(2)
+
+	assert.Nil(t, file.Getxattr(nil, &fuse.GetxattrRequest{Name: mimeTypeXAttr}, resp))
+	assert.Equal(t, "application/vnd.apache.parquet", string(resp.Xattr))
+	assert.Nil(t, file.Getxattr(nil, &fuse.GetxattrRequest{Name: mimeTypeXAttr}, resp))
+
+	file.Attrs.Size = 2000
+	assert.Nil(t, file.Getxattr(nil, &fuse.GetxattrRequest{Name: mimeTypeXAttr}, resp))
+	assert.Equal(t, fuse.ErrNoXattr, file.Getxattr(nil, &fuse.GetxattrRequest{Name: "user.other"}, resp))
+}
@@ -0,0 +1,111 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2/hadoopconf"
+)
+
+// The namenode argument of the mount is a comma separated list of namenode:port, or the ID of a
+// nameservice of the Hadoop configuration in $HADOOP_CONF_DIR or $HADOOP_HOME/conf, e.g., prod
+// or hdfs://prod, whose namenodes are those of dfs.ha.namenodes.prod. A connection is opened to
+// the namenode which served the previous one or, once it is unreachable or standby, to the next
+// namenode of the list, so that the metadata operations which failed, and are retried, are
+// served by the new active namenode. A namenode which failed is only tried again after the
+// others for -namenodeFailureBackoff. Every failover is logged, and the namenode of the last
+// connection is reported by the status command
+var namenodeFailureBackoff time.Duration
+
+// Returns the namenodes of the comma separated namenode:port list, or of the nameservice
+func resolveNamenodes(addresses string, conf hadoopconf.HadoopConf) ([]string, error) {
+	nameservice := strings.TrimSuffix(strings.TrimPrefix(addresses, "hdfs://"), "/")
+	ids, ok := conf["dfs.ha.namenodes."+nameservice]
+	if strings.Contains(nameservice, ",") || strings.Contains(nameservice, ":") || !ok {
+		return strings.Split(addresses, ","), nil
+	}
+	var namenodes []string
+	for _, id := range strings.Split(ids, ",") {
+		key := fmt.Sprintf("dfs.namenode.rpc-address.%s.%s", nameservice, strings.TrimSpace(id))
+		address, ok := conf[key]
+		if !ok {
+			return nil, fmt.Errorf("%s is not set for the namenode %s of the nameservice %s", key, id, nameservice)
+		}
+		namenodes = append(namenodes, address)
+	}
+	return namenodes, nil
+}
+
+// Returns the namenodes of the argument of the mount, resolving a nameservice with the Hadoop
+// configuration of the environment
+func resolveNamenodeArg(addresses string) ([]string, error) {
+	conf, err := hadoopconf.LoadFromEnvironment()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Hadoop configuration: %v", err)
+	}
+	return resolveNamenodes(addresses, conf)
+}
+
+// Order in which the namenodes are connected to: the one of the last connection, then those
+// which did not fail recently, then those which did, the oldest failure first
+// Concurrency: thread safe
+type NamenodeFailover struct {
+	Addresses []string
+	Clock     Clock
+
+	mutex    sync.Mutex
+	active   string
+	failedAt map[string]time.Time
+}
+
+// Creates the failover of the namenodes
+func NewNamenodeFailover(addresses []string, clock Clock) *NamenodeFailover {
+	return &NamenodeFailover{Addresses: addresses, Clock: clock, failedAt: map[string]time.Time{}}
+}
+
+// Returns the namenodes in the order they are tried
+func (failover *NamenodeFailover) Candidates() []string {
+	failover.mutex.Lock()
+	defer failover.mutex.Unlock()
+	now := failover.Clock.Now()
+	var healthy, failed []string
+	for _, address := range failover.Addresses {
+		if at, ok := failover.failedAt[address]; ok && now.Sub(at) < namenodeFailureBackoff {
+			failed = append(failed, address)
+		} else if address == failover.active {
+			healthy = append([]string{address}, healthy...)
+		} else {
+			healthy = append(healthy, address)
+		}
+	}
+	sort.SliceStable(failed, func(i, j int) bool { return failover.failedAt[failed[i]].Before(failover.failedAt[failed[j]]) })
+	return append(healthy, failed...)
+}
+
+// Records that the namenode could not be connected to or is standby
+func (failover *NamenodeFailover) Failed(address string, err error) {
+	failover.mutex.Lock()
+	defer failover.mutex.Unlock()
+	failover.failedAt[address] = failover.Clock.Now()
+	if len(failover.Addresses) > 1 {
+		logwarn(fmt.Sprintf("Namenode %s is unavailable", address), Fields{Operation: Connect, Error: err})
+	}
+}
+
+// Records the namenode of a new connection
+func (failover *NamenodeFailover) Connected(address string) {
+	failover.mutex.Lock()
+	defer failover.mutex.Unlock()
+	delete(failover.failedAt, address)
+	if failover.active != "" && failover.active != address {
+		loginfo(fmt.Sprintf("Failed over from namenode %s to %s", failover.active, address), Fields{Operation: Connect})
+		backendHealth.failedOver()
+	}
+	failover.active = address
+	backendHealth.connected(address)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2/hadoopconf"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that a nameservice is resolved to its namenodes, and that namenode lists are kept
+func TestResolveNamenodes(t *testing.T) {
+	conf := hadoopconf.HadoopConf{
+		"dfs.ha.namenodes.prod":             "nn1, nn2",
+		"dfs.namenode.rpc-address.prod.nn1": "host1:8020",
+		"dfs.namenode.rpc-address.prod.nn2": "host2:8020",
+		"dfs.ha.namenodes.broken":           "nn1",
+	}
+	for _, arg := range []string{"prod", "hdfs://prod", "hdfs://prod/"} {
+		namenodes, err := resolveNamenodes(arg, conf)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"host1:8020", "host2:8020"}, namenodes)
+	}
+	namenodes, err := resolveNamenodes("host1:8020,host3:8020", conf)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"host1:8020", "host3:8020"}, namenodes)
+	namenodes, _ = resolveNamenodes("host1:8020", nil)
+	assert.Equal(t, []string{"host1:8020"}, namenodes)
+	_, err = resolveNamenodes("broken", conf)
+	assert.NotNil(t, err)
+}
+
+// Testing that the namenode of the last connection is tried first, and those which failed last
+func TestNamenodeFailover(t *testing.T) {
+	saveFlags(t, &namenodeFailureBackoff)
+	namenodeFailureBackoff = time.Minute
+	backendHealth = BackendHealth{}
+	defer func() { backendHealth = BackendHealth{} }()
+	clock := &MockClock{}
+	failover := NewNamenodeFailover([]string{"nn1", "nn2", "nn3"}, clock)
+	assert.Equal(t, []string{"nn1", "nn2", "nn3"}, failover.Candidates())
+
+	failover.Failed("nn1", errors.New("connection refused"))
+	clock.NotifyTimeElapsed(time.Second)
+	failover.Failed("nn2", errors.New("standby"))
+	failover.Connected("nn3")
+	assert.Equal(t, []string{"nn3", "nn1", "nn2"}, failover.Candidates())
+	assert.Equal(t, "nn3", backendHealth.status(nil).Namenode)
+	assert.Equal(t, 0, backendHealth.status(nil).Failovers)
+
+	// the namenode of the connection is tried first once the others recovered
+	clock.NotifyTimeElapsed(time.Minute)
+	assert.Equal(t, []string{"nn3", "nn1", "nn2"}, failover.Candidates())
+	failover.Failed("nn3", errors.New("connection reset"))
+	failover.Connected("nn1")
+	assert.Equal(t, []string{"nn1", "nn2", "nn3"}, failover.Candidates())
+	assert.Equal(t, "nn1", backendHealth.status(nil).Namenode)
+	assert.Equal(t, 1, backendHealth.status(nil).Failovers)
+}
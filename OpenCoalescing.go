@@ -0,0 +1,72 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"time"
+)
+
+// The handles of a file which is open several times share one proxy, and so one HDFS reader
+// with its block locations and datanode connection. Processes opening the same file one after
+// another, e.g., the workers of a job loading the same model, open a new reader each, with a
+// namenode RPC for the block locations. With -openCoalesceWindow, the reader of the last
+// read-only handle of a file is kept that long after it is closed, and an open of the file in
+// the meantime takes it over instead of opening a new one, as long as the reader is of the
+// current version of the file, i.e., of the size and modification time the file has in the
+// attribute cache
+var openCoalesceWindow time.Duration
+
+// Keeps the proxy of the last read-only handle for reuse by the next open, returns false if it
+// has to be closed. Called with the file handles locked
+func (file *FileINode) linger(proxy *RemoteROFileProxy) bool {
+	if openCoalesceWindow <= 0 {
+		return false
+	}
+	if _, ok := proxy.hdfsReader.(VersionedReader); !ok {
+		return false
+	}
+	file.closeLingering()
+	file.lingering = proxy
+	file.lingerExpires = file.FileSystem.Clock.Monotonic() + openCoalesceWindow
+	time.AfterFunc(openCoalesceWindow, func() {
+		file.lockFileHandles()
+		defer file.unlockFileHandles()
+		if file.lingering == proxy && file.FileSystem.Clock.Monotonic() >= file.lingerExpires {
+			file.closeLingering()
+		}
+	})
+	return true
+}
+
+// Closes the kept proxy. Called with the file handles locked
+func (file *FileINode) closeLingering() {
+	if file.lingering == nil {
+		return
+	}
+	if err := file.lingering.Close(); err != nil {
+		logwarn("Failed to close reader", file.logInfo(Fields{Operation: Close, Error: err}))
+	}
+	file.lingering = nil
+}
+
+// Returns the kept proxy if it can serve a new open, nil otherwise. Called with the file handles locked
+func (file *FileINode) reuseLingering() *RemoteROFileProxy {
+	if openCoalesceWindow <= 0 {
+		return nil
+	}
+	proxy := file.lingering
+	reused := proxy != nil && file.FileSystem.Clock.Monotonic() < file.lingerExpires && proxy.path == file.AbsolutePath()
+	if reused {
+		version, err := proxy.hdfsReader.(VersionedReader).Version()
+		reused = err == nil && version.Size == int64(file.Attrs.Size) && version.Mtime == file.Attrs.Mtime.UnixNano()
+	}
+	metrics.Record(ReaderReuseOp, 0, 0, 0, reused, nil)
+	if !reused {
+		file.closeLingering()
+		return nil
+	}
+	file.lingering = nil
+	// the next handle may read differently
+	proxy.pattern = ReadPattern{}
+	return proxy
+}
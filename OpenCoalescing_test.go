@@ -0,0 +1,57 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that an open within -openCoalesceWindow reuses the reader of the closed handle
+func TestOpenCoalescing(t *testing.T) {
+	saveFlags(t, &openCoalesceWindow)
+	openCoalesceWindow = time.Minute
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	file := root.(*DirINode).NodeFromAttrs(Attrs{Name: "weights", Mode: 0644, Size: 100, Mtime: time.Unix(0, 1)}).(*FileINode)
+
+	var readers []*MockReadSeekCloserWithPseudoRandomContent
+	hdfsAccessor.EXPECT().OpenRead("/weights").DoAndReturn(func(path string) (ReadSeekCloser, error) {
+		reader := &MockReadSeekCloserWithPseudoRandomContent{FileSize: 100}
+		readers = append(readers, reader)
+		return versionedPseudoRandomReader{reader}, nil
+	}).Times(3)
+	open := func() {
+		h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+		resp := &fuse.ReadResponse{Data: make([]byte, 10)}
+		assert.Nil(t, h.(*FileHandle).Read(nil, &fuse.ReadRequest{Offset: 0, Size: 10}, resp))
+		assert.Equal(t, 10, len(resp.Data))
+		assert.Nil(t, h.(*FileHandle).Release(nil, nil))
+	}
+
+	open()
+	open()
+	assert.Equal(t, 1, len(readers))
+	assert.False(t, readers[0].IsClosed)
+
+	// a changed file is not read with the kept reader
+	file.Attrs.Size = 200
+	open()
+	assert.Equal(t, 2, len(readers))
+	assert.True(t, readers[0].IsClosed)
+	file.Attrs.Size = 100
+
+	// nor is a file opened after the window
+	mockClock.NotifyTimeElapsed(2 * time.Minute)
+	open()
+	assert.Equal(t, 3, len(readers))
+	assert.True(t, readers[1].IsClosed)
+}
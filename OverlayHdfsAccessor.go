@@ -0,0 +1,631 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// With -overlayDir, HopsFS is the read-only lower layer of the mount and a local directory the
+// upper layer, like overlayfs, e.g., for experiments which must not touch a shared dataset until
+// they are blessed. Files created or written through the mount are kept in the upper layer under
+// their HDFS path, and reads fall through to HopsFS for the files which are not. Removing a file
+// of HopsFS leaves a whiteout, a ".wh.<name>" file hiding it, and a directory created where one
+// was removed is marked opaque with a ".wh..opq" file, hiding the directory of HopsFS. Files of
+// HopsFS are copied up when they are appended to or their attributes change, and directories of
+// HopsFS cannot be renamed, mv then copies them. The commit admin command pushes the upper layer
+// to HopsFS and empties it
+const (
+	overlayWhiteoutPrefix = ".wh."
+	overlayOpaqueMarker   = ".wh..opq"
+)
+
+var overlayDir string
+
+func init() {
+	registerAdminCommand("commit", AdminCommand{
+		Help:    "Pushes the changes kept in -overlayDir to HopsFS",
+		Handler: commitCmd,
+	})
+}
+
+// Serves the paths written through the mount from the upper layer, other paths from Lower
+type OverlayHdfsAccessor struct {
+	Lower HdfsAccessor
+	Dir   string // root of the upper layer, mirrors the HDFS paths
+}
+
+var _ HdfsAccessor = (*OverlayHdfsAccessor)(nil) // ensure OverlayHdfsAccessor implements HdfsAccessor
+
+// Creates an instance of OverlayHdfsAccessor
+func NewOverlayHdfsAccessor(lower HdfsAccessor, dir string) *OverlayHdfsAccessor {
+	return &OverlayHdfsAccessor{Lower: lower, Dir: dir}
+}
+
+// Returns the path of an HDFS path in the upper layer
+func (oa *OverlayHdfsAccessor) upper(p string) string {
+	return filepath.Join(oa.Dir, filepath.FromSlash(p))
+}
+
+// Returns the path of the whiteout hiding an HDFS path
+func (oa *OverlayHdfsAccessor) whiteout(p string) string {
+	return filepath.Join(oa.upper(path.Dir(p)), overlayWhiteoutPrefix+path.Base(p))
+}
+
+// Returns true if the path of HopsFS is hidden by a whiteout or an opaque directory above it
+func (oa *OverlayHdfsAccessor) lowerHidden(p string) bool {
+	for dir := path.Clean(p); ; dir = path.Dir(dir) {
+		if dir != "/" && localExists(oa.whiteout(dir)) {
+			return true
+		}
+		if dir != p && localExists(filepath.Join(oa.upper(dir), overlayOpaqueMarker)) {
+			return true
+		}
+		if dir == "/" {
+			return false
+		}
+	}
+}
+
+func localExists(localPath string) bool {
+	_, err := os.Lstat(localPath)
+	return err == nil
+}
+
+// Returns true if the path exists in HopsFS and is not hidden
+func (oa *OverlayHdfsAccessor) inLower(p string) bool {
+	if oa.lowerHidden(p) {
+		return false
+	}
+	_, err := oa.Lower.Stat(p)
+	return err == nil
+}
+
+// Creates the directories of the upper layer above a path
+func (oa *OverlayHdfsAccessor) makeParents(p string) error {
+	return os.MkdirAll(oa.upper(path.Dir(p)), 0755)
+}
+
+// Copies a file of HopsFS into the upper layer
+func (oa *OverlayHdfsAccessor) copyUp(p string) error {
+	attrs, err := oa.Lower.Stat(p)
+	if err != nil {
+		return err
+	}
+	if attrs.Mode.IsDir() {
+		return syscall.EROFS
+	}
+	reader, err := oa.Lower.OpenRead(p)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := oa.makeParents(p); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(oa.upper(p), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, attrs.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, reader)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(oa.upper(p), attrs.Mtime, attrs.Mtime)
+	}
+	if err != nil {
+		os.Remove(oa.upper(p))
+		return err
+	}
+	loginfo("Copied up to the overlay", Fields{Operation: OverlayOp, Path: p, Bytes: attrs.Size})
+	return nil
+}
+
+// Copies a file of HopsFS into the upper layer if it is not there yet
+func (oa *OverlayHdfsAccessor) ensureUpper(p string) error {
+	if localExists(oa.upper(p)) {
+		return nil
+	}
+	if !oa.inLower(p) {
+		return syscall.ENOENT
+	}
+	return oa.copyUp(p)
+}
+
+// Returns the attributes of an entry of the upper layer
+func overlayAttrs(name string, info os.FileInfo) Attrs {
+	attrs := Attrs{Name: name, Mode: info.Mode(), Size: uint64(info.Size()), Mtime: info.ModTime(), Ctime: info.ModTime()}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		attrs.Uid = stat.Uid
+		attrs.Gid = stat.Gid
+		attrs.Group = idMapper.GroupName(stat.Gid)
+	}
+	if info.IsDir() {
+		attrs.Size = 0
+	}
+	return attrs
+}
+
+// Opens HDFS file for reading
+func (oa *OverlayHdfsAccessor) OpenRead(p string) (ReadSeekCloser, error) {
+	if info, err := os.Stat(oa.upper(p)); err == nil && info.Mode().IsRegular() {
+		f, err := os.Open(oa.upper(p))
+		if err != nil {
+			return nil, err
+		}
+		return &overlayReader{file: f}, nil
+	}
+	if oa.lowerHidden(p) {
+		return nil, syscall.ENOENT
+	}
+	return oa.Lower.OpenRead(p)
+}
+
+// Opens HDFS file for writing
+func (oa *OverlayHdfsAccessor) CreateFile(p string, mode os.FileMode, overwrite bool) (HdfsWriter, error) {
+	if !overwrite {
+		if _, err := oa.Stat(p); err == nil {
+			return nil, syscall.EEXIST
+		}
+	}
+	if err := oa.makeParents(p); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(oa.upper(p), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(oa.whiteout(p))
+	return &overlayWriter{file: f}, nil
+}
+
+// Opens HDFS file for appending
+func (oa *OverlayHdfsAccessor) Append(p string) (HdfsWriter, error) {
+	if err := oa.ensureUpper(p); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(oa.upper(p), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &overlayWriter{file: f}, nil
+}
+
+// Enumerates HDFS directory, the entries of the upper layer replace those of HopsFS
+func (oa *OverlayHdfsAccessor) ReadDir(p string) ([]Attrs, error) {
+	entries := make(map[string]Attrs)
+	var lowerErr error = syscall.ENOENT
+	if !oa.lowerHidden(p) && !localExists(filepath.Join(oa.upper(p), overlayOpaqueMarker)) {
+		var lower []Attrs
+		if lower, lowerErr = oa.Lower.ReadDir(p); lowerErr == nil {
+			for _, attrs := range lower {
+				entries[attrs.Name] = attrs
+			}
+		}
+	}
+	infos, err := ioutil.ReadDir(oa.upper(p))
+	if err != nil {
+		if lowerErr != nil {
+			return nil, lowerErr
+		}
+		infos = nil
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasPrefix(name, overlayWhiteoutPrefix) {
+			delete(entries, strings.TrimPrefix(name, overlayWhiteoutPrefix))
+			continue
+		}
+		if lowerAttrs, ok := entries[name]; ok && info.IsDir() && lowerAttrs.Mode.IsDir() {
+			continue // the directory of HopsFS keeps its attributes
+		}
+		entries[name] = overlayAttrs(name, info)
+	}
+	result := make([]Attrs, 0, len(entries))
+	for _, attrs := range entries {
+		result = append(result, attrs)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// Retrieves file/directory attributes, directories in both layers have the attributes of HopsFS
+func (oa *OverlayHdfsAccessor) Stat(p string) (Attrs, error) {
+	info, err := os.Lstat(oa.upper(p))
+	if err == nil && !info.IsDir() {
+		return overlayAttrs(path.Base(p), info), nil
+	}
+	if oa.lowerHidden(p) {
+		if err == nil {
+			return overlayAttrs(path.Base(p), info), nil
+		}
+		return Attrs{}, syscall.ENOENT
+	}
+	attrs, lowerErr := oa.Lower.Stat(p)
+	if lowerErr != nil && err == nil {
+		return overlayAttrs(path.Base(p), info), nil
+	}
+	return attrs, lowerErr
+}
+
+// Retrieves HDFS usage
+func (oa *OverlayHdfsAccessor) StatFs() (FsInfo, error) {
+	return oa.Lower.StatFs()
+}
+
+// Creates a directory in the upper layer, opaque if it replaces a removed directory
+func (oa *OverlayHdfsAccessor) Mkdir(p string, mode os.FileMode) error {
+	if _, err := oa.Stat(p); err == nil {
+		return syscall.EEXIST
+	}
+	if err := oa.makeParents(p); err != nil {
+		return err
+	}
+	if err := os.Mkdir(oa.upper(p), mode.Perm()|0700); err != nil {
+		return err
+	}
+	if localExists(oa.whiteout(p)) {
+		os.Remove(oa.whiteout(p))
+		return ioutil.WriteFile(filepath.Join(oa.upper(p), overlayOpaqueMarker), nil, 0600)
+	}
+	return nil
+}
+
+// Removes a file or directory, leaving a whiteout if it is in HopsFS
+func (oa *OverlayHdfsAccessor) Remove(p string) error {
+	attrs, err := oa.Stat(p)
+	if err != nil {
+		return err
+	}
+	if attrs.Mode.IsDir() {
+		entries, err := oa.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return syscall.ENOTEMPTY
+		}
+	}
+	return oa.RemoveAll(p)
+}
+
+// Removes a file or directory recursively, leaving a whiteout if it is in HopsFS
+func (oa *OverlayHdfsAccessor) RemoveAll(p string) error {
+	inLower := oa.inLower(p)
+	if !inLower && !localExists(oa.upper(p)) {
+		return syscall.ENOENT
+	}
+	if err := os.RemoveAll(oa.upper(p)); err != nil {
+		return err
+	}
+	if inLower {
+		if err := oa.makeParents(p); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(oa.whiteout(p), nil, 0600)
+	}
+	return nil
+}
+
+// Renames a file or directory. Files of HopsFS are copied up, directories of HopsFS fail with EXDEV
+func (oa *OverlayHdfsAccessor) Rename(oldPath string, newPath string) error {
+	inLower := oa.inLower(oldPath)
+	if inLower {
+		attrs, err := oa.Lower.Stat(oldPath)
+		if err != nil {
+			return err
+		}
+		if attrs.Mode.IsDir() {
+			return syscall.EXDEV
+		}
+	}
+	if err := oa.ensureUpper(oldPath); err != nil {
+		return err
+	}
+	if err := oa.makeParents(newPath); err != nil {
+		return err
+	}
+	replacesLowerDir := false
+	if info, err := os.Stat(oa.upper(oldPath)); err == nil && info.IsDir() {
+		if attrs, err := oa.Stat(newPath); err == nil && attrs.Mode.IsDir() {
+			if entries, err := oa.ReadDir(newPath); err != nil || len(entries) > 0 {
+				return syscall.ENOTEMPTY
+			}
+			replacesLowerDir = oa.inLower(newPath)
+			os.RemoveAll(oa.upper(newPath))
+		}
+	}
+	if err := os.Rename(oa.upper(oldPath), oa.upper(newPath)); err != nil {
+		return err
+	}
+	os.Remove(oa.whiteout(newPath))
+	if replacesLowerDir {
+		ioutil.WriteFile(filepath.Join(oa.upper(newPath), overlayOpaqueMarker), nil, 0600)
+	}
+	if inLower {
+		return ioutil.WriteFile(oa.whiteout(oldPath), nil, 0600)
+	}
+	return nil
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (oa *OverlayHdfsAccessor) EnsureConnected() error {
+	return oa.Lower.EnsureConnected()
+}
+
+// Changes the owner and group of the file, in the upper layer. The owner is kept if the
+// mount may not change it
+func (oa *OverlayHdfsAccessor) Chown(p string, owner, group string) error {
+	if err := oa.ensureUpper(p); err != nil {
+		return err
+	}
+	uid, gid := -1, -1
+	if owner != "" {
+		uid = int(localUid(owner))
+	}
+	if group != "" {
+		gid = int(localGid(group))
+	}
+	if err := os.Lchown(oa.upper(p), uid, gid); err != nil {
+		logdebug("Unable to change the owner in the overlay", Fields{Operation: Chown, Path: p, Error: err})
+	}
+	return nil
+}
+
+// Changes the mode of the file, in the upper layer
+func (oa *OverlayHdfsAccessor) Chmod(p string, mode os.FileMode) error {
+	if err := oa.ensureUpper(p); err != nil {
+		return err
+	}
+	return os.Chmod(oa.upper(p), mode.Perm())
+}
+
+// Changes the modification time of the file, in the upper layer
+func (oa *OverlayHdfsAccessor) Chtimes(p string, mtime time.Time) error {
+	if err := oa.ensureUpper(p); err != nil {
+		return err
+	}
+	return os.Chtimes(oa.upper(p), mtime, mtime)
+}
+
+// Retrieves the HDFS checksum of the file, not available for the files of the upper layer
+func (oa *OverlayHdfsAccessor) Checksum(p string) (FileChecksum, error) {
+	if localExists(oa.upper(p)) {
+		return FileChecksum{}, syscall.ENOTSUP
+	}
+	return oa.Lower.Checksum(p)
+}
+
+// Retrieves the extended attributes of the file in HopsFS, none for the files of the upper layer
+func (oa *OverlayHdfsAccessor) GetXAttrs(p string) (map[string]string, error) {
+	if info, err := os.Stat(oa.upper(p)); err == nil && !info.IsDir() {
+		return map[string]string{}, nil
+	}
+	return oa.Lower.GetXAttrs(p)
+}
+
+// Changes are kept in the upper layer, which has no extended attributes
+func (oa *OverlayHdfsAccessor) SetXAttr(p string, name, value string) error {
+	return syscall.ENOTSUP
+}
+
+// Retrieves the ACL entries of the file in HopsFS, none for the files of the upper layer
+func (oa *OverlayHdfsAccessor) GetAcl(p string) ([]AclEntry, error) {
+	if info, err := os.Stat(oa.upper(p)); err == nil && !info.IsDir() {
+		return nil, nil
+	}
+	return oa.Lower.GetAcl(p)
+}
+
+// Changes are kept in the upper layer, which has no ACLs
+func (oa *OverlayHdfsAccessor) SetAcl(p string, entries []AclEntry) error {
+	return syscall.ENOTSUP
+}
+
+// Sets the storage policy of the file in HopsFS, the files of the upper layer are local
+func (oa *OverlayHdfsAccessor) SetStoragePolicy(p string, policy string) error {
+	if info, err := os.Stat(oa.upper(p)); err == nil && !info.IsDir() {
+		return syscall.ENOTSUP
+	}
+	return oa.Lower.SetStoragePolicy(p, policy)
+}
+
+// Sets the erasure coding policy of the directory in HopsFS
+func (oa *OverlayHdfsAccessor) SetErasureCodingPolicy(p string, policy string) error {
+	return oa.Lower.SetErasureCodingPolicy(p, policy)
+}
+
+// Retrieves the totals of a directory tree in HopsFS, without the upper layer
+func (oa *OverlayHdfsAccessor) GetContentSummary(p string) (ContentSummary, error) {
+	return oa.Lower.GetContentSummary(p)
+}
+
+// Retrieves the configuration of the namenode
+func (oa *OverlayHdfsAccessor) ServerDefaults() (ServerDefaults, error) {
+	return oa.Lower.ServerDefaults()
+}
+
+// Truncates the file, in the upper layer
+func (oa *OverlayHdfsAccessor) Truncate(p string, size int64) (bool, error) {
+	if err := oa.ensureUpper(p); err != nil {
+		return false, err
+	}
+	return true, os.Truncate(oa.upper(p), size)
+}
+
+// Creates a snapshot of a snapshottable directory in HopsFS
+func (oa *OverlayHdfsAccessor) CreateSnapshot(p, name string) (string, error) {
+	return oa.Lower.CreateSnapshot(p, name)
+}
+
+// Close current meta connection if needed
+func (oa *OverlayHdfsAccessor) Close() error {
+	return oa.Lower.Close()
+}
+
+func commitCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	for _, hdfsAccessor := range filesystem.HdfsAccessors {
+		if overlay, ok := hdfsAccessor.(*OverlayHdfsAccessor); ok {
+			start := filesystem.Clock.Now()
+			err := overlay.Commit(out)
+			metrics.Record(OverlayOp, filesystem.Clock.Now().Sub(start), 0, 0, false, err)
+			return err
+		}
+	}
+	return fmt.Errorf("-overlayDir is not set")
+}
+
+// Pushes the upper layer to HopsFS and empties it: whiteouts remove the paths from HopsFS,
+// directories are created and files uploaded. Prints a line per change
+func (oa *OverlayHdfsAccessor) Commit(out *AdminOutput) error {
+	if !localExists(oa.Dir) {
+		return nil
+	}
+	return oa.commitDir("/", out)
+}
+
+func (oa *OverlayHdfsAccessor) commitDir(dir string, out *AdminOutput) error {
+	infos, err := ioutil.ReadDir(oa.upper(dir))
+	if err != nil {
+		return err
+	}
+	// whiteouts first, so that an entry replacing a removed one is not removed with it
+	sort.SliceStable(infos, func(i, j int) bool {
+		return strings.HasPrefix(infos[i].Name(), overlayWhiteoutPrefix) && !strings.HasPrefix(infos[j].Name(), overlayWhiteoutPrefix)
+	})
+	for _, info := range infos {
+		name := info.Name()
+		local := filepath.Join(oa.upper(dir), name)
+		switch {
+		case name == overlayOpaqueMarker:
+			entries, err := oa.Lower.ReadDir(dir)
+			if err != nil && err != syscall.ENOENT {
+				return err
+			}
+			for _, entry := range entries {
+				if err := oa.Lower.RemoveAll(path.Join(dir, entry.Name)); err != nil {
+					return err
+				}
+			}
+			os.Remove(local)
+		case strings.HasPrefix(name, overlayWhiteoutPrefix):
+			p := path.Join(dir, strings.TrimPrefix(name, overlayWhiteoutPrefix))
+			if err := oa.Lower.RemoveAll(p); err != nil && err != syscall.ENOENT {
+				return err
+			}
+			os.Remove(local)
+			out.Printf("removed %s", p)
+		case info.IsDir():
+			p := path.Join(dir, name)
+			if attrs, err := oa.Lower.Stat(p); err != nil || !attrs.Mode.IsDir() {
+				if err == nil {
+					// a file of HopsFS replaced by a directory
+					if err := oa.Lower.Remove(p); err != nil {
+						return err
+					}
+				}
+				if err := oa.Lower.Mkdir(p, info.Mode()); err != nil {
+					return err
+				}
+				out.Printf("created %s/", p)
+			}
+			if err := oa.commitDir(p, out); err != nil {
+				return err
+			}
+			os.Remove(local)
+		default:
+			p := path.Join(dir, name)
+			if err := oa.commitFile(p, local, info); err != nil {
+				return fmt.Errorf("%s: %v", p, err)
+			}
+			os.Remove(local)
+			out.Printf("uploaded %s (%d bytes)", p, info.Size())
+		}
+	}
+	return nil
+}
+
+func (oa *OverlayHdfsAccessor) commitFile(p string, local string, info os.FileInfo) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if attrs, err := oa.Lower.Stat(p); err == nil && attrs.Mode.IsDir() {
+		if err := oa.Lower.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	preserved := capturePreservedMetadata(oa.Lower, p)
+	w, err := oa.Lower.CreateFile(p, info.Mode(), true)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	preserved.restore(oa.Lower, p)
+	return nil
+}
+
+// Reads a file of the upper layer
+type overlayReader struct {
+	file *os.File
+}
+
+func (r *overlayReader) Seek(pos int64) error {
+	_, err := r.file.Seek(pos, io.SeekStart)
+	return err
+}
+
+func (r *overlayReader) Position() (int64, error) {
+	return r.file.Seek(0, io.SeekCurrent)
+}
+
+func (r *overlayReader) Read(buffer []byte) (int, error) {
+	return r.file.Read(buffer)
+}
+
+func (r *overlayReader) Close() error {
+	return r.file.Close()
+}
+
+// Writes a file of the upper layer
+type overlayWriter struct {
+	file *os.File
+}
+
+func (w *overlayWriter) Seek(pos int64) error {
+	_, err := w.file.Seek(pos, io.SeekStart)
+	return err
+}
+
+func (w *overlayWriter) Write(buffer []byte) (int, error) {
+	return w.file.Write(buffer)
+}
+
+func (w *overlayWriter) Flush() error {
+	return nil
+}
+
+func (w *overlayWriter) Close() error {
+	return w.file.Close()
+}
+
+func (w *overlayWriter) Truncate() error {
+	return fmt.Errorf("Truncate is not implemented")
+}
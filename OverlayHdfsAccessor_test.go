@@ -0,0 +1,82 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that writes and removes stay in the overlay, and that commit pushes them to HopsFS
+func TestOverlay(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hopsfs-overlay")
+	defer os.RemoveAll(dir)
+	mockCtrl := gomock.NewController(t)
+	lower := NewMockHdfsAccessor(mockCtrl)
+	overlay := NewOverlayHdfsAccessor(lower, dir)
+
+	lower.EXPECT().Stat("/data").Return(Attrs{Name: "data", Mode: os.ModeDir | 0755}, nil).AnyTimes()
+	lower.EXPECT().Stat("/data/a").Return(Attrs{Name: "a", Mode: 0644, Size: 5}, nil).AnyTimes()
+	lower.EXPECT().Stat("/data/b").Return(Attrs{}, syscall.ENOENT).AnyTimes()
+	lower.EXPECT().ReadDir("/data").Return([]Attrs{{Name: "a", Mode: 0644, Size: 5}}, nil).AnyTimes()
+
+	w, err := overlay.CreateFile("/data/b", 0644, false)
+	assert.Nil(t, err)
+	w.Write([]byte("new"))
+	assert.Nil(t, w.Close())
+	attrs, err := overlay.Stat("/data/b")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), attrs.Size)
+	entries, err := overlay.ReadDir("/data")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	// the file of HopsFS is hidden by a whiteout, HopsFS is not changed
+	assert.Nil(t, overlay.Remove("/data/a"))
+	_, err = overlay.Stat("/data/a")
+	assert.Equal(t, syscall.ENOENT, err)
+	entries, _ = overlay.ReadDir("/data")
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "b", entries[0].Name)
+
+	writer := NewMockHdfsWriter(mockCtrl)
+	var uploaded []byte
+	lower.EXPECT().RemoveAll("/data/a").Return(nil)
+	lower.EXPECT().CreateFile("/data/b", os.FileMode(0644), true).Return(writer, nil)
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		uploaded = append(uploaded, b...)
+		return len(b), nil
+	})
+	writer.EXPECT().Close().Return(nil)
+	out := &AdminOutput{encoder: json.NewEncoder(ioutil.Discard)}
+	assert.Nil(t, overlay.Commit(out))
+	assert.Equal(t, "new", string(uploaded))
+	left, _ := ioutil.ReadDir(filepath.Join(dir, "data"))
+	assert.Empty(t, left)
+}
+
+// Testing that a directory created where one was removed hides the directory of HopsFS
+func TestOverlayOpaqueDir(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hopsfs-overlay")
+	defer os.RemoveAll(dir)
+	mockCtrl := gomock.NewController(t)
+	lower := NewMockHdfsAccessor(mockCtrl)
+	overlay := NewOverlayHdfsAccessor(lower, dir)
+
+	lower.EXPECT().Stat("/d").Return(Attrs{Name: "d", Mode: os.ModeDir | 0755}, nil).AnyTimes()
+	lower.EXPECT().ReadDir("/d").Return([]Attrs{{Name: "x", Mode: 0644}}, nil).AnyTimes()
+	assert.Nil(t, overlay.RemoveAll("/d"))
+	assert.Nil(t, overlay.Mkdir("/d", 0755))
+	entries, err := overlay.ReadDir("/d")
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+	_, err = overlay.Stat("/d/x")
+	assert.Equal(t, syscall.ENOENT, err)
+}
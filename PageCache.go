@@ -0,0 +1,67 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// Files are never opened with direct I/O, so reads and memory mappings, e.g., numpy memmap
+// or dlopen of shared libraries stored in HopsFS, go through the page cache of the kernel.
+// By default the kernel drops the cached pages of a file whenever it is opened. With
+// -keepPageCache, the pages are kept across opens as long as the file has the size and
+// modification time it had when it was last opened, so that a library or a model loaded by
+// many processes is read from HopsFS once. When a stat shows that the file changed in HopsFS,
+// the cached pages are invalidated, also those of memory mappings which are still mapped
+var keepPageCache bool
+
+// Version of the content which the page cache of the kernel may hold
+type pageCacheVersion struct {
+	size  uint64
+	mtime time.Time
+}
+
+// Returns the flags of the response to an open of the file. Called with the file locked
+func (file *FileINode) pageCacheFlags() fuse.OpenResponseFlags {
+	if !keepPageCache {
+		return 0
+	}
+	file.lockFileHandles()
+	_, remote := file.fileProxy.(*RemoteROFileProxy)
+	local := file.fileProxy != nil && !remote
+	file.unlockFileHandles()
+	if local {
+		// the kernel cached the writes, the attributes are those of the staging file
+		return 0
+	}
+	if file.FileSystem.attrsExpired(&file.Attrs) {
+		if err := file.Parent.LookupAttrs(file.Attrs.Name, &file.Attrs); err != nil {
+			return 0
+		}
+		file.checkPageCache()
+	}
+	if file.pageCache.matches(&file.Attrs) {
+		return fuse.OpenKeepCache
+	}
+	file.pageCache = &pageCacheVersion{size: file.Attrs.Size, mtime: file.Attrs.Mtime}
+	return 0
+}
+
+// Returns true if the cached pages are of the content with the attributes
+func (v *pageCacheVersion) matches(attrs *Attrs) bool {
+	return v != nil && v.size == attrs.Size && v.mtime.Equal(attrs.Mtime)
+}
+
+// Invalidates the pages cached by the kernel if the attributes show the file changed in HopsFS.
+// Called with the file locked after its attributes are fetched
+func (file *FileINode) checkPageCache() {
+	if file.pageCache == nil || file.pageCache.matches(&file.Attrs) {
+		return
+	}
+	loginfo("File changed in HopsFS, invalidating the page cache", file.logInfo(Fields{Operation: Stat, FileSize: file.Attrs.Size}))
+	file.pageCache = nil
+	// notifying the kernel while serving a request for the file could block it
+	go file.FileSystem.invalidateNodeData(file)
+}
@@ -0,0 +1,44 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the page cache is kept across opens of an unchanged file, and dropped once the file changed
+func TestKeepPageCache(t *testing.T) {
+	saveFlags(t, &keepPageCache)
+	keepPageCache = true
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	attrs := Attrs{Name: "lib.so", Mode: 0755, Size: 10, Mtime: time.Unix(100, 0)}
+	hdfsAccessor.EXPECT().Stat("/lib.so").DoAndReturn(func(path string) (Attrs, error) { return attrs, nil }).AnyTimes()
+	file := root.(*DirINode).NodeFromAttrs(attrs).(*FileINode)
+	reader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/lib.so").Return(reader, nil).Times(3)
+	reader.EXPECT().Close().Return(nil).Times(3)
+	open := func() fuse.OpenResponseFlags {
+		resp := &fuse.OpenResponse{}
+		h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, resp)
+		assert.Nil(t, err)
+		assert.Nil(t, h.(*FileHandle).Release(nil, nil))
+		return resp.Flags & fuse.OpenKeepCache
+	}
+
+	assert.Equal(t, fuse.OpenResponseFlags(0), open())
+	assert.Equal(t, fuse.OpenKeepCache, open())
+
+	// the file was rewritten in HopsFS
+	mockClock.NotifyTimeElapsed(10 * time.Second)
+	attrs.Size = 12
+	assert.Equal(t, fuse.OpenResponseFlags(0), open())
+}
@@ -0,0 +1,208 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// With -failedUploadsDir, the staging file of a flush which failed after all its retries,
+// e.g., during an outage of the cluster, is copied to the directory together with a manifest
+// instead of being lost when the file is closed. The application still gets the error.
+// The backlog is printed by the stats command as failed_uploads, and the replay-failed
+// command uploads the parked files once the cluster is back. A parked file is dropped instead
+// if its HDFS file was written again after it was parked, and a newer failure of the same
+// HDFS path replaces the parked file of the older one
+const parkedUploadPrefix = "hopsfs-failed-"
+const parkedManifestSuffix = ".json"
+
+func init() {
+	registerAdminCommand("replay-failed", AdminCommand{
+		Help:    "Uploads the files parked in -failedUploadsDir by failed flushes",
+		Handler: replayFailedCmd,
+	})
+}
+
+// Manifest of a parked upload
+type ParkedUpload struct {
+	Path       string      `json:"path"` // HDFS path the file is uploaded to
+	Mode       os.FileMode `json:"mode"`
+	Size       int64       `json:"size"`
+	MountPoint string      `json:"mount_point"`
+	Parked     time.Time   `json:"parked"`
+	Error      string      `json:"error"` // error of the failed flush
+	file       string      // local copy of the staging file
+}
+
+// Copies a staging file with its manifest to dir, replacing the parked uploads of the same HDFS path
+func parkUpload(dir string, stagingFile *os.File, upload ParkedUpload) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	older, err := listParkedUploads(dir)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(dir, parkedUploadPrefix)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(f, io.NewSectionReader(stagingFile, 0, 1<<62))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	upload.Size = n
+	if err == nil {
+		var data []byte
+		data, _ = json.Marshal(upload)
+		err = ioutil.WriteFile(f.Name()+parkedManifestSuffix, data, 0600)
+	}
+	if err != nil {
+		removeParkedUpload(f.Name())
+		return "", err
+	}
+	for _, o := range older {
+		if o.Path == upload.Path {
+			removeParkedUpload(o.file)
+		}
+	}
+	return f.Name(), nil
+}
+
+func removeParkedUpload(file string) {
+	os.Remove(file)
+	os.Remove(file + parkedManifestSuffix)
+}
+
+// Returns the parked uploads in dir, oldest first
+func listParkedUploads(dir string) ([]ParkedUpload, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var uploads []ParkedUpload
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, parkedUploadPrefix) || !strings.HasSuffix(name, parkedManifestSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		var upload ParkedUpload
+		if err := json.Unmarshal(data, &upload); err != nil {
+			logwarn("Ignoring invalid manifest of a parked upload", Fields{Operation: FailedUploads, TmpFile: name, Error: err})
+			continue
+		}
+		upload.file = filepath.Join(dir, strings.TrimSuffix(name, parkedManifestSuffix))
+		uploads = append(uploads, upload)
+	}
+	sort.SliceStable(uploads, func(i, j int) bool { return uploads[i].Parked.Before(uploads[j].Parked) })
+	return uploads, nil
+}
+
+// Uploads a parked file and removes it. Returns false without uploading if the HDFS file was written after the file was parked
+func replayParkedUpload(hdfsAccessor HdfsAccessor, upload ParkedUpload) (bool, error) {
+	if attrs, err := hdfsAccessor.Stat(upload.Path); err == nil && attrs.Mtime.After(upload.Parked.Add(clockSkewTolerance)) {
+		removeParkedUpload(upload.file)
+		return false, nil
+	}
+	f, err := os.Open(upload.file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	// same as a flush, the file may not be writable by its owner
+	preserved := capturePreservedMetadata(hdfsAccessor, upload.Path)
+	hdfsAccessor.Remove(upload.Path)
+	w, err := hdfsAccessor.CreateFile(upload.Path, upload.Mode, true)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return false, err
+	}
+	if err := w.Close(); err != nil {
+		return false, err
+	}
+	preserved.restore(hdfsAccessor, upload.Path)
+	removeParkedUpload(upload.file)
+	return true, nil
+}
+
+// Parks the staging file of a handle whose flush failed
+func (fh *FileHandle) parkUpload(operation string, flushErr error) {
+	proxy, ok := fh.File.fileProxy.(*LocalRWFileProxy)
+	if !ok {
+		return
+	}
+	start := fh.File.FileSystem.Clock.Now()
+	file, err := parkUpload(failedUploadsDir, proxy.localFile, ParkedUpload{
+		Path:       fh.File.AbsolutePath(),
+		Mode:       fh.File.Attrs.Mode,
+		MountPoint: fh.File.FileSystem.MountPoint,
+		Parked:     start,
+		Error:      flushErr.Error(),
+	})
+	metrics.Record(ParkUpload, fh.File.FileSystem.Clock.Now().Sub(start), 0, 0, false, err)
+	if err != nil {
+		logerror("Failed to park the staging file of a failed upload", fh.logInfo(Fields{Operation: operation, Error: err}))
+		return
+	}
+	logwarn("Parked the staging file of a failed upload, run replay-failed once the cluster is back", fh.logInfo(Fields{Operation: operation, TmpFile: file, Error: flushErr}))
+}
+
+// Returns the number and total size of the parked uploads
+func parkedUploadsBacklog() (int, int64) {
+	uploads, _ := listParkedUploads(failedUploadsDir)
+	var size int64
+	for _, upload := range uploads {
+		size += upload.Size
+	}
+	return len(uploads), size
+}
+
+func replayFailedCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	if failedUploadsDir == "" {
+		return fmt.Errorf("-failedUploadsDir is not set")
+	}
+	uploads, err := listParkedUploads(failedUploadsDir)
+	if err != nil {
+		return err
+	}
+	hdfsAccessor := filesystem.getDFSConnector()
+	failed := 0
+	for _, upload := range uploads {
+		start := filesystem.Clock.Now()
+		replayed, err := replayParkedUpload(hdfsAccessor, upload)
+		switch {
+		case err != nil:
+			failed++
+			out.Printf("failed %s: %v", upload.Path, err)
+		case replayed:
+			out.Printf("uploaded %s (%d bytes)", upload.Path, upload.Size)
+		default:
+			out.Printf("skipped %s, written after it was parked", upload.Path)
+		}
+		metrics.Record(ReplayUpload, filesystem.Clock.Now().Sub(start), upload.Size, 0, false, err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d uploads failed and are still parked", failed, len(uploads))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that parked uploads replace older ones of the same path, are replayed, and are dropped if superseded
+func TestParkedUploads(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hopsfs-failed-uploads")
+	defer os.RemoveAll(dir)
+	staging, _ := ioutil.TempFile("", "hopsfs-stage-test")
+	defer os.Remove(staging.Name())
+	defer staging.Close()
+	parked := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	staging.WriteString("old")
+	_, err := parkUpload(dir, staging, ParkedUpload{Path: "/a", Mode: 0644, Parked: parked})
+	assert.Nil(t, err)
+	staging.WriteAt([]byte("new"), 0)
+	_, err = parkUpload(dir, staging, ParkedUpload{Path: "/a", Mode: 0644, Parked: parked.Add(time.Second)})
+	assert.Nil(t, err)
+	_, err = parkUpload(dir, staging, ParkedUpload{Path: "/b", Mode: 0644, Parked: parked.Add(2 * time.Second)})
+	assert.Nil(t, err)
+	uploads, err := listParkedUploads(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(uploads))
+	assert.Equal(t, "/a", uploads[0].Path)
+	assert.Equal(t, int64(3), uploads[0].Size)
+
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	writer := NewMockHdfsWriter(mockCtrl)
+	var written []byte
+	hdfsAccessor.EXPECT().Stat("/a").Return(Attrs{}, syscall.ENOENT)
+	hdfsAccessor.EXPECT().Remove("/a").Return(syscall.ENOENT)
+	hdfsAccessor.EXPECT().CreateFile("/a", os.FileMode(0644), true).Return(writer, nil)
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		written = append(written, b...)
+		return len(b), nil
+	})
+	writer.EXPECT().Close().Return(nil)
+	replayed, err := replayParkedUpload(hdfsAccessor, uploads[0])
+	assert.Nil(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, "new", string(written))
+
+	// /b was rewritten after it was parked
+	hdfsAccessor.EXPECT().Stat("/b").Return(Attrs{Mtime: parked.Add(time.Hour)}, nil)
+	replayed, err = replayParkedUpload(hdfsAccessor, uploads[1])
+	assert.Nil(t, err)
+	assert.False(t, replayed)
+	uploads, _ = listParkedUploads(dir)
+	assert.Empty(t, uploads)
+}
@@ -0,0 +1,49 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"syscall"
+	"unicode/utf8"
+)
+
+// The namenode rejects path components longer than dfs.namenode.fs-limits.max-component-length
+// bytes and paths longer than 8000 characters. Names are checked against -maxComponentLength and
+// -maxPathLength before any RPC, so that tools get ENAMETOOLONG instead of a generic I/O error.
+// With -shortenLongNames, over-length components are replaced by a prefix of the name and a hash
+// of the whole name instead. The same long name always maps to the same short name, so the file
+// can be opened, renamed and removed by its long name, but listings show the short name
+const hdfsMaxPathLength = 8000
+
+// Hex digits of the hash appended to shortened names, after a "~"
+const shortNameHashLength = 16
+
+// Returns the name of the child in HDFS, the name itself unless it is too long or not in the form of -unicodeNormalization
+func (dir *DirINode) hdfsChildName(name string) (string, error) {
+	name = normalizeName(name)
+	if maxComponentLength > 0 && len(name) > maxComponentLength {
+		if !shortenLongNames || maxComponentLength <= shortNameHashLength+1 {
+			logwarn("Name is too long", Fields{Path: dir.AbsolutePath(), Message: name})
+			return "", syscall.ENAMETOOLONG
+		}
+		name = shortenName(name, maxComponentLength)
+	}
+	if maxPathLength > 0 && utf8.RuneCountInString(dir.AbsolutePathForChild(name)) > maxPathLength {
+		logwarn("Path is too long", Fields{Path: dir.AbsolutePath(), Message: name})
+		return "", syscall.ENAMETOOLONG
+	}
+	return name, nil
+}
+
+// Returns a name of at most max bytes made of a prefix of the name and a hash of the name
+func shortenName(name string, max int) string {
+	sum := sha256.Sum256([]byte(name))
+	prefix := name[:max-shortNameHashLength-1]
+	// not cutting a multi-byte character in half
+	for len(prefix) > 0 && !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix + "~" + hex.EncodeToString(sum[:])[:shortNameHashLength]
+}
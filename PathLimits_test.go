@@ -0,0 +1,53 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that over-length names fail with ENAMETOOLONG or are shortened consistently
+func TestPathLimits(t *testing.T) {
+	saveFlags(t, &maxComponentLength, &maxPathLength, &shortenLongNames)
+	maxComponentLength, maxPathLength = 32, 60
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem(nil, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	dir := root.(*DirINode)
+
+	name, err := dir.hdfsChildName("short")
+	assert.Nil(t, err)
+	assert.Equal(t, "short", name)
+	long := strings.Repeat("x", 40)
+	_, err = dir.hdfsChildName(long)
+	assert.Equal(t, syscall.ENAMETOOLONG, err)
+	_, err = dir.Lookup(nil, long)
+	assert.Equal(t, syscall.ENAMETOOLONG, err)
+	_, err = dir.Mkdir(nil, &fuse.MkdirRequest{Name: long})
+	assert.Equal(t, syscall.ENAMETOOLONG, err)
+
+	shortenLongNames = true
+	name, err = dir.hdfsChildName(long)
+	assert.Nil(t, err)
+	assert.Equal(t, 32, len(name))
+	again, _ := dir.hdfsChildName(long)
+	assert.Equal(t, name, again)
+	other, _ := dir.hdfsChildName(strings.Repeat("x", 41))
+	assert.NotEqual(t, name, other)
+	assert.True(t, strings.HasPrefix(name, "xxxxxxxxxxxxxxx~"))
+
+	// multi-byte characters are not cut
+	name, _ = dir.hdfsChildName(strings.Repeat("é", 20))
+	assert.Equal(t, 31, len(name))
+	assert.True(t, strings.HasPrefix(name, "ééééééé~"))
+
+	nested := &DirINode{FileSystem: fs, Parent: dir, Attrs: Attrs{Name: strings.Repeat("d", 30)}}
+	sub := &DirINode{FileSystem: fs, Parent: nested, Attrs: Attrs{Name: strings.Repeat("e", 25)}}
+	_, err = sub.hdfsChildName("file")
+	assert.Equal(t, syscall.ENAMETOOLONG, err)
+}
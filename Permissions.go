@@ -0,0 +1,139 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"bazil.org/fuse"
+)
+
+// Where access is checked, in order of precedence: with "kernel" only the kernel checks, using the
+// local uid/gid the HDFS owner and group are mapped to. With "client" only hopsfs-mount checks,
+// using the HDFS groups of the caller. With "backend" neither does, and HDFS checks every RPC
+// against the HDFS user of the mount, whoever the local caller is. In all modes HDFS has the last
+// word, an operation it denies fails with EACCES or EPERM
+const (
+	PermissionChecksKernel  = "kernel"  // the kernel checks permissions using the uid/gid of the entries (default_permissions)
+	PermissionChecksClient  = "client"  // hopsfs-mount checks permissions using the HDFS groups of the caller
+	PermissionChecksBackend = "backend" // only HDFS checks permissions, as the HDFS user of the mount
+)
+
+// Access mask bits, as in access(2)
+const (
+	accessRead  = 4
+	accessWrite = 2
+	accessExec  = 1
+)
+
+// Returns true if permissions are checked by hopsfs-mount instead of the kernel
+func clientPermissionChecks() bool {
+	return permissionChecks == PermissionChecksClient
+}
+
+// Returns true if the caller bypasses the client checks. Root does, unless -squashRoot
+// treats it as any other user. The kernel checks never squash root
+func privileged(caller fuse.Header) bool {
+	return caller.Uid == 0 && !squashRoot
+}
+
+// Checks whether the caller is allowed to access the entry with the given mask.
+// Owner, group and other permission bits are applied as in POSIX. The caller
+// is a member of the group of the entry if the group resolver says so, as the
+// HDFS groups of a user do not necessarily exist on the local machine.
+// Always succeeds when the kernel checks the permissions
+func (filesystem *FileSystem) checkAccess(attrs *Attrs, caller fuse.Header, mask uint32, path string) error {
+	if !clientPermissionChecks() || privileged(caller) {
+		return nil
+	}
+
+	perm := uint32(attrs.Mode & os.ModePerm)
+	var granted uint32
+	if caller.Uid == attrs.Uid {
+		granted = perm >> 6
+	} else if filesystem.inGroup(attrs, caller) {
+		granted = perm >> 3
+	} else {
+		granted = perm
+	}
+
+	if mask&^granted&07 != 0 {
+		loginfo("Permission denied", Fields{Operation: Access, Path: path, UID: caller.Uid, PID: caller.Pid, Mode: attrs.Mode, Flags: mask})
+		return fuse.Errno(syscall.EACCES)
+	}
+	return nil
+}
+
+// Checks that the caller is the owner of the entry, as required for chmod
+func (filesystem *FileSystem) checkOwner(attrs *Attrs, caller fuse.Header, path string) error {
+	if !clientPermissionChecks() || privileged(caller) || caller.Uid == attrs.Uid {
+		return nil
+	}
+	loginfo("Operation not permitted, caller is not the owner", Fields{Operation: Access, Path: path, UID: caller.Uid, PID: caller.Pid})
+	return fuse.Errno(syscall.EPERM)
+}
+
+// Returns true if the caller is a member of the HDFS group of the entry
+func (filesystem *FileSystem) inGroup(attrs *Attrs, caller fuse.Header) bool {
+	group := attrs.Group
+	if group == "" {
+		// entries created through the mount point carry only the local gid
+		if caller.Gid == attrs.Gid {
+			return true
+		}
+		group = idMapper.GroupName(attrs.Gid)
+	}
+	if filesystem.GroupResolver == nil || group == "" {
+		return caller.Gid == attrs.Gid
+	}
+	groups, err := filesystem.GroupResolver.Groups(caller)
+	if err != nil {
+		logwarn("Unable to resolve the groups of the caller, using the primary group only", Fields{UID: caller.Uid, PID: caller.Pid, Error: err})
+		return caller.Gid == attrs.Gid
+	}
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the access mask required to open a file with the given flags
+func openAccessMask(flags fuse.OpenFlags) uint32 {
+	var mask uint32
+	switch {
+	case flags.IsReadOnly():
+		mask = accessRead
+	case flags.IsWriteOnly():
+		mask = accessWrite
+	case flags.IsReadWrite():
+		mask = accessRead | accessWrite
+	}
+	if flags&fuse.OpenTruncate != 0 {
+		mask |= accessWrite
+	}
+	return mask
+}
+
+// Checks whether the caller may change the attributes. Only the owner can change the mode and the
+// group, only root can change the owner. Changing the size requires write permission
+func checkSetattr(filesystem *FileSystem, attrs *Attrs, req *fuse.SetattrRequest, path string) error {
+	if !clientPermissionChecks() || privileged(req.Header) {
+		return nil
+	}
+	if req.Valid.Uid() && req.Uid != attrs.Uid {
+		loginfo("Operation not permitted, only root can change the owner", Fields{Operation: Chown, Path: path, UID: req.Header.Uid, PID: req.Header.Pid})
+		return fuse.Errno(syscall.EPERM)
+	}
+	if req.Valid.Mode() || req.Valid.Gid() {
+		if err := filesystem.checkOwner(attrs, req.Header, path); err != nil {
+			return err
+		}
+	}
+	if req.Valid.Size() {
+		return filesystem.checkAccess(attrs, req.Header, accessWrite, path)
+	}
+	return nil
+}
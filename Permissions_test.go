@@ -0,0 +1,122 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticGroupResolver struct {
+	groups []string
+	calls  int
+}
+
+func (resolver *staticGroupResolver) Groups(caller fuse.Header) ([]string, error) {
+	resolver.calls++
+	return resolver.groups, nil
+}
+
+// Testing client side permission checks using the HDFS groups of the caller
+func TestClientPermissionChecks(t *testing.T) {
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem(nil, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	attrs := &Attrs{Mode: 0750, Uid: 1000, Gid: 0, Group: "project1"}
+	caller := fuse.Header{Uid: 1001, Gid: 1001, Pid: 1}
+
+	// the kernel checks the permissions
+	assert.Nil(t, fs.checkAccess(attrs, caller, accessRead, "/f"))
+
+	saveFlags(t, &permissionChecks)
+	permissionChecks = PermissionChecksClient
+
+	assert.Equal(t, fuse.Errno(syscall.EACCES), fs.checkAccess(attrs, caller, accessRead, "/f"))
+	fs.GroupResolver = &staticGroupResolver{groups: []string{"users", "project1"}}
+	assert.Nil(t, fs.checkAccess(attrs, caller, accessRead|accessExec, "/f"))
+	assert.Equal(t, fuse.Errno(syscall.EACCES), fs.checkAccess(attrs, caller, accessWrite, "/f"))
+	assert.Nil(t, fs.checkAccess(attrs, fuse.Header{Uid: 1000}, accessRead|accessWrite, "/f"))
+	assert.Nil(t, fs.checkAccess(attrs, fuse.Header{Uid: 0}, accessWrite, "/f"))
+
+	req := &fuse.SetattrRequest{Header: caller, Mode: 0777, Valid: fuse.SetattrMode}
+	assert.Equal(t, fuse.Errno(syscall.EPERM), checkSetattr(fs, attrs, req, "/f"))
+	req.Header.Uid = 1000
+	assert.Nil(t, checkSetattr(fs, attrs, req, "/f"))
+	req = &fuse.SetattrRequest{Header: fuse.Header{Uid: 1000}, Uid: 1001, Valid: fuse.SetattrUid}
+	assert.Equal(t, fuse.Errno(syscall.EPERM), checkSetattr(fs, attrs, req, "/f"))
+}
+
+// Testing that -squashRoot checks root like any other user and that backend checks leave it to HDFS
+func TestSquashRootAndBackendChecks(t *testing.T) {
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem(nil, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	attrs := &Attrs{Mode: 0750, Uid: 1000, Gid: 1000}
+	root := fuse.Header{Uid: 0, Gid: 0, Pid: 1}
+	saveFlags(t, &permissionChecks, &squashRoot)
+
+	permissionChecks = PermissionChecksClient
+	assert.Nil(t, fs.checkAccess(attrs, root, accessRead, "/f"))
+	squashRoot = true
+	assert.Equal(t, fuse.Errno(syscall.EACCES), fs.checkAccess(attrs, root, accessRead, "/f"))
+	req := &fuse.SetattrRequest{Header: root, Uid: 1001, Valid: fuse.SetattrUid}
+	assert.Equal(t, fuse.Errno(syscall.EPERM), checkSetattr(fs, attrs, req, "/f"))
+
+	permissionChecks, squashRoot = PermissionChecksBackend, false
+	assert.Nil(t, fs.checkAccess(attrs, fuse.Header{Uid: 1001}, accessWrite, "/f"))
+	// only the kernel mode mounts with default_permissions
+	backendOptions := len(getMountOptions(false))
+	permissionChecks = PermissionChecksKernel
+	assert.Equal(t, backendOptions+1, len(getMountOptions(false)))
+}
+
+func TestOpenAccessMask(t *testing.T) {
+	assert.Equal(t, uint32(accessRead), openAccessMask(fuse.OpenReadOnly))
+	assert.Equal(t, uint32(accessWrite), openAccessMask(fuse.OpenWriteOnly))
+	assert.Equal(t, uint32(accessRead|accessWrite), openAccessMask(fuse.OpenReadWrite))
+	assert.Equal(t, uint32(accessRead|accessWrite), openAccessMask(fuse.OpenReadOnly|fuse.OpenTruncate))
+}
+
+// Testing the static group mapping file and caching of resolved groups
+func TestGroupResolvers(t *testing.T) {
+	f, _ := ioutil.TempFile("", "groups")
+	defer os.Remove(f.Name())
+	f.WriteString("# user: groups\nalice: project1, project2\n\nbob:project3\n")
+	f.Close()
+
+	resolver, err := newFileGroupResolver(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"project1", "project2"}, resolver.groups["alice"])
+	assert.Equal(t, []string{"project3"}, resolver.groups["bob"])
+
+	mockClock := &MockClock{}
+	static := &staticGroupResolver{groups: []string{"g"}}
+	cache := newCachingGroupResolver(static, false, time.Minute, mockClock)
+	cache.Groups(fuse.Header{Uid: 1, Pid: 10})
+	groups, _ := cache.Groups(fuse.Header{Uid: 1, Pid: 11})
+	assert.Equal(t, []string{"g"}, groups)
+	assert.Equal(t, 1, static.calls)
+	mockClock.NotifyTimeElapsed(2 * time.Minute)
+	cache.Groups(fuse.Header{Uid: 1, Pid: 11})
+	assert.Equal(t, 2, static.calls)
+}
+
+// Testing that cached groups do not expire when the wall clock is set forward or back
+func TestGroupCacheIgnoresClockJumps(t *testing.T) {
+	mockClock := &MockClock{}
+	static := &staticGroupResolver{groups: []string{"g"}}
+	cache := newCachingGroupResolver(static, false, time.Minute, mockClock)
+	cache.Groups(fuse.Header{Uid: 1})
+	mockClock.JumpWallClock(time.Hour)
+	cache.Groups(fuse.Header{Uid: 1})
+	mockClock.JumpWallClock(-2 * time.Hour)
+	cache.Groups(fuse.Header{Uid: 1})
+	assert.Equal(t, 1, static.calls)
+	mockClock.NotifyTimeElapsed(2 * time.Minute)
+	cache.Groups(fuse.Header{Uid: 1})
+	assert.Equal(t, 2, static.calls)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Right after mounting, the -prefetchPaths directories are listed in the background, down to
+// -prefetchDepth levels below them, so the first ls or lookups in them, e.g., by notebooks at
+// startup, are served from the cache instead of waiting for the namenode one directory at a
+// time. The "prefetch" admin command does the same on demand
+func init() {
+	registerAdminCommand("prefetch", AdminCommand{
+		Usage:    "<path> [depth]",
+		Help:     "Lists a directory tree into the cache",
+		PathArgs: 1,
+		Handler:  prefetchCmd,
+	})
+}
+
+func prefetchCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	depth := prefetchDepth
+	if len(args) > 2 {
+		return fmt.Errorf("usage: prefetch <path> [depth]")
+	}
+	if len(args) == 2 {
+		d, err := strconv.Atoi(args[1])
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid depth %s", args[1])
+		}
+		depth = d
+	}
+	listed, err := filesystem.prefetchListings(args[0], depth)
+	out.Printf("listed %d directories", listed)
+	return err
+}
+
+// Returns the HDFS paths given by -prefetchPaths, a comma separated list
+func prefetchPathList() []string {
+	var paths []string
+	for _, p := range strings.Split(prefetchPaths, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, path.Clean(p))
+		}
+	}
+	return paths
+}
+
+// Lists the -prefetchPaths directories, called in the background after mounting
+func (filesystem *FileSystem) prefetchAll() {
+	for _, p := range prefetchPathList() {
+		listed, err := filesystem.prefetchListings(p, prefetchDepth)
+		if err != nil {
+			logwarn("Failed to prefetch listings", Fields{Operation: ReadDir, Path: p, Error: err})
+			continue
+		}
+		loginfo(fmt.Sprintf("Prefetched %d directory listings", listed), Fields{Operation: ReadDir, Path: p})
+	}
+}
+
+// Lists the directory and its subdirectories down to depth levels below it into the cache.
+// Returns the number of listed directories
+func (filesystem *FileSystem) prefetchListings(hdfsPath string, depth int) (int, error) {
+	dir, err := filesystem.lookupDir(hdfsPath)
+	if err != nil {
+		return 0, err
+	}
+	listed := 0
+	level := []*DirINode{dir}
+	for d := 0; d <= depth && len(level) > 0; d++ {
+		var next []*DirINode
+		for _, dir := range level {
+			if _, err := dir.ReadDirAll(nil); err != nil {
+				// e.g., no permission, the rest of the tree is still listed
+				continue
+			}
+			listed++
+			for _, node := range dir.cachedEntries() {
+				if child, ok := node.(*DirINode); ok {
+					next = append(next, child)
+				}
+			}
+		}
+		level = next
+	}
+	return listed, nil
+}
+
+// Returns the directory node of an HDFS path, looking up the nodes which are not cached
+func (filesystem *FileSystem) lookupDir(hdfsPath string) (*DirINode, error) {
+	if !filesystem.IsPathAllowed(hdfsPath) {
+		return nil, syscall.ENOENT
+	}
+	root, _ := filesystem.Root()
+	dir := root.(*DirINode)
+	rel := strings.TrimPrefix(path.Clean(hdfsPath), path.Clean(filesystem.SrcDir))
+	for _, name := range strings.Split(rel, "/") {
+		if name == "" {
+			continue
+		}
+		node, err := dir.Lookup(nil, name)
+		if err != nil {
+			return nil, err
+		}
+		child, ok := node.(*DirINode)
+		if !ok {
+			return nil, syscall.ENOTDIR
+		}
+		dir = child
+	}
+	return dir, nil
+}
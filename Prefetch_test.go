@@ -0,0 +1,34 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that prefetching lists the directory and its subdirectories down to the depth into the cache
+func TestPrefetchListings(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+
+	hdfsAccessor.EXPECT().Stat("/projects").Return(Attrs{Name: "projects", Mode: os.ModeDir | 0755}, nil)
+	hdfsAccessor.EXPECT().ReadDir("/projects").Return([]Attrs{
+		{Name: "demo", Mode: os.ModeDir | 0755}, {Name: "README", Mode: 0644}}, nil)
+	hdfsAccessor.EXPECT().ReadDir("/projects/demo").Return([]Attrs{
+		{Name: "data", Mode: os.ModeDir | 0755}}, nil)
+
+	listed, err := fs.prefetchListings("/projects", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, listed)
+	assert.NotNil(t, fs.cachedNode("/projects/README"))
+	assert.NotNil(t, fs.cachedNode("/projects/demo/data"))
+
+	_, err = fs.prefetchListings("/projects/README", 1)
+	assert.NotNil(t, err)
+}
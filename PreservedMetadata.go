@@ -0,0 +1,70 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+// Flushes rewrite the HDFS file, by removing and creating it again or by renaming a new file
+// over it, and so do replaces, the replay of parked uploads and overlay commits. HDFS keeps
+// the extended attributes and the ACL with the inode, so the tags and grants a catalog tool set
+// on the file would be gone after the next write through the mount. With -preserveMetadata the
+// user.* extended attributes and the ACL entries of the previous file are read before the
+// rewrite and set on the new file. Failing to restore them is logged, the data is uploaded
+var preserveMetadata bool
+
+// Metadata of an HDFS file which a rewrite drops
+type PreservedMetadata struct {
+	XAttrs map[string]string // user.* extended attributes
+	Acl    []AclEntry        // ACL entries which are not in the mode
+}
+
+// Reads the metadata of the file to restore after rewriting it. Returns nil if the file has
+// none, does not exist, or the backend does not keep such metadata
+func capturePreservedMetadata(hdfsAccessor HdfsAccessor, path string) *PreservedMetadata {
+	if !preserveMetadata {
+		return nil
+	}
+	metadata := &PreservedMetadata{}
+	if xattrs, err := hdfsAccessor.GetXAttrs(path); err == nil {
+		for name, value := range xattrs {
+			// the other namespaces are the namenode's or need the superuser
+			if strings.HasPrefix(name, "user.") {
+				if metadata.XAttrs == nil {
+					metadata.XAttrs = map[string]string{}
+				}
+				metadata.XAttrs[name] = value
+			}
+		}
+	} else if err != syscall.ENOENT && err != syscall.ENOTSUP {
+		logwarn("Unable to read the extended attributes to preserve", Fields{Operation: PreserveOp, Path: path, Error: err})
+	}
+	if acl, err := hdfsAccessor.GetAcl(path); err == nil {
+		metadata.Acl = acl
+	} else if err != syscall.ENOENT && err != syscall.ENOTSUP {
+		logwarn("Unable to read the ACL to preserve", Fields{Operation: PreserveOp, Path: path, Error: err})
+	}
+	if len(metadata.XAttrs) == 0 && len(metadata.Acl) == 0 {
+		return nil
+	}
+	return metadata
+}
+
+// Sets the metadata on the rewritten file. Nothing to do for nil
+func (metadata *PreservedMetadata) restore(hdfsAccessor HdfsAccessor, path string) {
+	if metadata == nil {
+		return
+	}
+	for name, value := range metadata.XAttrs {
+		if err := hdfsAccessor.SetXAttr(path, name, value); err != nil {
+			logwarn("Unable to restore an extended attribute of the rewritten file", Fields{Operation: PreserveOp, Path: path, Message: name, Error: err})
+		}
+	}
+	if len(metadata.Acl) > 0 {
+		if err := hdfsAccessor.SetAcl(path, metadata.Acl); err != nil {
+			logwarn("Unable to restore the ACL of the rewritten file", Fields{Operation: PreserveOp, Path: path, Error: err})
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that a flush rewriting a file sets the user xattrs and the ACL of the previous file on the new one
+func TestFlushPreservesMetadata(t *testing.T) {
+	saveFlags(t, &preserveMetadata)
+	preserveMetadata = true
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().OpenRead("/data.csv").DoAndReturn(func(path string) (ReadSeekCloser, error) {
+		return &MockReadSeekCloserWithPseudoRandomContent{FileSize: 5}, nil
+	}).AnyTimes()
+	hdfsAccessor.EXPECT().Stat("/data.csv").Return(Attrs{Name: "data.csv", Mode: 0644, Size: 5}, nil).AnyTimes()
+	file := root.(*DirINode).NodeFromAttrs(Attrs{Name: "data.csv", Mode: 0644, Size: 5}).(*FileINode)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fh := h.(*FileHandle)
+	assert.Nil(t, fh.Write(nil, &fuse.WriteRequest{Data: []byte("more"), Offset: 5}, &fuse.WriteResponse{}))
+
+	acl := []AclEntry{{Tag: aclUser, Name: "catalog", Perm: 4}}
+	writer := NewMockHdfsWriter(mockCtrl)
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) { return len(b), nil }).AnyTimes()
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().GetXAttrs("/data.csv").Return(map[string]string{"user.classification": "pii", "trusted.tier": "hot"}, nil),
+		hdfsAccessor.EXPECT().GetAcl("/data.csv").Return(acl, nil),
+		hdfsAccessor.EXPECT().Remove("/data.csv").Return(nil),
+		hdfsAccessor.EXPECT().CreateFile("/data.csv", os.FileMode(0644), true).Return(writer, nil),
+		writer.EXPECT().Close().Return(nil),
+		hdfsAccessor.EXPECT().SetXAttr("/data.csv", "user.classification", "pii").Return(nil),
+		hdfsAccessor.EXPECT().SetAcl("/data.csv", acl).Return(nil),
+	)
+	assert.Nil(t, fh.Flush(nil, &fuse.FlushRequest{}))
+}
+
+// Testing that replaying a parked upload keeps the metadata, and that a backend without xattrs and ACLs is not an error
+func TestReplayPreservesMetadata(t *testing.T) {
+	saveFlags(t, &preserveMetadata)
+	preserveMetadata = true
+	dir, _ := ioutil.TempDir("", "hopsfs-failed-uploads")
+	defer os.RemoveAll(dir)
+	staging, _ := ioutil.TempFile("", "hopsfs-stage-test")
+	defer os.Remove(staging.Name())
+	defer staging.Close()
+	staging.WriteString("new")
+	_, err := parkUpload(dir, staging, ParkedUpload{Path: "/a", Mode: 0644, Parked: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)})
+	assert.Nil(t, err)
+	uploads, _ := listParkedUploads(dir)
+
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	writer := NewMockHdfsWriter(mockCtrl)
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) { return len(b), nil }).AnyTimes()
+	hdfsAccessor.EXPECT().Stat("/a").Return(Attrs{}, nil)
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().GetXAttrs("/a").Return(map[string]string{"user.owner_team": "ml"}, nil),
+		hdfsAccessor.EXPECT().GetAcl("/a").Return(nil, syscall.ENOTSUP),
+		hdfsAccessor.EXPECT().Remove("/a").Return(nil),
+		hdfsAccessor.EXPECT().CreateFile("/a", os.FileMode(0644), true).Return(writer, nil),
+		writer.EXPECT().Close().Return(nil),
+		hdfsAccessor.EXPECT().SetXAttr("/a", "user.owner_team", "ml").Return(syscall.EACCES),
+	)
+	// failing to restore does not fail the upload
+	replayed, err := replayParkedUpload(hdfsAccessor, uploads[0])
+	assert.Nil(t, err)
+	assert.True(t, replayed)
+
+	hdfsAccessor.EXPECT().GetXAttrs("/b").Return(nil, syscall.ENOTSUP)
+	hdfsAccessor.EXPECT().GetAcl("/b").Return(nil, syscall.ENOTSUP)
+	assert.Nil(t, capturePreservedMetadata(hdfsAccessor, "/b"))
+	preserveMetadata = false
+	assert.Nil(t, capturePreservedMetadata(hdfsAccessor, "/b"))
+}
@@ -0,0 +1,81 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// -profile sets the options tuned for a workload in one go. The options of the profile only
+// change the defaults: an option given on the command line, in the environment or in the
+// config file takes precedence over the profile
+const (
+	ProfileTraining    = "training"    // large sequential reads feeding the data loaders of training jobs
+	ProfileInteractive = "interactive" // notebooks and file browsers, where the latency of metadata operations shows
+)
+
+var profile string
+
+// Options of each profile, as given on the command line
+var profiles = map[string]map[string]string{
+	ProfileTraining: {
+		"maxReadahead":        "1048576",   // kernel readahead of sequential reads
+		"blockCacheBlockSize": "4194304",   // reads from HopsFS in 4 MiB
+		"readaheadBlocks":     "16",        // up to 64 MiB read ahead, with -blockCacheDir
+		"blockCacheMemory":    "536870912", // the hot blocks stay in memory
+		"keepPageCache":       "true",      // epochs read the same files again
+		"openCoalesceWindow":  "1m",        // data loader workers open the same files one after another
+		"attrCacheTTL":        "1m",        // datasets do not change while read
+	},
+	ProfileInteractive: {
+		"listingCacheTTL":   "10s",   // file browsers list the open directory every few seconds
+		"negativeLookupTTL": "10s",   // and look up checkpoints, .git and config files which mostly do not exist
+		"attrCacheTTL":      "10s",   // and stat what they list
+		"maxReadahead":      "16384", // files are opened to peek at their beginning
+		"retryMaxAttempts":  "3",     // an error is better than a frozen notebook
+		"retryMaxDelay":     "2s",
+		"retryTimeLimit":    "15s",
+	},
+}
+
+// Returns the names of the profiles, sorted
+func profileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sets the options of -profile which are not set otherwise. Called once the command line and the config are applied
+func applyProfile(flags *flag.FlagSet) error {
+	if profile == "" {
+		return nil
+	}
+	options, ok := profiles[profile]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, expected one of %s", profile, strings.Join(profileNames(), ", "))
+	}
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if set[name] {
+			continue
+		}
+		if err := flags.Set(name, options[name]); err != nil {
+			return fmt.Errorf("profile %s: invalid value %q for -%s: %v", profile, options[name], name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that a profile sets the options which are not given otherwise. Registering the flags
+// sets all the options to their defaults, so the test runs in a child process to leave the
+// options of the other tests alone
+func TestApplyProfile(t *testing.T) {
+	if os.Getenv("HOPSFS_MOUNT_TEST_PROFILE") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestApplyProfile$")
+		cmd.Env = append(os.Environ(), "HOPSFS_MOUNT_TEST_PROFILE=1")
+		out, err := cmd.CombinedOutput()
+		assert.Nil(t, err, string(out))
+		return
+	}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerFlags(flags, &RetryPolicy{})
+
+	assert.Nil(t, flags.Parse([]string{"-profile", ProfileTraining, "-readaheadBlocks", "2"}))
+	assert.Nil(t, applyProfile(flags))
+	assert.Equal(t, 2, readaheadBlocks)
+	assert.Equal(t, uint(1024*1024), maxReadahead)
+	assert.True(t, keepPageCache)
+
+	// every option of every profile exists and has a valid value
+	for _, name := range profileNames() {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		registerFlags(flags, &RetryPolicy{})
+		assert.Nil(t, flags.Parse([]string{"-profile", name}))
+		assert.Nil(t, applyProfile(flags))
+	}
+
+	profile = "fast"
+	assert.EqualError(t, applyProfile(flags), `unknown profile "fast", expected one of interactive, training`)
+}
@@ -0,0 +1,177 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// Jobs hitting the HDFS quota of their project fail with EDQUOT in the middle of a write. With
+// -quotaWarningPercent, after a file is uploaded, the quotas of the closest directory with a
+// quota, its own or one above it, are checked at most once per -quotaCheckInterval per
+// directory, and a warning is logged and recorded in the metrics as the "quota_warning"
+// operation once the usage reaches -quotaWarningPercent. The walk stops at the first quota,
+// as every level costs a content summary of the subtree. The user.hopsfs.quota_usage extended
+// attribute of a directory tells the usage of that quota, e.g., "93.1% space /Projects/p1" or "none"
+const quotaUsageXAttr = "user.hopsfs.quota_usage"
+
+// df through the mount reported the capacity of the whole cluster, while the writes of the
+// user are limited by the quotas of the mounted directory. With -statfsQuota, statfs reports
+// the space quota of the source dir as the size of the file system, and the part of it which
+// is not consumed, capped by the free space of the cluster, as the free space. The space quota
+// counts the replicas, as does the consumed space. A name quota is reported as the number of
+// inodes, and the names left as the free ones. The quota is the one of the source dir or, if it
+// has none, of the closest directory above it with a quota, as the writes are limited by it. Without
+// quota, or if the content summary fails, statfs reports the cluster. The summary is the one of the
+// last poll with -capacityInterval, and is otherwise reused for statfsQuotaTTL, as each content
+// summary walks the whole subtree of the directory on the namenode
+var statfsQuota bool
+
+// Time the quota of the source dir is reused by statfs without -capacityInterval
+const statfsQuotaTTL = time.Minute
+
+// Default of -quotaCheckInterval
+const defaultQuotaCheckInterval = time.Minute
+
+// Usage of the closest quota applying to a directory
+type QuotaUsage struct {
+	Percent float64
+	Kind    string // "space" or "names", empty if no quota applies
+	Dir     string // directory the quota is set on
+}
+
+func (usage QuotaUsage) String() string {
+	if usage.Kind == "" {
+		return "none"
+	}
+	return fmt.Sprintf("%.1f%% %s %s", usage.Percent, usage.Kind, usage.Dir)
+}
+
+// Returns the usage of the fullest of the quotas set on a directory
+func summaryQuotaUsage(cs ContentSummary) (float64, string) {
+	percent, kind := 0.0, ""
+	if cs.SpaceQuota > 0 {
+		percent, kind = 100*float64(cs.SpaceConsumed)/float64(cs.SpaceQuota), "space"
+	}
+	if cs.NameQuota > 0 {
+		// the namenode counts the directory itself against its name quota
+		if names := 100 * float64(cs.FileCount+cs.DirectoryCount) / float64(cs.NameQuota); kind == "" || names > percent {
+			percent, kind = names, "names"
+		}
+	}
+	return percent, kind
+}
+
+// Returns the usage of the fullest quota set on the closest directory with a quota, the directory itself or one above it
+func (dir *DirINode) quotaUsage() (QuotaUsage, error) {
+	for d := dir; d != nil; d = d.Parent {
+		cs, err := d.contentSummary()
+		if err != nil {
+			return QuotaUsage{}, err
+		}
+		if percent, kind := summaryQuotaUsage(cs); kind != "" {
+			return QuotaUsage{Percent: percent, Kind: kind, Dir: d.AbsolutePath()}, nil
+		}
+	}
+	return QuotaUsage{}, nil
+}
+
+// Warns if a quota applying to the directory is almost used up, called after writes
+func (dir *DirINode) checkQuota() {
+	if !dir.FileSystem.Capabilities.ContentSummary {
+		return
+	}
+	now := dir.FileSystem.Clock.Monotonic()
+	dir.lockMutex()
+	if dir.quotaChecked && now < dir.quotaCheckedAt+quotaCheckInterval {
+		dir.unlockMutex()
+		return
+	}
+	dir.quotaChecked, dir.quotaCheckedAt = true, now
+	dir.unlockMutex()
+
+	usage, err := dir.quotaUsage()
+	if err != nil {
+		logdebug("Unable to check the quota usage", Fields{Operation: GetContentSummary, Path: dir.AbsolutePath(), Error: err})
+		return
+	}
+	if usage.Kind != "" && usage.Percent >= quotaWarningPercent {
+		logwarn(fmt.Sprintf("Directory quota is %.1f%% used", usage.Percent), Fields{Operation: QuotaWarning, Path: usage.Dir, Message: usage.Kind})
+		metrics.Record(QuotaWarning, 0, 0, 0, false, nil)
+	}
+}
+
+// Returns the content summary and the path of the closest directory with a quota, the HDFS path
+// or one above it, an empty summary if none has a quota
+func closestQuotaSummary(hdfsAccessor HdfsAccessor, p string) (ContentSummary, string, error) {
+	for {
+		cs, err := hdfsAccessor.GetContentSummary(p)
+		if err != nil {
+			return ContentSummary{}, "", err
+		}
+		if _, kind := summaryQuotaUsage(cs); kind != "" {
+			return cs, p, nil
+		}
+		if p == "/" || p == "" {
+			return ContentSummary{}, "", nil
+		}
+		p = path.Dir(p)
+	}
+}
+
+// Returns the content summary of the closest quota of the source dir, cached for statfsQuotaTTL
+func (filesystem *FileSystem) sourceQuotaSummary() (ContentSummary, error) {
+	filesystem.quotaSummaryMutex.Lock()
+	defer filesystem.quotaSummaryMutex.Unlock()
+	now := filesystem.Clock.Monotonic()
+	if filesystem.quotaSummary == nil || now > filesystem.quotaSummaryExpires {
+		cs, _, err := closestQuotaSummary(filesystem.getDFSConnector(), filesystem.SrcDir)
+		if err != nil {
+			return ContentSummary{}, err
+		}
+		filesystem.quotaSummary = &cs
+		filesystem.quotaSummaryExpires = now + statfsQuotaTTL
+	}
+	return *filesystem.quotaSummary, nil
+}
+
+// Replaces the cluster numbers of the statfs response with the closest quotas of the source dir
+func (filesystem *FileSystem) quotaStatfs(resp *fuse.StatfsResponse) {
+	if !statfsQuota || !filesystem.Capabilities.ContentSummary {
+		return
+	}
+	cs, ok := ContentSummary{}, false
+	if filesystem.Capacity != nil {
+		cs, ok = filesystem.Capacity.Summary()
+	}
+	if !ok {
+		var err error
+		if cs, err = filesystem.sourceQuotaSummary(); err != nil {
+			logdebug("Unable to get the quotas of the source dir", Fields{Operation: GetContentSummary, Path: filesystem.SrcDir, Error: err})
+			return
+		}
+	}
+	if cs.SpaceQuota > 0 {
+		free := uint64(0)
+		if cs.SpaceConsumed < cs.SpaceQuota {
+			free = uint64(cs.SpaceQuota-cs.SpaceConsumed) / uint64(resp.Bsize)
+		}
+		if free > resp.Bfree {
+			free = resp.Bfree
+		}
+		resp.Blocks = uint64(cs.SpaceQuota) / uint64(resp.Bsize)
+		resp.Bfree = free
+		resp.Bavail = free
+	}
+	if cs.NameQuota > 0 {
+		resp.Files = uint64(cs.NameQuota)
+		resp.Ffree = 0
+		if names := cs.FileCount + cs.DirectoryCount; names < cs.NameQuota {
+			resp.Ffree = uint64(cs.NameQuota - names)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the closest quota above a directory is reported and warned about at most once per interval
+func TestQuotaUsage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	project := root.(*DirINode).NodeFromAttrs(Attrs{Name: "p1", Mode: os.ModeDir | 0755}).(*DirINode)
+	dataset := project.NodeFromAttrs(Attrs{Name: "data", Mode: os.ModeDir | 0755}).(*DirINode)
+	hdfsAccessor.EXPECT().GetContentSummary("/").Return(ContentSummary{NameQuota: -1, SpaceQuota: -1}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().GetContentSummary("/p1").Return(ContentSummary{SpaceConsumed: 950, FileCount: 10, DirectoryCount: 2, NameQuota: 100, SpaceQuota: 1000}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().GetContentSummary("/p1/data").Return(ContentSummary{FileCount: 5, DirectoryCount: 1, NameQuota: 10, SpaceQuota: -1}, nil).AnyTimes()
+
+	resp := &fuse.GetxattrResponse{}
+	assert.Nil(t, dataset.Getxattr(nil, &fuse.GetxattrRequest{Name: quotaUsageXAttr}, resp))
+	assert.Equal(t, "60.0% names /p1/data", string(resp.Xattr))
+	assert.Nil(t, project.Getxattr(nil, &fuse.GetxattrRequest{Name: quotaUsageXAttr}, resp))
+	assert.Equal(t, "95.0% space /p1", string(resp.Xattr))
+	assert.Nil(t, root.(*DirINode).Getxattr(nil, &fuse.GetxattrRequest{Name: quotaUsageXAttr}, resp))
+	assert.Equal(t, "none", string(resp.Xattr))
+
+	saveFlags(t, &quotaWarningPercent, &quotaCheckInterval)
+	quotaWarningPercent, quotaCheckInterval = 90, time.Minute
+	metrics.Snapshot(true)
+	dataset.checkQuota()
+	assert.Equal(t, uint64(0), metrics.Snapshot(false)[QuotaWarning].Count)
+	project.checkQuota()
+	project.checkQuota()
+	assert.Equal(t, uint64(1), metrics.Snapshot(false)[QuotaWarning].Count)
+	mockClock.NotifyTimeElapsed(2 * time.Minute)
+	project.checkQuota()
+	assert.Equal(t, uint64(2), metrics.Snapshot(true)[QuotaWarning].Count)
+}
+
+// Testing that statfs reports the closest quotas of the source dir with -statfsQuota, and the cluster without them
+func TestStatfsQuota(t *testing.T) {
+	saveFlags(t, &statfsQuota)
+	statfsQuota = true
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/p1", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 1024 * 1024, used: 1024, remaining: 1023 * 1024}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().GetContentSummary("/p1").Return(ContentSummary{SpaceConsumed: 10 * 1024, FileCount: 10, DirectoryCount: 2, NameQuota: 100, SpaceQuota: 100 * 1024}, nil)
+
+	resp := &fuse.StatfsResponse{}
+	assert.Nil(t, fs.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(100), resp.Blocks)
+	assert.Equal(t, uint64(90), resp.Bfree)
+	assert.Equal(t, uint64(90), resp.Bavail)
+	assert.Equal(t, uint64(100), resp.Files)
+	assert.Equal(t, uint64(88), resp.Ffree)
+
+	// the summary is reused for statfsQuotaTTL
+	assert.Nil(t, fs.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(100), resp.Blocks)
+
+	// the free space is capped by the cluster, and the cluster is reported without quota
+	mockClock.monotonic += 2 * statfsQuotaTTL
+	hdfsAccessor.EXPECT().GetContentSummary("/p1").Return(ContentSummary{SpaceConsumed: 10 * 1024, NameQuota: -1, SpaceQuota: 10 * 1024 * 1024}, nil)
+	resp = &fuse.StatfsResponse{}
+	assert.Nil(t, fs.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(10*1024), resp.Blocks)
+	assert.Equal(t, uint64(1023), resp.Bfree)
+	assert.Equal(t, uint64(0), resp.Files)
+
+	// the quota of a directory above the source dir applies to it
+	mockClock.monotonic += 2 * statfsQuotaTTL
+	hdfsAccessor.EXPECT().GetContentSummary("/p1").Return(ContentSummary{NameQuota: -1, SpaceQuota: -1}, nil)
+	hdfsAccessor.EXPECT().GetContentSummary("/").Return(ContentSummary{SpaceConsumed: 50 * 1024, NameQuota: -1, SpaceQuota: 200 * 1024}, nil)
+	resp = &fuse.StatfsResponse{}
+	assert.Nil(t, fs.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(200), resp.Blocks)
+	assert.Equal(t, uint64(150), resp.Bfree)
+
+	mockClock.monotonic += 2 * statfsQuotaTTL
+	hdfsAccessor.EXPECT().GetContentSummary("/p1").Return(ContentSummary{NameQuota: -1, SpaceQuota: -1}, nil)
+	hdfsAccessor.EXPECT().GetContentSummary("/").Return(ContentSummary{NameQuota: -1, SpaceQuota: -1}, nil)
+	resp = &fuse.StatfsResponse{}
+	assert.Nil(t, fs.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(1024), resp.Blocks)
+	assert.Equal(t, uint64(1023), resp.Bfree)
+}
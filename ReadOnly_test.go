@@ -0,0 +1,47 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that a read-only mount refuses the mutations with EROFS without any RPC
+func TestReadOnlyMount(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, true, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	dir := root.(*DirINode)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+
+	_, err := dir.Mkdir(nil, &fuse.MkdirRequest{Name: "foo", Mode: os.ModeDir | 0755})
+	assert.Equal(t, syscall.EROFS, err)
+	_, _, err = dir.Create(nil, &fuse.CreateRequest{Name: "foo", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Equal(t, syscall.EROFS, err)
+	assert.Equal(t, syscall.EROFS, dir.Remove(nil, &fuse.RemoveRequest{Name: "foo"}))
+	assert.Equal(t, syscall.EROFS, dir.Rename(nil, &fuse.RenameRequest{OldName: "foo", NewName: "bar"}, dir))
+	_, err = dir.Symlink(nil, &fuse.SymlinkRequest{NewName: "link", Target: "foo"})
+	assert.Equal(t, syscall.EROFS, err)
+	assert.Equal(t, syscall.EROFS, dir.Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: 0700}, &fuse.SetattrResponse{}))
+
+	file := dir.NodeFromAttrs(Attrs{Name: "data.csv", Mode: 0644, Size: 5}).(*FileINode)
+	_, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Equal(t, syscall.EROFS, err)
+	_, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly | fuse.OpenTruncate}, &fuse.OpenResponse{})
+	assert.Equal(t, syscall.EROFS, err)
+	assert.Equal(t, syscall.EROFS, file.Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrSize}, &fuse.SetattrResponse{}))
+	assert.Equal(t, syscall.EROFS, file.Setxattr(nil, &fuse.SetxattrRequest{Name: aclAccessXAttr, Xattr: []byte{}}))
+
+	// reads are served
+	hdfsAccessor.EXPECT().OpenRead("/data.csv").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 5}, nil)
+	_, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+// Columnar formats, e.g., parquet and ORC, keep their metadata at the end of the file. Their
+// readers open the file, read the last few KB and then jump to the column chunks they need.
+// The last -footerCacheSize bytes of files of at least -footerCacheMinFileSize bytes are kept
+// in memory with the inode, so reopening the file does not read the footer from HDFS again
+type FileFooter struct {
+	Version FileVersion
+	Offset  int64 // offset of the first byte of Data in the file
+	Data    []byte
+}
+
+// Returns the offset where the cached tail of a file of the version starts, false if the
+// file is too small for its tail to be cached
+func footerOffset(version FileVersion) (int64, bool) {
+	if footerCacheSize <= 0 || version.Size < footerCacheMinFileSize || version.Size <= footerCacheSize {
+		return 0, false
+	}
+	return version.Size - footerCacheSize, true
+}
+
+// Classifies the reads of an open file as sequential or random. A read which does not start
+// where the previous one ended is random, as is a first read of the tail of a file which has its
+// tail cached, the pattern of columnar readers. The readahead window adapts to how much of the
+// previous readahead was read: it doubles, up to -readaheadBlocks, while most of it is read,
+// halves otherwise, and drops to zero while the reads are random
+type ReadPattern struct {
+	next   int64 // offset following the last read
+	reads  int64
+	random bool
+
+	window    int   // blocks read ahead by the next miss
+	aheadFrom int64 // first block of the last readahead
+	aheadTo   int64 // block following the last readahead
+	aheadUsed int64 // blocks of the last readahead which were read
+	lastHit   int64
+}
+
+// Accounts a read of n bytes at the offset of a file of the version
+func (p *ReadPattern) Record(off int64, n int, version FileVersion) {
+	if p.reads == 0 {
+		start, ok := footerOffset(version)
+		p.random = ok && off >= start
+	} else {
+		p.random = off != p.next
+	}
+	p.reads++
+	p.next = off + int64(n)
+}
+
+// Returns true if the last read was random
+func (p *ReadPattern) Random() bool {
+	return p.random
+}
+
+// Accounts a read of a cached block, returns true if the block was read ahead
+func (p *ReadPattern) Hit(index int64) bool {
+	if index < p.aheadFrom || index >= p.aheadTo {
+		return false
+	}
+	if index != p.lastHit {
+		p.aheadUsed++
+		p.lastHit = index
+	}
+	return true
+}
+
+// Returns the number of blocks to read ahead of the block missing the cache, at most max
+func (p *ReadPattern) Readahead(index int64, max int) int {
+	if p.random {
+		p.window = 0
+	} else if p.aheadTo > p.aheadFrom {
+		if 2*p.aheadUsed >= p.aheadTo-p.aheadFrom {
+			p.window *= 2
+		} else {
+			p.window /= 2
+		}
+	}
+	if !p.random && p.window == 0 {
+		p.window = 1
+	}
+	if p.window > max {
+		p.window = max
+	}
+	p.aheadFrom, p.aheadTo, p.aheadUsed = index+1, index+1+int64(p.window), 0
+	return p.window
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that footer reads and jumps are random, contiguous reads sequential
+func TestReadPattern(t *testing.T) {
+	saveFlags(t, &footerCacheSize, &footerCacheMinFileSize)
+	footerCacheSize, footerCacheMinFileSize = 100, 1000
+	version := FileVersion{FileId: 1, Size: 10000}
+
+	var columnar ReadPattern
+	columnar.Record(9992, 8, version)
+	assert.True(t, columnar.Random())
+	columnar.Record(9900, 92, version)
+	assert.True(t, columnar.Random())
+	columnar.Record(100, 50, version)
+	assert.True(t, columnar.Random())
+	columnar.Record(150, 50, version)
+	assert.False(t, columnar.Random())
+
+	var sequential ReadPattern
+	sequential.Record(0, 4096, version)
+	assert.False(t, sequential.Random())
+	sequential.Record(4096, 4096, version)
+	assert.False(t, sequential.Random())
+}
+
+// Testing that the tail of a file is read from HDFS once, also by a later open of the file
+func TestFooterCache(t *testing.T) {
+	saveFlags(t, &footerCacheSize, &footerCacheMinFileSize)
+	footerCacheSize, footerCacheMinFileSize = 1000, 5000
+	stats := &ReaderStats{}
+	reader := versionedPseudoRandomReader{&MockReadSeekCloserWithPseudoRandomContent{FileSize: 10000, ReaderStats: stats}}
+	fs := &FileSystem{Clock: &MockClock{}}
+	file := &FileINode{FileSystem: fs, Attrs: Attrs{Name: "f"}, Parent: &DirINode{FileSystem: fs}}
+
+	buf := make([]byte, 8)
+	n, err := (&RemoteROFileProxy{hdfsReader: reader, file: file}).ReadAt(buf, 9992)
+	assert.Nil(t, err)
+	assert.Equal(t, 8, n)
+	assert.Equal(t, generateByteAtOffset(9992), buf[0])
+	reads := stats.ReadCount
+
+	proxy := &RemoteROFileProxy{hdfsReader: reader, file: file}
+	buf = make([]byte, 500)
+	n, err = proxy.ReadAt(buf, 9500)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, n)
+	assert.Equal(t, generateByteAtOffset(9500), buf[0])
+	n, err = proxy.ReadAt(buf, 9800)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 200, n)
+	assert.Equal(t, reads, stats.ReadCount)
+
+	// a new version of the file reads the tail again
+	file.footer.Version.Mtime = 0
+	proxy.ReadAt(buf, 9500)
+	assert.True(t, stats.ReadCount > reads)
+}
+
+// Testing that the readahead window grows while it is read, shrinks otherwise and is zero for random reads
+func TestAdaptiveReadahead(t *testing.T) {
+	var p ReadPattern
+	version := FileVersion{FileId: 1, Size: 1 << 30}
+	p.Record(0, 100, version)
+	assert.Equal(t, 1, p.Readahead(0, 4))
+	assert.True(t, p.Hit(1))
+	assert.Equal(t, 2, p.Readahead(2, 4))
+	p.Hit(3)
+	p.Hit(3)
+	p.Hit(4)
+	assert.Equal(t, 4, p.Readahead(5, 4))
+	assert.Equal(t, 2, p.Readahead(10, 4))
+	p.Hit(11)
+	assert.Equal(t, 4, p.Readahead(20, 4))
+	assert.False(t, p.Hit(30))
+
+	p.Record(1000000, 100, version)
+	assert.Equal(t, 0, p.Readahead(40, 4))
+	p.Record(1000100, 100, version)
+	assert.Equal(t, 1, p.Readahead(40, 4))
+}
+
+// Testing that sequential reads fill the following blocks of the block cache, random reads do not
+func TestCachedReadahead(t *testing.T) {
+	saveFlags(t, &readaheadBlocks)
+	readaheadBlocks = 4
+	dir, _ := ioutil.TempDir("", "blockcache")
+	defer os.RemoveAll(dir)
+	cache, _ := NewBlockCache(dir, 1024*1024, 1000, 0)
+	reader := versionedPseudoRandomReader{&MockReadSeekCloserWithPseudoRandomContent{FileSize: 10000, ReaderStats: &ReaderStats{}}}
+	fs := &FileSystem{BlockCache: cache, Clock: &MockClock{}}
+	proxy := &RemoteROFileProxy{hdfsReader: reader, file: &FileINode{FileSystem: fs, Attrs: Attrs{Name: "f"}, Parent: &DirINode{FileSystem: fs}}}
+
+	buf := make([]byte, 500)
+	for off := int64(0); off < 2500; off += 500 {
+		_, err := proxy.ReadAt(buf, off)
+		assert.Nil(t, err)
+		assert.Equal(t, generateByteAtOffset(off), buf[0])
+	}
+	// block 0 and 1 read ahead, then block 2 to 4
+	count, _ := cache.Usage()
+	assert.Equal(t, 5, count)
+
+	proxy.ReadAt(buf, 8000)
+	count, _ = cache.Usage()
+	assert.Equal(t, 6, count)
+	assert.Equal(t, generateByteAtOffset(8000), buf[0])
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"path"
+	"syscall"
+	"time"
+)
+
+// Deleting a large tree through the mount point issues one RPC per entry, as the
+// kernel unlinks every file and directory one by one. The "rmr" admin command
+// deletes the whole subtree with a single recursive delete RPC instead
+func init() {
+	registerAdminCommand("rmr", AdminCommand{
+		Usage:    "<path>",
+		Help:     "Recursively deletes a directory using a single RPC. Requires -fastRecursiveDelete",
+		PathArgs: 1,
+		Handler:  recursiveDeleteCmd,
+	})
+}
+
+func recursiveDeleteCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	hdfsPath := args[0]
+	if err := filesystem.checkRecursiveDelete(hdfsPath); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	loginfo("Recursively deleting path", Fields{Operation: RemoveAll, Path: hdfsPath})
+	if err := filesystem.getDFSConnector().RemoveAll(hdfsPath); err != nil {
+		logwarn("Failed to recursively delete path", Fields{Operation: RemoveAll, Path: hdfsPath, Error: err})
+		return err
+	}
+	filesystem.forgetCachedNode(hdfsPath)
+	out.Printf("Deleted %s in %v", hdfsPath, time.Since(start))
+	return nil
+}
+
+// Safety checks performed before deleting a subtree with a single RPC
+func (filesystem *FileSystem) checkRecursiveDelete(hdfsPath string) error {
+	if !fastRecursiveDelete {
+		return fmt.Errorf("recursive delete is disabled. Remount with -fastRecursiveDelete to enable it")
+	}
+	if filesystem.ReadOnly {
+		return syscall.EROFS
+	}
+	if hdfsPath == "/" || path.Clean(hdfsPath) == path.Clean(filesystem.SrcDir) {
+		return fmt.Errorf("refusing to delete the root of the mount point")
+	}
+	if !filesystem.IsPathAllowed(hdfsPath) {
+		return syscall.ENOENT
+	}
+	if err := checkProtected(RemoveAll, hdfsPath, protectDeletedTree); err != nil {
+		return fmt.Errorf("%s is or may contain a path protected by -protectedPaths. Error: %v", hdfsPath, err)
+	}
+	if _, err := filesystem.getDFSConnector().Stat(hdfsPath); err != nil {
+		return err
+	}
+	// files open in the subtree may have data which is not uploaded yet
+	if node, ok := filesystem.cachedNode(hdfsPath).(*DirINode); ok {
+		if openFile := node.findOpenFile(); openFile != "" {
+			return fmt.Errorf("%s is open, refusing to delete %s. Error: %v", openFile, hdfsPath, syscall.EBUSY)
+		}
+	} else if file, ok := filesystem.cachedNode(hdfsPath).(*FileINode); ok && file.countActiveHandles() > 0 {
+		return fmt.Errorf("%s is open. Error: %v", hdfsPath, syscall.EBUSY)
+	}
+	return nil
+}
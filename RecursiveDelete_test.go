@@ -0,0 +1,56 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHdfsPathFromMountPath(t *testing.T) {
+	fs, _ := NewFileSystem(nil, "/src", []string{"*"}, false, NewDefaultRetryPolicy(WallClock{}), WallClock{})
+	fs.MountPoint = "/mnt/hopsfs"
+	p, err := fs.HdfsPathFromMountPath("/mnt/hopsfs/a/b")
+	assert.Nil(t, err)
+	assert.Equal(t, "/src/a/b", p)
+	p, err = fs.HdfsPathFromMountPath("/mnt/hopsfs")
+	assert.Nil(t, err)
+	assert.Equal(t, "/src", p)
+	_, err = fs.HdfsPathFromMountPath("/mnt/other/a")
+	assert.NotNil(t, err)
+	_, err = fs.HdfsPathFromMountPath("a/b")
+	assert.NotNil(t, err)
+}
+
+// Testing that rmr is refused unless enabled, for the mount root and for subtrees with open files
+func TestRecursiveDelete(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	out := &AdminOutput{encoder: json.NewEncoder(&bytes.Buffer{})}
+
+	fastRecursiveDelete = false
+	assert.NotNil(t, recursiveDeleteCmd(fs, []string{"/dir"}, out))
+
+	saveFlags(t, &fastRecursiveDelete)
+	fastRecursiveDelete = true
+	assert.NotNil(t, recursiveDeleteCmd(fs, []string{"/"}, out))
+
+	root, _ := fs.Root()
+	dir := root.(*DirINode).NodeFromAttrs(Attrs{Name: "dir", Mode: os.ModeDir | 0755}).(*DirINode)
+	file := dir.NodeFromAttrs(Attrs{Name: "file", Mode: 0644}).(*FileINode)
+	file.AddHandle(&FileHandle{File: file})
+	hdfsAccessor.EXPECT().Stat("/dir").Return(Attrs{Name: "dir", Mode: os.ModeDir | 0755}, nil).AnyTimes()
+	assert.NotNil(t, recursiveDeleteCmd(fs, []string{"/dir"}, out))
+
+	file.activeHandles = nil
+	hdfsAccessor.EXPECT().RemoveAll("/dir").Return(nil)
+	assert.Nil(t, recursiveDeleteCmd(fs, []string{"/dir"}, out))
+	assert.Nil(t, fs.cachedNode("/dir"))
+}
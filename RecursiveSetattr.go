@@ -0,0 +1,162 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Recursive chmod/chown from the shell issues a lookup and a setattr RPC per entry.
+// The "chmodr" and "chownr" admin commands walk the tree inside the mount process
+// with bounded parallelism instead, reporting progress to the admin client
+func init() {
+	registerAdminCommand("chmodr", AdminCommand{
+		Usage:    "<path> <octal mode>",
+		Help:     "Recursively changes the mode of a directory tree",
+		PathArgs: 1,
+		Handler:  recursiveChmodCmd,
+	})
+	registerAdminCommand("chownr", AdminCommand{
+		Usage:    "<path> <user>[:<group>]",
+		Help:     "Recursively changes the HDFS owner and group of a directory tree",
+		PathArgs: 1,
+		Handler:  recursiveChownCmd,
+	})
+}
+
+func recursiveChmodCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: chmodr <path> <octal mode>")
+	}
+	perm, err := strconv.ParseUint(args[1], 8, 32)
+	if err != nil || perm > 07777 {
+		return fmt.Errorf("invalid mode %s", args[1])
+	}
+	mode := fileModeFromHdfsPerm(uint32(perm))
+	return filesystem.walkAndApply(args[0], Chmod, out, func(hdfsAccessor HdfsAccessor, p string) error {
+		return hdfsAccessor.Chmod(p, mode)
+	})
+}
+
+func recursiveChownCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: chownr <path> <user>[:<group>]")
+	}
+	owner := args[1]
+	group := ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		owner, group = owner[:i], owner[i+1:]
+	}
+	if owner == "" && group == "" {
+		return fmt.Errorf("invalid owner %s", args[1])
+	}
+	// empty owner or group is not changed by the backend
+	return filesystem.walkAndApply(args[0], Chown, out, func(hdfsAccessor HdfsAccessor, p string) error {
+		return hdfsAccessor.Chown(p, owner, group)
+	})
+}
+
+// Applies fn to root and all the entries below it. Directories are listed and entries
+// are updated concurrently by at most -recursiveOpsParallelism goroutines
+func (filesystem *FileSystem) walkAndApply(root string, operation string, out *AdminOutput, fn func(HdfsAccessor, string) error) error {
+	if filesystem.ReadOnly {
+		return syscall.EROFS
+	}
+	if !filesystem.IsPathAllowed(root) {
+		return syscall.ENOENT
+	}
+	attrs, err := filesystem.getDFSConnector().Stat(root)
+	if err != nil {
+		return err
+	}
+
+	parallelism := recursiveOpsParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	var done, failed uint64
+	var firstErr error
+	var errMutex sync.Mutex
+	start := time.Now()
+
+	apply := func(p string) {
+		defer func() { <-sem }()
+		if err := fn(filesystem.getDFSConnector(), p); err != nil {
+			logwarn("Recursive operation failed", Fields{Operation: operation, Path: p, Error: err})
+			atomic.AddUint64(&failed, 1)
+			errMutex.Lock()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %v", p, err)
+			}
+			errMutex.Unlock()
+		}
+		if n := atomic.AddUint64(&done, 1); n%1000 == 0 {
+			out.Printf("%d entries processed", n)
+		}
+	}
+
+	var visit func(dir string)
+	visit = func(dir string) {
+		defer wg.Done()
+		sem <- struct{}{}
+		entries, err := filesystem.getDFSConnector().ReadDir(dir)
+		<-sem
+		if err != nil {
+			logwarn("Failed to list directory", Fields{Operation: operation, Path: dir, Error: err})
+			atomic.AddUint64(&failed, 1)
+			return
+		}
+		for _, entry := range entries {
+			child := path.Join(dir, entry.Name)
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				apply(child)
+			}()
+			if entry.Mode&os.ModeDir != 0 {
+				wg.Add(1)
+				go visit(child)
+			}
+		}
+	}
+
+	sem <- struct{}{}
+	apply(root)
+	if attrs.Mode&os.ModeDir != 0 {
+		wg.Add(1)
+		visit(root)
+	}
+	wg.Wait()
+
+	filesystem.expireCachedAttrs(root)
+	out.Printf("%d entries processed in %v, %d failures", atomic.LoadUint64(&done), time.Since(start), atomic.LoadUint64(&failed))
+	if firstErr != nil {
+		return fmt.Errorf("%d entries failed. First error %v", atomic.LoadUint64(&failed), firstErr)
+	}
+	return nil
+}
+
+// Expires cached attributes of an HDFS path and its cached subtree,
+// so that the next access fetches them from the backend
+func (filesystem *FileSystem) expireCachedAttrs(hdfsPath string) {
+	node := filesystem.cachedNode(hdfsPath)
+	if dir, ok := node.(*DirINode); ok {
+		dir.expireCachedAttrs()
+	} else if file, ok := node.(*FileINode); ok {
+		file.lockFile()
+		file.InvalidateMetadataCache()
+		file.unlockFile()
+		filesystem.invalidateNodeAttr(file)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that chmodr applies the mode to every entry of the tree
+func TestRecursiveChmod(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	out := &AdminOutput{encoder: json.NewEncoder(&bytes.Buffer{})}
+
+	hdfsAccessor.EXPECT().Stat("/dir").Return(Attrs{Name: "dir", Mode: os.ModeDir | 0755}, nil)
+	hdfsAccessor.EXPECT().ReadDir("/dir").Return([]Attrs{{Name: "sub", Mode: os.ModeDir | 0755}, {Name: "a", Mode: 0644}}, nil)
+	hdfsAccessor.EXPECT().ReadDir("/dir/sub").Return([]Attrs{{Name: "b", Mode: 0644}}, nil)
+	for _, p := range []string{"/dir", "/dir/sub", "/dir/a", "/dir/sub/b"} {
+		hdfsAccessor.EXPECT().Chmod(p, os.FileMode(0750)).Return(nil)
+	}
+	assert.Nil(t, recursiveChmodCmd(fs, []string{"/dir", "750"}, out))
+	assert.NotNil(t, recursiveChmodCmd(fs, []string{"/dir", "999"}, out))
+}
@@ -4,12 +4,18 @@ package main
 
 import (
 	"errors"
+	"io"
 	"os"
 )
 
 type RemoteROFileProxy struct {
 	hdfsReader ReadSeekCloser
 	file       *FileINode
+	pattern    ReadPattern
+	ahead      BackgroundReadahead
+	path       string // HDFS path the reader was opened for
+
+	connector HdfsAccessor // connection of the user who opened the reader, nil for the mount's
 }
 
 var _ FileProxy = (*RemoteROFileProxy)(nil)
@@ -35,6 +41,20 @@ func (p *RemoteROFileProxy) ReadAt(b []byte, off int64) (int, error) {
 	if off < 0 {
 		return 0, &os.PathError{Op: "readat", Path: p.file.AbsolutePath(), Err: errors.New("negative offset")}
 	}
+	if v, ok := p.hdfsReader.(VersionedReader); ok {
+		if version, err := v.Version(); err == nil {
+			p.pattern.Record(off, len(b), version)
+			if start, ok := footerOffset(version); ok && off >= start {
+				return p.footerReadAt(version, start, b, off)
+			}
+			if cache := p.file.FileSystem.BlockCache; cache != nil {
+				return p.cachedReadAt(cache, version, b, off)
+			}
+		}
+	}
+	if n, err, ok := p.readAhead(b, off); ok {
+		return n, err
+	}
 
 	if err := p.hdfsReader.Seek(off); err != nil {
 		return 0, err
@@ -56,6 +76,97 @@ func (p *RemoteROFileProxy) ReadAt(b []byte, off int64) (int, error) {
 	return n, err
 }
 
+// Reads through the block cache. The version of the reader is the one of the data it returns
+func (p *RemoteROFileProxy) cachedReadAt(cache *BlockCache, version FileVersion, b []byte, off int64) (int, error) {
+	n := 0
+	for len(b) > 0 && off < version.Size {
+		index := off / cache.BlockSize
+		block, hit := cache.Get(version, index)
+		if hit && p.pattern.Hit(index) {
+			metrics.Record(ReadaheadOp, 0, int64(len(block)), 0, true, nil)
+		}
+		if !hit {
+			// sequential reads read the following blocks too, with a single seek
+			count := 1 + int64(p.pattern.Readahead(index, readaheadBlocks))
+			if count > 1 {
+				metrics.Record(ReadaheadFetched, 0, (count-1)*cache.BlockSize, 0, false, nil)
+			}
+			data, err := p.readBlock(index*cache.BlockSize, count*cache.BlockSize)
+			if err != nil && err != io.EOF {
+				return n, err
+			}
+			for i := int64(0); i < count && i*cache.BlockSize < int64(len(data)); i++ {
+				end := (i + 1) * cache.BlockSize
+				if end > int64(len(data)) {
+					end = int64(len(data))
+				}
+				if end-i*cache.BlockSize == cache.BlockSize || (index+i)*cache.BlockSize+end-i*cache.BlockSize == version.Size {
+					cache.Put(version, index+i, data[i*cache.BlockSize:end])
+				}
+			}
+			block = data
+			if int64(len(block)) > cache.BlockSize {
+				block = block[:cache.BlockSize]
+			}
+		}
+		metrics.Record(BlockCacheOp, 0, int64(len(block)), 0, hit, nil)
+		start := off - index*cache.BlockSize
+		if start >= int64(len(block)) {
+			break
+		}
+		m := copy(b, block[start:])
+		n += m
+		off += int64(m)
+		b = b[m:]
+	}
+	logdebug("RemoteFileProxy ReadAt", p.file.logInfo(Fields{Operation: Read, Bytes: n, Offset: off}))
+	if len(b) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Reads from the cached tail of the file, reading it from HDFS if it is not cached for the version
+func (p *RemoteROFileProxy) footerReadAt(version FileVersion, start int64, b []byte, off int64) (int, error) {
+	footer := p.file.footer
+	hit := footer != nil && footer.Version == version
+	if !hit {
+		data, err := p.readBlock(start, version.Size-start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		footer = &FileFooter{Version: version, Offset: start, Data: data}
+		p.file.footer = footer
+	}
+	metrics.Record(FooterCacheOp, 0, int64(len(b)), 0, hit, nil)
+	n := 0
+	if off-footer.Offset < int64(len(footer.Data)) {
+		n = copy(b, footer.Data[off-footer.Offset:])
+	}
+	logdebug("RemoteFileProxy ReadAt", p.file.logInfo(Fields{Operation: Read, Bytes: n, Offset: off}))
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Reads up to size bytes at the offset from HDFS
+func (p *RemoteROFileProxy) readBlock(off int64, size int64) ([]byte, error) {
+	if err := p.hdfsReader.Seek(off); err != nil {
+		return nil, err
+	}
+	block := make([]byte, size)
+	n := 0
+	for n < len(block) {
+		m, err := p.hdfsReader.Read(block[n:])
+		n += m
+		if err != nil {
+			return block[:n], err
+		}
+	}
+	return block, nil
+}
+
 func (p *RemoteROFileProxy) SeekToStart() (err error) {
 	p.file.lockFileHandles()
 	defer p.file.unlockFileHandles()
@@ -70,6 +181,7 @@ func (p *RemoteROFileProxy) Read(b []byte) (n int, err error) {
 
 func (p *RemoteROFileProxy) Close() error {
 	//NOTE: Locking is done in File.go
+	p.closeReadahead()
 	return p.hdfsReader.Close()
 }
 
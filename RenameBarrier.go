@@ -0,0 +1,43 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+)
+
+// A file is renamed in HDFS right away, while the data written to its staging file is only
+// uploaded when the file is flushed. Without a barrier, "mv" right after writing a file, before
+// the kernel sent the flush of the close, or while a writer still has the file open, moved an
+// empty or partial file, and readers of the new path saw it until the upload completed. Rename
+// is a write barrier: the data written to the renamed file, or to the open files of a renamed
+// directory, is uploaded to the old path before the rename, so that the new path has the
+// complete content. The staging files stay with the open files, which upload their later writes
+// to the new path. A failed upload fails the rename
+func (file *FileINode) flushBeforeRename() error {
+	if file.Dirty() == 0 {
+		return nil
+	}
+	loginfo("Uploading the written data before the rename", file.logInfo(Fields{Operation: Rename, Bytes: file.Dirty()}))
+	if err := file.syncHandles(nil, &fuse.FsyncRequest{}); err != nil {
+		logwarn("Failed to upload the written data, failing the rename", file.logInfo(Fields{Operation: Rename, Error: err}))
+		return err
+	}
+	return nil
+}
+
+// Uploads the data written to the open files of the cached subtree before it is renamed
+func (dir *DirINode) flushBelowBeforeRename() error {
+	for _, node := range dir.cachedEntries() {
+		var err error
+		if fnode, ok := node.(*FileINode); ok {
+			err = fnode.flushBeforeRename()
+		} else if dnode, ok := node.(*DirINode); ok {
+			err = dnode.flushBelowBeforeRename()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a file system whose HDFS files accept any content, with the written data collected in uploaded
+func renameBarrierFs(mockCtrl *gomock.Controller, hdfsAccessor *MockHdfsAccessor, uploaded *[]byte) *FileSystem {
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	hdfsAccessor.EXPECT().Stat(gomock.Any()).DoAndReturn(func(path string) (Attrs, error) {
+		return Attrs{Name: path[1:], Mode: os.FileMode(0644)}, nil
+	}).AnyTimes()
+	hdfsAccessor.EXPECT().Chown(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().Remove(gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().Mkdir(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hdfswriter := NewMockHdfsWriter(mockCtrl)
+	hdfswriter.EXPECT().Write(gomock.Any()).DoAndReturn(func(p []byte) (int, error) {
+		*uploaded = append(*uploaded, p...)
+		return len(p), nil
+	}).AnyTimes()
+	hdfswriter.EXPECT().Close().Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().CreateFile(gomock.Any(), gomock.Any(), gomock.Any()).Return(hdfswriter, nil).AnyTimes()
+	return fs
+}
+
+// Testing that the data written to a file is uploaded before the file is renamed, and not again on close
+func TestRenameFlushesWrittenData(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	var uploaded []byte
+	fs := renameBarrierFs(mockCtrl, hdfsAccessor, &uploaded)
+	root, _ := fs.Root()
+	_, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "file.part",
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("hello world"), Offset: 0}, &fuse.WriteResponse{}))
+
+	hdfsAccessor.EXPECT().Rename("/file.part", "/file").DoAndReturn(func(oldPath, newPath string) error {
+		assert.Equal(t, "hello world", string(uploaded))
+		return nil
+	})
+	assert.Nil(t, root.(*DirINode).Rename(nil, &fuse.RenameRequest{OldName: "file.part", NewName: "file"}, root))
+	assert.Equal(t, int64(0), fs.Dirty.Dirty())
+
+	uploaded = nil
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+	assert.Nil(t, uploaded)
+}
+
+// Testing that the data written to the open files of a directory is uploaded before the directory is renamed
+func TestRenameFlushesFilesOfDir(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	var uploaded []byte
+	fs := renameBarrierFs(mockCtrl, hdfsAccessor, &uploaded)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Mkdir(nil, &fuse.MkdirRequest{Name: "attempt", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+	_, h, err := node.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "part-0",
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("rows"), Offset: 0}, &fuse.WriteResponse{}))
+
+	hdfsAccessor.EXPECT().Rename("/attempt", "/task").DoAndReturn(func(oldPath, newPath string) error {
+		assert.Equal(t, "rows", string(uploaded))
+		return nil
+	})
+	assert.Nil(t, root.(*DirINode).Rename(nil, &fuse.RenameRequest{OldName: "attempt", NewName: "task"}, root))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
@@ -0,0 +1,198 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Staging files of at least -resumableUploadThreshold bytes are uploaded to a hidden temporary
+// file next to the target, in parts of -resumableUploadPartSize bytes. Every part is appended
+// and closed, and the progress is recorded in a <staging file>.upload manifest. A retried
+// upload, or a mount restarted after a crash, continues from the length of the temporary file
+// instead of starting over. The temporary file replaces the target once complete
+const uploadManifestSuffix = ".upload"
+
+// Manifests of other mounts are kept this long for their mount to resume them, then reaped
+const uploadManifestExpiry = 24 * time.Hour
+
+// Progress of the upload of a staging file
+type UploadManifest struct {
+	Path     string      `json:"path"`      // HDFS path the staging file is uploaded to
+	TempPath string      `json:"temp_path"` // HDFS file the parts are appended to
+	Mode     os.FileMode `json:"mode"`
+	Size     int64       `json:"size"`     // size of the staging file when the upload started
+	ModTime  time.Time   `json:"mod_time"` // modification time of the staging file when the upload started
+	Uploaded int64       `json:"uploaded"` // bytes in the completed parts
+}
+
+// Returns the manifest of a staging file, nil if there is none
+func loadUploadManifest(stagingPath string) *UploadManifest {
+	data, err := ioutil.ReadFile(stagingPath + uploadManifestSuffix)
+	if err != nil {
+		return nil
+	}
+	var manifest UploadManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil
+	}
+	return &manifest
+}
+
+func saveUploadManifest(stagingPath string, manifest *UploadManifest) error {
+	data, _ := json.Marshal(manifest)
+	tmp := stagingPath + uploadManifestSuffix + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, stagingPath+uploadManifestSuffix)
+}
+
+// Uploads a staging file in parts, continuing a previous upload of the same content if there is one
+func uploadStagingFile(hdfsAccessor HdfsAccessor, stagingFile *os.File, hdfsPath string, mode os.FileMode, partSize int64) (int64, error) {
+	info, err := stagingFile.Stat()
+	if err != nil {
+		return 0, err
+	}
+	stagingPath := stagingFile.Name()
+	manifest := loadUploadManifest(stagingPath)
+	if manifest != nil && (manifest.Path != hdfsPath || manifest.Size != info.Size() || !manifest.ModTime.Equal(info.ModTime())) {
+		// the file was written or renamed since the upload started
+		hdfsAccessor.Remove(manifest.TempPath)
+		manifest = nil
+	}
+	if manifest == nil {
+		manifest = &UploadManifest{
+			Path:     hdfsPath,
+			TempPath: path.Join(path.Dir(hdfsPath), fmt.Sprintf(".%s.hopsfs-upload-%s", path.Base(hdfsPath), filepath.Base(stagingPath))),
+			Mode:     mode,
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+		}
+		// owner writable until complete, appending needs write permission
+		w, err := hdfsAccessor.CreateFile(manifest.TempPath, 0600, true)
+		if err != nil {
+			return 0, err
+		}
+		if err := w.Close(); err != nil {
+			return 0, err
+		}
+		if err := saveUploadManifest(stagingPath, manifest); err != nil {
+			return 0, err
+		}
+	} else {
+		loginfo("Resuming upload", Fields{Operation: Write, Path: hdfsPath, TmpFile: stagingPath, Bytes: manifest.Uploaded, FileSize: manifest.Size})
+	}
+
+	for {
+		w, err := hdfsAccessor.Append(manifest.TempPath)
+		if err != nil {
+			return manifest.Uploaded, err
+		}
+		// the temporary file only ever has a prefix of the staging file, possibly more than the
+		// manifest records if the process died before recording the last part
+		attrs, err := hdfsAccessor.Stat(manifest.TempPath)
+		if err != nil {
+			w.Close()
+			return manifest.Uploaded, err
+		}
+		offset := int64(attrs.Size)
+		if offset > manifest.Size {
+			w.Close()
+			hdfsAccessor.Remove(manifest.TempPath)
+			os.Remove(stagingPath + uploadManifestSuffix)
+			return manifest.Uploaded, fmt.Errorf("%s is longer than the staging file", manifest.TempPath)
+		}
+		if offset == manifest.Size {
+			if err := w.Close(); err != nil {
+				return manifest.Uploaded, err
+			}
+			break
+		}
+		end := offset + partSize
+		if end > manifest.Size {
+			end = manifest.Size
+		}
+		_, err = io.Copy(writerOnly{w}, io.NewSectionReader(stagingFile, offset, end-offset))
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return manifest.Uploaded, err
+		}
+		manifest.Uploaded = end
+		saveUploadManifest(stagingPath, manifest)
+		logdebug("Uploaded part", Fields{Operation: Write, Path: hdfsPath, Bytes: manifest.Uploaded, FileSize: manifest.Size})
+	}
+
+	// the previous content stays in place until the upload is complete
+	hdfsAccessor.Remove(hdfsPath)
+	if err := hdfsAccessor.Rename(manifest.TempPath, hdfsPath); err != nil {
+		return manifest.Uploaded, err
+	}
+	if err := hdfsAccessor.Chmod(hdfsPath, manifest.Mode); err != nil {
+		logwarn("Unable to set the mode of the uploaded file", Fields{Operation: Chmod, Path: hdfsPath, Mode: manifest.Mode, Error: err})
+	}
+	os.Remove(stagingPath + uploadManifestSuffix)
+	return manifest.Size, nil
+}
+
+// Adapts HdfsWriter to io.Writer
+type writerOnly struct {
+	w HdfsWriter
+}
+
+func (w writerOnly) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+// Called by the staging reaper for a staging file of a process which is not running anymore.
+// Takes the file over and resumes its upload in the background if it belongs to this mount and
+// has a manifest. Returns false if the file is not taken over
+func (filesystem *FileSystem) resumeUpload(stagingPath string, owner StagingOwner) bool {
+	manifest := loadUploadManifest(stagingPath)
+	if manifest == nil || owner.MountPoint != filesystem.MountPoint {
+		return false
+	}
+	if info, err := os.Stat(stagingPath); err != nil || info.Size() != manifest.Size || !info.ModTime().Equal(manifest.ModTime) {
+		// written after the upload started, the data was never flushed by the application
+		return false
+	}
+	claimed, err := claimStagingFile(stagingPath)
+	if err != nil {
+		return false
+	}
+	stagingFile, err := os.Open(claimed)
+	if err != nil {
+		os.Remove(claimed)
+		os.Remove(claimed + uploadManifestSuffix)
+		return true
+	}
+	tagStagingFile(stagingFile, StagingOwner{MountPoint: filesystem.MountPoint, Path: manifest.Path})
+
+	go func() {
+		defer removeStagingFile(stagingFile)
+		hdfsAccessor := filesystem.getDFSConnector()
+		// data written to the target after the crash wins over the interrupted upload. The
+		// modification time is set by the namenode, allowing for the skew of its clock
+		if attrs, err := hdfsAccessor.Stat(manifest.Path); err == nil && attrs.Mtime.After(manifest.ModTime.Add(clockSkewTolerance)) {
+			logwarn("Target was modified after the interrupted upload, discarding it", Fields{Operation: Write, Path: manifest.Path, TmpFile: claimed})
+			hdfsAccessor.Remove(manifest.TempPath)
+			return
+		}
+		uploaded, err := uploadStagingFile(hdfsAccessor, stagingFile, manifest.Path, manifest.Mode, resumableUploadPartSize)
+		if err != nil {
+			logerror("Failed to resume upload", Fields{Operation: Write, Path: manifest.Path, TmpFile: claimed, Bytes: uploaded, Error: err})
+			return
+		}
+		loginfo("Completed interrupted upload", Fields{Operation: Write, Path: manifest.Path, Bytes: uploaded})
+	}()
+	return true
+}
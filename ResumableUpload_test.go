@@ -0,0 +1,118 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Remote files of a mocked HDFS, appended to by mocked writers
+func mockRemoteFiles(mockCtrl *gomock.Controller, hdfsAccessor *MockHdfsAccessor, failAppend *int) map[string]*bytes.Buffer {
+	files := make(map[string]*bytes.Buffer)
+	writer := func(buf *bytes.Buffer) HdfsWriter {
+		w := NewMockHdfsWriter(mockCtrl)
+		w.EXPECT().Write(gomock.Any()).DoAndReturn(func(p []byte) (int, error) { return buf.Write(p) }).AnyTimes()
+		w.EXPECT().Flush().Return(nil).AnyTimes()
+		w.EXPECT().Close().Return(nil).AnyTimes()
+		return w
+	}
+	hdfsAccessor.EXPECT().CreateFile(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(path string, mode os.FileMode, overwrite bool) (HdfsWriter, error) {
+		files[path] = &bytes.Buffer{}
+		return writer(files[path]), nil
+	}).AnyTimes()
+	hdfsAccessor.EXPECT().Append(gomock.Any()).DoAndReturn(func(path string) (HdfsWriter, error) {
+		if *failAppend == 0 {
+			return nil, errors.New("connection reset")
+		}
+		*failAppend--
+		return writer(files[path]), nil
+	}).AnyTimes()
+	hdfsAccessor.EXPECT().Stat(gomock.Any()).DoAndReturn(func(path string) (Attrs, error) {
+		if files[path] == nil {
+			return Attrs{}, syscall.ENOENT
+		}
+		return Attrs{Name: filepath.Base(path), Mode: 0644, Size: uint64(files[path].Len())}, nil
+	}).AnyTimes()
+	hdfsAccessor.EXPECT().Remove(gomock.Any()).DoAndReturn(func(path string) error {
+		delete(files, path)
+		return nil
+	}).AnyTimes()
+	hdfsAccessor.EXPECT().Rename(gomock.Any(), gomock.Any()).DoAndReturn(func(oldPath string, newPath string) error {
+		files[newPath] = files[oldPath]
+		delete(files, oldPath)
+		return nil
+	}).AnyTimes()
+	hdfsAccessor.EXPECT().Chmod(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	return files
+}
+
+// Testing that an interrupted upload continues from the uploaded parts
+func TestResumableUpload(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "staging")
+	defer os.RemoveAll(dir)
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	appends := 2
+	files := mockRemoteFiles(mockCtrl, hdfsAccessor, &appends)
+
+	content := []byte("0123456789")
+	f, _ := newStagingFile(dir, "/mnt", "/big")
+	f.Write(content)
+
+	// the third part fails
+	uploaded, err := uploadStagingFile(hdfsAccessor, f, "/big", 0644, 4)
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(8), uploaded)
+	manifest := loadUploadManifest(f.Name())
+	assert.Equal(t, int64(8), manifest.Uploaded)
+	assert.Equal(t, "0123456789"[:8], files[manifest.TempPath].String())
+	_, ok := files["/big"]
+	assert.False(t, ok)
+
+	appends = 2
+	uploaded, err = uploadStagingFile(hdfsAccessor, f, "/big", 0644, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), uploaded)
+	assert.Equal(t, string(content), files["/big"].String())
+	assert.Equal(t, 1, len(files))
+	assert.Nil(t, loadUploadManifest(f.Name()))
+
+	// changed content starts over
+	f.WriteAt([]byte("x"), 10)
+	appends = 10
+	_, err = uploadStagingFile(hdfsAccessor, f, "/big", 0644, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, "0123456789x", files["/big"].String())
+	removeStagingFile(f)
+}
+
+// Testing that interrupted uploads of dead processes are not reaped while they can be resumed
+func TestReapKeepsInterruptedUploads(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "staging")
+	defer os.RemoveAll(dir)
+
+	orphan := filepath.Join(dir, stagingFilePrefix+"999999999-1234")
+	ioutil.WriteFile(orphan, []byte("data"), 0600)
+	ioutil.WriteFile(orphan+stagingOwnerSuffix, []byte(`{"pid":999999999,"mount_point":"/mnt"}`), 0600)
+	saveUploadManifest(orphan, &UploadManifest{Path: "/big", Size: 4})
+
+	assert.Equal(t, 0, reapStagingFiles(dir, nil))
+	entries, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 3, len(entries))
+
+	resumed := ""
+	assert.Equal(t, 0, reapStagingFiles(dir, func(stagingPath string, owner StagingOwner) bool {
+		resumed = owner.MountPoint
+		return true
+	}))
+	assert.Equal(t, "/mnt", resumed)
+}
@@ -4,11 +4,19 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"math/rand"
 	"time"
 )
 
+// The failed RPCs are retried up to -retryMaxAttempts times within -retryTimeLimit, the delays
+// growing from -retryMinDelay by a factor of -retryBackoff up to -retryMaxDelay, each one drawn
+// between -retryMinDelay and the computed delay with -retryJitter, so that the clients which
+// failed together do not retry together. -retries=false fails the operations on the first
+// error, for the users who prefer failing fast to waiting for the cluster to come back
+var retries = true
+
 // Encapsulats policy and logic of handling retries
 type RetryPolicy struct {
 	Clock           Clock         // Interface to clock
@@ -25,6 +33,7 @@ type Op struct {
 	Attempt     int           // 1-based index of current attemmpt
 	Expires     time.Time     // point in time after which no retries are allowed
 	Delay       time.Duration // last delay (exponentially grows)
+	Start       time.Time     // point in time when the operation started
 }
 
 // Creates trivial retry policy which disallows all retries
@@ -50,10 +59,12 @@ func NewDefaultRetryPolicy(clock Clock) *RetryPolicy {
 
 // Starts a new operation (a retry context) and returns data structure to track operation retires
 func (retryPolicy *RetryPolicy) StartOperation() *Op {
+	now := retryPolicy.Clock.Now()
 	return &Op{
 		Attempt:     1,
 		RetryPolicy: retryPolicy,
-		Expires:     retryPolicy.Clock.Now().Add(retryPolicy.TimeLimit)}
+		Start:       now,
+		Expires:     now.Add(retryPolicy.TimeLimit)}
 }
 
 // Prints diagnostic message (using Printf formatting semantic) and
@@ -96,3 +107,21 @@ func (op *Op) ShouldRetry(message string, args ...interface{}) bool {
 	// Allowing to retry
 	return true
 }
+
+// Registers the options of the retry policy
+func registerRetryFlags(flags *flag.FlagSet, retryPolicy *RetryPolicy) {
+	flags.DurationVar(&retryPolicy.TimeLimit, "retryTimeLimit", 5*time.Minute, "time limit for all retry attempts for failed operations")
+	flags.IntVar(&retryPolicy.MaxAttempts, "retryMaxAttempts", 10, "Maxumum retry attempts for failed operations")
+	flags.DurationVar(&retryPolicy.MinDelay, "retryMinDelay", 1*time.Second, "minimum delay between retries (note, first retry always happens immediatelly)")
+	flags.DurationVar(&retryPolicy.MaxDelay, "retryMaxDelay", 60*time.Second, "maximum delay between retries")
+	flags.Float64Var(&retryPolicy.ExpBackoffBase, "retryBackoff", 1.618, "Factor by which the delay between retries grows")
+	flags.BoolVar(&retryPolicy.RandomizeDelays, "retryJitter", true, "Draws each delay between retries at random between -retryMinDelay and the computed delay")
+	flags.BoolVar(&retries, "retries", true, "Retries the failed operations. If false, operations fail on the first error")
+}
+
+// Applies -retries once the options are parsed
+func applyRetryFlags(retryPolicy *RetryPolicy) {
+	if !retries {
+		retryPolicy.MaxAttempts = 1
+	}
+}
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"flag"
 	"testing"
 	"time"
 
@@ -61,3 +62,23 @@ func TestExponentialBackoff(t *testing.T) {
 	}
 	assert.Equal(t, time.Minute, clock.LastSleepDuration) // MaxDelay
 }
+
+// Testing that the options set the retry policy, and that -retries=false disables the retries
+func TestRetryFlags(t *testing.T) {
+	saveFlags(t, &retries)
+	clock := &MockClock{}
+	rp := NewDefaultRetryPolicy(clock)
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerRetryFlags(flags, rp)
+	assert.Nil(t, flags.Parse([]string{"-retryBackoff", "2", "-retryJitter=false", "-retryMinDelay", "1s"}))
+	applyRetryFlags(rp)
+	op := rp.StartOperation()
+	assert.True(t, op.ShouldRetry("Attempt 1"))
+	assert.True(t, op.ShouldRetry("Attempt 2"))
+	assert.True(t, op.ShouldRetry("Attempt 3"))
+	assert.Equal(t, 2*time.Second, clock.LastSleepDuration)
+
+	assert.Nil(t, flags.Parse([]string{"-retries=false"}))
+	applyRetryFlags(rp)
+	assert.False(t, rp.StartOperation().ShouldRetry("Attempt 1"))
+}
@@ -0,0 +1,318 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// With -routingTable, subtrees of the mount are served by other namenodes, e.g., an HDFS
+// archive cluster next to HopsFS, so that users see a single tree. Each line of the table is
+//
+//	<prefix> <namenode:port>[/target] [tls=true|false] [rootCABundle=..] [clientCertificate=..] [clientKey=..] [dataTransferProtection=..]
+//
+// Paths under the prefix are served by the namenode, under the target directory if given, e.g.,
+// "/archive archive-nn:8020/data" maps /archive/2020 to /data/2020 of archive-nn. The longest
+// matching prefix wins, other paths go to the namenode given on the command line. The
+// credentials default to those of the command line. Renames across namenodes fail with EXDEV
+type Route struct {
+	Prefix   string       // path in the mount
+	Address  string       // namenode of the route
+	Target   string       // path on the namenode the prefix is mapped to
+	TLS      TLSConfig    // credentials of the connection to the namenode
+	Accessor HdfsAccessor // connection to the namenode
+}
+
+// Returns the routes of a routing table file, without their accessors
+func parseRoutingTable(file string, defaultTLS TLSConfig) ([]*Route, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var routes []*Route
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !path.IsAbs(fields[0]) {
+			return nil, fmt.Errorf("%s:%d: expected <prefix> <namenode:port>[/target] [options]", file, lineNo)
+		}
+		route := &Route{Prefix: path.Clean(fields[0]), Address: fields[1], TLS: defaultTLS}
+		route.Target = route.Prefix
+		if i := strings.Index(fields[1], "/"); i >= 0 {
+			route.Address, route.Target = fields[1][:i], path.Clean(fields[1][i:])
+		}
+		if route.Prefix == "/" {
+			return nil, fmt.Errorf("%s:%d: / is served by the namenode given on the command line", file, lineNo)
+		}
+		for _, option := range fields[2:] {
+			kv := strings.SplitN(option, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("%s:%d: expected option=value, got %q", file, lineNo, option)
+			}
+			switch kv[0] {
+			case "tls":
+				route.TLS.TLS = kv[1] == "true"
+			case "rootCABundle":
+				route.TLS.RootCABundle = kv[1]
+			case "clientCertificate":
+				route.TLS.ClientCertificate = kv[1]
+			case "clientKey":
+				route.TLS.ClientKey = kv[1]
+			case "dataTransferProtection":
+				if !validDataTransferProtection(kv[1]) {
+					return nil, fmt.Errorf("%s:%d: invalid dataTransferProtection %q", file, lineNo, kv[1])
+				}
+				route.TLS.DataTransferProtection = kv[1]
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown option %q", file, lineNo, kv[0])
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, scanner.Err()
+}
+
+// Dispatches every call to the accessor of the route of its path
+type RoutingHdfsAccessor struct {
+	Default HdfsAccessor
+	Routes  []*Route // sorted by descending prefix length, so that the longest prefix matches first
+}
+
+var _ HdfsAccessor = (*RoutingHdfsAccessor)(nil) // ensure RoutingHdfsAccessor implements HdfsAccessor
+
+// Creates an instance of RoutingHdfsAccessor
+func NewRoutingHdfsAccessor(defaultAccessor HdfsAccessor, routes []*Route) *RoutingHdfsAccessor {
+	sorted := append([]*Route(nil), routes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].Prefix) > len(sorted[j].Prefix) })
+	return &RoutingHdfsAccessor{Default: defaultAccessor, Routes: sorted}
+}
+
+// Returns the route of a path, nil for the default namenode
+func (ra *RoutingHdfsAccessor) route(p string) *Route {
+	for _, route := range ra.Routes {
+		if p == route.Prefix || strings.HasPrefix(p, route.Prefix+"/") {
+			return route
+		}
+	}
+	return nil
+}
+
+// Returns the accessor serving the path and the path on its namenode
+func (ra *RoutingHdfsAccessor) resolve(p string) (HdfsAccessor, string) {
+	route := ra.route(p)
+	if route == nil {
+		return ra.Default, p
+	}
+	return route.Accessor, path.Join(route.Target, strings.TrimPrefix(p, route.Prefix))
+}
+
+// Opens HDFS file for reading
+func (ra *RoutingHdfsAccessor) OpenRead(p string) (ReadSeekCloser, error) {
+	accessor, target := ra.resolve(p)
+	return accessor.OpenRead(target)
+}
+
+// Opens HDFS file for writing
+func (ra *RoutingHdfsAccessor) CreateFile(p string, mode os.FileMode, overwrite bool) (HdfsWriter, error) {
+	accessor, target := ra.resolve(p)
+	return accessor.CreateFile(target, mode, overwrite)
+}
+
+// Opens HDFS file for appending
+func (ra *RoutingHdfsAccessor) Append(p string) (HdfsWriter, error) {
+	accessor, target := ra.resolve(p)
+	return accessor.Append(target)
+}
+
+// Enumerates HDFS directory. The prefixes of the routes in the directory are listed too,
+// also if the default namenode has no such directory
+func (ra *RoutingHdfsAccessor) ReadDir(p string) ([]Attrs, error) {
+	accessor, target := ra.resolve(p)
+	entries, err := accessor.ReadDir(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range ra.Routes {
+		if path.Dir(route.Prefix) != p {
+			continue
+		}
+		name := path.Base(route.Prefix)
+		replaced := false
+		attrs, err := ra.Stat(route.Prefix)
+		if err != nil {
+			logwarn("Unable to stat the target of a route", Fields{Path: route.Prefix, Error: err})
+			continue
+		}
+		for i := range entries {
+			if entries[i].Name == name {
+				entries[i] = attrs
+				replaced = true
+			}
+		}
+		if !replaced {
+			entries = append(entries, attrs)
+		}
+	}
+	return entries, nil
+}
+
+// Retrieves file/directory attributes
+func (ra *RoutingHdfsAccessor) Stat(p string) (Attrs, error) {
+	accessor, target := ra.resolve(p)
+	attrs, err := accessor.Stat(target)
+	if err == nil {
+		// the target of a route may have another name than its prefix
+		attrs.Name = path.Base(p)
+	}
+	return attrs, err
+}
+
+// Retrieves HDFS usage of the default namenode
+func (ra *RoutingHdfsAccessor) StatFs() (FsInfo, error) {
+	return ra.Default.StatFs()
+}
+
+// Creates a directory
+func (ra *RoutingHdfsAccessor) Mkdir(p string, mode os.FileMode) error {
+	accessor, target := ra.resolve(p)
+	return accessor.Mkdir(target, mode)
+}
+
+// Removes a file or directory
+func (ra *RoutingHdfsAccessor) Remove(p string) error {
+	accessor, target := ra.resolve(p)
+	return accessor.Remove(target)
+}
+
+// Removes a file or directory recursively
+func (ra *RoutingHdfsAccessor) RemoveAll(p string) error {
+	accessor, target := ra.resolve(p)
+	return accessor.RemoveAll(target)
+}
+
+// Renames a file or directory, both paths must be served by the same namenode
+func (ra *RoutingHdfsAccessor) Rename(oldPath string, newPath string) error {
+	if ra.route(oldPath) != ra.route(newPath) {
+		return syscall.EXDEV
+	}
+	accessor, oldTarget := ra.resolve(oldPath)
+	_, newTarget := ra.resolve(newPath)
+	return accessor.Rename(oldTarget, newTarget)
+}
+
+// Ensures the accessor of the default namenode is connected, routes connect on first use
+func (ra *RoutingHdfsAccessor) EnsureConnected() error {
+	return ra.Default.EnsureConnected()
+}
+
+// Changes the owner and group of the file
+func (ra *RoutingHdfsAccessor) Chown(p string, owner, group string) error {
+	accessor, target := ra.resolve(p)
+	return accessor.Chown(target, owner, group)
+}
+
+// Changes the mode of the file
+func (ra *RoutingHdfsAccessor) Chmod(p string, mode os.FileMode) error {
+	accessor, target := ra.resolve(p)
+	return accessor.Chmod(target, mode)
+}
+
+// Changes the modification time of the file
+func (ra *RoutingHdfsAccessor) Chtimes(p string, mtime time.Time) error {
+	accessor, target := ra.resolve(p)
+	return accessor.Chtimes(target, mtime)
+}
+
+// Retrieves the HDFS checksum of the file
+func (ra *RoutingHdfsAccessor) Checksum(p string) (FileChecksum, error) {
+	accessor, target := ra.resolve(p)
+	return accessor.Checksum(target)
+}
+
+// Retrieves the extended attributes of the file
+func (ra *RoutingHdfsAccessor) GetXAttrs(p string) (map[string]string, error) {
+	accessor, target := ra.resolve(p)
+	return accessor.GetXAttrs(target)
+}
+
+// Creates or replaces an extended attribute of the file
+func (ra *RoutingHdfsAccessor) SetXAttr(p string, name, value string) error {
+	accessor, target := ra.resolve(p)
+	return accessor.SetXAttr(target, name, value)
+}
+
+// Retrieves the ACL entries of the file
+func (ra *RoutingHdfsAccessor) GetAcl(p string) ([]AclEntry, error) {
+	accessor, target := ra.resolve(p)
+	return accessor.GetAcl(target)
+}
+
+// Replaces the ACL of the file
+func (ra *RoutingHdfsAccessor) SetAcl(p string, entries []AclEntry) error {
+	accessor, target := ra.resolve(p)
+	return accessor.SetAcl(target, entries)
+}
+
+// Sets the storage policy of the file
+func (ra *RoutingHdfsAccessor) SetStoragePolicy(p string, policy string) error {
+	accessor, target := ra.resolve(p)
+	return accessor.SetStoragePolicy(target, policy)
+}
+
+// Sets the erasure coding policy of the directory
+func (ra *RoutingHdfsAccessor) SetErasureCodingPolicy(p string, policy string) error {
+	accessor, target := ra.resolve(p)
+	return accessor.SetErasureCodingPolicy(target, policy)
+}
+
+// Retrieves the totals of a directory tree. The totals of the routes below the directory
+// are on other namenodes and not included
+func (ra *RoutingHdfsAccessor) GetContentSummary(p string) (ContentSummary, error) {
+	accessor, target := ra.resolve(p)
+	return accessor.GetContentSummary(target)
+}
+
+// Retrieves the configuration of the default namenode
+func (ra *RoutingHdfsAccessor) ServerDefaults() (ServerDefaults, error) {
+	return ra.Default.ServerDefaults()
+}
+
+// Truncates the file
+func (ra *RoutingHdfsAccessor) Truncate(p string, size int64) (bool, error) {
+	accessor, target := ra.resolve(p)
+	return accessor.Truncate(target, size)
+}
+
+// Creates a snapshot of a snapshottable directory
+func (ra *RoutingHdfsAccessor) CreateSnapshot(p, name string) (string, error) {
+	accessor, target := ra.resolve(p)
+	snapshotPath, err := accessor.CreateSnapshot(target, name)
+	if err != nil {
+		return "", err
+	}
+	// the path of the snapshot in the mount
+	return path.Join(p, strings.TrimPrefix(snapshotPath, target)), nil
+}
+
+// Closes the connections to all namenodes
+func (ra *RoutingHdfsAccessor) Close() error {
+	err := ra.Default.Close()
+	for _, route := range ra.Routes {
+		if routeErr := route.Accessor.Close(); err == nil {
+			err = routeErr
+		}
+	}
+	return err
+}
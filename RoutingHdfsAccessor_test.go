@@ -0,0 +1,61 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRoutingTable(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "routes")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "routes")
+	ioutil.WriteFile(file, []byte("# archive\n/archive archive-nn:8020/data tls=false\n\n/Projects/p1/ext other-nn:8020 clientCertificate=/etc/p1.pem\n"), 0600)
+
+	routes, err := parseRoutingTable(file, TLSConfig{TLS: true, ClientCertificate: "/etc/default.pem"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(routes))
+	assert.Equal(t, Route{Prefix: "/archive", Address: "archive-nn:8020", Target: "/data", TLS: TLSConfig{ClientCertificate: "/etc/default.pem"}}, *routes[0])
+	assert.Equal(t, Route{Prefix: "/Projects/p1/ext", Address: "other-nn:8020", Target: "/Projects/p1/ext", TLS: TLSConfig{TLS: true, ClientCertificate: "/etc/p1.pem"}}, *routes[1])
+
+	ioutil.WriteFile(file, []byte("/archive archive-nn:8020 tsl=true\n"), 0600)
+	_, err = parseRoutingTable(file, TLSConfig{})
+	assert.EqualError(t, err, file+`:1: unknown option "tsl"`)
+}
+
+// Testing that paths are served by the namenode of the longest matching prefix
+func TestRoutingHdfsAccessor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defaultAccessor := NewMockHdfsAccessor(mockCtrl)
+	archive := NewMockHdfsAccessor(mockCtrl)
+	recent := NewMockHdfsAccessor(mockCtrl)
+	ra := NewRoutingHdfsAccessor(defaultAccessor, []*Route{
+		{Prefix: "/archive", Target: "/data", Accessor: archive},
+		{Prefix: "/archive/recent", Target: "/recent", Accessor: recent},
+	})
+
+	defaultAccessor.EXPECT().Stat("/Projects").Return(Attrs{Name: "Projects"}, nil)
+	archive.EXPECT().Stat("/data/2020").Return(Attrs{Name: "2020"}, nil)
+	recent.EXPECT().Stat("/recent/2023").Return(Attrs{Name: "2023"}, nil)
+	ra.Stat("/Projects")
+	ra.Stat("/archive/2020")
+	ra.Stat("/archive/recent/2023")
+
+	// the route is listed in the root, with the name of its prefix
+	defaultAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "Projects"}}, nil)
+	archive.EXPECT().Stat("/data").Return(Attrs{Name: "data", Mode: os.ModeDir}, nil)
+	entries, err := ra.ReadDir("/")
+	assert.Nil(t, err)
+	assert.Equal(t, []Attrs{{Name: "Projects"}, {Name: "archive", Mode: os.ModeDir}}, entries)
+
+	assert.Equal(t, syscall.EXDEV, ra.Rename("/archive/2020/a", "/Projects/a"))
+	archive.EXPECT().Rename("/data/2020/a", "/data/2021/a").Return(nil)
+	assert.Nil(t, ra.Rename("/archive/2020/a", "/archive/2021/a"))
+}
@@ -0,0 +1,837 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Workloads writing millions of tiny files, e.g., extracted image datasets or per-sample feature
+// files, cost the namenode an inode and a block per file. -packDirs is a comma separated list of
+// globs of HDFS directories, in the syntax of -protectedPaths. The files written through the
+// mount in a matching directory which are at most -packMaxBytes long are not created in HDFS,
+// but appended to the .hopsfs-pack file of the directory, and listed in its .hopsfs-pack.idx
+// index, which the mount reads to list, stat and read them like the other files. The pack files
+// themselves are hidden. Removing a packed file, renaming it within its directory and changing
+// its mode or modification time append to the index, a packed file which is appended to or
+// truncated is unpacked into a regular file first, and renaming it to another directory fails
+// with EXDEV, so that mv copies it. The space of the removed and replaced packed files stays in
+// the pack. The packed files have the owner of the pack, their owner cannot be changed, and they
+// have no extended attributes nor ACLs. HDFS appends have a single writer, so only one mount may
+// write to a packed directory at a time, and the mounts reading it need the same -packDirs. The
+// index lines of the files packed within packIndexFlushDelay are appended at once, and at
+// unmount: the other mounts see the files afterwards, and a crash of the mount loses them
+var packDirs string
+var packMaxBytes int64
+
+// Names of the pack and of its index in a packed directory
+const (
+	packDataName  = ".hopsfs-pack"
+	packIndexName = ".hopsfs-pack.idx"
+)
+
+// How long the index lines are batched before they are appended to the index
+const packIndexFlushDelay = time.Second
+
+// A packed file: its range in the pack and its attributes
+type packEntry struct {
+	Offset int64
+	Size   int64
+	Mtime  time.Time
+	Mode   os.FileMode
+}
+
+// The index of a packed directory as of when it was read
+type packIndex struct {
+	entries  map[string]packEntry
+	size     int64     // size of the index in HDFS, it is read again once it changed
+	owner    Attrs     // attributes of the pack, the owner of the packed files
+	loaded   time.Time // read again after -attrCacheTTL, when looked up
+	packSize int64     // size of the pack, where the next file is appended, -1 if not known
+	torn     bool      // the index does not end with a newline, e.g., after a crash of the writer
+	pending  string    // lines applied to the entries and not appended to the index yet
+}
+
+// Packs the small files written to the directories of -packDirs, see above
+// Concurrency: thread safe, the changes of the packs are serialized
+type PackingHdfsAccessor struct {
+	Lower    HdfsAccessor
+	Clock    Clock
+	MaxBytes int64
+	patterns [][]string
+
+	mutex          sync.Mutex
+	indexes        map[string]*packIndex // by directory
+	flushScheduled bool                  // the pending index lines are appended after packIndexFlushDelay
+}
+
+var _ HdfsAccessor = (*PackingHdfsAccessor)(nil) // ensure PackingHdfsAccessor implements HdfsAccessor
+
+// Creates an instance of PackingHdfsAccessor packing the files of the directories matching the globs
+func NewPackingHdfsAccessor(lower HdfsAccessor, clock Clock, dirs string, maxBytes int64) *PackingHdfsAccessor {
+	pa := &PackingHdfsAccessor{Lower: lower, Clock: clock, MaxBytes: maxBytes, indexes: map[string]*packIndex{}}
+	for _, pattern := range strings.Split(dirs, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			pa.patterns = append(pa.patterns, pathComponents(pattern))
+		}
+	}
+	return pa
+}
+
+// Returns true if the files of the directory are packed
+func (pa *PackingHdfsAccessor) packedDir(dir string) bool {
+	components := pathComponents(dir)
+	for _, pattern := range pa.patterns {
+		if matchComponents(pattern, components) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the path is the pack or the index of a packed directory
+func (pa *PackingHdfsAccessor) packFile(p string) bool {
+	name := path.Base(p)
+	return (name == packDataName || name == packIndexName) && pa.packedDir(path.Dir(p))
+}
+
+// Formats the index line of a packed file, or of its removal if entry is nil
+func packIndexLine(name string, entry *packEntry) string {
+	if entry == nil {
+		return fmt.Sprintf("- %s\n", url.PathEscape(name))
+	}
+	return fmt.Sprintf("+ %d %d %d %o %s\n", entry.Offset, entry.Size, entry.Mtime.UnixNano(), uint32(entry.Mode), url.PathEscape(name))
+}
+
+// Applies the lines of the index to the entries, the later lines of a name replace the earlier
+// ones. Malformed lines, e.g., torn by a crash of the writer, are skipped
+func parsePackIndex(r io.Reader, entries map[string]packEntry) map[string]packEntry {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "-" {
+			if name, err := url.PathUnescape(fields[1]); err == nil {
+				delete(entries, name)
+			}
+			continue
+		}
+		var entry packEntry
+		var mtime int64
+		var mode uint32
+		if len(fields) != 6 || fields[0] != "+" {
+			continue
+		}
+		if _, err := fmt.Sscanf(strings.Join(fields[1:5], " "), "%d %d %d %o", &entry.Offset, &entry.Size, &mtime, &mode); err != nil {
+			continue
+		}
+		name, err := url.PathUnescape(fields[5])
+		if err != nil {
+			continue
+		}
+		entry.Mtime, entry.Mode = time.Unix(0, mtime), os.FileMode(mode)
+		entries[name] = entry
+	}
+	return entries
+}
+
+// Returns the index of the directory, read again if it is older than -attrCacheTTL and
+// changed, or if its size is not the given one. The pending lines are applied to the index read
+// again. Called with the mutex held
+func (pa *PackingHdfsAccessor) index(dir string, indexAttrs *Attrs) (*packIndex, error) {
+	cached := pa.indexes[dir]
+	if cached != nil && indexAttrs == nil && pa.Clock.Now().Sub(cached.loaded) < attrCacheTTL {
+		return cached, nil
+	}
+	if indexAttrs == nil {
+		attrs, err := pa.Lower.Stat(path.Join(dir, packIndexName))
+		if err == syscall.ENOENT && cached != nil && cached.size == 0 {
+			// only pending lines, the index is created when they are appended
+			cached.loaded = pa.Clock.Now()
+			return cached, nil
+		} else if err == syscall.ENOENT {
+			delete(pa.indexes, dir)
+			return &packIndex{entries: map[string]packEntry{}, packSize: -1}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		indexAttrs = &attrs
+	}
+	if cached != nil && cached.size == int64(indexAttrs.Size) {
+		cached.loaded = pa.Clock.Now()
+		return cached, nil
+	}
+	reader, err := pa.Lower.OpenRead(path.Join(dir, packIndexName))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := pa.Lower.Stat(path.Join(dir, packDataName))
+	if err != nil {
+		return nil, err
+	}
+	index := &packIndex{entries: parsePackIndex(bytes.NewReader(data), map[string]packEntry{}), size: int64(len(data)), owner: owner,
+		loaded: pa.Clock.Now(), packSize: int64(owner.Size), torn: len(data) > 0 && data[len(data)-1] != '\n'}
+	if cached != nil {
+		index.pending = cached.pending
+		parsePackIndex(strings.NewReader(index.pending), index.entries)
+	}
+	pa.indexes[dir] = index
+	return index, nil
+}
+
+// Returns the packed file, false if the path is not a packed file
+func (pa *PackingHdfsAccessor) packed(p string) (packEntry, *packIndex, bool) {
+	if !pa.packedDir(path.Dir(p)) {
+		return packEntry{}, nil, false
+	}
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	index, err := pa.index(path.Dir(p), nil)
+	if err != nil {
+		logwarn("Failed to read the index of the pack", Fields{Operation: PackOp, Path: path.Dir(p), Error: err})
+		return packEntry{}, nil, false
+	}
+	entry, ok := index.entries[path.Base(p)]
+	return entry, index, ok
+}
+
+// Returns the attributes of a packed file
+func packedAttrs(name string, entry packEntry, owner Attrs) Attrs {
+	return Attrs{Name: name, Mode: entry.Mode, Size: uint64(entry.Size), Mtime: entry.Mtime, Ctime: entry.Mtime, Crtime: entry.Mtime,
+		Uid: owner.Uid, Gid: owner.Gid, Group: owner.Group}
+}
+
+// Applies the lines to the cached index of the directory, and appends them to the index in HDFS
+// with the lines of the next packIndexFlushDelay. Called with the mutex held
+func (pa *PackingHdfsAccessor) appendIndex(dir string, index *packIndex, lines string) {
+	parsePackIndex(strings.NewReader(lines), index.entries)
+	index.pending += lines
+	index.loaded = pa.Clock.Now()
+	pa.indexes[dir] = index
+	if !pa.flushScheduled {
+		pa.flushScheduled = true
+		time.AfterFunc(packIndexFlushDelay, func() { pa.flushIndexes() })
+	}
+}
+
+// Appends the pending lines to the indexes, those which fail to be appended are kept for the next flush
+func (pa *PackingHdfsAccessor) flushIndexes() error {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	pa.flushScheduled = false
+	var firstErr error
+	for dir, index := range pa.indexes {
+		if index.pending == "" {
+			continue
+		}
+		lines := index.pending
+		if index.torn {
+			// the torn line stays malformed, and is skipped
+			lines = "\n" + lines
+		}
+		if err := pa.appendFile(path.Join(dir, packIndexName), []byte(lines)); err != nil {
+			logwarn("Failed to append to the index of the pack", Fields{Operation: PackOp, Path: dir, Error: err})
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		index.size += int64(len(lines))
+		index.torn = false
+		index.pending = ""
+	}
+	if firstErr != nil && !pa.flushScheduled {
+		pa.flushScheduled = true
+		time.AfterFunc(packIndexFlushDelay, func() { pa.flushIndexes() })
+	}
+	return firstErr
+}
+
+// Appends the pending index lines of the accessor at unmount, after the uploads
+type PackIndexFlush struct {
+	Accessor *PackingHdfsAccessor
+}
+
+func (flush *PackIndexFlush) Close() error {
+	return flush.Accessor.flushIndexes()
+}
+
+// Appends the data to the file, which is created if it does not exist
+func (pa *PackingHdfsAccessor) appendFile(p string, data []byte) error {
+	w, err := pa.Lower.Append(p)
+	if err == syscall.ENOENT {
+		w, err = pa.Lower.CreateFile(p, 0644, false)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Appends the content of the file to the pack and indexes it, replacing the regular file of the
+// same name if there may be one. The size of the pack is kept with the index, and only stat'ed
+// when it is not known, e.g., after a failed append
+func (pa *PackingHdfsAccessor) pack(p string, data []byte, mode os.FileMode, replaces bool) error {
+	dir := path.Dir(p)
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	index, err := pa.index(dir, nil)
+	if err != nil {
+		return err
+	}
+	if index.packSize < 0 {
+		if attrs, err := pa.Lower.Stat(path.Join(dir, packDataName)); err == nil {
+			index.packSize = int64(attrs.Size)
+			index.owner = attrs
+		} else if err == syscall.ENOENT {
+			index.packSize = 0
+		} else {
+			return err
+		}
+	}
+	offset := index.packSize
+	// an empty file takes no space in the pack
+	if len(data) > 0 {
+		if err := pa.appendFile(path.Join(dir, packDataName), data); err != nil {
+			index.packSize = -1
+			return err
+		}
+		index.packSize += int64(len(data))
+		if index.owner.Name == "" {
+			if index.owner, err = pa.Lower.Stat(path.Join(dir, packDataName)); err != nil {
+				return err
+			}
+		}
+	}
+	entry := &packEntry{Offset: offset, Size: int64(len(data)), Mtime: pa.Clock.Now(), Mode: mode.Perm()}
+	pa.appendIndex(dir, index, packIndexLine(path.Base(p), entry))
+	if replaces {
+		if err := pa.Lower.Remove(p); err != nil && err != syscall.ENOENT {
+			return err
+		}
+	}
+	logdebug("Packed the file", Fields{Operation: PackOp, Path: p, Bytes: len(data)})
+	return nil
+}
+
+// Removes the packed file from the index, returns false if it is not packed
+func (pa *PackingHdfsAccessor) unindex(p string) (bool, error) {
+	if !pa.packedDir(path.Dir(p)) {
+		return false, nil
+	}
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	index, err := pa.index(path.Dir(p), nil)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := index.entries[path.Base(p)]; !ok {
+		return false, nil
+	}
+	pa.appendIndex(path.Dir(p), index, packIndexLine(path.Base(p), nil))
+	return true, nil
+}
+
+// Changes the attributes of the packed file, returns false if it is not packed
+func (pa *PackingHdfsAccessor) updateEntry(p string, update func(entry *packEntry)) (bool, error) {
+	if !pa.packedDir(path.Dir(p)) {
+		return false, nil
+	}
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	index, err := pa.index(path.Dir(p), nil)
+	if err != nil {
+		return false, err
+	}
+	entry, ok := index.entries[path.Base(p)]
+	if !ok {
+		return false, nil
+	}
+	update(&entry)
+	pa.appendIndex(path.Dir(p), index, packIndexLine(path.Base(p), &entry))
+	return true, nil
+}
+
+// Turns the packed file into a regular file, before it is appended to or truncated
+func (pa *PackingHdfsAccessor) unpack(p string) error {
+	entry, _, ok := pa.packed(p)
+	if !ok {
+		return nil
+	}
+	reader, err := pa.OpenRead(p)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	w, err := pa.Lower.CreateFile(p, entry.Mode, true)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	_, err = pa.unindex(p)
+	loginfo("Unpacked the file", Fields{Operation: PackOp, Path: p, Bytes: entry.Size})
+	return err
+}
+
+// Opens HDFS file for reading, the packed files are read from the pack
+func (pa *PackingHdfsAccessor) OpenRead(p string) (ReadSeekCloser, error) {
+	if pa.packFile(p) {
+		return nil, syscall.ENOENT
+	}
+	reader, err := pa.Lower.OpenRead(p)
+	if err != syscall.ENOENT {
+		return reader, err
+	}
+	entry, _, ok := pa.packed(p)
+	if !ok {
+		return nil, err
+	}
+	if reader, err = pa.Lower.OpenRead(path.Join(path.Dir(p), packDataName)); err != nil {
+		return nil, err
+	}
+	return &packReader{Impl: reader, name: path.Base(p), entry: entry}, nil
+}
+
+// Opens HDFS file for writing, the files of the packed directories are packed on close if they are small enough
+func (pa *PackingHdfsAccessor) CreateFile(p string, mode os.FileMode, overwrite bool) (HdfsWriter, error) {
+	if pa.packFile(p) {
+		return nil, syscall.EPERM
+	}
+	if !pa.packedDir(path.Dir(p)) {
+		return pa.Lower.CreateFile(p, mode, overwrite)
+	}
+	w := &packWriter{pa: pa, path: p, mode: mode}
+	if !overwrite {
+		_, err := pa.Stat(p)
+		if err == nil {
+			return nil, syscall.EEXIST
+		}
+		w.replaces = err != syscall.ENOENT
+	} else {
+		// a name is either packed or a regular file
+		_, _, packed := pa.packed(p)
+		w.replaces = !packed
+	}
+	return w, nil
+}
+
+// Opens HDFS file for appending, a packed file is unpacked first
+func (pa *PackingHdfsAccessor) Append(p string) (HdfsWriter, error) {
+	if err := pa.unpack(p); err != nil {
+		return nil, err
+	}
+	return pa.Lower.Append(p)
+}
+
+// Enumerates HDFS directory, with the packed files and without the pack
+func (pa *PackingHdfsAccessor) ReadDir(p string) ([]Attrs, error) {
+	entries, err := pa.Lower.ReadDir(p)
+	if err != nil || !pa.packedDir(p) {
+		return entries, err
+	}
+	var indexAttrs *Attrs
+	result := make([]Attrs, 0, len(entries))
+	regular := map[string]bool{}
+	for i, attrs := range entries {
+		switch attrs.Name {
+		case packIndexName:
+			indexAttrs = &entries[i]
+		case packDataName:
+		default:
+			result = append(result, attrs)
+			regular[attrs.Name] = true
+		}
+	}
+	pa.mutex.Lock()
+	var index *packIndex
+	if indexAttrs != nil {
+		index, err = pa.index(p, indexAttrs)
+	} else if index = pa.indexes[p]; index == nil || index.size > 0 {
+		// no index, or one which was removed, unless its lines are pending
+		pa.mutex.Unlock()
+		return result, nil
+	}
+	pa.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	for name, entry := range index.entries {
+		if !regular[name] {
+			result = append(result, packedAttrs(name, entry, index.owner))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// Retrieves file/directory attributes, those of the packed files from the index
+func (pa *PackingHdfsAccessor) Stat(p string) (Attrs, error) {
+	if pa.packFile(p) {
+		return Attrs{}, syscall.ENOENT
+	}
+	attrs, err := pa.Lower.Stat(p)
+	if err != syscall.ENOENT {
+		return attrs, err
+	}
+	if entry, index, ok := pa.packed(p); ok {
+		return packedAttrs(path.Base(p), entry, index.owner), nil
+	}
+	return attrs, err
+}
+
+// Retrieves HDFS usage
+func (pa *PackingHdfsAccessor) StatFs() (FsInfo, error) {
+	return pa.Lower.StatFs()
+}
+
+// Creates a directory
+func (pa *PackingHdfsAccessor) Mkdir(p string, mode os.FileMode) error {
+	return pa.Lower.Mkdir(p, mode)
+}
+
+// Removes a file or directory, a packed directory whose files are all removed with its pack
+func (pa *PackingHdfsAccessor) Remove(p string) error {
+	if pa.packFile(p) {
+		return syscall.ENOENT
+	}
+	err := pa.Lower.Remove(p)
+	if err == syscall.ENOENT {
+		if removed, unindexErr := pa.unindex(p); removed || unindexErr != nil {
+			return unindexErr
+		}
+	}
+	if err == syscall.ENOTEMPTY && pa.packedDir(p) {
+		if entries, readErr := pa.ReadDir(p); readErr == nil && len(entries) == 0 {
+			return pa.RemoveAll(p)
+		}
+	}
+	return err
+}
+
+// Removes a file or directory recursively
+func (pa *PackingHdfsAccessor) RemoveAll(p string) error {
+	if pa.packFile(p) {
+		return syscall.ENOENT
+	}
+	err := pa.Lower.RemoveAll(p)
+	if err == syscall.ENOENT {
+		if removed, unindexErr := pa.unindex(p); removed || unindexErr != nil {
+			return unindexErr
+		}
+	}
+	if err == nil {
+		pa.mutex.Lock()
+		for dir := range pa.indexes {
+			if dir == p || strings.HasPrefix(dir, p+"/") {
+				delete(pa.indexes, dir)
+			}
+		}
+		pa.mutex.Unlock()
+	}
+	return err
+}
+
+// Renames a file or directory. A packed file is renamed in the index within its directory, and
+// fails with EXDEV to another directory
+func (pa *PackingHdfsAccessor) Rename(oldPath string, newPath string) error {
+	if pa.packFile(oldPath) || pa.packFile(newPath) {
+		return syscall.EPERM
+	}
+	entry, _, ok := pa.packed(oldPath)
+	if _, err := pa.Lower.Stat(oldPath); err == nil || !ok {
+		// a regular file replacing a packed one
+		if err := pa.Lower.Rename(oldPath, newPath); err != nil {
+			return err
+		}
+		_, err := pa.unindex(newPath)
+		return err
+	}
+	if path.Dir(oldPath) != path.Dir(newPath) {
+		return syscall.EXDEV
+	} else if oldPath == newPath {
+		return nil
+	}
+	if err := pa.Lower.Remove(newPath); err != nil && err != syscall.ENOENT {
+		return err
+	}
+	dir := path.Dir(oldPath)
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	index, err := pa.index(dir, nil)
+	if err != nil {
+		return err
+	}
+	pa.appendIndex(dir, index, packIndexLine(path.Base(newPath), &entry)+packIndexLine(path.Base(oldPath), nil))
+	return nil
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (pa *PackingHdfsAccessor) EnsureConnected() error {
+	return pa.Lower.EnsureConnected()
+}
+
+// Changes the owner and group of the file. The packed files keep the owner of the pack
+func (pa *PackingHdfsAccessor) Chown(p string, owner, group string) error {
+	if _, _, ok := pa.packed(p); ok {
+		if _, err := pa.Lower.Stat(p); err == syscall.ENOENT {
+			logdebug("Packed files keep the owner of the pack", Fields{Operation: Chown, Path: p})
+			return nil
+		}
+	}
+	return pa.Lower.Chown(p, owner, group)
+}
+
+// Changes the mode of the file, of a packed file in the index
+func (pa *PackingHdfsAccessor) Chmod(p string, mode os.FileMode) error {
+	err := pa.Lower.Chmod(p, mode)
+	if err == syscall.ENOENT {
+		if updated, updateErr := pa.updateEntry(p, func(entry *packEntry) { entry.Mode = mode.Perm() }); updated || updateErr != nil {
+			return updateErr
+		}
+	}
+	return err
+}
+
+// Changes the modification time of the file, of a packed file in the index
+func (pa *PackingHdfsAccessor) Chtimes(p string, mtime time.Time) error {
+	err := pa.Lower.Chtimes(p, mtime)
+	if err == syscall.ENOENT {
+		if updated, updateErr := pa.updateEntry(p, func(entry *packEntry) { entry.Mtime = mtime }); updated || updateErr != nil {
+			return updateErr
+		}
+	}
+	return err
+}
+
+// Retrieves the HDFS checksum of the file, not available for the packed files
+func (pa *PackingHdfsAccessor) Checksum(p string) (FileChecksum, error) {
+	checksum, err := pa.Lower.Checksum(p)
+	if _, _, ok := pa.packed(p); err == syscall.ENOENT && ok {
+		return FileChecksum{}, syscall.ENOTSUP
+	}
+	return checksum, err
+}
+
+// Retrieves the extended attributes of the file, none for the packed files
+func (pa *PackingHdfsAccessor) GetXAttrs(p string) (map[string]string, error) {
+	xattrs, err := pa.Lower.GetXAttrs(p)
+	if _, _, ok := pa.packed(p); err == syscall.ENOENT && ok {
+		return map[string]string{}, nil
+	}
+	return xattrs, err
+}
+
+// Creates or replaces an extended attribute of the file, the packed files have none
+func (pa *PackingHdfsAccessor) SetXAttr(p string, name, value string) error {
+	err := pa.Lower.SetXAttr(p, name, value)
+	if _, _, ok := pa.packed(p); err == syscall.ENOENT && ok {
+		return syscall.ENOTSUP
+	}
+	return err
+}
+
+// Retrieves the ACL entries of the file, none for the packed files
+func (pa *PackingHdfsAccessor) GetAcl(p string) ([]AclEntry, error) {
+	entries, err := pa.Lower.GetAcl(p)
+	if _, _, ok := pa.packed(p); err == syscall.ENOENT && ok {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// Replaces the access and default ACL of the file, the packed files have none
+func (pa *PackingHdfsAccessor) SetAcl(p string, entries []AclEntry) error {
+	err := pa.Lower.SetAcl(p, entries)
+	if _, _, ok := pa.packed(p); err == syscall.ENOENT && ok {
+		return syscall.ENOTSUP
+	}
+	return err
+}
+
+// Sets the storage policy of the file, the packed files have the policy of their pack
+func (pa *PackingHdfsAccessor) SetStoragePolicy(p string, policy string) error {
+	err := pa.Lower.SetStoragePolicy(p, policy)
+	if _, _, ok := pa.packed(p); err == syscall.ENOENT && ok {
+		return syscall.ENOTSUP
+	}
+	return err
+}
+
+// Sets the erasure coding policy of the directory
+func (pa *PackingHdfsAccessor) SetErasureCodingPolicy(p string, policy string) error {
+	return pa.Lower.SetErasureCodingPolicy(p, policy)
+}
+
+// Retrieves the totals of a directory tree, counting the packs rather than the packed files
+func (pa *PackingHdfsAccessor) GetContentSummary(p string) (ContentSummary, error) {
+	return pa.Lower.GetContentSummary(p)
+}
+
+// Retrieves the configuration of the namenode
+func (pa *PackingHdfsAccessor) ServerDefaults() (ServerDefaults, error) {
+	return pa.Lower.ServerDefaults()
+}
+
+// Creates a snapshot of a snapshottable directory
+func (pa *PackingHdfsAccessor) CreateSnapshot(p, name string) (string, error) {
+	return pa.Lower.CreateSnapshot(p, name)
+}
+
+// Truncates the file, a packed file is unpacked first
+func (pa *PackingHdfsAccessor) Truncate(p string, size int64) (bool, error) {
+	if err := pa.unpack(p); err != nil {
+		return false, err
+	}
+	return pa.Lower.Truncate(p, size)
+}
+
+// Close current meta connection if needed
+func (pa *PackingHdfsAccessor) Close() error {
+	return pa.Lower.Close()
+}
+
+// Reads a packed file from the pack
+type packReader struct {
+	Impl  ReadSeekCloser // reader of the pack
+	name  string
+	entry packEntry
+	pos   int64 // within the packed file
+}
+
+// Returns a version of the packed file of its own, the caches keyed by version would otherwise
+// mix up the files of the pack: an id derived from the pack and the name, and the mtime and
+// size of the entry, as in its attributes
+func (r *packReader) Version() (FileVersion, error) {
+	v, ok := r.Impl.(VersionedReader)
+	if !ok {
+		return FileVersion{}, errors.New("Version is not known")
+	}
+	pack, err := v.Version()
+	if err != nil {
+		return FileVersion{}, err
+	}
+	id := fnv.New64a()
+	fmt.Fprintf(id, "%d/%s", pack.FileId, r.name)
+	// the high bit keeps the ids apart from those of the HDFS inodes
+	return FileVersion{FileId: id.Sum64() | 1<<63, Mtime: r.entry.Mtime.UnixNano(), Size: r.entry.Size}, nil
+}
+
+func (r *packReader) Seek(pos int64) error {
+	r.pos = pos
+	return r.Impl.Seek(r.entry.Offset + pos)
+}
+
+func (r *packReader) Position() (int64, error) {
+	return r.pos, nil
+}
+
+func (r *packReader) Read(buffer []byte) (int, error) {
+	if r.pos == 0 {
+		if err := r.Impl.Seek(r.entry.Offset); err != nil {
+			return 0, err
+		}
+	}
+	if left := r.entry.Size - r.pos; left <= 0 {
+		return 0, io.EOF
+	} else if int64(len(buffer)) > left {
+		buffer = buffer[:left]
+	}
+	n, err := r.Impl.Read(buffer)
+	r.pos += int64(n)
+	if err == io.EOF && r.pos < r.entry.Size {
+		err = io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *packReader) Close() error {
+	return r.Impl.Close()
+}
+
+// Buffers a file of a packed directory, packed on close if it is at most -packMaxBytes long, and
+// written to a regular file once it is longer
+type packWriter struct {
+	pa       *PackingHdfsAccessor
+	path     string
+	mode     os.FileMode
+	replaces bool // a regular file of the same name may exist, it is removed once the file is packed
+	buffer   bytes.Buffer
+	spill    HdfsWriter // the regular file, once the file is too large to be packed
+}
+
+func (w *packWriter) Seek(pos int64) error {
+	return fmt.Errorf("Seek is not implemented")
+}
+
+func (w *packWriter) Write(buffer []byte) (int, error) {
+	if w.spill == nil && int64(w.buffer.Len()+len(buffer)) > w.pa.MaxBytes {
+		spill, err := w.pa.Lower.CreateFile(w.path, w.mode, true)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := spill.Write(w.buffer.Bytes()); err != nil {
+			spill.Close()
+			return 0, err
+		}
+		w.spill = spill
+		w.buffer.Reset()
+	}
+	if w.spill != nil {
+		return w.spill.Write(buffer)
+	}
+	return w.buffer.Write(buffer)
+}
+
+// The data of a file being packed is only written on close
+func (w *packWriter) Flush() error {
+	if w.spill != nil {
+		return w.spill.Flush()
+	}
+	return nil
+}
+
+func (w *packWriter) Close() error {
+	if w.spill != nil {
+		if err := w.spill.Close(); err != nil {
+			return err
+		}
+		// the regular file replaces the packed one
+		_, err := w.pa.unindex(w.path)
+		return err
+	}
+	return w.pa.pack(w.path, w.buffer.Bytes(), w.mode, w.replaces)
+}
+
+func (w *packWriter) Truncate() error {
+	return fmt.Errorf("Truncate is not implemented")
+}
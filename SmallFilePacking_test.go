@@ -0,0 +1,202 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the content of the file read through the accessor
+func readPacked(t *testing.T, accessor HdfsAccessor, p string) string {
+	reader, err := accessor.OpenRead(p)
+	assert.Nil(t, err)
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	return string(data)
+}
+
+// Testing that the small files of a packed directory are written to the pack, and listed, read,
+// renamed and removed through its index
+func TestSmallFilePacking(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hopsfs-pack")
+	defer os.RemoveAll(dir)
+	mockCtrl := gomock.NewController(t)
+	hdfs := NewMockHdfsAccessor(mockCtrl)
+	hdfs.EXPECT().Stat(gomock.Any()).Return(Attrs{}, syscall.ENOENT).AnyTimes()
+	hdfs.EXPECT().ReadDir(gomock.Any()).Return([]Attrs{}, nil).AnyTimes()
+	hdfs.EXPECT().OpenRead(gomock.Any()).Return(nil, syscall.ENOENT).AnyTimes()
+	// the overlay keeps the files of the test locally
+	lower := NewOverlayHdfsAccessor(hdfs, dir)
+	assert.Nil(t, lower.Mkdir("/data", 0755))
+	clock := &MockClock{now: time.Unix(1600000000, 0)}
+	pa := NewPackingHdfsAccessor(lower, clock, "/data", 8)
+
+	for name, content := range map[string]string{"/data/a": "aaa", "/data/b": "bbbb", "/data/big": "0123456789"} {
+		w, err := pa.CreateFile(name, 0644, false)
+		assert.Nil(t, err)
+		_, err = w.Write([]byte(content))
+		assert.Nil(t, err)
+		assert.Nil(t, w.Close())
+	}
+	pack, err := lower.Stat("/data/" + packDataName)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(7), pack.Size)
+	_, err = lower.Stat("/data/big")
+	assert.Nil(t, err, "the large file is a regular file")
+	_, err = lower.Stat("/data/a")
+	assert.Equal(t, syscall.ENOENT, err)
+
+	entries, err := pa.ReadDir("/data")
+	assert.Nil(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	assert.Equal(t, []string{"a", "b", "big"}, names)
+	attrs, err := pa.Stat("/data/b")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), attrs.Size)
+	assert.Equal(t, clock.Now(), attrs.Mtime)
+	assert.Equal(t, "aaa", readPacked(t, pa, "/data/a"))
+	assert.Equal(t, "bbbb", readPacked(t, pa, "/data/b"))
+	_, err = pa.Stat("/data/" + packIndexName)
+	assert.Equal(t, syscall.ENOENT, err)
+
+	// renames within the directory rewrite the index, to other directories fail
+	assert.Nil(t, pa.Rename("/data/a", "/data/c"))
+	assert.Equal(t, "aaa", readPacked(t, pa, "/data/c"))
+	_, err = pa.Stat("/data/a")
+	assert.Equal(t, syscall.ENOENT, err)
+	assert.Equal(t, syscall.EXDEV, pa.Rename("/data/c", "/other/c"))
+
+	// the index lines are batched, then read back by another mount, and by the first one once its copy expired
+	_, err = lower.Stat("/data/" + packIndexName)
+	assert.Equal(t, syscall.ENOENT, err)
+	clock.NotifyTimeElapsed(attrCacheTTL)
+	assert.Equal(t, "aaa", readPacked(t, pa, "/data/c"), "the pending lines are kept")
+	assert.Nil(t, pa.flushIndexes())
+	other := NewPackingHdfsAccessor(lower, clock, "/data", 8)
+	assert.Equal(t, "aaa", readPacked(t, other, "/data/c"))
+	assert.Nil(t, other.Remove("/data/c"))
+	assert.Nil(t, (&PackIndexFlush{Accessor: other}).Close())
+	clock.NotifyTimeElapsed(attrCacheTTL)
+	_, err = pa.Stat("/data/c")
+	assert.Equal(t, syscall.ENOENT, err)
+
+	// appending unpacks the file
+	w, err := pa.Append("/data/b")
+	assert.Nil(t, err)
+	w.Write([]byte("!"))
+	assert.Nil(t, w.Close())
+	attrs, err = lower.Stat("/data/b")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), attrs.Size)
+	assert.Equal(t, "bbbb!", readPacked(t, pa, "/data/b"))
+	_, _, packed := pa.packed("/data/b")
+	assert.False(t, packed)
+}
+
+// Testing the parsing of the index, including a line torn by a crash and malformed lines
+func TestParsePackIndex(t *testing.T) {
+	index := packIndexLine("a b", &packEntry{Offset: 0, Size: 3, Mode: 0644}) +
+		packIndexLine("c", &packEntry{Offset: 3, Size: 2, Mode: 0600}) +
+		packIndexLine("a b", nil) +
+		packIndexLine("c", &packEntry{Offset: 5, Size: 1, Mode: 0600}) + "+ 6 1"
+	entries := parsePackIndex(strings.NewReader(index), map[string]packEntry{})
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, int64(5), entries["c"].Offset)
+	assert.Equal(t, os.FileMode(0600), entries["c"].Mode)
+
+	// the lines appended after a torn line, or after garbage, still count
+	index += "\n" + "garbage\n" + "- %zz\n" + "+ 6 x 0 644 d\n" + packIndexLine("e", &packEntry{Offset: 6, Size: 1, Mode: 0644})
+	entries = parsePackIndex(strings.NewReader(index), map[string]packEntry{})
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, int64(6), entries["e"].Offset)
+}
+
+// Testing that the lines appended to a torn index start on a line of their own, and that a file
+// packed in a new directory costs no other RPC than the appends
+func TestPackTornIndex(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hopsfs-pack")
+	defer os.RemoveAll(dir)
+	mockCtrl := gomock.NewController(t)
+	hdfs := NewMockHdfsAccessor(mockCtrl)
+	hdfs.EXPECT().Stat(gomock.Any()).Return(Attrs{}, syscall.ENOENT).AnyTimes()
+	hdfs.EXPECT().OpenRead(gomock.Any()).Return(nil, syscall.ENOENT).AnyTimes()
+	lower := NewOverlayHdfsAccessor(hdfs, dir)
+	assert.Nil(t, lower.Mkdir("/data", 0755))
+	clock := &MockClock{now: time.Unix(1600000000, 0)}
+	pa := NewPackingHdfsAccessor(lower, clock, "/data", 8)
+	assert.Nil(t, pa.appendFile("/data/"+packDataName, []byte("aaa")))
+	assert.Nil(t, pa.appendFile("/data/"+packIndexName, []byte(packIndexLine("a", &packEntry{Size: 3, Mode: 0644})+"+ 3 1")))
+
+	w, err := pa.CreateFile("/data/b", 0644, true)
+	assert.Nil(t, err)
+	w.Write([]byte("b"))
+	assert.Nil(t, w.Close())
+	assert.Nil(t, pa.flushIndexes())
+	data, err := ioutil.ReadFile(dir + "/data/" + packIndexName)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(string(data), "+ 3 1\n+ 3 1 1600000000000000000 644 b\n"), string(data))
+
+	other := NewPackingHdfsAccessor(lower, clock, "/data", 8)
+	assert.Equal(t, "aaa", readPacked(t, other, "/data/a"))
+	assert.Equal(t, "b", readPacked(t, other, "/data/b"))
+
+	// the pack size is known, the next file costs the append of the pack only
+	counting := &countingAppends{HdfsAccessor: lower}
+	pa.Lower = counting
+	w, err = pa.CreateFile("/data/c", 0644, false)
+	assert.Nil(t, err)
+	w.Write([]byte("c"))
+	assert.Nil(t, w.Close())
+	assert.Equal(t, 1, counting.appends)
+	assert.Equal(t, 1, counting.stats, "the stat of CreateFile")
+	assert.Equal(t, 0, counting.removes)
+	assert.Equal(t, "c", readPacked(t, pa, "/data/c"))
+}
+
+// Counts the appends, stats and removes of the accessor
+type countingAppends struct {
+	HdfsAccessor
+	appends, stats, removes int
+}
+
+func (c *countingAppends) Append(p string) (HdfsWriter, error) {
+	c.appends++
+	return c.HdfsAccessor.Append(p)
+}
+
+func (c *countingAppends) Stat(p string) (Attrs, error) {
+	c.stats++
+	return c.HdfsAccessor.Stat(p)
+}
+
+func (c *countingAppends) Remove(p string) error {
+	c.removes++
+	return c.HdfsAccessor.Remove(p)
+}
+
+// Testing that the packed files have versions of their own
+func TestPackReaderVersion(t *testing.T) {
+	pack := versionedPseudoRandomReader{&MockReadSeekCloserWithPseudoRandomContent{FileSize: 100}}
+	a := &packReader{Impl: pack, name: "a", entry: packEntry{Offset: 0, Size: 10, Mtime: time.Unix(1, 0)}}
+	b := &packReader{Impl: pack, name: "b", entry: packEntry{Offset: 10, Size: 10, Mtime: time.Unix(1, 0)}}
+	va, err := a.Version()
+	assert.Nil(t, err)
+	vb, err := b.Version()
+	assert.Nil(t, err)
+	assert.NotEqual(t, va.FileId, vb.FileId)
+	assert.Equal(t, FileVersion{FileId: va.FileId, Mtime: time.Unix(1, 0).UnixNano(), Size: 10}, va)
+	_, err = (&packReader{Impl: &MockReadSeekCloserWithPseudoRandomContent{FileSize: 100}}).Version()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,40 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// With -snapshot, the mount serves the HDFS snapshot of that name of -srcDir, i.e.,
+// <srcDir>/.snapshot/<name>, read-only, so that a training run sees the dataset as it was when
+// the snapshot was taken while the live directory keeps changing. With -createSnapshot, the
+// snapshot is created when mounting, named after -snapshot or after the time of the mount,
+// e.g., hopsfs-mount-20200101-120000. -srcDir must be snapshottable, see hdfs dfsadmin -allowSnapshot.
+// The snapshot is not deleted on unmount
+const snapshotDirName = ".snapshot"
+
+// Returns the source directory of the mount of a snapshot of srcDir, creating the snapshot if create is set
+func snapshotSrcDir(hdfsAccessor HdfsAccessor, srcDir string, name string, create bool, now time.Time) (string, error) {
+	if create {
+		if name == "" {
+			name = "hopsfs-mount-" + now.UTC().Format("20060102-150405")
+		}
+		snapshotPath, err := hdfsAccessor.CreateSnapshot(srcDir, name)
+		if err != nil {
+			return "", fmt.Errorf("unable to create snapshot %s of %s, the directory must be snapshottable. Error: %v", name, srcDir, err)
+		}
+		if snapshotPath == "" {
+			snapshotPath = path.Join(srcDir, snapshotDirName, name)
+		}
+		loginfo("Created snapshot", Fields{Operation: CreateSnapshot, Path: snapshotPath})
+		return snapshotPath, nil
+	}
+	snapshotPath := path.Join(srcDir, snapshotDirName, name)
+	if _, err := hdfsAccessor.Stat(snapshotPath); err != nil {
+		return "", fmt.Errorf("snapshot %s of %s is not accessible. Error: %v", name, srcDir, err)
+	}
+	return snapshotPath, nil
+}
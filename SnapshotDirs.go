@@ -0,0 +1,94 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"hash/fnv"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Recovering a file from an HDFS snapshot needed hdfs dfs -cp, or a second mount with
+// -snapshot. With -snapshotDirs, every snapshottable directory has a .snapshot directory,
+// hidden from its listing as in HDFS, listing its snapshots, and /path/.snapshot/<name>/ serves
+// the directory as it was in the snapshot, read-only, so that files can be recovered with cp.
+// Creating, writing, removing, renaming and changing the attributes of the entries of a
+// snapshot fail with EROFS. The entries of a snapshot have the same file ids in HDFS as the live
+// ones, their inode numbers are derived from the snapshot so that cp does not take a snapshot
+// file and the live one for the same file
+var snapshotDirs bool
+
+// Time whether a directory is snapshottable is cached, a lookup of .snapshot lists the snapshots
+const snapshottableTTL = time.Minute
+
+// Returns true if the HDFS path is in a snapshot, or is a .snapshot directory
+func inSnapshot(p string) bool {
+	return strings.Contains(p+"/", "/"+snapshotDirName+"/")
+}
+
+// Fails with EROFS on a read-only mount, and for the paths in a snapshot with -snapshotDirs
+func (filesystem *FileSystem) checkWritableAt(hdfsPath string) error {
+	if err := filesystem.checkWritable(); err != nil {
+		return err
+	}
+	if snapshotDirs && inSnapshot(hdfsPath) {
+		return syscall.EROFS
+	}
+	return nil
+}
+
+// Returns the attributes of the .snapshot directory of dir, ENOENT if dir is not snapshottable
+func (dir *DirINode) snapshotDirAttrs(attrs *Attrs) error {
+	now := dir.FileSystem.Clock.Monotonic()
+	snapshottable := atomic.LoadInt32(&dir.snapshottable)
+	if snapshottable == 0 || int64(now) > atomic.LoadInt64(&dir.snapshottableExpires) {
+		snapshottable = 1
+		if _, err := dir.FileSystem.getDFSConnector().ReadDir(dir.AbsolutePathForChild(snapshotDirName)); err != nil {
+			if !IsSuccessOrNonRetriableError(err) {
+				return err
+			}
+			snapshottable = -1
+		}
+		atomic.StoreInt64(&dir.snapshottableExpires, int64(now+snapshottableTTL))
+		atomic.StoreInt32(&dir.snapshottable, snapshottable)
+	}
+	if snapshottable < 0 {
+		return syscall.ENOENT
+	}
+	previous := *attrs
+	*attrs = Attrs{
+		Inode: snapshotInode(dir.AbsolutePathForChild(snapshotDirName), dir.Attrs.Inode),
+		Name:  snapshotDirName,
+		Mode:  os.ModeDir | 0555,
+		Uid:   dir.Attrs.Uid,
+		Gid:   dir.Attrs.Gid,
+		Mtime: dir.Attrs.Mtime,
+		Ctime: dir.Attrs.Ctime,
+	}
+	attrs.Expires = dir.entryAttrsExpiry(&previous, attrs)
+	return nil
+}
+
+// Returns the inode number of an entry of HDFS path with the file id, distinct for each snapshot
+// of the entry with -snapshotDirs
+func snapshotInode(p string, fileID uint64) uint64 {
+	i := strings.Index(p+"/", "/"+snapshotDirName+"/")
+	if !snapshotDirs || i < 0 {
+		return fileID
+	}
+	// the snapshot root, e.g., /data/.snapshot/s1
+	root := p[:i+len(snapshotDirName)+1]
+	if rest := p[len(root):]; rest != "" {
+		if j := strings.Index(rest[1:], "/"); j >= 0 {
+			root += rest[:j+1]
+		} else {
+			root = p
+		}
+	}
+	h := fnv.New64a()
+	h.Write([]byte(root))
+	return (fileID ^ h.Sum64()) | 1<<63
+}
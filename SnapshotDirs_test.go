@@ -0,0 +1,74 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the snapshots of a directory are served under .snapshot, read-only and with
+// inode numbers distinct from the live entries
+func TestSnapshotDirs(t *testing.T) {
+	saveFlags(t, &snapshotDirs)
+	snapshotDirs = true
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(WallClock{}), WallClock{})
+	root, _ := fs.Root()
+	data := root.(*DirINode).NodeFromAttrs(Attrs{Name: "data", Mode: os.ModeDir | 0755, Inode: 2}).(*DirINode)
+	other := root.(*DirINode).NodeFromAttrs(Attrs{Name: "other", Mode: os.ModeDir | 0755, Inode: 3}).(*DirINode)
+
+	hdfsAccessor.EXPECT().ReadDir("/data/.snapshot").Return([]Attrs{{Name: "s1", Mode: os.ModeDir | 0755, Inode: 2}}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().ReadDir("/other/.snapshot").Return(nil, syscall.ENOENT)
+	hdfsAccessor.EXPECT().Stat("/data/.snapshot/s1/a").Return(Attrs{Name: "a", Mode: 0644, Inode: 4}, nil)
+	hdfsAccessor.EXPECT().Stat("/data/a").Return(Attrs{Name: "a", Mode: 0644, Inode: 4}, nil)
+
+	_, err := other.Lookup(nil, snapshotDirName)
+	assert.Equal(t, syscall.ENOENT, err)
+	// a directory which is not snapshottable is not listed again
+	_, err = other.Lookup(nil, snapshotDirName)
+	assert.Equal(t, syscall.ENOENT, err)
+
+	node, err := data.Lookup(nil, snapshotDirName)
+	assert.Nil(t, err)
+	snapshots := node.(*DirINode)
+	assert.Equal(t, os.ModeDir|0555, snapshots.Attrs.Mode)
+	entries, err := snapshots.ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "s1", entries[0].Name)
+
+	node, err = snapshots.Lookup(nil, "s1")
+	assert.Nil(t, err)
+	s1 := node.(*DirINode)
+	assert.NotEqual(t, data.Attrs.Inode, s1.Attrs.Inode)
+	node, err = s1.Lookup(nil, "a")
+	assert.Nil(t, err)
+	snapshotFile := node.(*FileINode)
+	node, err = data.Lookup(nil, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), node.(*FileINode).Attrs.Inode)
+	assert.NotEqual(t, uint64(4), snapshotFile.Attrs.Inode)
+
+	// the entries of the snapshots are read-only
+	_, _, err = s1.Create(nil, &fuse.CreateRequest{Name: "b", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Equal(t, syscall.EROFS, err)
+	_, err = s1.Mkdir(nil, &fuse.MkdirRequest{Name: "d", Mode: os.ModeDir | 0755})
+	assert.Equal(t, syscall.EROFS, err)
+	assert.Equal(t, syscall.EROFS, s1.Remove(nil, &fuse.RemoveRequest{Name: "a"}))
+	assert.Equal(t, syscall.EROFS, data.Rename(nil, &fuse.RenameRequest{OldName: "a", NewName: "a"}, s1))
+	assert.Equal(t, syscall.EROFS, s1.Rename(nil, &fuse.RenameRequest{OldName: "a", NewName: "a"}, data))
+	_, err = snapshotFile.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, syscall.EROFS, err)
+	_, err = s1.Symlink(nil, &fuse.SymlinkRequest{NewName: "l", Target: "a"})
+	assert.Equal(t, syscall.EROFS, err)
+	assert.Equal(t, syscall.EROFS, s1.setAcl(aclAccessXAttr, nil))
+	assert.Equal(t, syscall.EROFS, snapshotFile.setAcl(aclAccessXAttr, nil))
+	assert.Equal(t, syscall.EROFS, snapshotFile.Setxattr(nil, &fuse.SetxattrRequest{Name: replaceTargetXAttr, Xattr: []byte("b")}))
+}
@@ -0,0 +1,33 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that snapshots are mounted by name, and created with a default name
+func TestSnapshotSrcDir(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	hdfsAccessor.EXPECT().Stat("/data/.snapshot/s1").Return(Attrs{Name: "s1"}, nil)
+	dir, err := snapshotSrcDir(hdfsAccessor, "/data", "s1", false, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "/data/.snapshot/s1", dir)
+
+	hdfsAccessor.EXPECT().Stat("/data/.snapshot/missing").Return(Attrs{}, syscall.ENOENT)
+	_, err = snapshotSrcDir(hdfsAccessor, "/data", "missing", false, now)
+	assert.NotNil(t, err)
+
+	hdfsAccessor.EXPECT().CreateSnapshot("/data", "hopsfs-mount-20200102-030405").Return("/data/.snapshot/hopsfs-mount-20200102-030405", nil)
+	dir, err = snapshotSrcDir(hdfsAccessor, "/data", "", true, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "/data/.snapshot/hopsfs-mount-20200102-030405", dir)
+}
@@ -0,0 +1,233 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Staging files are named hopsfs-stage-<pid>-<random> and have a <name>.owner sidecar
+// describing the mount and the HDFS path they belong to. They are removed as soon as the
+// last handle of the file is closed. Files left behind by a crashed process are reaped
+// by any mount sharing the staging directory, at startup and every -stagingReapInterval.
+// The sidecar is the journal of the file: it records whether the file has data which is not in
+// HDFS yet, for -recoverStaging to recover the writes of a crashed mount
+const stagingFilePrefix = "hopsfs-stage-"
+const stagingOwnerSuffix = ".owner"
+
+// Returns the staging directories given by -stageDir, a comma separated list
+func stagingDirs() []string {
+	var dirs []string
+	for _, dir := range strings.Split(stagingDir, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		dirs = append(dirs, os.TempDir())
+	}
+	return dirs
+}
+
+// Returns the staging directory of an HDFS path. With several staging directories, e.g., one
+// per local disk, files are spread across them by the hash of the path, so that concurrent
+// writers use the bandwidth of all the disks
+func stagingDirFor(hdfsPath string) string {
+	dirs := stagingDirs()
+	if len(dirs) == 1 {
+		return dirs[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(hdfsPath))
+	return dirs[h.Sum32()%uint32(len(dirs))]
+}
+
+// Contents of the sidecar of a staging file
+type StagingOwner struct {
+	Pid        int         `json:"pid"`
+	MountPoint string      `json:"mount_point"`
+	Path       string      `json:"path"` // HDFS path the staging file is uploaded to
+	Created    time.Time   `json:"created"`
+	Dirty      bool        `json:"dirty,omitempty"` // written since the last upload
+	Mode       os.FileMode `json:"mode,omitempty"`  // mode of the HDFS file, recorded with Dirty
+}
+
+// Creates a staging file tagged with the owning process, mount point and HDFS path
+func newStagingFile(dir string, mountPoint string, hdfsPath string) (*os.File, error) {
+	pid := os.Getpid()
+	f, err := ioutil.TempFile(dir, fmt.Sprintf("%s%d-", stagingFilePrefix, pid))
+	if err != nil {
+		return nil, err
+	}
+	if err := tagStagingFile(f, StagingOwner{MountPoint: mountPoint, Path: hdfsPath}); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// Writes the sidecar of a staging file. Called again when the file is renamed while open
+// and when it becomes dirty or clean
+func tagStagingFile(f *os.File, owner StagingOwner) error {
+	owner.Pid = os.Getpid()
+	owner.Created = time.Now()
+	data, _ := json.Marshal(owner)
+	return ioutil.WriteFile(f.Name()+stagingOwnerSuffix, data, 0600)
+}
+
+// Closes and removes a staging file together with its sidecars
+func removeStagingFile(f *os.File) error {
+	err := f.Close()
+	removeStagingPath(f.Name())
+	return err
+}
+
+func removeStagingPath(stagingPath string) {
+	os.Remove(stagingPath)
+	os.Remove(stagingPath + stagingOwnerSuffix)
+	os.Remove(stagingPath + uploadManifestSuffix)
+}
+
+// Returns the staging file name of a sidecar name, an empty string if the name is not a sidecar name
+func sidecarOf(name string) string {
+	for _, suffix := range []string{stagingOwnerSuffix, uploadManifestSuffix, uploadManifestSuffix + ".tmp"} {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return ""
+}
+
+// Returns the pid encoded in the name of a staging file, 0 if the name is not a staging file name
+func stagingFilePid(name string) int {
+	if !strings.HasPrefix(name, stagingFilePrefix) {
+		return 0
+	}
+	rest := strings.TrimPrefix(name, stagingFilePrefix)
+	i := strings.Index(rest, "-")
+	if i <= 0 {
+		return 0
+	}
+	pid, err := strconv.Atoi(rest[:i])
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// Renames the staging file of a process which is not running anymore under the pid of this
+// process, so that other mounts sharing the directory leave it alone. Moves the upload manifest
+// along and removes the sidecar, which the new owner writes again. Returns the new name
+func claimStagingFile(stagingPath string) (string, error) {
+	name := filepath.Base(stagingPath)
+	rest := strings.TrimPrefix(name, stagingFilePrefix)
+	claimed := filepath.Join(filepath.Dir(stagingPath), fmt.Sprintf("%s%d-%s", stagingFilePrefix, os.Getpid(), rest[strings.Index(rest, "-")+1:]))
+	if err := os.Rename(stagingPath, claimed); err != nil {
+		return "", err
+	}
+	os.Rename(stagingPath+uploadManifestSuffix, claimed+uploadManifestSuffix)
+	os.Remove(stagingPath + stagingOwnerSuffix)
+	return claimed, nil
+}
+
+// Returns false if the process does not exist anymore
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Removes staging files of processes which are not running anymore, unless resume takes them
+// over to complete their upload, also in the staging dirs of the users. resume may be nil.
+// Returns the number of reaped files
+func reapStagingFiles(dir string, resume func(stagingPath string, owner StagingOwner) bool) int {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logwarn("Unable to list staging directory", Fields{Path: dir, Error: err})
+		return 0
+	}
+	reaped := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() && isUserStagingDir(name) {
+			reaped += reapStagingFiles(filepath.Join(dir, name), resume)
+			continue
+		}
+		pid := stagingFilePid(name)
+		if pid == 0 || sidecarOf(name) != "" || pid == os.Getpid() || processAlive(pid) {
+			continue
+		}
+		stagingPath := filepath.Join(dir, name)
+		var owner StagingOwner
+		if data, err := ioutil.ReadFile(stagingPath + stagingOwnerSuffix); err == nil {
+			json.Unmarshal(data, &owner)
+		}
+		if resume != nil && resume(stagingPath, owner) {
+			continue
+		}
+		if info, err := os.Stat(stagingPath + uploadManifestSuffix); err == nil && owner.MountPoint != "" &&
+			time.Since(info.ModTime()) < uploadManifestExpiry {
+			continue // an interrupted upload, left for its mount to resume
+		}
+		logwarn("Removing staging file of a process which is not running", Fields{TmpFile: stagingPath, PID: pid,
+			Path: owner.Path, Bytes: entry.Size()})
+		removeStagingPath(stagingPath)
+		reaped++
+	}
+	// sidecars whose staging file is gone, e.g., the process crashed in between removing them
+	for _, entry := range entries {
+		name := entry.Name()
+		if sidecarOf(name) == "" {
+			continue
+		}
+		stagingPath := filepath.Join(dir, sidecarOf(name))
+		if _, err := os.Stat(stagingPath); os.IsNotExist(err) && !processAlive(stagingFilePid(filepath.Base(stagingPath))) {
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+	return reaped
+}
+
+// Periodically reaps staging files left behind by crashed processes
+type StagingReaper struct {
+	Dir      string
+	Interval time.Duration
+	Clock    Clock
+	Resume   func(stagingPath string, owner StagingOwner) bool // takes over interrupted uploads, may be nil
+	done     chan struct{}
+}
+
+// Creates the reaper and reaps the files left behind by previous runs right away
+func NewStagingReaper(dir string, interval time.Duration, clock Clock, resume func(string, StagingOwner) bool) *StagingReaper {
+	if n := reapStagingFiles(dir, resume); n > 0 {
+		loginfo(fmt.Sprintf("Removed %d staging files left behind by previous runs", n), Fields{Path: dir})
+	}
+	return &StagingReaper{Dir: dir, Interval: interval, Clock: clock, Resume: resume, done: make(chan struct{})}
+}
+
+// Reaps until closed
+func (reaper *StagingReaper) Run() {
+	for {
+		select {
+		case <-reaper.done:
+			return
+		case <-reaper.Clock.After(reaper.Interval):
+			reapStagingFiles(reaper.Dir, reaper.Resume)
+		}
+	}
+}
+
+// Stops the reaper
+func (reaper *StagingReaper) Close() error {
+	close(reaper.done)
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that staging files are tagged, removed on close and reaped when the owner is gone
+func TestStagingFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "staging")
+	defer os.RemoveAll(dir)
+
+	f, err := newStagingFile(dir, "/mnt/hopsfs", "/a/b")
+	assert.Nil(t, err)
+	assert.Equal(t, os.Getpid(), stagingFilePid(filepath.Base(f.Name())))
+	_, err = os.Stat(f.Name() + stagingOwnerSuffix)
+	assert.Nil(t, err)
+
+	// the files of this process are never reaped
+	assert.Equal(t, 0, reapStagingFiles(dir, nil))
+	assert.Nil(t, removeStagingFile(f))
+	entries, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 0, len(entries))
+
+	// a file left behind by a process which is not running anymore
+	orphan := filepath.Join(dir, stagingFilePrefix+"999999999-1234")
+	ioutil.WriteFile(orphan, []byte("data"), 0600)
+	ioutil.WriteFile(orphan+stagingOwnerSuffix, []byte(`{"pid":999999999}`), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "unrelated"), []byte("data"), 0600)
+	assert.Equal(t, 1, reapStagingFiles(dir, nil))
+	entries, _ = ioutil.ReadDir(dir)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, 0, stagingFilePid("unrelated"))
+}
+
+// Testing that staging files are spread across the staging directories
+func TestStagingDirStriping(t *testing.T) {
+	saveFlags(t, &stagingDir)
+
+	stagingDir = "/disk1/stage"
+	assert.Equal(t, "/disk1/stage", stagingDirFor("/a"))
+
+	stagingDir = "/disk1/stage, /disk2/stage,/disk3/stage"
+	assert.Equal(t, []string{"/disk1/stage", "/disk2/stage", "/disk3/stage"}, stagingDirs())
+	used := make(map[string]bool)
+	for _, p := range []string{"/a", "/b", "/c", "/d", "/e", "/f", "/g", "/h"} {
+		assert.Equal(t, stagingDirFor(p), stagingDirFor(p))
+		used[stagingDirFor(p)] = true
+	}
+	assert.Equal(t, 3, len(used))
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// With -maxStagingBytes, the staging files of the mount are kept below that size, so that a
+// large copy cannot fill the disk of the host. A write which would go over the limit first
+// evicts the staging files of open files which are all in HDFS, i.e., uploaded and not written
+// since: their handles read from HDFS again and the next write downloads the file again. If
+// that is not enough, -stagingFullPolicy tells whether the write blocks until other files are
+// closed or uploaded and evicted, for at most -dirtyWaitTimeout, or fails with ENOSPC right away.
+// The room a write grows a staging file by is reserved from the running total of the staging
+// files under a lock, so that concurrent writers cannot go over the limit together
+const (
+	StagingFullBlock  = "block"
+	StagingFullENOSPC = "enospc"
+)
+
+// How often a write blocked by -maxStagingBytes checks whether there is room
+const stagingFullPollInterval = 100 * time.Millisecond
+
+var maxStagingBytes int64
+var stagingFullPolicy = StagingFullBlock
+
+// Returns the total size of the open staging files
+func (filesystem *FileSystem) stagingUsage() int64 {
+	filesystem.stagedMutex.Lock()
+	defer filesystem.stagedMutex.Unlock()
+	return filesystem.stagingBytes
+}
+
+// Called before a write of n bytes at the offset by the uid to the file. Reserves the room the
+// staging file grows by, see reserveStagingSize(). If the write would go over -maxStagingBytes,
+// the staging files of other files which are all in HDFS are evicted first, then the write blocks
+// or fails as set by -stagingFullPolicy if this is not enough. Past -maxStagingBytesPerUser, only
+// the staging files of the same user are evicted, and the write fails with EDQUOT
+func (filesystem *FileSystem) reserveStaging(file *FileINode, uid uint32, offset int64, n int64) error {
+	size, ok := file.stagingExtent(offset, n)
+	if !ok {
+		return nil
+	}
+	for waited := time.Duration(0); ; waited += stagingFullPollInterval {
+		owner, err := filesystem.reserveStagingSize(file, uid, size)
+		if err == nil {
+			return nil
+		}
+		for _, other := range filesystem.StagedFiles() {
+			if o, _ := filesystem.stagingOwner(other); other == file || (err == syscall.EDQUOT && o != owner) {
+				continue
+			}
+			if other.evictStaging() == 0 {
+				continue
+			}
+			if owner, err = filesystem.reserveStagingSize(file, uid, size); err == nil {
+				return nil
+			}
+		}
+		if err == syscall.EDQUOT {
+			logwarn("Staging files of the user are at -maxStagingBytesPerUser, failing the write", file.logInfo(Fields{Operation: Write, UID: owner, Bytes: filesystem.stagingUsageByUser()[owner]}))
+			return err
+		}
+		used := filesystem.stagingUsage()
+		if stagingFullPolicy == StagingFullENOSPC || waited >= dirtyWaitTimeout {
+			logerror("Staging files are at -maxStagingBytes, failing the write", file.logInfo(Fields{Operation: Write, Bytes: used}))
+			return syscall.ENOSPC
+		}
+		if waited == 0 {
+			logwarn("Staging files are at -maxStagingBytes, blocking the write", file.logInfo(Fields{Operation: Write, Bytes: used}))
+		}
+		<-filesystem.Clock.After(stagingFullPollInterval)
+	}
+}
+
+// Removes the staging file of the file if its content is all in HDFS, switching the handles back
+// to reading from HDFS. Returns the size of the removed staging file
+func (file *FileINode) evictStaging() int64 {
+	file.lockFileHandles()
+	defer file.unlockFileHandles()
+	proxy, ok := file.fileProxy.(*LocalRWFileProxy)
+	if !ok || proxy.dirty || file.Dirty() > 0 || file.logStream != nil {
+		return 0
+	}
+	info, err := proxy.localFile.Stat()
+	if err != nil {
+		return 0
+	}
+	reader, err := file.FileSystem.getDFSConnector().OpenRead(file.AbsolutePath())
+	if err != nil {
+		logwarn("Failed to open file for reading, keeping its staging file", file.logInfo(Fields{Operation: StagingEvictOp, Error: err}))
+		return 0
+	}
+	file.fileProxy = &RemoteROFileProxy{hdfsReader: reader, file: file, path: file.AbsolutePath()}
+	if err := proxy.Close(); err != nil {
+		logwarn("Failed to remove staging file", file.logInfo(Fields{Operation: StagingEvictOp, TmpFile: proxy.localFile.Name(), Error: err}))
+	}
+	file.FileSystem.removeStaged(file)
+	metrics.Record(StagingEvictOp, 0, info.Size(), 0, false, nil)
+	loginfo("Evicted staging file", file.logInfo(Fields{Operation: StagingEvictOp, Bytes: info.Size()}))
+	return info.Size()
+}
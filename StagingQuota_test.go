@@ -0,0 +1,88 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that staging files in HDFS are evicted at -maxStagingBytes, and that writes fail once nothing can be evicted
+func TestStagingQuota(t *testing.T) {
+	saveFlags(t, &quotaWarningPercent)
+	quotaWarningPercent = 0 // no quota check in the background after the upload
+	saveFlags(t, &maxStagingBytes, &stagingFullPolicy)
+	maxStagingBytes = 12
+	stagingFullPolicy = StagingFullENOSPC
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	open := func(name string, opens int) *FileHandle {
+		hdfsAccessor.EXPECT().OpenRead("/" + name).DoAndReturn(func(path string) (ReadSeekCloser, error) {
+			return &MockReadSeekCloserWithPseudoRandomContent{FileSize: 5}, nil
+		}).Times(opens)
+		hdfsAccessor.EXPECT().Stat("/"+name).Return(Attrs{Name: name, Mode: 0644, Size: 5}, nil).AnyTimes()
+		file := root.(*DirINode).NodeFromAttrs(Attrs{Name: name, Mode: 0644, Size: 5}).(*FileINode)
+		h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+		return h.(*FileHandle)
+	}
+
+	// a is written and uploaded, its staging file can be evicted
+	a := open("a", 3)
+	assert.Nil(t, a.Write(nil, &fuse.WriteRequest{Data: []byte("H"), Offset: 0}, &fuse.WriteResponse{}))
+	writer := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/a").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/a", os.FileMode(0644), true).Return(writer, nil)
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) { return len(b), nil })
+	writer.EXPECT().Close().Return(nil)
+	assert.Nil(t, a.Fsync(nil, &fuse.FsyncRequest{}))
+	assert.Equal(t, int64(5), fs.stagingUsage())
+
+	// downloading b and writing to it needs the room of a
+	b := open("b", 2)
+	assert.Nil(t, b.Write(nil, &fuse.WriteRequest{Data: []byte("xxxxxxxx"), Offset: 0}, &fuse.WriteResponse{}))
+	assert.Equal(t, int64(8), fs.stagingUsage())
+	_, local := a.File.fileProxy.(*LocalRWFileProxy)
+	assert.False(t, local)
+
+	// b is dirty and cannot be evicted
+	assert.Equal(t, syscall.ENOSPC, b.Write(nil, &fuse.WriteRequest{Data: make([]byte, 10), Offset: 6}, &fuse.WriteResponse{}))
+	assert.Nil(t, b.Write(nil, &fuse.WriteRequest{Data: []byte("yy"), Offset: 6}, &fuse.WriteResponse{}))
+	assert.Nil(t, a.Release(nil, nil))
+	assert.Nil(t, b.Release(nil, nil))
+}
+
+// Testing that the room of the staging files is reserved at once, and released with the staging file
+func TestReserveStagingSize(t *testing.T) {
+	saveFlags(t, &maxStagingBytes)
+	maxStagingBytes = 10
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{NewMockHdfsAccessor(gomock.NewController(t))}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	a, b := &FileINode{FileSystem: fs}, &FileINode{FileSystem: fs}
+
+	_, err := fs.reserveStagingSize(a, 1, 6)
+	assert.Nil(t, err)
+	_, err = fs.reserveStagingSize(b, 2, 6)
+	assert.Equal(t, syscall.ENOSPC, err)
+	assert.Equal(t, int64(6), fs.stagingUsage())
+	// rewriting the reserved bytes needs no room
+	_, err = fs.reserveStagingSize(a, 1, 4)
+	assert.Nil(t, err)
+	owner, err := fs.reserveStagingSize(b, 2, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), owner)
+	assert.Equal(t, map[uint32]int64{1: 6, 2: 4}, fs.stagingUsageByUser())
+
+	fs.removeStaged(a)
+	assert.Equal(t, int64(4), fs.stagingUsage())
+	assert.Equal(t, map[uint32]int64{2: 4}, fs.stagingUsageByUser())
+}
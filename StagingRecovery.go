@@ -0,0 +1,114 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+)
+
+// The sidecar of a staging file records when the file is written and when it is uploaded again.
+// A staging file left behind dirty by a crashed mount has data the application wrote and which
+// never reached HDFS. -recoverStaging tells what a mount restarted with the same mount point
+// and stage directory does with such files: none removes them as any other staging file of a
+// dead process, upload uploads them as if the file was closed, and quarantine moves them to
+// -failedUploadsDir for the replay-failed command. Either way, a file written in HDFS after the
+// staging file was last written keeps its content
+const (
+	RecoverStagingNone       = "none"
+	RecoverStagingUpload     = "upload"
+	RecoverStagingQuarantine = "quarantine"
+)
+
+var recoverStaging = RecoverStagingNone
+
+// Returns the sidecar of the staging file. Called with the file handles locked
+func (p *LocalRWFileProxy) owner() StagingOwner {
+	owner := StagingOwner{MountPoint: p.file.FileSystem.MountPoint, Path: p.file.AbsolutePath(), Dirty: p.dirty}
+	if p.dirty {
+		owner.Mode = p.file.Attrs.Mode
+	}
+	return owner
+}
+
+// Records in the sidecar whether the staging file has data which is not in DFS. Called with the file handles locked
+func (p *LocalRWFileProxy) journal(dirty bool) {
+	if p.dirty == dirty {
+		return
+	}
+	p.dirty = dirty
+	if err := tagStagingFile(p.localFile, p.owner()); err != nil {
+		logwarn("Failed to update staging file owner", p.file.logInfo(Fields{Operation: RecoverStagingOp, TmpFile: p.localFile.Name(), Error: err}))
+	}
+}
+
+// Called by the staging reaper for a staging file of a process which is not running anymore.
+// Resumes its upload if it was interrupted, and otherwise recovers the file as set by
+// -recoverStaging if it is dirty and belongs to this mount. Returns false if the file is not taken over
+func (filesystem *FileSystem) recoverStagingFile(stagingPath string, owner StagingOwner) bool {
+	if filesystem.resumeUpload(stagingPath, owner) {
+		return true
+	}
+	if recoverStaging == RecoverStagingNone || !owner.Dirty || owner.MountPoint != filesystem.MountPoint {
+		return false
+	}
+	info, err := os.Stat(stagingPath)
+	if err != nil {
+		return false
+	}
+	manifest := loadUploadManifest(stagingPath)
+	claimed, err := claimStagingFile(stagingPath)
+	if err != nil {
+		return false
+	}
+	stagingFile, err := os.Open(claimed)
+	if err != nil {
+		return false
+	}
+	// still dirty until recovered, a mount restarted after a failed recovery tries again
+	tagStagingFile(stagingFile, owner)
+	os.Remove(claimed + uploadManifestSuffix)
+	upload := ParkedUpload{
+		Path:       owner.Path,
+		Mode:       owner.Mode,
+		Size:       info.Size(),
+		MountPoint: owner.MountPoint,
+		Parked:     info.ModTime(),
+		Error:      "the mount process died before uploading the file",
+		file:       claimed,
+	}
+	logwarn("Recovering staging file of a crashed mount", Fields{Operation: RecoverStagingOp, Path: owner.Path, TmpFile: claimed,
+		PID: owner.Pid, Bytes: info.Size(), Message: recoverStaging})
+
+	if recoverStaging == RecoverStagingQuarantine {
+		defer stagingFile.Close()
+		parked, err := parkUpload(failedUploadsDir, stagingFile, upload)
+		if err != nil {
+			logerror("Failed to quarantine staging file, keeping it", Fields{Operation: RecoverStagingOp, Path: owner.Path, TmpFile: claimed, Error: err})
+			return true
+		}
+		removeStagingPath(claimed)
+		loginfo("Quarantined staging file, run replay-failed to upload it", Fields{Operation: RecoverStagingOp, Path: owner.Path, TmpFile: parked})
+		return true
+	}
+
+	go func() {
+		defer stagingFile.Close()
+		hdfsAccessor := filesystem.getDFSConnector()
+		if manifest != nil {
+			// the interrupted upload was of older content
+			hdfsAccessor.Remove(manifest.TempPath)
+		}
+		uploaded, err := replayParkedUpload(hdfsAccessor, upload)
+		if err != nil {
+			logerror("Failed to upload staging file of a crashed mount, keeping it", Fields{Operation: RecoverStagingOp, Path: owner.Path, TmpFile: claimed, Error: err})
+			return
+		}
+		removeStagingPath(claimed)
+		if !uploaded {
+			logwarn("Target was modified after the crash, discarding the staging file", Fields{Operation: RecoverStagingOp, Path: owner.Path, TmpFile: claimed})
+			return
+		}
+		loginfo("Uploaded staging file of a crashed mount", Fields{Operation: RecoverStagingOp, Path: owner.Path, Bytes: info.Size()})
+	}()
+	return true
+}
@@ -0,0 +1,113 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func readStagingOwner(stagingPath string) StagingOwner {
+	var owner StagingOwner
+	data, _ := ioutil.ReadFile(stagingPath + stagingOwnerSuffix)
+	json.Unmarshal(data, &owner)
+	return owner
+}
+
+// Testing that the sidecar records whether the staging file has data which is not uploaded
+func TestStagingJournal(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileHandle := openForDelta(t, mockCtrl, hdfsAccessor, "hello")
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("H"), Offset: 0}, &fuse.WriteResponse{}))
+	stagingPath := fileHandle.File.fileProxy.(*LocalRWFileProxy).localFile.Name()
+	owner := readStagingOwner(stagingPath)
+	assert.True(t, owner.Dirty)
+	assert.Equal(t, "/records", owner.Path)
+	assert.Equal(t, os.FileMode(0644), owner.Mode)
+
+	writer := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/records").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/records", os.FileMode(0644), true).Return(writer, nil)
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) { return len(b), nil })
+	writer.EXPECT().Close().Return(nil)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.False(t, readStagingOwner(stagingPath).Dirty)
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
+
+// Leaves the staging file of a crashed mount in dir
+func writeOrphan(dir string, name string, owner StagingOwner) string {
+	orphan := filepath.Join(dir, stagingFilePrefix+"999999999-"+name)
+	ioutil.WriteFile(orphan, []byte("data"), 0600)
+	owner.Pid = 999999999
+	data, _ := json.Marshal(owner)
+	ioutil.WriteFile(orphan+stagingOwnerSuffix, data, 0600)
+	return orphan
+}
+
+// Testing that the dirty staging files of a crashed mount are quarantined, and the clean ones removed
+func TestRecoverStagingQuarantine(t *testing.T) {
+	saveFlags(t, &recoverStaging, &failedUploadsDir)
+	dir, _ := ioutil.TempDir("", "staging")
+	defer os.RemoveAll(dir)
+	recoverStaging = RecoverStagingQuarantine
+	failedUploadsDir = filepath.Join(dir, "failed")
+	stageDir := filepath.Join(dir, "stage")
+	os.Mkdir(stageDir, 0700)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{NewMockHdfsAccessor(gomock.NewController(t))}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.MountPoint = "/mnt"
+
+	writeOrphan(stageDir, "1", StagingOwner{MountPoint: "/mnt", Path: "/dirty", Dirty: true, Mode: 0640})
+	writeOrphan(stageDir, "2", StagingOwner{MountPoint: "/mnt", Path: "/clean"})
+	writeOrphan(stageDir, "3", StagingOwner{MountPoint: "/other", Path: "/other", Dirty: true})
+	assert.Equal(t, 2, reapStagingFiles(stageDir, fs.recoverStagingFile))
+	entries, _ := ioutil.ReadDir(stageDir)
+	assert.Empty(t, entries)
+	uploads, err := listParkedUploads(failedUploadsDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(uploads))
+	assert.Equal(t, "/dirty", uploads[0].Path)
+	assert.Equal(t, os.FileMode(0640), uploads[0].Mode)
+	assert.Equal(t, int64(4), uploads[0].Size)
+}
+
+// Testing that the dirty staging files of a crashed mount are uploaded in the background
+func TestRecoverStagingUpload(t *testing.T) {
+	saveFlags(t, &recoverStaging)
+	dir, _ := ioutil.TempDir("", "staging")
+	defer os.RemoveAll(dir)
+	recoverStaging = RecoverStagingUpload
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.MountPoint = "/mnt"
+
+	writer := NewMockHdfsWriter(mockCtrl)
+	uploaded := make(chan []byte, 1)
+	hdfsAccessor.EXPECT().Stat("/dirty").Return(Attrs{Name: "dirty", Mode: 0640, Mtime: time.Now().Add(-time.Hour)}, nil)
+	hdfsAccessor.EXPECT().Remove("/dirty").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/dirty", os.FileMode(0640), true).Return(writer, nil)
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		uploaded <- append([]byte(nil), b...)
+		return len(b), nil
+	})
+	writer.EXPECT().Close().Return(nil)
+	writeOrphan(dir, "1", StagingOwner{MountPoint: "/mnt", Path: "/dirty", Dirty: true, Mode: 0640})
+	assert.Equal(t, 0, reapStagingFiles(dir, fs.recoverStagingFile))
+	assert.Equal(t, "data", string(<-uploaded))
+	assert.Eventually(t, func() bool {
+		entries, _ := ioutil.ReadDir(dir)
+		return len(entries) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,155 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The staging files of a mount shared by all local users (allow_other) are in one directory
+// owned by the mount, and any user can fill the disk for everyone. With -stagingPerUser the
+// staging files of each user are in a uid-<uid> subdirectory of the staging dir, owned by the
+// user like the staging files themselves, so that the disk quotas of the local file system
+// apply and du shows who uses the space. The staging files are accounted to the uid of the
+// process which created them, and with -maxStagingBytesPerUser a write which would grow the
+// staging files of a user past the limit first evicts the clean staging files of the user, then
+// fails with EDQUOT. The room a write grows a staging file by is reserved from a running total of
+// each user before the write, so that concurrent writers cannot both pass the limit. The usage of
+// each user is shown by the status command
+var stagingPerUser bool
+var maxStagingBytesPerUser int64
+
+// Prefix of the staging subdirectories of the users with -stagingPerUser
+const stagingUserDirPrefix = "uid-"
+
+// Returns the directory of the staging files of the uid in the staging dir, creating it if needed
+func userStagingDir(dir string, uid uint32) (string, error) {
+	if !stagingPerUser {
+		return dir, nil
+	}
+	dir = filepath.Join(dir, fmt.Sprintf("%s%d", stagingUserDirPrefix, uid))
+	if err := os.Mkdir(dir, 0700); err != nil {
+		if os.IsExist(err) {
+			return dir, nil
+		}
+		return "", err
+	}
+	chownStaging(dir, uid)
+	return dir, nil
+}
+
+// Returns true if the name is that of a staging subdirectory of a user
+func isUserStagingDir(name string) bool {
+	_, err := strconv.ParseUint(strings.TrimPrefix(name, stagingUserDirPrefix), 10, 32)
+	return strings.HasPrefix(name, stagingUserDirPrefix) && err == nil
+}
+
+// Gives a staging file or directory to the uid. A mount which does not run as root keeps them
+func chownStaging(path string, uid uint32) {
+	if err := os.Chown(path, int(uid), -1); err != nil {
+		logdebug("Unable to give the staging file to its user", Fields{TmpFile: path, UID: uid, Error: err})
+	}
+}
+
+// Size accounted to the staging file of a file, the largest size it was reserved or grew to
+type stagingReservation struct {
+	uid  uint32
+	size int64
+}
+
+// Accounts the staging file of the file to the uid, unless it is accounted to another user, at the
+// size if it is larger than the size accounted so far. Called with stagedMutex held
+func (filesystem *FileSystem) accountStaging(file *FileINode, uid uint32, size int64) stagingReservation {
+	if filesystem.stagingReserved == nil {
+		filesystem.stagingReserved = map[*FileINode]stagingReservation{}
+		filesystem.stagingUserBytes = map[uint32]int64{}
+	}
+	r, ok := filesystem.stagingReserved[file]
+	if !ok {
+		r.uid = uid
+	}
+	if size > r.size {
+		filesystem.stagingBytes += size - r.size
+		filesystem.stagingUserBytes[r.uid] += size - r.size
+		r.size = size
+	}
+	filesystem.stagingReserved[file] = r
+	return r
+}
+
+// Releases the size accounted to the staging file of the file, once removed. Called with stagedMutex held
+func (filesystem *FileSystem) releaseStaging(file *FileINode) {
+	r, ok := filesystem.stagingReserved[file]
+	if !ok {
+		return
+	}
+	delete(filesystem.stagingReserved, file)
+	filesystem.stagingBytes -= r.size
+	if filesystem.stagingUserBytes[r.uid] -= r.size; filesystem.stagingUserBytes[r.uid] <= 0 {
+		delete(filesystem.stagingUserBytes, r.uid)
+	}
+}
+
+// Reserves the room for the staging file of the file to grow to size, accounted to the uid unless
+// the staging file is accounted to another user. Fails, reserving nothing, with EDQUOT if the
+// staging files of the user would grow past -maxStagingBytesPerUser, and with ENOSPC if all the
+// staging files would grow past -maxStagingBytes. Returns the uid accounted
+func (filesystem *FileSystem) reserveStagingSize(file *FileINode, uid uint32, size int64) (uint32, error) {
+	filesystem.stagedMutex.Lock()
+	defer filesystem.stagedMutex.Unlock()
+	r, ok := filesystem.stagingReserved[file]
+	if !ok {
+		r.uid = uid
+	}
+	if need := size - r.size; need > 0 {
+		if maxStagingBytesPerUser > 0 && filesystem.stagingUserBytes[r.uid]+need > maxStagingBytesPerUser {
+			return r.uid, syscall.EDQUOT
+		}
+		if maxStagingBytes > 0 && filesystem.stagingBytes+need > maxStagingBytes {
+			return r.uid, syscall.ENOSPC
+		}
+	}
+	return filesystem.accountStaging(file, uid, size).uid, nil
+}
+
+// Returns the uid the staging file of the file is accounted to, false if it has none
+func (filesystem *FileSystem) stagingOwner(file *FileINode) (uint32, bool) {
+	filesystem.stagedMutex.Lock()
+	defer filesystem.stagedMutex.Unlock()
+	r, ok := filesystem.stagingReserved[file]
+	return r.uid, ok
+}
+
+// Returns the total size of the open staging files of each uid
+func (filesystem *FileSystem) stagingUsageByUser() map[uint32]int64 {
+	filesystem.stagedMutex.Lock()
+	defer filesystem.stagedMutex.Unlock()
+	usage := make(map[uint32]int64, len(filesystem.stagingUserBytes))
+	for uid, size := range filesystem.stagingUserBytes {
+		usage[uid] = size
+	}
+	return usage
+}
+
+// Returns the size the staging file of the file grows to by a write of n bytes at the offset, false
+// if the write does not go to a staging file
+func (file *FileINode) stagingExtent(offset int64, n int64) (int64, bool) {
+	file.lockFileHandles()
+	defer file.unlockFileHandles()
+	end := offset + n
+	switch file.fileProxy.(type) {
+	case *StreamingFileProxy:
+		return 0, false
+	case *RemoteROFileProxy:
+		// the file is downloaded first
+		if size := int64(file.Attrs.Size); size > end {
+			return size, true
+		}
+	}
+	return end, true
+}
@@ -0,0 +1,75 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the staging files of each user are in a directory of the user and limited by -maxStagingBytesPerUser
+func TestStagingPerUser(t *testing.T) {
+	saveFlags(t, &stagingDir, &stagingPerUser, &maxStagingBytesPerUser)
+	stagingDir, _ = ioutil.TempDir("", "hopsfs-stage")
+	defer os.RemoveAll(stagingDir)
+	stagingPerUser = true
+	maxStagingBytesPerUser = 8
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	open := func(name string, uid uint32) *FileHandle {
+		hdfsAccessor.EXPECT().OpenRead("/" + name).DoAndReturn(func(path string) (ReadSeekCloser, error) {
+			return &MockReadSeekCloserWithPseudoRandomContent{FileSize: 5}, nil
+		}).AnyTimes()
+		hdfsAccessor.EXPECT().Stat("/"+name).Return(Attrs{Name: name, Mode: 0644, Size: 5}, nil).AnyTimes()
+		file := root.(*DirINode).NodeFromAttrs(Attrs{Name: name, Mode: 0644, Size: 5}).(*FileINode)
+		h, err := file.Open(nil, &fuse.OpenRequest{Header: fuse.Header{Uid: uid}, Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+		return h.(*FileHandle)
+	}
+
+	a := open("a", 1)
+	assert.Nil(t, a.Write(nil, &fuse.WriteRequest{Data: []byte("H"), Offset: 0}, &fuse.WriteResponse{}))
+	b := open("b", 2)
+	// downloading b counts
+	assert.Equal(t, syscall.EDQUOT, b.Write(nil, &fuse.WriteRequest{Data: []byte("xxxxxx"), Offset: 3}, &fuse.WriteResponse{}))
+	assert.Nil(t, b.Write(nil, &fuse.WriteRequest{Data: []byte("xxx"), Offset: 0}, &fuse.WriteResponse{}))
+	assert.Equal(t, map[uint32]int64{1: 5, 2: 5}, fs.stagingUsageByUser())
+	stagingFile := a.File.fileProxy.(*LocalRWFileProxy).localFile.Name()
+	assert.Equal(t, filepath.Join(stagingDir, "uid-1"), filepath.Dir(stagingFile))
+	userName := func(uid uint32) string {
+		if name := idMapper.UserName(uid); name != "" {
+			return name
+		}
+		return strconv.FormatUint(uint64(uid), 10)
+	}
+	assert.Equal(t, map[string]int64{userName(1): 5, userName(2): 5}, fs.status().StagedUsers)
+
+	// a is dirty, nothing of uid 1 can be evicted
+	assert.Equal(t, syscall.EDQUOT, a.Write(nil, &fuse.WriteRequest{Data: []byte("yyyy"), Offset: 5}, &fuse.WriteResponse{}))
+	assert.Nil(t, a.Write(nil, &fuse.WriteRequest{Data: []byte("yyy"), Offset: 5}, &fuse.WriteResponse{}))
+}
+
+// Testing that the staging files of crashed processes are reaped in the directories of the users too
+func TestReapUserStagingDirs(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hopsfs-stage")
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "uid-1000"), 0700)
+	os.Mkdir(filepath.Join(dir, "uid-x"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "uid-1000", stagingFilePrefix+"999999999-1234"), []byte("data"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "uid-x", stagingFilePrefix+"999999999-1234"), []byte("data"), 0600)
+	assert.Equal(t, 1, reapStagingFiles(dir, nil))
+	assert.True(t, isUserStagingDir("uid-0"))
+	assert.False(t, isUserStagingDir("uid-"))
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// Debugging a hung mount needed the admin socket, which may be stuck behind the same lock as
+// the mount, or the info level, which is too verbose to keep on. On SIGUSR1, e.g., kill -USR1
+// <pid>, the mount logs a snapshot of its state: the status, i.e., the connection with the
+// namenodes, dirty and staged data and cache sizes, the number of open handles of each file,
+// the cache hit ratios, and the counts, errors, retries and latencies of every operation since
+// the last -metricsLogInterval summary. The snapshot is logged at the info level, or at the
+// error level if -logLevel is above info, so that it is logged without restarting the mount
+// with another -logLevel. It is not logged with -logLevel fatal or panic. Listens for the life
+// of the process
+func (filesystem *FileSystem) dumpStatsOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	for range sigs {
+		filesystem.dumpStats()
+	}
+}
+
+// Logs a snapshot of the state of the mount, see above
+func (filesystem *FileSystem) dumpStats() {
+	lvl := logger.InfoLevel
+	if logThreshold < lvl {
+		lvl = logger.ErrorLevel
+	}
+	if logThreshold < lvl {
+		return
+	}
+	status, _ := json.Marshal(filesystem.status())
+	logmessage(lvl, "Statistics dump", Fields{Operation: StatsDumpOp, Message: string(status)})
+
+	handles := map[string]int{}
+	for _, fh := range filesystem.Handles.Handles() {
+		handles[fh.File.AbsolutePath()]++
+	}
+	paths := make([]string, 0, len(handles))
+	for p := range handles {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		logmessage(lvl, "Statistics dump: open handles", Fields{Operation: StatsDumpOp, Path: p, Count: handles[p]})
+	}
+
+	snapshot, classes := metrics.snapshot(false)
+	logmessage(lvl, "Statistics dump", Fields{Operation: CacheHitRatios, Message: formatCacheHitRatios(cacheHitRatios(snapshot))})
+	for _, op := range sortedOps(snapshot) {
+		logmessage(lvl, "Statistics dump", opSummaryFields(op, snapshot[op], classes[op]))
+	}
+}
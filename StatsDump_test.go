@@ -0,0 +1,45 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	logger "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the statistics dump is logged at the error level when -logLevel is above info,
+// with the status, the open handles of each file and the operations
+func TestDumpStats(t *testing.T) {
+	defer initLogger("fatal", false, "")
+	initLogger("error", false, "")
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(WallClock{}), WallClock{})
+	hdfsAccessor.EXPECT().OpenRead("/data").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 5, ReaderStats: &ReaderStats{}}, nil)
+	root, _ := fs.Root()
+	file := root.(*DirINode).NodeFromAttrs(Attrs{Name: "data", Mode: 0644, Size: 5}).(*FileINode)
+	for i := 0; i < 2; i++ {
+		_, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+	}
+	metrics.Record(Read, 0, 5, 1, false, nil)
+
+	fs.dumpStats()
+	assert.Contains(t, buf.String(), "[ERRO]")
+	assert.Contains(t, buf.String(), "dirty_bytes")
+	assert.Contains(t, buf.String(), "[op:stats_dump] [path:/data] [count:2]")
+	assert.Contains(t, buf.String(), "[op:read]")
+
+	buf.Reset()
+	initLogger("fatal", false, "")
+	logger.SetOutput(&buf)
+	fs.dumpStats()
+	assert.Equal(t, "", buf.String())
+}
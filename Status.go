@@ -0,0 +1,278 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The status admin command reports the state of a running mount as one JSON document, for
+// monitoring scripts and login banners: hopsfs-mount status --json <mount point>. Without
+// --json the sub command prints the same fields as name: value lines
+func init() {
+	registerAdminCommand("status", AdminCommand{
+		Help:    "Prints the state of the mount as JSON: connection, credential expiry, dirty data, caches and build",
+		Handler: statusCmd,
+	})
+	registerSubcommand("status", Subcommand{
+		Usage: "[--json] [-adminSocket path] MountPoint",
+		Help:  "Prints the state of a running mount, same as admin status",
+		Run:   runStatus,
+	})
+}
+
+// State of a running mount, as reported by the status command
+type MountStatus struct {
+	Build       BuildInfo          `json:"build"`
+	MountPoint  string             `json:"mount_point"`
+	SrcDir      string             `json:"src_dir"`
+	ReadOnly    bool               `json:"read_only"`
+	Frozen      bool               `json:"frozen"`
+	Connection  ConnectionStatus   `json:"connection"`
+	Credentials []CredentialStatus `json:"credentials,omitempty"`
+	DirtyBytes  int64              `json:"dirty_bytes"`                    // written and not uploaded yet
+	StagedFiles int                `json:"staged_files"`                   // files with a staging file
+	StagedBytes int64              `json:"staged_bytes"`                   // size of the staging files
+	StagedUsers map[string]int64   `json:"staged_bytes_by_user,omitempty"` // size of the staging files of each user
+	BlockCache  *CacheStatus       `json:"block_cache,omitempty"`
+	BlockMemory *CacheStatus       `json:"block_cache_memory,omitempty"`
+	Capacity    *CapacityStatus    `json:"capacity,omitempty"` // usage of HDFS at the last poll of -capacityInterval
+}
+
+// Health of the connection with the namenodes
+type ConnectionStatus struct {
+	State         string     `json:"state"`                           // ok, failing or unknown before the first call
+	Namenode      string     `json:"namenode,omitempty"`              // namenode of the last connection
+	Failovers     int        `json:"failovers,omitempty"`             // connections to another namenode than the previous one
+	Unhealthy     int        `json:"unhealthy_connections,omitempty"` // connections of the pool which failed recently
+	LastSuccess   *time.Time `json:"last_success,omitempty"`
+	LastFailure   *time.Time `json:"last_failure,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	CanarySuccess *time.Time `json:"canary_last_success,omitempty"`
+	CanaryError   string     `json:"canary_error,omitempty"`
+}
+
+// Credential the connections are authenticated with
+type CredentialStatus struct {
+	Kind    string     `json:"kind"` // tls_certificate or kerberos
+	Name    string     `json:"name"` // certificate file or principal
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Usage of HDFS
+type CapacityStatus struct {
+	Capacity    uint64  `json:"capacity"`
+	Used        uint64  `json:"used"`
+	Remaining   uint64  `json:"remaining"`
+	UsedPercent float64 `json:"used_percent"`
+	Quota       string  `json:"quota"` // closest quota applying to the source dir, see quotaUsageXAttr
+}
+
+// Usage of a cache
+type CacheStatus struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// Outcome of the latest calls to the namenodes, recorded by InstrumentedHdfsAccessor. A call
+// which the namenode answered with an error, e.g., ENOENT, tells that the connection works
+// Concurrency: thread safe
+type BackendHealth struct {
+	mutex       sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+	namenode    string
+	failovers   int
+}
+
+var backendHealth BackendHealth
+
+func (health *BackendHealth) observe(now time.Time, err error) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	if IsSuccessOrNonRetriableError(err) {
+		health.lastSuccess = now
+	} else {
+		health.lastFailure = now
+		health.lastError = err.Error()
+	}
+}
+
+// Records the namenode of a new connection, see NamenodeFailover
+func (health *BackendHealth) connected(namenode string) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.namenode = namenode
+}
+
+// Counts a connection to another namenode than the previous one
+func (health *BackendHealth) failedOver() {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.failovers++
+}
+
+// Returns the status of the connection from the calls observed and the canary
+func (health *BackendHealth) status(canary *CanaryMonitor) ConnectionStatus {
+	health.mutex.Lock()
+	status := ConnectionStatus{State: "unknown", Namenode: health.namenode, Failovers: health.failovers}
+	if !health.lastSuccess.IsZero() {
+		status.State = "ok"
+		status.LastSuccess = timePtr(health.lastSuccess)
+	}
+	if !health.lastFailure.IsZero() {
+		status.LastFailure = timePtr(health.lastFailure)
+		status.LastError = health.lastError
+		if health.lastFailure.After(health.lastSuccess) {
+			status.State = "failing"
+		}
+	}
+	health.mutex.Unlock()
+	if canary != nil {
+		success, err := canary.Status()
+		if !success.IsZero() {
+			status.CanarySuccess = timePtr(success)
+		}
+		if err != nil {
+			status.CanaryError = err.Error()
+		}
+	}
+	return status
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+// Returns the status of the mount
+func (filesystem *FileSystem) status() MountStatus {
+	status := MountStatus{
+		Build:      buildInfo(),
+		MountPoint: filesystem.MountPoint,
+		SrcDir:     filesystem.SrcDir,
+		ReadOnly:   filesystem.ReadOnly,
+		Frozen:     filesystem.Mutations.Frozen(),
+		Connection: backendHealth.status(filesystem.Canary),
+		DirtyBytes: filesystem.Dirty.Dirty(),
+	}
+	if filesystem.Pool != nil {
+		status.Connection.Unhealthy = filesystem.Pool.Unhealthy()
+	}
+	if refresher := filesystem.CredentialRefresher; refresher != nil {
+		status.Credentials = append(status.Credentials, CredentialStatus{Kind: "tls_certificate", Name: refresher.CertificateFile, Expires: timePtr(refresher.Expiry())})
+	}
+	if kerberosLogin != nil {
+		credential := CredentialStatus{Kind: "kerberos", Name: kerberosLogin.Client().Credentials.CName().PrincipalNameString()}
+		if expiry := kerberosLogin.Expiry(); !expiry.IsZero() {
+			credential.Expires = timePtr(expiry)
+		}
+		status.Credentials = append(status.Credentials, credential)
+	}
+	status.StagedFiles = len(filesystem.StagedFiles())
+	status.StagedBytes = filesystem.stagingUsage()
+	for uid, size := range filesystem.stagingUsageByUser() {
+		if status.StagedUsers == nil {
+			status.StagedUsers = map[string]int64{}
+		}
+		name := idMapper.UserName(uid)
+		if name == "" {
+			name = strconv.FormatUint(uint64(uid), 10)
+		}
+		status.StagedUsers[name] += size
+	}
+	if filesystem.BlockCache != nil {
+		entries, size := filesystem.BlockCache.Usage()
+		status.BlockCache = &CacheStatus{Entries: entries, Bytes: size}
+		entries, size = filesystem.BlockCache.MemoryUsage()
+		status.BlockMemory = &CacheStatus{Entries: entries, Bytes: size}
+	}
+	if filesystem.Capacity != nil {
+		status.Capacity = filesystem.Capacity.status()
+	}
+	return status
+}
+
+func statusCmd(filesystem *FileSystem, args []string, out *AdminOutput) error {
+	b, err := json.Marshal(filesystem.status())
+	if err != nil {
+		return err
+	}
+	out.Printf("%s", b)
+	return nil
+}
+
+// Entry point of the "status" sub command
+func runStatus(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "Prints the status as one JSON document")
+	socket := flags.String("adminSocket", "", "Admin socket of the mount, same as its -adminSocket. By default it is derived from the mount point")
+	flags.Usage = func() {
+		subcommandUsage("status")
+		fmt.Fprintf(os.Stderr, "  \nOptions:\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	mountPoint, err := filepath.Abs(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid path %s. Error: %v\n", flags.Arg(0), err)
+		return 2
+	}
+	err = sendAdminRequest(adminSocketFor(*socket, mountPoint), AdminRequest{Command: "status"}, func(msg string) {
+		if *jsonOutput {
+			fmt.Println(msg)
+			return
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(msg), &fields); err != nil {
+			fmt.Println(msg)
+			return
+		}
+		printStatusFields("", fields)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "status failed. Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// Prints the fields of the JSON status as name: value lines, nested fields as parent.name
+func printStatusFields(prefix string, fields map[string]interface{}) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch value := fields[name].(type) {
+		case map[string]interface{}:
+			printStatusFields(prefix+name+".", value)
+		case []interface{}:
+			for i, item := range value {
+				if m, ok := item.(map[string]interface{}); ok {
+					printStatusFields(fmt.Sprintf("%s%s.%d.", prefix, name, i), m)
+				} else {
+					fmt.Printf("%s%s.%d: %v\n", prefix, name, i, item)
+				}
+			}
+		case float64:
+			// JSON numbers are floats, sizes must not be printed in exponent notation
+			fmt.Printf("%s%s: %.0f\n", prefix, name, value)
+		default:
+			fmt.Printf("%s%s: %v\n", prefix, name, value)
+		}
+	}
+}
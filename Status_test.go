@@ -0,0 +1,79 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the status command reports the connection state and the dirty data as JSON
+func TestStatus(t *testing.T) {
+	defer func() { backendHealth = BackendHealth{} }()
+	backendHealth = BackendHealth{}
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	status := func() MountStatus {
+		var buffer bytes.Buffer
+		assert.Nil(t, statusCmd(fs, nil, &AdminOutput{encoder: json.NewEncoder(&buffer)}))
+		var reply AdminReply
+		assert.Nil(t, json.NewDecoder(&buffer).Decode(&reply))
+		var status MountStatus
+		assert.Nil(t, json.Unmarshal([]byte(reply.Message), &status))
+		return status
+	}
+
+	s := status()
+	assert.Equal(t, "unknown", s.Connection.State)
+	assert.Equal(t, VERSION, s.Build.Version)
+	assert.Equal(t, int64(0), s.DirtyBytes)
+	assert.Nil(t, s.Credentials)
+
+	// an error answered by the namenode tells that the connection works
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	backendHealth.observe(now, syscall.ENOENT)
+	s = status()
+	assert.Equal(t, "ok", s.Connection.State)
+	assert.True(t, now.Equal(*s.Connection.LastSuccess))
+
+	backendHealth.observe(now.Add(time.Second), errors.New("connection refused"))
+	s = status()
+	assert.Equal(t, "failing", s.Connection.State)
+	assert.Equal(t, "connection refused", s.Connection.LastError)
+
+	backendHealth.observe(now.Add(2*time.Second), nil)
+	assert.Equal(t, "ok", status().Connection.State)
+}
+
+// Testing that the status sub command reaches a mount started with a custom -adminSocket
+func TestStatusAdminSocket(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	dir, err := ioutil.TempDir("", "hopsfs-mount-admin")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "admin.sock")
+	server, err := NewAdminServer(fs, socketPath)
+	assert.Nil(t, err)
+	defer server.Close()
+	go server.Serve()
+
+	assert.Equal(t, socketPath, adminSocketFor(socketPath, dir))
+	assert.Equal(t, defaultAdminSocketPath(dir), adminSocketFor("", dir))
+	assert.Equal(t, 0, runStatus([]string{"-json", "-adminSocket", socketPath, dir}))
+	assert.Equal(t, 1, runStatus([]string{"-json", dir}))
+}
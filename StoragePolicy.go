@@ -0,0 +1,73 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"strconv"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+)
+
+// Moving data between the storage tiers needed hdfs storagepolicies. The storage policy of a
+// file or directory is its user.hdfs.storagePolicy extended attribute, e.g.,
+// getfattr -n user.hdfs.storagePolicy <path>, the policy it inherits if none is set on it, and
+// no attribute if the policy is unspecified up to the root. Setting the attribute, e.g.,
+// setfattr -n user.hdfs.storagePolicy -v COLD <dir>, sets the policy, which applies to the new
+// blocks, the existing ones are moved by the HDFS mover, and removing it unsets the policy.
+// The HDFS client has no storage policy RPCs, so setting the policy needs -webhdfsURL, and
+// fails with ENOTSUP without it
+const storagePolicyXAttr = "user.hdfs.storagePolicy"
+
+// Names of the storage policies of HDFS and HopsFS by their ids
+var storagePolicyNames = map[uint8]string{
+	1:  "PROVIDED",
+	2:  "COLD",
+	5:  "WARM",
+	7:  "HOT",
+	10: "ONE_SSD",
+	12: "ALL_SSD",
+	14: "DB", // HopsFS, small files stored in the database of the namenode
+	15: "LAZY_PERSIST",
+}
+
+// Returns the name of the storage policy of the HDFS path, ENODATA if it is unspecified
+func (filesystem *FileSystem) getStoragePolicyXAttr(hdfsPath string) ([]byte, error) {
+	// not the cached attributes, the policy of the entry changes with the one of its parents
+	attrs, err := filesystem.getDFSConnector().Stat(hdfsPath)
+	if err != nil {
+		return nil, err
+	}
+	if attrs.Policy == 0 {
+		return nil, fuse.ErrNoXattr
+	}
+	if name, ok := storagePolicyNames[attrs.Policy]; ok {
+		return []byte(name), nil
+	}
+	return []byte(strconv.Itoa(int(attrs.Policy))), nil
+}
+
+// Sets the storage policy of the HDFS path to the value of the attribute
+func (filesystem *FileSystem) setStoragePolicyXAttr(hdfsPath string, value []byte) error {
+	policy := strings.ToUpper(strings.TrimSpace(string(value)))
+	if policy == "" || strings.Trim(policy, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_") != "" {
+		return syscall.EINVAL
+	}
+	return filesystem.setStoragePolicy(hdfsPath, policy)
+}
+
+// Sets the storage policy of the HDFS path, or unsets it if empty
+func (filesystem *FileSystem) setStoragePolicy(hdfsPath string, policy string) error {
+	if err := filesystem.checkWritableAt(hdfsPath); err != nil {
+		return err
+	}
+	filesystem.Mutations.Enter()
+	defer filesystem.Mutations.Exit()
+	if err := filesystem.getDFSConnector().SetStoragePolicy(hdfsPath, policy); err != nil {
+		logwarn("Failed to set the storage policy", Fields{Operation: StoragePolicyOp, Path: hdfsPath, Message: policy, Error: err})
+		return err
+	}
+	loginfo("Storage policy set", Fields{Operation: StoragePolicyOp, Path: hdfsPath, Message: policy})
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the storage policy of files and directories is read and set through the extended attribute
+func TestStoragePolicyXAttr(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(WallClock{}), WallClock{})
+	root, _ := fs.Root()
+	dir := root.(*DirINode).NodeFromAttrs(Attrs{Name: "archive", Mode: os.ModeDir | 0755}).(*DirINode)
+	file := dir.NodeFromAttrs(Attrs{Name: "a", Mode: 0644}).(*FileINode)
+
+	hdfsAccessor.EXPECT().Stat("/archive").Return(Attrs{Name: "archive", Mode: os.ModeDir | 0755, Policy: 2}, nil)
+	resp := &fuse.GetxattrResponse{}
+	assert.Nil(t, dir.Getxattr(nil, &fuse.GetxattrRequest{Name: storagePolicyXAttr}, resp))
+	assert.Equal(t, "COLD", string(resp.Xattr))
+	hdfsAccessor.EXPECT().Stat("/archive/a").Return(Attrs{Name: "a", Mode: 0644}, nil)
+	assert.Equal(t, fuse.ErrNoXattr, file.Getxattr(nil, &fuse.GetxattrRequest{Name: storagePolicyXAttr}, resp))
+
+	hdfsAccessor.EXPECT().SetStoragePolicy("/archive", "ALL_SSD").Return(nil)
+	assert.Nil(t, dir.Setxattr(nil, &fuse.SetxattrRequest{Name: storagePolicyXAttr, Xattr: []byte("all_ssd\n")}))
+	hdfsAccessor.EXPECT().SetStoragePolicy("/archive/a", "").Return(nil)
+	assert.Nil(t, file.Removexattr(nil, &fuse.RemovexattrRequest{Name: storagePolicyXAttr}))
+	assert.Equal(t, syscall.EINVAL, file.Setxattr(nil, &fuse.SetxattrRequest{Name: storagePolicyXAttr, Xattr: []byte("hot cold")}))
+	assert.Equal(t, syscall.EINVAL, file.Setxattr(nil, &fuse.SetxattrRequest{Name: storagePolicyXAttr}))
+}
+
+// Testing that the storage and erasure coding policies are set and unset over WebHDFS
+func TestWebHdfsSetPolicies(t *testing.T) {
+	var ops []string
+	var ecPolicy string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "alice", r.URL.Query().Get("user.name"))
+		ops = append(ops, r.Method+" "+r.URL.Query().Get("op")+" "+r.URL.Path+" "+r.URL.Query().Get("storagepolicy"))
+		if policy := r.URL.Query().Get("ecpolicy"); policy != "" {
+			ecPolicy = policy
+		}
+		if r.URL.Query().Get("storagepolicy") == "UNKNOWN" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	webhdfs, err := NewWebHdfs(server.URL, WallClock{}, TLSConfig{})
+	assert.Nil(t, err)
+	dfs := &hdfsAccessorImpl{User: "alice", WebHdfs: webhdfs}
+	assert.Nil(t, dfs.SetStoragePolicy("/data/a b", "COLD"))
+	assert.Nil(t, dfs.SetStoragePolicy("/data/a b", ""))
+	assert.Equal(t, syscall.EINVAL, dfs.SetStoragePolicy("/data", "UNKNOWN"))
+	assert.Equal(t, []string{"PUT SETSTORAGEPOLICY /webhdfs/v1/data/a b COLD", "POST UNSETSTORAGEPOLICY /webhdfs/v1/data/a b ", "PUT SETSTORAGEPOLICY /webhdfs/v1/data UNKNOWN"}, ops)
+
+	assert.Nil(t, dfs.SetErasureCodingPolicy("/data", "RS-6-3-1024k"))
+	assert.Nil(t, dfs.SetErasureCodingPolicy("/data", ""))
+	assert.Equal(t, []string{"PUT SETECPOLICY /webhdfs/v1/data ", "POST UNSETECPOLICY /webhdfs/v1/data "}, ops[3:])
+	assert.Equal(t, "RS-6-3-1024k", ecPolicy)
+
+	assert.Equal(t, syscall.ENOTSUP, (&hdfsAccessorImpl{}).SetStoragePolicy("/data", "COLD"))
+	assert.Equal(t, syscall.ENOTSUP, (&hdfsAccessorImpl{}).SetErasureCodingPolicy("/data", "RS-6-3-1024k"))
+}
@@ -0,0 +1,217 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// With -streamingWrites, new files are written directly to the HDFS writer instead of a
+// staging file as long as they are written sequentially, e.g., by cp, tar and dd, so that
+// large files need no local disk and close does not wait for an upload. The first write
+// which is not at the end of the file, a read or a truncate to another size, closes the
+// writer and falls back to a staging file with the content streamed so far. Flush closes
+// the writer if it is the last handle, so that errors of the close are returned by close(2),
+// and fsync flushes the writer (hflush). Streamed data is not retried: a failed write fails
+// the write call and leaves the file with the data written before.
+// Existing files opened with O_APPEND, e.g., by >> and log appenders, are streamed the same
+// way with the HDFS append RPC, unless -appendWrites=false, so only the new data is shipped
+type StreamingFileProxy struct {
+	writer    HdfsWriter // nil once closed
+	file      *FileINode
+	connector HdfsAccessor // connection of the writer, see -impersonate
+	uid       uint32       // uid of the writer, to whom the staging file of a fall back belongs
+	written   int64        // size of the file
+	pending   int64        // data written since the last flush of the writer
+	mtime     time.Time    // time of the last write
+}
+
+var _ FileProxy = (*StreamingFileProxy)(nil)
+
+// Creates the file in DFS and returns the proxy streaming to it
+func (file *FileINode) newStreamingFileProxy(operation string, hdfsAccessor HdfsAccessor, uid uint32) (*StreamingFileProxy, error) {
+	w, err := hdfsAccessor.CreateFile(file.AbsolutePath(), file.Attrs.Mode, false)
+	if err != nil {
+		logerror("Failed to create file in DFS", file.logInfo(Fields{Operation: operation, Error: err}))
+		return nil, err
+	}
+	return &StreamingFileProxy{writer: w, file: file, connector: hdfsAccessor, uid: uid, mtime: file.FileSystem.Clock.Now()}, nil
+}
+
+// Opens the file in DFS for append and returns the proxy streaming to its end
+func (file *FileINode) newAppendingFileProxy(hdfsAccessor HdfsAccessor, uid uint32) (*StreamingFileProxy, error) {
+	attrs, err := hdfsAccessor.Stat(file.AbsolutePath())
+	if err != nil {
+		return nil, err
+	}
+	w, err := hdfsAccessor.Append(file.AbsolutePath())
+	if err != nil {
+		return nil, err
+	}
+	return &StreamingFileProxy{writer: w, file: file, connector: hdfsAccessor, uid: uid, written: int64(attrs.Size), mtime: attrs.Mtime}, nil
+}
+
+// Closes the writer and replaces the proxy of the file by a staging file with the content
+// written so far. Must be called with the file handles locked
+func (p *StreamingFileProxy) fallBack() (*LocalRWFileProxy, error) {
+	if p.writer != nil {
+		err := p.writer.Close()
+		p.writer = nil
+		if err != nil {
+			logerror("Failed to close streaming writer", p.file.logInfo(Fields{Operation: Close, Error: err}))
+			return nil, err
+		}
+	}
+	p.file.fileProxy = nil
+	stagingFile, err := p.file.createStagingFile(Open, true, p.connector, p.uid)
+	if err != nil {
+		p.file.fileProxy = p
+		return nil, err
+	}
+	local := newLocalRWFileProxy(stagingFile, p.file)
+	p.file.fileProxy = local
+	loginfo("Non-sequential access, streaming write falls back to staging", p.file.logInfo(Fields{Operation: StreamFallback, Bytes: p.written}))
+	return local, nil
+}
+
+func (p *StreamingFileProxy) Truncate(size int64) (int64, error) {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	if p.writer != nil && size == p.written {
+		return 0, nil
+	}
+	local, err := p.fallBack()
+	if err != nil {
+		return 0, err
+	}
+	return truncateStagingFile(local.localFile, size)
+}
+
+func (p *StreamingFileProxy) WriteAt(b []byte, off int64) (int, error) {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	if p.writer == nil && off == p.written && appendWrites {
+		// written again after the writer was closed by the flush of close(2), e.g., of a dup'ed descriptor
+		if w, err := p.connector.Append(p.file.AbsolutePath()); err == nil {
+			p.writer = w
+		}
+	}
+	if p.writer != nil && off == p.written {
+		n, err := p.writer.Write(b)
+		p.written += int64(n)
+		p.pending += int64(n)
+		p.mtime = p.file.FileSystem.Clock.Now()
+		return n, err
+	}
+	local, err := p.fallBack()
+	if err != nil {
+		return 0, err
+	}
+	return local.localFile.WriteAt(b, off)
+}
+
+func (p *StreamingFileProxy) ReadAt(b []byte, off int64) (int, error) {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	local, err := p.fallBack()
+	if err != nil {
+		return 0, err
+	}
+	return local.localFile.ReadAt(b, off)
+}
+
+func (p *StreamingFileProxy) SeekToStart() error {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	local, err := p.fallBack()
+	if err != nil {
+		return err
+	}
+	_, err = local.localFile.Seek(0, io.SeekStart)
+	return err
+}
+
+func (p *StreamingFileProxy) Read(b []byte) (int, error) {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	local, err := p.fallBack()
+	if err != nil {
+		return 0, err
+	}
+	return local.localFile.Read(b)
+}
+
+func (p *StreamingFileProxy) Close() error {
+	//NOTE: Locking is done in File.go
+	if p.writer == nil {
+		return nil
+	}
+	err := p.writer.Close()
+	p.writer = nil
+	return err
+}
+
+// Flushes the writer, the data is then visible to readers of the file
+func (p *StreamingFileProxy) Sync() error {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	if p.writer == nil || p.pending == 0 {
+		return nil
+	}
+	if err := p.writer.Flush(); err != nil {
+		return err
+	}
+	p.pending = 0
+	return nil
+}
+
+// Closes the writer if no other handle is open, flushes it otherwise
+func (p *StreamingFileProxy) finish() error {
+	p.file.lockFileHandles()
+	if len(p.file.activeHandles) > 1 {
+		p.file.unlockFileHandles()
+		return p.Sync()
+	}
+	defer p.file.unlockFileHandles()
+	p.pending = 0
+	return p.Close()
+}
+
+// Returns true if the writer is not closed yet
+func (p *StreamingFileProxy) streaming() bool {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	return p.writer != nil
+}
+
+// Returns the size and modification time of the file written so far
+func (p *StreamingFileProxy) stat() (int64, time.Time) {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	return p.written, p.mtime
+}
+
+// Makes the data streamed through the handle visible in HDFS, instead of uploading a staging file
+func (fh *FileHandle) flushStream(proxy *StreamingFileProxy, operation string) error {
+	start := fh.File.FileSystem.Clock.Now()
+	var err error
+	if operation == Flush {
+		err = proxy.finish()
+	} else {
+		err = proxy.Sync()
+	}
+	size, _ := proxy.stat()
+	metrics.Record(operation, fh.File.FileSystem.Clock.Now().Sub(start), size, 0, false, err)
+	if err != nil {
+		logerror("Failed to flush streamed data to DFS", fh.logInfo(Fields{Operation: operation, Error: err}))
+		return err
+	}
+	loginfo("Flushed streamed data to DFS", fh.logInfo(Fields{Operation: operation, Bytes: size}))
+	return nil
+}
+
+// Returns true if new files in the path are streamed to HDFS
+func isStreamingPath(absPath string) bool {
+	return streamingWrites && !isLogStreamPath(absPath)
+}
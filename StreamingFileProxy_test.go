@@ -0,0 +1,140 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that sequential writes are streamed to HDFS, and that the file is completed on flush
+func TestStreamingWrites(t *testing.T) {
+	saveFlags(t, &streamingWrites)
+	streamingWrites = true
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+
+	writer := NewMockHdfsWriter(mockCtrl)
+	var written []byte
+	hdfsAccessor.EXPECT().CreateFile("/streamed", os.FileMode(0644), false).Return(writer, nil)
+	hdfsAccessor.EXPECT().Chown("/streamed", gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().Stat("/streamed").Return(Attrs{Name: "streamed", Mode: 0644}, nil).AnyTimes()
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		written = append(written, b...)
+		return len(b), nil
+	}).Times(2)
+	writer.EXPECT().Flush().Return(nil)
+	writer.EXPECT().Close().Return(nil)
+
+	root, _ := fs.Root()
+	_, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "streamed",
+		Flags: fuse.OpenWriteOnly | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("hello "), Offset: 0}, &fuse.WriteResponse{}))
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("world"), Offset: 6}, &fuse.WriteResponse{}))
+	assert.Equal(t, int64(0), fs.Dirty.Dirty())
+
+	var attr fuse.Attr
+	assert.Nil(t, fileHandle.Attr(nil, &attr))
+	assert.Equal(t, uint64(11), attr.Size)
+
+	assert.Nil(t, fileHandle.Fsync(nil, &fuse.FsyncRequest{}))
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Equal(t, "hello world", string(written))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
+
+// Testing that a write which is not at the end of the file falls back to a staging file with the data streamed so far
+func TestStreamingWriteFallBack(t *testing.T) {
+	saveFlags(t, &streamingWrites)
+	streamingWrites = true
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+
+	writer := NewMockHdfsWriter(mockCtrl)
+	var streamed bytes.Buffer
+	hdfsAccessor.EXPECT().CreateFile("/patched", os.FileMode(0644), false).Return(writer, nil)
+	hdfsAccessor.EXPECT().Chown("/patched", gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	writer.EXPECT().Write(gomock.Any()).DoAndReturn(streamed.Write)
+	writer.EXPECT().Close().Return(nil)
+
+	// the content streamed so far is downloaded into the staging file
+	hdfsAccessor.EXPECT().Stat("/patched").Return(Attrs{Name: "patched", Mode: 0644}, nil).AnyTimes()
+	reader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/patched").Return(reader, nil)
+	reader.EXPECT().Read(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		if streamed.Len() == 0 {
+			return 0, io.EOF
+		}
+		return streamed.Read(b)
+	}).AnyTimes()
+	reader.EXPECT().Close().Return(nil)
+
+	root, _ := fs.Root()
+	_, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: "patched",
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("hello world"), Offset: 0}, &fuse.WriteResponse{}))
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("W"), Offset: 6}, &fuse.WriteResponse{}))
+	proxy, ok := fileHandle.File.fileProxy.(*LocalRWFileProxy)
+	assert.True(t, ok)
+	content := make([]byte, 11)
+	proxy.localFile.ReadAt(content, 0)
+	assert.Equal(t, "hello World", string(content))
+
+	// the staging file is uploaded on flush
+	uploaded := NewMockHdfsWriter(mockCtrl)
+	var upload []byte
+	hdfsAccessor.EXPECT().Remove("/patched").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/patched", os.FileMode(0644), true).Return(uploaded, nil)
+	uploaded.EXPECT().Write(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		upload = append(upload, b...)
+		return len(b), nil
+	}).AnyTimes()
+	uploaded.EXPECT().Close().Return(nil)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Equal(t, "hello World", string(upload))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
+
+// Testing that a file opened with O_APPEND only ships the new data with the append RPC
+func TestAppendWrites(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+
+	reader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/app.log").Return(reader, nil)
+	reader.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Stat("/app.log").Return(Attrs{Name: "app.log", Mode: 0644, Size: 4}, nil)
+	writer := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Append("/app.log").Return(writer, nil)
+	writer.EXPECT().Write([]byte("line\n")).Return(5, nil)
+	writer.EXPECT().Close().Return(nil)
+
+	root, _ := fs.Root()
+	file := root.(*DirINode).NodeFromAttrs(Attrs{Name: "app.log", Mode: 0644, Size: 4}).(*FileINode)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("line\n"), Offset: 4}, &fuse.WriteResponse{}))
+	var attr fuse.Attr
+	assert.Nil(t, fileHandle.Attr(nil, &attr))
+	assert.Equal(t, uint64(9), attr.Size)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
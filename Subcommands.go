@@ -0,0 +1,229 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A sub command of the hopsfs-mount binary, e.g., hopsfs-mount umount /mnt/hopsfs.
+// The first argument selects the sub command, without one the arguments are those of mount,
+// so that existing mount scripts and fstab entries keep working
+type Subcommand struct {
+	Usage string                  // arguments of the sub command
+	Help  string                  // one line description of the sub command
+	Run   func(args []string) int // runs the sub command and returns the process exit code
+}
+
+var subcommands = make(map[string]Subcommand)
+
+// Registers a sub command of the binary
+func registerSubcommand(name string, cmd Subcommand) {
+	subcommands[name] = cmd
+}
+
+func init() {
+	registerSubcommand("mount", Subcommand{
+		Usage: "[Options] Namenode:Port MountPoint",
+		Help:  "Mounts HopsFS, the default if no sub command is given",
+		Run:   runMount,
+	})
+	registerSubcommand("umount", Subcommand{
+		Usage: "[-drain] [-drainTimeout duration] [-adminSocket path] MountPoint",
+		Help:  "Unmounts HopsFS, also if the mount process is gone. With -drain, once the open files are closed",
+		Run:   runUnmount,
+	})
+	registerSubcommand("admin", Subcommand{
+		Usage: "[Options] Command [Args]",
+		Help:  "Sends an admin command to a running mount",
+		Run:   runAdminClient,
+	})
+	registerSubcommand("stats", Subcommand{
+		Usage: "MountPoint",
+		Help:  "Prints the statistics of a running mount, same as admin stats",
+		Run:   runStats,
+	})
+	registerSubcommand("prefetch", Subcommand{
+		Usage: "Path [depth]",
+		Help:  "Lists a directory tree of a running mount into its cache, same as admin prefetch",
+		Run: func(args []string) int {
+			return runAdminClient(append([]string{"prefetch"}, args...))
+		},
+	})
+	registerSubcommand("replay-failed", Subcommand{
+		Usage: "MountPoint",
+		Help:  "Uploads the files parked by failed flushes of a running mount, same as admin replay-failed",
+		Run: func(args []string) int {
+			return runMountPointCommand("replay-failed", args)
+		},
+	})
+	registerSubcommand("commit", Subcommand{
+		Usage: "MountPoint",
+		Help:  "Pushes the changes kept in -overlayDir of a running mount to HopsFS, same as admin commit",
+		Run: func(args []string) int {
+			return runMountPointCommand("commit", args)
+		},
+	})
+	registerSubcommand("selftest", Subcommand{
+		Usage: "[Options] Namenode:Port [HDFSDir]",
+		Help:  "Checks the connection to HopsFS with the options of mount, and that files can be written to HDFSDir",
+		Run:   runSelftest,
+	})
+	registerSubcommand("version", Subcommand{
+		Help: "Prints the version",
+		Run:  runVersion,
+	})
+	registerSubcommand("completion", Subcommand{
+		Usage: "bash",
+		Help:  "Prints the bash completion script, e.g., source <(hopsfs-mount completion bash)",
+		Run:   runCompletion,
+	})
+}
+
+// Returns names of all sub commands in alphabetical order
+func subcommandNames() []string {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func subcommandUsage(name string) {
+	fmt.Fprintf(os.Stderr, "Usage of %s %s:\n", os.Args[0], name)
+	fmt.Fprintf(os.Stderr, "  %s\n", strings.TrimSpace(fmt.Sprintf("%s %s %s", os.Args[0], name, subcommands[name].Usage)))
+}
+
+func runStats(args []string) int {
+	return runMountPointCommand("stats", args)
+}
+
+// Sends the admin command of the same name as the sub command to the mount given as the only argument
+func runMountPointCommand(name string, args []string) int {
+	if len(args) != 1 {
+		subcommandUsage(name)
+		return 2
+	}
+	mountPoint, err := filepath.Abs(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid path %s. Error: %v\n", args[0], err)
+		return 2
+	}
+	return runAdminClient([]string{"-mountPoint", mountPoint, name})
+}
+
+// Connects to HopsFS like mount does, stats the source directory and the file system, and
+// writes, reads back and deletes a file in HDFSDir like the canary of a mount
+func runSelftest(args []string) int {
+	retryPolicy := NewDefaultRetryPolicy(WallClock{})
+	parseFlags(retryPolicy, args, func() {
+		subcommandUsage("selftest")
+		fmt.Fprintf(os.Stderr, "  \nOptions:\n")
+		flag.PrintDefaults()
+	}, 1, 2)
+	initKerberos()
+
+	tlsConfig := tlsConfigFromFlags()
+	namenodes, err := resolveNamenodeArg(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL connect: %v\n", err)
+		return 1
+	}
+	hdfsAccessor, err := NewHdfsAccessor(strings.Join(namenodes, ","), WallClock{}, tlsConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL connect: %v\n", err)
+		return 1
+	}
+	defer hdfsAccessor.Close()
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, retryPolicy)
+
+	failed := false
+	check := func(name string, f func() error) {
+		if err := f(); err != nil {
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			failed = true
+			return
+		}
+		fmt.Printf("ok   %s\n", name)
+	}
+	check("connect", ftHdfsAccessor.EnsureConnected)
+	check("stat "+mntSrcDir, func() error {
+		_, err := ftHdfsAccessor.Stat(mntSrcDir)
+		return err
+	})
+	check("list "+mntSrcDir, func() error {
+		_, err := ftHdfsAccessor.ReadDir(mntSrcDir)
+		return err
+	})
+	check("statfs", func() error {
+		_, err := ftHdfsAccessor.StatFs()
+		return err
+	})
+	check("server defaults", func() error {
+		defaults, err := ftHdfsAccessor.ServerDefaults()
+		if err == nil {
+			fmt.Printf("     data transfer: %s\n", describeDataTransfer(tlsConfig, defaults))
+		}
+		return err
+	})
+	if flag.NArg() == 2 {
+		fileSystem, err := NewFileSystem([]HdfsAccessor{ftHdfsAccessor}, mntSrcDir, []string{"*"}, false, retryPolicy, WallClock{})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FAIL %v\n", err)
+			return 1
+		}
+		check("write, read and delete in "+flag.Arg(1), NewCanaryMonitor(fileSystem, flag.Arg(1), 0).probe)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func runVersion(args []string) int {
+	info := buildInfo()
+	fmt.Printf("hopsfs-mount %s\n", info.Version)
+	fmt.Printf("git commit: %s\n", info.GitCommit)
+	fmt.Printf("built: %s by %s with %s\n", info.BuildTime, HOSTNAME, info.GoVersion)
+	fmt.Printf("hdfs client: %s\n", info.HdfsClient)
+	return 0
+}
+
+func runCompletion(args []string) int {
+	if len(args) != 1 || args[0] != "bash" {
+		subcommandUsage("completion")
+		return 2
+	}
+	flags := flag.NewFlagSet("mount", flag.ContinueOnError)
+	registerFlags(flags, NewDefaultRetryPolicy(WallClock{}))
+	var options []string
+	flags.VisitAll(func(f *flag.Flag) {
+		options = append(options, "-"+f.Name)
+	})
+	fmt.Print(bashCompletion(filepath.Base(os.Args[0]), subcommandNames(), adminCommandNames(), options))
+	return 0
+}
+
+// Returns a bash completion script completing the sub commands, the admin commands and the options
+func bashCompletion(program string, commands, adminNames, options []string) string {
+	return fmt.Sprintf(`_hopsfs_mount() {
+    local cur=${COMP_WORDS[COMP_CWORD]}
+    if [ "$COMP_CWORD" -eq 1 ]; then
+        COMPREPLY=($(compgen -W "%s %s" -- "$cur"))
+    elif [ "$COMP_CWORD" -eq 2 ] && [ "${COMP_WORDS[1]}" = "admin" ]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+    elif [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+    else
+        COMPREPLY=($(compgen -f -- "$cur"))
+    fi
+}
+complete -o filenames -F _hopsfs_mount %s
+`, strings.Join(commands, " "), strings.Join(options, " "), strings.Join(adminNames, " "), strings.Join(options, " "), program)
+}
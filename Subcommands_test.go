@@ -0,0 +1,30 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the legacy invocation is not taken for a sub command
+func TestSubcommands(t *testing.T) {
+	for _, name := range []string{"mount", "umount", "admin", "stats", "prefetch", "selftest", "version", "completion"} {
+		_, ok := subcommands[name]
+		assert.True(t, ok, name)
+	}
+	for _, name := range subcommandNames() {
+		assert.False(t, strings.HasPrefix(name, "-"))
+		assert.False(t, strings.Contains(name, ":"))
+	}
+}
+
+// Testing that the completion script completes sub commands, admin commands and options
+func TestBashCompletion(t *testing.T) {
+	script := bashCompletion("hopsfs-mount", []string{"admin", "mount"}, []string{"du", "rmr"}, []string{"-lazy", "-tls"})
+	assert.Contains(t, script, `compgen -W "admin mount -lazy -tls"`)
+	assert.Contains(t, script, `compgen -W "du rmr"`)
+	assert.Contains(t, script, "complete -o filenames -F _hopsfs_mount hopsfs-mount\n")
+}
@@ -0,0 +1,194 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+// HDFS symlinks listed by the namenode are shown as symlinks, readlink returns their target.
+// The HDFS client has no RPC to create symlinks, so with -symlinkSuffix ln -s creates a small
+// file named after the link and the suffix holding the target, which the mount shows as a
+// symlink named without the suffix. Other HDFS clients see the marker file
+var symlinkSuffix string
+
+// Maximum length of a symlink target, PATH_MAX of Linux
+const symlinkMaxTarget = 4096
+
+// Symbolic link, an HDFS symlink or an emulated one
+type SymlinkINode struct {
+	FileSystem *FileSystem // pointer to the FileSystem which owns this link
+	Parent     *DirINode   // pointer to the parent directory (allows computing fully-qualified paths on demand)
+	Attrs      Attrs       // Cache of file attributes, Target is read on the first readlink for emulated links
+	Emulated   bool        // the link is a marker file named after the link and -symlinkSuffix
+	mutex      sync.Mutex  // guards Attrs
+}
+
+// Verify that *SymlinkINode implements necesary FUSE interfaces
+var _ fs.Node = (*SymlinkINode)(nil)
+var _ fs.NodeReadlinker = (*SymlinkINode)(nil)
+var _ fs.NodeSymlinker = (*DirINode)(nil)
+
+// Returns the HDFS path of the link, of the marker file for emulated links
+func (link *SymlinkINode) AbsolutePath() string {
+	if link.Emulated {
+		return link.Parent.AbsolutePathForChild(link.Attrs.Name + symlinkSuffix)
+	}
+	return link.Parent.AbsolutePathForChild(link.Attrs.Name)
+}
+
+// Responds on FUSE request to get the attributes of the link
+func (link *SymlinkINode) Attr(ctx context.Context, a *fuse.Attr) error {
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+	return link.Attrs.ConvertAttrToFuse(a)
+}
+
+// Responds on FUSE Readlink request
+func (link *SymlinkINode) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+	if link.Attrs.Target != "" || !link.Emulated {
+		return link.Attrs.Target, nil
+	}
+	path := link.AbsolutePath()
+	reader, err := link.FileSystem.getDFSConnector().OpenRead(path)
+	if err != nil {
+		logwarn("Failed to open the symlink marker file", Fields{Operation: Readlink, Path: path, Error: err})
+		return "", err
+	}
+	defer reader.Close()
+	target, err := ioutil.ReadAll(io.LimitReader(reader, symlinkMaxTarget+1))
+	if err != nil {
+		logwarn("Failed to read the symlink marker file", Fields{Operation: Readlink, Path: path, Error: err})
+		return "", err
+	}
+	if len(target) == 0 || len(target) > symlinkMaxTarget {
+		logwarn("Invalid symlink marker file", Fields{Operation: Readlink, Path: path, Bytes: len(target)})
+		return "", syscall.EIO
+	}
+	link.Attrs.Target = string(target)
+	return link.Attrs.Target, nil
+}
+
+// Returns the attributes of the emulated link of a marker file, or false if the entry is not a marker file
+func emulatedSymlinkAttrs(attrs Attrs) (Attrs, bool) {
+	if symlinkSuffix == "" || !attrs.Mode.IsRegular() || attrs.Size == 0 || attrs.Size > symlinkMaxTarget ||
+		len(attrs.Name) <= len(symlinkSuffix) || !strings.HasSuffix(attrs.Name, symlinkSuffix) {
+		return attrs, false
+	}
+	attrs.Name = strings.TrimSuffix(attrs.Name, symlinkSuffix)
+	attrs.Mode = os.ModeSymlink | 0777
+	attrs.Target = ""
+	return attrs, true
+}
+
+// Creates the node of an emulated link. Called with the directory locked
+func (dir *DirINode) emulatedSymlinkNode(attrs Attrs) fs.Node {
+	node := dir.NodeFromAttrs(attrs)
+	node.(*SymlinkINode).Emulated = true
+	if cached := dir.EntriesGet(attrs.Name); cached != nil {
+		if link, ok := (*cached).(*SymlinkINode); ok {
+			link.Emulated = true
+		}
+	}
+	return node
+}
+
+// Returns the HDFS name of the child, the name of the marker file for emulated links. Called with the directory locked
+func (dir *DirINode) hdfsEntryName(name string) string {
+	if node := dir.EntriesGet(name); node != nil {
+		if link, ok := (*node).(*SymlinkINode); ok && link.Emulated {
+			return name + symlinkSuffix
+		}
+	}
+	return name
+}
+
+// Returns the HDFS path of the entry with the name which a rename to the HDFS name replaces without
+// HDFS renaming over it, an emulated link replaced by a file or a file replaced by an emulated link,
+// or empty if none. Called with the directory locked
+func (dir *DirINode) shadowedEntry(name string, hdfsName string) string {
+	if node := dir.EntriesGet(name); node != nil {
+		if entry := dir.hdfsEntryName(name); entry != hdfsName {
+			return dir.AbsolutePathForChild(entry)
+		}
+	}
+	return ""
+}
+
+// Responds on FUSE Symlink request, creating the marker file of an emulated link
+func (dir *DirINode) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node, error) {
+	if err := dir.FileSystem.checkWritableAt(dir.AbsolutePathForChild(req.NewName)); err != nil {
+		return nil, err
+	}
+	dir.FileSystem.Mutations.Enter()
+	defer dir.FileSystem.Mutations.Exit()
+	dir.lockMutex()
+	defer dir.unlockMutex()
+
+	if symlinkSuffix == "" {
+		return nil, syscall.ENOTSUP
+	}
+	name, err := dir.hdfsChildName(req.NewName)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Target) == 0 || len(req.Target) > symlinkMaxTarget {
+		return nil, syscall.ENAMETOOLONG
+	}
+	if err := dir.FileSystem.checkAccess(&dir.Attrs, req.Header, accessWrite|accessExec, dir.AbsolutePath()); err != nil {
+		return nil, err
+	}
+	// the name of the link itself must be free too
+	if node := dir.EntriesGet(name); node != nil {
+		return nil, syscall.EEXIST
+	}
+	if _, err := dir.FileSystem.getDFSConnector().Stat(dir.AbsolutePathForChild(name)); err != syscall.ENOENT {
+		if err == nil {
+			return nil, syscall.EEXIST
+		}
+		return nil, err
+	}
+
+	hdfsAccessor, err := dir.FileSystem.connectorFor(req.Header)
+	if err != nil {
+		return nil, err
+	}
+	path := dir.AbsolutePathForChild(name + symlinkSuffix)
+	loginfo("Creating symlink to "+req.Target, Fields{Operation: Symlink, Path: path})
+	writer, err := hdfsAccessor.CreateFile(path, 0644, false)
+	if err != nil {
+		logwarn("Failed to create the symlink marker file", Fields{Operation: Symlink, Path: path, Error: err})
+		return nil, err
+	}
+	if _, err := writer.Write([]byte(req.Target)); err != nil {
+		writer.Close()
+		hdfsAccessor.Remove(path)
+		logwarn("Failed to write the symlink marker file", Fields{Operation: Symlink, Path: path, Error: err})
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		hdfsAccessor.Remove(path)
+		logwarn("Failed to close the symlink marker file", Fields{Operation: Symlink, Path: path, Error: err})
+		return nil, err
+	}
+	if dir.FileSystem.impersonated(hdfsAccessor) {
+		// created as the caller, who already owns it
+	} else if err := ChownOp(&dir.Attrs, hdfsAccessor, path, req.Uid, req.Gid); err != nil {
+		logwarn("Unable to change ownership of new symlink", Fields{Operation: Symlink, Path: path, UID: req.Uid, GID: req.Gid, Error: err})
+		hdfsAccessor.Remove(path)
+		return nil, err
+	}
+	return dir.emulatedSymlinkNode(Attrs{Name: name, Mode: os.ModeSymlink | 0777, Size: uint64(len(req.Target)),
+		Uid: req.Uid, Gid: req.Gid, Target: req.Target}), nil
+}
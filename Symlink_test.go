@@ -0,0 +1,82 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that HDFS symlinks and emulated ones are shown as symlinks, and that ln -s, mv and rm work on emulated ones
+func TestSymlink(t *testing.T) {
+	saveFlags(t, &symlinkSuffix)
+	symlinkSuffix = ""
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	dir := root.(*DirINode)
+
+	_, err := dir.Symlink(nil, &fuse.SymlinkRequest{NewName: "best", Target: "data/model.pt"})
+	assert.Equal(t, syscall.ENOTSUP, err)
+	symlinkSuffix = ".symlink"
+
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Name: "data", Mode: os.ModeDir | 0755},
+		{Name: "latest.symlink", Mode: 0644, Size: 7},
+		{Name: "current", Mode: os.ModeSymlink | 0777, Size: 4, Target: "data"}}, nil)
+	entries, err := dir.ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []fuse.Dirent{{Name: "data", Type: fuse.DT_Dir}, {Name: "latest", Type: fuse.DT_Link},
+		{Name: "current", Type: fuse.DT_Link}}, entries)
+
+	node, err := dir.Lookup(nil, "current")
+	assert.Nil(t, err)
+	target, err := node.(*SymlinkINode).Readlink(nil, &fuse.ReadlinkRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, "data", target)
+
+	// the target of an emulated link is read once
+	reader := NewMockReadSeekCloser(mockCtrl)
+	hdfsAccessor.EXPECT().OpenRead("/latest.symlink").Return(reader, nil)
+	reader.EXPECT().Read(gomock.Any()).DoAndReturn(func(b []byte) (int, error) { return copy(b, "../data"), io.EOF })
+	reader.EXPECT().Close().Return(nil)
+	node, err = dir.Lookup(nil, "latest")
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		target, err = node.(*SymlinkINode).Readlink(nil, &fuse.ReadlinkRequest{})
+		assert.Nil(t, err)
+		assert.Equal(t, "../data", target)
+	}
+	attr := fuse.Attr{}
+	assert.Nil(t, node.Attr(nil, &attr))
+	assert.Equal(t, os.ModeSymlink|0777, attr.Mode)
+
+	// ln -s creates the marker file, mv and rm move and remove it
+	writer := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Stat("/best").Return(Attrs{}, syscall.ENOENT)
+	hdfsAccessor.EXPECT().CreateFile("/best.symlink", os.FileMode(0644), false).Return(writer, nil)
+	writer.EXPECT().Write([]byte("data/model.pt")).Return(13, nil)
+	writer.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Chown(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	node, err = dir.Symlink(nil, &fuse.SymlinkRequest{NewName: "best", Target: "data/model.pt"})
+	assert.Nil(t, err)
+	_, err = dir.Symlink(nil, &fuse.SymlinkRequest{NewName: "best", Target: "data"})
+	assert.Equal(t, syscall.EEXIST, err)
+
+	hdfsAccessor.EXPECT().Rename("/best.symlink", "/top.symlink").Return(nil)
+	assert.Nil(t, dir.Rename(nil, &fuse.RenameRequest{OldName: "best", NewName: "top"}, dir))
+	target, err = node.(*SymlinkINode).Readlink(nil, &fuse.ReadlinkRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, "data/model.pt", target)
+	assert.Equal(t, "/top.symlink", node.(*SymlinkINode).AbsolutePath())
+	hdfsAccessor.EXPECT().Remove("/top.symlink").Return(nil)
+	assert.Nil(t, dir.Remove(nil, &fuse.RemoveRequest{Name: "top"}))
+}
@@ -0,0 +1,136 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// An rm through the mount deleted the files for good, while hdfs dfs -rm moves them to the
+// trash of the user. With -useTrash, unlink and rmdir move the entry to
+// /user/<user>/.Trash/Current/<HDFS path> instead, creating the missing directories, as hdfs dfs
+// -rm does: <user> is the HDFS user of the mount, or the user of the process with -impersonate,
+// and an entry already in the trash under the same name gets the time of the deletion appended
+// to the name of the new one. The entries of the trash are deleted by the trash emptier of the
+// namenode, after fs.trash.interval. Deleting entries already in a .Trash directory, and the
+// paths matching the globs of -trashSkipPaths, in the syntax of -protectedPaths, e.g., a scratch
+// directory /tmp/**, deletes them for good, as does the rmr admin command. The entries which
+// cannot be moved to the trash, the packed files of -packDirs and the entries routed to another
+// namenode than the home directory (EXDEV), or all of them if the home directory of the user is
+// missing or not writable, are deleted for good too, with a warning
+var useTrash bool
+var trashSkipPaths string
+
+// Name of the trash directory in the home directory of a user
+const trashDirName = ".Trash"
+
+// Returns true if the HDFS path is deleted for good rather than moved to the trash
+func skipsTrash(p string) bool {
+	if !useTrash || strings.Contains(p+"/", "/"+trashDirName+"/") {
+		return true
+	}
+	components := pathComponents(p)
+	for _, pattern := range strings.Split(trashSkipPaths, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if !strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(pattern, path.Base(p)); matched {
+				return true
+			}
+		} else if matchComponents(pathComponents(pattern), components) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the HDFS user the mount acts as: the short name of the Kerberos principal, or the common
+// name of the client certificate with -tls, as the namenode authenticates them, HADOOP_USER_NAME
+// or the user of the process otherwise
+func mountUserName() string {
+	if kerberosLogin != nil {
+		return kerberosLogin.UserName()
+	}
+	if tls != nil && *tls && clientCertificate != "" {
+		cert, err := readCertificate(clientCertificate)
+		if err == nil && cert.Subject.CommonName != "" {
+			return cert.Subject.CommonName
+		}
+		logwarn(fmt.Sprintf("Unable to read the user of %s", clientCertificate), Fields{Error: err})
+	}
+	return hadoopUserName
+}
+
+// Returns the current trash directory of the user issuing the operations of the connector
+func (filesystem *FileSystem) trashDir(hdfsAccessor HdfsAccessor, uid uint32) string {
+	user := mountUserName()
+	if filesystem.impersonated(hdfsAccessor) {
+		user = filesystem.UserConnectors.userName(uid)
+	}
+	return path.Join("/user", user, trashDirName, "Current")
+}
+
+// Removes the HDFS path, or moves it to the trash with -useTrash, see above. A directory is only
+// moved if it is empty, as rmdir only removes empty ones
+func (filesystem *FileSystem) removeOrTrash(hdfsAccessor HdfsAccessor, p string, isDir bool, uid uint32) error {
+	if skipsTrash(p) {
+		return hdfsAccessor.Remove(p)
+	}
+	if isDir {
+		entries, err := hdfsAccessor.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return syscall.ENOTEMPTY
+		}
+	}
+	root := filesystem.trashDir(hdfsAccessor, uid)
+	target := path.Join(root, p)
+	if _, err := hdfsAccessor.Stat(target); err == nil {
+		target = fmt.Sprintf("%s%d", target, filesystem.Clock.Now().UnixNano()/1000000)
+	}
+	err := hdfsAccessor.Rename(p, target)
+	if err != nil && unwrapAndTranslateError(err) != syscall.EXDEV {
+		// the first deletion below the parent, creates the missing directories and retries
+		if err = mkdirTrash(hdfsAccessor, root, path.Dir(target)); err == nil {
+			err = hdfsAccessor.Rename(p, target)
+		}
+	}
+	if err != nil && !trashUsable(err) {
+		logwarn("Unable to move to the trash, removing for good", Fields{Operation: Remove, Path: p, Error: err, Message: root})
+		return hdfsAccessor.Remove(p)
+	}
+	if err != nil {
+		logwarn("Failed to move to the trash", Fields{Operation: Remove, Path: p, Error: err})
+		return err
+	}
+	loginfo("Moved to the trash", Fields{Operation: Remove, Path: p, Message: target})
+	return nil
+}
+
+// Returns false if the error of moving an entry to the trash tells that it cannot be moved there
+func trashUsable(err error) bool {
+	switch unwrapAndTranslateError(err) {
+	case syscall.EXDEV, syscall.ENOENT, syscall.EACCES, syscall.EPERM:
+		return false
+	}
+	return true
+}
+
+// Creates the directory of the trash and its missing parents, from the home directory of the user
+func mkdirTrash(hdfsAccessor HdfsAccessor, root string, dir string) error {
+	home := path.Dir(path.Dir(root))
+	for _, name := range pathComponents(strings.TrimPrefix(dir, home)) {
+		home = path.Join(home, name)
+		if err := hdfsAccessor.Mkdir(home, os.ModeDir|0700); err != nil && !isExistError(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that -useTrash moves the removed entries to the trash of the user, creating its
+// directories, and that the entries of the trash and of -trashSkipPaths are deleted for good
+func TestUseTrash(t *testing.T) {
+	saveFlags(t, &useTrash, &trashSkipPaths, &hadoopUserName)
+	useTrash, trashSkipPaths, hadoopUserName = true, "/data/scratch/**", "alice"
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	data := root.(*DirINode).NodeFromAttrs(Attrs{Name: "data", Mode: os.ModeDir | 0777}).(*DirINode)
+
+	// the first deletion creates the directories of the trash
+	hdfsAccessor.EXPECT().Stat("/user/alice/.Trash/Current/data/a").Return(Attrs{}, syscall.ENOENT)
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().Rename("/data/a", "/user/alice/.Trash/Current/data/a").Return(syscall.ENOENT),
+		hdfsAccessor.EXPECT().Mkdir("/user/alice/.Trash", os.ModeDir|0700).Return(nil),
+		hdfsAccessor.EXPECT().Mkdir("/user/alice/.Trash/Current", os.ModeDir|0700).Return(nil),
+		hdfsAccessor.EXPECT().Mkdir("/user/alice/.Trash/Current/data", os.ModeDir|0700).Return(nil),
+		hdfsAccessor.EXPECT().Rename("/data/a", "/user/alice/.Trash/Current/data/a").Return(nil),
+	)
+	assert.Nil(t, data.Remove(nil, &fuse.RemoveRequest{Name: "a"}))
+
+	// a name already in the trash gets the time of the deletion appended
+	hdfsAccessor.EXPECT().Stat("/user/alice/.Trash/Current/data/a").Return(Attrs{Name: "a"}, nil)
+	hdfsAccessor.EXPECT().Rename("/data/a", "/user/alice/.Trash/Current/data/a1577880000000").Return(nil)
+	assert.Nil(t, data.Remove(nil, &fuse.RemoveRequest{Name: "a"}))
+
+	// rmdir only moves empty directories
+	hdfsAccessor.EXPECT().ReadDir("/data/full").Return([]Attrs{{Name: "b"}}, nil)
+	assert.Equal(t, syscall.ENOTEMPTY, data.Remove(nil, &fuse.RemoveRequest{Name: "full", Dir: true}))
+
+	hdfsAccessor.EXPECT().Remove("/data/scratch/c").Return(nil)
+	assert.Nil(t, fs.removeOrTrash(hdfsAccessor, "/data/scratch/c", false, 0))
+	hdfsAccessor.EXPECT().Remove("/user/alice/.Trash/Current/data/a").Return(nil)
+	assert.Nil(t, fs.removeOrTrash(hdfsAccessor, "/user/alice/.Trash/Current/data/a", false, 0))
+}
+
+// Testing that the entries which cannot be moved to the trash are deleted for good
+func TestUseTrashFallback(t *testing.T) {
+	saveFlags(t, &useTrash, &hadoopUserName)
+	useTrash, hadoopUserName = true, "alice"
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	hdfsAccessor.EXPECT().Stat(gomock.Any()).Return(Attrs{}, syscall.ENOENT).AnyTimes()
+
+	// a packed file, or a file of another namenode
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().Rename("/packed/a", "/user/alice/.Trash/Current/packed/a").Return(syscall.EXDEV),
+		hdfsAccessor.EXPECT().Remove("/packed/a").Return(nil),
+	)
+	assert.Nil(t, fs.removeOrTrash(hdfsAccessor, "/packed/a", false, 0))
+
+	// no home directory
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().Rename("/data/b", "/user/alice/.Trash/Current/data/b").Return(syscall.ENOENT),
+		hdfsAccessor.EXPECT().Mkdir("/user/alice/.Trash", os.ModeDir|0700).Return(syscall.ENOENT),
+		hdfsAccessor.EXPECT().Remove("/data/b").Return(nil),
+	)
+	assert.Nil(t, fs.removeOrTrash(hdfsAccessor, "/data/b", false, 0))
+
+	// a home directory which is not writable
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().Rename("/data/c", "/user/alice/.Trash/Current/data/c").Return(syscall.ENOENT),
+		hdfsAccessor.EXPECT().Mkdir("/user/alice/.Trash", os.ModeDir|0700).Return(syscall.EACCES),
+		hdfsAccessor.EXPECT().Remove("/data/c").Return(nil),
+	)
+	assert.Nil(t, fs.removeOrTrash(hdfsAccessor, "/data/c", false, 0))
+
+	// other failures fail the deletion
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().Rename("/data/d", "/user/alice/.Trash/Current/data/d").Return(syscall.EIO),
+		hdfsAccessor.EXPECT().Mkdir("/user/alice/.Trash", os.ModeDir|0700).Return(syscall.EIO),
+	)
+	assert.Equal(t, syscall.EIO, fs.removeOrTrash(hdfsAccessor, "/data/d", false, 0))
+}
+
+// Testing that the trash of a TLS mount is the one of the user of the client certificate
+func TestTrashDirTLS(t *testing.T) {
+	saveFlags(t, &tls, &clientCertificate, &hadoopUserName)
+	f, _ := ioutil.TempFile("", "cert")
+	f.Close()
+	defer os.Remove(f.Name())
+	writeTestCertificate(t, f.Name(), time.Now().Add(time.Hour))
+	enabled := true
+	tls, clientCertificate, hadoopUserName = &enabled, f.Name(), "alice"
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{NewMockHdfsAccessor(gomock.NewController(t))}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	assert.Equal(t, "/user/hdfs/.Trash/Current", fs.trashDir(fs.HdfsAccessors[0], 0))
+
+	enabled = false
+	assert.Equal(t, "/user/alice/.Trash/Current", fs.trashDir(fs.HdfsAccessors[0], 0))
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"syscall"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// macOS clients send names decomposed (NFD), e.g., "e" followed by a combining accent, while
+// Linux tools send what was typed, mostly composed (NFC). HDFS compares names byte by byte, so
+// the same name created from both ends up as two entries. With -unicodeNormalization the names
+// of the entries created through the mount are normalized to one form. Lookups, removes and
+// renames of an entry stored in another form, by other clients or before the option was set,
+// find it by any form of its name, and it keeps its HDFS name
+var unicodeNormalization = NormalizationNone
+
+// Values of -unicodeNormalization
+const (
+	NormalizationNone = "none" // names are passed as they are
+	NormalizationNFC  = "nfc"  // composed, like Linux and Windows tools
+	NormalizationNFD  = "nfd"  // decomposed, like macOS
+)
+
+// Returns the name in the form of -unicodeNormalization
+func normalizeName(name string) string {
+	switch unicodeNormalization {
+	case NormalizationNFC:
+		return norm.NFC.String(name)
+	case NormalizationNFD:
+		return norm.NFD.String(name)
+	}
+	return name
+}
+
+// Returns the other forms the entry with the normalized name may have in HDFS, none for ASCII names
+func nameVariants(name string) []string {
+	if unicodeNormalization == NormalizationNone {
+		return nil
+	}
+	var variants []string
+	for _, variant := range []string{norm.NFC.String(name), norm.NFD.String(name)} {
+		if variant != name && (len(variants) == 0 || variants[0] != variant) {
+			variants = append(variants, variant)
+		}
+	}
+	return variants
+}
+
+// Returns the HDFS name of the cached entry with the normalized name, which may be stored in
+// another form, or the name if there is none. Called with the directory locked
+func (dir *DirINode) storedName(name string) string {
+	if dir.EntriesGet(name) != nil {
+		return name
+	}
+	for _, variant := range nameVariants(name) {
+		if dir.EntriesGet(variant) != nil {
+			return variant
+		}
+	}
+	return name
+}
+
+// Looks up the entry with the normalized name, which was not found, by the other forms of the
+// name. Fails with ENOENT if there is none. Called with the directory locked
+func (dir *DirINode) lookupVariant(name string, attrs *Attrs) error {
+	for _, variant := range nameVariants(name) {
+		if err := dir.LookupAttrs(variant, attrs); err != syscall.ENOENT {
+			return err
+		}
+	}
+	return syscall.ENOENT
+}
@@ -0,0 +1,64 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	cafeNFC = "caf\u00e9"  // composed
+	cafeNFD = "cafe\u0301" // decomposed
+)
+
+// Testing that names created through the mount are normalized and that entries stored in another form are still found
+func TestUnicodeNormalization(t *testing.T) {
+	saveFlags(t, &unicodeNormalization)
+	unicodeNormalization = NormalizationNFC
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
+
+	// a Mac creates a directory
+	hdfsAccessor.EXPECT().Mkdir("/"+cafeNFC, os.FileMode(0755)|os.ModeDir).Return(nil)
+	hdfsAccessor.EXPECT().Chown("/"+cafeNFC, "root", "root").Return(nil)
+	_, err := root.(*DirINode).Mkdir(nil, &fuse.MkdirRequest{Name: cafeNFD, Mode: os.FileMode(0755) | os.ModeDir})
+	assert.Nil(t, err)
+	node, err := root.(*DirINode).Lookup(nil, cafeNFD)
+	assert.Nil(t, err)
+	assert.Equal(t, "/"+cafeNFC, node.(*DirINode).AbsolutePath())
+
+	// a Linux user looks up a file another client created decomposed
+	gomock.InOrder(
+		hdfsAccessor.EXPECT().Stat("/"+cafeNFC+".txt").Return(Attrs{}, syscall.ENOENT),
+		hdfsAccessor.EXPECT().Stat("/"+cafeNFD+".txt").Return(Attrs{Name: cafeNFD + ".txt", Mode: 0644}, nil),
+	)
+	node, err = root.(*DirINode).Lookup(nil, cafeNFC+".txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "/"+cafeNFD+".txt", node.(*FileINode).AbsolutePath())
+	hdfsAccessor.EXPECT().Remove("/" + cafeNFD + ".txt").Return(nil)
+	assert.Nil(t, root.(*DirINode).Remove(nil, &fuse.RemoveRequest{Name: cafeNFC + ".txt"}))
+
+	hdfsAccessor.EXPECT().Stat("/tea").Return(Attrs{}, syscall.ENOENT)
+	_, err = root.(*DirINode).Lookup(nil, "tea")
+	assert.Equal(t, syscall.ENOENT, err)
+}
+
+func TestNameVariants(t *testing.T) {
+	saveFlags(t, &unicodeNormalization)
+	assert.Nil(t, nameVariants(cafeNFC))
+	assert.Equal(t, cafeNFD, normalizeName(cafeNFD))
+	unicodeNormalization = NormalizationNFD
+	assert.Equal(t, cafeNFD, normalizeName(cafeNFC))
+	assert.Equal(t, []string{cafeNFC}, nameVariants(cafeNFD))
+	assert.Empty(t, nameVariants("tea"))
+}
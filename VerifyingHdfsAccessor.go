@@ -0,0 +1,276 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// With -verifyBackend, every read of file data is also done against a second namenode, e.g.,
+// the old HDFS cluster while migrating to HopsFS, and the data read is compared. The data of
+// the primary backend is served either way, mismatches and failures of the second backend are
+// logged and recorded as failed verify_read operations, so that the stats tell whether the
+// two clusters agree before cutting over. All other operations only go to the primary backend.
+// Reads take as long as they take on the slower backend
+var verifyBackend string
+
+type VerifyingHdfsAccessor struct {
+	Primary   HdfsAccessor // backend the data is served from
+	Secondary HdfsAccessor // backend the reads are compared with
+	Clock     Clock        // interface to get wall clock time
+}
+
+var _ HdfsAccessor = (*VerifyingHdfsAccessor)(nil) // ensure VerifyingHdfsAccessor implements HdfsAccessor
+
+// Creates an instance of VerifyingHdfsAccessor
+func NewVerifyingHdfsAccessor(primary HdfsAccessor, secondary HdfsAccessor, clock Clock) *VerifyingHdfsAccessor {
+	return &VerifyingHdfsAccessor{Primary: primary, Secondary: secondary, Clock: clock}
+}
+
+// Records the verification of bytes of a file, err is the mismatch or the failure of the second backend
+func (va *VerifyingHdfsAccessor) record(path string, start time.Time, bytes int64, err error) {
+	metrics.Record(VerifyReadOp, va.Clock.Now().Sub(start), bytes, 0, false, err)
+	if err != nil {
+		logwarn("Read verification failed, not verifying the rest of this read", Fields{Operation: VerifyReadOp, Path: path, Error: err})
+	}
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (va *VerifyingHdfsAccessor) EnsureConnected() error {
+	return va.Primary.EnsureConnected()
+}
+
+// Opens HDFS file for reading on both backends. The file is read from the primary one only if it cannot be opened on the second one
+func (va *VerifyingHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	reader, err := va.Primary.OpenRead(path)
+	if err != nil {
+		return nil, err
+	}
+	start := va.Clock.Now()
+	secondary, err := va.Secondary.OpenRead(path)
+	if err != nil {
+		va.record(path, start, 0, fmt.Errorf("failed to open on %s: %v", verifyBackend, err))
+		return reader, nil
+	}
+	return &verifyingReader{ReadSeekCloser: reader, secondary: secondary, path: path, accessor: va}, nil
+}
+
+// Opens HDFS file for writing
+func (va *VerifyingHdfsAccessor) CreateFile(path string, mode os.FileMode, overwrite bool) (HdfsWriter, error) {
+	return va.Primary.CreateFile(path, mode, overwrite)
+}
+
+// Opens HDFS file for appending
+func (va *VerifyingHdfsAccessor) Append(path string) (HdfsWriter, error) {
+	return va.Primary.Append(path)
+}
+
+// Enumerates HDFS directory
+func (va *VerifyingHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	return va.Primary.ReadDir(path)
+}
+
+// Retrieves file/directory attributes
+func (va *VerifyingHdfsAccessor) Stat(path string) (Attrs, error) {
+	return va.Primary.Stat(path)
+}
+
+// Retrieves HDFS usage
+func (va *VerifyingHdfsAccessor) StatFs() (FsInfo, error) {
+	return va.Primary.StatFs()
+}
+
+// Creates a directory
+func (va *VerifyingHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
+	return va.Primary.Mkdir(path, mode)
+}
+
+// Removes a file or directory
+func (va *VerifyingHdfsAccessor) Remove(path string) error {
+	return va.Primary.Remove(path)
+}
+
+// Removes a file or directory recursively
+func (va *VerifyingHdfsAccessor) RemoveAll(path string) error {
+	return va.Primary.RemoveAll(path)
+}
+
+// Renames a file or directory
+func (va *VerifyingHdfsAccessor) Rename(oldPath string, newPath string) error {
+	return va.Primary.Rename(oldPath, newPath)
+}
+
+// Changes the owner and group of the file
+func (va *VerifyingHdfsAccessor) Chown(path string, owner, group string) error {
+	return va.Primary.Chown(path, owner, group)
+}
+
+// Changes the mode of the file
+func (va *VerifyingHdfsAccessor) Chmod(path string, mode os.FileMode) error {
+	return va.Primary.Chmod(path, mode)
+}
+
+// Changes the modification time of the file
+func (va *VerifyingHdfsAccessor) Chtimes(path string, mtime time.Time) error {
+	return va.Primary.Chtimes(path, mtime)
+}
+
+// Retrieves the HDFS checksum of the file
+func (va *VerifyingHdfsAccessor) Checksum(path string) (FileChecksum, error) {
+	return va.Primary.Checksum(path)
+}
+
+// Retrieves the extended attributes of the file
+func (va *VerifyingHdfsAccessor) GetXAttrs(path string) (map[string]string, error) {
+	return va.Primary.GetXAttrs(path)
+}
+
+// Creates or replaces an extended attribute of the file
+func (va *VerifyingHdfsAccessor) SetXAttr(path, name, value string) error {
+	return va.Primary.SetXAttr(path, name, value)
+}
+
+// Retrieves the ACL entries of the file which are not in its mode
+func (va *VerifyingHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	return va.Primary.GetAcl(path)
+}
+
+// Replaces the access and default ACL of the file
+func (va *VerifyingHdfsAccessor) SetAcl(path string, entries []AclEntry) error {
+	return va.Primary.SetAcl(path, entries)
+}
+
+// Sets the storage policy of the file
+func (va *VerifyingHdfsAccessor) SetStoragePolicy(path, policy string) error {
+	return va.Primary.SetStoragePolicy(path, policy)
+}
+
+// Sets the erasure coding policy of the directory
+func (va *VerifyingHdfsAccessor) SetErasureCodingPolicy(path, policy string) error {
+	return va.Primary.SetErasureCodingPolicy(path, policy)
+}
+
+// Retrieves the totals of a directory tree
+func (va *VerifyingHdfsAccessor) GetContentSummary(path string) (ContentSummary, error) {
+	return va.Primary.GetContentSummary(path)
+}
+
+// Retrieves the configuration of the namenode
+func (va *VerifyingHdfsAccessor) ServerDefaults() (ServerDefaults, error) {
+	return va.Primary.ServerDefaults()
+}
+
+// Truncates the file
+func (va *VerifyingHdfsAccessor) Truncate(path string, size int64) (bool, error) {
+	return va.Primary.Truncate(path, size)
+}
+
+// Creates a snapshot of a snapshottable directory
+func (va *VerifyingHdfsAccessor) CreateSnapshot(path, name string) (string, error) {
+	return va.Primary.CreateSnapshot(path, name)
+}
+
+// Closes the connections to both backends
+func (va *VerifyingHdfsAccessor) Close() error {
+	va.Secondary.Close()
+	return va.Primary.Close()
+}
+
+// Reads from the primary backend and compares the data with the second one, until the first mismatch
+type verifyingReader struct {
+	ReadSeekCloser
+	secondary ReadSeekCloser // nil once a mismatch or failure was reported
+	path      string
+	accessor  *VerifyingHdfsAccessor
+}
+
+// Reads a chunk of data from the primary backend and the same bytes from the second one
+func (r *verifyingReader) Read(buffer []byte) (int, error) {
+	offset, posErr := r.ReadSeekCloser.Position()
+	n, err := r.ReadSeekCloser.Read(buffer)
+	if r.secondary == nil || posErr != nil || (err != nil && err != io.EOF) {
+		return n, err
+	}
+	start := r.accessor.Clock.Now()
+	if verifyErr := r.verify(offset, buffer[:n], err == io.EOF); verifyErr != nil {
+		r.accessor.record(r.path, start, int64(n), verifyErr)
+		r.secondary.Close()
+		r.secondary = nil
+	} else if n > 0 {
+		r.accessor.record(r.path, start, int64(n), nil)
+	}
+	return n, err
+}
+
+// Compares the data read at the offset with the data of the second backend, which must end there too if eof
+func (r *verifyingReader) verify(offset int64, data []byte, eof bool) error {
+	if pos, err := r.secondary.Position(); err != nil || pos != offset {
+		if err := r.secondary.Seek(offset); err != nil {
+			return fmt.Errorf("failed to seek to %d on %s: %v", offset, verifyBackend, err)
+		}
+	}
+	other := make([]byte, len(data), len(data)+1)
+	read := 0
+	for read < len(other) {
+		n, err := r.secondary.Read(other[read:])
+		read += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read at %d on %s: %v", offset+int64(read), verifyBackend, err)
+		}
+	}
+	if read < len(data) {
+		return fmt.Errorf("file ends at %d on %s, %d bytes earlier", offset+int64(read), verifyBackend, len(data)-read)
+	}
+	if !bytes.Equal(data, other) {
+		for i := range data {
+			if data[i] != other[i] {
+				return fmt.Errorf("data differs from %s at offset %d", verifyBackend, offset+int64(i))
+			}
+		}
+	}
+	if eof {
+		if n, _ := r.secondary.Read(other[:1]); n > 0 {
+			return fmt.Errorf("file is longer on %s than %d bytes", verifyBackend, offset+int64(len(data)))
+		}
+	}
+	return nil
+}
+
+// Seeks both backends
+func (r *verifyingReader) Seek(pos int64) error {
+	if err := r.ReadSeekCloser.Seek(pos); err != nil {
+		return err
+	}
+	if r.secondary != nil {
+		if err := r.secondary.Seek(pos); err != nil {
+			r.accessor.record(r.path, r.accessor.Clock.Now(), 0, fmt.Errorf("failed to seek to %d on %s: %v", pos, verifyBackend, err))
+			r.secondary.Close()
+			r.secondary = nil
+		}
+	}
+	return nil
+}
+
+// Closes the readers of both backends
+func (r *verifyingReader) Close() error {
+	if r.secondary != nil {
+		r.secondary.Close()
+	}
+	return r.ReadSeekCloser.Close()
+}
+
+// Returns the version of the file on the primary backend, for the caches keyed by version
+func (r *verifyingReader) Version() (FileVersion, error) {
+	if v, ok := r.ReadSeekCloser.(VersionedReader); ok {
+		return v.Version()
+	}
+	return FileVersion{}, errors.New("Version is not known")
+}
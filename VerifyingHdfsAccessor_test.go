@@ -0,0 +1,94 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that reads are served from the primary backend and compared with the second one
+func TestVerifyingHdfsAccessor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	primary := NewMockHdfsAccessor(mockCtrl)
+	secondary := NewMockHdfsAccessor(mockCtrl)
+	va := NewVerifyingHdfsAccessor(primary, secondary, &MockClock{})
+	primary.EXPECT().OpenRead(gomock.Any()).DoAndReturn(func(path string) (ReadSeekCloser, error) {
+		return &MockReadSeekCloserWithPseudoRandomContent{FileSize: 1000}, nil
+	}).AnyTimes()
+	readAll := func(reader ReadSeekCloser, offset int) int {
+		buffer := make([]byte, 300)
+		total := 0
+		for {
+			n, err := reader.Read(buffer)
+			for i := 0; i < n; i++ {
+				assert.Equal(t, generateByteAtOffset(int64(offset+total+i)), buffer[i])
+			}
+			total += n
+			if err == io.EOF {
+				return total
+			}
+			assert.Nil(t, err)
+		}
+	}
+
+	// the second backend returns the same data in other chunks
+	metrics.Snapshot(true)
+	secondary.EXPECT().OpenRead("/same").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 1000, Rand: rand.New(rand.NewSource(1))}, nil)
+	reader, err := va.OpenRead("/same")
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, readAll(reader, 0))
+	assert.Nil(t, reader.Seek(100))
+	assert.Equal(t, 900, readAll(reader, 100))
+	assert.Nil(t, reader.Close())
+	stats := metrics.Snapshot(true)[VerifyReadOp]
+	assert.Equal(t, uint64(0), stats.Errors)
+	assert.Equal(t, uint64(1900), stats.Bytes)
+
+	// a shorter file on the second backend is reported once, the data is still served
+	secondary.EXPECT().OpenRead("/truncated").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 700}, nil)
+	reader, err = va.OpenRead("/truncated")
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, readAll(reader, 0))
+	assert.Equal(t, uint64(1), metrics.Snapshot(true)[VerifyReadOp].Errors)
+
+	secondary.EXPECT().OpenRead("/longer").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 1001}, nil)
+	reader, err = va.OpenRead("/longer")
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, readAll(reader, 0))
+	assert.Equal(t, uint64(1), metrics.Snapshot(true)[VerifyReadOp].Errors)
+
+	// a file missing on the second backend is read from the primary one
+	secondary.EXPECT().OpenRead("/new").Return(nil, io.ErrUnexpectedEOF)
+	reader, err = va.OpenRead("/new")
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, readAll(reader, 0))
+	assert.Equal(t, uint64(1), metrics.Snapshot(true)[VerifyReadOp].Errors)
+}
+
+// Testing that the verifying reader reports the version of the file on the primary backend
+func TestVerifyingReaderVersion(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	primary := NewMockHdfsAccessor(mockCtrl)
+	secondary := NewMockHdfsAccessor(mockCtrl)
+	va := NewVerifyingHdfsAccessor(primary, secondary, &MockClock{})
+	primary.EXPECT().OpenRead("/versioned").Return(&versionedPseudoRandomReader{&MockReadSeekCloserWithPseudoRandomContent{FileSize: 100}}, nil)
+	secondary.EXPECT().OpenRead("/versioned").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 100}, nil)
+	reader, err := va.OpenRead("/versioned")
+	assert.Nil(t, err)
+	version, err := reader.(VersionedReader).Version()
+	assert.Nil(t, err)
+	assert.Equal(t, FileVersion{FileId: 7, Mtime: 1, Size: 100}, version)
+	assert.Nil(t, reader.Close())
+
+	primary.EXPECT().OpenRead("/unversioned").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 100}, nil)
+	secondary.EXPECT().OpenRead("/unversioned").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 100}, nil)
+	reader, err = va.OpenRead("/unversioned")
+	assert.Nil(t, err)
+	_, err = reader.(VersionedReader).Version()
+	assert.NotNil(t, err)
+}
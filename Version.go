@@ -2,6 +2,12 @@
 // Licensed under the MIT license. See LICENSE file in the project root for details.
 package main
 
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
 var (
 	//TODO: Add Version tag, manually update
 	VERSION = "1.3.3"
@@ -15,3 +21,41 @@ var (
 	// Built hostname overwritten automatically by build
 	HOSTNAME = "LOCALHOST"
 )
+
+// Extended attribute of the root of the mount with the build information, so that support can
+// tell which build a user runs with getfattr -n user.hopsfs.version <mount point>
+const versionXAttr = "user.hopsfs.version"
+
+// Module of the HDFS client, replaced by the HopsFS client in go.mod
+const hdfsClientModule = "github.com/colinmarc/hdfs/v2"
+
+// Identifies the build of the running binary
+type BuildInfo struct {
+	Version    string
+	GitCommit  string
+	BuildTime  string
+	GoVersion  string
+	HdfsClient string // module and version of the HDFS client the binary is built with
+}
+
+// Returns the build information of the running binary
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: VERSION, GitCommit: GITCOMMIT, BuildTime: BUILDTIME, GoVersion: runtime.Version(), HdfsClient: "unknown"}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range build.Deps {
+			if dep.Path != hdfsClientModule {
+				continue
+			}
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			info.HdfsClient = dep.Path + " " + dep.Version
+		}
+	}
+	return info
+}
+
+func (info BuildInfo) String() string {
+	return fmt.Sprintf("version=%s git_commit=%s build_time=%s go_version=%s hdfs_client=%q",
+		info.Version, info.GitCommit, info.BuildTime, info.GoVersion, info.HdfsClient)
+}
@@ -0,0 +1,318 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	cryptotls "crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+)
+
+// In split networks, the namenode is reachable from the client but the data ports of the
+// datanodes are firewalled, so every read failed once it tried the datanodes. With -webhdfsURL,
+// e.g., the URL of an HttpFS server or of a gateway proxying WebHDFS, a read which cannot
+// connect to any datanode of its block reads the rest of the block over HTTP with the OPEN
+// operation of WebHDFS instead, authenticated as the HDFS user of the mount and, with -tls,
+// with its client certificate. The datanodes are then considered unreachable for a minute, and
+// the following blocks are read over HTTP as well, at the speed of the HTTP server, before the
+// datanodes are tried again. Writes still go to the datanodes
+var webhdfsURL string
+
+// How long the datanodes are considered unreachable after a read failed to connect to them
+const datanodeRecheckInterval = time.Minute
+
+// Block size assumed for the files whose block size is not known
+const defaultHdfsBlockSize = 128 * 1024 * 1024
+
+// Reads files, manages ACLs, and sets storage and erasure coding policies, over HTTP with the WebHDFS REST API, see -webhdfsURL
+// Concurrency: thread safe
+type WebHdfs struct {
+	URL    string
+	Clock  Clock
+	client *http.Client
+
+	mutex            sync.Mutex
+	unreachableUntil time.Time // the reads go over HTTP until then
+}
+
+// Creates the WebHDFS client, with the client certificate of the TLS config if it is enabled
+func NewWebHdfs(address string, clock Clock, tlsConfig TLSConfig) (*WebHdfs, error) {
+	if _, err := url.Parse(address); err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{}
+	if tlsConfig.TLS {
+		ca, err := ioutil.ReadFile(tlsConfig.RootCABundle)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read root CA bundle: %v", err)
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(ca)
+		transport.TLSClientConfig = &cryptotls.Config{RootCAs: roots,
+			// loaded at each handshake, so that a renewed certificate is used right away
+			GetClientCertificate: func(*cryptotls.CertificateRequestInfo) (*cryptotls.Certificate, error) {
+				cert, err := cryptotls.LoadX509KeyPair(tlsConfig.ClientCertificate, tlsConfig.ClientKey)
+				return &cert, err
+			}}
+	}
+	return &WebHdfs{URL: strings.TrimSuffix(address, "/"), Clock: clock, client: &http.Client{Transport: transport}}, nil
+}
+
+// Returns true if the reads go over HTTP rather than to the datanodes
+func (webhdfs *WebHdfs) datanodesUnreachable() bool {
+	webhdfs.mutex.Lock()
+	defer webhdfs.mutex.Unlock()
+	return webhdfs.Clock.Now().Before(webhdfs.unreachableUntil)
+}
+
+// Reads over HTTP for datanodeRecheckInterval, called when a read failed to connect to the datanodes
+func (webhdfs *WebHdfs) markUnreachable(path string, err error) {
+	webhdfs.mutex.Lock()
+	defer webhdfs.mutex.Unlock()
+	if !webhdfs.Clock.Now().Before(webhdfs.unreachableUntil) {
+		logwarn(fmt.Sprintf("The datanodes are unreachable, reading over HTTP from %s for %v", webhdfs.URL, datanodeRecheckInterval),
+			Fields{Operation: Read, Path: path, Error: err})
+	}
+	webhdfs.unreachableUntil = webhdfs.Clock.Now().Add(datanodeRecheckInterval)
+}
+
+// Opens the range of the file with the OPEN operation
+func (webhdfs *WebHdfs) open(path, user string, offset, length int64) (io.ReadCloser, error) {
+	query := url.Values{"op": {"OPEN"}, "offset": {fmt.Sprint(offset)}, "length": {fmt.Sprint(length)}}
+	if user != "" {
+		query.Set("user.name", user)
+	}
+	resp, err := webhdfs.client.Get(webhdfs.URL + "/webhdfs/v1" + (&url.URL{Path: path}).EscapedPath() + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("WebHDFS OPEN %s returned %s", path, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Sets the storage policy of the path with the SETSTORAGEPOLICY operation, or unsets it with
+// UNSETSTORAGEPOLICY if policy is empty
+func (webhdfs *WebHdfs) setStoragePolicy(path, user, policy string) error {
+	if policy == "" {
+		return webhdfs.call(http.MethodPost, "UNSETSTORAGEPOLICY", path, user, nil, nil)
+	}
+	return webhdfs.call(http.MethodPut, "SETSTORAGEPOLICY", path, user, url.Values{"storagepolicy": {policy}}, nil)
+}
+
+// Sets the erasure coding policy of the directory with the SETECPOLICY operation, or unsets it
+// with UNSETECPOLICY if policy is empty
+func (webhdfs *WebHdfs) setErasureCodingPolicy(path, user, policy string) error {
+	if policy == "" {
+		return webhdfs.call(http.MethodPost, "UNSETECPOLICY", path, user, nil, nil)
+	}
+	return webhdfs.call(http.MethodPut, "SETECPOLICY", path, user, url.Values{"ecpolicy": {policy}}, nil)
+}
+
+// Retrieves the ACL entries of the path with the GETACLSTATUS operation
+func (webhdfs *WebHdfs) getAcl(path, user string) ([]AclEntry, error) {
+	var status struct {
+		AclStatus struct {
+			Entries []string `json:"entries"`
+		} `json:"AclStatus"`
+	}
+	if err := webhdfs.call(http.MethodGet, "GETACLSTATUS", path, user, nil, &status); err != nil {
+		return nil, err
+	}
+	entries := make([]AclEntry, 0, len(status.AclStatus.Entries))
+	for _, spec := range status.AclStatus.Entries {
+		e, err := parseAclSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Replaces the access and default ACL of the path with the SETACL operation
+func (webhdfs *WebHdfs) setAcl(path, user string, entries []AclEntry) error {
+	return webhdfs.call(http.MethodPut, "SETACL", path, user, url.Values{"aclspec": {formatAclSpec(entries)}}, nil)
+}
+
+// Runs the operation on the path, with the parameters of the query, and decodes the JSON
+// response into result if it is not nil
+func (webhdfs *WebHdfs) call(method, op, path, user string, query url.Values, result interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("op", op)
+	if user != "" {
+		query.Set("user.name", user)
+	}
+	req, err := http.NewRequest(method, webhdfs.URL+"/webhdfs/v1"+(&url.URL{Path: path}).EscapedPath()+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := webhdfs.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if result != nil {
+			return json.NewDecoder(resp.Body).Decode(result)
+		}
+		return nil
+	case http.StatusBadRequest:
+		// e.g., an unknown policy or an invalid ACL
+		return syscall.EINVAL
+	case http.StatusForbidden, http.StatusUnauthorized:
+		return syscall.EACCES
+	case http.StatusNotFound:
+		return syscall.ENOENT
+	}
+	return fmt.Errorf("WebHDFS %s %s returned %s", op, path, resp.Status)
+}
+
+// Returns true if the error is the failure to connect to the datanodes of a block
+func isDataTransferError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial"
+	}
+	return err != nil && err.Error() == "no available datanodes"
+}
+
+// Reads from the datanodes, and the blocks whose datanodes are unreachable over HTTP
+// Concurrency: not thread safe: at most on request at a time
+type WebHdfsFallbackReader struct {
+	Impl      ReadSeekCloser // reader of the datanodes
+	WebHdfs   *WebHdfs
+	Path      string
+	User      string
+	Size      int64
+	BlockSize int64
+
+	offset     int64
+	implOffset int64         // offset of Impl, which is sought to offset before it is read again
+	body       io.ReadCloser // HTTP response being read, up to the end of the block of bodyOffset
+	bodyOffset int64
+	bodyEnd    int64
+}
+
+var _ ReadSeekCloser = (*WebHdfsFallbackReader)(nil)
+var _ VersionedReader = (*WebHdfsFallbackReader)(nil)
+
+// Returns the reader of the file falling back to WebHDFS
+func newWebHdfsFallbackReader(impl *HdfsReader, webhdfs *WebHdfs, user string) *WebHdfsFallbackReader {
+	info := impl.BackendReader.Stat()
+	blockSize := int64(defaultHdfsBlockSize)
+	if status, ok := info.Sys().(*hdfs.FileStatus); ok && status.GetBlocksize() > 0 {
+		blockSize = int64(status.GetBlocksize())
+	}
+	return &WebHdfsFallbackReader{Impl: impl, WebHdfs: webhdfs, Path: impl.BackendReader.Name(), User: user,
+		Size: info.Size(), BlockSize: blockSize}
+}
+
+// Read a chunk of data
+func (r *WebHdfsFallbackReader) Read(buffer []byte) (int, error) {
+	if r.body == nil && !r.WebHdfs.datanodesUnreachable() {
+		if r.implOffset != r.offset {
+			if err := r.Impl.Seek(r.offset); err != nil {
+				return 0, err
+			}
+			r.implOffset = r.offset
+		}
+		n, err := r.Impl.Read(buffer)
+		r.offset += int64(n)
+		r.implOffset = r.offset
+		if n > 0 || !isDataTransferError(err) {
+			return n, err
+		}
+		r.WebHdfs.markUnreachable(r.Path, err)
+	}
+	return r.readHttp(buffer)
+}
+
+// Reads the chunk over HTTP, opening the rest of the block if needed
+func (r *WebHdfsFallbackReader) readHttp(buffer []byte) (int, error) {
+	if r.offset >= r.Size {
+		return 0, io.EOF
+	}
+	if r.body != nil && r.bodyOffset != r.offset {
+		r.closeBody()
+	}
+	if r.body == nil {
+		end := (r.offset/r.BlockSize + 1) * r.BlockSize
+		if end > r.Size {
+			end = r.Size
+		}
+		body, err := r.WebHdfs.open(r.Path, r.User, r.offset, end-r.offset)
+		if err != nil {
+			logwarn("Failed to read over HTTP", Fields{Operation: Read, Path: r.Path, Offset: r.offset, Error: err})
+			return 0, err
+		}
+		r.body, r.bodyOffset, r.bodyEnd = body, r.offset, end
+	}
+	if max := r.bodyEnd - r.bodyOffset; int64(len(buffer)) > max {
+		buffer = buffer[:max]
+	}
+	n, err := r.body.Read(buffer)
+	r.offset += int64(n)
+	r.bodyOffset = r.offset
+	metrics.Record(WebHdfsRead, 0, int64(n), 0, false, nil)
+	if err == io.EOF && r.bodyOffset < r.bodyEnd && n == 0 {
+		err = io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		err = nil
+	}
+	if err != nil || r.bodyOffset >= r.bodyEnd {
+		r.closeBody()
+	}
+	return n, err
+}
+
+// Closes the HTTP response being read
+func (r *WebHdfsFallbackReader) closeBody() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
+
+// Seeks to a given position
+func (r *WebHdfsFallbackReader) Seek(pos int64) error {
+	r.offset = pos
+	return nil
+}
+
+// Returns current position
+func (r *WebHdfsFallbackReader) Position() (int64, error) {
+	return r.offset, nil
+}
+
+// Returns the version of the file at the time it was opened
+func (r *WebHdfsFallbackReader) Version() (FileVersion, error) {
+	if v, ok := r.Impl.(VersionedReader); ok {
+		return v.Version()
+	}
+	return FileVersion{}, errors.New("No file version")
+}
+
+// Closes the stream
+func (r *WebHdfsFallbackReader) Close() error {
+	r.closeBody()
+	return r.Impl.Close()
+}
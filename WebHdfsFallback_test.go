@@ -0,0 +1,66 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that the blocks whose datanodes are unreachable are read over HTTP, and the datanodes tried again later
+func TestWebHdfsFallback(t *testing.T) {
+	content := "0123456789"
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/webhdfs/v1/data/part-0", r.URL.Path)
+		assert.Equal(t, "OPEN", r.URL.Query().Get("op"))
+		assert.Equal(t, "alice", r.URL.Query().Get("user.name"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		length, _ := strconv.Atoi(r.URL.Query().Get("length"))
+		ranges = append(ranges, content[offset:offset+length])
+		w.Write([]byte(content[offset : offset+length]))
+	}))
+	defer server.Close()
+
+	clock := &MockClock{}
+	webhdfs, err := NewWebHdfs(server.URL+"/", clock, TLSConfig{})
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	impl := NewMockReadSeekCloser(mockCtrl)
+	reader := &WebHdfsFallbackReader{Impl: impl, WebHdfs: webhdfs, Path: "/data/part-0", User: "alice", Size: 10, BlockSize: 4}
+
+	buffer := make([]byte, 3)
+	impl.EXPECT().Read(gomock.Any()).Return(0, &net.OpError{Op: "dial", Err: io.ErrClosedPipe})
+	n, err := reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "012", string(buffer[:n]))
+	n, err = reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "3", string(buffer[:n]), "the read stops at the end of the block")
+	n, err = reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "456", string(buffer[:n]), "the next block is read over HTTP without trying the datanodes")
+	assert.Equal(t, []string{"0123", "4567"}, ranges)
+
+	// the datanodes are tried again after a while, once the block being read over HTTP is read
+	clock.NotifyTimeElapsed(datanodeRecheckInterval)
+	n, err = reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "7", string(buffer[:n]))
+	impl.EXPECT().Seek(int64(8)).Return(nil)
+	impl.EXPECT().Read(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+		return copy(b, "89"), nil
+	})
+	n, err = reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "89", string(buffer[:n]))
+	impl.EXPECT().Close().Return(nil)
+	assert.Nil(t, reader.Close())
+}
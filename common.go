@@ -8,12 +8,11 @@ import (
 	"time"
 
 	"bazil.org/fuse"
-	"logicalclocks.com/hopsfs-mount/ugcache"
 )
 
-func ChmodOp(attrs *Attrs, fileSystem *FileSystem, path string, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+func ChmodOp(attrs *Attrs, hdfsAccessor HdfsAccessor, path string, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
 	loginfo("Setting attributes", Fields{Operation: Chmod, Path: path, Mode: req.Mode})
-	err := fileSystem.getDFSConnector().Chmod(path, req.Mode)
+	err := hdfsAccessor.Chmod(path, req.Mode)
 	if err != nil {
 		return err
 	} else {
@@ -23,7 +22,7 @@ func ChmodOp(attrs *Attrs, fileSystem *FileSystem, path string, req *fuse.Setatt
 	}
 }
 
-func SetAttrChownOp(attrs *Attrs, fileSystem *FileSystem, path string, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+func SetAttrChownOp(attrs *Attrs, hdfsAccessor HdfsAccessor, path string, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
 	var uid = attrs.Uid
 	var gid = attrs.Gid
 
@@ -35,31 +34,32 @@ func SetAttrChownOp(attrs *Attrs, fileSystem *FileSystem, path string, req *fuse
 		gid = req.Gid
 	}
 
-	return ChownOp(attrs, fileSystem, path, uid, gid)
+	return ChownOp(attrs, hdfsAccessor, path, uid, gid)
 }
 
-func ChownOp(attrs *Attrs, fileSystem *FileSystem, path string, uid uint32, gid uint32) error {
+func ChownOp(attrs *Attrs, hdfsAccessor HdfsAccessor, path string, uid uint32, gid uint32) error {
 	var userName = ""
 	var groupName = ""
 
-	userName = ugcache.LookupUserName(uid)
+	userName = idMapper.UserName(uid)
 	if userName == "" {
 		return fmt.Errorf(fmt.Sprintf("Setattr failed. Unable to find user information. Path %s", path))
 	}
 
-	groupName = ugcache.LookupGroupName(gid)
+	groupName = idMapper.GroupName(gid)
 	if groupName == "" {
 		return fmt.Errorf(fmt.Sprintf("Setattr failed. Unable to find group information. Path %s", path))
 	}
 
 	loginfo("Setting attributes", Fields{Operation: Chown, Path: path, UID: uid, User: userName, GID: gid, Group: groupName})
-	err := fileSystem.getDFSConnector().Chown(path, userName, groupName)
+	err := hdfsAccessor.Chown(path, userName, groupName)
 
 	if err != nil {
 		return err
 	} else {
 		attrs.Uid = uid
 		attrs.Gid = gid
+		attrs.Group = groupName
 		return nil
 	}
 }
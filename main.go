@@ -7,7 +7,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
 	"strings"
@@ -15,7 +14,6 @@ import (
 	"time"
 
 	"bazil.org/fuse"
-	"bazil.org/fuse/fs"
 	_ "bazil.org/fuse/fs/fstestutil"
 )
 
@@ -32,38 +30,183 @@ var readOnly *bool
 var tls *bool
 var connectors int
 var version *bool
+var adminSocket string
+var fastRecursiveDelete bool
+var recursiveOpsParallelism int
+var permissionChecks = PermissionChecksKernel
+var squashRoot bool
+var unmappedId uint
+var groupResolver string
+var groupMappingFile string
+var idMapping string
+var idMappingFile string
+var hopsworksGroupsURL string
+var hopsworksAPIKeyFile string
+var groupCacheTTL time.Duration
+var credentialRefreshMargin time.Duration
+var clockSkewTolerance time.Duration
+var credentialDrainTimeout = 10 * time.Minute
+var metricsLogInterval time.Duration
+var canaryDir string
+var capabilityProbeDir string
+var routingTable string
+var quotaWarningPercent float64
+var failedUploadsDir string
+var streamingWrites bool
+var maxReadahead uint
+var writebackCache = true
+var maxBackground uint
+var appendWrites = true
+var hideTemporaryDirs bool
+var snapshotName string
+var createSnapshot bool
+var hedgedReadPercentile float64
+var hedgedReadMinDeadline time.Duration
+var hedgedReadBudget float64
+var quotaCheckInterval = defaultQuotaCheckInterval
+var canaryInterval time.Duration
+var maxDirtyBytes int64
+var dirtyWaitTimeout time.Duration
+var stagingReapInterval time.Duration
+var skipUnchangedUploads bool
+var resumableUploadThreshold int64
+var resumableUploadPartSize int64
+var logStreamDirs string
+var logStreamInterval = 5 * time.Second
+var logStreamBytes int64 = 8 * 1024 * 1024
+var durability = DurabilityAlways
+var durabilityInterval time.Duration
+var blockCacheDir string
+var blockCacheSize int64
+var blockCacheBlockSize int64
+var blockCacheMemory int64
+var readaheadBlocks int
+var maxTransfers int
+var prefetchPaths string
+var mimeTypeXattr bool
+var maxComponentLength int
+var maxPathLength int
+var shortenLongNames bool
+var prefetchDepth int
+var batchUids string
+var footerCacheSize int64
+var footerCacheMinFileSize int64
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(cmd.Run(os.Args[2:]))
+		}
+	}
+	// without a sub command, the arguments are those of mount
+	os.Exit(runMount(os.Args[1:]))
+}
 
+// Entry point of the "mount" sub command. Serves the file system until it is unmounted
+func runMount(args []string) int {
 	retryPolicy := NewDefaultRetryPolicy(WallClock{})
-	parseArgsAndInitLogger(retryPolicy)
+	parseArgsAndInitLogger(retryPolicy, args)
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
-	hopsRpcAddress := flag.Arg(0)
+	namenodes, err := resolveNamenodeArg(flag.Arg(0))
+	if err != nil {
+		logfatal(fmt.Sprintf("Unable to resolve the namenodes of %s. Error: %v", flag.Arg(0), err), nil)
+	}
+	hopsRpcAddress := strings.Join(namenodes, ",")
 	mountPoint := flag.Arg(1)
-	createStagingDir()
 
 	allowedPrefixes := strings.Split(*allowedPrefixesString, ",")
 
-	tlsConfig := TLSConfig{
-		TLS:               *tls,
-		RootCABundle:      rootCABundle,
-		ClientCertificate: clientCertificate,
-		ClientKey:         clientKey,
+	tlsConfig := tlsConfigFromFlags()
+
+	if idMapper, err = NewIdMapper(idMapping); err != nil {
+		logfatal(fmt.Sprintf("Failed to create the id mapping. Error: %v", err), nil)
+	}
+
+	initKerberos()
+	if hedgedReadPercentile > 0 {
+		readHedger = NewReadHedger(hedgedReadPercentile, hedgedReadMinDeadline, hedgedReadBudget, WallClock{})
 	}
 
-	ftHdfsAccessors := make([]HdfsAccessor, connectors)
+	connections := make([]HdfsAccessor, connectors)
+	reconnecters := make([]Reconnecter, connectors)
 
 	for i := 0; i < connectors; i++ {
 		hdfsAccessor, err := NewHdfsAccessor(hopsRpcAddress, WallClock{}, tlsConfig)
 		if err != nil {
 			logfatal(fmt.Sprintf("Error/NewHopsFSAccessor: %v ", err), nil)
 		}
-		ftHdfsAccessors[i] = NewFaultTolerantHdfsAccessor(hdfsAccessor, retryPolicy)
+		reconnecters[i] = hdfsAccessor.(Reconnecter)
+		connections[i] = NewInstrumentedHdfsAccessor(hdfsAccessor, WallClock{})
+	}
+	loginfo(fmt.Sprintf("Create %d file system clients", len(connections)), nil)
+	// the retries of an operation are handed another connection by the pool
+	pool := NewConnectionPool(connections, WallClock{})
+	// Wrapping with FaultTolerantHdfsAccessor
+	ftHdfsAccessors := []HdfsAccessor{NewFaultTolerantHdfsAccessor(pool, retryPolicy)}
+
+	if routingTable != "" {
+		routes, err := parseRoutingTable(routingTable, tlsConfig)
+		if err != nil {
+			logfatal(fmt.Sprintf("Invalid routing table. Error: %v", err), nil)
+		}
+		for _, route := range routes {
+			if err := checkTLSConfig(route.TLS); err != nil {
+				logfatal(fmt.Sprintf("Invalid credentials for %s. Error: %v", route.Prefix, err), nil)
+			}
+			hdfsAccessor, err := NewHdfsAccessor(route.Address, WallClock{}, route.TLS)
+			if err != nil {
+				logfatal(fmt.Sprintf("Error/NewHopsFSAccessor for %s: %v ", route.Prefix, err), nil)
+			}
+			if (route.TLS.TLS && route.TLS.ClientCertificate == clientCertificate) || kerberosLogin != nil {
+				reconnecters = append(reconnecters, hdfsAccessor.(Reconnecter))
+			}
+			route.Accessor = NewFaultTolerantHdfsAccessor(NewInstrumentedHdfsAccessor(hdfsAccessor, WallClock{}), retryPolicy)
+			loginfo(fmt.Sprintf("Routing %s to %s%s", route.Prefix, route.Address, route.Target), nil)
+		}
+		for i := range ftHdfsAccessors {
+			ftHdfsAccessors[i] = NewRoutingHdfsAccessor(ftHdfsAccessors[i], routes)
+		}
+	}
+
+	if verifyBackend != "" {
+		hdfsAccessor, err := NewHdfsAccessor(verifyBackend, WallClock{}, tlsConfig)
+		if err != nil {
+			logfatal(fmt.Sprintf("Error/NewHopsFSAccessor for %s: %v ", verifyBackend, err), nil)
+		}
+		reconnecters = append(reconnecters, hdfsAccessor.(Reconnecter))
+		// a single attempt, the reads must not wait for a second backend that is down
+		noRetries := &RetryPolicy{Clock: WallClock{}, MaxAttempts: 1, TimeLimit: 10 * time.Second, MinDelay: time.Second, MaxDelay: time.Second, ExpBackoffBase: 1}
+		secondary := NewFaultTolerantHdfsAccessor(NewInstrumentedHdfsAccessor(hdfsAccessor, WallClock{}), noRetries)
+		for i := range ftHdfsAccessors {
+			ftHdfsAccessors[i] = NewVerifyingHdfsAccessor(ftHdfsAccessors[i], secondary, WallClock{})
+		}
+		loginfo(fmt.Sprintf("Verifying the reads against %s", verifyBackend), nil)
+	}
+
+	if snapshotName != "" || createSnapshot {
+		snapshotDir, err := snapshotSrcDir(ftHdfsAccessors[0], mntSrcDir, snapshotName, createSnapshot, time.Now())
+		if err != nil {
+			logfatal(fmt.Sprintf("Unable to mount the snapshot. Error: %v", err), nil)
+		}
+		mntSrcDir = snapshotDir
+		*readOnly = true
+	}
+
+	if overlayDir != "" {
+		for i := range ftHdfsAccessors {
+			ftHdfsAccessors[i] = NewOverlayHdfsAccessor(ftHdfsAccessors[i], overlayDir)
+		}
+		loginfo(fmt.Sprintf("Writes are kept in the overlay %s until committed", overlayDir), nil)
+	}
+
+	if packDirs != "" {
+		for i := range ftHdfsAccessors {
+			ftHdfsAccessors[i] = NewPackingHdfsAccessor(ftHdfsAccessors[i], WallClock{}, packDirs, packMaxBytes)
+		}
 	}
-	loginfo(fmt.Sprintf("Create %d file system clients", len(ftHdfsAccessors)), nil)
 
 	if strings.Compare(mntSrcDir, "/") != 0 {
 		err := checkSrcMountPath(ftHdfsAccessors[0])
@@ -72,17 +215,95 @@ func main() {
 		}
 	}
 
-	// Wrapping with FaultTolerantHdfsAccessor
-
 	if !*lazyMount && ftHdfsAccessors[0].EnsureConnected() != nil {
 		logfatal("Can't establish connection to HopsFS, mounting will NOT be performend (this can be suppressed with -lazy", nil)
 	}
 
+	capabilities := assumedCapabilities()
+	if !*lazyMount {
+		probeDir := capabilityProbeDir
+		if probeDir == "" {
+			probeDir = canaryDir
+		}
+		capabilities = probeCapabilities(ftHdfsAccessors[0], mntSrcDir, probeDir)
+		capabilities.disableUnsupported()
+		warnUnencryptedDataTransfer(tlsConfig, capabilities.Defaults)
+	}
+
+	// nothing is staged by a read-only mount
+	if !*readOnly {
+		createStagingDir()
+	}
+
 	// Creating the virtual file system
 	fileSystem, err := NewFileSystem(ftHdfsAccessors, mntSrcDir, allowedPrefixes, *readOnly, retryPolicy, WallClock{})
 	if err != nil {
 		logfatal(fmt.Sprintf("Error/NewFileSystem: %v ", err), nil)
 	}
+	fileSystem.Capabilities = capabilities
+	fileSystem.Pool = pool
+	if flushCoalesceWindow > 0 || asyncUploads > 0 {
+		// first, while the connections are still open
+		fileSystem.CloseOnUnmount(&DeferredFlushes{FileSystem: fileSystem})
+	}
+	if asyncUploads > 0 {
+		fileSystem.Uploader = NewAsyncUploader(asyncUploads)
+		fileSystem.CloseOnUnmount(fileSystem.Uploader)
+	}
+	for _, accessor := range ftHdfsAccessors {
+		if packer, ok := accessor.(*PackingHdfsAccessor); ok {
+			// after the uploads, which pack files
+			fileSystem.CloseOnUnmount(&PackIndexFlush{Accessor: packer})
+		}
+	}
+	if hookCommand != "" || hookURL != "" {
+		// after the uploads, whose flushes fire events
+		fileSystem.Hooks, err = NewHooks(hookCommand, hookURL, hookEvents, hookTimeout, WallClock{})
+		if err != nil {
+			logfatal(fmt.Sprintf("Invalid -hookEvents: %v", err), nil)
+		}
+		fileSystem.CloseOnUnmount(fileSystem.Hooks)
+	}
+
+	if impersonate {
+		fileSystem.UserConnectors = NewUserConnectors(func(user string) (HdfsAccessor, error) {
+			hdfsAccessor, err := NewHdfsAccessorAsUser(hopsRpcAddress, WallClock{}, tlsConfig, user)
+			if err != nil {
+				return nil, err
+			}
+			ftHdfsAccessor := NewFaultTolerantHdfsAccessor(NewInstrumentedHdfsAccessor(hdfsAccessor, WallClock{}), retryPolicy)
+			ftHdfsAccessor.User = user
+			return ftHdfsAccessor, nil
+		})
+		fileSystem.CloseOnUnmount(fileSystem.UserConnectors)
+		loginfo("The RPCs of the callers are issued as their HDFS users", nil)
+	}
+
+	if blockCacheDir != "" {
+		fileSystem.BlockCache, err = NewBlockCache(blockCacheDir, blockCacheSize, blockCacheBlockSize, blockCacheMemory)
+		if err != nil {
+			logfatal(fmt.Sprintf("Failed to create the block cache. Error: %v", err), nil)
+		}
+		fileSystem.CloseOnUnmount(fileSystem.BlockCache)
+	} else if readCacheMB > 0 {
+		fileSystem.BlockCache = NewMemoryBlockCache(blockCacheBlockSize, readCacheMB*1024*1024)
+	}
+	if birthTimesFile != "" {
+		if birthTimes, err = OpenBirthTimes(birthTimesFile); err != nil {
+			logfatal(fmt.Sprintf("Failed to open the birth times file. Error: %v", err), nil)
+		}
+		fileSystem.CloseOnUnmount(birthTimes)
+	}
+	if maxTransfers > 0 {
+		fileSystem.IOScheduler = NewIOScheduler(maxTransfers)
+	}
+
+	if clientPermissionChecks() {
+		fileSystem.GroupResolver, err = NewGroupResolver(groupResolver)
+		if err != nil {
+			logfatal(fmt.Sprintf("Failed to create the group resolver. Error: %v", err), nil)
+		}
+	}
 
 	mountOptions := getMountOptions(*readOnly)
 	c, err := fileSystem.Mount(mountPoint, mountOptions...)
@@ -91,6 +312,83 @@ func main() {
 	}
 	loginfo(fmt.Sprintf("Mounted successfully. HopsFS src dir: %s ", mntSrcDir), nil)
 
+	adminSocket = adminSocketFor(adminSocket, mountPoint)
+	adminServer, err := NewAdminServer(fileSystem, adminSocket)
+	if err != nil {
+		logerror(fmt.Sprintf("Failed to create admin socket %s. Admin commands are disabled. Error: %v", adminSocket, err), nil)
+	} else {
+		fileSystem.CloseOnUnmount(adminServer)
+		go adminServer.Serve()
+	}
+
+	if *tls && credentialRefreshMargin > 0 {
+		refresher, err := NewCredentialRefresher(clientCertificate, reconnecters, credentialRefreshMargin, WallClock{})
+		if err != nil {
+			logerror(fmt.Sprintf("Unable to watch the client certificate for renewal. Error: %v", err), nil)
+		} else {
+			fileSystem.CredentialRefresher = refresher
+			fileSystem.CloseOnUnmount(refresher)
+			go refresher.Run()
+		}
+	}
+	if kerberosLogin != nil && credentialRefreshMargin > 0 {
+		kerberosLogin.Reconnecters = reconnecters
+		fileSystem.CloseOnUnmount(kerberosLogin)
+		go kerberosLogin.Run()
+	}
+
+	for _, dir := range stagingDirs() {
+		if *readOnly {
+			break // the staging files of other mounts are left to them
+		}
+		stagingReaper := NewStagingReaper(dir, stagingReapInterval, WallClock{}, fileSystem.recoverStagingFile)
+		fileSystem.CloseOnUnmount(stagingReaper)
+		go stagingReaper.Run()
+	}
+
+	if durability == DurabilityInterval {
+		flusher := NewDurabilityFlusher(fileSystem, durabilityInterval)
+		fileSystem.CloseOnUnmount(flusher)
+		go flusher.Run()
+	}
+
+	if logStreamDirs != "" {
+		fileSystem.CloseOnUnmount(fileSystem.LogStreams)
+		go fileSystem.LogStreams.Run()
+	}
+
+	if capacityInterval > 0 {
+		monitor := NewCapacityMonitor(fileSystem, capacityInterval)
+		fileSystem.Capacity = monitor
+		fileSystem.CloseOnUnmount(monitor)
+		go monitor.Run()
+	}
+
+	if canaryDir != "" {
+		canary := NewCanaryMonitor(fileSystem, canaryDir, canaryInterval)
+		fileSystem.Canary = canary
+		fileSystem.CloseOnUnmount(canary)
+		go canary.Run()
+	}
+
+	if prefetchPaths != "" {
+		go fileSystem.prefetchAll()
+	}
+
+	if heatmapFile != "" {
+		fileSystem.Heatmap = NewAccessHeatmap(heatmapFile, heatmapInterval, WallClock{})
+		fileSystem.CloseOnUnmount(fileSystem.Heatmap)
+		go fileSystem.Heatmap.Run()
+	}
+	// last, once nothing else uses the connections
+	fileSystem.CloseOnUnmount(&PoolShutdown{Pool: pool})
+
+	if metricsLogInterval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go metrics.LogSummaries(metricsLogInterval, WallClock{}, done)
+	}
+
 	// Increase the maximum number of file descriptor from 1K to 1M in Linux
 	rLimit := syscall.Rlimit{
 		Cur: 1024 * 1024,
@@ -107,6 +405,7 @@ func main() {
 		loginfo("Closed...", nil)
 	}()
 
+	go fileSystem.dumpStatsOnSignal()
 	go func() {
 		for x := range sigs {
 			//Handling INT/TERM signals - trying to gracefully unmount and exit
@@ -118,7 +417,7 @@ func main() {
 			retryPolicy.MaxDelay = 0
 		}
 	}()
-	err = fs.Serve(c, fileSystem)
+	err = fileSystem.Serve(c)
 	if err != nil {
 		logfatal(fmt.Sprintf("Failed to serve FS. Error: %v", err), nil)
 	}
@@ -128,54 +427,294 @@ func main() {
 	if err := c.MountError; err != nil {
 		logfatal(fmt.Sprintf("Mount process had errors: %v", err), nil)
 	}
+	return 0
 }
 
 var Usage = func() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  %s [Options] Namenode:Port MountPoint\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s Command [Args]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  \nCommands:\n")
+	for _, name := range subcommandNames() {
+		fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", strings.TrimSpace(name+" "+subcommands[name].Usage), subcommands[name].Help)
+	}
 	fmt.Fprintf(os.Stderr, "  \nOptions:\n")
 	flag.PrintDefaults()
 }
 
-func parseArgsAndInitLogger(retryPolicy *RetryPolicy) {
-	lazyMount = flag.Bool("lazy", false, "Allows to mount HopsFS filesystem before HopsFS is available")
-	flag.DurationVar(&retryPolicy.TimeLimit, "retryTimeLimit", 5*time.Minute, "time limit for all retry attempts for failed operations")
-	flag.IntVar(&retryPolicy.MaxAttempts, "retryMaxAttempts", 10, "Maxumum retry attempts for failed operations")
-	flag.DurationVar(&retryPolicy.MinDelay, "retryMinDelay", 1*time.Second, "minimum delay between retries (note, first retry always happens immediatelly)")
-	flag.DurationVar(&retryPolicy.MaxDelay, "retryMaxDelay", 60*time.Second, "maximum delay between retries")
-	allowedPrefixesString = flag.String("allowedPrefixes", "*", "Comma-separated list of allowed path prefixes on the remote file system, if specified the mount point will expose access to those prefixes only")
-	readOnly = flag.Bool("readOnly", false, "Enables mount with readonly")
-	flag.StringVar(&logLevel, "logLevel", "error", "logs to be printed. error, warn, info, debug, trace")
-	flag.StringVar(&stagingDir, "stageDir", "/tmp", "stage directory for writing files")
-	tls = flag.Bool("tls", false, "Enables tls connections")
-	flag.StringVar(&rootCABundle, "rootCABundle", "/srv/hops/super_crypto/hdfs/hops_root_ca.pem", "Root CA bundle location ")
-	flag.StringVar(&clientCertificate, "clientCertificate", "/srv/hops/super_crypto/hdfs/hdfs_certificate_bundle.pem", "Client certificate location")
-	flag.StringVar(&clientKey, "clientKey", "/srv/hops/super_crypto/hdfs/hdfs_priv.pem", "Client key location")
-	flag.StringVar(&mntSrcDir, "srcDir", "/", "HopsFS src directory")
-	flag.StringVar(&logFile, "logFile", "", "Log file path. By default the log is written to console")
-	flag.IntVar(&connectors, "numConnections", 1, "Number of connections with the namenode")
-	version = flag.Bool("version", false, "Print version")
-
-	flag.Usage = Usage
-	flag.Parse()
+// Parses the arguments of mount and initializes the logger
+func parseArgsAndInitLogger(retryPolicy *RetryPolicy, args []string) {
+	parseFlags(retryPolicy, args, Usage, 2, 2)
+	loginfo(fmt.Sprintf("Staging dir is:%s, Using TLS: %v, RetryAttempts: %d,  LogFile: %s", stagingDir, *tls, retryPolicy.MaxAttempts, logFile), nil)
+	loginfo(fmt.Sprintf("hopsfs-mount: current head GITCommit: %s Built time: %s Built by: %s ", GITCOMMIT, BUILDTIME, HOSTNAME), Fields{Operation: BuildInfoOp, Message: buildInfo().String()})
+}
+
+// Parses the options shared by the sub commands which connect to HopsFS followed by
+// minArgs to maxArgs arguments, validates them and initializes the logger
+func parseFlags(retryPolicy *RetryPolicy, args []string, usage func(), minArgs, maxArgs int) {
+	registerFlags(flag.CommandLine, retryPolicy)
+	flag.Usage = usage
+	flag.CommandLine.Parse(args)
+	if err := applyConfig(flag.CommandLine); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration. %v\n", err)
+		os.Exit(2)
+	}
+	if err := applyProfile(flag.CommandLine); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -profile. %v\n", err)
+		os.Exit(2)
+	}
+
+	applyRetryFlags(retryPolicy)
 
 	if *version {
 		fmt.Println(VERSION)
 		os.Exit(0)
 	}
 
-	if flag.NArg() != 2 {
-		Usage()
+	if flag.NArg() < minArgs || flag.NArg() > maxArgs {
+		usage()
+		os.Exit(2)
+	}
+
+	if permissionChecks != PermissionChecksKernel && permissionChecks != PermissionChecksClient && permissionChecks != PermissionChecksBackend {
+		fmt.Fprintf(os.Stderr, "Invalid -permissionChecks %q. Expected %s, %s or %s\n", permissionChecks, PermissionChecksKernel, PermissionChecksClient, PermissionChecksBackend)
+		os.Exit(2)
+	}
+	if squashRoot && permissionChecks != PermissionChecksClient {
+		fmt.Fprintf(os.Stderr, "-squashRoot requires -permissionChecks=%s, the kernel always lets root through\n", PermissionChecksClient)
+		os.Exit(2)
+	}
+
+	if durability != DurabilityNone && durability != DurabilityInterval && durability != DurabilityAlways {
+		fmt.Fprintf(os.Stderr, "Invalid -durability %q. Expected %s, %s or %s\n", durability, DurabilityNone, DurabilityInterval, DurabilityAlways)
+		os.Exit(2)
+	}
+
+	if unicodeNormalization != NormalizationNone && unicodeNormalization != NormalizationNFC && unicodeNormalization != NormalizationNFD {
+		fmt.Fprintf(os.Stderr, "Invalid -unicodeNormalization %q. Expected %s, %s or %s\n", unicodeNormalization, NormalizationNone, NormalizationNFC, NormalizationNFD)
+		os.Exit(2)
+	}
+
+	if crcFiles != CrcFilesKeep && crcFiles != CrcFilesHide && crcFiles != CrcFilesSynthesize {
+		fmt.Fprintf(os.Stderr, "Invalid -crcFiles %q. Expected %s, %s or %s\n", crcFiles, CrcFilesKeep, CrcFilesHide, CrcFilesSynthesize)
+		os.Exit(2)
+	}
+
+	if !validDataTransferProtection(dataTransferProtection) {
+		fmt.Fprintf(os.Stderr, "Invalid -dataTransferProtection %q. Expected %s, %s or %s\n", dataTransferProtection, DataTransferAuthentication, DataTransferIntegrity, DataTransferPrivacy)
+		os.Exit(2)
+	}
+	if err := checkTLSConfig(tlsConfigFromFlags()); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -tls credentials: %v\n", err)
+		os.Exit(2)
+	}
+
+	if kerberosKeytab != "" && kerberosPrincipal == "" {
+		fmt.Fprintf(os.Stderr, "-kerberosKeytab needs -kerberosPrincipal, the principal to log in as\n")
 		os.Exit(2)
 	}
+	if kerberosKeytab != "" || kerberosCCache != "" {
+		kerberos = true
+	}
 
+	if impersonate {
+		// the connections of the callers are opened as given users, sharing nothing with the other namenodes and the overlay
+		conflicts := []struct {
+			option string
+			set    bool
+		}{{"-kerberos", kerberos}, {"-routingTable", routingTable != ""}, {"-verifyBackend", verifyBackend != ""}, {"-overlayDir", overlayDir != ""}, {"-packDirs", packDirs != ""}}
+		for _, conflict := range conflicts {
+			if conflict.set {
+				fmt.Fprintf(os.Stderr, "-impersonate cannot be combined with %s\n", conflict.option)
+				os.Exit(2)
+			}
+		}
+	}
+
+	if createSnapshot && *lazyMount {
+		fmt.Fprintf(os.Stderr, "-createSnapshot needs HopsFS to be available when mounting, it cannot be combined with -lazy\n")
+		os.Exit(2)
+	}
+
+	if hedgedReadPercentile < 0 || hedgedReadPercentile >= 100 {
+		fmt.Fprintf(os.Stderr, "Invalid -hedgedReadPercentile %v. Expected a percentile below 100, or 0 to disable hedged reads\n", hedgedReadPercentile)
+		os.Exit(2)
+	}
+
+	if stagingFullPolicy != StagingFullBlock && stagingFullPolicy != StagingFullENOSPC {
+		fmt.Fprintf(os.Stderr, "Invalid -stagingFullPolicy %q. Expected %s or %s\n", stagingFullPolicy, StagingFullBlock, StagingFullENOSPC)
+		os.Exit(2)
+	}
+
+	if recoverStaging != RecoverStagingNone && recoverStaging != RecoverStagingUpload && recoverStaging != RecoverStagingQuarantine {
+		fmt.Fprintf(os.Stderr, "Invalid -recoverStaging %q. Expected %s, %s or %s\n", recoverStaging, RecoverStagingNone, RecoverStagingUpload, RecoverStagingQuarantine)
+		os.Exit(2)
+	}
+
+	if recoverStaging == RecoverStagingQuarantine && failedUploadsDir == "" {
+		fmt.Fprintf(os.Stderr, "-recoverStaging %s moves the staging files to -failedUploadsDir, which is not set\n", RecoverStagingQuarantine)
+		os.Exit(2)
+	}
+
+	if err := checkLogFormat(logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 	if err := checkLogFileCreation(); err != nil {
-		log.Fatalf("Error creating log file. Error: %v", err)
+		logfatal("Error creating log file", Fields{Path: logFile, Error: err})
 	}
+	setDebugPaths(debugPaths)
 	initLogger(logLevel, false, logFile)
+}
 
-	loginfo(fmt.Sprintf("Staging dir is:%s, Using TLS: %v, RetryAttempts: %d,  LogFile: %s", stagingDir, *tls, retryPolicy.MaxAttempts, logFile), nil)
-	loginfo(fmt.Sprintf("hopsfs-mount: current head GITCommit: %s Built time: %s Built by: %s ", GITCOMMIT, BUILDTIME, HOSTNAME), nil)
+// Registers the options of mount, also used by the other sub commands connecting to HopsFS
+func registerFlags(flags *flag.FlagSet, retryPolicy *RetryPolicy) {
+	lazyMount = flags.Bool("lazy", false, "Allows to mount HopsFS filesystem before HopsFS is available")
+	registerRetryFlags(flags, retryPolicy)
+	allowedPrefixesString = flags.String("allowedPrefixes", "*", "Comma-separated list of allowed path prefixes on the remote file system, if specified the mount point will expose access to those prefixes only")
+	readOnly = flags.Bool("readOnly", false, "Mounts read-only: creates, writes, removes, renames and attribute changes fail with EROFS, and no staging dir is created")
+	flags.StringVar(&logLevel, "logLevel", "error", "logs to be printed. error, warn, info, debug, trace")
+	flags.StringVar(&debugPaths, "debugPaths", "", "Comma separated globs of HDFS paths whose messages are logged down to the trace level whatever the -logLevel, e.g., /Projects/x/**")
+	flags.StringVar(&stagingDir, "stageDir", "/tmp", "stage directory for writing files. A comma separated list spreads the staging files across the directories, e.g., one per local disk")
+	tls = flags.Bool("tls", false, "Enables tls connections")
+	flags.StringVar(&rootCABundle, "rootCABundle", "/srv/hops/super_crypto/hdfs/hops_root_ca.pem", "Root CA bundle location ")
+	flags.StringVar(&clientCertificate, "clientCertificate", "/srv/hops/super_crypto/hdfs/hdfs_certificate_bundle.pem", "Client certificate location")
+	flags.StringVar(&clientKey, "clientKey", "/srv/hops/super_crypto/hdfs/hdfs_priv.pem", "Client key location")
+	flags.BoolVar(&preserveMetadata, "preserveMetadata", false, "Restores the user.* extended attributes and the ACL of a file after rewriting it, e.g., when uploading a modified file")
+	flags.StringVar(&dataTransferProtection, "dataTransferProtection", "", "Protection of the block data exchanged with the datanodes: authentication, integrity or privacy, which encrypts it. The namenode's dfs.encrypt.data.transfer if empty")
+	flags.BoolVar(&kerberos, "kerberos", false, "Authenticates the connections with the namenodes with Kerberos, with -kerberosKeytab or the ticket cache")
+	flags.StringVar(&kerberosPrincipal, "kerberosPrincipal", "", "Principal logged in as with -kerberosKeytab, user@REALM")
+	flags.StringVar(&kerberosKeytab, "kerberosKeytab", "", "Keytab of -kerberosPrincipal. The ticket cache is used if empty")
+	flags.StringVar(&kerberosCCache, "kerberosCCache", "", "Kerberos ticket cache, maintained by kinit, k5start or sssd. By default $KRB5CCNAME or /tmp/krb5cc_<uid>")
+	flags.StringVar(&krb5Conf, "krb5Conf", defaultKrb5Conf(), "Kerberos configuration, with the realm and the KDCs")
+	flags.StringVar(&kerberosServicePrincipal, "kerberosServicePrincipal", "nn/_HOST", "Service principal of the namenodes, dfs.namenode.kerberos.principal without the realm. _HOST is replaced by the namenode host")
+	flags.DurationVar(&kerberosRelogin, "kerberosRelogin", time.Hour, "How often the principal logs in again with -kerberosKeytab. Never if 0, the TGT is then only renewed")
+	flags.StringVar(&mntSrcDir, "srcDir", "/", "HopsFS src directory")
+	flags.StringVar(&logFile, "logFile", "", "Log file path. By default the log is written to console")
+	flags.StringVar(&logFormat, "logFormat", LogFormatText, "Format of the log messages: text, or json for one JSON object per line")
+	flags.IntVar(&logMaxSize, "logMaxSize", 100, "Size in megabytes at which -logFile is rotated")
+	flags.IntVar(&logMaxBackups, "logMaxBackups", 10, "Number of rotated log files kept, 0 keeps them all")
+	flags.IntVar(&logMaxAge, "logMaxAge", 30, "Days the rotated log files are kept, 0 keeps them regardless of their age")
+	flags.BoolVar(&logCompress, "logCompress", false, "Compresses the rotated log files with gzip")
+	flags.DurationVar(&logRotateInterval, "logRotateInterval", 0, "Interval at which -logFile is rotated whatever its size, e.g., 24h. Disabled if 0")
+	flags.IntVar(&connectors, "numConnections", 1, "Number of connections with the namenode")
+	flags.DurationVar(&connectionRecheckInterval, "connectionRecheckInterval", 10*time.Second, "How long a connection whose operation failed is only used if no other connection is healthy")
+	flags.DurationVar(&namenodeFailureBackoff, "namenodeFailureBackoff", time.Minute, "How long a namenode which was unreachable or standby is only connected to after the other namenodes")
+	version = flags.Bool("version", false, "Print version")
+	flags.StringVar(&adminSocket, "adminSocket", "", "Unix socket for admin commands. By default it is derived from the mount point")
+	flags.DurationVar(&freezeTimeout, "freezeTimeout", 10*time.Minute, "Thaws a mount frozen by the freeze admin command after this long. Never if 0")
+	flags.IntVar(&recursiveOpsParallelism, "recursiveOpsParallelism", 8, "Maximum number of concurrent RPCs issued by the 'chmodr' and 'chownr' admin commands")
+	flags.StringVar(&permissionChecks, "permissionChecks", PermissionChecksKernel, "Where permissions are checked. kernel: by the kernel using the local uid/gid of the entries, client: by hopsfs-mount using the HDFS groups of the caller, backend: only by HDFS, as the HDFS user of the mount")
+	flags.BoolVar(&squashRoot, "squashRoot", false, "Checks the permissions of root like those of any other user. Requires -permissionChecks=client")
+	flags.UintVar(&unmappedId, "unmappedId", 0, "uid and gid of the entries whose HDFS owner or group has no local account, e.g., 65534 for nobody")
+	flags.StringVar(&groupResolver, "groupResolver", GroupResolverNSS, "Resolves the HDFS groups of the caller for -permissionChecks=client. nss: local groups of the calling process, file: -groupMappingFile, idMapping: groups of the user from -idMapping")
+	flags.StringVar(&groupMappingFile, "groupMappingFile", "", "File with lines of the form 'user: group1, group2' mapping local users to HDFS groups")
+	flags.StringVar(&idMapping, "idMapping", IdMappingNSS, "Maps HDFS owners and groups to local uids and gids. nss: local accounts of the same name, file: -idMappingFile, then nss, numeric: owners and groups which are numbers are the ids, then nss, url: -idMappingURL, then nss, ldap: -ldapURL, then nss, hopsworks: groups from -hopsworksGroupsURL, ids from nss")
+	flags.StringVar(&idMappingFile, "idMappingFile", "", "File with lines of the form 'user <name> <uid>' and 'group <name> <gid>' mapping HDFS owners and groups to local ids")
+	flags.StringVar(&idMappingURL, "idMappingURL", "", "URL of a JSON object listing the uids and gids of the users and groups, for -idMapping=url")
+	flags.DurationVar(&idCacheTTL, "idCacheTTL", 5*time.Minute, "How long the ids fetched by -idMapping=url or ldap are used before they are fetched again")
+	flags.StringVar(&ldapURL, "ldapURL", "", "ldap:// or ldaps:// URL of the directory of -idMapping=ldap")
+	flags.StringVar(&ldapBaseDN, "ldapBaseDN", "", "DN under which -idMapping=ldap searches the posixAccount and posixGroup entries")
+	flags.StringVar(&ldapBindDN, "ldapBindDN", "", "DN -idMapping=ldap binds as, anonymous if empty")
+	flags.StringVar(&ldapBindPasswordFile, "ldapBindPasswordFile", "", "File containing the password of -ldapBindDN")
+	flags.StringVar(&hopsworksGroupsURL, "hopsworksGroupsURL", "", "Hopsworks REST endpoint returning the HDFS groups of a user as a JSON array, for -idMapping=hopsworks. {user} is replaced with the user name")
+	flags.StringVar(&hopsworksAPIKeyFile, "hopsworksAPIKeyFile", "", "File containing the Hopsworks API key used by -idMapping=hopsworks")
+	flags.DurationVar(&groupCacheTTL, "groupCacheTTL", time.Minute, "How long the resolved groups of a caller are cached")
+	flags.DurationVar(&credentialRefreshMargin, "credentialRefreshMargin", 30*time.Minute, "With -tls, the client certificate is watched and the connections are renewed as soon as a renewed certificate is found, with -kerberos the ticket cache. Warns if the certificate or ticket in use expires within this time. 0 disables watching")
+	flags.DurationVar(&clockSkewTolerance, "clockSkewTolerance", 2*time.Second, "Maximum expected difference between the clock of this host and the clocks of the namenode and the certificate authority. Times set by them are compared with local times with this tolerance")
+	flags.DurationVar(&credentialDrainTimeout, "credentialDrainTimeout", 10*time.Minute, "Time given to open readers and writers to finish with a replaced connection before it is closed")
+	flags.StringVar(&heatmapFile, "heatmapFile", "", "File to which the reads of each HDFS directory are written, as CSV if it ends with .csv, JSON otherwise. Disabled if empty")
+	flags.DurationVar(&heatmapInterval, "heatmapInterval", 10*time.Minute, "Interval at which -heatmapFile is written, also written when unmounting. Only when unmounting if 0")
+	flags.StringVar(&hookCommand, "hookCommand", "", "Command run through sh -c after files are created, flushed or deleted, with the event as JSON on stdin. Disabled if empty")
+	flags.StringVar(&hookURL, "hookURL", "", "URL to which the events of files created, flushed or deleted are POSTed as JSON. Disabled if empty")
+	flags.StringVar(&hookEvents, "hookEvents", "create,flush,delete", "Comma separated events of -hookCommand and -hookURL")
+	flags.DurationVar(&hookTimeout, "hookTimeout", 10*time.Second, "Maximum time of a run of -hookCommand or a call to -hookURL")
+	flags.DurationVar(&metricsLogInterval, "metricsLogInterval", 0, "If set, a summary of the operations completed in the last interval is logged at info level. Each operation is logged at debug level")
+	flags.StringVar(&canaryDir, "canaryDir", "", "HDFS directory where a canary file is periodically written, read back and deleted to check the health of the mount. Disabled if empty")
+	flags.StringVar(&verifyBackend, "verifyBackend", "", "Namenode, as namenode:port, against which every read is repeated and compared, e.g., while migrating between clusters. The data of the first namenode is served. Disabled if empty")
+	flags.StringVar(&routingTable, "routingTable", "", "File mapping path prefixes of the mount to other namenodes, one '<prefix> <namenode:port>[/target] [tls=..] [rootCABundle=..] [clientCertificate=..] [clientKey=..] [dataTransferProtection=..]' line per prefix")
+	flags.Float64Var(&hedgedReadPercentile, "hedgedReadPercentile", 0, "Hedges reads taking longer than this percentile of recent reads with a read of a second stream, e.g., 95. Disabled if 0")
+	flags.DurationVar(&hedgedReadMinDeadline, "hedgedReadMinDeadline", 10*time.Millisecond, "Reads are not hedged before this time")
+	flags.Float64Var(&hedgedReadBudget, "hedgedReadBudget", 5, "Maximum percentage of the reads which are hedged")
+	flags.StringVar(&snapshotName, "snapshot", "", "Mounts the HDFS snapshot of -srcDir of this name read-only")
+	flags.BoolVar(&createSnapshot, "createSnapshot", false, "Creates a snapshot of -srcDir when mounting and mounts it read-only, named after -snapshot or the time of the mount")
+	flags.BoolVar(&hideTemporaryDirs, "hideTemporaryDirs", false, "Omits the _temporary directories of Hadoop output committers from listings")
+	flags.StringVar(&crcFiles, "crcFiles", CrcFilesKeep, "Handling of the .<name>.crc sidecars of Hadoop's LocalFileSystem: keep, hide them from listings, or synthesize them for the files which have none or a stale one")
+	flags.StringVar(&protectedPaths, "protectedPaths", "", "Comma separated globs of HDFS paths which cannot be removed or renamed through the mount, e.g., /warehouse/**,*.model")
+	flags.StringVar(&overlayDir, "overlayDir", "", "Local directory where all changes made through the mount are kept, HopsFS is only read, until they are pushed with the commit admin command")
+	flags.StringVar(&packDirs, "packDirs", "", "Comma separated globs of HDFS directories whose small files written through the mount are packed into one HDFS file per directory")
+	flags.Int64Var(&packMaxBytes, "packMaxBytes", 64*1024, "Files of -packDirs up to this size are packed")
+	flags.BoolVar(&deltaUploads, "deltaUploads", false, "Flushes only append the data written past the end of the file in HDFS, and truncate files cut shorter, instead of uploading the whole file")
+	flags.BoolVar(&appendWrites, "appendWrites", true, "Data written to files opened with O_APPEND is appended to HDFS with the append RPC instead of rewriting the file on close")
+	flags.BoolVar(&streamingWrites, "streamingWrites", false, "New files written sequentially are streamed to HDFS without a staging file. Files written out of order fall back to a staging file")
+	flags.StringVar(&recoverStaging, "recoverStaging", RecoverStagingNone, "What a restarted mount does with the staging files a crashed mount left with data which is not in HopsFS: none, upload or quarantine in -failedUploadsDir")
+	flags.StringVar(&failedUploadsDir, "failedUploadsDir", "", "Local directory where the staging files of flushes failing after all retries are kept for the replay-failed admin command")
+	flags.Float64Var(&quotaWarningPercent, "quotaWarningPercent", 0, "Logs a warning when a write brings the closest directory with an HDFS quota to this percentage of it. Disabled if 0")
+	flags.BoolVar(&statfsQuota, "statfsQuota", false, "statfs reports the space and name quotas of the source dir, or of the closest directory above it with a quota, instead of the capacity of the cluster")
+	flags.DurationVar(&quotaCheckInterval, "quotaCheckInterval", defaultQuotaCheckInterval, "Minimum time between quota checks of a directory after writes")
+	flags.StringVar(&capabilityProbeDir, "capabilityProbeDir", "", "HDFS directory where a file is created and appended to at mount time to check that the backend supports append. -canaryDir if empty. Append is assumed if both are empty")
+	flags.DurationVar(&canaryInterval, "canaryInterval", time.Minute, "Time between canary probes")
+	flags.DurationVar(&capacityInterval, "capacityInterval", 0, "How often the usage of HDFS and of the quotas of the source dir are polled, statfs is answered from the last poll. Disabled if 0")
+	flags.Float64Var(&capacityWarningPercent, "capacityWarningPercent", 90, "Alerts when the cluster or a quota of the source dir is this percentage used, with -capacityInterval")
+	flags.StringVar(&capacityWebhook, "capacityWebhook", "", "URL the capacity alerts are posted to as JSON. Disabled if empty")
+	flags.Int64Var(&maxDirtyBytes, "maxDirtyBytes", 0, "Limit of the data written to staging files which is not uploaded yet. Writes slow down above half of the limit, and at the limit upload the file written so far and block. 0 means unlimited")
+	flags.DurationVar(&dirtyWaitTimeout, "dirtyWaitTimeout", time.Minute, "How long a write blocks at -maxDirtyBytes or -maxStagingBytes before failing with ENOSPC")
+	flags.Int64Var(&maxStagingBytes, "maxStagingBytes", 0, "Limit of the size of the staging files. Staging files of open files which are in HopsFS are evicted first. 0 means unlimited")
+	flags.BoolVar(&stagingPerUser, "stagingPerUser", false, "Keeps the staging files of each user in a uid-<uid> subdirectory of -stageDir, owned by the user like its staging files")
+	flags.Int64Var(&maxStagingBytesPerUser, "maxStagingBytesPerUser", 0, "Limit of the size of the staging files of each user. Writes past it fail with EDQUOT once the clean staging files of the user are evicted. 0 means unlimited")
+	flags.StringVar(&stagingFullPolicy, "stagingFullPolicy", StagingFullBlock, "What a write does at -maxStagingBytes once nothing can be evicted: block, waiting for files to be closed or uploaded, or enospc")
+	flags.DurationVar(&stagingReapInterval, "stagingReapInterval", 10*time.Minute, "How often staging files left behind by crashed processes are removed from the stage directory")
+	flags.BoolVar(&skipUnchangedUploads, "skipUnchangedUploads", false, "Skips the upload of a file rewritten with the content it already has in HDFS, comparing the HDFS checksum. Only the modification time is updated")
+	flags.Int64Var(&resumableUploadThreshold, "resumableUploadThreshold", 0, "Files of at least this size are uploaded in parts, so that an interrupted upload is resumed, also by a restarted mount. 0 disables resumable uploads")
+	flags.Int64Var(&resumableUploadPartSize, "resumableUploadPartSize", 1024*1024*1024, "Size of the parts of resumable uploads. Progress is recorded after every part")
+	flags.StringVar(&logStreamDirs, "logStreamDirs", "", "Comma separated list of HDFS directories whose files are appended to HDFS while they are written, e.g., logs, instead of being uploaded on close")
+	flags.DurationVar(&logStreamInterval, "logStreamInterval", 5*time.Second, "How often data written to files under -logStreamDirs is appended to HDFS")
+	flags.Int64Var(&logStreamBytes, "logStreamBytes", 8*1024*1024, "Data written to a file under -logStreamDirs is appended to HDFS as soon as this much is pending")
+	flags.StringVar(&durability, "durability", DurabilityAlways, "When written data is uploaded to HDFS. none: on close, interval: on close and every -durabilityInterval, always: on close and on every fsync")
+	flags.DurationVar(&durabilityInterval, "durabilityInterval", 30*time.Second, "How often the data written to open files is uploaded with -durability=interval")
+	flags.StringVar(&blockCacheDir, "blockCacheDir", "", "Local directory caching the blocks of the files read from HDFS. Disabled if empty")
+	flags.Int64Var(&blockCacheSize, "blockCacheSize", 10*1024*1024*1024, "Maximum size of -blockCacheDir")
+	flags.Int64Var(&blockCacheBlockSize, "blockCacheBlockSize", 1024*1024, "Size of the blocks in -blockCacheDir")
+	flags.Int64Var(&blockCacheMemory, "blockCacheMemory", 64*1024*1024, "Memory keeping the most recently used blocks of -blockCacheDir, in bytes")
+	flags.Int64Var(&readCacheMB, "readCacheMB", 0, "Memory caching the blocks of the files read from HDFS without -blockCacheDir, in MiB, shared by all the files. Disabled if 0")
+	flags.IntVar(&readaheadBlocks, "readaheadBlocks", 4, "Maximum blocks of -blockCacheDir or -readCacheMB read ahead of sequential reads")
+	flags.Int64Var(&readaheadBytes, "readaheadBytes", 0, "Bytes read in the background ahead of sequential reads without -blockCacheDir or -readCacheMB. Disabled if 0")
+	flags.Int64Var(&readaheadMaxBytes, "readaheadMaxBytes", 1<<30, "Bytes read ahead by -readaheadBytes for all the files of the mount at once. Unlimited if 0")
+	flags.IntVar(&readaheadStreams, "readaheadStreams", 1, "HDFS readers of a file reading the chunks of -readaheadBytes concurrently")
+	flags.StringVar(&webhdfsURL, "webhdfsURL", "", "URL of an HttpFS or WebHDFS server the blocks are read from when their datanodes are unreachable, and the ACLs and the storage and erasure coding policies are managed with")
+	flags.UintVar(&maxReadahead, "maxReadahead", 64*1024, "Bytes the kernel reads ahead of sequential reads")
+	flags.BoolVar(&writebackCache, "writebackCache", true, "Lets the kernel buffer the writes in the page cache and send them to the mount in large requests")
+	flags.UintVar(&maxBackground, "maxBackground", 0, "Background requests, e.g., writebacks and readaheads, the kernel sends to the mount at once. The kernel default, 12, if 0")
+	flags.BoolVar(&keepPageCache, "keepPageCache", false, "Keeps the pages of a file cached by the kernel across opens while the file does not change in HopsFS, e.g., for shared libraries and memory mapped models")
+	flags.DurationVar(&openCoalesceWindow, "openCoalesceWindow", 0, "Keeps the HDFS reader of a closed read-only file this long for the next open of the file. Disabled if 0")
+	flags.Int64Var(&footerCacheSize, "footerCacheSize", 64*1024, "Bytes at the end of large files kept in memory for columnar readers. Disabled if 0")
+	flags.Int64Var(&footerCacheMinFileSize, "footerCacheMinFileSize", 1024*1024, "Minimum size of the files whose end is kept in memory")
+	flags.IntVar(&maxTransfers, "maxTransfers", 0, "Maximum concurrent reads and uploads of file data, interactive reads go first once reached. Unlimited if 0")
+	flags.StringVar(&batchUids, "batchUids", "", "Comma separated uids whose reads are batch reads for -maxTransfers")
+	flags.StringVar(&prefetchPaths, "prefetchPaths", "", "Comma separated HDFS directories listed into the cache after mounting")
+	flags.IntVar(&prefetchDepth, "prefetchDepth", 1, "Levels of subdirectories of -prefetchPaths which are listed too")
+	flags.IntVar(&asyncUploads, "asyncUploads", 0, "Uploads the flushes in the background, at most this many at once, close returns before the data is in HDFS. Disabled if 0")
+	flags.DurationVar(&flushCoalesceWindow, "flushCoalesceWindow", 0, "Defers the upload of a flush for this long, so that the flushes of a file within the window are one upload. Disabled if 0")
+	flags.DurationVar(&attrCacheMaxTTL, "attrCacheMaxTTL", 0, "Keeps the attributes of the entries of directories which do not change for up to this long in the mount, doubling the TTL from -attrCacheTTL each time they are found unchanged. Disabled if 0")
+	flags.DurationVar(&attrCacheTTL, "attrCacheTTL", 5*time.Second, "Keeps the attributes of files and directories for this long, in the mount and in the kernel. Nothing is cached if 0")
+	flags.DurationVar(&listingCacheTTL, "listingCacheTTL", 0, "Serves the listing of a directory from memory for this long. Disabled if 0")
+	flags.DurationVar(&negativeLookupTTL, "negativeLookupTTL", 0, "Reports a name which was not found as missing for this long without a stat. Disabled if 0")
+	flags.StringVar(&symlinkSuffix, "symlinkSuffix", "", "Emulates the symlinks created through the mount by files named after the link and this suffix holding the target, e.g., .symlink. ln -s fails if empty")
+	flags.StringVar(&birthTimesFile, "birthTimesFile", "", "Local file keeping the birth time of the files, the modification time they were first seen with, across remounts")
+	flags.BoolVar(&mimeTypeXattr, "mimeTypeXattr", false, "Exposes the type of the content of files, sniffed from their first bytes, as the user.hopsfs.mime_type extended attribute")
+	flags.IntVar(&maxComponentLength, "maxComponentLength", 255, "Maximum length in bytes of a file name, dfs.namenode.fs-limits.max-component-length of the namenode. Unlimited if 0")
+	flags.IntVar(&maxPathLength, "maxPathLength", hdfsMaxPathLength, "Maximum length in characters of an HDFS path. Unlimited if 0")
+	flags.Int64Var(&maxFileSize, "maxFileSize", 0, "Writes and truncates growing a file past this size fail with EFBIG before the data is staged. Unlimited if 0")
+	flags.BoolVar(&shortenLongNames, "shortenLongNames", false, "Replaces file names longer than -maxComponentLength by a prefix and a hash of the name instead of failing with ENAMETOOLONG")
+	flags.StringVar(&unicodeNormalization, "unicodeNormalization", NormalizationNone, "Unicode form of the names created through the mount: none, nfc or nfd. With nfc or nfd, entries are also found by the other forms of their names")
+	flags.StringVar(&profile, "profile", "", "Sets the options tuned for a workload, the options given otherwise take precedence: "+strings.Join(profileNames(), ", "))
+	flags.StringVar(&configFile, "config", "", "TOML or YAML file setting options by name. Options given on the command line or as HOPSFS_MOUNT_<OPTION> environment variables take precedence")
+	flags.BoolVar(&fastRecursiveDelete, "fastRecursiveDelete", false, "Allows the 'rmr' admin command to delete a directory tree using a single recursive delete RPC")
+	flags.BoolVar(&snapshotDirs, "snapshotDirs", false, "Serves the snapshots of the snapshottable directories read-only under their .snapshot directory")
+	flags.BoolVar(&useTrash, "useTrash", false, "Moves the files and directories removed through the mount to the HDFS trash of the user, as hdfs dfs -rm does")
+	flags.StringVar(&trashSkipPaths, "trashSkipPaths", "", "Comma separated globs of HDFS paths deleted for good with -useTrash, e.g., /tmp/**")
+	flags.BoolVar(&controlDir, "controlDir", false, "Exposes the .hopsfs directory at the mount root, whose files read the stats and status of the mount and invalidate its caches")
+	flags.BoolVar(&impersonate, "impersonate", false, "Issues the creates, removes, renames, attribute changes and uploads of each local user as the HDFS user of the same name, on a connection per user. Needs simple authentication on the namenode")
 }
 
 // check that we can create / open the log file
@@ -208,9 +747,23 @@ func getMountOptions(ro bool) []fuse.MountOption {
 		fuse.Subtype("hopsfs"),
 		fuse.VolumeName("HopsFS filesystem"),
 		fuse.AllowOther(),
-		fuse.WritebackCache(),
-		fuse.MaxReadahead(1024 * 64), //TODO: make configurable
-		fuse.DefaultPermissions(),
+		fuse.MaxReadahead(uint32(maxReadahead)),
+	}
+
+	// the kernel coalesces the small writes of the applications in its page cache, and sends
+	// them in requests of up to MaxWrite. The FUSE library has no mount option for MaxWrite, it
+	// accepts big writes and replies with a MaxWrite of 128 KiB, its receive buffer, in INIT, so
+	// without big writes the kernel would send pages of 4 KiB
+	if writebackCache {
+		mountOptions = append(mountOptions, fuse.WritebackCache())
+	}
+	if maxBackground > 0 {
+		// the kernel considers the mount congested at 3/4 of the background requests, as by default
+		mountOptions = append(mountOptions, fuse.MaxBackground(uint16(maxBackground)), fuse.CongestionThreshold(uint16(maxBackground*3/4)))
+	}
+
+	if permissionChecks == PermissionChecksKernel {
+		mountOptions = append(mountOptions, fuse.DefaultPermissions())
 	}
 
 	if ro {
@@ -220,8 +773,10 @@ func getMountOptions(ro bool) []fuse.MountOption {
 }
 
 func createStagingDir() {
-	if err := os.MkdirAll(stagingDir, 0700); err != nil {
-		logerror(fmt.Sprintf("Failed to create stageDir: %s. Error: %v", stagingDir, err), Fields{})
+	for _, dir := range stagingDirs() {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			logerror(fmt.Sprintf("Failed to create stageDir: %s. Error: %v", dir, err), Fields{})
+		}
 	}
 }
 